@@ -8,12 +8,23 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	_ "embed" // Embed external data.
+	"encoding/json"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alpine-hodler/gidari"
+	"github.com/alpine-hodler/gidari/internal/openapi"
+	"github.com/alpine-hodler/gidari/internal/secret"
+	"github.com/alpine-hodler/gidari/internal/wizard"
 	"github.com/alpine-hodler/gidari/version"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -22,6 +33,9 @@ import (
 //go:embed bash-completion.sh
 var bashCompletion string
 
+// configWatchInterval is the interval at which a watched configuration file is checked for changes.
+const configWatchInterval = 2 * time.Second
+
 func main() {
 	// configFilepath is the path to the configuration file.
 	var configFilepath string
@@ -29,6 +43,24 @@ func main() {
 	// verbose is a flag that enables verbose logging.
 	var verbose bool
 
+	// retryFailed is a flag that re-executes only the chunks that failed during the previous run.
+	var retryFailed bool
+
+	// replayRun is the ID of an archived run whose responses are replayed instead of making web requests.
+	var replayRun string
+
+	// showProgress is a flag that reports the progress of each table while the transport operation runs.
+	var showProgress bool
+
+	// assumeYes is a flag that confirms truncating tables without prompting.
+	var assumeYes bool
+
+	// dryRun is a flag that prints an estimate of the web requests instead of running the transport operation.
+	var dryRun bool
+
+	// watch is a flag that reloads the configuration when the file changes while the live tail runs.
+	var watch bool
+
 	cmd := &cobra.Command{
 		Long: "Gidari is a tool for querying web APIs and persisting resultant data onto local storage\n" +
 			"using a configuration file.",
@@ -40,22 +72,49 @@ func main() {
 		Deprecated:             "",
 		Version:                version.Gidari,
 
-		Run: func(_ *cobra.Command, args []string) { run(configFilepath, verbose, args) },
+		Run: func(_ *cobra.Command, args []string) {
+			run(configFilepath, replayRun, verbose, retryFailed, dryRun, showProgress, assumeYes, watch, args)
+		},
 	}
 
 	cmd.Flags().StringVar(&configFilepath, "config", "c", "path to configuration")
 	cmd.Flags().BoolVar(&verbose, "verbose", false, "print log data as the binary executes")
+	cmd.Flags().BoolVar(&retryFailed, "retry-failed", false,
+		"only re-execute the chunks recorded in the failedChunksFile by the previous run")
+	cmd.Flags().StringVar(&replayRun, "replay", "",
+		"replay the archived responses of a run ID instead of making web requests")
+	cmd.Flags().BoolVar(&showProgress, "progress", false,
+		"report the progress of each table, overriding the progress setting of the configuration")
+	cmd.Flags().BoolVar(&assumeYes, "yes", false,
+		"confirm truncating tables without prompting when the truncate policy requires confirmation")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false,
+		"print the estimated number of web requests and run time without making any requests")
+	cmd.Flags().BoolVar(&watch, "watch", false,
+		"reload the configuration when the file changes while the live tail runs, in addition to on SIGHUP")
 
 	if err := cmd.MarkFlagRequired("config"); err != nil {
 		logrus.Fatalf("error marking flag as required: %v", err)
 	}
 
+	cmd.AddCommand(newInitCommand())
+	cmd.AddCommand(newDiscoverCommand())
+	cmd.AddCommand(newDDLCommand())
+	cmd.AddCommand(newPreviewCommand())
+	cmd.AddCommand(newLintCommand())
+	cmd.AddCommand(newGapsCommand())
+	cmd.AddCommand(newDiffCommand())
+	cmd.AddCommand(newSnapshotCommand())
+	cmd.AddCommand(newCopyCommand())
+	cmd.AddCommand(newUpgradeCommand())
+	cmd.AddCommand(newCredentialsCommand())
+
 	if err := cmd.Execute(); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(configFilepath string, verboseLogging bool, _ []string) {
+// loadConfig will read the configuration file, exiting if it cannot be read.
+func loadConfig(configFilepath string, verboseLogging bool) *gidari.Config {
 	file, err := os.Open(configFilepath)
 	if err != nil {
 		log.Fatalf("error opening config file  %s: %v", configFilepath, err)
@@ -71,8 +130,682 @@ func run(configFilepath string, verboseLogging bool, _ []string) {
 		cfg.Logger.SetLevel(logrus.InfoLevel)
 	}
 
-	err = gidari.Transport(context.Background(), cfg)
-	if err != nil {
+	return cfg
+}
+
+func run(configFilepath, replayRun string, verboseLogging, retryFailed, dryRun, showProgress, assumeYes, watch bool,
+	_ []string,
+) {
+	cfg := loadConfig(configFilepath, verboseLogging)
+	cfg.ConfirmTruncate = confirmTruncate(assumeYes)
+
+	if showProgress && cfg.Progress == nil {
+		cfg.Progress = new(gidari.ProgressConfig)
+	}
+
+	if dryRun {
+		if err := gidari.WriteEstimate(context.Background(), cfg, os.Stdout); err != nil {
+			log.Fatalf("failed to estimate requests: %v", err)
+		}
+
+		return
+	}
+
+	if retryFailed {
+		if err := gidari.RetryFailedChunks(context.Background(), cfg); err != nil {
+			log.Fatalf("failed to retry failed chunks: %v", err)
+		}
+
+		return
+	}
+
+	if replayRun != "" {
+		if err := gidari.ReplayArchive(context.Background(), cfg, replayRun); err != nil {
+			log.Fatalf("failed to replay archive: %v", err)
+		}
+
+		return
+	}
+
+	cfg.ReloadFile(configFilepath)
+	reloadOnSignal(cfg)
+
+	if watch {
+		go watchConfig(configFilepath, cfg)
+	}
+
+	if err := gidari.Transport(context.Background(), cfg); err != nil {
 		log.Fatalf("failed to transport data: %v", err)
 	}
 }
+
+// reloadOnSignal will request a reload of the configuration on SIGHUP.
+func reloadOnSignal(cfg *gidari.Config) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			cfg.RequestReload()
+		}
+	}()
+}
+
+// watchConfig will request a reload of the configuration whenever the modification time of its file changes.
+func watchConfig(configFilepath string, cfg *gidari.Config) {
+	var modTime time.Time
+
+	if info, err := os.Stat(configFilepath); err == nil {
+		modTime = info.ModTime()
+	}
+
+	for range time.Tick(configWatchInterval) {
+		info, err := os.Stat(configFilepath)
+		if err != nil || info.ModTime().Equal(modTime) {
+			continue
+		}
+
+		modTime = info.ModTime()
+		cfg.RequestReload()
+	}
+}
+
+// confirmTruncate will return a function that asks the user on stdin to confirm truncating tables, or that confirms
+// every truncate if assumeYes is true.
+func confirmTruncate(assumeYes bool) func(string, []string) bool {
+	return func(storage string, tables []string) bool {
+		if assumeYes {
+			return true
+		}
+
+		fmt.Fprintf(os.Stderr, "Truncate %d tables on %s: %s? [y/N] ", len(tables), storage, strings.Join(tables, ", "))
+
+		answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return false
+		}
+
+		answer = strings.ToLower(strings.TrimSpace(answer))
+
+		return answer == "y" || answer == "yes"
+	}
+}
+
+// newInitCommand will return a command that writes a starter configuration by asking about the web API interactively.
+func newInitCommand() *cobra.Command {
+	// outputFilepath is the path that the configuration is written to.
+	var outputFilepath string
+
+	// force is a flag that overwrites an existing configuration file.
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Write a starter configuration by answering questions about the web API",
+		Long: "Init asks for the base URL of the web API, its authentication, and a sample endpoint, which it\n" +
+			"fetches to propose where the response records are, the table to write them to, and the rate limit.\n" +
+			"The answers are written as a starter configuration, which is not overwritten without --force.",
+		Example: "gidari init --output config.yml",
+
+		Run: func(_ *cobra.Command, _ []string) { initConfig(outputFilepath, force) },
+	}
+
+	cmd.Flags().StringVar(&outputFilepath, "output", "gidari.yml", "path to write the configuration to")
+	cmd.Flags().BoolVar(&force, "force", false, "overwrite the configuration file if it exists")
+
+	return cmd
+}
+
+func initConfig(outputFilepath string, force bool) {
+	if _, err := os.Stat(outputFilepath); err == nil && !force {
+		log.Fatalf("%s already exists, use --force to overwrite it", outputFilepath)
+	}
+
+	answers, err := wizard.Run(context.Background(), os.Stdin, os.Stderr, nil)
+	if err != nil {
+		log.Fatalf("error running wizard: %v", err)
+	}
+
+	var config strings.Builder
+	if err := answers.WriteConfig(&config); err != nil {
+		log.Fatalf("error writing configuration: %v", err)
+	}
+
+	// The configuration may hold the credential, so it is only readable by the user.
+	if err := os.WriteFile(outputFilepath, []byte(config.String()), 0o600); err != nil {
+		log.Fatalf("error writing config file %s: %v", outputFilepath, err)
+	}
+
+	fmt.Printf("wrote %s; review it, then check it with \"gidari lint --config %s\"\n", outputFilepath, outputFilepath)
+}
+
+// newDiscoverCommand will return a command that generates a skeleton configuration from an OpenAPI specification.
+func newDiscoverCommand() *cobra.Command {
+	// specFilepath is the path to the OpenAPI specification.
+	var specFilepath string
+
+	cmd := &cobra.Command{
+		Use:   "discover",
+		Short: "Generate a skeleton configuration from an OpenAPI/Swagger specification",
+		Long: "Discover reads an OpenAPI 3 or Swagger 2 specification (JSON or YAML) and prints a skeleton\n" +
+			"configuration with a request for every GET operation to stdout.",
+		Example: "gidari discover --spec openapi.json > config.yml",
+
+		Run: func(_ *cobra.Command, _ []string) { discover(specFilepath) },
+	}
+
+	cmd.Flags().StringVar(&specFilepath, "spec", "", "path to the OpenAPI/Swagger specification")
+
+	if err := cmd.MarkFlagRequired("spec"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+func discover(specFilepath string) {
+	spec, err := os.ReadFile(specFilepath)
+	if err != nil {
+		log.Fatalf("error reading specification %s: %v", specFilepath, err)
+	}
+
+	skeleton, err := openapi.Discover(spec)
+	if err != nil {
+		log.Fatalf("error discovering specification: %v", err)
+	}
+
+	if err := skeleton.WriteConfig(os.Stdout); err != nil {
+		log.Fatalf("error writing configuration: %v", err)
+	}
+}
+
+// newDDLCommand will return a command that generates SQL DDL from a sample of the data fetched for a configuration.
+func newDDLCommand() *cobra.Command {
+	var configFilepath string
+
+	// dialect is the SQL dialect of the generated DDL.
+	var dialect string
+
+	cmd := &cobra.Command{
+		Use:   "ddl",
+		Short: "Generate CREATE TABLE statements from a sample of each configured request",
+		Long: "DDL fetches the first chunk of each request in the configuration, infers the schema of the\n" +
+			"response records, and prints the CREATE TABLE statements to stdout. Only primary key columns are\n" +
+			"NOT NULL, and warnings about the statements, e.g. tables without a primary key, are printed to\n" +
+			"stderr. Nothing is written to storage.",
+		Example: "gidari ddl --config config.yml --dialect postgres",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			cfg := loadConfig(configFilepath, false)
+
+			warnings, err := gidari.WriteDDL(context.Background(), cfg, dialect, os.Stdout)
+			if err != nil {
+				log.Fatalf("failed to generate ddl: %v", err)
+			}
+
+			// Warnings are written to stderr, so that stdout can be applied as it is.
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&configFilepath, "config", "", "path to configuration")
+	cmd.Flags().StringVar(&dialect, "dialect", "postgres", "SQL dialect of the DDL: postgres or mysql")
+
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+// newLintCommand will return a command that warns about the risky settings of a configuration.
+func newLintCommand() *cobra.Command {
+	var configFilepath string
+
+	// strict is a flag that exits with an error if there are any warnings, e.g. in CI.
+	var strict bool
+
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Warn about the risky settings of a configuration",
+		Long: "Lint validates the configuration and prints a warning for each setting that is likely to cause\n" +
+			"problems: rate limits that are effectively missing, tables that are truncated before they are loaded,\n" +
+			"unbounded timeseries ranges, web requests without a timeout, and tables written by several requests.\n" +
+			"Nothing is fetched.",
+		Example: "gidari lint --config config.yml --strict",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			warnings := gidari.Lint(loadConfig(configFilepath, false))
+			for _, warning := range warnings {
+				fmt.Println(warning.String())
+			}
+
+			if strict && len(warnings) > 0 {
+				log.Fatalf("%d lint warnings", len(warnings))
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&configFilepath, "config", "", "path to configuration")
+	cmd.Flags().BoolVar(&strict, "strict", false, "exit with an error if there are any warnings")
+
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+// newUpgradeCommand will return a command that upgrades a configuration file to the latest version of the format.
+func newUpgradeCommand() *cobra.Command {
+	var configFilepath string
+
+	// write is a flag that rewrites the configuration file instead of printing the upgraded configuration.
+	var write bool
+
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade a configuration file to the latest version of the configuration format",
+		Long: "Upgrade migrates a configuration of an older version of the configuration format to the latest\n" +
+			"version and prints it to stdout, or rewrites the file with --write. Comments are not kept.",
+		Example: "gidari upgrade --config config.yml --write",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			bytes, err := os.ReadFile(configFilepath)
+			if err != nil {
+				log.Fatalf("error reading config file %s: %v", configFilepath, err)
+			}
+
+			upgraded, version, err := gidari.UpgradeConfig(bytes)
+			if err != nil {
+				log.Fatalf("failed to upgrade config: %v", err)
+			}
+
+			if !write {
+				fmt.Print(string(upgraded))
+
+				return
+			}
+
+			if version == gidari.ConfigVersion {
+				fmt.Printf("%s is already version %d\n", configFilepath, version)
+
+				return
+			}
+
+			info, err := os.Stat(configFilepath)
+			if err != nil {
+				log.Fatalf("error reading config file %s: %v", configFilepath, err)
+			}
+
+			if err := os.WriteFile(configFilepath, upgraded, info.Mode()); err != nil {
+				log.Fatalf("error writing config file %s: %v", configFilepath, err)
+			}
+
+			fmt.Printf("upgraded %s from version %d to %d\n", configFilepath, version, gidari.ConfigVersion)
+		},
+	}
+
+	cmd.Flags().StringVar(&configFilepath, "config", "", "path to configuration")
+	cmd.Flags().BoolVar(&write, "write", false, "rewrite the configuration file instead of printing it")
+
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+// newPreviewCommand will return a command that previews a sample of the data fetched for a configuration.
+func newPreviewCommand() *cobra.Command {
+	var configFilepath string
+
+	cmd := &cobra.Command{
+		Use:   "preview",
+		Short: "Preview a sample of the data fetched by each configured request",
+		Long: "Preview fetches the first chunk of each request in the configuration and prints the field names,\n" +
+			"inferred types, and sample values of the response records, along with an estimate of the total\n" +
+			"number of records. Nothing is written to storage.",
+		Example: "gidari preview --config config.yml",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			cfg := loadConfig(configFilepath, false)
+			if err := gidari.WritePreview(context.Background(), cfg, os.Stdout); err != nil {
+				log.Fatalf("failed to preview data: %v", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&configFilepath, "config", "", "path to configuration")
+
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+// newGapsCommand will return a command that detects, and optionally fills, the gaps of the timeseries tables of a
+// configuration.
+func newGapsCommand() *cobra.Command {
+	var configFilepath string
+
+	// fill will fetch the data of the gaps and upsert it.
+	var fill bool
+
+	cmd := &cobra.Command{
+		Use:   "gaps",
+		Short: "Detect the gaps of the timeseries tables of each configured request",
+		Long: "Gaps scans the timeseries tables of the requests with a \"gaps\" granularity for intervals of their\n" +
+			"ranges without records, and prints them to stdout. With --fill, the data of the gaps is fetched\n" +
+			"again and upserted.",
+		Example: "gidari gaps --config config.yml --fill",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			ctx := context.Background()
+			cfg := loadConfig(configFilepath, false)
+
+			gaps, err := gidari.DetectGaps(ctx, cfg)
+			if err != nil {
+				log.Fatalf("failed to detect gaps: %v", err)
+			}
+
+			if err := gidari.WriteGaps(os.Stdout, gaps); err != nil {
+				log.Fatalf("failed to write gaps: %v", err)
+			}
+
+			if fill {
+				if err := gidari.FillGaps(ctx, cfg, gaps); err != nil {
+					log.Fatalf("failed to fill gaps: %v", err)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&configFilepath, "config", "", "path to configuration")
+	cmd.Flags().BoolVar(&fill, "fill", false, "fetch the data of the gaps and upsert it")
+
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+// newDiffCommand will return a command that reports the differences between the data fetched for a configuration and
+// the data in storage.
+func newDiffCommand() *cobra.Command {
+	var configFilepath string
+
+	// asJSON will write the report as JSON.
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Report the records that changed upstream since they were stored",
+		Long: "Diff fetches the data of each request in the configuration and compares the records with the\n" +
+			"records stored on each storage device by their primary keys, then prints the new, changed, and\n" +
+			"deleted keys of each table to stdout. Nothing is written to storage.",
+		Example: "gidari diff --config config.yml",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			cfg := loadConfig(configFilepath, false)
+
+			diffs, err := gidari.DiffTables(context.Background(), cfg)
+			if err != nil {
+				log.Fatalf("failed to diff tables: %v", err)
+			}
+
+			if asJSON {
+				if err := json.NewEncoder(os.Stdout).Encode(diffs); err != nil {
+					log.Fatalf("failed to write diff: %v", err)
+				}
+
+				return
+			}
+
+			if err := gidari.WriteDiff(os.Stdout, diffs); err != nil {
+				log.Fatalf("failed to write diff: %v", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&configFilepath, "config", "", "path to configuration")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "write the report as JSON")
+
+	if err := cmd.MarkFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	return cmd
+}
+
+// newSnapshotCommand will return a command that exports the data in storage to a snapshot directory and imports it
+// back.
+func newSnapshotCommand() *cobra.Command {
+	var configFilepath string
+
+	// tables are the tables to export.
+	var tables []string
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Export the data in storage to a snapshot directory, or import a snapshot",
+		Long: "Snapshot exports the tables of each storage device in the configuration to a directory of NDJSON\n" +
+			"files with a manifest of their record counts, primary keys, and checksums, and imports them back\n" +
+			"into the storage devices of a configuration.",
+	}
+
+	cmd.PersistentFlags().StringVar(&configFilepath, "config", "", "path to configuration")
+
+	if err := cmd.MarkPersistentFlagRequired("config"); err != nil {
+		logrus.Fatalf("error marking flag as required: %v", err)
+	}
+
+	exportCmd := &cobra.Command{
+		Use:     "export <directory>",
+		Short:   "Export the tables of each storage device to a snapshot directory",
+		Example: "gidari snapshot export --config config.yml --table accounts ./snapshot",
+		Args:    cobra.ExactArgs(1),
+
+		Run: func(_ *cobra.Command, args []string) {
+			cfg := loadConfig(configFilepath, false)
+
+			if _, err := gidari.ExportSnapshot(context.Background(), cfg, args[0], tables); err != nil {
+				log.Fatalf("failed to export snapshot: %v", err)
+			}
+		},
+	}
+
+	exportCmd.Flags().StringSliceVar(&tables, "table", nil, "table to export, defaults to every table")
+
+	cmd.AddCommand(exportCmd)
+	cmd.AddCommand(&cobra.Command{
+		Use:     "import <directory>",
+		Short:   "Upsert the tables of a snapshot directory into each storage device",
+		Example: "gidari snapshot import --config config.yml ./snapshot",
+		Args:    cobra.ExactArgs(1),
+
+		Run: func(_ *cobra.Command, args []string) {
+			cfg := loadConfig(configFilepath, false)
+
+			if err := gidari.ImportSnapshot(context.Background(), cfg, args[0]); err != nil {
+				log.Fatalf("failed to import snapshot: %v", err)
+			}
+		},
+	})
+
+	return cmd
+}
+
+// newCopyCommand will return a command that copies tables from one storage device to others.
+func newCopyCommand() *cobra.Command {
+	// from is the connection string of the storage device to copy from.
+	var from string
+
+	// to are the connection strings of the storage devices to copy to.
+	var to []string
+
+	// tables are the tables to copy.
+	var tables []string
+
+	// verboseLogging will log the progress of the copy to stdout.
+	var verboseLogging bool
+
+	// showProgress will report the progress of each table.
+	var showProgress bool
+
+	cmd := &cobra.Command{
+		Use:   "copy",
+		Short: "Copy tables from one storage device to others",
+		Long: "Copy reads the tables of the storage device of --from and upserts their records in batches into the\n" +
+			"storage devices of --to, e.g. from PostgreSQL to MongoDB. Every table of the source is copied unless\n" +
+			"--tables is set.",
+		Example: "gidari copy --from postgresql://... --to mongodb://... --tables accounts,orders",
+
+		Run: func(_ *cobra.Command, _ []string) {
+			cfg := new(gidari.Config)
+			cfg.ConnectionStrings = to
+			cfg.Logger = logrus.New()
+			cfg.Logger.SetOutput(io.Discard)
+
+			if verboseLogging {
+				cfg.Logger.SetOutput(os.Stdout)
+			}
+
+			if showProgress {
+				cfg.Progress = new(gidari.ProgressConfig)
+			}
+
+			if err := gidari.CopyTables(context.Background(), cfg, from, tables); err != nil {
+				log.Fatalf("failed to copy tables: %v", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "connection string of the storage device to copy from")
+	cmd.Flags().StringSliceVar(&to, "to", nil, "connection string of a storage device to copy to")
+	cmd.Flags().StringSliceVar(&tables, "tables", nil, "tables to copy, defaults to every table")
+	cmd.Flags().BoolVar(&verboseLogging, "verbose", false, "print log data as the binary executes")
+	cmd.Flags().BoolVar(&showProgress, "progress", false, "report the progress of each table")
+
+	for _, flag := range []string{"from", "to"} {
+		if err := cmd.MarkFlagRequired(flag); err != nil {
+			logrus.Fatalf("error marking flag as required: %v", err)
+		}
+	}
+
+	return cmd
+}
+
+// newCredentialsCommand will return a command that manages the credentials of the local credential store and the
+// keyring, which configurations reference as "secret://store/<name>" and "secret://keyring/<name>".
+func newCredentialsCommand() *cobra.Command {
+	// keyring is a flag that manages the credentials of the operating system's keyring instead of the store.
+	var keyring bool
+
+	cmd := &cobra.Command{
+		Use:   "credentials",
+		Short: "Manage the credentials of the local encrypted credential store or the keyring",
+		Long: "Credentials manages named credentials that configurations reference instead of holding them, as\n" +
+			"\"secret://store/<name>\" for the encrypted credential store, or \"secret://keyring/<name>\" for the\n" +
+			"keyring of the operating system. The store is encrypted with the GIDARI_CREDENTIALS_PASSPHRASE\n" +
+			"environment variable, and kept at GIDARI_CREDENTIALS_FILE or the user's configuration directory.",
+	}
+
+	cmd.PersistentFlags().BoolVar(&keyring, "keyring", false,
+		"manage the credentials of the operating system's keyring instead of the encrypted store")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:     "set <name>",
+		Short:   "Set a credential to the secret read from stdin",
+		Example: "printf '%s' \"$API_SECRET\" | gidari credentials set coinbase",
+		Args:    cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			credential, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				log.Fatalf("error reading credential from stdin: %v", err)
+			}
+
+			setCredential(keyring, args[0], strings.TrimRight(string(credential), "\r\n"))
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "delete <name>",
+		Short: "Delete a credential",
+		Args:  cobra.ExactArgs(1),
+		Run:   func(_ *cobra.Command, args []string) { deleteCredential(keyring, args[0]) },
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list",
+		Short: "List the names of the credentials of the encrypted store",
+		Args:  cobra.NoArgs,
+		Run: func(_ *cobra.Command, _ []string) {
+			for _, name := range openCredentialStore().Names() {
+				fmt.Println(name)
+			}
+		},
+	})
+
+	return cmd
+}
+
+// openCredentialStore will open the local credential store, exiting if it cannot be opened.
+func openCredentialStore() *secret.Store {
+	passphrase := os.Getenv("GIDARI_CREDENTIALS_PASSPHRASE")
+	if passphrase == "" {
+		log.Fatal("GIDARI_CREDENTIALS_PASSPHRASE must be set to open the credential store")
+	}
+
+	path, err := secret.DefaultStorePath()
+	if err != nil {
+		log.Fatalf("error finding credential store: %v", err)
+	}
+
+	store, err := secret.OpenStore(path, passphrase)
+	if err != nil {
+		log.Fatalf("error opening credential store %s: %v", path, err)
+	}
+
+	return store
+}
+
+func setCredential(keyring bool, name, credential string) {
+	if keyring {
+		if err := secret.NewKeyring().Set(context.Background(), name, credential); err != nil {
+			log.Fatalf("error setting keyring credential: %v", err)
+		}
+
+		return
+	}
+
+	store := openCredentialStore()
+	store.Set(name, credential)
+
+	if err := store.Save(); err != nil {
+		log.Fatalf("error saving credential store: %v", err)
+	}
+}
+
+func deleteCredential(keyring bool, name string) {
+	if keyring {
+		if err := secret.NewKeyring().Delete(context.Background(), name); err != nil {
+			log.Fatalf("error deleting keyring credential: %v", err)
+		}
+
+		return
+	}
+
+	store := openCredentialStore()
+	if err := store.Delete(name); err != nil {
+		log.Fatalf("error deleting credential: %v", err)
+	}
+
+	if err := store.Save(); err != nil {
+		log.Fatalf("error saving credential store: %v", err)
+	}
+}
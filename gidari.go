@@ -6,14 +6,218 @@ import (
 	"io"
 	"os"
 
+	"github.com/alpine-hodler/gidari/internal/storage"
 	"github.com/alpine-hodler/gidari/internal/transport"
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/alpine-hodler/gidari/tools"
 )
 
+// The following errors classify the failures that can occur during a transport operation. Use "errors.Is" to check
+// an error returned by "Transport" or "TransportFile" against these values.
+var (
+	// ErrAnomaly is returned when a run's record counts or response sizes deviate from the history of previous runs
+	// and the anomaly action is "fail".
+	ErrAnomaly = transport.ErrAnomaly
+
+	// ErrAssertionFailed is returned when a data quality assertion does not hold for the loaded data.
+	ErrAssertionFailed = transport.ErrAssertionFailed
+
+	// ErrAuth is returned when the web API rejects the credentials used to make a request.
+	ErrAuth = web.ErrAuth
+
+	// ErrErrorBudgetExceeded is returned when the number of failed chunks exceeds the configured error budget.
+	ErrErrorBudgetExceeded = transport.ErrErrorBudgetExceeded
+
+	// ErrDecode is returned when the data fetched from the web API cannot be decoded into records.
+	ErrDecode = tools.ErrFailedToDecodeRecords
+
+	// ErrMissingConfigField is returned when a required configuration field is missing.
+	ErrMissingConfigField = transport.ErrMissingConfigField
+
+	// ErrProtectedTable is returned when the truncate policy selects a protected table.
+	ErrProtectedTable = transport.ErrProtectedTable
+
+	// ErrRateLimited is returned when the web API responds with a "Too Many Requests" status.
+	ErrRateLimited = web.ErrRateLimited
+
+	// ErrRecordsMissing is returned when decoded records are missing from storage and reconciliation fails the run.
+	ErrRecordsMissing = transport.ErrRecordsMissing
+
+	// ErrResponse is returned when the web API responds with an unsuccessful status code.
+	ErrResponse = web.ErrGettingResponse
+
+	// ErrStorageConflict is returned when a write fails due to a conflict in storage, such as a unique key
+	// violation or a write conflict between concurrent transactions.
+	ErrStorageConflict = storage.ErrConflict
+
+	// ErrTruncateNotConfirmed is returned when the truncate policy requires confirmation and it was not given.
+	ErrTruncateNotConfirmed = transport.ErrTruncateNotConfirmed
+)
+
+// ConfigVersion is the latest version of the configuration format. Configurations of older versions are upgraded
+// when they are loaded.
+const ConfigVersion = transport.ConfigVersion
+
+// AnomalyConfig detects runs whose record counts or response sizes deviate from the history of previous runs.
+type AnomalyConfig = transport.AnomalyConfig
+
+// Assertion is a data quality check of a table that must hold before the data of a transport operation is committed.
+type Assertion = transport.Assertion
+
+// ArchiveConfig archives the body of every web response, keyed by its content hash, with an index of the requests of
+// each run.
+type ArchiveConfig = transport.ArchiveConfig
+
+// ArchiveEntry is an entry of the index of a run's archived responses.
+type ArchiveEntry = transport.ArchiveEntry
+
+// ArchiveStore stores the objects of the raw response archive, e.g. in an object store.
+type ArchiveStore = transport.ArchiveStore
+
+// AuditConfig records every write operation of a transport operation in an append-only audit log.
+type AuditConfig = transport.AuditConfig
+
+// AuditEntry records a write operation of a transport operation on a table of a storage device.
+type AuditEntry = transport.AuditEntry
+
+// EmbedField embeds text fields of the records of a table, and stores the vector in a target field.
+type EmbedField = transport.EmbedField
+
+// Embedder embeds texts as vectors. Set a custom embedder, e.g. for a local model, on the configuration's "Embedder".
+type Embedder = transport.Embedder
+
+// EmbeddingConfig is the built-in embedder, which requests the vectors from an OpenAI-compatible embeddings API.
+type EmbeddingConfig = transport.EmbeddingConfig
+
+// Error is returned when a transport operation fails for a specific request, carrying the table and URL associated
+// with the failure. Use "errors.As" to extract it from an error returned by "Transport" or "TransportFile".
+type Error = transport.Error
+
+// Event announces the data that a transport operation committed to a table.
+type Event = transport.Event
+
+// FailedChunk is a chunk of a request that failed within the error budget and was skipped. Failed chunks are recorded
+// on the configuration's "FailedChunks" after a transport operation.
+type FailedChunk = transport.FailedChunk
+
+// GeoField maps a location in the records of a table to a native geospatial type.
+type GeoField = transport.GeoField
+
+// Gap is an interval of a timeseries table without records, detected from the table's expected granularity.
+type Gap = transport.Gap
+
+// GraphConfig maps the records of a table to the nodes and relationships of a graph, for graph storage.
+type GraphConfig = transport.GraphConfig
+
+// GraphRelationship is a relationship from the node of a record to the nodes whose keys are held by its field.
+type GraphRelationship = transport.GraphRelationship
+
+// GSS is a GSSAPI security context, e.g. of Kerberos, that authenticates web requests with SPNEGO and Postgres
+// connections.
+type GSS = transport.GSS
+
+// GSSFunc creates the GSSAPI security contexts of a transport operation. Set it on the configuration's "GSS".
+type GSSFunc = transport.GSSFunc
+
+// JSONDecoder decodes the JSON records of the upsert requests on the storage devices, e.g. the fast decoder.
+type JSONDecoder = transport.JSONDecoder
+
+// LintWarning is a setting of a valid configuration that is likely to cause problems, e.g. a missing timeout.
+type LintWarning = transport.LintWarning
+
+// NotifyConfig emits a notification for every table that received data once the data is committed.
+type NotifyConfig = transport.NotifyConfig
+
+// PlannerConfig plans the chunk periods and in-flight web requests of each run from the statistics of the previous
+// run.
+type PlannerConfig = transport.PlannerConfig
+
+// ProfileConfig captures CPU and heap profiles of every run, or of the slow runs, to a directory.
+type ProfileConfig = transport.ProfileConfig
+
+// ProgressConfig enables reporting the progress of each table during a transport operation.
+type ProgressConfig = transport.ProgressConfig
+
+// Publisher publishes the events of a transport operation once its data is committed. Set custom publishers, e.g. for
+// Kafka or SNS, on the configuration's "Publishers".
+type Publisher = transport.Publisher
+
+// ReconcileConfig reconciles the records decoded by a transport operation with the records present in storage.
+type ReconcileConfig = transport.ReconcileConfig
+
+// Reconciliation is the number of records decoded for a table, and the number of them present in a storage device.
+// Reconciliations are recorded on the configuration's "Reconciliations" after a transport operation.
+type Reconciliation = transport.Reconciliation
+
+// RecordTransformer transforms the JSON encoded records of a response into the records to store, e.g. with a
+// WebAssembly module instantiated by a "WASMRuntime".
+type RecordTransformer = transport.RecordTransformer
+
+// ResponseError is returned when the web API responds with an unsuccessful status code, carrying the status of the
+// response. Use "errors.As" to extract it from an error returned by "Transport" or "TransportFile".
+type ResponseError = web.ResponseError
+
+// SchemaConfig validates the records of each table against the columns and primary key of the table before they are
+// written.
+type SchemaConfig = transport.SchemaConfig
+
+// SecretProvider fetches secrets from a secrets manager, for the "secret://" references of a configuration. Set custom
+// providers on the configuration's "SecretProviders".
+type SecretProvider = transport.SecretProvider
+
+// SnapshotManifest is the manifest of a snapshot, which lists the file, record count, primary keys, and checksum of
+// each exported table.
+type SnapshotManifest = transport.SnapshotManifest
+
+// SnapshotTable is the entry of a table in the manifest of a snapshot.
+type SnapshotTable = transport.SnapshotTable
+
+// StampConfig stamps every record with the time it was ingested and the endpoint it was fetched from.
+type StampConfig = transport.StampConfig
+
+// SurrogateKeyConfig generates a key for each record of a table without a natural key, derived from the content of
+// the record, so that upserts are idempotent across runs.
+type SurrogateKeyConfig = transport.SurrogateKeyConfig
+
+// TableDiff is the difference between the records fetched for a table and the records stored in the table of a
+// storage device.
+type TableDiff = transport.TableDiff
+
+// Verification is the result of re-reading the sampled records of a table from a storage device once they are
+// committed. Verifications are recorded on the configuration's "Verifications" after a transport operation.
+type Verification = transport.Verification
+
+// VerifyConfig re-reads a random sample of the upserted records once they are committed and compares them with the
+// decoded records.
+type VerifyConfig = transport.VerifyConfig
+
+// WASMRuntime instantiates the WebAssembly modules of the requests' "wasm" transforms, e.g. with wazero. Set it on the
+// configuration's "WASMRuntime".
+type WASMRuntime = transport.WASMRuntime
+
 // Config is the configuration object used to make programatic Transport requests.
 type Config struct {
 	transport.Config
 }
 
+// RegisterType will register a Go struct type for a table. Records fetched for the table are decoded into the struct
+// using its "gidari" struct tags, e.g. `gidari:"price,required"`, and validated before they are stored. Only the
+// tagged fields are stored. If the struct implements "Validate() error", it is called for each record.
+func (cfg *Config) RegisterType(table string, val interface{}) error {
+	dec, err := tools.NewTypedRecordDecoder(val)
+	if err != nil {
+		return fmt.Errorf("unable to register type for %q: %w", table, err)
+	}
+
+	if cfg.RecordTypes == nil {
+		cfg.RecordTypes = make(map[string]*tools.TypedRecordDecoder)
+	}
+
+	cfg.RecordTypes[table] = dec
+
+	return nil
+}
+
 func NewConfig(ctx context.Context, file *os.File) (*Config, error) {
 	info, err := file.Stat()
 	if err != nil {
@@ -38,6 +242,24 @@ func NewConfig(ctx context.Context, file *os.File) (*Config, error) {
 	return &Config{*cfg}, nil
 }
 
+// ReloadFile will reload the configuration from a YAML file when a reload is requested with "RequestReload" while the
+// live tail runs, e.g. on SIGHUP or when the file changes.
+func (cfg *Config) ReloadFile(path string) {
+	cfg.Reloader = func() (*transport.Config, error) {
+		bytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read file: %w", err)
+		}
+
+		reloaded, err := transport.NewConfig(bytes)
+		if err != nil {
+			return nil, fmt.Errorf("unable to create new config: %w", err)
+		}
+
+		return reloaded, nil
+	}
+}
+
 // TransportFile will construct the transport operation using a configuration YAML file.
 func TransportFile(ctx context.Context, file *os.File) error {
 	cfg, err := NewConfig(ctx, file)
@@ -56,3 +278,158 @@ func Transport(ctx context.Context, cfg *Config) error {
 
 	return nil
 }
+
+// RetryFailedChunks will re-execute only the chunks that failed within the error budget during the previous transport
+// operation. The failed chunks are read from the configuration's "failedChunksFile".
+func RetryFailedChunks(ctx context.Context, cfg *Config) error {
+	if err := transport.RetryFailedChunks(ctx, &cfg.Config); err != nil {
+		return fmt.Errorf("unable to retry failed chunks: %w", err)
+	}
+
+	return nil
+}
+
+// ReplayArchive will run the responses that a previous transport operation archived through the decode, transform, and
+// upsert pipeline again, without making any web requests. The responses are read from the configuration's archive.
+func ReplayArchive(ctx context.Context, cfg *Config, runID string) error {
+	if err := transport.ReplayArchive(ctx, &cfg.Config, runID); err != nil {
+		return fmt.Errorf("unable to replay archive: %w", err)
+	}
+
+	return nil
+}
+
+// WriteEstimate will write a dry-run estimate of the transport operation to w: the number of web requests for each
+// request in the configuration, the expected wall-clock time given the rate limits, and the total number of web
+// requests counted against the API's quota. No web requests are made and nothing is written to storage.
+func WriteEstimate(ctx context.Context, cfg *Config, w io.Writer) error {
+	if err := transport.WriteEstimate(ctx, &cfg.Config, w); err != nil {
+		return fmt.Errorf("unable to write estimate: %w", err)
+	}
+
+	return nil
+}
+
+// DetectGaps will scan the timeseries tables of the requests with a "gaps" granularity for intervals of their ranges
+// without records. No web requests are made and nothing is written to storage.
+func DetectGaps(ctx context.Context, cfg *Config) ([]*Gap, error) {
+	gaps, err := transport.DetectGaps(ctx, &cfg.Config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect gaps: %w", err)
+	}
+
+	return gaps, nil
+}
+
+// FillGaps will fetch the data of the gaps from the web API and upsert it.
+func FillGaps(ctx context.Context, cfg *Config, gaps []*Gap) error {
+	if err := transport.FillGaps(ctx, &cfg.Config, gaps); err != nil {
+		return fmt.Errorf("unable to fill gaps: %w", err)
+	}
+
+	return nil
+}
+
+// DiffTables will fetch the data of every request in the configuration and compare the records with the records
+// stored on each storage device, reporting the new, changed, and deleted keys of each table. Nothing is written to
+// storage.
+func DiffTables(ctx context.Context, cfg *Config) ([]*TableDiff, error) {
+	diffs, err := transport.DiffTables(ctx, &cfg.Config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to diff tables: %w", err)
+	}
+
+	return diffs, nil
+}
+
+// WriteDiff will write a report of the differences of the tables to w.
+func WriteDiff(w io.Writer, diffs []*TableDiff) error {
+	if err := transport.WriteDiff(w, diffs); err != nil {
+		return fmt.Errorf("unable to write diff: %w", err)
+	}
+
+	return nil
+}
+
+// ExportSnapshot will write the tables of every storage device in the configuration to a snapshot directory, one NDJSON
+// file per table along with a manifest. If no tables are given, every table is exported.
+func ExportSnapshot(ctx context.Context, cfg *Config, dir string, tables []string) (*SnapshotManifest, error) {
+	manifest, err := transport.ExportSnapshot(ctx, &cfg.Config, dir, tables)
+	if err != nil {
+		return nil, fmt.Errorf("unable to export snapshot: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// CopyTables will copy tables from the storage device of a connection string to every storage device in the
+// configuration, through the upsert pipeline of a run. If no tables are given, every table of the source is copied.
+func CopyTables(ctx context.Context, cfg *Config, from string, tables []string) error {
+	if err := transport.CopyTables(ctx, &cfg.Config, from, tables); err != nil {
+		return fmt.Errorf("unable to copy tables: %w", err)
+	}
+
+	return nil
+}
+
+// ImportSnapshot will upsert the tables of a snapshot directory into every storage device in the configuration through
+// the upsert pipeline of a run, after checking its files against the checksums of its manifest. A snapshot exported
+// from one storage device can seed any other.
+func ImportSnapshot(ctx context.Context, cfg *Config, dir string) error {
+	if err := transport.ImportSnapshot(ctx, &cfg.Config, dir); err != nil {
+		return fmt.Errorf("unable to import snapshot: %w", err)
+	}
+
+	return nil
+}
+
+// WriteGaps will write a table of the gaps to w.
+func WriteGaps(w io.Writer, gaps []*Gap) error {
+	if err := transport.WriteGaps(w, gaps); err != nil {
+		return fmt.Errorf("unable to write gaps: %w", err)
+	}
+
+	return nil
+}
+
+// Lint will return the warnings of the settings of a configuration that are valid but likely to cause problems, e.g.
+// rate limits that are effectively missing, tables that are truncated before they are loaded, unbounded timeseries
+// ranges, web requests without a timeout, and tables written by several requests. Nothing is fetched.
+func Lint(cfg *Config) []*LintWarning {
+	return transport.Lint(&cfg.Config)
+}
+
+// WritePreview will fetch the first chunk of each request in the configuration and write a preview of the response
+// records to w: the field names, inferred types, sample values, and an estimate of the total number of records. Nothing
+// is written to storage.
+func WritePreview(ctx context.Context, cfg *Config, w io.Writer) error {
+	if err := transport.WritePreview(ctx, &cfg.Config, w); err != nil {
+		return fmt.Errorf("unable to write preview: %w", err)
+	}
+
+	return nil
+}
+
+// WriteDDL will fetch the first chunk of each request in the configuration and write the "CREATE TABLE" statements
+// inferred from the response records to w, returning the warnings about the statements to review, e.g. tables without
+// a primary key. The dialect is either "postgres" or "mysql". Nothing is written to storage.
+func WriteDDL(ctx context.Context, cfg *Config, dialect string, w io.Writer) ([]string, error) {
+	warnings, err := transport.WriteDDL(ctx, &cfg.Config, tools.SQLDialect(dialect), w)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write ddl: %w", err)
+	}
+
+	return warnings, nil
+}
+
+// UpgradeConfig will upgrade a YAML configuration to the latest version of the configuration format, returning the
+// upgraded configuration and the version that it was upgraded from. The order of the keys is kept, but comments are
+// not.
+func UpgradeConfig(yamlBytes []byte) ([]byte, int, error) {
+	upgraded, version, err := transport.UpgradeConfig(yamlBytes)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to upgrade config: %w", err)
+	}
+
+	return upgraded, version, nil
+}
@@ -0,0 +1,658 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+
+// Package expr is a small expression language for configurations, e.g. record filters and computed fields. Its syntax
+// is the subset of CEL shared with Go expressions:
+//
+//   - literals: numbers, strings, true, false, and null
+//   - variables and fields: record.volume, record["trade id"], params.symbol
+//   - arithmetic: +, -, *, /, %, and + to concatenate strings
+//   - comparisons: ==, !=, <, <=, >, >=
+//   - logic: &&, ||, !
+//   - functions: has(record.field), size(x), int(x), double(x), string(x), lower(s), upper(s), and the methods
+//     s.contains(sub), s.startsWith(prefix), s.endsWith(suffix), and s.matches(regexp)
+//
+// Numbers are doubles. Missing fields are null, and has(record.field) tests whether a field is set.
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrSyntax = fmt.Errorf("expression syntax error")
+	ErrEval   = fmt.Errorf("expression evaluation error")
+)
+
+// SyntaxError wraps an error with ErrSyntax.
+func SyntaxError(src, reason string) error {
+	return fmt.Errorf("%w in %q: %s", ErrSyntax, src, reason)
+}
+
+// EvalError wraps an error with ErrEval.
+func EvalError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrEval, reason)
+}
+
+// Program is a compiled expression. It is safe for concurrent use.
+type Program struct {
+	src  string
+	root ast.Expr
+}
+
+// Compile will parse an expression, checking that it only uses the syntax of the language.
+func Compile(src string) (*Program, error) {
+	root, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, SyntaxError(src, err.Error())
+	}
+
+	var syntaxErr error
+
+	ast.Inspect(root, func(node ast.Node) bool {
+		if syntaxErr != nil || node == nil {
+			return false
+		}
+
+		switch node := node.(type) {
+		case *ast.BasicLit:
+			if node.Kind == token.IMAG {
+				syntaxErr = SyntaxError(src, "imaginary numbers are not supported")
+			}
+		case *ast.BinaryExpr:
+			switch node.Op {
+			case token.ADD, token.SUB, token.MUL, token.QUO, token.REM, token.EQL, token.NEQ, token.LSS, token.LEQ,
+				token.GTR, token.GEQ, token.LAND, token.LOR:
+			default:
+				syntaxErr = SyntaxError(src, fmt.Sprintf("operator %s is not supported", node.Op))
+			}
+		case *ast.UnaryExpr:
+			if node.Op != token.NOT && node.Op != token.SUB {
+				syntaxErr = SyntaxError(src, fmt.Sprintf("operator %s is not supported", node.Op))
+			}
+		case *ast.CallExpr:
+			syntaxErr = checkCall(src, node)
+		case *ast.Ident, *ast.SelectorExpr, *ast.IndexExpr, *ast.ParenExpr:
+		default:
+			syntaxErr = SyntaxError(src, fmt.Sprintf("%T is not supported", node))
+		}
+
+		return true
+	})
+
+	if syntaxErr != nil {
+		return nil, syntaxErr
+	}
+
+	return &Program{src: src, root: root}, nil
+}
+
+// checkCall will check that a call is of a function or method of the language.
+func checkCall(src string, call *ast.CallExpr) error {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		if _, ok := functions[fun.Name]; !ok && fun.Name != "has" {
+			return SyntaxError(src, fmt.Sprintf("unknown function %q", fun.Name))
+		}
+
+		if fun.Name == "has" {
+			if len(call.Args) != 1 {
+				return SyntaxError(src, "has takes a field")
+			}
+
+			switch call.Args[0].(type) {
+			case *ast.SelectorExpr, *ast.IndexExpr:
+			default:
+				return SyntaxError(src, "has takes a field")
+			}
+		}
+	case *ast.SelectorExpr:
+		if _, ok := methods[fun.Sel.Name]; !ok {
+			return SyntaxError(src, fmt.Sprintf("unknown method %q", fun.Sel.Name))
+		}
+	default:
+		return SyntaxError(src, "only functions and methods can be called")
+	}
+
+	return nil
+}
+
+// String will return the source of the expression.
+func (prg *Program) String() string {
+	return prg.src
+}
+
+// Eval will evaluate the expression with the variables, e.g. "record" for a record filter. Variables are values
+// decoded from JSON: maps, lists, strings, numbers, booleans, or nil.
+func (prg *Program) Eval(vars map[string]interface{}) (interface{}, error) {
+	value, err := eval(prg.root, vars)
+	if err != nil {
+		return nil, fmt.Errorf("unable to evaluate %q: %w", prg.src, err)
+	}
+
+	return value, nil
+}
+
+// EvalBool will evaluate an expression that must return a boolean, e.g. a filter.
+func (prg *Program) EvalBool(vars map[string]interface{}) (bool, error) {
+	value, err := prg.Eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("unable to evaluate %q: %w", prg.src, EvalError(fmt.Sprintf("%s is not a bool",
+			typeName(value))))
+	}
+
+	return result, nil
+}
+
+// normalize will convert the numbers of decoded values to doubles.
+func normalize(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case json.Number:
+		number, err := value.Float64()
+		if err != nil {
+			return nil, EvalError(err.Error())
+		}
+
+		return number, nil
+	case int:
+		return float64(value), nil
+	case int32:
+		return float64(value), nil
+	case int64:
+		return float64(value), nil
+	case float32:
+		return float64(value), nil
+	default:
+		return value, nil
+	}
+}
+
+// typeName will return the name of the type of a value in the language.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "list"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func eval(node ast.Expr, vars map[string]interface{}) (interface{}, error) {
+	switch node := node.(type) {
+	case *ast.ParenExpr:
+		return eval(node.X, vars)
+	case *ast.BasicLit:
+		return evalLiteral(node)
+	case *ast.Ident:
+		switch node.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+
+		value, ok := vars[node.Name]
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("undeclared variable %q", node.Name))
+		}
+
+		return normalize(value)
+	case *ast.SelectorExpr:
+		return evalField(node.X, node.Sel.Name, vars)
+	case *ast.IndexExpr:
+		return evalIndex(node, vars)
+	case *ast.UnaryExpr:
+		return evalUnary(node, vars)
+	case *ast.BinaryExpr:
+		return evalBinary(node, vars)
+	case *ast.CallExpr:
+		return evalCall(node, vars)
+	default:
+		return nil, EvalError(fmt.Sprintf("%T is not supported", node))
+	}
+}
+
+func evalLiteral(lit *ast.BasicLit) (interface{}, error) {
+	switch lit.Kind {
+	case token.INT, token.FLOAT:
+		number, err := strconv.ParseFloat(strings.ReplaceAll(lit.Value, "_", ""), 64)
+		if err != nil {
+			integer, ierr := strconv.ParseInt(lit.Value, 0, 64)
+			if ierr != nil {
+				return nil, EvalError(err.Error())
+			}
+
+			number = float64(integer)
+		}
+
+		return number, nil
+	case token.STRING, token.CHAR:
+		value, err := strconv.Unquote(lit.Value)
+		if err != nil {
+			return nil, EvalError(err.Error())
+		}
+
+		return value, nil
+	default:
+		return nil, EvalError(fmt.Sprintf("literal %s is not supported", lit.Value))
+	}
+}
+
+// evalField will return the field of a map, or null if it is not set.
+func evalField(node ast.Expr, name string, vars map[string]interface{}) (interface{}, error) {
+	container, err := eval(node, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	value, _, err := field(container, name)
+
+	return value, err
+}
+
+// field will return the field of a map and whether it is set.
+func field(container interface{}, name string) (interface{}, bool, error) {
+	switch container := container.(type) {
+	case map[string]interface{}:
+		value, ok := container[name]
+		if !ok {
+			return nil, false, nil
+		}
+
+		value, err := normalize(value)
+
+		return value, true, err
+	case map[string]string:
+		value, ok := container[name]
+
+		return value, ok, nil
+	case nil:
+		return nil, false, nil
+	default:
+		return nil, false, EvalError(fmt.Sprintf("%s has no fields", typeName(container)))
+	}
+}
+
+func evalIndex(node *ast.IndexExpr, vars map[string]interface{}) (interface{}, error) {
+	container, err := eval(node.X, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := eval(node.Index, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if list, ok := container.([]interface{}); ok {
+		number, ok := index.(float64)
+		if !ok || number != math.Trunc(number) {
+			return nil, EvalError(fmt.Sprintf("list index must be an integer, got %s", typeName(index)))
+		}
+
+		if number < 0 || int(number) >= len(list) {
+			return nil, EvalError(fmt.Sprintf("list index %v out of range", number))
+		}
+
+		return normalize(list[int(number)])
+	}
+
+	name, ok := index.(string)
+	if !ok {
+		return nil, EvalError(fmt.Sprintf("map key must be a string, got %s", typeName(index)))
+	}
+
+	value, _, err := field(container, name)
+
+	return value, err
+}
+
+func evalUnary(node *ast.UnaryExpr, vars map[string]interface{}) (interface{}, error) {
+	value, err := eval(node.X, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Op {
+	case token.NOT:
+		boolean, ok := value.(bool)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("! requires a bool, got %s", typeName(value)))
+		}
+
+		return !boolean, nil
+	default:
+		number, ok := value.(float64)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("- requires a double, got %s", typeName(value)))
+		}
+
+		return -number, nil
+	}
+}
+
+func evalBinary(node *ast.BinaryExpr, vars map[string]interface{}) (interface{}, error) {
+	left, err := eval(node.X, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// The logical operators short-circuit.
+	if node.Op == token.LAND || node.Op == token.LOR {
+		boolean, ok := left.(bool)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("%s requires bools, got %s", node.Op, typeName(left)))
+		}
+
+		if (node.Op == token.LAND && !boolean) || (node.Op == token.LOR && boolean) {
+			return boolean, nil
+		}
+
+		right, err := eval(node.Y, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		result, ok := right.(bool)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("%s requires bools, got %s", node.Op, typeName(right)))
+		}
+
+		return result, nil
+	}
+
+	right, err := eval(node.Y, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.Op {
+	case token.EQL:
+		return equal(left, right), nil
+	case token.NEQ:
+		return !equal(left, right), nil
+	case token.LSS, token.LEQ, token.GTR, token.GEQ:
+		return compare(node.Op, left, right)
+	default:
+		return arithmetic(node.Op, left, right)
+	}
+}
+
+// equal will return true if the values are equal, values of different types are not equal.
+func equal(left, right interface{}) bool {
+	switch left := left.(type) {
+	case map[string]interface{}, []interface{}:
+		return fmt.Sprint(left) == fmt.Sprint(right)
+	default:
+		return left == right
+	}
+}
+
+func compare(op token.Token, left, right interface{}) (bool, error) {
+	var cmp int
+
+	switch left := left.(type) {
+	case float64:
+		number, ok := right.(float64)
+		if !ok {
+			return false, EvalError(fmt.Sprintf("can not compare double to %s", typeName(right)))
+		}
+
+		switch {
+		case left < number:
+			cmp = -1
+		case left > number:
+			cmp = 1
+		}
+	case string:
+		str, ok := right.(string)
+		if !ok {
+			return false, EvalError(fmt.Sprintf("can not compare string to %s", typeName(right)))
+		}
+
+		cmp = strings.Compare(left, str)
+	default:
+		return false, EvalError(fmt.Sprintf("can not compare %s", typeName(left)))
+	}
+
+	switch op {
+	case token.LSS:
+		return cmp < 0, nil
+	case token.LEQ:
+		return cmp <= 0, nil
+	case token.GTR:
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+func arithmetic(op token.Token, left, right interface{}) (interface{}, error) {
+	if str, ok := left.(string); ok && op == token.ADD {
+		other, ok := right.(string)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("can not add string and %s", typeName(right)))
+		}
+
+		return str + other, nil
+	}
+
+	x, xok := left.(float64)
+	y, yok := right.(float64)
+
+	if !xok || !yok {
+		return nil, EvalError(fmt.Sprintf("%s requires doubles, got %s and %s", op, typeName(left), typeName(right)))
+	}
+
+	switch op {
+	case token.ADD:
+		return x + y, nil
+	case token.SUB:
+		return x - y, nil
+	case token.MUL:
+		return x * y, nil
+	case token.QUO:
+		if y == 0 {
+			return nil, EvalError("division by zero")
+		}
+
+		return x / y, nil
+	default:
+		if y == 0 {
+			return nil, EvalError("modulus by zero")
+		}
+
+		return math.Mod(x, y), nil
+	}
+}
+
+// functions are the functions of the language, other than "has", which takes a field rather than a value.
+var functions = map[string]func(interface{}) (interface{}, error){
+	"size": func(value interface{}) (interface{}, error) {
+		switch value := value.(type) {
+		case string:
+			return float64(len([]rune(value))), nil
+		case []interface{}:
+			return float64(len(value)), nil
+		case map[string]interface{}:
+			return float64(len(value)), nil
+		default:
+			return nil, EvalError(fmt.Sprintf("size of %s", typeName(value)))
+		}
+	},
+	"double": toDouble,
+	"int": func(value interface{}) (interface{}, error) {
+		number, err := toDouble(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return math.Trunc(number.(float64)), nil
+	},
+	"string": func(value interface{}) (interface{}, error) {
+		switch value := value.(type) {
+		case string:
+			return value, nil
+		case float64:
+			return strconv.FormatFloat(value, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(value), nil
+		default:
+			return nil, EvalError(fmt.Sprintf("string of %s", typeName(value)))
+		}
+	},
+	"lower": func(value interface{}) (interface{}, error) {
+		str, ok := value.(string)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("lower of %s", typeName(value)))
+		}
+
+		return strings.ToLower(str), nil
+	},
+	"upper": func(value interface{}) (interface{}, error) {
+		str, ok := value.(string)
+		if !ok {
+			return nil, EvalError(fmt.Sprintf("upper of %s", typeName(value)))
+		}
+
+		return strings.ToUpper(str), nil
+	},
+}
+
+// toDouble will convert a double, or a string that holds a number, to a double.
+func toDouble(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case float64:
+		return value, nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, EvalError(fmt.Sprintf("double of %q", value))
+		}
+
+		return number, nil
+	default:
+		return nil, EvalError(fmt.Sprintf("double of %s", typeName(value)))
+	}
+}
+
+// methods are the string methods of the language.
+var methods = map[string]func(string, string) (bool, error){
+	"contains":   func(str, sub string) (bool, error) { return strings.Contains(str, sub), nil },
+	"startsWith": func(str, prefix string) (bool, error) { return strings.HasPrefix(str, prefix), nil },
+	"endsWith":   func(str, suffix string) (bool, error) { return strings.HasSuffix(str, suffix), nil },
+	"matches": func(str, pattern string) (bool, error) {
+		matched, err := regexp.MatchString(pattern, str)
+		if err != nil {
+			return false, EvalError(err.Error())
+		}
+
+		return matched, nil
+	},
+}
+
+func evalCall(call *ast.CallExpr, vars map[string]interface{}) (interface{}, error) {
+	switch fun := call.Fun.(type) {
+	case *ast.Ident:
+		if fun.Name == "has" {
+			return evalHas(call.Args[0], vars)
+		}
+
+		if len(call.Args) != 1 {
+			return nil, EvalError(fmt.Sprintf("%s takes 1 argument", fun.Name))
+		}
+
+		arg, err := eval(call.Args[0], vars)
+		if err != nil {
+			return nil, err
+		}
+
+		return functions[fun.Name](arg)
+	default:
+		sel, _ := fun.(*ast.SelectorExpr)
+		if len(call.Args) != 1 {
+			return nil, EvalError(fmt.Sprintf("%s takes 1 argument", sel.Sel.Name))
+		}
+
+		receiver, err := eval(sel.X, vars)
+		if err != nil {
+			return nil, err
+		}
+
+		arg, err := eval(call.Args[0], vars)
+		if err != nil {
+			return nil, err
+		}
+
+		str, sok := receiver.(string)
+		other, ook := arg.(string)
+
+		if !sok || !ook {
+			return nil, EvalError(fmt.Sprintf("%s requires strings, got %s and %s", sel.Sel.Name, typeName(receiver),
+				typeName(arg)))
+		}
+
+		return methods[sel.Sel.Name](str, other)
+	}
+}
+
+// evalHas will return true if the field is set.
+func evalHas(node ast.Expr, vars map[string]interface{}) (interface{}, error) {
+	var (
+		container interface{}
+		name      string
+		err       error
+	)
+
+	switch node := node.(type) {
+	case *ast.SelectorExpr:
+		container, err = eval(node.X, vars)
+		name = node.Sel.Name
+	case *ast.IndexExpr:
+		container, err = eval(node.X, vars)
+		if err == nil {
+			var index interface{}
+			if index, err = eval(node.Index, vars); err == nil {
+				name, _ = index.(string)
+			}
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, ok, err := field(container, name)
+
+	return ok, err
+}
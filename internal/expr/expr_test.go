@@ -0,0 +1,127 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package expr
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestEval(t *testing.T) {
+	t.Parallel()
+
+	record := map[string]interface{}{
+		"symbol":   "BTC-USD",
+		"price":    "20000.5",
+		"size":     json.Number("2"),
+		"volume":   json.Number("0"),
+		"trade id": json.Number("7"),
+		"side":     nil,
+		"tags":     []interface{}{"a", "b"},
+	}
+
+	vars := map[string]interface{}{
+		"record": record,
+		"params": map[string]string{"granularity": "60"},
+	}
+
+	for _, tcase := range []struct {
+		src      string
+		expected interface{}
+	}{
+		{`record.volume > 0`, false},
+		{`record.size * 2 + 1`, 5.0},
+		{`double(record.price) * record.size`, 40001.0},
+		{`int(double(record.price))`, 20000.0},
+		{`record["trade id"] % 4`, 3.0},
+		{`record.symbol == "BTC-USD" && !(record.size < 1)`, true},
+		{`record.symbol.startsWith("BTC") || record.missing > 0`, true},
+		{`record.symbol.matches("^[A-Z]+-USD$")`, true},
+		{`lower(record.symbol) + "-" + params.granularity`, "btc-usd-60"},
+		{`has(record.side) && !has(record.missing)`, true},
+		{`record.side == null && record.missing == null`, true},
+		{`size(record.tags) == 2 && record.tags[1] == "b"`, true},
+		{`string(record.size) == "2"`, true},
+		{`-record.size`, -2.0},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.src, func(t *testing.T) {
+			t.Parallel()
+
+			prg, err := Compile(tcase.src)
+			if err != nil {
+				t.Fatalf("error compiling expression: %v", err)
+			}
+
+			value, err := prg.Eval(vars)
+			if err != nil {
+				t.Fatalf("error evaluating expression: %v", err)
+			}
+
+			if value != tcase.expected {
+				t.Fatalf("expected %v, got %v", tcase.expected, value)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, src := range []string{
+		`record.volume >`,
+		`record.volume & 1`,
+		`func() {}`,
+		`unknown(record.volume)`,
+		`record.symbol.split("-")`,
+		`has(record)`,
+		`record.volume.(int)`,
+	} {
+		src := src
+
+		t.Run(src, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := Compile(src); !errors.Is(err, ErrSyntax) {
+				t.Fatalf("expected error %v, got %v", ErrSyntax, err)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	t.Parallel()
+
+	vars := map[string]interface{}{"record": map[string]interface{}{"symbol": "BTC-USD"}}
+
+	for _, src := range []string{
+		`record.symbol > 1`,
+		`record.symbol * 2`,
+		`unknown.volume`,
+		`1 / 0`,
+		`double(record.symbol)`,
+		`record.symbol`,
+	} {
+		src := src
+
+		t.Run(src, func(t *testing.T) {
+			t.Parallel()
+
+			prg, err := Compile(src)
+			if err != nil {
+				t.Fatalf("error compiling expression: %v", err)
+			}
+
+			if _, err := prg.EvalBool(vars); !errors.Is(err, ErrEval) {
+				t.Fatalf("expected error %v, got %v", ErrEval, err)
+			}
+		})
+	}
+}
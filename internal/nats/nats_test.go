@@ -0,0 +1,203 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package nats
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeServer is a NATS server that records the published messages and rejects the subjects in "deny". The first
+// "stalls" connections stop responding once their handshake is done.
+type fakeServer struct {
+	listener  net.Listener
+	connect   chan string
+	published chan string
+	deny      string
+	stalls    int32
+}
+
+func newFakeServer(t *testing.T, deny string, stalls int32) *fakeServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := &fakeServer{
+		listener:  listener,
+		connect:   make(chan string, 1),
+		published: make(chan string, 8),
+		deny:      deny,
+		stalls:    stalls,
+	}
+
+	go srv.serve()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return srv
+}
+
+func (srv *fakeServer) serve() {
+	for {
+		conn, err := srv.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go srv.serveConn(conn, atomic.AddInt32(&srv.stalls, -1) >= 0)
+	}
+}
+
+func (srv *fakeServer) serveConn(conn net.Conn, stall bool) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"headers\":true}\r\n")
+
+	pings := 0
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+
+		switch fields[0] {
+		case "CONNECT":
+			srv.connect <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+		case "PING":
+			// The first ping is the one of the handshake.
+			if pings++; stall && pings > 1 {
+				continue
+			}
+
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+
+			if fields[1] == srv.deny {
+				fmt.Fprint(conn, "-ERR 'Permissions Violation for Publish'\r\n")
+
+				continue
+			}
+
+			srv.published <- fields[1] + " " + string(payload[:size])
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	t.Parallel()
+
+	t.Run("published", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		srv := newFakeServer(t, "", 0)
+
+		conn, err := Dial(ctx, "nats://user:pass@"+srv.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+
+		if connect := <-srv.connect; !strings.Contains(connect, `"user":"user","pass":"pass"`) {
+			t.Fatalf("expected the credentials to be sent, got %s", connect)
+		}
+
+		if err := conn.Publish(ctx, "gidari.candles", []byte(`{"table":"candles"}`)); err != nil {
+			t.Fatalf("failed to publish: %v", err)
+		}
+
+		if msg, expected := <-srv.published, `gidari.candles {"table":"candles"}`; msg != expected {
+			t.Fatalf("expected message %q, got %q", expected, msg)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		t.Parallel()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		srv := newFakeServer(t, "private", 0)
+
+		conn, err := Dial(ctx, "nats://"+srv.listener.Addr().String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+
+		if err := conn.Publish(ctx, "private", []byte("{}")); !errors.Is(err, ErrServer) {
+			t.Fatalf("expected error %v, got %v", ErrServer, err)
+		}
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+
+	srv := newFakeServer(t, "", 1)
+
+	conn, err := Dial(context.Background(), "nats://"+srv.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	<-srv.connect
+
+	// The context has no deadline, so the connection's timeout stops the wait on a server that stopped responding.
+	conn.timeout = 100 * time.Millisecond
+
+	var netErr net.Error
+	if err := conn.Publish(context.Background(), "gidari.candles", []byte("1")); !errors.As(err, &netErr) ||
+		!netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+
+	<-srv.published
+
+	// The failed connection was dropped, so the next publish dials the server again.
+	if err := conn.Publish(context.Background(), "gidari.candles", []byte("2")); err != nil {
+		t.Fatalf("failed to publish after reconnecting: %v", err)
+	}
+
+	<-srv.connect
+
+	if msg := <-srv.published; msg != "gidari.candles 2" {
+		t.Fatalf("expected the message to be published on the new connection, got %q", msg)
+	}
+
+	conn.Close()
+
+	if err := conn.Publish(context.Background(), "gidari.candles", []byte("3")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected error %v, got %v", ErrClosed, err)
+	}
+}
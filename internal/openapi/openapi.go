@@ -0,0 +1,581 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package openapi
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	ErrNoPaths            = fmt.Errorf("no paths defined in the specification")
+	ErrUnresolvedRef      = fmt.Errorf("unable to resolve reference")
+	ErrUnsupportedVersion = fmt.Errorf("unsupported specification version")
+)
+
+// UnresolvedRefError is returned when a "$ref" in the specification cannot be resolved.
+func UnresolvedRefError(ref string) error {
+	return fmt.Errorf("%w: %s", ErrUnresolvedRef, ref)
+}
+
+// maxRefDepth is the maximum number of nested references that will be followed, protecting against cyclic references.
+const maxRefDepth = 32
+
+// schema is the subset of a JSON schema object needed to infer where response records live.
+type schema struct {
+	Ref        string             `yaml:"$ref"`
+	Type       string             `yaml:"type"`
+	Format     string             `yaml:"format"`
+	Items      *schema            `yaml:"items"`
+	Properties map[string]*schema `yaml:"properties"`
+	Default    interface{}        `yaml:"default"`
+	Example    interface{}        `yaml:"example"`
+}
+
+// parameter is an OpenAPI/Swagger parameter object.
+type parameter struct {
+	Ref      string      `yaml:"$ref"`
+	Name     string      `yaml:"name"`
+	In       string      `yaml:"in"`
+	Required bool        `yaml:"required"`
+	Default  interface{} `yaml:"default"`
+	Example  interface{} `yaml:"example"`
+	Format   string      `yaml:"format"`
+	Schema   *schema     `yaml:"schema"`
+}
+
+// mediaType is an OpenAPI 3 media type object.
+type mediaType struct {
+	Schema *schema `yaml:"schema"`
+}
+
+// response is an OpenAPI/Swagger response object. Swagger 2 defines the schema on the response directly, OpenAPI 3
+// defines it per content type.
+type response struct {
+	Ref     string                `yaml:"$ref"`
+	Schema  *schema               `yaml:"schema"`
+	Content map[string]*mediaType `yaml:"content"`
+}
+
+// operation is an OpenAPI/Swagger operation object.
+type operation struct {
+	OperationID string               `yaml:"operationId"`
+	Summary     string               `yaml:"summary"`
+	Parameters  []*parameter         `yaml:"parameters"`
+	Responses   map[string]*response `yaml:"responses"`
+}
+
+// pathItem is an OpenAPI/Swagger path item object. Only "GET" operations are considered for discovery, since they are
+// the operations used to query data.
+type pathItem struct {
+	Parameters []*parameter `yaml:"parameters"`
+	Get        *operation   `yaml:"get"`
+}
+
+// server is an OpenAPI 3 server object.
+type server struct {
+	URL string `yaml:"url"`
+}
+
+// document is an OpenAPI 3 or Swagger 2 document. JSON documents are valid YAML, so both formats are decoded with the
+// YAML decoder.
+type document struct {
+	Swagger string `yaml:"swagger"`
+	OpenAPI string `yaml:"openapi"`
+	Info    struct {
+		Title string `yaml:"title"`
+	} `yaml:"info"`
+
+	// Swagger 2
+	Host        string                `yaml:"host"`
+	BasePath    string                `yaml:"basePath"`
+	Schemes     []string              `yaml:"schemes"`
+	Definitions map[string]*schema    `yaml:"definitions"`
+	Parameters  map[string]*parameter `yaml:"parameters"`
+	Responses   map[string]*response  `yaml:"responses"`
+
+	// OpenAPI 3
+	Servers    []*server `yaml:"servers"`
+	Components struct {
+		Schemas    map[string]*schema    `yaml:"schemas"`
+		Parameters map[string]*parameter `yaml:"parameters"`
+		Responses  map[string]*response  `yaml:"responses"`
+	} `yaml:"components"`
+
+	Paths map[string]*pathItem `yaml:"paths"`
+}
+
+// refName will return the name of the component that a local reference points to, e.g. "#/definitions/Pet" will
+// return "Pet".
+func refName(ref string) string {
+	return ref[strings.LastIndex(ref, "/")+1:]
+}
+
+func (doc *document) resolveSchema(sch *schema, depth int) (*schema, error) {
+	for sch != nil && sch.Ref != "" {
+		if depth > maxRefDepth {
+			return nil, UnresolvedRefError(sch.Ref)
+		}
+
+		next, ok := doc.Components.Schemas[refName(sch.Ref)]
+		if !ok {
+			next, ok = doc.Definitions[refName(sch.Ref)]
+		}
+
+		if !ok {
+			return nil, UnresolvedRefError(sch.Ref)
+		}
+
+		sch = next
+		depth++
+	}
+
+	return sch, nil
+}
+
+func (doc *document) resolveParameter(param *parameter) (*parameter, error) {
+	if param.Ref == "" {
+		return param, nil
+	}
+
+	resolved, ok := doc.Components.Parameters[refName(param.Ref)]
+	if !ok {
+		resolved, ok = doc.Parameters[refName(param.Ref)]
+	}
+
+	if !ok {
+		return nil, UnresolvedRefError(param.Ref)
+	}
+
+	return resolved, nil
+}
+
+func (doc *document) resolveResponse(rsp *response) (*response, error) {
+	if rsp.Ref == "" {
+		return rsp, nil
+	}
+
+	resolved, ok := doc.Components.Responses[refName(rsp.Ref)]
+	if !ok {
+		resolved, ok = doc.Responses[refName(rsp.Ref)]
+	}
+
+	if !ok {
+		return nil, UnresolvedRefError(rsp.Ref)
+	}
+
+	return resolved, nil
+}
+
+// baseURL will return the base URL of the API. OpenAPI 3 uses the first server, Swagger 2 builds the URL from the
+// scheme, host, and base path.
+func (doc *document) baseURL() string {
+	if len(doc.Servers) > 0 {
+		return strings.TrimSuffix(doc.Servers[0].URL, "/")
+	}
+
+	scheme := "https"
+	if len(doc.Schemes) > 0 {
+		scheme = doc.Schemes[0]
+	}
+
+	if doc.Host == "" {
+		return strings.TrimSuffix(doc.BasePath, "/")
+	}
+
+	return strings.TrimSuffix(fmt.Sprintf("%s://%s%s", scheme, doc.Host, doc.BasePath), "/")
+}
+
+// QueryParam is a query parameter of a discovered request.
+type QueryParam struct {
+	Name  string
+	Value string
+
+	// Required is true if the API requires the parameter. Required parameters without a default or example value are
+	// given a placeholder value that must be filled in by the user.
+	Required bool
+}
+
+// Timeseries are the query parameters of a discovered request that appear to define a time range.
+type Timeseries struct {
+	StartName string
+	EndName   string
+}
+
+// isRange will return true if the parameter is the start or end of the time range.
+func (series *Timeseries) isRange(name string) bool {
+	return series != nil && (name == series.StartName || name == series.EndName)
+}
+
+// Request is a skeleton gidari request discovered from an OpenAPI operation.
+type Request struct {
+	Endpoint string
+	Method   string
+	Table    string
+	Summary  string
+
+	// RecordsPath is the dot-separated path to the array of records in the response, if the records are nested in
+	// an object.
+	RecordsPath string
+
+	// PathParams are the names of the path parameters that must be filled in on the endpoint.
+	PathParams []string
+
+	Query      []*QueryParam
+	Timeseries *Timeseries
+}
+
+// Skeleton is a skeleton gidari configuration discovered from an OpenAPI specification.
+type Skeleton struct {
+	Title    string
+	URL      string
+	Requests []*Request
+}
+
+// recordsPath will return the dot-separated path to the first array in the response schema. An empty string is
+// returned if the response is itself an array, or if no array could be found.
+func (doc *document) recordsPath(sch *schema, depth int) (string, bool, error) {
+	sch, err := doc.resolveSchema(sch, depth)
+	if err != nil || sch == nil || depth > maxRefDepth {
+		return "", false, err
+	}
+
+	if sch.Type == "array" || sch.Items != nil {
+		return "", true, nil
+	}
+
+	names := make([]string, 0, len(sch.Properties))
+	for name := range sch.Properties {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		path, ok, err := doc.recordsPath(sch.Properties[name], depth+1)
+		if err != nil {
+			return "", false, err
+		}
+
+		if ok {
+			return strings.Trim(name+"."+path, "."), true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// successSchema will return the schema of the first successful JSON response of an operation.
+func (doc *document) successSchema(opr *operation) (*schema, error) {
+	for _, code := range []string{"200", "201", "2XX", "default"} {
+		rsp, ok := opr.Responses[code]
+		if !ok || rsp == nil {
+			continue
+		}
+
+		rsp, err := doc.resolveResponse(rsp)
+		if err != nil {
+			return nil, err
+		}
+
+		if rsp.Schema != nil {
+			return rsp.Schema, nil
+		}
+
+		for contentType, media := range rsp.Content {
+			if strings.Contains(contentType, "json") && media != nil {
+				return media.Schema, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// TableName will derive a table name from the last static segment of the endpoint.
+func TableName(endpoint string) string {
+	parts := strings.Split(strings.Trim(endpoint, "/"), "/")
+	for idx := len(parts) - 1; idx >= 0; idx-- {
+		if parts[idx] != "" && !strings.HasPrefix(parts[idx], "{") {
+			return strings.ReplaceAll(parts[idx], "-", "_")
+		}
+	}
+
+	return "default"
+}
+
+// paramValue will return the default or example value of a parameter, which may be defined on the schema in
+// OpenAPI 3. Nil is returned if the parameter has no value.
+func paramValue(param *parameter) interface{} {
+	for _, value := range []interface{}{param.Default, param.Example} {
+		if value != nil {
+			return value
+		}
+	}
+
+	if param.Schema != nil {
+		for _, value := range []interface{}{param.Schema.Default, param.Schema.Example} {
+			if value != nil {
+				return value
+			}
+		}
+	}
+
+	return nil
+}
+
+// paramFormat will return the format of a parameter, which is defined on the schema in OpenAPI 3.
+func paramFormat(param *parameter) string {
+	if param.Schema != nil && param.Schema.Format != "" {
+		return param.Schema.Format
+	}
+
+	return param.Format
+}
+
+// nameWords will split a parameter name into its lower case words, e.g. "startTime", "start_time", and "start-time"
+// into "start" and "time".
+func nameWords(name string) []string {
+	var (
+		words []string
+		word  []rune
+	)
+
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, string(word))
+			word = nil
+		}
+	}
+
+	lower := false
+
+	for _, r := range name {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			flush()
+
+			lower = false
+
+			continue
+		case unicode.IsUpper(r) && lower:
+			flush()
+		}
+
+		word = append(word, unicode.ToLower(r))
+		lower = !unicode.IsUpper(r)
+	}
+
+	flush()
+
+	return words
+}
+
+// hasWord will return true if a parameter name has one of the words, so that e.g. "to" matches "to" and "createdTo",
+// but not "stock".
+func hasWord(name string, words ...string) bool {
+	for _, nameWord := range nameWords(name) {
+		for _, word := range words {
+			if nameWord == word {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// timeseries will infer the timeseries range parameters from the query parameters of a request, i.e. a pair of
+// "date-time" parameters named with the words "start" and "end", or their synonyms.
+func timeseries(params []*parameter) *Timeseries {
+	var series Timeseries
+
+	for _, param := range params {
+		if param.In != "query" || paramFormat(param) != "date-time" {
+			continue
+		}
+
+		switch {
+		case hasWord(param.Name, "start", "from", "begin"):
+			series.StartName = param.Name
+		case hasWord(param.Name, "end", "to", "until"):
+			series.EndName = param.Name
+		}
+	}
+
+	if series.StartName == "" || series.EndName == "" {
+		return nil
+	}
+
+	return &series
+}
+
+func (doc *document) request(endpoint string, item *pathItem) (*Request, error) {
+	opr := item.Get
+
+	req := &Request{
+		Endpoint: endpoint,
+		Method:   http.MethodGet,
+		Table:    TableName(endpoint),
+		Summary:  opr.Summary,
+	}
+
+	params := make([]*parameter, 0, len(item.Parameters)+len(opr.Parameters))
+
+	for _, param := range append(append([]*parameter{}, item.Parameters...), opr.Parameters...) {
+		param, err := doc.resolveParameter(param)
+		if err != nil {
+			return nil, err
+		}
+
+		params = append(params, param)
+	}
+
+	req.Timeseries = timeseries(params)
+
+	for _, param := range params {
+		switch param.In {
+		case "path":
+			req.PathParams = append(req.PathParams, param.Name)
+		case "query":
+			// Optional parameters are only included when they have a value to document, required parameters
+			// without a value are given a placeholder. The timeseries range parameters are always included, since
+			// the range is read from the query.
+			value := paramValue(param)
+			if value == nil && !param.Required && !req.Timeseries.isRange(param.Name) {
+				continue
+			}
+
+			if value == nil {
+				value = fmt.Sprintf("<%s>", param.Name)
+			}
+
+			req.Query = append(req.Query, &QueryParam{
+				Name:     param.Name,
+				Value:    fmt.Sprintf("%v", value),
+				Required: param.Required,
+			})
+		}
+	}
+
+	sch, err := doc.successSchema(opr)
+	if err != nil {
+		return nil, err
+	}
+
+	req.RecordsPath, _, err = doc.recordsPath(sch, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// Discover will consume an OpenAPI 3 or Swagger 2 document, in either JSON or YAML, and return a skeleton gidari
+// configuration with a request for every "GET" operation in the document.
+func Discover(spec []byte) (*Skeleton, error) {
+	var doc document
+	if err := yaml.Unmarshal(spec, &doc); err != nil {
+		return nil, fmt.Errorf("unable to decode specification: %w", err)
+	}
+
+	if doc.Swagger == "" && doc.OpenAPI == "" {
+		return nil, ErrUnsupportedVersion
+	}
+
+	if len(doc.Paths) == 0 {
+		return nil, ErrNoPaths
+	}
+
+	skeleton := &Skeleton{Title: doc.Info.Title, URL: doc.baseURL()}
+
+	endpoints := make([]string, 0, len(doc.Paths))
+	for endpoint := range doc.Paths {
+		endpoints = append(endpoints, endpoint)
+	}
+
+	sort.Strings(endpoints)
+
+	for _, endpoint := range endpoints {
+		item := doc.Paths[endpoint]
+		if item == nil || item.Get == nil {
+			continue
+		}
+
+		req, err := doc.request(endpoint, item)
+		if err != nil {
+			return nil, fmt.Errorf("unable to discover request for %q: %w", endpoint, err)
+		}
+
+		skeleton.Requests = append(skeleton.Requests, req)
+	}
+
+	return skeleton, nil
+}
+
+// configFuncs escape the values of the specification for the configuration template, so that values with YAML syntax,
+// e.g. quotes, colons, or newlines, can not change the structure of the configuration.
+var configFuncs = template.FuncMap{
+	// quote will return a value as a double-quoted YAML scalar, whose escapes are the same as Go's.
+	"quote": strconv.Quote,
+
+	// line will return a value on a single line, so that it does not end the comment that it is written in.
+	"line": func(value string) string { return strings.Join(strings.Fields(value), " ") },
+}
+
+var configTemplate = template.Must(template.New("config").Funcs(configFuncs).Parse(
+	`# Skeleton gidari configuration{{ with .Title }} for {{ line . }}{{ end }}, generated from an OpenAPI specification.
+# Review the requests, fill in the placeholder values, and add your connection strings before use.
+version: 2
+url: {{ quote .URL }}
+connectionStrings: []
+rateLimit:
+  burst: 1
+  period: 1s
+requests:
+{{- range .Requests }}
+  # {{ with .Summary }}{{ line . }}{{ else }}{{ .Method }} {{ line .Endpoint }}{{ end }}
+{{- with .PathParams }}
+  # path parameters to fill in: {{ range $idx, $name := . }}{{ if $idx }}, {{ end }}{{ line $name }}{{ end }}
+{{- end }}
+{{- with .RecordsPath }}
+  # response records are nested under {{ quote . }}
+{{- end }}
+  - endpoint: {{ quote .Endpoint }}
+    table: {{ quote .Table }}
+{{- with .Query }}
+    query:
+{{- range . }}
+      {{ quote .Name }}: {{ quote .Value }}{{ if .Required }} # required{{ end }}
+{{- end }}
+{{- end }}
+{{- with .Timeseries }}
+    timeseries:
+      startName: {{ quote .StartName }}
+      endName: {{ quote .EndName }}
+      period: 86400
+{{- end }}
+{{- end }}
+`))
+
+// WriteConfig will write the skeleton as a gidari YAML configuration.
+func (skeleton *Skeleton) WriteConfig(w io.Writer) error {
+	if err := configTemplate.Execute(w, skeleton); err != nil {
+		return fmt.Errorf("unable to write config: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/transport"
+)
+
+func TestDiscover(t *testing.T) {
+	t.Parallel()
+
+	spec, err := os.ReadFile("testdata/petstore.yml")
+	if err != nil {
+		t.Fatalf("error reading spec: %v", err)
+	}
+
+	skeleton, err := Discover(spec)
+	if err != nil {
+		t.Fatalf("error discovering spec: %v", err)
+	}
+
+	t.Run("base url", func(t *testing.T) {
+		t.Parallel()
+
+		if skeleton.URL != "https://petstore.test.com/v1" {
+			t.Fatalf("unexpected url: %q", skeleton.URL)
+		}
+	})
+
+	t.Run("requests", func(t *testing.T) {
+		t.Parallel()
+
+		expected := []*Request{
+			{
+				// Optional range parameters are kept, and "stockDate" is not an end parameter.
+				Endpoint: "/orders",
+				Method:   "GET",
+				Table:    "orders",
+				Summary:  "List orders",
+				Query: []*QueryParam{
+					{Name: "startTime", Value: "<startTime>"},
+					{Name: "end_time", Value: "<end_time>"},
+				},
+				Timeseries: &Timeseries{StartName: "startTime", EndName: "end_time"},
+			},
+			{
+				Endpoint:    "/pets",
+				Method:      "GET",
+				Table:       "pets",
+				Summary:     "List all pets",
+				RecordsPath: "data",
+				Query:       []*QueryParam{{Name: "limit", Value: "100"}},
+			},
+			{
+				Endpoint:   "/pets/{petId}/visits",
+				Method:     "GET",
+				Table:      "visits",
+				PathParams: []string{"petId"},
+				Query: []*QueryParam{
+					{Name: "start", Value: "<start>", Required: true},
+					{Name: "end", Value: "<end>", Required: true},
+				},
+				Timeseries: &Timeseries{StartName: "start", EndName: "end"},
+			},
+		}
+
+		if !reflect.DeepEqual(expected, skeleton.Requests) {
+			t.Fatalf("unexpected requests: %+v", skeleton.Requests)
+		}
+	})
+
+	t.Run("config is valid", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		if err := skeleton.WriteConfig(&buf); err != nil {
+			t.Fatalf("error writing config: %v", err)
+		}
+
+		cfg, err := transport.NewConfig(buf.Bytes())
+		if err != nil {
+			t.Fatalf("error creating config from skeleton: %v\n%s", err, buf.String())
+		}
+
+		if len(cfg.Requests) != len(skeleton.Requests) {
+			t.Fatalf("expected %d requests, got %d", len(skeleton.Requests), len(cfg.Requests))
+		}
+
+		// Skeletons are written in the latest version of the configuration format.
+		if _, version, err := transport.UpgradeConfig(buf.Bytes()); err != nil || version != transport.ConfigVersion {
+			t.Fatalf("expected config version %d, got %d: %v", transport.ConfigVersion, version, err)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := Discover([]byte(`{"paths": {}}`)); !errors.Is(err, ErrUnsupportedVersion) {
+			t.Fatalf("expected unsupported version error, got %v", err)
+		}
+	})
+}
+
+func TestWriteConfigEscapes(t *testing.T) {
+	t.Parallel()
+
+	skeleton := &Skeleton{
+		Title: "Pets\nurl: https://evil.test.com",
+		URL:   "https://petstore.test.com/v1",
+		Requests: []*Request{
+			{
+				Endpoint:    "/pets",
+				Method:      "GET",
+				Table:       "pets",
+				Summary:     "List: pets\n  - endpoint: /evil",
+				RecordsPath: "data\n",
+				PathParams:  []string{"petId\n"},
+				Query:       []*QueryParam{{Name: "filter", Value: `a" # b: c`, Required: true}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := skeleton.WriteConfig(&buf); err != nil {
+		t.Fatalf("error writing config: %v", err)
+	}
+
+	cfg, err := transport.NewConfig(buf.Bytes())
+	if err != nil {
+		t.Fatalf("error creating config from skeleton: %v\n%s", err, buf.String())
+	}
+
+	if cfg.URL.String() != skeleton.URL || len(cfg.Requests) != 1 {
+		t.Fatalf("expected the skeleton's url and request, got:\n%s", buf.String())
+	}
+
+	if value := cfg.Requests[0].Query["filter"]; value != skeleton.Requests[0].Query[0].Value {
+		t.Fatalf("expected the query value to be preserved, got %q", value)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsService is the signing name of AWS Secrets Manager.
+const awsService = "secretsmanager"
+
+// AWSSecretsManager fetches secrets from AWS Secrets Manager. The path of a reference is the name or ARN of the secret.
+// Requests are signed with AWS Signature Version 4.
+type AWSSecretsManager struct {
+	// Region is the region of the secrets, e.g. "us-east-1".
+	Region string
+
+	// AccessKeyID, SecretAccessKey, and SessionToken are the credentials that sign the requests. The session token is
+	// only required for temporary credentials.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// Endpoint is the endpoint of the API. Defaults to the regional endpoint.
+	Endpoint string
+
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewAWSSecretsManager will return an AWS Secrets Manager provider configured by the "AWS_REGION" (or
+// "AWS_DEFAULT_REGION"), "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", and "AWS_SESSION_TOKEN" environment variables.
+func NewAWSSecretsManager(client *http.Client) *AWSSecretsManager {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	return &AWSSecretsManager{
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		client:          client,
+		now:             time.Now,
+	}
+}
+
+// Secret will return the secret string of the current version of the secret, or its binary value if it has no string.
+func (aws *AWSSecretsManager) Secret(ctx context.Context, path string) (string, error) {
+	if aws.Region == "" || aws.AccessKeyID == "" || aws.SecretAccessKey == "" {
+		return "", ProviderError("aws", "AWS_REGION, AWS_ACCESS_KEY_ID, and AWS_SECRET_ACCESS_KEY are required")
+	}
+
+	endpoint := aws.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", awsService, aws.Region)
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": path})
+	if err != nil {
+		return "", fmt.Errorf("unable to marshal aws request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(endpoint, "/")+"/",
+		bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("unable to create aws request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	aws.sign(req, body)
+
+	rspBody, err := do(aws.client, "aws", req)
+	if err != nil {
+		return "", err
+	}
+
+	var rsp struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}
+
+	if err := json.Unmarshal(rspBody, &rsp); err != nil {
+		return "", ProviderError("aws", err.Error())
+	}
+
+	if rsp.SecretString != nil {
+		return *rsp.SecretString, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(rsp.SecretBinary)
+	if err != nil {
+		return "", ProviderError("aws", err.Error())
+	}
+
+	return string(data), nil
+}
+
+// sign will sign a request with AWS Signature Version 4, setting its "Authorization" header.
+func (aws *AWSSecretsManager) sign(req *http.Request, body []byte) {
+	now := aws.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	if aws.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", aws.SessionToken)
+	}
+
+	signedHeaders := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if aws.SessionToken != "" {
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+	}
+
+	sort.Strings(signedHeaders)
+
+	var canonicalHeaders strings.Builder
+	for _, header := range signedHeaders {
+		canonicalHeaders.WriteString(header + ":" + strings.TrimSpace(req.Header.Get(header)) + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		"/",
+		"",
+		canonicalHeaders.String(),
+		strings.Join(signedHeaders, ";"),
+		hexSHA256(body),
+	}, "\n")
+
+	scope := strings.Join([]string{date, aws.Region, awsService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hexSHA256([]byte(canonicalRequest))},
+		"\n")
+
+	key := []byte("AWS4" + aws.SecretAccessKey)
+	for _, part := range []string{date, aws.Region, awsService, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		aws.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// gcpEndpoint is the endpoint of the GCP Secret Manager API.
+const gcpEndpoint = "https://secretmanager.googleapis.com"
+
+// GCPSecretManager fetches secrets from GCP Secret Manager. The path of a reference is the resource name of a secret
+// version, "projects/<project>/secrets/<secret>/versions/<version>", or the shorthand "<project>/<secret>" for the
+// latest version.
+type GCPSecretManager struct {
+	// Token is an OAuth2 access token that authenticates the requests, e.g. from "gcloud auth print-access-token".
+	Token string
+
+	// Endpoint is the endpoint of the API. Defaults to "https://secretmanager.googleapis.com".
+	Endpoint string
+
+	client *http.Client
+}
+
+// NewGCPSecretManager will return a GCP Secret Manager provider authenticated by the "GOOGLE_OAUTH_ACCESS_TOKEN"
+// environment variable.
+func NewGCPSecretManager(client *http.Client) *GCPSecretManager {
+	return &GCPSecretManager{
+		Token:    os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"),
+		Endpoint: gcpEndpoint,
+		client:   client,
+	}
+}
+
+// gcpVersionName will return the resource name of the secret version of a path.
+func gcpVersionName(path string) (string, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	switch {
+	case len(parts) == 2:
+		return fmt.Sprintf("projects/%s/secrets/%s/versions/latest", parts[0], parts[1]), nil
+	case len(parts) == 3:
+		return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", parts[0], parts[1], parts[2]), nil
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		return path + "/versions/latest", nil
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+		return path, nil
+	}
+
+	return "", InvalidReferenceError(path, "expected projects/<project>/secrets/<secret>/versions/<version>")
+}
+
+// Secret will return the payload of the secret version at the path.
+func (gcp *GCPSecretManager) Secret(ctx context.Context, path string) (string, error) {
+	if gcp.Token == "" {
+		return "", ProviderError("gcp", "GOOGLE_OAUTH_ACCESS_TOKEN is not set")
+	}
+
+	name, err := gcpVersionName(path)
+	if err != nil {
+		return "", err
+	}
+
+	url := strings.TrimSuffix(gcp.Endpoint, "/") + "/v1/" + name + ":access"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to create gcp request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+gcp.Token)
+
+	body, err := do(gcp.client, "gcp", req)
+	if err != nil {
+		return "", err
+	}
+
+	var rsp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return "", ProviderError("gcp", err.Error())
+	}
+
+	data, err := base64.StdEncoding.DecodeString(rsp.Payload.Data)
+	if err != nil {
+		return "", ProviderError("gcp", err.Error())
+	}
+
+	return string(data), nil
+}
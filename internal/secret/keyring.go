@@ -0,0 +1,121 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// DefaultKeyringService is the keyring service of credentials whose reference only names the account.
+const DefaultKeyringService = "gidari"
+
+var ErrKeyringNotSupported = fmt.Errorf("keyring is not supported on this platform")
+
+// Keyring fetches secrets from the keyring of the operating system: the login keychain on macOS, using the "security"
+// tool, or the Secret Service on Linux, e.g. GNOME Keyring or KWallet, using the "secret-tool" tool. The path of a
+// reference is "<service>/<account>", or "<account>" of the "gidari" service.
+type Keyring struct {
+	// goos is the operating system, used to select the keyring tool.
+	goos string
+}
+
+// NewKeyring will return a provider of the keyring of the operating system.
+func NewKeyring() *Keyring {
+	return &Keyring{goos: runtime.GOOS}
+}
+
+// keyringItem will return the service and account of a path.
+func keyringItem(path string) (string, string) {
+	if service, account, ok := strings.Cut(path, "/"); ok {
+		return service, account
+	}
+
+	return DefaultKeyringService, path
+}
+
+// command will return the name and arguments of the command that runs an operation on an item of the keyring.
+func (keyring *Keyring) command(operation, service, account string) (string, []string, error) {
+	switch keyring.goos {
+	case "darwin":
+		switch operation {
+		case "get":
+			return "security", []string{"find-generic-password", "-s", service, "-a", account, "-w"}, nil
+		case "set":
+			// The secret is read from stdin by "-w" when it is the last argument.
+			return "security", []string{"add-generic-password", "-U", "-s", service, "-a", account, "-w"}, nil
+		case "delete":
+			return "security", []string{"delete-generic-password", "-s", service, "-a", account}, nil
+		}
+	case "linux", "freebsd", "openbsd":
+		switch operation {
+		case "get":
+			return "secret-tool", []string{"lookup", "service", service, "account", account}, nil
+		case "set":
+			label := fmt.Sprintf("--label=%s/%s", service, account)
+
+			return "secret-tool", []string{"store", label, "service", service, "account", account}, nil
+		case "delete":
+			return "secret-tool", []string{"clear", "service", service, "account", account}, nil
+		}
+	}
+
+	return "", nil, fmt.Errorf("%w: %s", ErrKeyringNotSupported, keyring.goos)
+}
+
+// run will run an operation on an item of the keyring, with the input on stdin, and return its output.
+func (keyring *Keyring) run(ctx context.Context, operation, path, input string) (string, error) {
+	service, account := keyringItem(path)
+
+	name, args, err := keyring.command(operation, service, account)
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", ProviderError("keyring", fmt.Sprintf("%s %q: %v: %s", operation, path, err,
+			strings.TrimSpace(stderr.String())))
+	}
+
+	return stdout.String(), nil
+}
+
+// Secret will return the secret of the keyring item at the path.
+func (keyring *Keyring) Secret(ctx context.Context, path string) (string, error) {
+	secret, err := keyring.run(ctx, "get", path, "")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(secret, "\n"), nil
+}
+
+// Set will store the secret in the keyring item at the path, replacing any existing secret.
+func (keyring *Keyring) Set(ctx context.Context, path, secret string) error {
+	_, err := keyring.run(ctx, "set", path, secret)
+
+	return err
+}
+
+// Delete will delete the keyring item at the path.
+func (keyring *Keyring) Delete(ctx context.Context, path string) error {
+	_, err := keyring.run(ctx, "delete", path, "")
+
+	return err
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Scheme is the scheme of secret references, e.g. "secret://vault/secret/data/db#password".
+const Scheme = "secret://"
+
+var (
+	ErrInvalidReference = fmt.Errorf("invalid secret reference")
+	ErrKeyNotFound      = fmt.Errorf("secret key not found")
+	ErrProvider         = fmt.Errorf("secret provider error")
+	ErrUnknownProvider  = fmt.Errorf("unknown secret provider")
+)
+
+// InvalidReferenceError wraps an error with ErrInvalidReference.
+func InvalidReferenceError(ref, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidReference, ref, reason)
+}
+
+// ProviderError wraps an error with ErrProvider.
+func ProviderError(provider, reason string) error {
+	return fmt.Errorf("%w: %s: %s", ErrProvider, provider, reason)
+}
+
+// embeddedReference matches the references embedded in a value, e.g. the password of a connection string
+// "postgresql://user:${secret://vault/secret/data/db#password}@localhost:5432/db".
+var embeddedReference = regexp.MustCompile(`\$\{(secret://[^}]+)\}`)
+
+// Provider fetches secrets from a secrets manager.
+type Provider interface {
+	// Secret will return the secret stored at a path. Secrets whose keys are referenced must be JSON objects.
+	Secret(ctx context.Context, path string) (string, error)
+}
+
+// Reference is a parsed secret reference, "secret://<provider>/<path>#<key>".
+type Reference struct {
+	// Provider is the name of the provider that stores the secret, e.g. "vault".
+	Provider string
+
+	// Path is the path of the secret within the provider.
+	Path string
+
+	// Key is the field of the secret's JSON object to return. If it is empty, the entire secret is returned.
+	Key string
+}
+
+// ParseReference will parse a secret reference.
+func ParseReference(ref string) (*Reference, error) {
+	if !strings.HasPrefix(ref, Scheme) {
+		return nil, InvalidReferenceError(ref, "scheme must be "+Scheme)
+	}
+
+	rest, key, _ := strings.Cut(strings.TrimPrefix(ref, Scheme), "#")
+
+	provider, path, _ := strings.Cut(rest, "/")
+	if provider == "" || path == "" {
+		return nil, InvalidReferenceError(ref, "provider and path are required")
+	}
+
+	return &Reference{Provider: provider, Path: path, Key: key}, nil
+}
+
+// IsReference will return true if a value is, or embeds, a secret reference.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, Scheme) || embeddedReference.MatchString(value)
+}
+
+// Resolver resolves secret references using a set of providers. Secrets are fetched once and cached for the lifetime
+// of the resolver. It is safe for concurrent use.
+type Resolver struct {
+	providers map[string]Provider
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewResolver will return a resolver with the built-in "vault", "aws", "gcp", "store", and "keyring" providers,
+// configured from the environment, and the custom providers, which take precedence.
+func NewResolver(client *http.Client, providers map[string]Provider) *Resolver {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resolver := &Resolver{
+		providers: map[string]Provider{
+			"vault":   NewVault(client),
+			"aws":     NewAWSSecretsManager(client),
+			"gcp":     NewGCPSecretManager(client),
+			"store":   new(envStore),
+			"keyring": NewKeyring(),
+		},
+		cache: make(map[string]string),
+	}
+
+	for name, provider := range providers {
+		resolver.providers[name] = provider
+	}
+
+	return resolver
+}
+
+// Resolve will return the value with its secret references replaced by their secrets. A value is either a reference,
+// or embeds references enclosed in "${...}". Values without references are returned as is.
+func (resolver *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	if strings.HasPrefix(value, Scheme) {
+		return resolver.secret(ctx, value)
+	}
+
+	var err error
+
+	resolved := embeddedReference.ReplaceAllStringFunc(value, func(match string) string {
+		if err != nil {
+			return match
+		}
+
+		var secret string
+
+		secret, err = resolver.secret(ctx, embeddedReference.FindStringSubmatch(match)[1])
+
+		return secret
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return resolved, nil
+}
+
+// secret will return the secret of a reference.
+func (resolver *Resolver) secret(ctx context.Context, ref string) (string, error) {
+	resolver.mu.Lock()
+	defer resolver.mu.Unlock()
+
+	if secret, ok := resolver.cache[ref]; ok {
+		return secret, nil
+	}
+
+	parsed, err := ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	provider, ok := resolver.providers[parsed.Provider]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrUnknownProvider, parsed.Provider)
+	}
+
+	secret, err := provider.Secret(ctx, parsed.Path)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve secret %q: %w", ref, err)
+	}
+
+	if parsed.Key != "" {
+		if secret, err = jsonKey(secret, parsed.Key); err != nil {
+			return "", fmt.Errorf("unable to resolve secret %q: %w", ref, err)
+		}
+	}
+
+	resolver.cache[ref] = secret
+
+	return secret, nil
+}
+
+// jsonKey will return the value of a key of a secret's JSON object. Values that are not strings are returned as JSON.
+func jsonKey(secret, key string) (string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("%w: secret is not a JSON object", ErrKeyNotFound)
+	}
+
+	raw, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrKeyNotFound, key)
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return string(raw), nil //nolint:nilerr // values that are not strings are returned as JSON.
+	}
+
+	return value, nil
+}
+
+// do will make a request to a provider's API and return the body of a successful response.
+func do(client *http.Client, provider string, req *http.Request) ([]byte, error) {
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, ProviderError(provider, err.Error())
+	}
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s response: %w", provider, err)
+	}
+
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		return nil, ProviderError(provider, fmt.Sprintf("%s: %s", rsp.Status, body))
+	}
+
+	return body, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// staticProvider returns the secrets of a map, counting the fetches.
+type staticProvider struct {
+	secrets map[string]string
+	fetches int
+}
+
+func (provider *staticProvider) Secret(_ context.Context, path string) (string, error) {
+	provider.fetches++
+
+	secret, ok := provider.secrets[path]
+	if !ok {
+		return "", fmt.Errorf("no secret at %q", path)
+	}
+
+	return secret, nil
+}
+
+func TestParseReference(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		ref      string
+		expected Reference
+		err      error
+	}{
+		{ref: "secret://vault/secret/data/db#password", expected: Reference{"vault", "secret/data/db", "password"}},
+		{ref: "secret://aws/prod/api-key", expected: Reference{Provider: "aws", Path: "prod/api-key"}},
+		{ref: "secret://vault", err: ErrInvalidReference},
+		{ref: "vault://secret/data/db", err: ErrInvalidReference},
+	} {
+		ref, err := ParseReference(tcase.ref)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("expected error %v for %q, got %v", tcase.err, tcase.ref, err)
+		}
+
+		if err == nil && *ref != tcase.expected {
+			t.Fatalf("expected %+v for %q, got %+v", tcase.expected, tcase.ref, *ref)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	t.Parallel()
+
+	provider := &staticProvider{secrets: map[string]string{
+		"db":    `{"user":"gidari","password":"p@ss","port":5432}`,
+		"token": "abc123",
+	}}
+
+	resolver := NewResolver(nil, map[string]Provider{"static": provider})
+	ctx := context.Background()
+
+	for _, tcase := range []struct {
+		value    string
+		expected string
+		err      error
+	}{
+		{value: "plain", expected: "plain"},
+		{value: "secret://static/token", expected: "abc123"},
+		{value: "secret://static/db#password", expected: "p@ss"},
+		{value: "secret://static/db#port", expected: "5432"},
+		{
+			value:    "postgresql://${secret://static/db#user}:${secret://static/db#password}@localhost:5432/db",
+			expected: "postgresql://gidari:p@ss@localhost:5432/db",
+		},
+		{value: "secret://static/db#missing", err: ErrKeyNotFound},
+		{value: "secret://static/token#key", err: ErrKeyNotFound},
+		{value: "secret://unknown/token", err: ErrUnknownProvider},
+	} {
+		resolved, err := resolver.Resolve(ctx, tcase.value)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("expected error %v for %q, got %v", tcase.err, tcase.value, err)
+		}
+
+		if resolved != tcase.expected {
+			t.Fatalf("expected %q for %q, got %q", tcase.expected, tcase.value, resolved)
+		}
+	}
+
+	// Secrets are fetched once per reference.
+	fetches := provider.fetches
+	if _, err := resolver.Resolve(ctx, "secret://static/token"); err != nil || provider.fetches != fetches {
+		t.Fatalf("expected the cached secret, got %d fetches and error %v", provider.fetches-fetches, err)
+	}
+}
+
+func TestVault(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v1/secret/data/db":
+			fmt.Fprint(w, `{"data":{"data":{"password":"kv2"},"metadata":{"version":1}}}`)
+		case "/v1/kv/db":
+			fmt.Fprint(w, `{"data":{"password":"kv1"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	resolver := NewResolver(nil, map[string]Provider{
+		"vault": &Vault{Address: server.URL, Token: "token", client: server.Client()},
+	})
+
+	for ref, expected := range map[string]string{
+		"secret://vault/secret/data/db#password": "kv2",
+		"secret://vault/kv/db#password":          "kv1",
+	} {
+		secret, err := resolver.Resolve(context.Background(), ref)
+		if err != nil {
+			t.Fatalf("failed to resolve %q: %v", ref, err)
+		}
+
+		if secret != expected {
+			t.Fatalf("expected %q for %q, got %q", expected, ref, secret)
+		}
+	}
+
+	if _, err := resolver.Resolve(context.Background(), "secret://vault/kv/missing"); !errors.Is(err, ErrProvider) {
+		t.Fatalf("expected error %v, got %v", ErrProvider, err)
+	}
+}
+
+func TestGCPSecretManager(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" ||
+			r.URL.Path != "/v1/projects/proj/secrets/api-key/versions/latest:access" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		fmt.Fprintf(w, `{"payload":{"data":%q}}`, base64.StdEncoding.EncodeToString([]byte("gcp-secret")))
+	}))
+	defer server.Close()
+
+	gcp := &GCPSecretManager{Token: "token", Endpoint: server.URL, client: server.Client()}
+
+	for _, path := range []string{"proj/api-key", "projects/proj/secrets/api-key"} {
+		secret, err := gcp.Secret(context.Background(), path)
+		if err != nil {
+			t.Fatalf("failed to fetch %q: %v", path, err)
+		}
+
+		if secret != "gcp-secret" {
+			t.Fatalf("expected %q for %q, got %q", "gcp-secret", path, secret)
+		}
+	}
+}
+
+func TestAWSSecretsManager(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+
+		prefix := "AWS4-HMAC-SHA256 Credential=AKID/20220101/us-east-1/secretsmanager/aws4_request, " +
+			"SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-amz-target, Signature="
+		if !strings.HasPrefix(authorization, prefix) || r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusForbidden)
+
+			return
+		}
+
+		fmt.Fprint(w, `{"SecretString":"{\"password\":\"aws\"}"}`)
+	}))
+	defer server.Close()
+
+	aws := &AWSSecretsManager{
+		Region:          "us-east-1",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		SessionToken:    "session",
+		Endpoint:        server.URL,
+		client:          server.Client(),
+		now:             func() time.Time { return time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC) },
+	}
+
+	resolver := NewResolver(nil, map[string]Provider{"aws": aws})
+
+	secret, err := resolver.Resolve(context.Background(), "secret://aws/prod/db#password")
+	if err != nil {
+		t.Fatalf("failed to resolve secret: %v", err)
+	}
+
+	if secret != "aws" {
+		t.Fatalf("expected %q, got %q", "aws", secret)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// storeVersion is the version of the credential store's file format.
+	storeVersion = 1
+
+	// The scrypt parameters that derive the key of a credential store from its passphrase.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	storeSaltSize = 16
+	storeFileMode = 0o600
+	storeDirMode  = 0o700
+)
+
+var (
+	ErrCredentialNotFound = fmt.Errorf("credential not found")
+	ErrWrongPassphrase    = fmt.Errorf("wrong passphrase or corrupted credential store")
+)
+
+// storeFile is the encrypted file of a credential store.
+type storeFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Store is a local credential store, a file of named credentials encrypted with a passphrase, for users who can not
+// run a secrets manager. The key is derived from the passphrase with scrypt, and the credentials are sealed with
+// XChaCha20-Poly1305. The path of a reference is the name of a credential, e.g. "secret://store/coinbase#secret" for a
+// JSON credential.
+type Store struct {
+	path       string
+	passphrase string
+
+	mu          sync.Mutex
+	credentials map[string]string
+}
+
+// DefaultStorePath will return the path of the credential store, set by the "GIDARI_CREDENTIALS_FILE" environment
+// variable, or "gidari/credentials" in the user's configuration directory.
+func DefaultStorePath() (string, error) {
+	if path := os.Getenv("GIDARI_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the user configuration directory: %w", err)
+	}
+
+	return filepath.Join(dir, "gidari", "credentials"), nil
+}
+
+// OpenStore will open the credential store at the path, decrypting it with the passphrase. If the file does not exist,
+// the store is empty until it is saved.
+func OpenStore(path, passphrase string) (*Store, error) {
+	store := &Store{path: path, passphrase: passphrase, credentials: make(map[string]string)}
+
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credential store: %w", err)
+	}
+
+	var file storeFile
+	if err := json.Unmarshal(bytes, &file); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal credential store: %w", err)
+	}
+
+	if file.Version != storeVersion {
+		return nil, fmt.Errorf("unsupported credential store version %d", file.Version)
+	}
+
+	aead, err := storeCipher(passphrase, file.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, file.Nonce, file.Data, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	if err := json.Unmarshal(plaintext, &store.credentials); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal credentials: %w", err)
+	}
+
+	return store, nil
+}
+
+// storeCipher will return the cipher of a credential store, keyed by its passphrase and salt.
+func storeCipher(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to derive credential store key: %w", err)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create credential store cipher: %w", err)
+	}
+
+	return aead, nil
+}
+
+// Secret will return the credential with the name.
+func (store *Store) Secret(_ context.Context, name string) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	credential, ok := store.credentials[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrCredentialNotFound, name)
+	}
+
+	return credential, nil
+}
+
+// Set will set the credential with the name. The store must be saved to persist it.
+func (store *Store) Set(name, credential string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.credentials[name] = credential
+}
+
+// Delete will delete the credential with the name. The store must be saved to persist the delete.
+func (store *Store) Delete(name string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.credentials[name]; !ok {
+		return fmt.Errorf("%w: %q", ErrCredentialNotFound, name)
+	}
+
+	delete(store.credentials, name)
+
+	return nil
+}
+
+// Names will return the sorted names of the credentials.
+func (store *Store) Names() []string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	names := make([]string, 0, len(store.credentials))
+	for name := range store.credentials {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Save will encrypt the credentials with a new salt and nonce, and write them to the store's file.
+func (store *Store) Save() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	plaintext, err := json.Marshal(store.credentials)
+	if err != nil {
+		return fmt.Errorf("unable to marshal credentials: %w", err)
+	}
+
+	salt := make([]byte, storeSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("unable to generate salt: %w", err)
+	}
+
+	aead, err := storeCipher(store.passphrase, salt)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	bytes, err := json.Marshal(&storeFile{
+		Version: storeVersion,
+		Salt:    salt,
+		Nonce:   nonce,
+		Data:    aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("unable to marshal credential store: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(store.path), storeDirMode); err != nil {
+		return fmt.Errorf("unable to create credential store directory: %w", err)
+	}
+
+	if err := os.WriteFile(store.path, bytes, storeFileMode); err != nil {
+		return fmt.Errorf("unable to write credential store: %w", err)
+	}
+
+	return nil
+}
+
+// envStore is the credential store of the "store" provider, opened from the environment on first use, so that the
+// passphrase is only required by configurations that reference it.
+type envStore struct {
+	once  sync.Once
+	store *Store
+	err   error
+}
+
+// Secret will return the credential with the name from the store at the default path, decrypted with the
+// "GIDARI_CREDENTIALS_PASSPHRASE" environment variable.
+func (env *envStore) Secret(ctx context.Context, name string) (string, error) {
+	env.once.Do(func() {
+		passphrase := os.Getenv("GIDARI_CREDENTIALS_PASSPHRASE")
+		if passphrase == "" {
+			env.err = ProviderError("store", "GIDARI_CREDENTIALS_PASSPHRASE is not set")
+
+			return
+		}
+
+		path, err := DefaultStorePath()
+		if err != nil {
+			env.err = err
+
+			return
+		}
+
+		env.store, env.err = OpenStore(path, passphrase)
+	})
+
+	if env.err != nil {
+		return "", env.err
+	}
+
+	return env.store.Secret(ctx, name)
+}
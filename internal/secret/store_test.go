@@ -0,0 +1,99 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "gidari", "credentials")
+
+	store, err := OpenStore(path, "passphrase")
+	if err != nil {
+		t.Fatalf("failed to open new store: %v", err)
+	}
+
+	store.Set("coinbase", `{"key":"k","secret":"s"}`)
+	store.Set("token", "abc123")
+
+	if err := store.Save(); err != nil {
+		t.Fatalf("failed to save store: %v", err)
+	}
+
+	// The credentials are not stored in plaintext.
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read store: %v", err)
+	}
+
+	if strings.Contains(string(bytes), "abc123") {
+		t.Fatalf("expected the credentials to be encrypted, got %s", bytes)
+	}
+
+	if _, err := OpenStore(path, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected error %v, got %v", ErrWrongPassphrase, err)
+	}
+
+	store, err = OpenStore(path, "passphrase")
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+
+	if names := store.Names(); len(names) != 2 || names[0] != "coinbase" || names[1] != "token" {
+		t.Fatalf("expected credentials [coinbase token], got %v", names)
+	}
+
+	resolver := NewResolver(nil, map[string]Provider{"store": store})
+
+	secret, err := resolver.Resolve(context.Background(), "secret://store/coinbase#secret")
+	if err != nil || secret != "s" {
+		t.Fatalf("expected secret %q, got %q and error %v", "s", secret, err)
+	}
+
+	if err := store.Delete("token"); err != nil {
+		t.Fatalf("failed to delete credential: %v", err)
+	}
+
+	if _, err := store.Secret(context.Background(), "token"); !errors.Is(err, ErrCredentialNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrCredentialNotFound, err)
+	}
+}
+
+func TestKeyringCommand(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		goos     string
+		path     string
+		expected string
+		err      error
+	}{
+		{goos: "darwin", path: "api", expected: "security find-generic-password -s gidari -a api -w"},
+		{goos: "linux", path: "coinbase/key", expected: "secret-tool lookup service coinbase account key"},
+		{goos: "windows", path: "api", err: ErrKeyringNotSupported},
+	} {
+		service, account := keyringItem(tcase.path)
+
+		name, args, err := (&Keyring{goos: tcase.goos}).command("get", service, account)
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("expected error %v on %s, got %v", tcase.err, tcase.goos, err)
+		}
+
+		if err == nil && strings.Join(append([]string{name}, args...), " ") != tcase.expected {
+			t.Fatalf("expected command %q on %s, got %s %v", tcase.expected, tcase.goos, name, args)
+		}
+	}
+}
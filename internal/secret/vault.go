@@ -0,0 +1,90 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Vault fetches secrets from the HTTP API of HashiCorp Vault. The path of a reference is the API path of the secret,
+// e.g. "secret/data/db" for the "db" secret of a KV version 2 engine mounted at "secret". The secret is the JSON object
+// of the secret's data.
+type Vault struct {
+	// Address is the address of the Vault server, e.g. "https://vault.example.com:8200".
+	Address string
+
+	// Token authenticates the requests.
+	Token string
+
+	// Namespace is the namespace of the secrets, for Vault Enterprise.
+	Namespace string
+
+	client *http.Client
+}
+
+// NewVault will return a Vault provider configured by the "VAULT_ADDR", "VAULT_TOKEN", and "VAULT_NAMESPACE"
+// environment variables.
+func NewVault(client *http.Client) *Vault {
+	return &Vault{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    client,
+	}
+}
+
+// Secret will return the data of the secret at the path as a JSON object.
+func (vault *Vault) Secret(ctx context.Context, path string) (string, error) {
+	if vault.Address == "" {
+		return "", ProviderError("vault", "VAULT_ADDR is not set")
+	}
+
+	url := strings.TrimSuffix(vault.Address, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("unable to create vault request: %w", err)
+	}
+
+	req.Header.Set("X-Vault-Token", vault.Token)
+
+	if vault.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", vault.Namespace)
+	}
+
+	body, err := do(vault.client, "vault", req)
+	if err != nil {
+		return "", err
+	}
+
+	var rsp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &rsp); err != nil {
+		return "", ProviderError("vault", err.Error())
+	}
+
+	// KV version 2 engines nest the data of the secret next to its metadata.
+	data, hasData := rsp.Data["data"]
+	if _, hasMetadata := rsp.Data["metadata"]; hasData && hasMetadata {
+		return string(data), nil
+	}
+
+	bytes, err := json.Marshal(rsp.Data)
+	if err != nil {
+		return "", ProviderError("vault", err.Error())
+	}
+
+	return string(bytes), nil
+}
@@ -0,0 +1,902 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// cosmosAPIVersion is the version of the Cosmos DB REST API, which must support transactional batches.
+	cosmosAPIVersion = "2020-07-15"
+
+	// cosmosDefaultPartitionKey is the default partition key path of the containers.
+	cosmosDefaultPartitionKey = "/id"
+
+	// cosmosDefaultPrimaryKey is the default primary key of the records of a table.
+	cosmosDefaultPrimaryKey = "id"
+
+	// cosmosMaxBatchSize is the maximum number of operations in a transactional batch.
+	cosmosMaxBatchSize = 100
+
+	// cosmosDefaultMaxRetries is the default number of times a throttled request is retried.
+	cosmosDefaultMaxRetries = 9
+
+	// cosmosDefaultConcurrency is the default number of batches that are upserted concurrently.
+	cosmosDefaultConcurrency = 4
+
+	// cosmosMaxBackoff is the longest wait before retrying a throttled request without a "x-ms-retry-after-ms".
+	cosmosMaxBackoff = 5 * time.Second
+
+	// cosmosFailedDependency is the status of the operations of a failed batch that did not cause the failure.
+	cosmosFailedDependency = 424
+)
+
+var (
+	ErrCosmos          = fmt.Errorf("cosmos db error")
+	ErrInvalidCosmosDB = fmt.Errorf("invalid cosmos db connection string")
+)
+
+// CosmosError wraps an error of the Cosmos DB REST API with ErrCosmos.
+func CosmosError(status int, message string) error {
+	return fmt.Errorf("%w %d: %s", ErrCosmos, status, message)
+}
+
+// InvalidCosmosDBError wraps an error with ErrInvalidCosmosDB.
+func InvalidCosmosDBError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCosmosDB, reason)
+}
+
+// CosmosDB is a storage device for the SQL API of Azure Cosmos DB. Each table is a container of the database, which
+// is created when it is first written to. Records are upserted in transactional batches of up to 100 records that
+// share a partition key, and requests that are throttled for exceeding the provisioned request units (429s) are
+// retried after the wait that Cosmos DB asks for.
+//
+// The connection string is either "cosmosdb://<account>:<key>@<host>/<database>[?options]", where the key is URL
+// encoded, or an account connection string, i.e. "AccountEndpoint=<uri>;AccountKey=<key>;Database=<database>". The
+// options, which are "Name=value" pairs of the account connection string, are:
+//
+//   - partitionKey: the partition key path of the containers. Defaults to "/id".
+//   - primaryKey: the comma separated fields that identify a record, from which its "id" is derived. Defaults to
+//     "id". Records without them are identified by their content.
+//   - maxRetries: the number of times a throttled request is retried. Defaults to 9.
+//   - concurrency: the number of batches that are upserted concurrently. Defaults to 4.
+//   - tls: "false" to connect over HTTP, e.g. to a local emulator.
+type CosmosDB struct {
+	client       *http.Client
+	endpoint     string
+	key          []byte
+	database     string
+	partitionKey string
+	primaryKeys  []string
+	maxRetries   int
+	concurrency  int
+
+	// collections are the containers that are known to exist.
+	collections sync.Map
+}
+
+// cosmosResponse is a response of the Cosmos DB REST API.
+type cosmosResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// cosmosRequest is a request to the Cosmos DB REST API. The resource type and link are the resource that the request
+// is authorized for, and the path is the path of the request.
+type cosmosRequest struct {
+	method       string
+	resourceType string
+	resourceLink string
+	path         string
+	header       map[string]string
+	body         interface{}
+}
+
+// cosmosBatchResult is the result of an operation of a transactional batch.
+type cosmosBatchResult struct {
+	StatusCode int `json:"statusCode"`
+}
+
+// NewCosmosDB will connect to the Cosmos DB database of the connection string, creating it if it does not exist.
+func NewCosmosDB(ctx context.Context, dns string) (*CosmosDB, error) {
+	stg, err := parseCosmosDB(dns)
+	if err != nil {
+		return nil, err
+	}
+
+	stg.client = http.DefaultClient
+
+	rsp, err := stg.do(ctx, &cosmosRequest{
+		method:       http.MethodGet,
+		resourceType: "dbs",
+		resourceLink: "dbs/" + stg.database,
+		path:         "/dbs/" + stg.database,
+	})
+	if err == nil && rsp.status == http.StatusNotFound {
+		_, err = stg.do(ctx, &cosmosRequest{
+			method:       http.MethodPost,
+			resourceType: "dbs",
+			path:         "/dbs",
+			body:         map[string]string{"id": stg.database},
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database %q: %w", stg.database, err)
+	}
+
+	return stg, nil
+}
+
+// parseCosmosDB will parse a "cosmosdb://" or account connection string.
+func parseCosmosDB(dns string) (*CosmosDB, error) {
+	options := make(map[string]string)
+
+	var scheme, host, key string
+
+	if strings.HasPrefix(dns, "cosmosdb://") {
+		uri, err := url.Parse(dns)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse connection string: %w", err)
+		}
+
+		for option := range uri.Query() {
+			options[strings.ToLower(option)] = uri.Query().Get(option)
+		}
+
+		scheme, host = "https", uri.Host
+		key, _ = uri.User.Password()
+		options["database"] = strings.Trim(uri.Path, "/")
+	} else {
+		for _, pair := range strings.Split(dns, ";") {
+			if name, value, ok := strings.Cut(strings.TrimSpace(pair), "="); ok {
+				options[strings.ToLower(name)] = value
+			}
+		}
+
+		uri, err := url.Parse(options["accountendpoint"])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse account endpoint: %w", err)
+		}
+
+		scheme, host, key = uri.Scheme, uri.Host, options["accountkey"]
+	}
+
+	if options["tls"] == "false" {
+		scheme = "http"
+	}
+
+	stg := &CosmosDB{
+		endpoint:     scheme + "://" + host,
+		database:     options["database"],
+		partitionKey: cosmosDefaultPartitionKey,
+		primaryKeys:  []string{cosmosDefaultPrimaryKey},
+		maxRetries:   cosmosDefaultMaxRetries,
+		concurrency:  cosmosDefaultConcurrency,
+	}
+
+	if host == "" || stg.database == "" || key == "" {
+		return nil, InvalidCosmosDBError("host, key, and database are required")
+	}
+
+	var err error
+	if stg.key, err = base64.StdEncoding.DecodeString(key); err != nil {
+		return nil, InvalidCosmosDBError("key must be base64 encoded")
+	}
+
+	if pk := options["partitionkey"]; pk != "" {
+		stg.partitionKey = "/" + strings.TrimPrefix(pk, "/")
+	}
+
+	if keys := options["primarykey"]; keys != "" {
+		stg.primaryKeys = strings.Split(keys, ",")
+	}
+
+	for option, value := range map[string]*int{"maxretries": &stg.maxRetries, "concurrency": &stg.concurrency} {
+		if options[option] == "" {
+			continue
+		}
+
+		if *value, err = strconv.Atoi(options[option]); err != nil || *value < 0 {
+			return nil, InvalidCosmosDBError(option + " must be a non-negative integer")
+		}
+	}
+
+	if stg.concurrency == 0 {
+		stg.concurrency = 1
+	}
+
+	return stg, nil
+}
+
+// IsNoSQL returns "true" to indicate that "CosmosDB" is a NoSQL storage device.
+func (stg *CosmosDB) IsNoSQL() bool { return true }
+
+// Type implements the storage interface.
+func (stg *CosmosDB) Type() uint8 { return CosmosDBType }
+
+// Close will close the idle connections to Cosmos DB.
+func (stg *CosmosDB) Close() {
+	stg.client.CloseIdleConnections()
+}
+
+// cosmosAuthorization will return the master key authorization of a request.
+func cosmosAuthorization(key []byte, method, resourceType, resourceLink, date string) string {
+	payload := strings.ToLower(method) + "\n" + strings.ToLower(resourceType) + "\n" + resourceLink + "\n" +
+		strings.ToLower(date) + "\n\n"
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+
+	return url.QueryEscape("type=master&ver=1.0&sig=" + base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// cosmosBackoff will return how long to wait before retrying a throttled request: the wait that Cosmos DB asks for,
+// or an exponential backoff if it does not.
+func cosmosBackoff(header http.Header, attempt int) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("x-ms-retry-after-ms"), 64); err == nil {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	backoff := 100 * time.Millisecond << attempt
+	if backoff <= 0 || backoff > cosmosMaxBackoff {
+		return cosmosMaxBackoff
+	}
+
+	return backoff
+}
+
+// do will make a request to the Cosmos DB REST API, retrying it while it is throttled. Responses with a status of 404
+// or 409 are returned to be handled by the caller, other failures are returned as errors.
+func (stg *CosmosDB) do(ctx context.Context, creq *cosmosRequest) (*cosmosResponse, error) {
+	var body []byte
+
+	if creq.body != nil {
+		var err error
+		if body, err = json.Marshal(creq.body); err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, creq.method, stg.endpoint+creq.path, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create request: %w", err)
+		}
+
+		date := time.Now().UTC().Format(http.TimeFormat)
+
+		req.Header.Set("Authorization", cosmosAuthorization(stg.key, creq.method, creq.resourceType,
+			creq.resourceLink, date))
+		req.Header.Set("x-ms-date", date)
+		req.Header.Set("x-ms-version", cosmosAPIVersion)
+		req.Header.Set("Content-Type", "application/json")
+
+		for name, value := range creq.header {
+			req.Header.Set(name, value)
+		}
+
+		httpRsp, err := stg.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("unable to request %q: %w", creq.path, err)
+		}
+
+		rsp := &cosmosResponse{status: httpRsp.StatusCode, header: httpRsp.Header}
+		rsp.body, err = io.ReadAll(httpRsp.Body)
+		httpRsp.Body.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to read response of %q: %w", creq.path, err)
+		}
+
+		switch {
+		case rsp.status == http.StatusTooManyRequests && attempt < stg.maxRetries:
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(cosmosBackoff(rsp.header, attempt)):
+			}
+		case rsp.status < http.StatusMultipleChoices, rsp.status == http.StatusNotFound,
+			rsp.status == http.StatusConflict:
+			return rsp, nil
+		default:
+			var apiErr struct {
+				Message string `json:"message"`
+			}
+
+			if json.Unmarshal(rsp.body, &apiErr) != nil || apiErr.Message == "" {
+				apiErr.Message = http.StatusText(rsp.status)
+			}
+
+			if rejectedStatus(rsp.status) {
+				return nil, RejectedError(CosmosError(rsp.status, apiErr.Message))
+			}
+
+			return nil, CosmosError(rsp.status, apiErr.Message)
+		}
+	}
+}
+
+// collectionLink will return the resource link of the container of a table.
+func (stg *CosmosDB) collectionLink(table string) string {
+	return "dbs/" + stg.database + "/colls/" + table
+}
+
+// ensureCollection will create the container of a table, if it does not exist.
+func (stg *CosmosDB) ensureCollection(ctx context.Context, table string) error {
+	if _, ok := stg.collections.Load(table); ok {
+		return nil
+	}
+
+	rsp, err := stg.do(ctx, &cosmosRequest{
+		method:       http.MethodGet,
+		resourceType: "colls",
+		resourceLink: stg.collectionLink(table),
+		path:         "/" + stg.collectionLink(table),
+	})
+	if err == nil && rsp.status == http.StatusNotFound {
+		_, err = stg.do(ctx, &cosmosRequest{
+			method:       http.MethodPost,
+			resourceType: "colls",
+			resourceLink: "dbs/" + stg.database,
+			path:         "/dbs/" + stg.database + "/colls",
+			body: map[string]interface{}{
+				"id": table,
+				"partitionKey": map[string]interface{}{
+					"paths":   []string{stg.partitionKey},
+					"kind":    "Hash",
+					"version": 2,
+				},
+			},
+		})
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to create container %q: %w", table, err)
+	}
+
+	stg.collections.Store(table, true)
+
+	return nil
+}
+
+// cosmosID will return the "id" of a record: its primary keys joined by "|", or its content if it does not have them.
+// The characters that are not allowed in an "id" are replaced by "_".
+func cosmosID(doc map[string]interface{}, primaryKeys []string) (string, error) {
+	keys := make([]string, 0, len(primaryKeys))
+
+	for _, pk := range primaryKeys {
+		value, ok := doc[pk]
+		if !ok || value == nil {
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return "", fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+			}
+
+			sum := sha1.Sum(data)
+
+			return "sha1:" + hex.EncodeToString(sum[:]), nil
+		}
+
+		if str, ok := value.(string); ok {
+			keys = append(keys, str)
+
+			continue
+		}
+
+		key, err := json.Marshal(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		keys = append(keys, string(key))
+	}
+
+	return strings.NewReplacer("/", "_", "\\", "_", "?", "_", "#", "_").Replace(strings.Join(keys, "|")), nil
+}
+
+// cosmosPartitionKey will return the partition key header of a document, given the path of the partition key.
+// Documents without the partition key are stored in the partition of undefined values.
+func cosmosPartitionKey(doc map[string]interface{}, path string) (string, error) {
+	var value interface{} = doc
+
+	for _, field := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "[{}]", nil
+		}
+
+		if value, ok = obj[field]; !ok {
+			return "[{}]", nil
+		}
+	}
+
+	header, err := json.Marshal([]interface{}{value})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return string(header), nil
+}
+
+// batch will execute a transactional batch of operations on documents that share a partition key, and return the
+// results of the operations.
+func (stg *CosmosDB) batch(ctx context.Context, table, partitionKey string,
+	ops []map[string]interface{},
+) ([]cosmosBatchResult, error) {
+	rsp, err := stg.do(ctx, &cosmosRequest{
+		method:       http.MethodPost,
+		resourceType: "docs",
+		resourceLink: stg.collectionLink(table),
+		path:         "/" + stg.collectionLink(table) + "/docs",
+		header: map[string]string{
+			"x-ms-cosmos-is-batch-request": "True",
+			"x-ms-cosmos-batch-atomic":     "True",
+			"x-ms-documentdb-partitionkey": partitionKey,
+		},
+		body: ops,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.status == http.StatusNotFound {
+		stg.collections.Delete(table)
+
+		return nil, CosmosError(rsp.status, fmt.Sprintf("container %q not found", table))
+	}
+
+	var results []cosmosBatchResult
+	if err := json.Unmarshal(rsp.body, &results); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	return results, nil
+}
+
+// upsertBatch will upsert a transactional batch of documents that share a partition key, and return the number of
+// documents that were inserted and updated.
+func (stg *CosmosDB) upsertBatch(ctx context.Context, table, partitionKey string,
+	docs []map[string]interface{},
+) (int64, int64, error) {
+	ops := make([]map[string]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		ops = append(ops, map[string]interface{}{"operationType": "Upsert", "resourceBody": doc})
+	}
+
+	results, err := stg.batch(ctx, table, partitionKey, ops)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var inserted, updated int64
+
+	for _, result := range results {
+		switch {
+		case result.StatusCode == http.StatusCreated:
+			inserted++
+		case result.StatusCode == http.StatusOK:
+			updated++
+		case rejectedStatus(result.StatusCode):
+			return 0, 0, RejectedError(CosmosError(result.StatusCode, "batch upsert failed"))
+		case result.StatusCode != cosmosFailedDependency:
+			return 0, 0, CosmosError(result.StatusCode, "batch upsert failed")
+		}
+	}
+
+	return inserted, updated, nil
+}
+
+// deleteBatch will delete a transactional batch of documents that share a partition key, and return the number of
+// documents that were deleted. A document that no longer exists fails the batch, which is retried without it.
+func (stg *CosmosDB) deleteBatch(ctx context.Context, table, partitionKey string, ids []string) (int64, error) {
+	for len(ids) > 0 {
+		ops := make([]map[string]interface{}, 0, len(ids))
+		for _, id := range ids {
+			ops = append(ops, map[string]interface{}{"operationType": "Delete", "id": id})
+		}
+
+		results, err := stg.batch(ctx, table, partitionKey, ops)
+		if err != nil {
+			return 0, err
+		}
+
+		var remaining []string
+
+		for idx, result := range results {
+			switch result.StatusCode {
+			case http.StatusNoContent, http.StatusNotFound:
+			case cosmosFailedDependency:
+				remaining = append(remaining, ids[idx])
+			default:
+				return 0, CosmosError(result.StatusCode, "batch delete failed")
+			}
+		}
+
+		if len(remaining) == 0 {
+			var deleted int64
+
+			for _, result := range results {
+				if result.StatusCode == http.StatusNoContent {
+					deleted++
+				}
+			}
+
+			return deleted, nil
+		}
+
+		ids = remaining
+	}
+
+	return 0, nil
+}
+
+// Upsert will upsert the records into the container of the table, in concurrent transactional batches of the records
+// that share a partition key. Each batch is atomic, but a failed upsert may leave other batches upserted.
+func (stg *CosmosDB) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	if len(records) == 0 {
+		return &proto.UpsertResponse{}, nil
+	}
+
+	if err := stg.ensureCollection(ctx, req.GetTable()); err != nil {
+		return nil, err
+	}
+
+	var partitionKeys []string
+
+	partitions := make(map[string][]map[string]interface{})
+
+	for _, record := range records {
+		doc := record.AsMap()
+
+		if doc["id"], err = cosmosID(doc, stg.primaryKeys); err != nil {
+			return nil, err
+		}
+
+		partitionKey, err := cosmosPartitionKey(doc, stg.partitionKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := partitions[partitionKey]; !ok {
+			partitionKeys = append(partitionKeys, partitionKey)
+		}
+
+		partitions[partitionKey] = append(partitions[partitionKey], doc)
+	}
+
+	var mu sync.Mutex
+
+	rsp := new(proto.UpsertResponse)
+
+	errs, ectx := errgroup.WithContext(ctx)
+	errs.SetLimit(stg.concurrency)
+
+	for _, partitionKey := range partitionKeys {
+		docs := partitions[partitionKey]
+
+		for start := 0; start < len(docs); start += cosmosMaxBatchSize {
+			partitionKey := partitionKey
+			batch := docs[start:minInt(start+cosmosMaxBatchSize, len(docs))]
+
+			errs.Go(func() error {
+				inserted, updated, err := stg.upsertBatch(ectx, req.GetTable(), partitionKey, batch)
+				if err != nil {
+					return fmt.Errorf("unable to upsert batch of partition %s: %w", partitionKey, err)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+
+				rsp.UpsertedCount += inserted
+				rsp.InsertedCount += inserted
+				rsp.MatchedCount += updated
+				rsp.UpdatedCount += updated
+
+				return nil
+			})
+		}
+	}
+
+	if err := errs.Wait(); err != nil {
+		return nil, err
+	}
+
+	return rsp, nil
+}
+
+// minInt will return the smaller of two integers.
+func minInt(left, right int) int {
+	if left < right {
+		return left
+	}
+
+	return right
+}
+
+// resourceUsage will return a quota of the "x-ms-resource-usage" header of a container, e.g. "documentsCount".
+func resourceUsage(header http.Header, quota string) int64 {
+	for _, pair := range strings.Split(header.Get("x-ms-resource-usage"), ";") {
+		if name, value, ok := strings.Cut(pair, "="); ok && name == quota {
+			usage, _ := strconv.ParseInt(value, 10, 64)
+
+			return usage
+		}
+	}
+
+	return 0
+}
+
+// collectionUsage will return the usage of the container of a table, or nil if it does not exist.
+func (stg *CosmosDB) collectionUsage(ctx context.Context, table string) (http.Header, error) {
+	rsp, err := stg.do(ctx, &cosmosRequest{
+		method:       http.MethodGet,
+		resourceType: "colls",
+		resourceLink: stg.collectionLink(table),
+		path:         "/" + stg.collectionLink(table),
+		header:       map[string]string{"x-ms-documentdb-populatequotainfo": "true"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get container %q: %w", table, err)
+	}
+
+	if rsp.status == http.StatusNotFound {
+		return nil, nil
+	}
+
+	return rsp.header, nil
+}
+
+// partitionKeyPath will return the partition key path of the containers as a property path of a query, e.g.
+// "c[\"product\"]" for "/product".
+func (stg *CosmosDB) partitionKeyPath() (string, error) {
+	path := "c"
+
+	for _, field := range strings.Split(strings.TrimPrefix(stg.partitionKey, "/"), "/") {
+		quoted, err := json.Marshal(field)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		path += "[" + string(quoted) + "]"
+	}
+
+	return path, nil
+}
+
+// documentKeys will return the ids of the documents of a table by their partition key header, and the partition keys
+// in the order they were read. It returns no documents if the container does not exist.
+func (stg *CosmosDB) documentKeys(ctx context.Context, table string) (map[string][]string, []string, error) {
+	path, err := stg.partitionKeyPath()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := map[string]interface{}{"query": "SELECT c.id, " + path + " AS pk FROM c"}
+
+	var partitionKeys []string
+
+	partitions := make(map[string][]string)
+
+	for continuation := ""; ; {
+		creq := &cosmosRequest{
+			method:       http.MethodPost,
+			resourceType: "docs",
+			resourceLink: stg.collectionLink(table),
+			path:         "/" + stg.collectionLink(table) + "/docs",
+			header: map[string]string{
+				"Content-Type":                               "application/query+json",
+				"x-ms-documentdb-isquery":                    "True",
+				"x-ms-documentdb-query-enablecrosspartition": "True",
+			},
+			body: query,
+		}
+
+		if continuation != "" {
+			creq.header["x-ms-continuation"] = continuation
+		}
+
+		rsp, err := stg.do(ctx, creq)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to query documents of %q: %w", table, err)
+		}
+
+		if rsp.status == http.StatusNotFound {
+			return nil, nil, nil
+		}
+
+		var result struct {
+			Documents []map[string]interface{} `json:"Documents"`
+		}
+
+		if err := json.Unmarshal(rsp.body, &result); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+		}
+
+		for _, doc := range result.Documents {
+			id, _ := doc["id"].(string)
+
+			// Documents without the partition key are in the partition of undefined values.
+			partitionKey, err := cosmosPartitionKey(doc, "/pk")
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if _, ok := partitions[partitionKey]; !ok {
+				partitionKeys = append(partitionKeys, partitionKey)
+			}
+
+			partitions[partitionKey] = append(partitions[partitionKey], id)
+		}
+
+		if continuation = rsp.header.Get("x-ms-continuation"); continuation == "" {
+			return partitions, partitionKeys, nil
+		}
+	}
+}
+
+// Truncate will delete the documents of the tables, in concurrent transactional batches of the documents that share a
+// partition key. The containers are kept, so that their settings, e.g. their indexing policy and throughput, are not
+// lost.
+func (stg *CosmosDB) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
+	rsp := new(proto.TruncateResponse)
+
+	for _, table := range req.GetTables() {
+		partitions, partitionKeys, err := stg.documentKeys(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+
+		var mu sync.Mutex
+
+		errs, ectx := errgroup.WithContext(ctx)
+		errs.SetLimit(stg.concurrency)
+
+		for _, partitionKey := range partitionKeys {
+			ids := partitions[partitionKey]
+
+			for start := 0; start < len(ids); start += cosmosMaxBatchSize {
+				partitionKey, table := partitionKey, table
+				batch := ids[start:minInt(start+cosmosMaxBatchSize, len(ids))]
+
+				errs.Go(func() error {
+					deleted, err := stg.deleteBatch(ectx, table, partitionKey, batch)
+					if err != nil {
+						return fmt.Errorf("unable to delete batch of partition %s: %w", partitionKey, err)
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+
+					rsp.DeletedCount += int32(deleted)
+
+					return nil
+				})
+			}
+		}
+
+		if err := errs.Wait(); err != nil {
+			return nil, err
+		}
+	}
+
+	return rsp, nil
+}
+
+// tables will return the names of the containers of the database.
+func (stg *CosmosDB) tables(ctx context.Context) ([]string, error) {
+	var tables []string
+
+	for continuation := ""; ; {
+		creq := &cosmosRequest{
+			method:       http.MethodGet,
+			resourceType: "colls",
+			resourceLink: "dbs/" + stg.database,
+			path:         "/dbs/" + stg.database + "/colls",
+		}
+
+		if continuation != "" {
+			creq.header = map[string]string{"x-ms-continuation": continuation}
+		}
+
+		rsp, err := stg.do(ctx, creq)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list containers: %w", err)
+		}
+
+		var list struct {
+			DocumentCollections []struct {
+				ID string `json:"id"`
+			} `json:"DocumentCollections"`
+		}
+
+		if err := json.Unmarshal(rsp.body, &list); err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+		}
+
+		for _, coll := range list.DocumentCollections {
+			tables = append(tables, coll.ID)
+		}
+
+		if continuation = rsp.header.Get("x-ms-continuation"); continuation == "" {
+			return tables, nil
+		}
+	}
+}
+
+// ListTables will return the containers of the database and the size of their documents.
+func (stg *CosmosDB) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
+	tables, err := stg.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+
+	for _, table := range tables {
+		usage, err := stg.collectionUsage(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+
+		// The size of the documents is reported in kilobytes.
+		rsp.TableSet[table] = &proto.Table{Size: resourceUsage(usage, "documentsSize") * 1024}
+	}
+
+	return rsp, nil
+}
+
+// ListPrimaryKeys will return the primary keys of the tables, which are the same for every table.
+func (stg *CosmosDB) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResponse, error) {
+	tables, err := stg.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListPrimaryKeysResponse{PKSet: make(map[string]*proto.PrimaryKeys)}
+	for _, table := range tables {
+		rsp.PKSet[table] = &proto.PrimaryKeys{List: stg.primaryKeys}
+	}
+
+	return rsp, nil
+}
+
+// Untransacted will return true, since the batches of a transaction are upserted as they are received.
+func (stg *CosmosDB) Untransacted() bool { return true }
+
+// StartTx will start a transaction. Cosmos DB only has transactions within a partition key, so the batches are
+// upserted as they are received and are not removed by a rollback.
+func (stg *CosmosDB) StartTx(ctx context.Context) (*Txn, error) {
+	return startUntransactedTx(ctx, stg), nil
+}
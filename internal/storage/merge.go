@@ -0,0 +1,460 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// mergeMaxRows is the maximum number of records upserted by a single MERGE statement.
+const mergeMaxRows = 1000
+
+// mergeTxType is a type alias for the context key of the transactions of MERGE storage.
+type mergeTxType uint8
+
+const (
+	basicMergeTxID mergeTxType = iota
+)
+
+var (
+	ErrDriverNotRegistered = fmt.Errorf("sql driver is not registered")
+	ErrNoPrimaryKey        = fmt.Errorf("table has no primary key")
+)
+
+// DriverNotRegisteredError wraps an error with ErrDriverNotRegistered.
+func DriverNotRegisteredError(driver, pkg string) error {
+	return fmt.Errorf("%w: %q, import a driver that registers it, e.g. %q", ErrDriverNotRegistered, driver, pkg)
+}
+
+// mergeDialect is the SQL dialect of a database that records are upserted into with MERGE statements.
+type mergeDialect interface {
+	// driver returns the name of the "database/sql" driver of the database, and the package of a driver that
+	// registers it.
+	driver() (string, string)
+
+	// storageType returns the type of the storage device.
+	storageType() uint8
+
+	// columnsQuery returns the query of the columns of the tables, whose rows are the column, the table, whether the
+	// column is a primary key, and the size of the table in bytes.
+	columnsQuery() string
+
+	// quote returns a quoted identifier.
+	quote(identifier string) string
+
+	// maxParams returns the maximum number of parameters of a statement.
+	maxParams() int
+
+	// mergeStmt returns a MERGE statement of "vol" records of the columns. If "output" is true, the statement returns
+	// a row for each inserted or updated record, where the first column is "INSERT" or "UPDATE". If the request
+	// returns keys, the primary keys of the record follow.
+	mergeStmt(meta *pgmeta, table string, columns []string, vol int, req *proto.UpsertRequest) (stmt string, output bool)
+
+	// isConflict returns true if an error is a write conflict.
+	isConflict(err error) bool
+
+	// isRejected returns true if an error rejects the data of the statement.
+	isRejected(err error) bool
+}
+
+// MergeSQL is a storage device for SQL databases that upsert records with MERGE statements, i.e. SQL Server and
+// Oracle. The tables must exist and have primary keys, which the records are merged on. Existing records are only
+// updated if they change, and are otherwise reported as unchanged.
+//
+// The "database/sql" driver of the database is not built in, so programs that use it must import one, e.g.
+// "github.com/microsoft/go-mssqldb" for SQL Server or "github.com/sijms/go-ora/v2" for Oracle.
+type MergeSQL struct {
+	*sql.DB
+
+	dialect mergeDialect
+
+	// meta hold metdata about the database.
+	meta *pgmeta
+
+	metaMutex  sync.Mutex
+	writeMutex sync.Mutex
+
+	// activeTx are the transactions that are currently active on this connection, by the transaction ID of the
+	// context of a write method.
+	activeTx sync.Map
+}
+
+// newMergeSQL will return a new MergeSQL storage device for a dialect.
+func newMergeSQL(dialect mergeDialect, dns string) (*MergeSQL, error) {
+	driver, pkg := dialect.driver()
+
+	registered := false
+
+	for _, name := range sql.Drivers() {
+		registered = registered || name == driver
+	}
+
+	if !registered {
+		return nil, DriverNotRegisteredError(driver, pkg)
+	}
+
+	db, err := sql.Open(driver, dns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to %s: %w", Scheme(dialect.storageType()), err)
+	}
+
+	return &MergeSQL{DB: db, dialect: dialect, meta: new(pgmeta)}, nil
+}
+
+// IsNoSQL returns "false" to indicate that "MergeSQL" is not a NoSQL database.
+func (ms *MergeSQL) IsNoSQL() bool { return false }
+
+// Versioned returns "true" to indicate that "MergeSQL" only overwrites stored records with newer versions.
+func (ms *MergeSQL) Versioned() bool { return true }
+
+// Type implements the storage interface.
+func (ms *MergeSQL) Type() uint8 { return ms.dialect.storageType() }
+
+// Close will close the underlying database.
+func (ms *MergeSQL) Close() {
+	if ms.DB != nil {
+		ms.DB.Close()
+	}
+}
+
+// loadMeta will load the metadata of the tables of the database.
+func (ms *MergeSQL) loadMeta(ctx context.Context) error {
+	ms.metaMutex.Lock()
+	defer ms.metaMutex.Unlock()
+
+	rows, err := ms.DB.QueryContext(ctx, ms.dialect.columnsQuery())
+	if err != nil {
+		return fmt.Errorf("unable to query: %w", err)
+	}
+	defer rows.Close()
+
+	ms.meta.cols = make(map[string][]string)
+	ms.meta.pks = make(map[string][]string)
+	ms.meta.bytes = make(map[string]int64)
+
+	for rows.Next() {
+		var (
+			table      string
+			column     string
+			primaryKey bool
+			bytes      int64
+		)
+
+		if err := rows.Scan(&column, &table, &primaryKey, &bytes); err != nil {
+			return fmt.Errorf("unable to scan row: %w", err)
+		}
+
+		if primaryKey {
+			ms.meta.pks[table] = append(ms.meta.pks[table], column)
+		}
+
+		ms.meta.cols[table] = append(ms.meta.cols[table], column)
+		ms.meta.bytes[table] = bytes
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("unable to iterate rows: %w", err)
+	}
+
+	return nil
+}
+
+// ListPrimaryKeys will list the primary keys of the tables of the database.
+func (ms *MergeSQL) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResponse, error) {
+	if err := ms.loadMeta(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load metadata: %w", err)
+	}
+
+	rsp := &proto.ListPrimaryKeysResponse{PKSet: make(map[string]*proto.PrimaryKeys)}
+	for table, pks := range ms.meta.pks {
+		rsp.PKSet[table] = &proto.PrimaryKeys{List: pks}
+	}
+
+	return rsp, nil
+}
+
+// ReadSchema will return the columns and primary keys of the table of an upsert request, or nil if the table does not
+// exist.
+func (ms *MergeSQL) ReadSchema(ctx context.Context, req *proto.UpsertRequest) (*TableSchema, error) {
+	if err := ms.loadMeta(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load metadata: %w", err)
+	}
+
+	return ms.meta.schema(req.GetTable()), nil
+}
+
+// ListTables will list the tables of the database and their size.
+func (ms *MergeSQL) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
+	if err := ms.loadMeta(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load metadata: %w", err)
+	}
+
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+	for table := range ms.meta.cols {
+		rsp.TableSet[table] = &proto.Table{Size: ms.meta.bytes[table]}
+	}
+
+	return rsp, nil
+}
+
+// Truncate will truncate the tables.
+func (ms *MergeSQL) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
+	for _, table := range req.GetTables() {
+		if _, err := ms.DB.ExecContext(ctx, "TRUNCATE TABLE "+ms.dialect.quote(table)); err != nil {
+			return nil, fmt.Errorf("unable to truncate table %q: %w", table, err)
+		}
+	}
+
+	return &proto.TruncateResponse{}, nil
+}
+
+// getPrepareContextFn will return the function that prepares statements, within the transaction of the context if
+// it has one.
+func (ms *MergeSQL) getPrepareContextFn(ctx context.Context) (sqlPrepareContextFn, error) {
+	txID, ok := ctx.Value(basicMergeTxID).(string)
+	if ok {
+		if tx, ok := ms.activeTx.Load(txID); ok {
+			tx, ok := tx.(*sql.Tx)
+			if !ok {
+				return nil, ErrTransactionNotFound
+			}
+
+			return tx.PrepareContext, nil
+		}
+	}
+
+	return ms.DB.PrepareContext, nil
+}
+
+// Upsert will merge the records into the table on its primary keys.
+func (ms *MergeSQL) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	ms.writeMutex.Lock()
+	defer ms.writeMutex.Unlock()
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	if len(records) == 0 {
+		return &proto.UpsertResponse{}, nil
+	}
+
+	prepareContextFn, err := ms.getPrepareContextFn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	if err := ms.loadMeta(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load metadata: %w", err)
+	}
+
+	table := req.GetTable()
+	if len(ms.meta.pks[table]) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoPrimaryKey, table)
+	}
+
+	if versionColumn := req.GetVersionField(); versionColumn != "" && !ms.meta.isColumn(table, versionColumn) {
+		return nil, fmt.Errorf("%w: %s.%s", ErrInvalidVersionField, table, versionColumn)
+	}
+
+	rsp := new(proto.UpsertResponse)
+
+	var merged int64
+
+	for _, group := range ms.meta.groupRecords(table, records, req.GetMissingFields()) {
+		vol := ms.dialect.maxParams() / len(group.columns)
+		if vol > mergeMaxRows {
+			vol = mergeMaxRows
+		}
+
+		for _, partition := range tools.PartitionStructs(vol, group.records) {
+			changed, err := ms.merge(ctx, prepareContextFn, table, group.columns, partition, req, rsp)
+			if err != nil {
+				return nil, err
+			}
+
+			merged += changed
+			rsp.UnchangedCount += int64(len(partition)) - changed
+		}
+	}
+
+	// Without an output of the merged records, only the number of records that were inserted or updated is known.
+	rsp.UpsertedCount = rsp.InsertedCount
+	if rsp.InsertedCount+rsp.UpdatedCount == 0 {
+		rsp.UpsertedCount = merged
+	}
+
+	rsp.MatchedCount = rsp.UpdatedCount + rsp.UnchangedCount
+
+	return rsp, nil
+}
+
+// merge will merge a partition of records into the columns of a table, tallying the output of the statement on the
+// response. It returns the number of records that were inserted or updated.
+func (ms *MergeSQL) merge(ctx context.Context, pcf sqlPrepareContextFn, table string, columns []string,
+	partition []*structpb.Struct, req *proto.UpsertRequest, rsp *proto.UpsertResponse,
+) (int64, error) {
+	query, output := ms.dialect.mergeStmt(ms.meta, table, columns, len(partition), req)
+
+	stmt, err := pcf(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	arguments := tools.SQLFlattenPartition(columns, partition)
+
+	if !output {
+		result, err := stmt.ExecContext(ctx, arguments...)
+		if err != nil {
+			return 0, ms.mergeError(err)
+		}
+
+		changed, err := result.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("unable to get merged count: %w", err)
+		}
+
+		return changed, nil
+	}
+
+	rows, err := stmt.QueryContext(ctx, arguments...)
+	if err != nil {
+		return 0, ms.mergeError(err)
+	}
+	defer rows.Close()
+
+	var changed int64
+
+	pks := ms.meta.pks[table]
+
+	for rows.Next() {
+		var action string
+
+		dest := []interface{}{&action}
+
+		keys := make([]interface{}, len(pks))
+		if req.GetReturnKeys() {
+			for idx := range keys {
+				dest = append(dest, &keys[idx])
+			}
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return 0, fmt.Errorf("unable to scan merged record: %w", err)
+		}
+
+		changed++
+
+		if strings.EqualFold(action, "INSERT") {
+			rsp.InsertedCount++
+		} else {
+			rsp.UpdatedCount++
+		}
+
+		if req.GetReturnKeys() {
+			key, err := pgRecordKey(pks, keys)
+			if err != nil {
+				return 0, err
+			}
+
+			rsp.AffectedKeys = append(rsp.AffectedKeys, key)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("unable to iterate merged records: %w", err)
+	}
+
+	return changed, nil
+}
+
+// mergeError will wrap the error of a MERGE statement, as a conflict or a rejection if it is one.
+func (ms *MergeSQL) mergeError(err error) error {
+	if ms.dialect.isConflict(err) {
+		return fmt.Errorf("unable to execute merge: %w", ConflictError(err))
+	}
+
+	if ms.dialect.isRejected(err) {
+		return fmt.Errorf("unable to execute merge: %w", RejectedError(err))
+	}
+
+	return fmt.Errorf("unable to execute merge: %w", err)
+}
+
+// mergeNonKeys will return the columns that are not primary keys of a table.
+func mergeNonKeys(meta *pgmeta, table string, columns []string) []string {
+	var nonKeys []string
+
+	for _, column := range columns {
+		if !meta.isPK(table, column) {
+			nonKeys = append(nonKeys, column)
+		}
+	}
+
+	return nonKeys
+}
+
+// mergeJoin will quote the columns, format each with the format, and join them with the separator. The format's
+// "%[1]s" is the quoted column.
+func mergeJoin(dialect mergeDialect, format, sep string, columns []string) string {
+	parts := make([]string, len(columns))
+	for idx, column := range columns {
+		parts[idx] = fmt.Sprintf(format, dialect.quote(column))
+	}
+
+	return strings.Join(parts, sep)
+}
+
+// StartTx will start a transaction on the connection. The write methods that are sent to the transaction run within
+// it.
+func (ms *MergeSQL) StartTx(ctx context.Context) (*Txn, error) {
+	txn := newTxn()
+
+	txnID := uuid.New().String()
+
+	sqltx, err := ms.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return txn, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	ms.activeTx.Store(txnID, sqltx)
+
+	txCtx := context.WithValue(ctx, basicMergeTxID, txnID)
+
+	go func() {
+		defer ms.activeTx.Delete(txnID)
+
+		err = txn.receive(func(fn TxnChanFn) error { return fn(txCtx, ms) })
+
+		if err != nil {
+			sqltx.Rollback()
+			txn.done <- err
+
+			return
+		}
+
+		if <-txn.commit {
+			txn.done <- sqltx.Commit()
+		} else {
+			txn.done <- sqltx.Rollback()
+		}
+	}()
+
+	return txn, nil
+}
@@ -11,6 +11,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
@@ -21,13 +23,23 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
-	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
 	mdbLifetime              = 60 * time.Second
 	mdbTransactionRetryLimit = 3
 	mdbWriteConflicErrCode   = 112
+
+	// mdbDocumentValidationErrCode is the code of a document that fails the validation of its collection.
+	mdbDocumentValidationErrCode = 121
+
+	// mdbTopologyTimeout is the time allowed to detect whether a deployment is a standalone server.
+	mdbTopologyTimeout = 5 * time.Second
+
+	// mdbCountPartitionSize is the number of records whose documents are counted or read per query.
+	mdbCountPartitionSize = 1000
 )
 
 // Mongo is a wrapper for *mongo.Client, use to perform CRUD operations on a mongo DB instance.
@@ -36,6 +48,17 @@ type Mongo struct {
 	dns        string
 	lifetime   time.Duration
 	writeMutex sync.Mutex
+
+	// geoIndexes are the geo fields of each collection that are known to have a "2dsphere" index.
+	geoIndexes sync.Map
+
+	// selectedDatabase is the database of the requests without a database, if it is not that of the connection
+	// string.
+	selectedDatabase string
+
+	// topologyOnce detects whether the deployment is a standalone server, which does not support transactions.
+	topologyOnce sync.Once
+	standalone   bool
 }
 
 // NewMongo will return a new mongo client that can be used to perform CRUD operations on a mongo DB instance. This
@@ -61,6 +84,9 @@ func NewMongo(ctx context.Context, uri string) (*Mongo, error) {
 // IsNoSQL returns "true" indicating that the "MongoDB" database is NoSQL.
 func (m *Mongo) IsNoSQL() bool { return true }
 
+// Versioned returns "true" to indicate that "MongoDB" only overwrites stored records with newer versions.
+func (m *Mongo) Versioned() bool { return true }
+
 // Type returns the type of storage.
 func (m *Mongo) Type() uint8 {
 	return MongoType
@@ -84,55 +110,62 @@ func (m *Mongo) commitTransactionWithRetry(ctx mongo.SessionContext, retryCount
 			// Check if the server error is a "WriteConflict", if so then retry the transaction.
 			return m.commitTransactionWithRetry(ctx, retryCount+1)
 		}
+
+		return fmt.Errorf("commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-// ReceiveWrites will listen for writes to the transaction and commit them to the database every time the lifetime
-// limit is reached, or when the transaction is committed through the commit channel.
-func (m *Mongo) receiveWrites(sctx mongo.SessionContext, txn *Txn) *errgroup.Group {
+// splitTransaction will commit the transaction on the context and start a new one, to stay within the lifetime limit.
+func (m *Mongo) splitTransaction(sctx mongo.SessionContext) error {
+	if err := m.commitTransactionWithRetry(sctx, 0); err != nil {
+		return err
+	}
+
+	if err := sctx.StartTransaction(); err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	return nil
+}
+
+// receiveWrites will run the batches of writes sent to the transaction until its channel is closed, and split the
+// transaction every time the lifetime limit is reached. The ticker is serviced by the same loop that runs the writes,
+// so a split always falls between batches and never in the middle of an operation, and it happens on time even when
+// no writes arrive. Once a write fails the transaction is no longer split, so that the writes since the last split
+// are aborted together.
+func (m *Mongo) receiveWrites(sctx mongo.SessionContext, txn *Txn) error {
 	lifetimeTicker := time.NewTicker(m.lifetime)
-	errs, _ := errgroup.WithContext(context.Background())
-
-	errs.Go(func() error {
-		var err error
-
-		// Receive write requests.
-		for opr := range txn.ch {
-			select {
-			case <-lifetimeTicker.C:
-				// If the transaction has exceeded the lifetime, commit the transaction and start a new
-				// one.
-				if err := m.commitTransactionWithRetry(sctx, 0); err != nil {
-					panic(fmt.Errorf("commit transaction: %w", err))
-				}
+	defer lifetimeTicker.Stop()
 
-				// Start a new transaction on the context.
-				if err := sctx.StartTransaction(); err != nil {
-					panic(fmt.Errorf("error starting transaction: %w", err))
-				}
-			default:
+	var err error
+
+	for {
+		select {
+		case batch, ok := <-txn.ch:
+			if !ok {
+				return err
+			}
+
+			for _, opr := range batch {
 				if err != nil {
-					continue
+					break
 				}
 
 				err = opr(sctx, m)
 			}
+		case <-lifetimeTicker.C:
+			if err == nil {
+				err = m.splitTransaction(sctx)
+			}
 		}
-
-		if err != nil {
-			return fmt.Errorf("error in transaction: %w", err)
-		}
-
-		return nil
-	})
-
-	return errs
+	}
 }
 
 // startSession will create a session and listen for writes, committing and reseting the transaction every 60 seconds
-// to avoid lifetime limit errors.
+// to avoid lifetime limit errors. Once the writes are done, the transaction is committed or aborted by the decision
+// sent to the commit channel, which "Commit" and "Rollback" send right after closing the transaction channel.
 func (m *Mongo) startSession(ctx context.Context, txn *Txn) {
 	txn.done <- m.Client.UseSession(ctx, func(sctx mongo.SessionContext) error {
 		// Start the transaction, if there is an error break the go routine.
@@ -141,48 +174,112 @@ func (m *Mongo) startSession(ctx context.Context, txn *Txn) {
 			return fmt.Errorf("error starting transaction: %w", err)
 		}
 
-		errs := m.receiveWrites(sctx, txn)
-		err = errs.Wait()
+		err = m.receiveWrites(sctx, txn)
+
+		// Await the decision to commit or rollback. A failed transaction is aborted regardless.
+		commit := <-txn.commit
+
 		if err != nil {
+			// The error of the writes takes precedence over that of the abort, and the session aborts the
+			// transaction when it ends if the abort fails.
+			_ = sctx.AbortTransaction(sctx)
+
 			return fmt.Errorf("error in transaction: %w", err)
 		}
 
-		// Await the decision to commit or rollback.
-		switch {
-		case <-txn.commit:
-			if err := m.commitTransactionWithRetry(sctx, 0); err != nil {
-				return fmt.Errorf("commit transaction: %w", err)
-			}
-		default:
-			if err := sctx.AbortTransaction(sctx); err != nil {
-				return ErrTransactionAborted
-			}
+		if commit {
+			return m.commitTransactionWithRetry(sctx, 0)
+		}
+
+		if err := sctx.AbortTransaction(sctx); err != nil {
+			return ErrTransactionAborted
 		}
 
 		return nil
 	})
 }
 
+// mongoHello is the part of the "isMaster" response that identifies the topology of a deployment.
+type mongoHello struct {
+	SetName string `bson:"setName"`
+	Msg     string `bson:"msg"`
+}
+
+// detectStandalone will detect whether the deployment is a standalone server, i.e. neither a replica set member nor a
+// sharded cluster router. If the topology can not be detected, the deployment is assumed to support transactions.
+func (m *Mongo) detectStandalone(ctx context.Context) bool {
+	m.topologyOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(ctx, mdbTopologyTimeout)
+		defer cancel()
+
+		var hello mongoHello
+
+		err := m.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+		m.standalone = err == nil && isMongoStandalone(hello)
+	})
+
+	return m.standalone
+}
+
+func isMongoStandalone(hello mongoHello) bool {
+	return hello.SetName == "" && hello.Msg != "isdbgrid"
+}
+
+// Untransacted will return true if the deployment is a standalone server, on which the writes of a transaction run as
+// they are received. It is only known once a transaction was started.
+func (m *Mongo) Untransacted() bool {
+	return m.standalone
+}
+
 // StartTx will start a mongodb session where all data from write methods can be rolled back.
 //
 // MongoDB best practice is to "abort any multi-document transactions that runs for more than 60 seconds". The resulting
 // error for exceeding this time constraint is "TransactionExceededLifetimeLimitSeconds". To maintain agnostism at the
 // repository layer, we implement the logic to handle these transactions errors in the storage layer. Therefore, every
 // 60 seconds, the transacting data will be committed commit the transaction and start a new one.
+//
+// Transactions require a replica set or a sharded cluster. On a standalone server, e.g. a single-node development
+// deployment, the writes sent to the transaction run as they are received, in the batches of the transaction channel,
+// and a rollback does not remove them.
 func (m *Mongo) StartTx(ctx context.Context) (*Txn, error) {
-	// Construct a transaction.
-	txn := &Txn{
-		make(chan TxnChanFn),
-		make(chan error, 1),
-		make(chan bool, 1),
+	if m.detectStandalone(ctx) {
+		return startUntransactedTx(ctx, m), nil
 	}
 
+	// Construct a transaction.
+	txn := newTxn()
+
 	// Create a go routine that creates a session and listens for writes.
 	go m.startSession(ctx, txn)
 
 	return txn, nil
 }
 
+// SelectDatabase will select the database that requests without a database use, instead of the database of the
+// connection string.
+func (m *Mongo) SelectDatabase(name string) {
+	m.selectedDatabase = name
+}
+
+// database will return the database of a request: its override, the selected database, or the database of the
+// connection string.
+func (m *Mongo) database(override string) (string, error) {
+	if override != "" {
+		return override, nil
+	}
+
+	if m.selectedDatabase != "" {
+		return m.selectedDatabase, nil
+	}
+
+	connString, err := connstring.ParseAndValidate(m.dns)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse connstring: %w", err)
+	}
+
+	return connString.Database, nil
+}
+
 // Truncate will delete all records in a collection.
 func (m *Mongo) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
 	// If there are no collections to truncate, return.
@@ -190,13 +287,13 @@ func (m *Mongo) Truncate(ctx context.Context, req *proto.TruncateRequest) (*prot
 		return &proto.TruncateResponse{}, nil
 	}
 
-	connString, err := connstring.ParseAndValidate(m.dns)
+	database, err := m.database(req.GetDatabase())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse connstring: %w", err)
+		return nil, err
 	}
 
 	for _, collection := range req.GetTables() {
-		coll := m.Client.Database(connString.Database).Collection(collection)
+		coll := m.Client.Database(database).Collection(collection)
 
 		_, err = coll.DeleteMany(ctx, bson.M{})
 		if err != nil {
@@ -207,6 +304,451 @@ func (m *Mongo) Truncate(ctx context.Context, req *proto.TruncateRequest) (*prot
 	return &proto.TruncateResponse{}, nil
 }
 
+// TruncateRange will delete the documents of a collection whose time field is within the time window. The field can
+// be a date or an RFC 3339 string in UTC, which is how timestamps decoded from JSON are stored.
+func (m *Mongo) TruncateRange(ctx context.Context, req *TruncateRangeRequest) (*proto.TruncateResponse, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	database, err := m.database(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := mongoRangeFilter(req.Column, req.Start, req.End)
+
+	rsp, err := m.Client.Database(database).Collection(req.Table).DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("error truncating range of collection %s: %w", req.Table, err)
+	}
+
+	return &proto.TruncateResponse{DeletedCount: int32(rsp.DeletedCount)}, nil
+}
+
+// mongoRangeFilter will return the filter of the documents whose time field is within the time window, as a date or
+// an RFC 3339 string in UTC.
+func mongoRangeFilter(field string, start, end time.Time) bson.M {
+	start, end = start.UTC(), end.UTC()
+
+	// MongoDB only compares values of the same type, so each clause only matches fields of its type.
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{"$gte": start, "$lt": end}},
+		bson.M{field: bson.M{"$gte": start.Format(time.RFC3339Nano), "$lt": end.Format(time.RFC3339Nano)}},
+	}}
+}
+
+// Buckets will return the indexes of the time buckets of a collection that hold documents, whose time field is within
+// the time window. The field can be a date or an RFC 3339 string in UTC.
+func (m *Mongo) Buckets(ctx context.Context, req *BucketsRequest) ([]int64, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	database, err := m.database(req.Database)
+	if err != nil {
+		return nil, err
+	}
+
+	// Subtracting dates returns milliseconds.
+	elapsed := bson.M{"$subtract": bson.A{bson.M{"$toDate": "$" + req.Column}, req.Start.UTC()}}
+	pipeline := bson.A{
+		bson.M{"$match": mongoRangeFilter(req.Column, req.Start, req.End)},
+		bson.M{"$group": bson.M{"_id": bson.M{"$floor": bson.M{"$divide": bson.A{elapsed, req.Granularity.Milliseconds()}}}}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	}
+
+	cursor, err := m.Client.Database(database).Collection(req.Table).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("error listing buckets of collection %s: %w", req.Table, err)
+	}
+	defer cursor.Close(ctx)
+
+	var buckets []int64
+
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("error decoding bucket of collection %s: %w", req.Table, err)
+		}
+
+		bucket, err := mongoNumber(doc[0].Value)
+		if err != nil {
+			return nil, err
+		}
+
+		buckets = append(buckets, int64(bucket))
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error listing buckets of collection %s: %w", req.Table, err)
+	}
+
+	return buckets, nil
+}
+
+// mongoNumber will convert a numeric BSON value to a float.
+func mongoNumber(value interface{}) (float64, error) {
+	switch val := value.(type) {
+	case int32:
+		return float64(val), nil
+	case int64:
+		return float64(val), nil
+	case float64:
+		return val, nil
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("%w: %T is not a number", ErrInvalidMeasurement, value)
+	}
+}
+
+// mongoPipeline will return the aggregation pipeline that measures a collection. A query is an aggregation pipeline
+// as extended JSON, e.g. `[{"$match": {"price": {"$lte": 0}}}, {"$count": "n"}]`.
+func mongoPipeline(req *MeasureRequest) (bson.A, error) {
+	switch {
+	case req.Query != "":
+		var wrapper struct {
+			Pipeline bson.A `bson:"pipeline"`
+		}
+
+		if err := bson.UnmarshalExtJSON([]byte(`{"pipeline":`+req.Query+`}`), false, &wrapper); err != nil {
+			return nil, fmt.Errorf("%w: query is not an aggregation pipeline: %v", ErrInvalidMeasurement, err)
+		}
+
+		return wrapper.Pipeline, nil
+	case req.NullField != "":
+		// Missing fields are replaced with null, so they are counted as null.
+		isNull := bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$" + req.NullField, nil}}, nil}}
+
+		return bson.A{
+			bson.M{"$group": bson.M{
+				"_id":   nil,
+				"nulls": bson.M{"$sum": bson.M{"$cond": bson.A{isNull, 1, 0}}},
+				"total": bson.M{"$sum": 1},
+			}},
+			bson.M{"$project": bson.M{"_id": 0, "rate": bson.M{"$divide": bson.A{"$nulls", "$total"}}}},
+		}, nil
+	case len(req.UniqueFields) > 0:
+		key := bson.M{}
+		for _, field := range req.UniqueFields {
+			key[field] = "$" + field
+		}
+
+		return bson.A{
+			bson.M{"$group": bson.M{"_id": key, "count": bson.M{"$sum": 1}}},
+			bson.M{"$match": bson.M{"count": bson.M{"$gt": 1}}},
+			bson.M{"$count": "duplicates"},
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: no measurement for %q", ErrInvalidMeasurement, req.Table)
+	}
+}
+
+// Measure will return a measurement of a collection: the first field of the first document returned by an aggregation
+// pipeline, the fraction of documents whose field is null or missing, or the number of combinations of values of the
+// unique fields that occur more than once. Within a session, the uncommitted documents are measured. A pipeline that
+// returns no documents measures zero.
+func (m *Mongo) Measure(ctx context.Context, req *MeasureRequest) (float64, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	pipeline, err := mongoPipeline(req)
+	if err != nil {
+		return 0, err
+	}
+
+	database, err := m.database("")
+	if err != nil {
+		return 0, err
+	}
+
+	cursor, err := m.Client.Database(database).Collection(req.Table).Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, fmt.Errorf("error measuring collection %s: %w", req.Table, err)
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		if err := cursor.Err(); err != nil {
+			return 0, fmt.Errorf("error measuring collection %s: %w", req.Table, err)
+		}
+
+		return 0, nil
+	}
+
+	var doc bson.D
+	if err := cursor.Decode(&doc); err != nil {
+		return 0, fmt.Errorf("error decoding measurement of collection %s: %w", req.Table, err)
+	}
+
+	if len(doc) == 0 {
+		return 0, nil
+	}
+
+	return mongoNumber(doc[0].Value)
+}
+
+// mongoUpsertFilters will return the distinct filters that match the stored documents of the records of an upsert
+// request: their "_id" if they have one, or else the entire document, as the upsert matches them.
+func mongoUpsertFilters(req *proto.UpsertRequest) ([]bson.D, error) {
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode records: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	filters := make([]bson.D, 0, len(records))
+
+	for _, record := range records {
+		doc := bson.D{}
+		if err := tools.AssingRecordBSONDocument(record, &doc); err != nil {
+			return nil, fmt.Errorf("failed to assign record to bson document: %w", err)
+		}
+
+		if err := mongoDecimalFields(doc, req.GetDecimalFields()); err != nil {
+			return nil, err
+		}
+
+		if err := mongoNanoTimestampFields(doc, req.GetNanoTimestampFields()); err != nil {
+			return nil, err
+		}
+
+		filter := doc
+
+		for _, elem := range doc {
+			if elem.Key == "_id" {
+				filter = bson.D{elem}
+
+				break
+			}
+		}
+
+		key, err := bson.MarshalExtJSON(filter, true, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode filter: %w", err)
+		}
+
+		if !seen[string(key)] {
+			seen[string(key)] = true
+
+			filters = append(filters, filter)
+		}
+	}
+
+	return filters, nil
+}
+
+// CountUpserted will count the distinct records of an upsert request and the number of them that are present in the
+// collection. Within a session, the uncommitted documents are counted.
+func (m *Mongo) CountUpserted(ctx context.Context, req *proto.UpsertRequest) (*UpsertedCount, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	filters, err := mongoUpsertFilters(req)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := m.database(req.GetDatabase())
+	if err != nil {
+		return nil, err
+	}
+
+	coll := m.Client.Database(database).Collection(req.Table)
+	count := &UpsertedCount{Records: int64(len(filters))}
+
+	for start := 0; start < len(filters); start += mdbCountPartitionSize {
+		end := start + mdbCountPartitionSize
+		if end > len(filters) {
+			end = len(filters)
+		}
+
+		or := make(bson.A, 0, end-start)
+		for _, filter := range filters[start:end] {
+			or = append(or, filter)
+		}
+
+		opts := options.Count()
+		if collation := mongoCollation(req); collation != nil {
+			opts.SetCollation(collation)
+		}
+
+		present, err := coll.CountDocuments(ctx, bson.D{{Key: "$or", Value: or}}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("error counting collection %s: %w", req.Table, err)
+		}
+
+		count.Present += present
+	}
+
+	// Documents without an "_id" can be stored more than once, so they are not counted beyond the records.
+	if count.Present > count.Records {
+		count.Present = count.Records
+	}
+
+	return count, nil
+}
+
+// ReadTable will return every document of a collection as a record, identified by its "_id". Values that JSON can not
+// represent are read as relaxed extended JSON, e.g. an ObjectID as {"$oid": ...}.
+func (m *Mongo) ReadTable(ctx context.Context, req *proto.ReadRequest) (*StoredRecords, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	database, err := m.database("")
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := mongoFindRecords(ctx, m.Client.Database(database).Collection(req.GetTable()), bson.D{}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredRecords{PrimaryKeys: []string{"_id"}, Records: records}, nil
+}
+
+// CountTable will return the number of documents of a collection.
+func (m *Mongo) CountTable(ctx context.Context, table string) (int64, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	database, err := m.database("")
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := m.Client.Database(database).Collection(table).CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return 0, fmt.Errorf("error counting collection %s: %w", table, err)
+	}
+
+	return count, nil
+}
+
+// ReadPage will return the documents of a collection whose "_id" follows the "_id" of the last record of the previous
+// page, in the order of their "_id". The "_id" of the last record is read as relaxed extended JSON, as the records
+// are, so that e.g. an ObjectID is compared as an ObjectID. Documents are compared within the BSON type of the "_id"
+// of the last record, so the "_id" values of a paged collection must have one type.
+func (m *Mongo) ReadPage(ctx context.Context, req *ReadPageRequest) (*StoredRecords, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	database, err := m.database("")
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.D{}
+
+	if req.After != nil {
+		data, err := protojson.Marshal(&structpb.Struct{Fields: map[string]*structpb.Value{
+			"_id": req.After.GetFields()["_id"],
+		}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the _id of the previous page: %w", err)
+		}
+
+		var after struct {
+			ID interface{} `bson:"_id"`
+		}
+
+		if err := bson.UnmarshalExtJSON(data, false, &after); err != nil {
+			return nil, fmt.Errorf("failed to decode the _id of the previous page: %w", err)
+		}
+
+		filter = bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: after.ID}}}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(req.Limit))
+
+	records, err := mongoFindRecords(ctx, m.Client.Database(database).Collection(req.Table), filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredRecords{PrimaryKeys: []string{"_id"}, Records: records}, nil
+}
+
+// ReadUpserted will return the documents of a collection that match the records of an upsert request, by their "_id"
+// if they have one, or else by the entire document, as the upsert matches them. Within a session, the uncommitted
+// documents are read.
+func (m *Mongo) ReadUpserted(ctx context.Context, req *proto.UpsertRequest) (*StoredRecords, error) {
+	m.writeMutex.Lock()
+	defer m.writeMutex.Unlock()
+
+	filters, err := mongoUpsertFilters(req)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := m.database(req.GetDatabase())
+	if err != nil {
+		return nil, err
+	}
+
+	coll := m.Client.Database(database).Collection(req.Table)
+	stored := &StoredRecords{PrimaryKeys: []string{"_id"}}
+
+	for start := 0; start < len(filters); start += mdbCountPartitionSize {
+		end := start + mdbCountPartitionSize
+		if end > len(filters) {
+			end = len(filters)
+		}
+
+		or := make(bson.A, 0, end-start)
+		for _, filter := range filters[start:end] {
+			or = append(or, filter)
+		}
+
+		opts := options.Find()
+		if collation := mongoCollation(req); collation != nil {
+			opts.SetCollation(collation)
+		}
+
+		records, err := mongoFindRecords(ctx, coll, bson.D{{Key: "$or", Value: or}}, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		stored.Records = append(stored.Records, records...)
+	}
+
+	return stored, nil
+}
+
+// mongoFindRecords will return the documents of a collection that match a filter as records. Values that JSON can not
+// represent are read as relaxed extended JSON, e.g. an ObjectID as {"$oid": ...}.
+func mongoFindRecords(ctx context.Context, coll *mongo.Collection, filter bson.D, opts *options.FindOptions,
+) ([]*structpb.Struct, error) {
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("error reading collection %s: %w", coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	var records []*structpb.Struct
+
+	for cursor.Next(ctx) {
+		data, err := bson.MarshalExtJSON(cursor.Current, false, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode document: %w", err)
+		}
+
+		record := new(structpb.Struct)
+		if err := protojson.Unmarshal(data, record); err != nil {
+			return nil, fmt.Errorf("failed to decode document: %w", err)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("error reading collection %s: %w", coll.Name(), err)
+	}
+
+	return records, nil
+}
+
 // Upsert will insert or update a record in a collection.
 func (m *Mongo) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
 	m.writeMutex.Lock()
@@ -223,6 +765,7 @@ func (m *Mongo) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.Up
 	}
 
 	models := []mongo.WriteModel{}
+	collation := mongoCollation(req)
 
 	for _, record := range records {
 		doc := bson.D{}
@@ -230,24 +773,275 @@ func (m *Mongo) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.Up
 			return nil, fmt.Errorf("failed to assign record to bson document: %w", err)
 		}
 
-		models = append(models, mongo.NewUpdateOneModel().SetFilter(doc).
-			SetUpdate(bson.D{primitive.E{Key: "$set", Value: doc}}).
-			SetUpsert(true))
+		if err := mongoDecimalFields(doc, req.GetDecimalFields()); err != nil {
+			return nil, err
+		}
+
+		if err := mongoNanoTimestampFields(doc, req.GetNanoTimestampFields()); err != nil {
+			return nil, err
+		}
+
+		models = append(models, mongoCollate(mongoUpsertModel(doc, req.GetVersionField(), req.GetMissingFields()),
+			collation))
 	}
 
-	cs, err := connstring.ParseAndValidate(m.dns)
+	database, err := m.database(req.GetDatabase())
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+		return nil, err
 	}
 
-	coll := m.Client.Database(cs.Database).Collection(req.Table)
+	coll := m.Client.Database(database).Collection(req.Table)
+
+	if err := m.ensureGeoIndexes(coll, req.GetGeoFields()); err != nil {
+		return nil, err
+	}
 
 	bwr, err := coll.BulkWrite(ctx, models)
 	if err != nil {
+		var mdbErr mongo.ServerError
+		if mongo.IsDuplicateKeyError(err) ||
+			(errors.As(err, &mdbErr) && mdbErr.HasErrorCode(mdbWriteConflicErrCode)) {
+			return nil, fmt.Errorf("bulk write error: %w", ConflictError(err))
+		}
+
+		if errors.As(err, &mdbErr) && mdbErr.HasErrorCode(mdbDocumentValidationErrCode) {
+			return nil, fmt.Errorf("bulk write error: %w", RejectedError(err))
+		}
+
 		return nil, fmt.Errorf("bulk write error: %w", err)
 	}
 
-	return &proto.UpsertResponse{MatchedCount: bwr.MatchedCount, UpsertedCount: bwr.UpsertedCount}, nil
+	// Matched records are only modified if they have a newer version, the others are left unchanged.
+	rsp := &proto.UpsertResponse{
+		MatchedCount:   bwr.MatchedCount,
+		UpsertedCount:  bwr.UpsertedCount,
+		InsertedCount:  bwr.UpsertedCount,
+		UpdatedCount:   bwr.ModifiedCount,
+		UnchangedCount: bwr.MatchedCount - bwr.ModifiedCount,
+	}
+
+	if req.GetReturnKeys() {
+		if rsp.AffectedKeys, err = mongoUpsertedKeys(bwr.UpsertedIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return rsp, nil
+}
+
+// ensureGeoIndexes will create a "2dsphere" index on each geo field of a collection, so that GeoJSON geometries can be
+// queried geospatially. Indexes can not be created within a transaction, so they are created outside of the upsert's
+// transaction, once per connection.
+func (m *Mongo) ensureGeoIndexes(coll *mongo.Collection, fields []string) error {
+	for _, field := range fields {
+		key := coll.Database().Name() + "." + coll.Name() + "." + field
+		if _, ok := m.geoIndexes.Load(key); ok {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.lifetime)
+
+		_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: field, Value: "2dsphere"}}})
+
+		cancel()
+
+		if err != nil {
+			return fmt.Errorf("unable to create 2dsphere index on %q: %w", key, err)
+		}
+
+		m.geoIndexes.Store(key, true)
+	}
+
+	return nil
+}
+
+// mongoDecimalFields will convert the decimal fields of a document to "Decimal128" values, so that they are stored
+// exactly rather than as doubles.
+func mongoDecimalFields(doc bson.D, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	decimal := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		decimal[field] = true
+	}
+
+	for idx, elem := range doc {
+		if !decimal[elem.Key] {
+			continue
+		}
+
+		var str string
+
+		switch value := elem.Value.(type) {
+		case nil:
+			continue
+		case string:
+			str = value
+		case float64:
+			str = strconv.FormatFloat(value, 'g', -1, 64)
+		default:
+			return fmt.Errorf("%w for %q: %v", tools.ErrInvalidDecimal, elem.Key, value)
+		}
+
+		dec, err := primitive.ParseDecimal128(str)
+		if err != nil {
+			return fmt.Errorf("%w for %q: %v", tools.ErrInvalidDecimal, elem.Key, err)
+		}
+
+		doc[idx].Value = dec
+	}
+
+	return nil
+}
+
+// mongoNanoTimestampFields will convert the nanosecond timestamp fields of a document to "int64" values, since the
+// dates of MongoDB keep milliseconds.
+func mongoNanoTimestampFields(doc bson.D, fields []string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	nano := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		nano[field] = true
+	}
+
+	for idx, elem := range doc {
+		if !nano[elem.Key] {
+			continue
+		}
+
+		str, ok := elem.Value.(string)
+		if !ok {
+			if elem.Value == nil {
+				continue
+			}
+
+			return fmt.Errorf("%w for %q: %v", tools.ErrInvalidTimestamp, elem.Key, elem.Value)
+		}
+
+		nanos, err := tools.ParseNanoTimestamp(str)
+		if err != nil {
+			return fmt.Errorf("%w for %q", err, elem.Key)
+		}
+
+		doc[idx].Value = nanos
+	}
+
+	return nil
+}
+
+// mongoUpsertModel will return the write model that upserts a document. Documents are matched on every field, unless
+// a version field is given and the document has an "_id" and a version. Then the document is matched on its "_id" and
+// only replaces a stored document with an older version.
+//
+// If a missing fields policy is given, documents with an "_id" are matched on it. Fields that are missing from the
+// document are then either left untouched ("keep") or removed from the stored document ("null").
+func mongoUpsertModel(doc bson.D, versionField, missingFields string) mongo.WriteModel {
+	var id, version interface{}
+
+	for _, elem := range doc {
+		switch elem.Key {
+		case "_id":
+			id = elem.Value
+		case versionField:
+			version = elem.Value
+		}
+	}
+
+	if versionField == "" || id == nil || version == nil {
+		var filter interface{} = doc
+		if missingFields != "" && id != nil {
+			filter = bson.D{{Key: "_id", Value: id}}
+		}
+
+		if missingFields == MissingFieldsNull {
+			return mongo.NewReplaceOneModel().SetFilter(filter).SetReplacement(doc).SetUpsert(true)
+		}
+
+		return mongo.NewUpdateOneModel().SetFilter(filter).
+			SetUpdate(bson.D{primitive.E{Key: "$set", Value: doc}}).
+			SetUpsert(true)
+	}
+
+	// A newer document replaces the stored document, unless missing fields are kept. Then it is merged into it.
+	var upserted interface{} = bson.D{{Key: "$literal", Value: doc}}
+	if missingFields == MissingFieldsKeep {
+		upserted = bson.D{{Key: "$mergeObjects", Value: bson.A{"$$ROOT", upserted}}}
+	}
+
+	// A missing or null stored version sorts before any other value, so new documents are always inserted.
+	newer := bson.D{{Key: "$lt", Value: bson.A{"$" + versionField, bson.D{{Key: "$literal", Value: version}}}}}
+	replacement := bson.D{{Key: "$cond", Value: bson.A{newer, upserted, "$$ROOT"}}}
+
+	return mongo.NewUpdateOneModel().SetFilter(bson.D{{Key: "_id", Value: id}}).
+		SetUpdate(mongo.Pipeline{{{Key: "$replaceWith", Value: replacement}}}).
+		SetUpsert(true)
+}
+
+// mongoCollation will return the collation that the records of an upsert request are matched with, or nil if they are
+// matched by binary comparison.
+func mongoCollation(req *proto.UpsertRequest) *options.Collation {
+	if req.GetCollationLocale() == "" {
+		return nil
+	}
+
+	return &options.Collation{Locale: req.GetCollationLocale(), Strength: int(req.GetCollationStrength())}
+}
+
+// mongoCollate will set the collation that the filter of an upsert model matches documents with. Queries with a
+// collation other than that of the collection can not use its indexes, so the collection should be created with the
+// same collation.
+func mongoCollate(model mongo.WriteModel, collation *options.Collation) mongo.WriteModel {
+	if collation == nil {
+		return model
+	}
+
+	switch model := model.(type) {
+	case *mongo.UpdateOneModel:
+		return model.SetCollation(collation)
+	case *mongo.ReplaceOneModel:
+		return model.SetCollation(collation)
+	}
+
+	return model
+}
+
+// mongoUpsertedKeys will return the "_id" keys of the upserted documents, in the order of the upserted records.
+// MongoDB does not report which matched documents were modified, so only the keys of upserted documents are known.
+func mongoUpsertedKeys(upsertedIDs map[int64]interface{}) ([]*structpb.Struct, error) {
+	indexes := make([]int64, 0, len(upsertedIDs))
+	for idx := range upsertedIDs {
+		indexes = append(indexes, idx)
+	}
+
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	keys := make([]*structpb.Struct, 0, len(indexes))
+
+	for _, idx := range indexes {
+		var id interface{}
+
+		switch value := upsertedIDs[idx].(type) {
+		case primitive.ObjectID:
+			id = value.Hex()
+		case string, bool, int32, int64, float64:
+			id = value
+		default:
+			id = fmt.Sprint(value)
+		}
+
+		key, err := structpb.NewStruct(map[string]interface{}{"_id": id})
+		if err != nil {
+			return nil, fmt.Errorf("unable to build record key: %w", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
 }
 
 // ListPrimaryKeys will return a "proto.ListPrimaryKeysResponse" containing a list of primary keys data for all tables
@@ -274,12 +1068,12 @@ func (m *Mongo) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResp
 
 // ListTables will return a list of all tables in the MongoDB database.
 func (m *Mongo) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
-	connString, err := connstring.ParseAndValidate(m.dns)
+	database, err := m.database("")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+		return nil, err
 	}
 
-	collections, err := m.Client.Database(connString.Database).ListCollectionNames(ctx, bson.D{})
+	collections, err := m.Client.Database(database).ListCollectionNames(ctx, bson.D{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list collections: %w", err)
 	}
@@ -288,7 +1082,7 @@ func (m *Mongo) ListTables(ctx context.Context) (*proto.ListTablesResponse, erro
 
 	for _, collection := range collections {
 		// Need to get the size of the collection
-		result, err := m.Client.Database(connString.Database).RunCommand(ctx, bson.D{
+		result, err := m.Client.Database(database).RunCommand(ctx, bson.D{
 			primitive.E{Key: "collStats", Value: collection},
 		}).DecodeBytes()
 		if err != nil {
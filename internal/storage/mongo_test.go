@@ -10,13 +10,16 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alpine-hodler/gidari/proto"
 	"github.com/alpine-hodler/gidari/tools"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/x/bsonx"
 )
 
@@ -92,3 +95,137 @@ func TestMongoDBTxn(t *testing.T) {
 		}
 	})
 }
+
+// splitSession is a session context that counts the transactions that it commits and starts.
+type splitSession struct {
+	context.Context
+	mongo.Session
+
+	commits, starts int32
+}
+
+func (s *splitSession) CommitTransaction(context.Context) error {
+	atomic.AddInt32(&s.commits, 1)
+
+	return nil
+}
+
+func (s *splitSession) StartTransaction(...*options.TransactionOptions) error {
+	atomic.AddInt32(&s.starts, 1)
+
+	return nil
+}
+
+func TestMongoReceiveWrites(t *testing.T) {
+	t.Parallel()
+
+	t.Run("the lifetime splits the transaction without writes", func(t *testing.T) {
+		t.Parallel()
+
+		mdb := &Mongo{lifetime: 10 * time.Millisecond}
+		sctx := &splitSession{Context: context.Background()}
+		txn := newTxn()
+
+		errs := make(chan error, 1)
+		go func() { errs <- mdb.receiveWrites(sctx, txn) }()
+
+		time.Sleep(100 * time.Millisecond)
+
+		txn.Send(func(context.Context, Storage) error { return nil })
+		txn.Flush()
+		close(txn.ch)
+
+		if err := <-errs; err != nil {
+			t.Fatalf("error receiving writes: %v", err)
+		}
+
+		commits, starts := atomic.LoadInt32(&sctx.commits), atomic.LoadInt32(&sctx.starts)
+		if commits == 0 || commits != starts {
+			t.Fatalf("expected every split to commit and start a transaction, got %d commits and %d starts",
+				commits, starts)
+		}
+	})
+
+	t.Run("a failed transaction is not split", func(t *testing.T) {
+		t.Parallel()
+
+		mdb := &Mongo{lifetime: 10 * time.Millisecond}
+		sctx := &splitSession{Context: context.Background()}
+		txn := newTxn()
+
+		errs := make(chan error, 1)
+		go func() { errs <- mdb.receiveWrites(sctx, txn) }()
+
+		errFailed := fmt.Errorf("failed")
+
+		txn.Send(func(context.Context, Storage) error { return errFailed })
+		txn.Flush()
+
+		time.Sleep(20 * time.Millisecond)
+
+		commits := atomic.LoadInt32(&sctx.commits)
+
+		time.Sleep(50 * time.Millisecond)
+		close(txn.ch)
+
+		if err := <-errs; !errors.Is(err, errFailed) {
+			t.Fatalf("expected the error of the failed write, got %v", err)
+		}
+
+		if after := atomic.LoadInt32(&sctx.commits); after != commits {
+			t.Fatalf("expected no splits after the failure, got %d more commits", after-commits)
+		}
+	})
+}
+
+func TestIsMongoStandalone(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name       string
+		hello      mongoHello
+		standalone bool
+	}{
+		{name: "standalone", hello: mongoHello{}, standalone: true},
+		{name: "replica set", hello: mongoHello{SetName: "rs0"}, standalone: false},
+		{name: "sharded cluster", hello: mongoHello{Msg: "isdbgrid"}, standalone: false},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if standalone := isMongoStandalone(tcase.hello); standalone != tcase.standalone {
+				t.Fatalf("expected standalone %t, got %t", tcase.standalone, standalone)
+			}
+		})
+	}
+}
+
+func TestMongoDatabase(t *testing.T) {
+	t.Parallel()
+
+	mdb := &Mongo{dns: "mongodb://localhost:27017/coinbase"}
+
+	for _, tcase := range []struct {
+		name     string
+		selected string
+		override string
+		expected string
+	}{
+		{name: "connection string", expected: "coinbase"},
+		{name: "selected", selected: "archive", expected: "archive"},
+		{name: "request override", selected: "archive", override: "staging", expected: "staging"},
+	} {
+		mdb.SelectDatabase(tcase.selected)
+
+		database, err := mdb.database(tcase.override)
+		if err != nil {
+			t.Fatalf("%s: error getting database: %v", tcase.name, err)
+		}
+
+		if database != tcase.expected {
+			t.Fatalf("%s: expected database %q, got %q", tcase.name, tcase.expected, database)
+		}
+	}
+}
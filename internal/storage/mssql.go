@@ -0,0 +1,123 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+// mssqlMaxParams is the maximum number of parameters of a SQL Server statement, which is 2100 less a margin.
+const mssqlMaxParams = 2000
+
+// mssqlConflictNumbers are the SQL Server error numbers that indicate a write conflict.
+var mssqlConflictNumbers = map[int32]bool{
+	1205: true, // deadlock victim
+	2601: true, // duplicate key in unique index
+	2627: true, // unique constraint violation
+}
+
+// mssqlRejectedNumbers are the SQL Server error numbers that indicate data that the table rejects.
+var mssqlRejectedNumbers = map[int32]bool{
+	207:  true, // invalid column name
+	208:  true, // invalid object name
+	245:  true, // conversion failed
+	515:  true, // cannot insert null
+	547:  true, // constraint conflict
+	2628: true, // string or binary data would be truncated
+	8152: true, // string or binary data would be truncated
+}
+
+// mssqlDialect is the dialect of SQL Server.
+type mssqlDialect struct{}
+
+// NewMSSQL will return a new storage device for a SQL Server database, given a "sqlserver://" connection string. The
+// program must import a driver that registers "sqlserver", e.g. "github.com/microsoft/go-mssqldb".
+func NewMSSQL(_ context.Context, dns string) (*MergeSQL, error) {
+	return newMergeSQL(mssqlDialect{}, dns)
+}
+
+func (mssqlDialect) driver() (string, string) { return "sqlserver", "github.com/microsoft/go-mssqldb" }
+
+func (mssqlDialect) storageType() uint8 { return MSSQLType }
+
+func (mssqlDialect) columnsQuery() string { return string(mssqlColumns) }
+
+func (mssqlDialect) maxParams() int { return mssqlMaxParams }
+
+func (mssqlDialect) quote(identifier string) string {
+	return "[" + strings.ReplaceAll(identifier, "]", "]]") + "]"
+}
+
+// isConflict will return true if the error has the number of a write conflict.
+func (mssqlDialect) isConflict(err error) bool {
+	var numbered interface{ SQLErrorNumber() int32 }
+
+	return errors.As(err, &numbered) && mssqlConflictNumbers[numbered.SQLErrorNumber()]
+}
+
+// isRejected will return true if the error has the number of rejected data.
+func (mssqlDialect) isRejected(err error) bool {
+	var numbered interface{ SQLErrorNumber() int32 }
+
+	return errors.As(err, &numbered) && mssqlRejectedNumbers[numbered.SQLErrorNumber()]
+}
+
+// mergeStmt will return a MERGE statement that outputs the action of every inserted or updated record. Matched
+// records are only updated if any of their columns change, compared with "EXCEPT" so that NULLs are equal.
+func (dialect mssqlDialect) mergeStmt(meta *pgmeta, table string, columns []string, vol int,
+	req *proto.UpsertRequest,
+) (string, bool) {
+	rows := make([]string, vol)
+	for row := range rows {
+		values := make([]string, len(columns))
+		for idx := range columns {
+			values[idx] = fmt.Sprintf("@p%d", row*len(columns)+idx+1)
+		}
+
+		rows[row] = "(" + strings.Join(values, ",") + ")"
+	}
+
+	var stmt strings.Builder
+
+	fmt.Fprintf(&stmt, "MERGE INTO %s WITH (HOLDLOCK) AS target USING (VALUES %s) AS source (%s) ON %s",
+		dialect.quote(table),
+		strings.Join(rows, ","),
+		mergeJoin(dialect, "%s", ",", columns),
+		mergeJoin(dialect, "target.%[1]s = source.%[1]s", " AND ", meta.pks[table]))
+
+	if nonKeys := mergeNonKeys(meta, table, columns); len(nonKeys) > 0 {
+		condition := fmt.Sprintf("EXISTS (SELECT %s EXCEPT SELECT %s)",
+			mergeJoin(dialect, "source.%s", ",", nonKeys),
+			mergeJoin(dialect, "target.%s", ",", nonKeys))
+
+		if version := req.GetVersionField(); version != "" {
+			condition += fmt.Sprintf(" AND (target.%[1]s IS NULL OR source.%[1]s > target.%[1]s)",
+				dialect.quote(version))
+		}
+
+		fmt.Fprintf(&stmt, " WHEN MATCHED AND %s THEN UPDATE SET %s", condition,
+			mergeJoin(dialect, "target.%[1]s = source.%[1]s", ",", nonKeys))
+	}
+
+	fmt.Fprintf(&stmt, " WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s) OUTPUT $action",
+		mergeJoin(dialect, "%s", ",", columns),
+		mergeJoin(dialect, "source.%s", ",", columns))
+
+	if req.GetReturnKeys() {
+		stmt.WriteString("," + mergeJoin(dialect, "inserted.%s", ",", meta.pks[table]))
+	}
+
+	stmt.WriteString(";")
+
+	return stmt.String(), true
+}
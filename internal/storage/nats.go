@@ -0,0 +1,404 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/nats"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// natsDefaultPrefix is the default prefix of the subjects and streams of the tables.
+	natsDefaultPrefix = "gidari"
+
+	// natsDefaultPrimaryKey is the default primary key of the records of a table.
+	natsDefaultPrimaryKey = "id"
+
+	// natsStreamNotFoundCode is the JetStream API error code of a stream that does not exist.
+	natsStreamNotFoundCode = 10059
+
+	// natsMsgIDHeader is the header of the ID that JetStream uses to deduplicate messages.
+	natsMsgIDHeader = "Nats-Msg-Id"
+)
+
+var ErrJetStream = fmt.Errorf("jetstream error")
+
+// JetStreamError wraps an error of the JetStream API with ErrJetStream.
+func JetStreamError(code int, description string) error {
+	return fmt.Errorf("%w %d: %s", ErrJetStream, code, description)
+}
+
+// natsAPIError is the error of a JetStream API response.
+type natsAPIError struct {
+	Code        int    `json:"code"`
+	ErrCode     int    `json:"err_code"`
+	Description string `json:"description"`
+}
+
+// natsAPIResponse is the subset of the JetStream API responses that is needed to store records.
+type natsAPIResponse struct {
+	Error *natsAPIError `json:"error"`
+
+	// Duplicate is true if a published message was deduplicated.
+	Duplicate bool `json:"duplicate"`
+
+	// Purged is the number of messages deleted by a purge.
+	Purged int64 `json:"purged"`
+
+	// Streams are the names of the streams, and Total and Limit paginate them.
+	Streams []string `json:"streams"`
+	Total   int      `json:"total"`
+	Limit   int      `json:"limit"`
+
+	// State is the state of a stream.
+	State struct {
+		Bytes int64 `json:"bytes"`
+	} `json:"state"`
+}
+
+// natsStreamConfig is the configuration of a stream created for a table.
+type natsStreamConfig struct {
+	Name            string   `json:"name"`
+	Subjects        []string `json:"subjects"`
+	Storage         string   `json:"storage"`
+	DuplicateWindow int64    `json:"duplicate_window,omitempty"`
+}
+
+// NATS is a storage device that publishes records to NATS JetStream, for event-driven architectures that consume the
+// data. Each table is a stream, e.g. "gidari_candles", that captures the table's subject, e.g. "gidari.candles".
+// Records are published as JSON messages with an "Nats-Msg-Id" derived from their primary keys and content, so that
+// JetStream drops the unchanged records that are published again within the stream's duplicate window, while changed
+// records are published.
+//
+// The connection string is "nats://[user:pass@]host[:port][/prefix][?primaryKey=id&duplicateWindow=2m]". The prefix
+// defaults to "gidari" and the primary key to "id"; records without their primary keys are identified by their
+// content. The streams are created when they are first written to.
+type NATS struct {
+	conn            *nats.Conn
+	prefix          string
+	primaryKeys     []string
+	duplicateWindow time.Duration
+
+	// streams are the streams that are known to exist.
+	streams sync.Map
+}
+
+// NewNATS will connect to the NATS server of the connection string.
+func NewNATS(ctx context.Context, dns string) (*NATS, error) {
+	uri, err := url.Parse(dns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse connection string: %w", err)
+	}
+
+	stg := &NATS{
+		prefix:      strings.Trim(uri.Path, "/"),
+		primaryKeys: []string{natsDefaultPrimaryKey},
+	}
+
+	if stg.prefix == "" {
+		stg.prefix = natsDefaultPrefix
+	}
+
+	query := uri.Query()
+
+	if keys := query.Get("primaryKey"); keys != "" {
+		stg.primaryKeys = strings.Split(keys, ",")
+	}
+
+	if window := query.Get("duplicateWindow"); window != "" {
+		if stg.duplicateWindow, err = time.ParseDuration(window); err != nil {
+			return nil, fmt.Errorf("unable to parse duplicate window: %w", err)
+		}
+	}
+
+	uri.Path, uri.RawQuery = "", ""
+
+	if stg.conn, err = nats.Dial(ctx, uri.String()); err != nil {
+		return nil, fmt.Errorf("unable to connect to nats: %w", err)
+	}
+
+	return stg, nil
+}
+
+// IsNoSQL returns "true" to indicate that "NATS" is a NoSQL storage device.
+func (stg *NATS) IsNoSQL() bool { return true }
+
+// Type implements the storage interface.
+func (stg *NATS) Type() uint8 { return NATSType }
+
+// Close will close the connection to the NATS server.
+func (stg *NATS) Close() {
+	stg.conn.Close()
+}
+
+// subject will return the subject of a table.
+func (stg *NATS) subject(table string) string {
+	return stg.prefix + "." + table
+}
+
+// stream will return the name of the stream of a table. Stream names can not contain ".", "*", ">", or whitespace.
+func (stg *NATS) stream(table string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '*' || r == '>' || r == ' ' || r == '\t' {
+			return '_'
+		}
+
+		return r
+	}, stg.prefix+"_"+table)
+}
+
+// request will make a request to the JetStream API, returning an error if the API responds with one.
+func (stg *NATS) request(ctx context.Context, subject string, body interface{}) (*natsAPIResponse, error) {
+	data := []byte("{}")
+
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+	}
+
+	rspData, err := stg.conn.Request(ctx, &nats.Msg{Subject: subject, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("unable to request %q: %w", subject, err)
+	}
+
+	return decodeNATSResponse(rspData)
+}
+
+// decodeNATSResponse will decode a JetStream API response, returning an error if the API responds with one.
+func decodeNATSResponse(data []byte) (*natsAPIResponse, error) {
+	rsp := new(natsAPIResponse)
+	if err := json.Unmarshal(data, rsp); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	if rsp.Error != nil && rejectedStatus(rsp.Error.Code) {
+		return rsp, RejectedError(JetStreamError(rsp.Error.ErrCode, rsp.Error.Description))
+	}
+
+	if rsp.Error != nil {
+		return rsp, JetStreamError(rsp.Error.ErrCode, rsp.Error.Description)
+	}
+
+	return rsp, nil
+}
+
+// isStreamNotFound will return true if the JetStream API responded that a stream does not exist.
+func isStreamNotFound(rsp *natsAPIResponse) bool {
+	return rsp != nil && rsp.Error != nil && rsp.Error.ErrCode == natsStreamNotFoundCode
+}
+
+// ensureStream will create the stream of a table, if it does not exist.
+func (stg *NATS) ensureStream(ctx context.Context, table string) error {
+	stream := stg.stream(table)
+	if _, ok := stg.streams.Load(stream); ok {
+		return nil
+	}
+
+	rsp, err := stg.request(ctx, "$JS.API.STREAM.INFO."+stream, nil)
+	if isStreamNotFound(rsp) {
+		_, err = stg.request(ctx, "$JS.API.STREAM.CREATE."+stream, &natsStreamConfig{
+			Name:            stream,
+			Subjects:        []string{stg.subject(table)},
+			Storage:         "file",
+			DuplicateWindow: stg.duplicateWindow.Nanoseconds(),
+		})
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to create stream %q: %w", stream, err)
+	}
+
+	stg.streams.Store(stream, true)
+
+	return nil
+}
+
+// natsMsgID will return the ID that a record is deduplicated by: its primary keys and a hash of its content, or only
+// the hash if it does not have them. A changed record has a new ID, so that JetStream does not drop it as a duplicate
+// of the previous version of the record.
+func natsMsgID(record *structpb.Struct, primaryKeys []string, data []byte) (string, error) {
+	sum := sha1.Sum(data)
+	hash := "sha1:" + hex.EncodeToString(sum[:])
+
+	id, err := tools.CompositeKey{Fields: primaryKeys}.StructKey(record)
+	if errors.Is(err, tools.ErrIncompleteKey) {
+		return hash, nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return id + ":" + hash, nil
+}
+
+// Upsert will publish the records to the stream of the table. Records that were already published within the
+// stream's duplicate window are dropped by JetStream and reported as matched.
+func (stg *NATS) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	if len(records) == 0 {
+		return &proto.UpsertResponse{}, nil
+	}
+
+	if err := stg.ensureStream(ctx, req.GetTable()); err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*nats.Msg, 0, len(records))
+
+	for _, record := range records {
+		data, err := json.Marshal(record.AsMap())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		id, err := natsMsgID(record, stg.primaryKeys, data)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, &nats.Msg{
+			Subject: stg.subject(req.GetTable()),
+			Header:  map[string]string{natsMsgIDHeader: id},
+			Data:    data,
+		})
+	}
+
+	acks, err := stg.conn.Requests(ctx, msgs)
+	if err != nil {
+		return nil, fmt.Errorf("unable to publish records: %w", err)
+	}
+
+	rsp := new(proto.UpsertResponse)
+
+	for _, ack := range acks {
+		pubAck, err := decodeNATSResponse(ack)
+		if err != nil {
+			return nil, fmt.Errorf("unable to publish record: %w", err)
+		}
+
+		if pubAck.Duplicate {
+			rsp.MatchedCount++
+		} else {
+			rsp.UpsertedCount++
+		}
+	}
+
+	rsp.InsertedCount = rsp.UpsertedCount
+	rsp.UnchangedCount = rsp.MatchedCount
+
+	return rsp, nil
+}
+
+// Truncate will purge the messages of the tables' streams.
+func (stg *NATS) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
+	rsp := new(proto.TruncateResponse)
+
+	for _, table := range req.GetTables() {
+		purge, err := stg.request(ctx, "$JS.API.STREAM.PURGE."+stg.stream(table), nil)
+		if isStreamNotFound(purge) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to purge stream of table %q: %w", table, err)
+		}
+
+		rsp.DeletedCount += int32(purge.Purged)
+	}
+
+	return rsp, nil
+}
+
+// tables will return the tables of the streams with the storage's prefix.
+func (stg *NATS) tables(ctx context.Context) ([]string, error) {
+	var tables []string
+
+	for offset := 0; ; {
+		rsp, err := stg.request(ctx, "$JS.API.STREAM.NAMES", map[string]int{"offset": offset})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list streams: %w", err)
+		}
+
+		for _, stream := range rsp.Streams {
+			if table := strings.TrimPrefix(stream, stg.prefix+"_"); table != stream {
+				tables = append(tables, table)
+			}
+		}
+
+		offset += len(rsp.Streams)
+		if len(rsp.Streams) == 0 || offset >= rsp.Total {
+			return tables, nil
+		}
+	}
+}
+
+// ListTables will return the tables of the streams with the storage's prefix, and the size of their messages.
+func (stg *NATS) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
+	tables, err := stg.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+
+	for _, table := range tables {
+		info, err := stg.request(ctx, "$JS.API.STREAM.INFO."+stg.stream(table), nil)
+		if err != nil && !errors.Is(err, ErrJetStream) {
+			return nil, fmt.Errorf("unable to get stream of table %q: %w", table, err)
+		}
+
+		rsp.TableSet[table] = &proto.Table{Size: info.State.Bytes}
+	}
+
+	return rsp, nil
+}
+
+// ListPrimaryKeys will return the primary keys of the tables, which are the same for every table.
+func (stg *NATS) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResponse, error) {
+	tables, err := stg.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListPrimaryKeysResponse{PKSet: make(map[string]*proto.PrimaryKeys)}
+	for _, table := range tables {
+		rsp.PKSet[table] = &proto.PrimaryKeys{List: stg.primaryKeys}
+	}
+
+	return rsp, nil
+}
+
+// Untransacted will return true, since the records of a transaction are published as they are received.
+func (stg *NATS) Untransacted() bool { return true }
+
+// StartTx will start a transaction. JetStream has no transactions, so the records are published as they are upserted
+// and are not removed by a rollback. Since the records are deduplicated, a failed run can be safely repeated within
+// the streams' duplicate window.
+func (stg *NATS) StartTx(ctx context.Context) (*Txn, error) {
+	return startUntransactedTx(ctx, stg), nil
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// fakeJetStream is a NATS server with a minimal JetStream API, which deduplicates messages by their "Nats-Msg-Id".
+type fakeJetStream struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	streams map[string]bool
+	msgIDs  map[string]bool
+}
+
+func newFakeJetStream(t *testing.T) *fakeJetStream {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+
+	srv := &fakeJetStream{listener: listener, streams: make(map[string]bool), msgIDs: make(map[string]bool)}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go srv.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() { listener.Close() })
+
+	return srv
+}
+
+// respond will return the response of the JetStream API to a message.
+func (srv *fakeJetStream) respond(subject string, header []byte) string {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	switch {
+	case strings.HasPrefix(subject, "$JS.API.STREAM.INFO."):
+		if !srv.streams[strings.TrimPrefix(subject, "$JS.API.STREAM.INFO.")] {
+			return `{"error":{"code":404,"err_code":10059,"description":"stream not found"}}`
+		}
+
+		return `{"state":{"bytes":10}}`
+	case strings.HasPrefix(subject, "$JS.API.STREAM.CREATE."):
+		srv.streams[strings.TrimPrefix(subject, "$JS.API.STREAM.CREATE.")] = true
+
+		return `{}`
+	case subject == "$JS.API.STREAM.NAMES":
+		names := make([]string, 0, len(srv.streams))
+		for name := range srv.streams {
+			names = append(names, strconv.Quote(name))
+		}
+
+		return fmt.Sprintf(`{"streams":[%s],"total":%d}`, strings.Join(names, ","), len(names))
+	default:
+		id := string(header)
+		duplicate := srv.msgIDs[id]
+		srv.msgIDs[id] = true
+
+		return fmt.Sprintf(`{"stream":"gidari_candles","seq":1,"duplicate":%t}`, duplicate)
+	}
+}
+
+func (srv *fakeJetStream) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	fmt.Fprint(conn, "INFO {\"headers\":true}\r\n")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+
+		switch fields[0] {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB", "HPUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+
+			var header []byte
+			if fields[0] == "HPUB" {
+				headerLen, _ := strconv.Atoi(fields[3])
+				header = payload[:headerLen]
+			}
+
+			rsp := srv.respond(fields[1], header)
+			fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(rsp), rsp)
+		}
+	}
+}
+
+func TestNATS(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := newFakeJetStream(t)
+
+	stg, err := NewNATS(ctx, "nats://"+srv.listener.Addr().String()+"/gidari?primaryKey=product,time")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer stg.Close()
+
+	upsert := func(data string) *proto.UpsertResponse {
+		rsp, err := stg.Upsert(ctx, &proto.UpsertRequest{
+			Table:    "candles",
+			Data:     []byte(data),
+			DataType: int32(tools.UpsertDataJSON),
+		})
+		if err != nil {
+			t.Fatalf("failed to upsert: %v", err)
+		}
+
+		return rsp
+	}
+
+	rsp := upsert(`[{"product":"BTC-USD","time":1,"close":1},{"product":"BTC-USD","time":2,"close":2}]`)
+	if rsp.UpsertedCount != 2 || rsp.MatchedCount != 0 {
+		t.Fatalf("expected 2 upserted records, got %v", rsp)
+	}
+
+	// Unchanged records are deduplicated by their primary keys and content, records without keys by their content.
+	// The changed record is published again.
+	rsp = upsert(`[{"product":"BTC-USD","time":1,"close":1},{"product":"BTC-USD","time":2,"close":3},` +
+		`{"close":4},{"close":4}]`)
+	if rsp.UpsertedCount != 2 || rsp.MatchedCount != 2 {
+		t.Fatalf("expected 2 upserted and 2 matched records, got %v", rsp)
+	}
+
+	tables, err := stg.ListTables(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+
+	if table, ok := tables.TableSet["candles"]; !ok || table.Size != 10 || len(tables.TableSet) != 1 {
+		t.Fatalf("expected the candles table, got %v", tables.TableSet)
+	}
+}
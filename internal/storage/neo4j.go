@@ -0,0 +1,627 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// neo4jDefaultDatabase is the default database of a Neo4j server.
+	neo4jDefaultDatabase = "neo4j"
+
+	// neo4jDefaultPrimaryKey is the default property that nodes are merged on.
+	neo4jDefaultPrimaryKey = "id"
+)
+
+// neo4jTxType is a type alias for the context key of the transactions of Neo4j.
+type neo4jTxType uint8
+
+const (
+	basicNeo4jTxID neo4jTxType = iota
+)
+
+var (
+	ErrNeo4j           = fmt.Errorf("neo4j error")
+	ErrMissingNodeKey  = fmt.Errorf("record is missing a node key")
+	ErrInvalidRelation = fmt.Errorf("invalid graph relationship")
+)
+
+// Neo4jError wraps an error of the Neo4j HTTP API with ErrNeo4j.
+func Neo4jError(code, message string) error {
+	return fmt.Errorf("%w %s: %s", ErrNeo4j, code, message)
+}
+
+// Neo4j is a storage device that merges records into the nodes of a Neo4j graph, through its HTTP API. The records of
+// a table are merged into nodes labeled with the table, and with the label of the request's graph mapping if it has
+// one. Nodes are merged on their keys, and the graph mapping can merge relationships from them to the nodes whose
+// keys are held by their fields, e.g. a "follows" field of user IDs. An index is created on the keys of every label.
+//
+// The connection string is "neo4j://[user:pass@]host[:port][/database][?primaryKey=id]", where the port is the port
+// of the HTTP API, 7474 by default. The "neo4j+s" scheme connects over HTTPS, on port 7473 by default. The database
+// defaults to "neo4j" and the keys of the nodes without a graph mapping to "id".
+type Neo4j struct {
+	client      *http.Client
+	endpoint    string
+	user        *url.Userinfo
+	primaryKeys []string
+
+	// indexes are the labels and keys that are known to be indexed.
+	indexes sync.Map
+
+	// activeTx are the URLs of the transactions that are currently active, by the transaction ID of the context of
+	// a write method.
+	activeTx sync.Map
+}
+
+// neo4jStatement is a Cypher statement of a request to the HTTP API.
+type neo4jStatement struct {
+	Statement    string                 `json:"statement"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	IncludeStats bool                   `json:"includeStats,omitempty"`
+}
+
+// neo4jResult is the result of a Cypher statement.
+type neo4jResult struct {
+	Data []struct {
+		Row []json.RawMessage `json:"row"`
+	} `json:"data"`
+
+	Stats struct {
+		NodesDeleted int64 `json:"nodes_deleted"`
+	} `json:"stats"`
+}
+
+// neo4jResponse is a response of the HTTP API.
+type neo4jResponse struct {
+	Results []neo4jResult `json:"results"`
+	Errors  []struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// NewNeo4j will return a new storage device for the Neo4j server of the connection string.
+func NewNeo4j(ctx context.Context, dns string) (*Neo4j, error) {
+	uri, err := url.Parse(dns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse connection string: %w", err)
+	}
+
+	scheme, port := "http", "7474"
+	if uri.Scheme == "neo4j+s" {
+		scheme, port = "https", "7473"
+	}
+
+	host := uri.Host
+	if uri.Port() == "" {
+		host += ":" + port
+	}
+
+	database := strings.Trim(uri.Path, "/")
+	if database == "" {
+		database = neo4jDefaultDatabase
+	}
+
+	stg := &Neo4j{
+		client:      http.DefaultClient,
+		endpoint:    scheme + "://" + host + "/db/" + url.PathEscape(database),
+		user:        uri.User,
+		primaryKeys: []string{neo4jDefaultPrimaryKey},
+	}
+
+	if keys := uri.Query().Get("primaryKey"); keys != "" {
+		stg.primaryKeys = strings.Split(keys, ",")
+	}
+
+	if _, err := stg.run(ctx, stg.endpoint+"/tx/commit", http.MethodPost, nil); err != nil {
+		return nil, fmt.Errorf("unable to connect to neo4j: %w", err)
+	}
+
+	return stg, nil
+}
+
+// IsNoSQL returns "true" to indicate that "Neo4j" is a NoSQL storage device.
+func (stg *Neo4j) IsNoSQL() bool { return true }
+
+// Versioned returns "true" to indicate that "Neo4j" only overwrites stored records with newer versions.
+func (stg *Neo4j) Versioned() bool { return true }
+
+// Type implements the storage interface.
+func (stg *Neo4j) Type() uint8 { return Neo4jType }
+
+// Close will close the idle connections to the Neo4j server.
+func (stg *Neo4j) Close() {
+	stg.client.CloseIdleConnections()
+}
+
+// neo4jQuote will quote a label, relationship type, or property of a Cypher statement.
+func neo4jQuote(identifier string) string {
+	return "`" + strings.ReplaceAll(identifier, "`", "``") + "`"
+}
+
+// neo4jKeyMap will return a Cypher map of the keys, whose values are the same keys of a parameter, e.g.
+// "{`id`: row.keys.`id`}".
+func neo4jKeyMap(keys []string, param string) string {
+	entries := make([]string, len(keys))
+	for idx, key := range keys {
+		entries[idx] = fmt.Sprintf("%s: %s.%s", neo4jQuote(key), param, neo4jQuote(key))
+	}
+
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// run will run Cypher statements with a request to the HTTP API, returning an error if any of them failed.
+func (stg *Neo4j) run(ctx context.Context, rawURL, method string, stmts []*neo4jStatement,
+) (*neo4jResponse, error) {
+	rsp, _, err := stg.do(ctx, rawURL, method, stmts)
+
+	return rsp, err
+}
+
+// do will run Cypher statements with a request to the HTTP API, returning the response and its header.
+func (stg *Neo4j) do(ctx context.Context, rawURL, method string, stmts []*neo4jStatement,
+) (*neo4jResponse, http.Header, error) {
+	body, err := json.Marshal(map[string]interface{}{"statements": append([]*neo4jStatement{}, stmts...)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	if stg.user != nil {
+		password, _ := stg.user.Password()
+		req.SetBasicAuth(stg.user.Username(), password)
+	}
+
+	httpRsp, err := stg.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to request %q: %w", rawURL, err)
+	}
+	defer httpRsp.Body.Close()
+
+	data, err := io.ReadAll(httpRsp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read response of %q: %w", rawURL, err)
+	}
+
+	if httpRsp.StatusCode >= http.StatusMultipleChoices {
+		return nil, nil, Neo4jError(httpRsp.Status, string(data))
+	}
+
+	rsp := new(neo4jResponse)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, rsp); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+		}
+	}
+
+	if len(rsp.Errors) > 0 {
+		return nil, nil, Neo4jError(rsp.Errors[0].Code, rsp.Errors[0].Message)
+	}
+
+	return rsp, httpRsp.Header, nil
+}
+
+// txURL will return the URL that runs statements within the transaction of the context, or in their own transaction
+// if it does not have one.
+func (stg *Neo4j) txURL(ctx context.Context) string {
+	if txID, ok := ctx.Value(basicNeo4jTxID).(string); ok {
+		if location, ok := stg.activeTx.Load(txID); ok {
+			if location, ok := location.(string); ok {
+				return location
+			}
+		}
+	}
+
+	return stg.endpoint + "/tx/commit"
+}
+
+// ensureIndex will create an index on the keys of a label, if it does not exist. Indexes can not be created within a
+// transaction that writes data, so they are created in their own.
+func (stg *Neo4j) ensureIndex(ctx context.Context, label string, keys []string) error {
+	properties := make([]string, len(keys))
+	for idx, key := range keys {
+		properties[idx] = "n." + neo4jQuote(key)
+	}
+
+	stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS FOR (n:%s) ON (%s)", neo4jQuote(label),
+		strings.Join(properties, ", "))
+
+	if _, ok := stg.indexes.Load(stmt); ok {
+		return nil
+	}
+
+	if _, err := stg.run(ctx, stg.endpoint+"/tx/commit", http.MethodPost,
+		[]*neo4jStatement{{Statement: stmt}}); err != nil {
+		return fmt.Errorf("unable to create index on %q: %w", label, err)
+	}
+
+	stg.indexes.Store(stmt, true)
+
+	return nil
+}
+
+// neo4jProperty will return a value as a node property. Neo4j properties can only hold scalars and arrays of scalars,
+// so other values are encoded as JSON.
+func neo4jProperty(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		return string(data), nil
+	case []interface{}:
+		for _, elem := range value {
+			switch elem.(type) {
+			case map[string]interface{}, []interface{}, nil:
+				data, err := json.Marshal(value)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+				}
+
+				return string(data), nil
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// neo4jRows will return the parameters of the records: the keys that their nodes are merged on, and their properties.
+func neo4jRows(records []*structpb.Struct, keys []string) ([]interface{}, error) {
+	rows := make([]interface{}, 0, len(records))
+
+	for _, record := range records {
+		rec := record.AsMap()
+		nodeKeys := make(map[string]interface{}, len(keys))
+
+		for _, key := range keys {
+			if rec[key] == nil {
+				return nil, fmt.Errorf("%w: %q", ErrMissingNodeKey, key)
+			}
+
+			nodeKeys[key] = rec[key]
+		}
+
+		props := make(map[string]interface{}, len(rec))
+
+		for field, value := range rec {
+			prop, err := neo4jProperty(value)
+			if err != nil {
+				return nil, err
+			}
+
+			props[field] = prop
+		}
+
+		rows = append(rows, map[string]interface{}{"keys": nodeKeys, "props": props})
+	}
+
+	return rows, nil
+}
+
+// neo4jRelationshipRows will return the parameters of a relationship: the keys of the node of each record, and the
+// keys of the other nodes, which are held by the relationship's field.
+func neo4jRelationshipRows(records []*structpb.Struct, keys []string, field string) []interface{} {
+	var rows []interface{}
+
+	for _, record := range records {
+		rec := record.AsMap()
+
+		var targets []interface{}
+
+		switch value := rec[field].(type) {
+		case nil:
+			continue
+		case []interface{}:
+			for _, target := range value {
+				if target != nil {
+					targets = append(targets, target)
+				}
+			}
+		default:
+			targets = []interface{}{value}
+		}
+
+		nodeKeys := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			nodeKeys[key] = rec[key]
+		}
+
+		rows = append(rows, map[string]interface{}{"keys": nodeKeys, "targets": targets})
+	}
+
+	return rows
+}
+
+// nodeStmt will return the statement that merges the nodes of the records and returns the number of inserted and
+// updated nodes, and the keys of the inserted and updated nodes. Existing nodes are only updated by records with a
+// newer version, if a version field is set.
+func nodeStmt(table, label string, keys []string, req *proto.UpsertRequest) string {
+	labels := neo4jQuote(label)
+	if table != label {
+		labels += ":" + neo4jQuote(table)
+	}
+
+	set := "SET n += row.props"
+	if req.GetMissingFields() == MissingFieldsNull {
+		set = "SET n = row.props"
+	}
+
+	if version := req.GetVersionField(); version != "" {
+		set = fmt.Sprintf("FOREACH (_ IN CASE WHEN n.%[1]s IS NULL OR row.props.%[1]s > n.%[1]s THEN [1] ELSE [] "+
+			"END | %[2]s)", neo4jQuote(version), set)
+	}
+
+	return fmt.Sprintf("UNWIND $rows AS row "+
+		"OPTIONAL MATCH (existing:%[1]s %[2]s) "+
+		"WITH row, existing, properties(existing) AS before "+
+		"MERGE (n:%[3]s %[2]s) "+
+		"%[4]s "+
+		"WITH row, existing IS NULL AS inserted, existing IS NOT NULL AND before <> properties(n) AS updated "+
+		"RETURN sum(CASE WHEN inserted THEN 1 ELSE 0 END), sum(CASE WHEN updated THEN 1 ELSE 0 END), "+
+		"collect(CASE WHEN inserted OR updated THEN row.keys END)",
+		neo4jQuote(label), neo4jKeyMap(keys, "row.keys"), labels, set)
+}
+
+// relationshipStmt will return the statement that merges a relationship from the nodes of the records to the nodes
+// whose keys are held by the relationship's field.
+func relationshipStmt(label string, keys []string, rel *proto.GraphRelationship) string {
+	pattern := "(n)-[:%s]->(m)"
+	if rel.GetIncoming() {
+		pattern = "(n)<-[:%s]-(m)"
+	}
+
+	return fmt.Sprintf("UNWIND $rows AS row "+
+		"MATCH (n:%s %s) "+
+		"UNWIND row.targets AS target "+
+		"MERGE (m:%s {%s: target}) "+
+		"MERGE "+pattern,
+		neo4jQuote(label), neo4jKeyMap(keys, "row.keys"), neo4jQuote(rel.GetLabel()), neo4jQuote(rel.GetKey()),
+		neo4jQuote(rel.GetType()))
+}
+
+// Upsert will merge the records into nodes on their keys, and merge the relationships of the request's graph mapping.
+// The relationships are only added, relationships to nodes that are no longer held by a record are kept.
+func (stg *Neo4j) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	if len(records) == 0 {
+		return &proto.UpsertResponse{}, nil
+	}
+
+	graph := req.GetGraph()
+
+	label := graph.GetLabel()
+	if label == "" {
+		label = req.GetTable()
+	}
+
+	keys := graph.GetKeys()
+	if len(keys) == 0 {
+		keys = stg.primaryKeys
+	}
+
+	rows, err := neo4jRows(records, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stg.ensureIndex(ctx, label, keys); err != nil {
+		return nil, err
+	}
+
+	stmts := []*neo4jStatement{{
+		Statement:  nodeStmt(req.GetTable(), label, keys, req),
+		Parameters: map[string]interface{}{"rows": rows},
+	}}
+
+	for _, rel := range graph.GetRelationships() {
+		if rel.GetType() == "" || rel.GetField() == "" || rel.GetLabel() == "" || rel.GetKey() == "" {
+			return nil, fmt.Errorf("%w: type, field, label, and key are required", ErrInvalidRelation)
+		}
+
+		if err := stg.ensureIndex(ctx, rel.GetLabel(), []string{rel.GetKey()}); err != nil {
+			return nil, err
+		}
+
+		stmts = append(stmts, &neo4jStatement{
+			Statement:  relationshipStmt(label, keys, rel),
+			Parameters: map[string]interface{}{"rows": neo4jRelationshipRows(records, keys, rel.GetField())},
+		})
+	}
+
+	rsp, err := stg.run(ctx, stg.txURL(ctx), http.MethodPost, stmts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to merge nodes of %q: %w", label, err)
+	}
+
+	return neo4jUpsertResponse(rsp, int64(len(records)), req.GetReturnKeys())
+}
+
+// neo4jUpsertResponse will return the upsert response of the result of the statement that merged the nodes.
+func neo4jUpsertResponse(rsp *neo4jResponse, count int64, returnKeys bool) (*proto.UpsertResponse, error) {
+	if len(rsp.Results) == 0 || len(rsp.Results[0].Data) == 0 || len(rsp.Results[0].Data[0].Row) != 3 {
+		return nil, Neo4jError("", "unexpected result of merge")
+	}
+
+	row := rsp.Results[0].Data[0].Row
+	upsertRsp := new(proto.UpsertResponse)
+
+	if err := json.Unmarshal(row[0], &upsertRsp.InsertedCount); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	if err := json.Unmarshal(row[1], &upsertRsp.UpdatedCount); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	if returnKeys {
+		var keys []map[string]interface{}
+		if err := json.Unmarshal(row[2], &keys); err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+		}
+
+		for _, key := range keys {
+			affected, err := structpb.NewStruct(key)
+			if err != nil {
+				return nil, fmt.Errorf("unable to build record key: %w", err)
+			}
+
+			upsertRsp.AffectedKeys = append(upsertRsp.AffectedKeys, affected)
+		}
+	}
+
+	upsertRsp.UnchangedCount = count - upsertRsp.InsertedCount - upsertRsp.UpdatedCount
+	upsertRsp.UpsertedCount = upsertRsp.InsertedCount
+	upsertRsp.MatchedCount = count - upsertRsp.InsertedCount
+
+	return upsertRsp, nil
+}
+
+// Truncate will delete the nodes labeled with the tables, and their relationships.
+func (stg *Neo4j) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
+	stmts := make([]*neo4jStatement, 0, len(req.GetTables()))
+	for _, table := range req.GetTables() {
+		stmts = append(stmts, &neo4jStatement{
+			Statement:    fmt.Sprintf("MATCH (n:%s) DETACH DELETE n", neo4jQuote(table)),
+			IncludeStats: true,
+		})
+	}
+
+	rsp, err := stg.run(ctx, stg.txURL(ctx), http.MethodPost, stmts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to delete nodes: %w", err)
+	}
+
+	truncateRsp := new(proto.TruncateResponse)
+	for _, result := range rsp.Results {
+		truncateRsp.DeletedCount += int32(result.Stats.NodesDeleted)
+	}
+
+	return truncateRsp, nil
+}
+
+// labels will return the labels of the graph.
+func (stg *Neo4j) labels(ctx context.Context) ([]string, error) {
+	rsp, err := stg.run(ctx, stg.endpoint+"/tx/commit", http.MethodPost,
+		[]*neo4jStatement{{Statement: "CALL db.labels() YIELD label RETURN label"}})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list labels: %w", err)
+	}
+
+	var labels []string
+
+	for _, result := range rsp.Results {
+		for _, data := range result.Data {
+			var label string
+			if len(data.Row) == 0 || json.Unmarshal(data.Row[0], &label) != nil {
+				continue
+			}
+
+			labels = append(labels, label)
+		}
+	}
+
+	return labels, nil
+}
+
+// ListTables will return the labels of the graph. Their size is not known.
+func (stg *Neo4j) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
+	labels, err := stg.labels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+	for _, label := range labels {
+		rsp.TableSet[label] = &proto.Table{}
+	}
+
+	return rsp, nil
+}
+
+// ListPrimaryKeys will return the default keys of the labels, which are the same for every label.
+func (stg *Neo4j) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResponse, error) {
+	labels, err := stg.labels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListPrimaryKeysResponse{PKSet: make(map[string]*proto.PrimaryKeys)}
+	for _, label := range labels {
+		rsp.PKSet[label] = &proto.PrimaryKeys{List: stg.primaryKeys}
+	}
+
+	return rsp, nil
+}
+
+// StartTx will start a transaction on the Neo4j server. The write methods that are sent to the transaction run within
+// it.
+func (stg *Neo4j) StartTx(ctx context.Context) (*Txn, error) {
+	txn := newTxn()
+
+	_, header, err := stg.do(ctx, stg.endpoint+"/tx", http.MethodPost, nil)
+	if err != nil {
+		return txn, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	// The location of the transaction is the URL that runs statements within it.
+	location := header.Get("Location")
+	if location == "" {
+		return txn, fmt.Errorf("failed to start transaction: %w", Neo4jError("", "no transaction location"))
+	}
+
+	txnID := uuid.New().String()
+	stg.activeTx.Store(txnID, location)
+
+	txCtx := context.WithValue(ctx, basicNeo4jTxID, txnID)
+
+	go func() {
+		defer stg.activeTx.Delete(txnID)
+
+		err = txn.receive(func(fn TxnChanFn) error { return fn(txCtx, stg) })
+
+		commit := <-txn.commit && err == nil
+
+		if commit {
+			_, err = stg.run(ctx, location+"/commit", http.MethodPost, nil)
+		} else if _, rerr := stg.run(ctx, location, http.MethodDelete, nil); err == nil {
+			err = rerr
+		}
+
+		txn.done <- err
+	}()
+
+	return txn, nil
+}
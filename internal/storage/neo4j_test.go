@@ -0,0 +1,178 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// fakeNeo4j is a Neo4j HTTP API that records the statements it runs, and the transaction they ran in.
+type fakeNeo4j struct {
+	mu         sync.Mutex
+	statements map[string][]neo4jStatement
+	committed  bool
+}
+
+func (srv *fakeNeo4j) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if user, password, ok := req.BasicAuth(); !ok || user != "neo4j" || password != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+
+		return
+	}
+
+	switch req.URL.Path {
+	case "/db/graph/tx":
+		w.Header().Set("Location", "http://"+req.Host+"/db/graph/tx/1")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"results":[],"errors":[]}`))
+
+		return
+	case "/db/graph/tx/1/commit":
+		srv.committed = true
+		w.Write([]byte(`{"results":[],"errors":[]}`))
+
+		return
+	}
+
+	var body struct {
+		Statements []neo4jStatement `json:"statements"`
+	}
+
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+
+		return
+	}
+
+	srv.statements[req.URL.Path] = append(srv.statements[req.URL.Path], body.Statements...)
+
+	results := make([]string, 0, len(body.Statements))
+
+	for _, stmt := range body.Statements {
+		switch {
+		case strings.Contains(stmt.Statement, "OPTIONAL MATCH"):
+			results = append(results, `{"data":[{"row":[1,1,[{"id":"ada"},{"id":"bob"}]]}]}`)
+		case strings.HasPrefix(stmt.Statement, "CALL db.labels()"):
+			results = append(results, `{"data":[{"row":["User"]},{"row":["users"]}]}`)
+		default:
+			results = append(results, `{"data":[]}`)
+		}
+	}
+
+	w.Write([]byte(`{"results":[` + strings.Join(results, ",") + `],"errors":[]}`))
+}
+
+func TestNeo4j(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := &fakeNeo4j{statements: make(map[string][]neo4jStatement)}
+
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	stg, err := NewNeo4j(ctx, "neo4j://neo4j:secret@"+strings.TrimPrefix(httpSrv.URL, "http://")+"/graph")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer stg.Close()
+
+	txn, err := stg.StartTx(ctx)
+	if err != nil {
+		t.Fatalf("failed to start transaction: %v", err)
+	}
+
+	var rsp *proto.UpsertResponse
+
+	txn.Send(func(sctx context.Context, stg Storage) error {
+		rsp, err = stg.Upsert(sctx, &proto.UpsertRequest{
+			Table:      "users",
+			Data:       []byte(`[{"id":"ada","following":["bob"]},{"id":"bob","profile":{"age":1}},{"id":"cy"}]`),
+			DataType:   int32(tools.UpsertDataJSON),
+			ReturnKeys: true,
+			Graph: &proto.Graph{
+				Label: "User",
+				Relationships: []*proto.GraphRelationship{
+					{Type: "FOLLOWS", Field: "following", Label: "User", Key: "id"},
+				},
+			},
+		})
+
+		return err
+	})
+
+	if err := txn.Commit(); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+
+	if !srv.committed {
+		t.Fatalf("expected the transaction to be committed")
+	}
+
+	if rsp.InsertedCount != 1 || rsp.UpdatedCount != 1 || rsp.UnchangedCount != 1 || len(rsp.AffectedKeys) != 2 {
+		t.Fatalf("unexpected response: %v", rsp)
+	}
+
+	stmts := srv.statements["/db/graph/tx/1"]
+	if len(stmts) != 2 {
+		t.Fatalf("expected 2 statements in the transaction, got %d", len(stmts))
+	}
+
+	if !strings.Contains(stmts[0].Statement, "MERGE (n:`User`:`users` {`id`: row.keys.`id`}) SET n += row.props") {
+		t.Fatalf("unexpected node statement: %s", stmts[0].Statement)
+	}
+
+	// Nested objects are stored as JSON, since properties can only hold scalars and arrays.
+	rows, _ := json.Marshal(stmts[0].Parameters["rows"])
+	if !strings.Contains(string(rows), `"profile":"{\"age\":1}"`) {
+		t.Fatalf("unexpected rows: %s", rows)
+	}
+
+	if !strings.Contains(stmts[1].Statement, "MERGE (m:`User` {`id`: target}) MERGE (n)-[:`FOLLOWS`]->(m)") {
+		t.Fatalf("unexpected relationship statement: %s", stmts[1].Statement)
+	}
+
+	if targets, _ := json.Marshal(stmts[1].Parameters["rows"]); string(targets) !=
+		`[{"keys":{"id":"ada"},"targets":["bob"]}]` {
+		t.Fatalf("unexpected relationship rows: %s", targets)
+	}
+
+	tables, err := stg.ListTables(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+
+	if _, ok := tables.TableSet["users"]; !ok || len(tables.TableSet) != 2 {
+		t.Fatalf("unexpected tables: %v", tables.TableSet)
+	}
+
+	_, err = stg.Upsert(ctx, &proto.UpsertRequest{
+		Table:    "users",
+		Data:     []byte(`[{"name":"ada"}]`),
+		DataType: int32(tools.UpsertDataJSON),
+	})
+	if !errors.Is(err, ErrMissingNodeKey) {
+		t.Fatalf("expected error %v, got %v", ErrMissingNodeKey, err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+// oracleMaxParams is the maximum number of bind variables of an Oracle statement, which is kept well below the
+// limit of 65535 to bound the size of the statement.
+const oracleMaxParams = 10000
+
+// oracleConflictCodes are the Oracle error codes that indicate a write conflict.
+var oracleConflictCodes = []string{
+	"ORA-00001", // unique constraint violated
+	"ORA-00060", // deadlock detected
+	"ORA-08177", // can't serialize access for this transaction
+}
+
+// oracleRejectedCodes are the Oracle error codes that indicate data that the table rejects.
+var oracleRejectedCodes = []string{
+	"ORA-00904", // invalid identifier
+	"ORA-00942", // table or view does not exist
+	"ORA-01400", // cannot insert NULL
+	"ORA-01722", // invalid number
+	"ORA-02290", // check constraint violated
+	"ORA-02291", // integrity constraint violated, parent key not found
+	"ORA-12899", // value too large for column
+}
+
+// oracleDialect is the dialect of Oracle. Oracle folds unquoted identifiers to upper case, so upper case tables and
+// columns are listed in lower case to match the fields of the records, and lower case identifiers are quoted in upper
+// case.
+type oracleDialect struct{}
+
+// NewOracle will return a new storage device for an Oracle database, given an "oracle://" connection string. The
+// program must import a driver that registers "oracle", e.g. "github.com/sijms/go-ora/v2".
+func NewOracle(_ context.Context, dns string) (*MergeSQL, error) {
+	return newMergeSQL(oracleDialect{}, dns)
+}
+
+func (oracleDialect) driver() (string, string) { return "oracle", "github.com/sijms/go-ora/v2" }
+
+func (oracleDialect) storageType() uint8 { return OracleType }
+
+func (oracleDialect) columnsQuery() string { return string(oracleColumns) }
+
+func (oracleDialect) maxParams() int { return oracleMaxParams }
+
+func (oracleDialect) quote(identifier string) string {
+	if identifier == strings.ToLower(identifier) {
+		identifier = strings.ToUpper(identifier)
+	}
+
+	return `"` + strings.ReplaceAll(identifier, `"`, `""`) + `"`
+}
+
+// isConflict will return true if the error has the code of a write conflict.
+func (oracleDialect) isConflict(err error) bool {
+	for _, code := range oracleConflictCodes {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRejected will return true if the error has the code of rejected data.
+func (oracleDialect) isRejected(err error) bool {
+	for _, code := range oracleRejectedCodes {
+		if strings.Contains(err.Error(), code) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeStmt will return a MERGE INTO statement. Oracle can not output the merged records, so only the number of
+// records that were inserted or updated is known. Matched records are only updated if any of their columns change,
+// compared with "DECODE" so that NULLs are equal.
+func (dialect oracleDialect) mergeStmt(meta *pgmeta, table string, columns []string, vol int,
+	req *proto.UpsertRequest,
+) (string, bool) {
+	rows := make([]string, vol)
+	for row := range rows {
+		values := make([]string, len(columns))
+		for idx, column := range columns {
+			values[idx] = fmt.Sprintf(":%d %s", row*len(columns)+idx+1, dialect.quote(column))
+		}
+
+		rows[row] = "SELECT " + strings.Join(values, ",") + " FROM dual"
+	}
+
+	var stmt strings.Builder
+
+	fmt.Fprintf(&stmt, "MERGE INTO %s target USING (%s) source ON (%s)",
+		dialect.quote(table),
+		strings.Join(rows, " UNION ALL "),
+		mergeJoin(dialect, "target.%[1]s = source.%[1]s", " AND ", meta.pks[table]))
+
+	if nonKeys := mergeNonKeys(meta, table, columns); len(nonKeys) > 0 {
+		condition := fmt.Sprintf("NOT (%s)",
+			mergeJoin(dialect, "DECODE(target.%[1]s, source.%[1]s, 1, 0) = 1", " AND ", nonKeys))
+
+		if version := req.GetVersionField(); version != "" {
+			condition += fmt.Sprintf(" AND (target.%[1]s IS NULL OR source.%[1]s > target.%[1]s)",
+				dialect.quote(version))
+		}
+
+		fmt.Fprintf(&stmt, " WHEN MATCHED THEN UPDATE SET %s WHERE %s",
+			mergeJoin(dialect, "target.%[1]s = source.%[1]s", ",", nonKeys), condition)
+	}
+
+	fmt.Fprintf(&stmt, " WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		mergeJoin(dialect, "%s", ",", columns),
+		mergeJoin(dialect, "source.%s", ",", columns))
+
+	return stmt.String(), false
+}
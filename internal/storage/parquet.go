@@ -0,0 +1,268 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/apache/arrow/go/v12/parquet"
+	"github.com/apache/arrow/go/v12/parquet/file"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+)
+
+// parquetExt is the extension of the files of a table.
+const parquetExt = ".parquet"
+
+var ErrParquetAppendOnly = fmt.Errorf("parquet files are append-only")
+
+// ParquetAppendOnlyError wraps ErrParquetAppendOnly with the upsert option that needs the stored records.
+func ParquetAppendOnlyError(option string) error {
+	return fmt.Errorf("%w: %s is not supported", ErrParquetAppendOnly, option)
+}
+
+// Parquet is a storage device that writes the records of each table as Parquet files, for data lakes and columnar
+// engines, e.g. DuckDB or Spark, that query the files directly. Each table is a directory, and each batch of records
+// is written to its own file as an Arrow record batch, so that the records are not converted row by row. The columns
+// of a file are inferred from its records.
+//
+// The connection string is "parquet:///path/to/directory", or "parquet://directory" for a path relative to the working
+// directory. Parquet files can not be updated, so every upsert appends a file and the readers of a table deduplicate
+// the records on their keys if the same records are written more than once.
+type Parquet struct {
+	dir string
+
+	// written is the number of files written, which keeps the names of the files that are written at the same time
+	// unique.
+	written uint64
+}
+
+// NewParquet will create the directory of the connection string if it does not exist.
+func NewParquet(dns string) (*Parquet, error) {
+	uri, err := url.Parse(dns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse connection string: %w", err)
+	}
+
+	dir := uri.Host + uri.Path
+	if dir == "" {
+		return nil, fmt.Errorf("%w: a directory is required", ErrDNSNotSupported)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create directory: %w", err)
+	}
+
+	return &Parquet{dir: dir}, nil
+}
+
+// IsNoSQL returns "true" to indicate that "Parquet" is a NoSQL storage device.
+func (stg *Parquet) IsNoSQL() bool { return true }
+
+// Type implements the storage interface.
+func (stg *Parquet) Type() uint8 { return ParquetType }
+
+// Close is a no-op, since every file is closed when its batch is written.
+func (stg *Parquet) Close() {}
+
+// table will return the directory of a table.
+func (stg *Parquet) table(table string) string {
+	return filepath.Join(stg.dir, table)
+}
+
+// Upsert will decode the records of an upsert request into a record batch and write it to a new file of the table.
+// The stored records are never read, so the options that compare the records with them are rejected, and the records
+// of a repeated upsert are appended again.
+func (stg *Parquet) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	switch {
+	case req.GetVersionField() != "":
+		return nil, ParquetAppendOnlyError("versionField")
+	case req.GetDeleteField() != "":
+		return nil, ParquetAppendOnlyError("deleteField")
+	case req.GetMissingFields() == MissingFieldsKeep:
+		return nil, ParquetAppendOnlyError("keeping missing fields")
+	}
+
+	rec, err := tools.DecodeUpsertArrowRecord(memory.DefaultAllocator, req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode record batch: %w", err)
+	}
+
+	defer rec.Release()
+
+	return stg.UpsertBatch(ctx, req.Table, rec)
+}
+
+// UpsertBatch will write a record batch to a new file of the table. The file is written to a temporary file that is
+// renamed when it is complete, so that readers of the directory never see a partial file.
+func (stg *Parquet) UpsertBatch(_ context.Context, table string, rec arrow.Record) (*proto.UpsertResponse, error) {
+	if rec.NumRows() == 0 {
+		return new(proto.UpsertResponse), nil
+	}
+
+	dir := stg.table(table)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create directory of table %q: %w", table, err)
+	}
+
+	name := fmt.Sprintf("%d-%d%s", time.Now().UnixNano(), atomic.AddUint64(&stg.written, 1), parquetExt)
+	path := filepath.Join(dir, name)
+
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create file of table %q: %w", table, err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	writer, err := pqarrow.NewFileWriter(rec.Schema(), tmp, parquet.NewWriterProperties(),
+		pqarrow.DefaultWriterProps())
+	if err != nil {
+		tmp.Close()
+
+		return nil, fmt.Errorf("unable to create parquet writer: %w", err)
+	}
+
+	if err := writer.Write(rec); err != nil {
+		writer.Close()
+
+		return nil, fmt.Errorf("unable to write record batch of table %q: %w", table, err)
+	}
+
+	// Closing the writer writes the footer of the file and closes it.
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("unable to write parquet file of table %q: %w", table, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("unable to write parquet file of table %q: %w", table, err)
+	}
+
+	return &proto.UpsertResponse{UpsertedCount: rec.NumRows(), InsertedCount: rec.NumRows()}, nil
+}
+
+// files will return the Parquet files of a table.
+func (stg *Parquet) files(table string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(stg.table(table), "*"+parquetExt))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list files of table %q: %w", table, err)
+	}
+
+	return files, nil
+}
+
+// parquetRows will return the number of records of a Parquet file, from its footer.
+func parquetRows(path string) (int64, error) {
+	reader, err := file.OpenParquetFile(path, false)
+	if err != nil {
+		return 0, fmt.Errorf("unable to open %q: %w", path, err)
+	}
+
+	defer reader.Close()
+
+	return reader.NumRows(), nil
+}
+
+// Truncate will delete the files of the tables.
+func (stg *Parquet) Truncate(_ context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
+	rsp := new(proto.TruncateResponse)
+
+	for _, table := range req.GetTables() {
+		files, err := stg.files(table)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, path := range files {
+			rows, err := parquetRows(path)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("unable to delete %q: %w", path, err)
+			}
+
+			rsp.DeletedCount += int32(rows)
+		}
+	}
+
+	return rsp, nil
+}
+
+// ListTables will return the tables of the directory, with the size of their files.
+func (stg *Parquet) ListTables(_ context.Context) (*proto.ListTablesResponse, error) {
+	entries, err := os.ReadDir(stg.dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tables: %w", err)
+	}
+
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		files, err := stg.files(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		table := new(proto.Table)
+
+		for _, path := range files {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, fmt.Errorf("unable to stat %q: %w", path, err)
+			}
+
+			table.Size += info.Size()
+		}
+
+		rsp.TableSet[entry.Name()] = table
+	}
+
+	return rsp, nil
+}
+
+// ListPrimaryKeys will return the tables without primary keys, since Parquet files do not have keys.
+func (stg *Parquet) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResponse, error) {
+	tables, err := stg.ListTables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListPrimaryKeysResponse{PKSet: make(map[string]*proto.PrimaryKeys)}
+	for table := range tables.GetTableSet() {
+		rsp.PKSet[table] = &proto.PrimaryKeys{}
+	}
+
+	return rsp, nil
+}
+
+// Untransacted will return true, since every batch is written to its own file as it is received.
+func (stg *Parquet) Untransacted() bool { return true }
+
+// StartTx will start a transaction. Parquet files have no transactions, so each batch is written as it is upserted
+// and is not removed by a rollback.
+func (stg *Parquet) StartTx(ctx context.Context) (*Txn, error) {
+	return startUntransactedTx(ctx, stg), nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/apache/arrow/go/v12/parquet/file"
+	"github.com/apache/arrow/go/v12/parquet/pqarrow"
+)
+
+func TestParquet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	svc, err := New(ctx, Scheme(ParquetType)+"://"+dir)
+	if err != nil {
+		t.Fatalf("failed to construct storage: %v", err)
+	}
+
+	defer svc.Close()
+
+	data, err := json.Marshal([]map[string]interface{}{
+		{"id": 1, "product": "BTC-USD", "price": 1.5},
+		{"id": 2, "product": "ETH-USD"},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal records: %v", err)
+	}
+
+	req := &proto.UpsertRequest{Table: "trades", Data: data, DataType: int32(tools.UpsertDataJSON)}
+	for i := 0; i < 2; i++ {
+		rsp, err := svc.Upsert(ctx, req)
+		if err != nil {
+			t.Fatalf("failed to upsert records: %v", err)
+		}
+
+		if rsp.UpsertedCount != 2 {
+			t.Fatalf("expected 2 upserted records, got %d", rsp.UpsertedCount)
+		}
+	}
+
+	// The options that compare the records with the stored records are rejected without writing a file.
+	for _, opts := range []*proto.UpsertRequest{
+		{VersionField: "id"}, {DeleteField: "deleted"}, {MissingFields: MissingFieldsKeep},
+	} {
+		opts.Table, opts.Data, opts.DataType = req.Table, req.Data, req.DataType
+
+		if _, err := svc.Upsert(ctx, opts); !errors.Is(err, ErrParquetAppendOnly) {
+			t.Fatalf("expected error %v, got %v", ErrParquetAppendOnly, err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "trades", "*.parquet"))
+	if err != nil || len(files) != 2 {
+		t.Fatalf("expected a file per upsert, got %v (%v)", files, err)
+	}
+
+	reader, err := file.OpenParquetFile(files[0], false)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+
+	defer reader.Close()
+
+	fileReader, err := pqarrow.NewFileReader(reader, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+
+	tbl, err := fileReader.ReadTable(ctx)
+	if err != nil {
+		t.Fatalf("failed to read table: %v", err)
+	}
+
+	defer tbl.Release()
+
+	if tbl.NumRows() != 2 || tbl.NumCols() != 3 {
+		t.Fatalf("expected 2 rows of 3 columns, got %d rows of %d columns", tbl.NumRows(), tbl.NumCols())
+	}
+
+	idx := tbl.Schema().FieldIndices("product")
+	if len(idx) != 1 {
+		t.Fatalf("expected a product column, got %v", tbl.Schema())
+	}
+
+	products, ok := tbl.Column(idx[0]).Data().Chunk(0).(*array.String)
+	if !ok || products.Value(1) != "ETH-USD" {
+		t.Fatalf("unexpected products: %v", tbl.Column(idx[0]).Data().Chunk(0))
+	}
+
+	tables, err := svc.ListTables(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+
+	if size := tables.GetTableSet()["trades"].GetSize(); size == 0 {
+		t.Fatalf("expected the size of the trades table, got %v", tables.GetTableSet())
+	}
+
+	truncated, err := svc.Truncate(ctx, &proto.TruncateRequest{Tables: []string{"trades"}})
+	if err != nil {
+		t.Fatalf("failed to truncate table: %v", err)
+	}
+
+	if truncated.DeletedCount != 4 {
+		t.Fatalf("expected 4 deleted records, got %d", truncated.DeletedCount)
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, "trades", "*")); len(files) != 0 {
+		t.Fatalf("expected the files to be deleted, got %v", files)
+	}
+}
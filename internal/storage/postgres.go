@@ -10,24 +10,62 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/alpine-hodler/gidari/proto"
 	"github.com/alpine-hodler/gidari/tools"
 	"github.com/google/uuid"
 	"github.com/lib/pq" // postgres driver
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
 	pgPartitionSize = 1000
 	pgGCRetryLimit  = 10
+
+	// pgGeoConversion converts a GeoJSON geometry to a PostGIS geometry.
+	pgGeoConversion = "ST_SetSRID(ST_GeomFromGeoJSON(%s::text),4326)"
+
+	// pgVectorConversion converts the text of a vector, e.g. "[1,2,3]", to a pgvector vector.
+	pgVectorConversion = "%s::vector"
+
+	// pgJSONConversion converts the JSON text of a record embedded in a JSON column to JSONB.
+	pgJSONConversion = "%s::jsonb"
+
+	// pgMergeVersion is the first server version, as in "server_version_num", that supports MERGE statements.
+	pgMergeVersion = 150000
+
+	// pgMergeReturningVersion is the first server version that supports MERGE statements with a RETURNING clause.
+	pgMergeReturningVersion = 170000
+
+	// pgDeleteColumn is the column of the source of a MERGE statement that is true if a record is marked as deleted.
+	pgDeleteColumn = "_gidari_delete"
 )
 
+// pgConflictCodes are the postgres error codes that indicate a write conflict.
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+var pgConflictCodes = map[pq.ErrorCode]bool{
+	"23505": true, // unique_violation
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+}
+
+// pgRejectedClasses are the classes of the postgres error codes that indicate data that the table rejects.
+var pgRejectedClasses = map[pq.ErrorClass]bool{
+	"22": true, // data_exception
+	"23": true, // integrity_constraint_violation
+	"42": true, // syntax_error_or_access_rule_violation, e.g. undefined_column
+}
+
 // postgresTxType is a type alias for the postgres transaction type.
 type postgresTxType uint8
 
@@ -44,6 +82,19 @@ type pgmeta struct {
 
 	// bytes are the size in bytes for a specific table.
 	bytes map[string]int64
+
+	// version is the version number of the server, e.g. 150004 for 15.4.
+	version int
+}
+
+func (meta *pgmeta) isColumn(table, name string) bool {
+	for _, column := range meta.cols[table] {
+		if column == name {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (meta *pgmeta) isPK(table, name string) bool {
@@ -56,13 +107,25 @@ func (meta *pgmeta) isPK(table, name string) bool {
 	return false
 }
 
+// schema will return the schema of a table, or nil if the table does not exist.
+func (meta *pgmeta) schema(table string) *TableSchema {
+	if len(meta.cols[table]) == 0 {
+		return nil
+	}
+
+	return &TableSchema{
+		Columns:     append([]string{}, meta.cols[table]...),
+		PrimaryKeys: append([]string{}, meta.pks[table]...),
+	}
+}
+
 // exclusionConstraints will return a string of non-primary key columns to "exclude" if they are not changed in the
-// context of a Postgres insert. That is, if a column is not changed, it will not be updated. All columns beside primary
-// keys must be included in the "excluded" clause.
-func (meta *pgmeta) exclusionConstraints(table string) []string {
+// context of a Postgres insert. That is, if a column is not changed, it will not be updated. All upserted columns
+// beside primary keys must be included in the "excluded" clause.
+func (meta *pgmeta) exclusionConstraints(table string, columns []string) []string {
 	var constraints []string
 
-	for _, column := range meta.cols[table] {
+	for _, column := range columns {
 		if !meta.isPK(table, column) {
 			constraints = append(constraints, fmt.Sprintf("\"%s\" = EXCLUDED.\"%s\"", column, column))
 		}
@@ -71,13 +134,168 @@ func (meta *pgmeta) exclusionConstraints(table string) []string {
 	return constraints
 }
 
-// upsertStatement will return a postgres upsert statement for the meta object.
-func (meta *pgmeta) upsertStmt(ctx context.Context, table string, pcf sqlPrepareContextFn, vol int) (*sql.Stmt, error) {
-	query := fmt.Sprintf(`INSERT INTO %s(%s) VALUES %s ON CONFLICT (%s) DO UPDATE SET %s`, table,
-		strings.Join(meta.cols[table], ","),
-		tools.SQLIterativePlaceholders(len(meta.cols[table]), vol, "$"),
-		strings.Join(meta.pks[table], ","),
-		strings.Join(meta.exclusionConstraints(table), ","))
+// pgPlaceholders will return the placeholders of the values of an upsert statement. The values of the columns with a
+// conversion are wrapped by its format, e.g. the GeoJSON geometries of geo columns are converted to PostGIS geometries.
+func pgPlaceholders(columns []string, vol int, conversions map[string]string) string {
+	if len(conversions) == 0 {
+		return tools.SQLIterativePlaceholders(len(columns), vol, "$")
+	}
+
+	rows := make([]string, vol)
+	values := make([]string, len(columns))
+
+	for row := range rows {
+		for idx, column := range columns {
+			values[idx] = fmt.Sprintf("$%d", row*len(columns)+idx+1)
+			if format, ok := conversions[column]; ok {
+				values[idx] = fmt.Sprintf(format, values[idx])
+			}
+		}
+
+		rows[row] = "(" + strings.Join(values, ",") + ")"
+	}
+
+	return strings.Join(rows, ",")
+}
+
+// changedCondition will return a condition that is true if an upsert changes any of the non-primary key columns of
+// an existing record. Conflicting records that would not change are skipped, so that they are reported as unchanged.
+// If a version column is given, existing records are also skipped unless the upserted record has a newer version.
+// The existing record is referenced by "existing", e.g. the table, and the upserted record by "upserted", e.g.
+// "EXCLUDED".
+func (meta *pgmeta) changedCondition(table string, columns []string, versionColumn, existing, upserted string) string {
+	var existingCols, upsertedCols []string
+
+	for _, column := range columns {
+		if !meta.isPK(table, column) {
+			existingCols = append(existingCols, fmt.Sprintf("%s.\"%s\"", existing, column))
+			upsertedCols = append(upsertedCols, fmt.Sprintf("%s.\"%s\"", upserted, column))
+		}
+	}
+
+	condition := fmt.Sprintf("(%s) IS DISTINCT FROM (%s)", strings.Join(existingCols, ","),
+		strings.Join(upsertedCols, ","))
+	if versionColumn != "" {
+		condition += fmt.Sprintf(" AND (%[1]s.\"%[3]s\" IS NULL OR %[2]s.\"%[3]s\" > %[1]s.\"%[3]s\")", existing,
+			upserted, versionColumn)
+	}
+
+	return condition
+}
+
+// collatedKeys will return the primary keys of a table that are matched with the key collation of an upsert, if any.
+// If the request does not name the collated keys, every primary key is matched with the collation.
+func (meta *pgmeta) collatedKeys(table string, req *proto.UpsertRequest) (map[string]bool, error) {
+	keys := req.GetCollationKeys()
+
+	for _, key := range keys {
+		if !meta.isPK(table, key) {
+			return nil, fmt.Errorf("%w: %s.%s", ErrInvalidCollationKey, table, key)
+		}
+	}
+
+	if req.GetKeyCollation() == "" {
+		return nil, nil
+	}
+
+	if len(keys) == 0 {
+		keys = meta.pks[table]
+	}
+
+	collated := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		collated[key] = true
+	}
+
+	return collated, nil
+}
+
+// conflictTarget will return the conflict target of an upsert statement, the primary keys of the table. Keys that are
+// matched with a key collation, e.g. a nondeterministic ICU collation that ignores case, infer the unique index of the
+// table on its keys with that collation, so that records whose keys only differ by case update the same record.
+func (meta *pgmeta) conflictTarget(table string, req *proto.UpsertRequest) (string, error) {
+	collated, err := meta.collatedKeys(table, req)
+	if err != nil {
+		return "", err
+	}
+
+	target := make([]string, len(meta.pks[table]))
+
+	for idx, pk := range meta.pks[table] {
+		target[idx] = pk
+
+		if collated[pk] {
+			target[idx] = fmt.Sprintf("%s COLLATE %s", pk, pq.QuoteIdentifier(req.GetKeyCollation()))
+		}
+	}
+
+	return strings.Join(target, ","), nil
+}
+
+// conversions will return the formats that convert the values of the geo and vector columns of an upsert, after
+// validating the version, geo and vector columns of the request.
+func (meta *pgmeta) conversions(table string, req *proto.UpsertRequest) (map[string]string, error) {
+	if versionColumn := req.GetVersionField(); versionColumn != "" && !meta.isColumn(table, versionColumn) {
+		return nil, fmt.Errorf("%w: %s.%s", ErrInvalidVersionField, table, versionColumn)
+	}
+
+	conversions := make(map[string]string)
+
+	for _, column := range req.GetGeoFields() {
+		if !meta.isColumn(table, column) {
+			return nil, fmt.Errorf("%w: %s.%s", ErrInvalidGeoColumn, table, column)
+		}
+
+		conversions[column] = pgGeoConversion
+	}
+
+	for _, column := range req.GetVectorFields() {
+		if !meta.isColumn(table, column) {
+			return nil, fmt.Errorf("%w: %s.%s", ErrInvalidVectorColumn, table, column)
+		}
+
+		conversions[column] = pgVectorConversion
+	}
+
+	return conversions, nil
+}
+
+// upsertStatement will return a postgres upsert statement of the columns for the meta object. The statement returns a
+// row for each inserted or updated record, where the first column is true if the record was inserted. If "returnKeys"
+// is true, the primary keys of the record follow.
+func (meta *pgmeta) upsertStmt(ctx context.Context, table string, columns []string, pcf sqlPrepareContextFn, vol int,
+	req *proto.UpsertRequest,
+) (*sql.Stmt, error) {
+	conversions, err := meta.conversions(table, req)
+	if err != nil {
+		return nil, err
+	}
+
+	returning := []string{"(xmax = 0)"}
+	if req.GetReturnKeys() {
+		for _, pk := range meta.pks[table] {
+			returning = append(returning, fmt.Sprintf("\"%s\"", pk))
+		}
+	}
+
+	// Conflicting records without any columns to update are left as they are.
+	conflict := "DO NOTHING"
+	if constraints := meta.exclusionConstraints(table, columns); len(constraints) > 0 {
+		conflict = fmt.Sprintf("DO UPDATE SET %s WHERE %s", strings.Join(constraints, ","),
+			meta.changedCondition(table, columns, req.GetVersionField(), table, "EXCLUDED"))
+	}
+
+	target, err := meta.conflictTarget(table, req)
+	if err != nil {
+		return nil, err
+	}
+
+	query := fmt.Sprintf(`INSERT INTO %s(%s) VALUES %s ON CONFLICT (%s) %s RETURNING %s`, table,
+		strings.Join(columns, ","),
+		pgPlaceholders(columns, vol, conversions),
+		target,
+		conflict,
+		strings.Join(returning, ","))
 
 	stmt, err := pcf(ctx, query)
 	if err != nil {
@@ -87,6 +305,165 @@ func (meta *pgmeta) upsertStmt(ctx context.Context, table string, pcf sqlPrepare
 	return stmt, nil
 }
 
+// mergeSource will return the source of a MERGE statement, which selects the records of a JSONB array parameter as
+// rows of the table's type, along with a column that is true if a record is marked as deleted by a field set to true.
+// The values of the columns with a conversion are converted from the text of the records' fields instead.
+func (meta *pgmeta) mergeSource(table string, columns []string, conversions map[string]string,
+	deleteField string,
+) string {
+	selected := make([]string, 0, len(columns)+1)
+
+	for _, column := range columns {
+		format, ok := conversions[column]
+		if !ok {
+			selected = append(selected, fmt.Sprintf("r.\"%s\"", column))
+
+			continue
+		}
+
+		value := fmt.Sprintf("(e->>%s)", pq.QuoteLiteral(column))
+		selected = append(selected, fmt.Sprintf(format+" AS \"%s\"", value, column))
+	}
+
+	selected = append(selected, fmt.Sprintf("COALESCE(e->%s = 'true'::jsonb,false) AS %s",
+		pq.QuoteLiteral(deleteField), pgDeleteColumn))
+
+	// The converted fields are removed from the records before they are cast to the table's type, which could not
+	// parse them, e.g. GeoJSON geometries.
+	converted := make([]string, 0, len(conversions))
+	for column := range conversions {
+		converted = append(converted, pq.QuoteLiteral(column))
+	}
+
+	sort.Strings(converted)
+
+	record := "e"
+	if len(converted) > 0 {
+		record = fmt.Sprintf("e - ARRAY[%s]", strings.Join(converted, ","))
+	}
+
+	return fmt.Sprintf("SELECT %s FROM jsonb_array_elements($1::jsonb) AS e, jsonb_populate_record(NULL::%s,%s) AS r",
+		strings.Join(selected, ","), table, record)
+}
+
+// mergeStmts will return a MERGE statement of the columns for the records of a JSONB array parameter. Existing
+// records that are marked as deleted are deleted, other existing records are updated if they change, and records that
+// do not exist are inserted unless they are marked as deleted.
+//
+// Servers that support it return a row from the MERGE statement for each inserted, updated or deleted record, where
+// the first column is the action, i.e. "INSERT", "UPDATE" or "DELETE". If "returnKeys" is true, the primary keys of
+// the record follow. Older servers can not return rows from a MERGE statement, so a query of the same rows is also
+// returned, which is run before the MERGE statement within its transaction.
+func (meta *pgmeta) mergeStmts(table string, columns []string, req *proto.UpsertRequest) (string, string, error) {
+	if len(meta.pks[table]) == 0 {
+		return "", "", fmt.Errorf("%w: %s", ErrNoPrimaryKey, table)
+	}
+
+	conversions, err := meta.conversions(table, req)
+	if err != nil {
+		return "", "", err
+	}
+
+	if column := req.GetJsonColumn(); column != "" {
+		conversions[column] = pgJSONConversion
+	}
+
+	collated, err := meta.collatedKeys(table, req)
+	if err != nil {
+		return "", "", err
+	}
+
+	var on, keys []string
+
+	for _, pk := range meta.pks[table] {
+		condition := fmt.Sprintf("target.\"%[1]s\" = s.\"%[1]s\"", pk)
+		if collated[pk] {
+			condition += " COLLATE " + pq.QuoteIdentifier(req.GetKeyCollation())
+		}
+
+		on = append(on, condition)
+		keys = append(keys, fmt.Sprintf("target.\"%s\"", pk))
+	}
+
+	var set, quoted, values []string
+
+	for _, column := range columns {
+		if !meta.isPK(table, column) {
+			set = append(set, fmt.Sprintf("\"%[1]s\" = s.\"%[1]s\"", column))
+		}
+
+		quoted = append(quoted, fmt.Sprintf("\"%s\"", column))
+		values = append(values, fmt.Sprintf("s.\"%s\"", column))
+	}
+
+	source := meta.mergeSource(table, columns, conversions, req.GetDeleteField())
+
+	// Matched records without any columns to update are left as they are.
+	changed := "false"
+	update := ""
+
+	if len(set) > 0 {
+		changed = meta.changedCondition(table, columns, req.GetVersionField(), "target", "s")
+		update = fmt.Sprintf(" WHEN MATCHED AND %s THEN UPDATE SET %s", changed, strings.Join(set, ","))
+	}
+
+	merge := fmt.Sprintf("MERGE INTO %s AS target USING (%s) AS s ON %s "+
+		"WHEN MATCHED AND s.%[4]s THEN DELETE%[5]s "+
+		"WHEN NOT MATCHED AND NOT s.%[4]s THEN INSERT (%[6]s) VALUES (%[7]s)",
+		table, source, strings.Join(on, " AND "), pgDeleteColumn, update, strings.Join(quoted, ","),
+		strings.Join(values, ","))
+
+	returning := []string{"merge_action()"}
+	if req.GetReturnKeys() {
+		returning = append(returning, keys...)
+	}
+
+	if meta.version >= pgMergeReturningVersion {
+		return "", merge + " RETURNING " + strings.Join(returning, ","), nil
+	}
+
+	// The actions are inferred by joining the records with the existing rows, whose keys are returned since they
+	// may differ from the records' keys by collation.
+	returning[0] = fmt.Sprintf("CASE WHEN target.ctid IS NULL THEN 'INSERT' WHEN s.%s THEN 'DELETE' ELSE 'UPDATE' END",
+		pgDeleteColumn)
+
+	if req.GetReturnKeys() {
+		for idx, pk := range meta.pks[table] {
+			returning[idx+1] = fmt.Sprintf("COALESCE(%s,s.\"%s\")", keys[idx], pk)
+		}
+	}
+
+	actions := fmt.Sprintf("SELECT %s FROM (%s) AS s LEFT JOIN %s AS target ON %s "+
+		"WHERE (target.ctid IS NULL AND NOT s.%[5]s) OR (target.ctid IS NOT NULL AND (s.%[5]s OR %[6]s))",
+		strings.Join(returning, ","), source, table, strings.Join(on, " AND "), pgDeleteColumn, changed)
+
+	return actions, merge, nil
+}
+
+// deleteStmt will return a statement that deletes the existing records of a table with the primary keys of "vol"
+// records, matching the keys with the key collation of the request, if any. The statement returns a row for each
+// deleted record, where the first column is "DELETE". If "returnKeys" is true, the primary keys of the record follow.
+func (meta *pgmeta) deleteStmt(table string, vol int, req *proto.UpsertRequest) (string, error) {
+	if len(meta.pks[table]) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrNoPrimaryKey, table)
+	}
+
+	target, err := meta.conflictTarget(table, req)
+	if err != nil {
+		return "", err
+	}
+
+	returning := []string{"'DELETE'::text"}
+	if req.GetReturnKeys() {
+		for _, pk := range meta.pks[table] {
+			returning = append(returning, fmt.Sprintf("\"%s\"", pk))
+		}
+	}
+
+	return fmt.Sprintf("DELETE FROM %s WHERE (%s) IN (%s) RETURNING %s", table, target,
+		tools.SQLIterativePlaceholders(len(meta.pks[table]), vol, "$"), strings.Join(returning, ",")), nil
+}
+
 // garbageCollect will garbage collect the database. This will return disk space to the OS by running `VACUUM FULL`.
 // For more information, see: https://www.postgresql.org/docs/current/sql-vacuum.html
 func (pg *Postgres) garbageCollect(ctx context.Context, retryCount uint8, tables ...string) error {
@@ -125,6 +502,13 @@ func (pg *Postgres) loadMeta(ctx context.Context, garbageC bool) error {
 		}
 	}
 
+	// The server version is only queried once, since it does not change for a connection.
+	if pg.meta.version == 0 {
+		if err := pg.DB.QueryRowContext(ctx, "SHOW server_version_num").Scan(&pg.meta.version); err != nil {
+			return fmt.Errorf("unable to query server version: %w", err)
+		}
+	}
+
 	stmt, err := pg.DB.PrepareContext(ctx, string(pgColumns))
 	if err != nil {
 		return fmt.Errorf("unable to prepare statement: %w", err)
@@ -204,6 +588,16 @@ func (pg *Postgres) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeys
 	return rsp, nil
 }
 
+// ReadSchema will return the columns and primary keys of the table of an upsert request, or nil if the table does not
+// exist.
+func (pg *Postgres) ReadSchema(ctx context.Context, req *proto.UpsertRequest) (*TableSchema, error) {
+	if err := pg.loadMeta(ctx, false); err != nil {
+		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
+	}
+
+	return pg.meta.schema(req.GetTable()), nil
+}
+
 // ListTables will set a complete list of available tables on the response.
 func (pg *Postgres) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
 	// Since tables have a "size" associated with them, we need to garbage collect the database before we can
@@ -247,99 +641,1056 @@ func (pg *Postgres) Truncate(ctx context.Context, req *proto.TruncateRequest) (*
 	return &proto.TruncateResponse{}, nil
 }
 
-// getPrepareContextFn will return a function that can prepare an upsert statement for a given table.
-func (pg *Postgres) getPrepareContextFn(ctx context.Context) (sqlPrepareContextFn, error) {
-	// First check to see if a transaction has been assigned to the context. If it has, use the transaction.
-	// Otherwise, use the database.
-	txID, ok := ctx.Value(basicPostgressTxID).(string)
-	if ok {
-		if tx, ok := pg.activeTx.Load(txID); ok {
-			tx, ok := tx.(*sql.Tx)
-			if !ok {
-				return nil, ErrTransactionNotFound
-			}
+// Notify will send a notification to the listeners of a channel, i.e. the sessions that ran "LISTEN <channel>". If the
+// context has a transaction, the notification is only delivered once the transaction is committed, and is discarded
+// if it is rolled back.
+func (pg *Postgres) Notify(ctx context.Context, req *NotifyRequest) error {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
 
-			return tx.PrepareContext, nil
-		}
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to get preparer: %w", err)
 	}
 
-	return pg.DB.PrepareContext, nil
+	stmt, err := prepareContextFn(ctx, "SELECT pg_notify($1, $2)")
+	if err != nil {
+		return fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, req.Channel, req.Payload); err != nil {
+		return fmt.Errorf("unable to notify: %w", err)
+	}
+
+	return nil
 }
 
-// Upsert will insert the records on the request if they do not exist in the database. On conflict, it will use the
-// PK on the request record to update the data in the database. An upsert request will update the entire table
-// for a given record, include fields that have not been set directly.
-func (pg *Postgres) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+// TruncateRange will delete the rows of a table whose time column is within the time window. The column can be a
+// timestamp or a text column of ISO 8601 timestamps. If the context has a transaction, the rows are deleted within it.
+func (pg *Postgres) TruncateRange(ctx context.Context, req *TruncateRangeRequest) (*proto.TruncateResponse, error) {
 	pg.writeMutex.Lock()
 	defer pg.writeMutex.Unlock()
 
-	records, err := tools.DecodeUpsertRecords(req)
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("unable to decode records: %w", err)
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
 	}
 
-	// Do nothing if there are no records.
-	if len(records) == 0 {
-		return &proto.UpsertResponse{}, nil
+	query := fmt.Sprintf(string(pgTruncateRange), pq.QuoteIdentifier(req.Table), pq.QuoteIdentifier(req.Column))
+
+	stmt, err := prepareContextFn(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare statement: %w", err)
 	}
+	defer stmt.Close()
+
+	result, err := stmt.ExecContext(ctx, req.Start, req.End)
+	if err != nil {
+		return nil, fmt.Errorf("unable to delete range: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get deleted count: %w", err)
+	}
+
+	return &proto.TruncateResponse{DeletedCount: int32(deleted)}, nil
+}
+
+// Buckets will return the indexes of the time buckets of a table that hold rows, whose time column is within the time
+// window. The column can be a timestamp or a text column of ISO 8601 timestamps.
+func (pg *Postgres) Buckets(ctx context.Context, req *BucketsRequest) ([]int64, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
 
 	prepareContextFn, err := pg.getPrepareContextFn(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("unable to get preparer: %w", err)
 	}
 
-	if err := pg.loadMeta(ctx, false); err != nil {
-		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
-	}
+	query := fmt.Sprintf(string(pgBuckets), pq.QuoteIdentifier(req.Table), pq.QuoteIdentifier(req.Column))
 
-	table := req.GetTable()
+	stmt, err := prepareContextFn(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
 
-	// Upsert 1000 records at a time, the maximum number of records that can be inserted in a single statement on a
-	// postgres database.
-	for _, partition := range tools.PartitionStructs(pgPartitionSize, records) {
-		stmt, err := pg.meta.upsertStmt(ctx, table, prepareContextFn, len(partition))
-		if err != nil {
-			return nil, fmt.Errorf("unable to prepare statement: %w", err)
-		}
+	start := float64(req.Start.UnixNano()) / float64(time.Second)
 
-		// Execute upsert.
-		arguments := tools.SQLFlattenPartition(pg.meta.cols[table], partition)
-		if _, err := stmt.ExecContext(ctx, arguments...); err != nil {
-			return nil, fmt.Errorf("unable to execute upsert: %w", err)
-		}
+	rows, err := stmt.QueryContext(ctx, req.Start, req.End, start, req.Granularity.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("unable to query buckets of %q: %w", req.Table, err)
 	}
+	defer rows.Close()
 
-	return &proto.UpsertResponse{}, nil
-}
+	var buckets []int64
 
-// Postgres is a wrapper around the sql.DB object.
-type Postgres struct {
-	*sql.DB
+	for rows.Next() {
+		var bucket int64
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, fmt.Errorf("unable to scan bucket: %w", err)
+		}
 
-	// meta hold metdata about the database.
-	meta *pgmeta
+		buckets = append(buckets, bucket)
+	}
 
-	metaMutex  sync.Mutex
-	writeMutex sync.Mutex
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to query buckets of %q: %w", req.Table, err)
+	}
 
-	// activeTx are the transactions that are currently active on this connection. When a user calls "StartTx" on
-	// a Postgres intance, a transaction is created and added to this map. Afterward, if the user calls a write
-	// method (e.g. Insert, Update, Delete, Upsert), the transaction will be used to execute the query. In order for
-	// the write method to know which transaction to use, a context with the transaction ID must be passed into
-	// the method. The transaction ID is added to the context in the "StartTx" method. The transaction ID is
-	// removed from the context in the "CommitTx" and "RollbackTx" methods.
-	activeTx sync.Map
+	return buckets, nil
 }
 
-// NewPostgres will return a new Postgres option for querying data through a Postgres DB.
-func NewPostgres(ctx context.Context, connectionURL string) (*Postgres, error) {
-	postgres := new(Postgres)
+// Measure will return a measurement of a table: the single number returned by a query, the fraction of rows whose
+// column is null, or the number of combinations of values of the unique columns that occur more than once. If the
+// context has a transaction, the uncommitted rows are measured.
+func (pg *Postgres) Measure(ctx context.Context, req *MeasureRequest) (float64, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
 
-	var err error
+	var query string
+
+	switch {
+	case req.Query != "":
+		query = req.Query
+	case req.NullField != "":
+		query = fmt.Sprintf(string(pgNullRate), pq.QuoteIdentifier(req.Table), pq.QuoteIdentifier(req.NullField))
+	case len(req.UniqueFields) > 0:
+		columns := make([]string, len(req.UniqueFields))
+		for idx, field := range req.UniqueFields {
+			columns[idx] = pq.QuoteIdentifier(field)
+		}
 
-	postgres.DB, err = sql.Open("postgres", connectionURL)
-	if err != nil {
-		return nil, fmt.Errorf("unable to connect to postgres: %w", err)
+		query = fmt.Sprintf(string(pgDuplicates), pq.QuoteIdentifier(req.Table), strings.Join(columns, ","))
+	default:
+		return 0, fmt.Errorf("%w: no measurement for %q", ErrInvalidMeasurement, req.Table)
+	}
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	stmt, err := prepareContextFn(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var value sql.NullFloat64
+	if err := stmt.QueryRowContext(ctx).Scan(&value); err != nil {
+		return 0, fmt.Errorf("unable to measure %q: %w", req.Table, err)
+	}
+
+	return value.Float64, nil
+}
+
+// pgDistinctKeys will return the distinct primary keys of the records, skipping records that are missing a key.
+func pgDistinctKeys(records []*structpb.Struct, pks []string) ([]*structpb.Struct, error) {
+	seen := make(map[string]bool)
+	keys := make([]*structpb.Struct, 0, len(records))
+	composite := tools.CompositeKey{Fields: pks}
+
+	for _, record := range records {
+		encoded, err := composite.StructKey(record)
+		if errors.Is(err, tools.ErrIncompleteKey) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[encoded] {
+			continue
+		}
+
+		seen[encoded] = true
+
+		key := &structpb.Struct{Fields: make(map[string]*structpb.Value, len(pks))}
+		for _, pk := range pks {
+			key.Fields[pk] = record.GetFields()[pk]
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// CountUpserted will count the distinct records of an upsert request, by the primary keys of the table, and the number
+// of them that are present in the table. If the context has a transaction, the uncommitted rows are counted. Tables
+// without primary keys can not be counted.
+func (pg *Postgres) CountUpserted(ctx context.Context, req *proto.UpsertRequest) (*UpsertedCount, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	if err := pg.loadMeta(ctx, false); err != nil {
+		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
+	}
+
+	table := req.GetTable()
+
+	pks := pg.meta.pks[table]
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("%w for %q without primary keys", ErrCountNotSupported, table)
+	}
+
+	keys, err := pgDistinctKeys(records, pks)
+	if err != nil {
+		return nil, err
+	}
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	columns := make([]string, len(pks))
+	for idx, pk := range pks {
+		columns[idx] = pq.QuoteIdentifier(pk)
+	}
+
+	count := &UpsertedCount{Records: int64(len(keys))}
+
+	for _, partition := range tools.PartitionStructs(pgPartitionSize, keys) {
+		placeholders := tools.SQLIterativePlaceholders(len(pks), len(partition), "$")
+		query := fmt.Sprintf(string(pgCountKeys), pq.QuoteIdentifier(table), strings.Join(columns, ","), placeholders)
+
+		stmt, err := prepareContextFn(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare statement: %w", err)
+		}
+
+		var present int64
+
+		err = stmt.QueryRowContext(ctx, tools.SQLFlattenPartition(pks, partition)...).Scan(&present)
+		stmt.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to count %q: %w", table, err)
+		}
+
+		count.Present += present
+	}
+
+	return count, nil
+}
+
+// ReadTable will return every row of a table as a record, along with the primary keys of the table. If the context has
+// a transaction, the uncommitted rows are read. Tables without primary keys can not be read.
+func (pg *Postgres) ReadTable(ctx context.Context, req *proto.ReadRequest) (*StoredRecords, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
+
+	if err := pg.loadMeta(ctx, false); err != nil {
+		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
+	}
+
+	table := req.GetTable()
+
+	pks := pg.meta.pks[table]
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("%w for %q without primary keys", ErrReadNotSupported, table)
+	}
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	stmt, err := prepareContextFn(ctx, fmt.Sprintf(string(pgReadTable), pq.QuoteIdentifier(table)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	records, err := pgQueryRecords(ctx, stmt, table)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredRecords{PrimaryKeys: pks, Records: records}, nil
+}
+
+// CountTable will return the number of rows of a table. If the context has a transaction, the uncommitted rows are
+// counted.
+func (pg *Postgres) CountTable(ctx context.Context, table string) (int64, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	stmt, err := prepareContextFn(ctx, fmt.Sprintf(string(pgCountTable), pq.QuoteIdentifier(table)))
+	if err != nil {
+		return 0, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	var count int64
+	if err := stmt.QueryRowContext(ctx).Scan(&count); err != nil {
+		return 0, fmt.Errorf("unable to count %q: %w", table, err)
+	}
+
+	return count, nil
+}
+
+// ReadPage will return the rows of a table that follow the primary keys of the last record of the previous page, in
+// the order of the primary keys, along with the primary keys of the table. If the context has a transaction, the
+// uncommitted rows are read. Tables without primary keys can not be read.
+func (pg *Postgres) ReadPage(ctx context.Context, req *ReadPageRequest) (*StoredRecords, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
+
+	if err := pg.loadMeta(ctx, false); err != nil {
+		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
+	}
+
+	pks := pg.meta.pks[req.Table]
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("%w for %q without primary keys", ErrReadNotSupported, req.Table)
+	}
+
+	columns := make([]string, len(pks))
+	for idx, pk := range pks {
+		columns[idx] = pq.QuoteIdentifier(pk)
+	}
+
+	var (
+		where string
+		args  []interface{}
+	)
+
+	// Rows are compared with their primary keys as a row value, so that composite keys are ordered by each column in
+	// turn.
+	if req.After != nil {
+		where = fmt.Sprintf("WHERE (%s) > %s", strings.Join(columns, ","), tools.SQLIterativePlaceholders(len(pks), 1, "$"))
+		args = tools.SQLFlattenPartition(pks, []*structpb.Struct{req.After})
+	}
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	query := fmt.Sprintf(string(pgReadPage), pq.QuoteIdentifier(req.Table), where, strings.Join(columns, ","), req.Limit)
+
+	stmt, err := prepareContextFn(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	records, err := pgQueryRecords(ctx, stmt, req.Table, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoredRecords{PrimaryKeys: pks, Records: records}, nil
+}
+
+// ReadUpserted will return the rows of a table whose primary keys are the keys of the records of an upsert request,
+// along with the primary keys of the table. If the context has a transaction, the uncommitted rows are read. Tables
+// without primary keys can not be read.
+func (pg *Postgres) ReadUpserted(ctx context.Context, req *proto.UpsertRequest) (*StoredRecords, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	if err := pg.loadMeta(ctx, false); err != nil {
+		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
+	}
+
+	table := req.GetTable()
+
+	pks := pg.meta.pks[table]
+	if len(pks) == 0 {
+		return nil, fmt.Errorf("%w for %q without primary keys", ErrReadNotSupported, table)
+	}
+
+	keys, err := pgDistinctKeys(records, pks)
+	if err != nil {
+		return nil, err
+	}
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	columns := make([]string, len(pks))
+	for idx, pk := range pks {
+		columns[idx] = pq.QuoteIdentifier(pk)
+	}
+
+	stored := &StoredRecords{PrimaryKeys: pks}
+
+	for _, partition := range tools.PartitionStructs(pgPartitionSize, keys) {
+		placeholders := tools.SQLIterativePlaceholders(len(pks), len(partition), "$")
+		query := fmt.Sprintf(string(pgReadKeys), pq.QuoteIdentifier(table), strings.Join(columns, ","), placeholders)
+
+		stmt, err := prepareContextFn(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("unable to prepare statement: %w", err)
+		}
+
+		records, err := pgQueryRecords(ctx, stmt, table, tools.SQLFlattenPartition(pks, partition)...)
+		stmt.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		stored.Records = append(stored.Records, records...)
+	}
+
+	return stored, nil
+}
+
+// pgQueryRecords will query the rows of a statement that selects each row as JSON, and return them as records.
+func pgQueryRecords(ctx context.Context, stmt *sql.Stmt, table string, args ...interface{},
+) ([]*structpb.Struct, error) {
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %q: %w", table, err)
+	}
+	defer rows.Close()
+
+	var records []*structpb.Struct
+
+	for rows.Next() {
+		var row string
+		if err := rows.Scan(&row); err != nil {
+			return nil, fmt.Errorf("unable to scan row: %w", err)
+		}
+
+		record := new(structpb.Struct)
+		if err := protojson.Unmarshal([]byte(row), record); err != nil {
+			return nil, fmt.Errorf("unable to decode row: %w", err)
+		}
+
+		records = append(records, record)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read %q: %w", table, err)
+	}
+
+	return records, nil
+}
+
+// getPrepareContextFn will return a function that can prepare an upsert statement for a given table.
+func (pg *Postgres) getPrepareContextFn(ctx context.Context) (sqlPrepareContextFn, error) {
+	// First check to see if a transaction has been assigned to the context. If it has, use the transaction.
+	// Otherwise, use the database.
+	txID, ok := ctx.Value(basicPostgressTxID).(string)
+	if ok {
+		if tx, ok := pg.activeTx.Load(txID); ok {
+			tx, ok := tx.(*sql.Tx)
+			if !ok {
+				return nil, ErrTransactionNotFound
+			}
+
+			return tx.PrepareContext, nil
+		}
+	}
+
+	return pg.DB.PrepareContext, nil
+}
+
+// Upsert will insert the records on the request if they do not exist in the database. On conflict, it will use the
+// PK on the request record to update the data in the database. An upsert request will update the entire table
+// for a given record, include fields that have not been set directly. If the request has a delete field, the existing
+// records of the records that are marked as deleted are deleted instead.
+func (pg *Postgres) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	pg.writeMutex.Lock()
+	defer pg.writeMutex.Unlock()
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	// Do nothing if there are no records.
+	if len(records) == 0 {
+		return &proto.UpsertResponse{}, nil
+	}
+
+	prepareContextFn, err := pg.getPrepareContextFn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get preparer: %w", err)
+	}
+
+	if err := pg.loadMeta(ctx, false); err != nil {
+		return nil, fmt.Errorf("unable to load postgres metadata: %w", err)
+	}
+
+	table := req.GetTable()
+	rsp := new(proto.UpsertResponse)
+
+	if column := req.GetJsonColumn(); column != "" {
+		if !pg.meta.isColumn(table, column) {
+			return nil, fmt.Errorf("%w: %s.%s", ErrInvalidJSONColumn, table, column)
+		}
+
+		if err := pgEmbedRecords(records, column); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := pgEncodeGeometries(records, req.GetGeoFields()); err != nil {
+		return nil, err
+	}
+
+	if err := pgEncodeVectors(records, req.GetVectorFields()); err != nil {
+		return nil, err
+	}
+
+	// Records marked as deleted are merged on servers with MERGE statements. Older servers delete the existing records
+	// of the marked records first, and then upsert the others. Only the last record of a key is written, e.g. the
+	// marker of a record that was deleted after it was updated.
+	upsertGroup := pg.upsertGroup
+
+	if field := req.GetDeleteField(); field != "" {
+		records = pg.meta.lastRecords(table, records)
+
+		if pg.meta.version >= pgMergeVersion {
+			upsertGroup = pg.mergeGroup
+		} else {
+			var marked []*structpb.Struct
+
+			records, marked = pgMarkedRecords(records, field)
+			if err := pg.deleteMarked(ctx, table, prepareContextFn, marked, req, rsp); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, group := range pg.meta.groupRecords(table, records, req.GetMissingFields()) {
+		if err := upsertGroup(ctx, table, prepareContextFn, group, req, rsp); err != nil {
+			return nil, err
+		}
+	}
+
+	rsp.UpsertedCount = rsp.InsertedCount
+	rsp.MatchedCount = rsp.UpdatedCount + rsp.UnchangedCount + rsp.DeletedCount
+
+	return rsp, nil
+}
+
+// lastRecords will return the last record of each primary key of a table, in the order of the records. A MERGE
+// statement can not affect a stored record twice, and the stored record of a key that was upserted and then marked
+// as deleted must be deleted rather than upserted after the delete. Records without their primary keys are kept.
+func (meta *pgmeta) lastRecords(table string, records []*structpb.Struct) []*structpb.Struct {
+	key := tools.CompositeKey{Fields: meta.pks[table]}
+	seen := make(map[string]bool, len(records))
+	last := make([]*structpb.Struct, 0, len(records))
+
+	for idx := len(records) - 1; idx >= 0; idx-- {
+		if id, err := key.StructKey(records[idx]); err == nil {
+			if seen[id] {
+				continue
+			}
+
+			seen[id] = true
+		}
+
+		last = append(last, records[idx])
+	}
+
+	for left, right := 0, len(last)-1; left < right; left, right = left+1, right-1 {
+		last[left], last[right] = last[right], last[left]
+	}
+
+	return last
+}
+
+// pgRecordGroup is a group of records that are upserted into the same columns of a table.
+type pgRecordGroup struct {
+	columns []string
+	records []*structpb.Struct
+}
+
+// groupRecords will group the records of an upsert by the columns they are upserted into. By default, every column of
+// the table is upserted and missing fields are set to NULL. If missing fields are kept, the records are grouped by the
+// columns they have, so that the other columns of existing records are left untouched. Primary keys are always
+// upserted.
+func (meta *pgmeta) groupRecords(table string, records []*structpb.Struct, missingFields string) []*pgRecordGroup {
+	if missingFields != MissingFieldsKeep {
+		return []*pgRecordGroup{{columns: meta.cols[table], records: records}}
+	}
+
+	var groups []*pgRecordGroup
+
+	index := make(map[string]*pgRecordGroup)
+
+	for _, record := range records {
+		var columns []string
+
+		for _, column := range meta.cols[table] {
+			if _, ok := record.GetFields()[column]; ok || meta.isPK(table, column) {
+				columns = append(columns, column)
+			}
+		}
+
+		key := strings.Join(columns, ",")
+		if index[key] == nil {
+			index[key] = &pgRecordGroup{columns: columns}
+			groups = append(groups, index[key])
+		}
+
+		index[key].records = append(index[key].records, record)
+	}
+
+	return groups
+}
+
+// upsertGroup will upsert a group of records into the group's columns, tallying the upserted records on the response.
+func (pg *Postgres) upsertGroup(ctx context.Context, table string, pcf sqlPrepareContextFn, group *pgRecordGroup,
+	req *proto.UpsertRequest, rsp *proto.UpsertResponse,
+) error {
+	// Upsert 1000 records at a time, the maximum number of records that can be inserted in a single statement on a
+	// postgres database.
+	for _, partition := range tools.PartitionStructs(pgPartitionSize, group.records) {
+		stmt, err := pg.meta.upsertStmt(ctx, table, group.columns, pcf, len(partition), req)
+		if err != nil {
+			return fmt.Errorf("unable to prepare statement: %w", err)
+		}
+
+		// Execute upsert.
+		arguments := tools.SQLFlattenPartition(group.columns, partition)
+
+		rows, err := stmt.QueryContext(ctx, arguments...)
+		if err != nil {
+			return pgUpsertError(err)
+		}
+
+		changed, err := pg.scanUpserted(rows, table, req.GetReturnKeys(), rsp)
+		if err != nil {
+			return err
+		}
+
+		rsp.UnchangedCount += int64(len(partition)) - changed
+	}
+
+	return nil
+}
+
+// mergeGroup will merge a group of records into the group's columns with MERGE statements, deleting the existing
+// records that are marked as deleted, and tallying the merged records on the response.
+func (pg *Postgres) mergeGroup(ctx context.Context, table string, pcf sqlPrepareContextFn, group *pgRecordGroup,
+	req *proto.UpsertRequest, rsp *proto.UpsertResponse,
+) error {
+	actions, merge, err := pg.meta.mergeStmts(table, group.columns, req)
+	if err != nil {
+		return fmt.Errorf("unable to build merge statement: %w", err)
+	}
+
+	// The records are passed as a single JSONB array, so the size of a partition is not limited by the maximum
+	// number of parameters of a statement.
+	for _, partition := range tools.PartitionStructs(pgPartitionSize, group.records) {
+		data, err := json.Marshal(partition)
+		if err != nil {
+			return fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		query := merge
+		if actions != "" {
+			query = actions
+		}
+
+		changed, err := pg.queryChanged(ctx, pcf, query, table, req.GetReturnKeys(), rsp, string(data))
+		if err != nil {
+			return err
+		}
+
+		if actions != "" {
+			if err := pg.execStmt(ctx, pcf, merge, string(data)); err != nil {
+				return err
+			}
+		}
+
+		rsp.UnchangedCount += int64(len(partition)) - changed
+	}
+
+	return nil
+}
+
+// pgMarkedRecords will split the records into those that are not marked as deleted and those that are, i.e. whose
+// delete field is true.
+func pgMarkedRecords(records []*structpb.Struct, field string) ([]*structpb.Struct, []*structpb.Struct) {
+	var kept, marked []*structpb.Struct
+
+	for _, record := range records {
+		if record.GetFields()[field].GetBoolValue() {
+			marked = append(marked, record)
+		} else {
+			kept = append(kept, record)
+		}
+	}
+
+	return kept, marked
+}
+
+// deleteMarked will delete the existing records with the primary keys of the records that are marked as deleted,
+// tallying the deleted records on the response. Marked records that do not exist are left unchanged.
+func (pg *Postgres) deleteMarked(ctx context.Context, table string, pcf sqlPrepareContextFn,
+	records []*structpb.Struct, req *proto.UpsertRequest, rsp *proto.UpsertResponse,
+) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	pks := pg.meta.pks[table]
+
+	keys, err := pgDistinctKeys(records, pks)
+	if err != nil {
+		return err
+	}
+
+	var deleted int64
+
+	for _, partition := range tools.PartitionStructs(pgPartitionSize, keys) {
+		query, err := pg.meta.deleteStmt(table, len(partition), req)
+		if err != nil {
+			return fmt.Errorf("unable to build delete statement: %w", err)
+		}
+
+		changed, err := pg.queryChanged(ctx, pcf, query, table, req.GetReturnKeys(), rsp,
+			tools.SQLFlattenPartition(pks, partition)...)
+		if err != nil {
+			return err
+		}
+
+		deleted += changed
+	}
+
+	rsp.UnchangedCount += int64(len(records)) - deleted
+
+	return nil
+}
+
+// queryChanged will run a statement that returns a row for each changed record, where the first column is the action,
+// i.e. "INSERT", "UPDATE" or "DELETE", and tally the rows on the response, returning the number of changed records.
+func (pg *Postgres) queryChanged(ctx context.Context, pcf sqlPrepareContextFn, query, table string, returnKeys bool,
+	rsp *proto.UpsertResponse, args ...interface{},
+) (int64, error) {
+	stmt, err := pcf(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return 0, pgUpsertError(err)
+	}
+
+	return pg.scanChanged(rows, table, returnKeys, rsp)
+}
+
+// execStmt will prepare and execute a statement without any rows.
+func (pg *Postgres) execStmt(ctx context.Context, pcf sqlPrepareContextFn, query string, args ...interface{}) error {
+	stmt, err := pcf(ctx, query)
+	if err != nil {
+		return fmt.Errorf("unable to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, args...); err != nil {
+		return pgUpsertError(err)
+	}
+
+	return nil
+}
+
+// pgUpsertError will wrap an error of an upsert statement, wrapping write conflicts with ErrConflict and rejected data
+// with ErrRejected.
+func pgUpsertError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pgConflictCodes[pqErr.Code] {
+		return fmt.Errorf("unable to execute upsert: %w", ConflictError(err))
+	}
+
+	if errors.As(err, &pqErr) && pgRejectedClasses[pqErr.Code.Class()] {
+		return fmt.Errorf("unable to execute upsert: %w", RejectedError(err))
+	}
+
+	return fmt.Errorf("unable to execute upsert: %w", err)
+}
+
+// scanChanged will tally the rows returned by a merge or delete statement on the response, returning the number of
+// records that were inserted, updated or deleted.
+func (pg *Postgres) scanChanged(rows *sql.Rows, table string, returnKeys bool,
+	rsp *proto.UpsertResponse,
+) (int64, error) {
+	defer rows.Close()
+
+	var changed int64
+
+	pks := pg.meta.pks[table]
+
+	for rows.Next() {
+		var action string
+
+		dest := []interface{}{&action}
+
+		keys := make([]interface{}, len(pks))
+		if returnKeys {
+			for idx := range keys {
+				dest = append(dest, &keys[idx])
+			}
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return 0, fmt.Errorf("unable to scan changed record: %w", err)
+		}
+
+		changed++
+
+		switch action {
+		case "INSERT":
+			rsp.InsertedCount++
+		case "UPDATE":
+			rsp.UpdatedCount++
+		case "DELETE":
+			rsp.DeletedCount++
+		}
+
+		if returnKeys {
+			key, err := pgRecordKey(pks, keys)
+			if err != nil {
+				return 0, err
+			}
+
+			rsp.AffectedKeys = append(rsp.AffectedKeys, key)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("unable to iterate changed records: %w", err)
+	}
+
+	return changed, nil
+}
+
+// scanUpserted will tally the rows returned by an upsert statement on the response, returning the number of records
+// that were inserted or updated.
+func (pg *Postgres) scanUpserted(rows *sql.Rows, table string, returnKeys bool,
+	rsp *proto.UpsertResponse,
+) (int64, error) {
+	defer rows.Close()
+
+	var changed int64
+
+	pks := pg.meta.pks[table]
+
+	for rows.Next() {
+		var inserted bool
+
+		dest := []interface{}{&inserted}
+
+		keys := make([]interface{}, len(pks))
+		if returnKeys {
+			for idx := range keys {
+				dest = append(dest, &keys[idx])
+			}
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return 0, fmt.Errorf("unable to scan upserted record: %w", err)
+		}
+
+		changed++
+
+		if inserted {
+			rsp.InsertedCount++
+		} else {
+			rsp.UpdatedCount++
+		}
+
+		if returnKeys {
+			key, err := pgRecordKey(pks, keys)
+			if err != nil {
+				return 0, err
+			}
+
+			rsp.AffectedKeys = append(rsp.AffectedKeys, key)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("unable to iterate upserted records: %w", err)
+	}
+
+	return changed, nil
+}
+
+// pgEmbedRecords will set a column of each record to the JSON encoding of the entire record, so that a JSONB column
+// can hold records without a fixed schema.
+func pgEmbedRecords(records []*structpb.Struct, column string) error {
+	for _, record := range records {
+		data, err := record.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("unable to encode record as json: %w", err)
+		}
+
+		if record.Fields == nil {
+			record.Fields = make(map[string]*structpb.Value)
+		}
+
+		record.Fields[column] = structpb.NewStringValue(string(data))
+	}
+
+	return nil
+}
+
+// pgEncodeGeometries will encode the GeoJSON geometries of the geo columns of each record as JSON strings.
+func pgEncodeGeometries(records []*structpb.Struct, columns []string) error {
+	for _, record := range records {
+		for _, column := range columns {
+			value, ok := record.GetFields()[column]
+			if !ok || value.GetStructValue() == nil {
+				continue
+			}
+
+			data, err := value.MarshalJSON()
+			if err != nil {
+				return fmt.Errorf("unable to encode geometry as json: %w", err)
+			}
+
+			record.Fields[column] = structpb.NewStringValue(string(data))
+		}
+	}
+
+	return nil
+}
+
+// pgEncodeVectors will encode the arrays of the vector columns of each record as the text of a pgvector vector, e.g.
+// "[1,2,3]".
+func pgEncodeVectors(records []*structpb.Struct, columns []string) error {
+	for _, record := range records {
+		for _, column := range columns {
+			value, ok := record.GetFields()[column]
+			if !ok || value.GetListValue() == nil {
+				continue
+			}
+
+			// protojson output is deliberately unstable, so the vector is encoded with encoding/json.
+			data, err := json.Marshal(value.AsInterface())
+			if err != nil {
+				return fmt.Errorf("unable to encode vector as json: %w", err)
+			}
+
+			record.Fields[column] = structpb.NewStringValue(string(data))
+		}
+	}
+
+	return nil
+}
+
+// pgRecordKey will return the primary key of a record as a struct, given the values scanned for the primary key
+// columns.
+func pgRecordKey(pks []string, values []interface{}) (*structpb.Struct, error) {
+	fields := make(map[string]interface{}, len(pks))
+
+	for idx, pk := range pks {
+		switch value := values[idx].(type) {
+		case []byte:
+			fields[pk] = string(value)
+		case time.Time:
+			fields[pk] = value.Format(time.RFC3339Nano)
+		default:
+			fields[pk] = value
+		}
+	}
+
+	key, err := structpb.NewStruct(fields)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build record key: %w", err)
+	}
+
+	return key, nil
+}
+
+// PostgresGSS is a GSSAPI security context that authenticates Postgres connections, e.g. with Kerberos.
+type PostgresGSS = pq.GSS
+
+var (
+	// postgresGSS is the GSSAPI provider of Postgres connections, which is read by the provider that is registered
+	// with the driver.
+	postgresGSS   func() (PostgresGSS, error)
+	postgresGSSMu sync.RWMutex
+
+	registerPostgresGSSOnce sync.Once
+)
+
+// RegisterPostgresGSS will set the GSSAPI provider of Postgres connections, for servers that require "gss"
+// authentication. The provider is shared by every Postgres connection of the process. The driver's provider is
+// registered once, the first time a provider is set, and later calls only replace the provider it uses.
+func RegisterPostgresGSS(newGSS func() (PostgresGSS, error)) {
+	postgresGSSMu.Lock()
+	postgresGSS = newGSS
+	postgresGSSMu.Unlock()
+
+	registerPostgresGSSOnce.Do(func() { pq.RegisterGSSProvider(newPostgresGSS) })
+}
+
+// newPostgresGSS will create a security context with the provider that is set by RegisterPostgresGSS.
+func newPostgresGSS() (PostgresGSS, error) {
+	postgresGSSMu.RLock()
+	newGSS := postgresGSS
+	postgresGSSMu.RUnlock()
+
+	if newGSS == nil {
+		return nil, fmt.Errorf("a GSSAPI provider is required for gss authentication")
+	}
+
+	return newGSS()
+}
+
+// Postgres is a wrapper around the sql.DB object.
+type Postgres struct {
+	*sql.DB
+
+	// meta hold metdata about the database.
+	meta *pgmeta
+
+	metaMutex  sync.Mutex
+	writeMutex sync.Mutex
+
+	// activeTx are the transactions that are currently active on this connection. When a user calls "StartTx" on
+	// a Postgres intance, a transaction is created and added to this map. Afterward, if the user calls a write
+	// method (e.g. Insert, Update, Delete, Upsert), the transaction will be used to execute the query. In order for
+	// the write method to know which transaction to use, a context with the transaction ID must be passed into
+	// the method. The transaction ID is added to the context in the "StartTx" method. The transaction ID is
+	// removed from the context in the "CommitTx" and "RollbackTx" methods.
+	activeTx sync.Map
+}
+
+// NewPostgres will return a new Postgres option for querying data through a Postgres DB.
+func NewPostgres(ctx context.Context, connectionURL string) (*Postgres, error) {
+	postgres := new(Postgres)
+
+	var err error
+
+	postgres.DB, err = sql.Open("postgres", connectionURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to postgres: %w", err)
 	}
 
 	postgres.setMaxOpenConns()
@@ -354,6 +1705,9 @@ func NewPostgres(ctx context.Context, connectionURL string) (*Postgres, error) {
 // IsNoSQL returns "false" to indicate that "Postgres" is not a NoSQL database.
 func (pg *Postgres) IsNoSQL() bool { return false }
 
+// Versioned returns "true" to indicate that "Postgres" only overwrites stored records with newer versions.
+func (pg *Postgres) Versioned() bool { return true }
+
 // Type implements the storage interface.
 func (pg *Postgres) Type() uint8 { return PostgresType }
 
@@ -401,11 +1755,7 @@ func (pg *Postgres) setMaxOpenConns() {
 // to commit or rollback the transaction.
 func (pg *Postgres) StartTx(ctx context.Context) (*Txn, error) {
 	// Construct a gidari storage transaction.
-	txn := &Txn{
-		make(chan TxnChanFn),
-		make(chan error, 1),
-		make(chan bool, 1),
-	}
+	txn := newTxn()
 
 	// Instantiate a new transaction on the Postgres connection and store it in the activeTx map.
 	txnID := uuid.New().String()
@@ -426,13 +1776,7 @@ func (pg *Postgres) StartTx(ctx context.Context) (*Txn, error) {
 			pg.activeTx.Delete(txnID)
 		}()
 
-		for fn := range txn.ch {
-			if err != nil {
-				continue
-			}
-
-			err = fn(pgCtx, pg)
-		}
+		err = txn.receive(func(fn TxnChanFn) error { return fn(pgCtx, pg) })
 
 		if err != nil {
 			txn.done <- err
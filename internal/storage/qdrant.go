@@ -0,0 +1,444 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
+)
+
+const (
+	// qdrantDefaultVector is the default field of the records that holds their vector.
+	qdrantDefaultVector = "embedding"
+
+	// qdrantDefaultPrimaryKey is the default primary key of the records of a table.
+	qdrantDefaultPrimaryKey = "id"
+
+	// qdrantDefaultDistance is the default distance function of the collections.
+	qdrantDefaultDistance = "Cosine"
+
+	// qdrantPartitionSize is the number of points that are upserted with a single request.
+	qdrantPartitionSize = 1000
+)
+
+var (
+	ErrQdrant            = fmt.Errorf("qdrant error")
+	ErrMissingVector     = fmt.Errorf("record is missing a vector")
+	ErrMissingPrimaryKey = fmt.Errorf("record is missing a primary key")
+)
+
+// QdrantError wraps an error of the Qdrant API with ErrQdrant.
+func QdrantError(status int, message string) error {
+	return fmt.Errorf("%w %d: %s", ErrQdrant, status, message)
+}
+
+// Qdrant is a storage device for the Qdrant vector database, for retrieval over API data. Each table is a collection,
+// which is created with the size of the first vector that is upserted into it. Each record is a point, whose vector is
+// the record's vector field and whose payload is the rest of the record. The ID of a point is a UUID derived from the
+// record's primary keys, so that records are upserted on them.
+//
+// The connection string is "qdrant://[apiKey@]host[:port][?vector=embedding&primaryKey=id&distance=Cosine&tls=true]".
+// The port defaults to 6333, and the vector field to the request's first vector field or "embedding".
+type Qdrant struct {
+	client      *http.Client
+	endpoint    string
+	apiKey      string
+	vector      string
+	primaryKeys []string
+	distance    string
+
+	// collections are the collections that are known to exist.
+	collections sync.Map
+}
+
+// qdrantResponse is a response of the Qdrant API.
+type qdrantResponse struct {
+	Result json.RawMessage `json:"result"`
+	Status json.RawMessage `json:"status"`
+}
+
+// NewQdrant will return a new storage device for the Qdrant server of the connection string.
+func NewQdrant(ctx context.Context, dns string) (*Qdrant, error) {
+	uri, err := url.Parse(dns)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse connection string: %w", err)
+	}
+
+	query := uri.Query()
+
+	scheme := "http"
+	if query.Get("tls") == "true" {
+		scheme = "https"
+	}
+
+	host := uri.Host
+	if uri.Port() == "" {
+		host += ":6333"
+	}
+
+	stg := &Qdrant{
+		client:      http.DefaultClient,
+		endpoint:    scheme + "://" + host,
+		apiKey:      uri.User.Username(),
+		vector:      qdrantDefaultVector,
+		primaryKeys: []string{qdrantDefaultPrimaryKey},
+		distance:    qdrantDefaultDistance,
+	}
+
+	if vector := query.Get("vector"); vector != "" {
+		stg.vector = vector
+	}
+
+	if keys := query.Get("primaryKey"); keys != "" {
+		stg.primaryKeys = strings.Split(keys, ",")
+	}
+
+	if distance := query.Get("distance"); distance != "" {
+		stg.distance = distance
+	}
+
+	if _, _, err := stg.do(ctx, http.MethodGet, "/collections", nil); err != nil {
+		return nil, fmt.Errorf("unable to connect to qdrant: %w", err)
+	}
+
+	return stg, nil
+}
+
+// IsNoSQL returns "true" to indicate that "Qdrant" is a NoSQL storage device.
+func (stg *Qdrant) IsNoSQL() bool { return true }
+
+// Type implements the storage interface.
+func (stg *Qdrant) Type() uint8 { return QdrantType }
+
+// Close will close the idle connections to the Qdrant server.
+func (stg *Qdrant) Close() {
+	stg.client.CloseIdleConnections()
+}
+
+// do will make a request to the Qdrant API, returning the result and the status of the response. Responses with a
+// status of 404 are returned to be handled by the caller, other failures are returned as errors.
+func (stg *Qdrant) do(ctx context.Context, method, path string, body interface{}) (json.RawMessage, int, error) {
+	var data []byte
+
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, stg.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if stg.apiKey != "" {
+		req.Header.Set("api-key", stg.apiKey)
+	}
+
+	httpRsp, err := stg.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to request %q: %w", path, err)
+	}
+	defer httpRsp.Body.Close()
+
+	rspData, err := io.ReadAll(httpRsp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to read response of %q: %w", path, err)
+	}
+
+	if httpRsp.StatusCode == http.StatusNotFound {
+		return nil, httpRsp.StatusCode, nil
+	}
+
+	rsp := new(qdrantResponse)
+	if err := json.Unmarshal(rspData, rsp); err != nil && httpRsp.StatusCode < http.StatusMultipleChoices {
+		return nil, 0, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	if httpRsp.StatusCode >= http.StatusMultipleChoices {
+		var status struct {
+			Error string `json:"error"`
+		}
+
+		if json.Unmarshal(rsp.Status, &status) != nil || status.Error == "" {
+			status.Error = string(rspData)
+		}
+
+		if rejectedStatus(httpRsp.StatusCode) {
+			return nil, 0, RejectedError(QdrantError(httpRsp.StatusCode, status.Error))
+		}
+
+		return nil, 0, QdrantError(httpRsp.StatusCode, status.Error)
+	}
+
+	return rsp.Result, httpRsp.StatusCode, nil
+}
+
+// collectionPath will return the path of the collection of a table.
+func collectionPath(table string) string {
+	return "/collections/" + url.PathEscape(table)
+}
+
+// ensureCollection will create the collection of a table for vectors of a size, if it does not exist.
+func (stg *Qdrant) ensureCollection(ctx context.Context, table string, size int) error {
+	if _, ok := stg.collections.Load(table); ok {
+		return nil
+	}
+
+	_, status, err := stg.do(ctx, http.MethodGet, collectionPath(table), nil)
+	if err == nil && status == http.StatusNotFound {
+		_, _, err = stg.do(ctx, http.MethodPut, collectionPath(table), map[string]interface{}{
+			"vectors": map[string]interface{}{"size": size, "distance": stg.distance},
+		})
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to create collection %q: %w", table, err)
+	}
+
+	stg.collections.Store(table, true)
+
+	return nil
+}
+
+// qdrantPointID will return the ID of the point of a record, a UUID derived from the record's primary keys.
+func qdrantPointID(rec map[string]interface{}, primaryKeys []string) (string, error) {
+	keys := make([]interface{}, len(primaryKeys))
+	for idx, pk := range primaryKeys {
+		if rec[pk] == nil {
+			return "", RejectedError(fmt.Errorf("%w: %q", ErrMissingPrimaryKey, pk))
+		}
+
+		keys[idx] = rec[pk]
+	}
+
+	data, err := json.Marshal(keys)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, data).String(), nil
+}
+
+// qdrantPoint is a point of a collection.
+type qdrantPoint struct {
+	ID      string                 `json:"id"`
+	Vector  []interface{}          `json:"vector"`
+	Payload map[string]interface{} `json:"payload"`
+}
+
+// qdrantPoints will return the points of the records of an upsert request.
+func (stg *Qdrant) qdrantPoints(req *proto.UpsertRequest) ([]*qdrantPoint, error) {
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode records: %w", err)
+	}
+
+	vectorField := stg.vector
+	if fields := req.GetVectorFields(); len(fields) > 0 {
+		vectorField = fields[0]
+	}
+
+	points := make([]*qdrantPoint, 0, len(records))
+
+	for _, record := range records {
+		rec := record.AsMap()
+
+		vector, ok := rec[vectorField].([]interface{})
+		if !ok || len(vector) == 0 {
+			return nil, RejectedError(fmt.Errorf("%w: %q", ErrMissingVector, vectorField))
+		}
+
+		id, err := qdrantPointID(rec, stg.primaryKeys)
+		if err != nil {
+			return nil, err
+		}
+
+		delete(rec, vectorField)
+
+		points = append(points, &qdrantPoint{ID: id, Vector: vector, Payload: rec})
+	}
+
+	return points, nil
+}
+
+// existingPoints will return the number of the points that already exist in the collection of a table.
+func (stg *Qdrant) existingPoints(ctx context.Context, table string, points []*qdrantPoint) (int64, error) {
+	ids := make([]string, len(points))
+	for idx, point := range points {
+		ids[idx] = point.ID
+	}
+
+	result, _, err := stg.do(ctx, http.MethodPost, collectionPath(table)+"/points", map[string]interface{}{
+		"ids":          ids,
+		"with_payload": false,
+		"with_vector":  false,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unable to retrieve points: %w", err)
+	}
+
+	var existing []json.RawMessage
+	if err := json.Unmarshal(result, &existing); err != nil {
+		return 0, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	return int64(len(existing)), nil
+}
+
+// Upsert will upsert the records as points of the collection of the table, which is created if it does not exist.
+// Points that already existed are reported as matched.
+func (stg *Qdrant) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	points, err := stg.qdrantPoints(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := new(proto.UpsertResponse)
+	if len(points) == 0 {
+		return rsp, nil
+	}
+
+	if err := stg.ensureCollection(ctx, req.GetTable(), len(points[0].Vector)); err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(points); start += qdrantPartitionSize {
+		partition := points[start:minInt(start+qdrantPartitionSize, len(points))]
+
+		existing, err := stg.existingPoints(ctx, req.GetTable(), partition)
+		if err != nil {
+			return nil, err
+		}
+
+		_, _, err = stg.do(ctx, http.MethodPut, collectionPath(req.GetTable())+"/points?wait=true",
+			map[string]interface{}{"points": partition})
+		if err != nil {
+			return nil, fmt.Errorf("unable to upsert points: %w", err)
+		}
+
+		rsp.MatchedCount += existing
+		rsp.UpsertedCount += int64(len(partition)) - existing
+	}
+
+	rsp.InsertedCount = rsp.UpsertedCount
+
+	return rsp, nil
+}
+
+// Truncate will delete the collections of the tables, which are recreated when they are next written to.
+func (stg *Qdrant) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
+	rsp := new(proto.TruncateResponse)
+
+	for _, table := range req.GetTables() {
+		result, status, err := stg.do(ctx, http.MethodGet, collectionPath(table), nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get collection %q: %w", table, err)
+		}
+
+		if status == http.StatusNotFound {
+			continue
+		}
+
+		var info struct {
+			PointsCount int32 `json:"points_count"`
+		}
+
+		if err := json.Unmarshal(result, &info); err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+		}
+
+		if _, _, err := stg.do(ctx, http.MethodDelete, collectionPath(table), nil); err != nil {
+			return nil, fmt.Errorf("unable to delete collection %q: %w", table, err)
+		}
+
+		stg.collections.Delete(table)
+
+		rsp.DeletedCount += info.PointsCount
+	}
+
+	return rsp, nil
+}
+
+// tables will return the names of the collections.
+func (stg *Qdrant) tables(ctx context.Context) ([]string, error) {
+	result, _, err := stg.do(ctx, http.MethodGet, "/collections", nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list collections: %w", err)
+	}
+
+	var list struct {
+		Collections []struct {
+			Name string `json:"name"`
+		} `json:"collections"`
+	}
+
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	tables := make([]string, 0, len(list.Collections))
+	for _, coll := range list.Collections {
+		tables = append(tables, coll.Name)
+	}
+
+	return tables, nil
+}
+
+// ListTables will return the collections. Their size is not known.
+func (stg *Qdrant) ListTables(ctx context.Context) (*proto.ListTablesResponse, error) {
+	tables, err := stg.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+	for _, table := range tables {
+		rsp.TableSet[table] = &proto.Table{}
+	}
+
+	return rsp, nil
+}
+
+// ListPrimaryKeys will return the primary keys of the collections, which are the same for every collection.
+func (stg *Qdrant) ListPrimaryKeys(ctx context.Context) (*proto.ListPrimaryKeysResponse, error) {
+	tables, err := stg.tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp := &proto.ListPrimaryKeysResponse{PKSet: make(map[string]*proto.PrimaryKeys)}
+	for _, table := range tables {
+		rsp.PKSet[table] = &proto.PrimaryKeys{List: stg.primaryKeys}
+	}
+
+	return rsp, nil
+}
+
+// Untransacted will return true, since the points of a transaction are upserted as they are received.
+func (stg *Qdrant) Untransacted() bool { return true }
+
+// StartTx will start a transaction. Qdrant has no transactions, so the points are upserted as they are received and
+// are not removed by a rollback.
+func (stg *Qdrant) StartTx(ctx context.Context) (*Txn, error) {
+	return startUntransactedTx(ctx, stg), nil
+}
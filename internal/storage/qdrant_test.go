@@ -0,0 +1,209 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// fakeQdrant is a Qdrant API that holds the points of its collections in memory.
+type fakeQdrant struct {
+	mu          sync.Mutex
+	collections map[string]map[string]qdrantPoint
+	sizes       map[string]int
+}
+
+func (srv *fakeQdrant) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if req.Header.Get("api-key") != "secret" {
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"status":{"error":"forbidden"}}`))
+
+		return
+	}
+
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+	if len(parts) == 1 {
+		names := make([]string, 0, len(srv.collections))
+		for name := range srv.collections {
+			names = append(names, `{"name":"`+name+`"}`)
+		}
+
+		w.Write([]byte(`{"result":{"collections":[` + strings.Join(names, ",") + `]},"status":"ok"}`))
+
+		return
+	}
+
+	coll, ok := srv.collections[parts[1]]
+
+	switch {
+	case len(parts) == 2 && req.Method == http.MethodPut:
+		var body struct {
+			Vectors struct {
+				Size int `json:"size"`
+			} `json:"vectors"`
+		}
+
+		json.NewDecoder(req.Body).Decode(&body)
+
+		srv.collections[parts[1]] = make(map[string]qdrantPoint)
+		srv.sizes[parts[1]] = body.Vectors.Size
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+
+		return
+	case len(parts) == 2 && req.Method == http.MethodDelete:
+		delete(srv.collections, parts[1])
+	case len(parts) == 2:
+		w.Write([]byte(`{"result":{"points_count":` + strconv.Itoa(len(coll)) + `},"status":"ok"}`))
+
+		return
+	case req.Method == http.MethodPost:
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+
+		json.NewDecoder(req.Body).Decode(&body)
+
+		found := []string{}
+		for _, id := range body.IDs {
+			if _, ok := coll[id]; ok {
+				found = append(found, `{"id":"`+id+`"}`)
+			}
+		}
+
+		w.Write([]byte(`{"result":[` + strings.Join(found, ",") + `],"status":"ok"}`))
+
+		return
+	default:
+		var body struct {
+			Points []qdrantPoint `json:"points"`
+		}
+
+		json.NewDecoder(req.Body).Decode(&body)
+
+		for _, point := range body.Points {
+			if len(point.Vector) != srv.sizes[parts[1]] {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"status":{"error":"wrong vector size"}}`))
+
+				return
+			}
+
+			coll[point.ID] = point
+		}
+	}
+
+	w.Write([]byte(`{"result":true,"status":"ok"}`))
+}
+
+func TestQdrant(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	srv := &fakeQdrant{collections: make(map[string]map[string]qdrantPoint), sizes: make(map[string]int)}
+
+	httpSrv := httptest.NewServer(srv)
+	defer httpSrv.Close()
+
+	dns := "qdrant://secret@" + strings.TrimPrefix(httpSrv.URL, "http://") + "?vector=vec"
+
+	stg, err := NewQdrant(ctx, dns)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer stg.Close()
+
+	upsert := func(data string) (*proto.UpsertResponse, error) {
+		return stg.Upsert(ctx, &proto.UpsertRequest{
+			Table:    "docs",
+			Data:     []byte(data),
+			DataType: int32(tools.UpsertDataJSON),
+		})
+	}
+
+	rsp, err := upsert(`[{"id":1,"text":"a","vec":[1,0]},{"id":2,"text":"b","vec":[0,1]}]`)
+	if err != nil {
+		t.Fatalf("failed to upsert: %v", err)
+	}
+
+	if rsp.UpsertedCount != 2 || rsp.MatchedCount != 0 {
+		t.Fatalf("unexpected response: %v", rsp)
+	}
+
+	rsp, err = upsert(`[{"id":2,"text":"c","vec":[1,1]},{"id":3,"text":"d","vec":[0,0]}]`)
+	if err != nil {
+		t.Fatalf("failed to upsert: %v", err)
+	}
+
+	if rsp.UpsertedCount != 1 || rsp.MatchedCount != 1 {
+		t.Fatalf("unexpected response: %v", rsp)
+	}
+
+	if srv.sizes["docs"] != 2 || len(srv.collections["docs"]) != 3 {
+		t.Fatalf("unexpected collection: %v", srv.collections["docs"])
+	}
+
+	for _, point := range srv.collections["docs"] {
+		if _, ok := point.Payload["vec"]; ok || point.Payload["text"] == nil {
+			t.Fatalf("unexpected payload: %v", point.Payload)
+		}
+	}
+
+	if _, err := upsert(`[{"id":4,"text":"e"}]`); !errors.Is(err, ErrMissingVector) {
+		t.Fatalf("expected error %v, got %v", ErrMissingVector, err)
+	}
+
+	if _, err := upsert(`[{"text":"e","vec":[1,0]}]`); !errors.Is(err, ErrMissingPrimaryKey) {
+		t.Fatalf("expected error %v, got %v", ErrMissingPrimaryKey, err)
+	}
+
+	if _, err := upsert(`[{"id":4,"vec":[1,0,0]}]`); !errors.Is(err, ErrQdrant) {
+		t.Fatalf("expected error %v, got %v", ErrQdrant, err)
+	}
+
+	tables, err := stg.ListTables(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tables: %v", err)
+	}
+
+	if _, ok := tables.TableSet["docs"]; !ok {
+		t.Fatalf("unexpected tables: %v", tables.TableSet)
+	}
+
+	trsp, err := stg.Truncate(ctx, &proto.TruncateRequest{Tables: []string{"docs", "missing"}})
+	if err != nil {
+		t.Fatalf("failed to truncate: %v", err)
+	}
+
+	if trsp.DeletedCount != 3 || srv.collections["docs"] != nil {
+		t.Fatalf("unexpected truncate response: %v", trsp)
+	}
+
+	// The collection is recreated on the next write.
+	if _, err := upsert(`[{"id":1,"vec":[1,0,0]}]`); err != nil || srv.sizes["docs"] != 3 {
+		t.Fatalf("failed to recreate collection: %v", err)
+	}
+}
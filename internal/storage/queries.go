@@ -17,5 +17,38 @@ var pgColumns []byte
 //go:embed queries/pg_truncate_tables.sql
 var pgTruncatedTables []byte
 
+//go:embed queries/pg_truncate_range.sql
+var pgTruncateRange []byte
+
+//go:embed queries/pg_buckets.sql
+var pgBuckets []byte
+
+//go:embed queries/pg_null_rate.sql
+var pgNullRate []byte
+
+//go:embed queries/pg_duplicates.sql
+var pgDuplicates []byte
+
+//go:embed queries/pg_count_keys.sql
+var pgCountKeys []byte
+
+//go:embed queries/pg_read_table.sql
+var pgReadTable []byte
+
+//go:embed queries/pg_read_keys.sql
+var pgReadKeys []byte
+
+//go:embed queries/pg_read_page.sql
+var pgReadPage []byte
+
+//go:embed queries/pg_count_table.sql
+var pgCountTable []byte
+
 //go:embed queries/pg_garbage_collect.sql
 var pgGarbageCollect []byte
+
+//go:embed queries/mssql_columns.sql
+var mssqlColumns []byte
+
+//go:embed queries/oracle_columns.sql
+var oracleColumns []byte
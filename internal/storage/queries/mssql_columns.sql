@@ -0,0 +1,35 @@
+SELECT c.COLUMN_NAME,
+       c.TABLE_NAME,
+       CASE
+           WHEN EXISTS
+                (
+                    SELECT 1
+                    FROM INFORMATION_SCHEMA.TABLE_CONSTRAINTS tc
+                        INNER JOIN INFORMATION_SCHEMA.KEY_COLUMN_USAGE k
+                            ON k.CONSTRAINT_NAME = tc.CONSTRAINT_NAME
+                               AND k.TABLE_SCHEMA = tc.TABLE_SCHEMA
+                    WHERE tc.CONSTRAINT_TYPE = 'PRIMARY KEY'
+                          AND k.TABLE_SCHEMA = c.TABLE_SCHEMA
+                          AND k.TABLE_NAME = c.TABLE_NAME
+                          AND k.COLUMN_NAME = c.COLUMN_NAME
+                ) THEN
+               1
+           ELSE
+               0
+       END AS primary_key,
+       COALESCE(
+       (
+           SELECT SUM(a.used_pages) * 8192
+           FROM sys.partitions p
+               INNER JOIN sys.allocation_units a
+                   ON a.container_id = p.partition_id
+           WHERE p.object_id = OBJECT_ID(QUOTENAME(c.TABLE_SCHEMA) + '.' + QUOTENAME(c.TABLE_NAME))
+       ), 0) AS bytes
+FROM INFORMATION_SCHEMA.COLUMNS c
+    INNER JOIN INFORMATION_SCHEMA.TABLES t
+        ON t.TABLE_SCHEMA = c.TABLE_SCHEMA
+           AND t.TABLE_NAME = c.TABLE_NAME
+WHERE t.TABLE_TYPE = 'BASE TABLE'
+      AND c.TABLE_SCHEMA = SCHEMA_NAME()
+ORDER BY c.TABLE_NAME,
+         c.ORDINAL_POSITION
@@ -0,0 +1,38 @@
+SELECT CASE
+           WHEN c.column_name = UPPER(c.column_name) THEN
+               LOWER(c.column_name)
+           ELSE
+               c.column_name
+       END AS column_name,
+       CASE
+           WHEN c.table_name = UPPER(c.table_name) THEN
+               LOWER(c.table_name)
+           ELSE
+               c.table_name
+       END AS table_name,
+       CASE
+           WHEN EXISTS
+                (
+                    SELECT 1
+                    FROM user_constraints uc
+                        INNER JOIN user_cons_columns k
+                            ON k.constraint_name = uc.constraint_name
+                    WHERE uc.constraint_type = 'P'
+                          AND k.table_name = c.table_name
+                          AND k.column_name = c.column_name
+                ) THEN
+               1
+           ELSE
+               0
+       END AS primary_key,
+       COALESCE(
+       (
+           SELECT SUM(s.bytes)
+           FROM user_segments s
+           WHERE s.segment_name = c.table_name
+       ), 0) AS bytes
+FROM user_tab_columns c
+    INNER JOIN user_tables t
+        ON t.table_name = c.table_name
+ORDER BY c.table_name,
+         c.column_id
@@ -0,0 +1,4 @@
+SELECT DISTINCT floor((extract(epoch FROM %[2]s::timestamptz) - $3) / $4)::bigint AS bucket
+FROM %[1]s
+WHERE %[2]s::timestamptz >= $1 AND %[2]s::timestamptz < $2
+ORDER BY bucket;
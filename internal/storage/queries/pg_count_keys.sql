@@ -0,0 +1 @@
+SELECT COUNT(*) FROM %[1]s WHERE (%[2]s) IN (%[3]s);
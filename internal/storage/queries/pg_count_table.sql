@@ -0,0 +1 @@
+SELECT count(*) FROM %[1]s;
@@ -0,0 +1 @@
+SELECT COUNT(*)::float8 FROM (SELECT 1 FROM %[1]s GROUP BY %[2]s HAVING COUNT(*) > 1) AS duplicates;
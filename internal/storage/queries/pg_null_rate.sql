@@ -0,0 +1 @@
+SELECT COALESCE(1 - COUNT(%[2]s)::float8 / NULLIF(COUNT(*), 0), 0) FROM %[1]s;
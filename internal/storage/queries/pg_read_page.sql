@@ -0,0 +1 @@
+SELECT row_to_json(t)::text FROM %[1]s AS t %[2]s ORDER BY %[3]s LIMIT %[4]d;
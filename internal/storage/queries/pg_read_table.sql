@@ -0,0 +1 @@
+SELECT row_to_json(t)::text FROM %[1]s AS t;
@@ -0,0 +1 @@
+DELETE FROM %[1]s WHERE %[2]s::timestamptz >= $1 AND %[2]s::timestamptz < $2;
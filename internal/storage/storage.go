@@ -10,10 +10,15 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/alpine-hodler/gidari/proto"
+	"github.com/apache/arrow/go/v12/arrow"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 const (
@@ -22,12 +27,58 @@ const (
 
 	// PostgresType is the byte representation of a postgres database.
 	PostgresType
+
+	// NATSType is the byte representation of a NATS JetStream server.
+	NATSType
+
+	// CosmosDBType is the byte representation of an Azure Cosmos DB database.
+	CosmosDBType
+
+	// MSSQLType is the byte representation of a SQL Server database.
+	MSSQLType
+
+	// OracleType is the byte representation of an Oracle database.
+	OracleType
+
+	// Neo4jType is the byte representation of a Neo4j graph database.
+	Neo4jType
+
+	// QdrantType is the byte representation of a Qdrant vector database.
+	QdrantType
+
+	// ParquetType is the byte representation of a directory of Parquet files.
+	ParquetType
+)
+
+const (
+	// MissingFieldsKeep leaves the stored values of the fields that are missing from an upserted record untouched.
+	MissingFieldsKeep = "keep"
+
+	// MissingFieldsNull clears the stored values of the fields that are missing from an upserted record, i.e. they
+	// are set to NULL on SQL storage and removed from the document on NoSQL storage.
+	MissingFieldsNull = "null"
 )
 
 var (
+	ErrConflict            = fmt.Errorf("storage conflict")
+	ErrRejected            = fmt.Errorf("storage rejected the data")
 	ErrDNSNotSupported     = fmt.Errorf("dns is not supported")
 	ErrTransactionNotFound = fmt.Errorf("transaction not found")
 	ErrNoTables            = fmt.Errorf("no tables found")
+	ErrRangeNotSupported   = fmt.Errorf("range truncate is not supported")
+	ErrVersionNotSupported = fmt.Errorf("version field is not supported")
+	ErrNotifyNotSupported  = fmt.Errorf("notify is not supported")
+	ErrMeasureNotSupported = fmt.Errorf("measure is not supported")
+	ErrCountNotSupported   = fmt.Errorf("count is not supported")
+	ErrBucketsNotSupported = fmt.Errorf("buckets are not supported")
+	ErrReadNotSupported    = fmt.Errorf("read is not supported")
+	ErrSchemaNotSupported  = fmt.Errorf("schema is not supported")
+	ErrInvalidMeasurement  = fmt.Errorf("invalid measurement")
+	ErrInvalidVersionField = fmt.Errorf("version field is not a column of the table")
+	ErrInvalidJSONColumn   = fmt.Errorf("json column is not a column of the table")
+	ErrInvalidGeoColumn    = fmt.Errorf("geo field is not a column of the table")
+	ErrInvalidVectorColumn = fmt.Errorf("vector field is not a column of the table")
+	ErrInvalidCollationKey = fmt.Errorf("collation key is not a primary key of the table")
 	ErrTransactionAborted  = fmt.Errorf("transaction aborted")
 )
 
@@ -36,6 +87,53 @@ func DNSNotSupportedError(dns string) error {
 	return fmt.Errorf("%w: %s", ErrDNSNotSupported, dns)
 }
 
+// conflictError is a write conflict. It matches ErrConflict while preserving the error of the storage device for
+// "errors.Is" and "errors.As", e.g. to inspect the driver's error code.
+type conflictError struct{ err error }
+
+func (cerr *conflictError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrConflict, cerr.err)
+}
+
+func (cerr *conflictError) Unwrap() error { return cerr.err }
+
+func (cerr *conflictError) Is(target error) bool {
+	return errors.Is(target, ErrConflict)
+}
+
+// ConflictError wraps an error with ErrConflict. Conflicts are write failures caused by concurrent or duplicate
+// writes, such as unique key violations, write conflicts, or deadlocks.
+func ConflictError(err error) error {
+	return &conflictError{err: err}
+}
+
+// rejectedError is a write that the storage device rejected because of its data. It matches ErrRejected while
+// preserving the error of the storage device for "errors.Is" and "errors.As".
+type rejectedError struct{ err error }
+
+func (rerr *rejectedError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrRejected, rerr.err)
+}
+
+func (rerr *rejectedError) Unwrap() error { return rerr.err }
+
+func (rerr *rejectedError) Is(target error) bool {
+	return errors.Is(target, ErrRejected)
+}
+
+// RejectedError wraps an error with ErrRejected. Rejections are write failures that writing the same data again can
+// not fix, such as constraint violations, invalid values, or columns that are not in the table, unlike connection
+// failures and timeouts.
+func RejectedError(err error) error {
+	return &rejectedError{err: err}
+}
+
+// rejectedStatus will return true if the HTTP status of a storage API rejects the data of the request.
+func rejectedStatus(status int) bool {
+	return status == http.StatusBadRequest || status == http.StatusRequestEntityTooLarge ||
+		status == http.StatusUnprocessableEntity
+}
+
 // Storage is an interface that defines the methods that a storage device should implement.
 type Storage interface {
 	// Close will disconnect the storage device.
@@ -65,6 +163,216 @@ type Storage interface {
 	Upsert(context.Context, *proto.UpsertRequest) (*proto.UpsertResponse, error)
 }
 
+// BatchUpserter is an optional interface for storage devices that can consume Apache Arrow record batches directly,
+// such as Parquet files and other columnar stores. Storage devices that implement it are sent each chunk of upsert
+// data as a record batch instead of as JSON-encoded records.
+type BatchUpserter interface {
+	// UpsertBatch will insert or update an Arrow record batch in a table.
+	UpsertBatch(ctx context.Context, table string, rec arrow.Record) (*proto.UpsertResponse, error)
+}
+
+// DatabaseSelector is an optional interface for storage devices that can write to several databases over one
+// connection, such as MongoDB. The database of a request overrides the selected database.
+type DatabaseSelector interface {
+	// SelectDatabase will select the database that requests without a database use, instead of the database of the
+	// connection string.
+	SelectDatabase(name string)
+}
+
+// Untransacted is an optional interface for storage devices whose deployment may not support transactions. It reports
+// whether the writes sent to the transactions of the device run as they are received, so that a rollback does not
+// remove them.
+type Untransacted interface {
+	Untransacted() bool
+}
+
+// Versioner is an optional interface for storage devices that honor the version field of upsert requests, so that a
+// stored record is only overwritten by a newer version of it. The conflict policies of synced tables rely on it.
+type Versioner interface {
+	// Versioned will return true if the upserted records are compared with the versions of the stored records.
+	Versioned() bool
+}
+
+// TruncateRangeRequest is a request to delete the records of a table whose time column is within a time window.
+type TruncateRangeRequest struct {
+	// Table is the name of the table/collection to delete records from.
+	Table string
+
+	// Column is the name of the time column of the records.
+	Column string
+
+	// Start is the inclusive start of the time window.
+	Start time.Time
+
+	// End is the exclusive end of the time window.
+	End time.Time
+
+	// Database is the database of the table, for storage that can write to several databases over one connection.
+	// If empty, the database of the connection is used.
+	Database string
+}
+
+// RangeTruncater is an optional interface for storage devices that can delete the records of a table within a time
+// window, so that a window can be re-ingested without deleting the rest of the table.
+type RangeTruncater interface {
+	// TruncateRange will delete the records of a table whose time column is within the time window.
+	TruncateRange(context.Context, *TruncateRangeRequest) (*proto.TruncateResponse, error)
+}
+
+// NotifyRequest is a request to notify the listeners of a channel.
+type NotifyRequest struct {
+	// Channel is the name of the channel to notify.
+	Channel string
+
+	// Payload is the message sent to the listeners.
+	Payload string
+}
+
+// Notifier is an optional interface for storage devices that can notify listeners of fresh data, so that downstream
+// consumers do not have to poll for it.
+type Notifier interface {
+	// Notify will notify the listeners of a channel. Within a transaction, the listeners are only notified once the
+	// transaction is committed.
+	Notify(context.Context, *NotifyRequest) error
+}
+
+// BucketsRequest is a request for the time buckets of a table that hold records, e.g. to find the gaps of a timeseries
+// table. The buckets are intervals of the granularity, from the start of the time window.
+type BucketsRequest struct {
+	// Table is the name of the table/collection whose buckets are listed.
+	Table string
+
+	// Column is the name of the time column of the records.
+	Column string
+
+	// Start is the inclusive start of the time window, and of its first bucket.
+	Start time.Time
+
+	// End is the exclusive end of the time window.
+	End time.Time
+
+	// Granularity is the length of each bucket.
+	Granularity time.Duration
+
+	// Database is the database of the table, for storage that can write to several databases over one connection.
+	// If empty, the database of the connection is used.
+	Database string
+}
+
+// Bucketer is an optional interface for storage devices that can list the time buckets of a table that hold records.
+type Bucketer interface {
+	// Buckets will return the indexes of the buckets that hold records, in ascending order, where the first bucket of
+	// the time window has the index 0.
+	Buckets(context.Context, *BucketsRequest) ([]int64, error)
+}
+
+// StoredRecords are the records stored in a table, along with the fields of the primary keys that identify them.
+type StoredRecords struct {
+	// PrimaryKeys are the fields that identify the records of the table.
+	PrimaryKeys []string
+
+	// Records are the records of the table.
+	Records []*structpb.Struct
+}
+
+// TableReader is an optional interface for storage devices that can read every record of a table, e.g. to compare
+// the records of a run with the records in storage.
+type TableReader interface {
+	// ReadTable will return the records of the table of a read request. Within a transaction, the uncommitted records
+	// are read.
+	ReadTable(context.Context, *proto.ReadRequest) (*StoredRecords, error)
+}
+
+// ReadPageRequest is a request for a page of the records of a table, in the order of their primary keys.
+type ReadPageRequest struct {
+	// Table is the table to read.
+	Table string
+
+	// After is the last record of the previous page, whose primary keys the records of the page follow. The first page
+	// is read if it is nil.
+	After *structpb.Struct
+
+	// Limit is the number of records of a page.
+	Limit int
+}
+
+// TablePager is an optional interface for storage devices that can read the records of a table a page at a time, in
+// the order of their primary keys, e.g. to copy tables that do not fit in memory. Pages follow the keys of the last
+// record of the previous page, so that each page is read from an index rather than by skipping the records before it.
+type TablePager interface {
+	// CountTable will return the number of records of a table.
+	CountTable(ctx context.Context, table string) (int64, error)
+
+	// ReadPage will return a page of the records of a table, along with the fields of the primary keys that identify
+	// them. Within a transaction, the uncommitted records are read.
+	ReadPage(context.Context, *ReadPageRequest) (*StoredRecords, error)
+}
+
+// UpsertReader is an optional interface for storage devices that can read the stored records of an upsert request by
+// their keys, e.g. to verify that the records of a run read back as they were written.
+type UpsertReader interface {
+	// ReadUpserted will return the stored records whose keys are the keys of the records of an upsert request, along
+	// with the fields of the keys. Within a transaction, the uncommitted records are read.
+	ReadUpserted(context.Context, *proto.UpsertRequest) (*StoredRecords, error)
+}
+
+// TableSchema is the schema of a table: its columns and the columns of its primary key.
+type TableSchema struct {
+	// Columns are the columns of the table.
+	Columns []string
+
+	// PrimaryKeys are the columns of the primary key of the table.
+	PrimaryKeys []string
+}
+
+// SchemaReader is an optional interface for storage devices whose tables have a fixed set of columns, so that the
+// records of an upsert can be validated against the columns of their table before they are written.
+type SchemaReader interface {
+	// ReadSchema will return the schema of the table of an upsert request, or nil if the table does not exist.
+	ReadSchema(context.Context, *proto.UpsertRequest) (*TableSchema, error)
+}
+
+// MeasureRequest is a request to measure the data of a table, e.g. to assert its quality once it is loaded. Exactly
+// one of the measurements is set.
+type MeasureRequest struct {
+	// Table is the name of the table/collection to measure.
+	Table string
+
+	// Query is a native query that returns a single number: a SQL query, or a MongoDB aggregation pipeline on the
+	// collection as extended JSON, whose first document's first field is the number.
+	Query string
+
+	// NullField is the field whose fraction of null or missing values is measured.
+	NullField string
+
+	// UniqueFields are the fields whose combinations of values that occur more than once are counted.
+	UniqueFields []string
+}
+
+// Measurer is an optional interface for storage devices that can measure the data of their tables.
+type Measurer interface {
+	// Measure will return the measurement of a table. Within a transaction, the uncommitted data is measured.
+	Measure(context.Context, *MeasureRequest) (float64, error)
+}
+
+// UpsertedCount is the number of distinct records of an upsert request, by their keys, and the number of them that
+// are present in storage.
+type UpsertedCount struct {
+	// Records is the number of distinct records of the upsert request.
+	Records int64
+
+	// Present is the number of the records whose keys are present in storage.
+	Present int64
+}
+
+// Counter is an optional interface for storage devices that can count which records of an upsert request are present,
+// so that the records that were written can be reconciled with the records that were decoded.
+type Counter interface {
+	// CountUpserted will count the records of an upsert request that are present in its table. Within a transaction,
+	// the uncommitted records are counted.
+	CountUpserted(context.Context, *proto.UpsertRequest) (*UpsertedCount, error)
+}
+
 // sqlPrepareContextFn can be used to prepare a statement and return the result.
 type sqlPrepareContextFn func(context.Context, string) (*sql.Stmt, error)
 
@@ -75,6 +383,20 @@ func Scheme(t uint8) string {
 		return "mongodb"
 	case PostgresType:
 		return "postgresql"
+	case NATSType:
+		return "nats"
+	case CosmosDBType:
+		return "cosmosdb"
+	case MSSQLType:
+		return "sqlserver"
+	case OracleType:
+		return "oracle"
+	case Neo4jType:
+		return "neo4j"
+	case QdrantType:
+		return "qdrant"
+	case ParquetType:
+		return "parquet"
 	default:
 		return "unknown"
 	}
@@ -105,5 +427,68 @@ func New(ctx context.Context, dns string) (*Service, error) {
 		return &Service{svc}, nil
 	}
 
+	if strings.HasPrefix(dns, Scheme(NATSType)+"://") {
+		svc, err := NewNATS(ctx, dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct nats storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
+	if strings.HasPrefix(dns, Scheme(CosmosDBType)+"://") || strings.Contains(dns, "AccountEndpoint=") {
+		svc, err := NewCosmosDB(ctx, dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct cosmos db storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
+	if strings.HasPrefix(dns, Scheme(MSSQLType)+"://") {
+		svc, err := NewMSSQL(ctx, dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct sql server storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
+	if strings.HasPrefix(dns, Scheme(OracleType)+"://") {
+		svc, err := NewOracle(ctx, dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct oracle storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
+	if strings.HasPrefix(dns, Scheme(Neo4jType)+"://") || strings.HasPrefix(dns, Scheme(Neo4jType)+"+s://") {
+		svc, err := NewNeo4j(ctx, dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct neo4j storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
+	if strings.HasPrefix(dns, Scheme(QdrantType)+"://") {
+		svc, err := NewQdrant(ctx, dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct qdrant storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
+	if strings.HasPrefix(dns, Scheme(ParquetType)+"://") {
+		svc, err := NewParquet(dns)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct parquet storage: %w", err)
+		}
+
+		return &Service{svc}, nil
+	}
+
 	return nil, DNSNotSupportedError(dns)
 }
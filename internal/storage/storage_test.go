@@ -11,6 +11,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
@@ -18,7 +19,12 @@ import (
 
 	"github.com/alpine-hodler/gidari/proto"
 	"github.com/alpine-hodler/gidari/tools"
+	"github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func truncateStorage(ctx context.Context, t *testing.T, stg Storage, tables ...string) {
@@ -422,3 +428,674 @@ func TestListPrimaryKeys(t *testing.T) {
 		})
 	}
 }
+
+func TestUpsertedKeys(t *testing.T) {
+	t.Parallel()
+
+	t.Run("postgres", func(t *testing.T) {
+		t.Parallel()
+
+		at := time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC)
+
+		key, err := pgRecordKey([]string{"id", "product", "time"}, []interface{}{int64(1), []byte("BTC-USD"), at})
+		if err != nil {
+			t.Fatalf("failed to build key: %v", err)
+		}
+
+		expected := map[string]interface{}{"id": float64(1), "product": "BTC-USD", "time": "2022-05-10T00:00:00Z"}
+		if !reflect.DeepEqual(key.AsMap(), expected) {
+			t.Fatalf("expected key %v, got %v", expected, key.AsMap())
+		}
+	})
+
+	t.Run("mongo", func(t *testing.T) {
+		t.Parallel()
+
+		oid := primitive.NewObjectID()
+
+		keys, err := mongoUpsertedKeys(map[int64]interface{}{3: "x", 0: oid})
+		if err != nil {
+			t.Fatalf("failed to build keys: %v", err)
+		}
+
+		if len(keys) != 2 || keys[0].AsMap()["_id"] != oid.Hex() || keys[1].AsMap()["_id"] != "x" {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+	})
+}
+
+func TestVersionField(t *testing.T) {
+	t.Parallel()
+
+	t.Run("postgres", func(t *testing.T) {
+		t.Parallel()
+
+		meta := &pgmeta{
+			cols: map[string][]string{"candles": {"id", "close", "updated_at"}},
+			pks:  map[string][]string{"candles": {"id"}},
+		}
+
+		expected := `(candles."close",candles."updated_at") IS DISTINCT FROM (EXCLUDED."close",EXCLUDED."updated_at")` +
+			` AND (candles."updated_at" IS NULL OR EXCLUDED."updated_at" > candles."updated_at")`
+		got := meta.changedCondition("candles", meta.cols["candles"], "updated_at", "candles", "EXCLUDED")
+		if got != expected {
+			t.Fatalf("expected condition %q, got %q", expected, got)
+		}
+
+		_, err := meta.upsertStmt(context.Background(), "candles", meta.cols["candles"], nil, 1,
+			&proto.UpsertRequest{VersionField: "version"})
+		if !errors.Is(err, ErrInvalidVersionField) {
+			t.Fatalf("expected ErrInvalidVersionField, got %v", err)
+		}
+	})
+
+	t.Run("mongo", func(t *testing.T) {
+		t.Parallel()
+
+		for _, tcase := range []struct {
+			name     string
+			doc      bson.D
+			field    string
+			expected bson.D
+		}{
+			{
+				name:     "matched on the document without a version field",
+				doc:      bson.D{{Key: "_id", Value: 1}, {Key: "v", Value: 2}},
+				expected: bson.D{{Key: "_id", Value: 1}, {Key: "v", Value: 2}},
+			},
+			{
+				name:     "matched on the document without an id",
+				doc:      bson.D{{Key: "v", Value: 2}},
+				field:    "v",
+				expected: bson.D{{Key: "v", Value: 2}},
+			},
+			{
+				name:     "matched on the id with a version",
+				doc:      bson.D{{Key: "_id", Value: 1}, {Key: "v", Value: 2}},
+				field:    "v",
+				expected: bson.D{{Key: "_id", Value: 1}},
+			},
+		} {
+			tcase := tcase
+
+			t.Run(tcase.name, func(t *testing.T) {
+				t.Parallel()
+
+				model, ok := mongoUpsertModel(tcase.doc, tcase.field, "").(*mongo.UpdateOneModel)
+				if !ok {
+					t.Fatalf("expected an update one model")
+				}
+
+				if !reflect.DeepEqual(model.Filter, tcase.expected) {
+					t.Fatalf("expected filter %v, got %v", tcase.expected, model.Filter)
+				}
+			})
+		}
+	})
+}
+
+func TestJSONColumn(t *testing.T) {
+	t.Parallel()
+
+	record, err := structpb.NewStruct(map[string]interface{}{"id": "a", "nested": map[string]interface{}{"x": 1}})
+	if err != nil {
+		t.Fatalf("failed to create struct: %v", err)
+	}
+
+	records := []*structpb.Struct{record, {}}
+	if err := pgEmbedRecords(records, "data"); err != nil {
+		t.Fatalf("failed to embed records: %v", err)
+	}
+
+	args := tools.SQLFlattenPartition([]string{"id", "data"}, records)
+
+	var embedded map[string]interface{}
+	if err := json.Unmarshal([]byte(args[1].(string)), &embedded); err != nil {
+		t.Fatalf("failed to decode embedded record: %v", err)
+	}
+
+	expected := map[string]interface{}{"id": "a", "nested": map[string]interface{}{"x": float64(1)}}
+	if args[0] != "a" || !reflect.DeepEqual(embedded, expected) {
+		t.Fatalf("expected id %q and record %v, got %v and %v", "a", expected, args[0], embedded)
+	}
+
+	if args[3] != "{}" {
+		t.Fatalf("expected an empty record to embed as %q, got %v", "{}", args[3])
+	}
+}
+
+func TestGeoFields(t *testing.T) {
+	t.Parallel()
+
+	t.Run("placeholders", func(t *testing.T) {
+		t.Parallel()
+
+		columns := []string{"id", "location"}
+		if got := pgPlaceholders(columns, 2, nil); got != tools.SQLIterativePlaceholders(2, 2, "$") {
+			t.Fatalf("expected the iterative placeholders without geo columns, got %q", got)
+		}
+
+		expected := "($1,ST_SetSRID(ST_GeomFromGeoJSON($2::text),4326))," +
+			"($3,ST_SetSRID(ST_GeomFromGeoJSON($4::text),4326))"
+		if got := pgPlaceholders(columns, 2, map[string]string{"location": pgGeoConversion}); got != expected {
+			t.Fatalf("expected placeholders %q, got %q", expected, got)
+		}
+	})
+
+	t.Run("encoding", func(t *testing.T) {
+		t.Parallel()
+
+		record, err := structpb.NewStruct(map[string]interface{}{
+			"id":       "a",
+			"location": map[string]interface{}{"type": "Point", "coordinates": []interface{}{1.5, 2}},
+		})
+		if err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+
+		records := []*structpb.Struct{record, {}}
+		if err := pgEncodeGeometries(records, []string{"location"}); err != nil {
+			t.Fatalf("failed to encode geometries: %v", err)
+		}
+
+		var geometry map[string]interface{}
+		if err := json.Unmarshal([]byte(record.GetFields()["location"].GetStringValue()), &geometry); err != nil {
+			t.Fatalf("failed to decode geometry: %v", err)
+		}
+
+		expected := map[string]interface{}{"type": "Point", "coordinates": []interface{}{1.5, float64(2)}}
+		if !reflect.DeepEqual(geometry, expected) {
+			t.Fatalf("expected geometry %v, got %v", expected, geometry)
+		}
+	})
+}
+
+func TestVectorFields(t *testing.T) {
+	t.Parallel()
+
+	expected := "($1,$2::vector),($3,$4::vector)"
+	if got := pgPlaceholders([]string{"id", "vec"}, 2, map[string]string{"vec": pgVectorConversion}); got != expected {
+		t.Fatalf("expected placeholders %q, got %q", expected, got)
+	}
+
+	record, err := structpb.NewStruct(map[string]interface{}{"id": "a", "vec": []interface{}{1, 0.5}})
+	if err != nil {
+		t.Fatalf("failed to create struct: %v", err)
+	}
+
+	if err := pgEncodeVectors([]*structpb.Struct{record, {}}, []string{"vec"}); err != nil {
+		t.Fatalf("failed to encode vectors: %v", err)
+	}
+
+	if got := record.GetFields()["vec"].GetStringValue(); got != "[1,0.5]" {
+		t.Fatalf("expected vector %q, got %q", "[1,0.5]", got)
+	}
+}
+
+func TestDecimalFields(t *testing.T) {
+	t.Parallel()
+
+	doc := bson.D{{Key: "id", Value: "a"}, {Key: "price", Value: "29348.150000000000000001"}, {Key: "size", Value: nil}}
+	if err := mongoDecimalFields(doc, []string{"price", "size"}); err != nil {
+		t.Fatalf("failed to convert decimal fields: %v", err)
+	}
+
+	price, ok := doc[1].Value.(primitive.Decimal128)
+	if !ok || price.String() != "29348.150000000000000001" {
+		t.Fatalf("expected price to be decimal %q, got %v", "29348.150000000000000001", doc[1].Value)
+	}
+
+	if doc[0].Value != "a" || doc[2].Value != nil {
+		t.Fatalf("expected other fields to be unchanged, got %v", doc)
+	}
+
+	err := mongoDecimalFields(bson.D{{Key: "price", Value: "1,000"}}, []string{"price"})
+	if !errors.Is(err, tools.ErrInvalidDecimal) {
+		t.Fatalf("expected error %v, got %v", tools.ErrInvalidDecimal, err)
+	}
+}
+
+func TestNanoTimestampFields(t *testing.T) {
+	t.Parallel()
+
+	doc := bson.D{{Key: "id", Value: "a"}, {Key: "time", Value: "1700000000123456789"}, {Key: "done", Value: nil}}
+	if err := mongoNanoTimestampFields(doc, []string{"time", "done"}); err != nil {
+		t.Fatalf("failed to convert nanosecond timestamp fields: %v", err)
+	}
+
+	if nanos, ok := doc[1].Value.(int64); !ok || nanos != 1700000000123456789 {
+		t.Fatalf("expected time to be int64 %d, got %v", int64(1700000000123456789), doc[1].Value)
+	}
+
+	if doc[0].Value != "a" || doc[2].Value != nil {
+		t.Fatalf("expected other fields to be unchanged, got %v", doc)
+	}
+
+	err := mongoNanoTimestampFields(bson.D{{Key: "time", Value: 1.7e18}}, []string{"time"})
+	if !errors.Is(err, tools.ErrInvalidTimestamp) {
+		t.Fatalf("expected error %v, got %v", tools.ErrInvalidTimestamp, err)
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	t.Parallel()
+
+	t.Run("postgres", func(t *testing.T) {
+		t.Parallel()
+
+		meta := &pgmeta{
+			cols: map[string][]string{"orders": {"id", "status", "note"}},
+			pks:  map[string][]string{"orders": {"id"}},
+		}
+
+		var records []*structpb.Struct
+
+		for _, data := range []map[string]interface{}{
+			{"id": 1, "status": "open"},
+			{"id": 2, "status": "filled", "note": nil},
+			{"id": 3, "status": "closed"},
+			{"status": "open"},
+		} {
+			record, err := structpb.NewStruct(data)
+			if err != nil {
+				t.Fatalf("failed to create struct: %v", err)
+			}
+
+			records = append(records, record)
+		}
+
+		if groups := meta.groupRecords("orders", records, ""); len(groups) != 1 ||
+			!reflect.DeepEqual(groups[0].columns, meta.cols["orders"]) {
+			t.Fatalf("expected a single group of every column by default, got %v", groups)
+		}
+
+		groups := meta.groupRecords("orders", records, MissingFieldsKeep)
+
+		expected := [][]string{{"id", "status"}, {"id", "status", "note"}}
+		sizes := []int{3, 1}
+
+		if len(groups) != len(expected) {
+			t.Fatalf("expected %d groups, got %d", len(expected), len(groups))
+		}
+
+		for idx, group := range groups {
+			if !reflect.DeepEqual(group.columns, expected[idx]) || len(group.records) != sizes[idx] {
+				t.Fatalf("expected group %d to have columns %v and %d records, got %v and %d", idx, expected[idx],
+					sizes[idx], group.columns, len(group.records))
+			}
+		}
+	})
+
+	t.Run("mongo", func(t *testing.T) {
+		t.Parallel()
+
+		doc := bson.D{{Key: "_id", Value: 1}, {Key: "status", Value: "open"}}
+
+		if _, ok := mongoUpsertModel(doc, "", MissingFieldsNull).(*mongo.ReplaceOneModel); !ok {
+			t.Fatalf("expected a replace one model when missing fields are cleared")
+		}
+
+		model, ok := mongoUpsertModel(doc, "", MissingFieldsKeep).(*mongo.UpdateOneModel)
+		if !ok {
+			t.Fatalf("expected an update one model when missing fields are kept")
+		}
+
+		if expected := (bson.D{{Key: "_id", Value: 1}}); !reflect.DeepEqual(model.Filter, expected) {
+			t.Fatalf("expected filter %v, got %v", expected, model.Filter)
+		}
+	})
+}
+
+func TestMongoPipeline(t *testing.T) {
+	t.Parallel()
+
+	pipeline, err := mongoPipeline(&MeasureRequest{Table: "candles", Query: `[{"$match":{"price":{"$lte":0}}},{"$count":"n"}]`})
+	if err != nil {
+		t.Fatalf("failed to parse pipeline: %v", err)
+	}
+
+	if len(pipeline) != 2 {
+		t.Fatalf("expected 2 stages, got %d", len(pipeline))
+	}
+
+	if _, err := mongoPipeline(&MeasureRequest{Table: "candles", Query: "SELECT 1"}); !errors.Is(err, ErrInvalidMeasurement) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidMeasurement, err)
+	}
+
+	if _, err := mongoPipeline(&MeasureRequest{Table: "candles"}); !errors.Is(err, ErrInvalidMeasurement) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidMeasurement, err)
+	}
+
+	for _, value := range []interface{}{int32(2), int64(2), float64(2)} {
+		if number, err := mongoNumber(value); err != nil || number != 2 {
+			t.Fatalf("expected 2 from %T, got %v (%v)", value, number, err)
+		}
+	}
+}
+
+func TestPGDistinctKeys(t *testing.T) {
+	t.Parallel()
+
+	records := make([]*structpb.Struct, 0, 4)
+
+	for _, record := range []map[string]interface{}{
+		{"product": "BTC-USD", "time": 1, "price": 1},
+		{"product": "BTC-USD", "time": 1, "price": 2},
+		{"product": "ETH-USD", "time": 1},
+		{"product": "ETH-USD"},
+	} {
+		rec, err := structpb.NewStruct(record)
+		if err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+
+		records = append(records, rec)
+	}
+
+	keys, err := pgDistinctKeys(records, []string{"product", "time"})
+	if err != nil {
+		t.Fatalf("failed to get distinct keys: %v", err)
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 distinct keys, got %d", len(keys))
+	}
+
+	if _, ok := keys[0].GetFields()["price"]; ok {
+		t.Fatalf("expected keys to only hold the primary keys, got %v", keys[0])
+	}
+}
+
+func TestPGMetaSchema(t *testing.T) {
+	t.Parallel()
+
+	meta := &pgmeta{
+		cols: map[string][]string{"trades": {"id", "price"}, "logs": {"message"}},
+		pks:  map[string][]string{"trades": {"id"}},
+	}
+
+	expected := &TableSchema{Columns: []string{"id", "price"}, PrimaryKeys: []string{"id"}}
+	if schema := meta.schema("trades"); !reflect.DeepEqual(schema, expected) {
+		t.Fatalf("expected schema %+v, got %+v", expected, schema)
+	}
+
+	if schema := meta.schema("logs"); len(schema.PrimaryKeys) != 0 {
+		t.Fatalf("expected a table without a primary key, got %v", schema.PrimaryKeys)
+	}
+
+	if schema := meta.schema("orders"); schema != nil {
+		t.Fatalf("expected no schema of a table that does not exist, got %+v", schema)
+	}
+}
+
+func TestCollation(t *testing.T) {
+	t.Parallel()
+
+	t.Run("postgres", func(t *testing.T) {
+		t.Parallel()
+
+		meta := &pgmeta{pks: map[string][]string{"trades": {"symbol", "id"}}}
+
+		for _, tcase := range []struct {
+			name      string
+			req       *proto.UpsertRequest
+			expected  string
+			expectErr error
+		}{
+			{
+				name:     "binary",
+				req:      &proto.UpsertRequest{},
+				expected: "symbol,id",
+			},
+			{
+				name:     "every key",
+				req:      &proto.UpsertRequest{KeyCollation: "case_insensitive"},
+				expected: `symbol COLLATE "case_insensitive",id COLLATE "case_insensitive"`,
+			},
+			{
+				name:     "text keys",
+				req:      &proto.UpsertRequest{KeyCollation: "case_insensitive", CollationKeys: []string{"symbol"}},
+				expected: `symbol COLLATE "case_insensitive",id`,
+			},
+			{
+				name:      "unknown key",
+				req:       &proto.UpsertRequest{KeyCollation: "case_insensitive", CollationKeys: []string{"price"}},
+				expectErr: ErrInvalidCollationKey,
+			},
+		} {
+			target, err := meta.conflictTarget("trades", tcase.req)
+			if !errors.Is(err, tcase.expectErr) {
+				t.Fatalf("%s: expected error %v, got %v", tcase.name, tcase.expectErr, err)
+			}
+
+			if target != tcase.expected {
+				t.Fatalf("%s: expected conflict target %q, got %q", tcase.name, tcase.expected, target)
+			}
+		}
+	})
+
+	t.Run("mongo", func(t *testing.T) {
+		t.Parallel()
+
+		doc := bson.D{{Key: "_id", Value: "BTC-USD"}}
+		collation := mongoCollation(&proto.UpsertRequest{CollationLocale: "en", CollationStrength: 2})
+
+		model, ok := mongoCollate(mongoUpsertModel(doc, "", ""), collation).(*mongo.UpdateOneModel)
+		if !ok || model.Collation == nil || model.Collation.Locale != "en" || model.Collation.Strength != 2 {
+			t.Fatalf("expected the update to match with the collation, got %v", model.Collation)
+		}
+
+		if mongoCollation(&proto.UpsertRequest{}) != nil {
+			t.Fatalf("expected no collation without a locale")
+		}
+	})
+}
+
+func TestDeleteField(t *testing.T) {
+	t.Parallel()
+
+	meta := &pgmeta{
+		cols: map[string][]string{"trades": {"symbol", "id", "price", "geom"}, "tags": {"id"}},
+		pks:  map[string][]string{"trades": {"symbol", "id"}, "tags": {"id"}},
+	}
+
+	source := `SELECT r."symbol",r."id",r."price",` +
+		`ST_SetSRID(ST_GeomFromGeoJSON((e->>'geom')::text),4326) AS "geom",` +
+		`COALESCE(e->'deleted' = 'true'::jsonb,false) AS _gidari_delete ` +
+		`FROM jsonb_array_elements($1::jsonb) AS e, jsonb_populate_record(NULL::trades,e - ARRAY['geom']) AS r`
+
+	merge := `MERGE INTO trades AS target USING (` + source + `) AS s ` +
+		`ON target."symbol" = s."symbol" COLLATE "case_insensitive" AND target."id" = s."id" ` +
+		`WHEN MATCHED AND s._gidari_delete THEN DELETE ` +
+		`WHEN MATCHED AND (target."price",target."geom") IS DISTINCT FROM (s."price",s."geom") ` +
+		`THEN UPDATE SET "price" = s."price","geom" = s."geom" ` +
+		`WHEN NOT MATCHED AND NOT s._gidari_delete THEN INSERT ("symbol","id","price","geom") ` +
+		`VALUES (s."symbol",s."id",s."price",s."geom")`
+
+	for _, tcase := range []struct {
+		name      string
+		version   int
+		table     string
+		columns   []string
+		req       *proto.UpsertRequest
+		actions   string
+		merge     string
+		expectErr error
+	}{
+		{
+			name:    "returning",
+			version: 170002,
+			table:   "trades",
+			columns: meta.cols["trades"],
+			req: &proto.UpsertRequest{
+				DeleteField: "deleted", GeoFields: []string{"geom"}, KeyCollation: "case_insensitive",
+				CollationKeys: []string{"symbol"}, ReturnKeys: true,
+			},
+			merge: merge + ` RETURNING merge_action(),target."symbol",target."id"`,
+		},
+		{
+			name:    "actions query",
+			version: 150004,
+			table:   "trades",
+			columns: meta.cols["trades"],
+			req: &proto.UpsertRequest{
+				DeleteField: "deleted", GeoFields: []string{"geom"}, KeyCollation: "case_insensitive",
+				CollationKeys: []string{"symbol"}, ReturnKeys: true,
+			},
+			actions: `SELECT CASE WHEN target.ctid IS NULL THEN 'INSERT' WHEN s._gidari_delete THEN 'DELETE' ` +
+				`ELSE 'UPDATE' END,COALESCE(target."symbol",s."symbol"),COALESCE(target."id",s."id") ` +
+				`FROM (` + source + `) AS s LEFT JOIN trades AS target ` +
+				`ON target."symbol" = s."symbol" COLLATE "case_insensitive" AND target."id" = s."id" ` +
+				`WHERE (target.ctid IS NULL AND NOT s._gidari_delete) OR (target.ctid IS NOT NULL AND ` +
+				`(s._gidari_delete OR (target."price",target."geom") IS DISTINCT FROM (s."price",s."geom")))`,
+			merge: merge,
+		},
+		{
+			name:    "keys only",
+			version: 170002,
+			table:   "tags",
+			columns: meta.cols["tags"],
+			req:     &proto.UpsertRequest{DeleteField: "deleted"},
+			merge: `MERGE INTO tags AS target USING (SELECT r."id",` +
+				`COALESCE(e->'deleted' = 'true'::jsonb,false) AS _gidari_delete ` +
+				`FROM jsonb_array_elements($1::jsonb) AS e, jsonb_populate_record(NULL::tags,e) AS r) AS s ` +
+				`ON target."id" = s."id" WHEN MATCHED AND s._gidari_delete THEN DELETE ` +
+				`WHEN NOT MATCHED AND NOT s._gidari_delete THEN INSERT ("id") VALUES (s."id") ` +
+				`RETURNING merge_action()`,
+		},
+		{
+			name:      "no primary key",
+			version:   170002,
+			table:     "events",
+			req:       &proto.UpsertRequest{DeleteField: "deleted"},
+			expectErr: ErrNoPrimaryKey,
+		},
+	} {
+		meta := &pgmeta{cols: meta.cols, pks: meta.pks, version: tcase.version}
+
+		actions, merge, err := meta.mergeStmts(tcase.table, tcase.columns, tcase.req)
+		if !errors.Is(err, tcase.expectErr) {
+			t.Fatalf("%s: expected error %v, got %v", tcase.name, tcase.expectErr, err)
+		}
+
+		if actions != tcase.actions {
+			t.Fatalf("%s: expected actions query:\n%s\ngot:\n%s", tcase.name, tcase.actions, actions)
+		}
+
+		if merge != tcase.merge {
+			t.Fatalf("%s: expected merge statement:\n%s\ngot:\n%s", tcase.name, tcase.merge, merge)
+		}
+	}
+
+	stmt, err := meta.deleteStmt("trades", 2, &proto.UpsertRequest{DeleteField: "deleted", ReturnKeys: true})
+	if err != nil {
+		t.Fatalf("failed to build delete statement: %v", err)
+	}
+
+	expected := `DELETE FROM trades WHERE (symbol,id) IN (($1,$2),($3,$4)) RETURNING 'DELETE'::text,"symbol","id"`
+	if stmt != expected {
+		t.Fatalf("expected delete statement %q, got %q", expected, stmt)
+	}
+
+	records := make([]*structpb.Struct, 3)
+
+	for idx, fields := range []map[string]interface{}{
+		{"id": 1, "deleted": true},
+		{"id": 2, "deleted": false},
+		{"id": 3, "deleted": "true"},
+	} {
+		if records[idx], err = structpb.NewStruct(fields); err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+	}
+
+	kept, marked := pgMarkedRecords(records, "deleted")
+	if len(kept) != 2 || len(marked) != 1 || marked[0] != records[0] {
+		t.Fatalf("expected only the record with a true delete field to be marked, got %v", marked)
+	}
+
+	// The record with id 1 is marked as deleted after it was updated, so only the marker is written.
+	updated, err := structpb.NewStruct(map[string]interface{}{"id": 1, "deleted": false})
+	if err != nil {
+		t.Fatalf("failed to create struct: %v", err)
+	}
+
+	last := meta.lastRecords("tags", append([]*structpb.Struct{updated}, records...))
+	if len(last) != 3 || last[0] != records[0] || last[1] != records[1] || last[2] != records[2] {
+		t.Fatalf("expected the last record of each key, got %v", last)
+	}
+}
+
+type fakePostgresGSS struct{ service string }
+
+func (gss *fakePostgresGSS) GetInitToken(string, string) ([]byte, error) {
+	return []byte(gss.service), nil
+}
+func (gss *fakePostgresGSS) GetInitTokenFromSpn(string) ([]byte, error) {
+	return []byte(gss.service), nil
+}
+func (gss *fakePostgresGSS) Continue([]byte) (bool, []byte, error) { return true, nil, nil }
+
+func TestRegisterPostgresGSS(t *testing.T) {
+	t.Parallel()
+
+	for _, service := range []string{"first", "second"} {
+		service := service
+		RegisterPostgresGSS(func() (PostgresGSS, error) { return &fakePostgresGSS{service: service}, nil })
+
+		gss, err := newPostgresGSS()
+		if err != nil {
+			t.Fatalf("failed to create security context: %v", err)
+		}
+
+		if token, _ := gss.GetInitToken("localhost", "postgres"); string(token) != service {
+			t.Fatalf("expected the %q provider, got %q", service, token)
+		}
+	}
+}
+
+func TestConflictError(t *testing.T) {
+	t.Parallel()
+
+	err := fmt.Errorf("unable to execute upsert: %w", ConflictError(&pq.Error{Code: "23505"}))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected a conflict, got %v", err)
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		t.Fatalf("expected the postgres error to be preserved, got %v", err)
+	}
+}
+
+func TestPGUpsertError(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		err      error
+		conflict bool
+		rejected bool
+	}{
+		{name: "unique violation", err: &pq.Error{Code: "23505"}, conflict: true},
+		{name: "not null violation", err: &pq.Error{Code: "23502"}, rejected: true},
+		{name: "undefined column", err: &pq.Error{Code: "42703"}, rejected: true},
+		{name: "admin shutdown", err: &pq.Error{Code: "57P01"}},
+		{name: "connection refused", err: fmt.Errorf("dial tcp: connection refused")},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := pgUpsertError(tcase.err)
+			if errors.Is(err, ErrConflict) != tcase.conflict || errors.Is(err, ErrRejected) != tcase.rejected {
+				t.Fatalf("expected conflict %t and rejected %t, got %v", tcase.conflict, tcase.rejected, err)
+			}
+
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected the postgres error to be preserved, got %v", err)
+			}
+		})
+	}
+}
@@ -9,16 +9,40 @@ package storage
 
 import (
 	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// txnBatchSize is the number of functions that are sent to the transaction channel at once.
+	txnBatchSize = 256
+
+	// txnBuffer is the number of batches that the transaction channel holds before "Send" blocks on the storage.
+	txnBuffer = 8
+
+	// txnLinger is the longest time that a function waits in a partial batch before the batch is flushed, so that the
+	// writes of a slow trickle of functions are not held back until the batch is full or the transaction ends.
+	txnLinger = 50 * time.Millisecond
 )
 
 // TxnChanFn is a function that will be sent to the transaction channel.
 type TxnChanFn func(context.Context, Storage) error
 
 // Txn is a wrapper for a mongo session that can be used to perform CRUD operations on a mongo DB instance.
+//
+// The functions sent to a transaction are carried over its channel in batches, rather than one at a time, to reduce
+// the synchronization between the senders and the storage when there are many small operations. A batch is flushed
+// to the channel when it is full, when "Flush" is called, when it has lingered for a short time, and when the
+// transaction is committed or rolled back.
 type Txn struct {
-	ch     chan TxnChanFn
+	ch     chan []TxnChanFn
 	done   chan error
 	commit chan bool
+
+	mu     sync.Mutex
+	batch  []TxnChanFn
+	linger *time.Timer
+	closed bool
 }
 
 // Transactor is an interface that can be used to perform CRUD operations within the context of a database transaction.
@@ -26,25 +50,133 @@ type Transactor interface {
 	Commit() error
 	Rollback() error
 	Send(TxnChanFn)
+	Flush()
 }
 
-// Commit will commit the transaction.
-func (txn *Txn) Commit() error {
+// newTxn will return a transaction with a buffered batch channel.
+func newTxn() *Txn {
+	return &Txn{
+		ch:     make(chan []TxnChanFn, txnBuffer),
+		done:   make(chan error, 1),
+		commit: make(chan bool, 1),
+	}
+}
+
+// end will flush the pending batch and close the transaction channel, then send the decision to commit or rollback.
+func (txn *Txn) end(commit bool) error {
+	txn.mu.Lock()
+	txn.flush()
+	txn.closed = true
 	close(txn.ch)
-	txn.commit <- true
+	txn.mu.Unlock()
+
+	txn.commit <- commit
 
 	return <-txn.done
 }
 
+// Commit will commit the transaction.
+func (txn *Txn) Commit() error {
+	return txn.end(true)
+}
+
 // Rollback will rollback the transaction.
 func (txn *Txn) Rollback() error {
-	close(txn.ch)
-	txn.commit <- false
-
-	return <-txn.done
+	return txn.end(false)
 }
 
-// Send will send a function to the transaction channel.
+// Send will add a function to the pending batch of the transaction, flushing the batch to the transaction channel
+// once it is full.
 func (txn *Txn) Send(fn TxnChanFn) {
-	txn.ch <- fn
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	txn.batch = append(txn.batch, fn)
+
+	if len(txn.batch) >= txnBatchSize {
+		txn.flush()
+
+		return
+	}
+
+	if txn.linger == nil {
+		txn.linger = time.AfterFunc(txnLinger, txn.Flush)
+	}
+}
+
+// Flush will send the pending batch of the transaction to its channel, marking an explicit boundary after which the
+// functions sent so far are handed to the storage.
+func (txn *Txn) Flush() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if !txn.closed {
+		txn.flush()
+	}
+}
+
+// flush will send the pending batch to the transaction channel. The caller must hold the lock.
+func (txn *Txn) flush() {
+	if txn.linger != nil {
+		txn.linger.Stop()
+		txn.linger = nil
+	}
+
+	if len(txn.batch) == 0 {
+		return
+	}
+
+	txn.ch <- txn.batch
+	txn.batch = make([]TxnChanFn, 0, txnBatchSize)
+}
+
+// receive will run the functions of the batches sent to the transaction until its channel is closed. After the first
+// error, the remaining functions are drained without running, and the error is returned.
+func (txn *Txn) receive(run func(TxnChanFn) error) error {
+	var err error
+
+	for batch := range txn.ch {
+		for _, fn := range batch {
+			if err != nil {
+				break
+			}
+
+			err = run(fn)
+		}
+	}
+
+	return err
+}
+
+// FailedTx will return a "Txn" that skips the functions sent to it and returns the error when it is committed or
+// rolled back, e.g. in place of a transaction that could not be started.
+func FailedTx(err error) *Txn {
+	txn := newTxn()
+
+	go func() {
+		for range txn.ch {
+			continue
+		}
+
+		<-txn.commit
+		txn.done <- err
+	}()
+
+	return txn
+}
+
+// startUntransactedTx will start a "Txn" for storage devices without transactions, which runs the functions sent to
+// it as they are received. After the first error the remaining functions are skipped, and the error is returned by
+// "Commit" or "Rollback". A rollback does not undo the functions that already ran.
+func startUntransactedTx(ctx context.Context, stg Storage) *Txn {
+	txn := newTxn()
+
+	go func() {
+		err := txn.receive(func(fn TxnChanFn) error { return fn(ctx, stg) })
+
+		<-txn.commit
+		txn.done <- err
+	}()
+
+	return txn
 }
@@ -0,0 +1,125 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTxnBatches(t *testing.T) {
+	t.Parallel()
+
+	t.Run("commit runs every function", func(t *testing.T) {
+		t.Parallel()
+
+		txn := startUntransactedTx(context.Background(), nil)
+
+		var ran int64
+
+		for idx := 0; idx < 3*txnBatchSize+1; idx++ {
+			txn.Send(func(context.Context, Storage) error {
+				atomic.AddInt64(&ran, 1)
+
+				return nil
+			})
+		}
+
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("error committing: %v", err)
+		}
+
+		if ran != 3*txnBatchSize+1 {
+			t.Fatalf("expected %d functions to run, got %d", 3*txnBatchSize+1, ran)
+		}
+	})
+
+	t.Run("flush hands a partial batch to the storage", func(t *testing.T) {
+		t.Parallel()
+
+		txn := startUntransactedTx(context.Background(), nil)
+
+		ran := make(chan struct{}, 1)
+
+		txn.Send(func(context.Context, Storage) error {
+			ran <- struct{}{}
+
+			return nil
+		})
+		txn.Flush()
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("expected the flushed function to run before the transaction ends")
+		}
+
+		if err := txn.Commit(); err != nil {
+			t.Fatalf("error committing: %v", err)
+		}
+	})
+
+	t.Run("partial batches linger", func(t *testing.T) {
+		t.Parallel()
+
+		txn := startUntransactedTx(context.Background(), nil)
+
+		ran := make(chan struct{}, 1)
+
+		txn.Send(func(context.Context, Storage) error {
+			ran <- struct{}{}
+
+			return nil
+		})
+
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("expected the lingering batch to be flushed")
+		}
+
+		if err := txn.Rollback(); err != nil {
+			t.Fatalf("error rolling back: %v", err)
+		}
+	})
+
+	t.Run("functions after an error are skipped", func(t *testing.T) {
+		t.Parallel()
+
+		txn := startUntransactedTx(context.Background(), nil)
+
+		errFailed := errors.New("failed")
+
+		var ran int64
+
+		for idx := 0; idx < 2*txnBatchSize; idx++ {
+			idx := idx
+
+			txn.Send(func(context.Context, Storage) error {
+				atomic.AddInt64(&ran, 1)
+
+				if idx == 10 {
+					return errFailed
+				}
+
+				return nil
+			})
+		}
+
+		if err := txn.Commit(); !errors.Is(err, errFailed) {
+			t.Fatalf("expected the error of the failed function, got %v", err)
+		}
+
+		if ran != 11 {
+			t.Fatalf("expected 11 functions to run, got %d", ran)
+		}
+	})
+}
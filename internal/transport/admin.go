@@ -0,0 +1,372 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+// adminShutdownTimeout is the time that the admin API has to finish its requests when a transport operation ends.
+const adminShutdownTimeout = 5 * time.Second
+
+var ErrInvalidAdmin = fmt.Errorf("invalid admin")
+
+// InvalidAdminError wraps an error with ErrInvalidAdmin.
+func InvalidAdminError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAdmin, reason)
+}
+
+// AdminConfig serves an admin API while a transport operation runs, including its live tail, to control it without
+// stopping the process:
+//
+//   - "POST /pause" stops fetching new chunks, while the chunks in flight are fetched and written.
+//   - "POST /resume" resumes fetching.
+//   - "POST /cancel?table=<table>" skips the remaining chunks of the requests of a table.
+//   - "POST /cancel?request=<index>" skips the remaining chunks of a request, by its index in the requests.
+//   - "POST /reload" reloads the configuration before the next poll of the live tail, see Config.RequestReload.
+//   - "GET /status" returns whether the operation is paused and the canceled tables and requests.
+//
+// Every endpoint responds with the status as JSON. Without a token, requests that a browser could send on behalf of
+// another site are forbidden: those with an "Origin" header, and those whose "Host" is not a loopback address.
+type AdminConfig struct {
+	// Address is the address that the admin API listens on, e.g. "localhost:8090".
+	Address string `yaml:"address"`
+
+	// Token is the bearer token that the requests to the admin API must send in their "Authorization" header. It is
+	// required unless the admin API only listens on a loopback address, e.g. "localhost:8090" or "127.0.0.1:8090".
+	Token string `yaml:"token"`
+}
+
+func (ac *AdminConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+
+	if ac.Address == "" {
+		return InvalidAdminError("address is required")
+	}
+
+	if ac.Token == "" && !isLoopback(ac.Address) {
+		return InvalidAdminError(fmt.Sprintf("token is required to listen on %q, which is not a loopback address",
+			ac.Address))
+	}
+
+	return nil
+}
+
+// isLoopback will return true if an address only listens on the loopback interface. An address without a host, e.g.
+// ":8090", listens on every interface.
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	return isLoopbackHost(host)
+}
+
+// isLoopbackHost will return true if a host is a loopback address.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLocalRequest will return true if a request to the admin API was not sent by a browser on behalf of another site:
+// cross-site requests have an "Origin" header, and DNS rebinding sends the host name of the other site.
+func isLocalRequest(r *http.Request) bool {
+	if r.Header.Get("Origin") != "" {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	return isLoopbackHost(strings.Trim(host, "[]"))
+}
+
+// AdminStatus is the status of a transport operation that is controlled by the admin API.
+type AdminStatus struct {
+	// Paused is true if fetching is paused.
+	Paused bool `json:"paused"`
+
+	// Canceled are the tables whose remaining chunks are skipped.
+	Canceled []string `json:"canceled"`
+
+	// CanceledRequests are the indexes of the requests whose remaining chunks are skipped.
+	CanceledRequests []int `json:"canceledRequests"`
+}
+
+// runControl is the state of a transport operation that is controlled by the admin API. A nil run control is never
+// paused or canceled.
+type runControl struct {
+	mu       sync.Mutex
+	paused   bool
+	resumed  chan struct{}
+	canceled map[string]bool
+
+	// canceledRequests are the indexes of the canceled requests.
+	canceledRequests map[int]bool
+
+	// reload requests a reload of the configuration.
+	reload func()
+}
+
+func newRunControl() *runControl {
+	return &runControl{canceled: make(map[string]bool), canceledRequests: make(map[int]bool)}
+}
+
+// pause will pause fetching.
+func (rc *runControl) pause() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if !rc.paused {
+		rc.paused = true
+		rc.resumed = make(chan struct{})
+	}
+}
+
+// resume will resume fetching, releasing the workers that are waiting.
+func (rc *runControl) resume() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.paused {
+		rc.paused = false
+		close(rc.resumed)
+	}
+}
+
+// cancel will skip the remaining chunks of a table.
+func (rc *runControl) cancel(table string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.canceled[table] = true
+}
+
+// cancelRequest will skip the remaining chunks of a request.
+func (rc *runControl) cancelRequest(request int) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.canceledRequests[request] = true
+}
+
+// isCanceled will return true if the remaining chunks of a table, or of the request with the given index, if any,
+// are skipped.
+func (rc *runControl) isCanceled(table string, request *int) bool {
+	if rc == nil {
+		return false
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.canceled[table] || (request != nil && rc.canceledRequests[*request])
+}
+
+// wait will block while fetching is paused, or until the context is done.
+func (rc *runControl) wait(ctx context.Context) error {
+	if rc == nil {
+		return nil
+	}
+
+	rc.mu.Lock()
+	paused, resumed := rc.paused, rc.resumed
+	rc.mu.Unlock()
+
+	if !paused {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("paused: %w", ctx.Err())
+	}
+}
+
+// status will return the status of the operation.
+func (rc *runControl) status() *AdminStatus {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	status := &AdminStatus{
+		Paused:           rc.paused,
+		Canceled:         make([]string, 0, len(rc.canceled)),
+		CanceledRequests: make([]int, 0, len(rc.canceledRequests)),
+	}
+
+	for table := range rc.canceled {
+		status.Canceled = append(status.Canceled, table)
+	}
+
+	for request := range rc.canceledRequests {
+		status.CanceledRequests = append(status.CanceledRequests, request)
+	}
+
+	sort.Strings(status.Canceled)
+	sort.Ints(status.CanceledRequests)
+
+	return status
+}
+
+// handler will return the handler of the admin API.
+func (rc *runControl) handler(token string, logger *logrus.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	action := func(method string, fn func(r *http.Request) (string, error)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")),
+				[]byte("Bearer "+token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+				return
+			}
+
+			if token == "" && !isLocalRequest(r) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+
+				return
+			}
+
+			if r.Method != method {
+				w.Header().Set("Allow", method)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+				return
+			}
+
+			msg, err := fn(r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+
+				return
+			}
+
+			if msg != "" {
+				logger.Info(tools.LogFormatter{Msg: msg}.String())
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(rc.status())
+		}
+	}
+
+	mux.HandleFunc("/pause", action(http.MethodPost, func(*http.Request) (string, error) {
+		rc.pause()
+
+		return "paused by the admin API", nil
+	}))
+
+	mux.HandleFunc("/resume", action(http.MethodPost, func(*http.Request) (string, error) {
+		rc.resume()
+
+		return "resumed by the admin API", nil
+	}))
+
+	mux.HandleFunc("/cancel", action(http.MethodPost, func(r *http.Request) (string, error) {
+		table, request := r.URL.Query().Get("table"), r.URL.Query().Get("request")
+		if (table == "") == (request == "") {
+			return "", fmt.Errorf("either table or request is required")
+		}
+
+		if request != "" {
+			idx, err := strconv.Atoi(request)
+			if err != nil || idx < 0 {
+				return "", fmt.Errorf("request must be the index of a request, got %q", request)
+			}
+
+			rc.cancelRequest(idx)
+
+			return fmt.Sprintf("remaining chunks of request %d canceled by the admin API", idx), nil
+		}
+
+		rc.cancel(table)
+
+		return fmt.Sprintf("remaining chunks of %s canceled by the admin API", table), nil
+	}))
+
+	mux.HandleFunc("/reload", action(http.MethodPost, func(*http.Request) (string, error) {
+		if rc.reload == nil {
+			return "", fmt.Errorf("reload is not supported")
+		}
+
+		rc.reload()
+
+		return "reload requested by the admin API", nil
+	}))
+
+	mux.HandleFunc("/status", action(http.MethodGet, func(*http.Request) (string, error) {
+		return "", nil
+	}))
+
+	return mux
+}
+
+// serveAdmin will serve the admin API of the configuration, if any, until the returned function is called.
+func (cfg *Config) serveAdmin() (func(), error) {
+	if cfg.Admin == nil {
+		return func() {}, nil
+	}
+
+	listener, err := net.Listen("tcp", cfg.Admin.Address)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serve admin API: %w", err)
+	}
+
+	cfg.control = newRunControl()
+	cfg.control.reload = cfg.RequestReload
+
+	srv := &http.Server{
+		Handler:           cfg.control.handler(cfg.Admin.Token, cfg.Logger),
+		ReadHeaderTimeout: adminShutdownTimeout,
+	}
+
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logErr := tools.LogFormatter{Msg: fmt.Sprintf("admin API stopped: %v", err)}
+			cfg.Logger.Error(logErr.String())
+		}
+	}()
+
+	logInfo := tools.LogFormatter{Msg: fmt.Sprintf("admin API listening on %s", listener.Addr())}
+	cfg.Logger.Info(logInfo.String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), adminShutdownTimeout)
+		defer cancel()
+
+		_ = srv.Shutdown(ctx)
+
+		cfg.control.resume()
+		cfg.control = nil
+	}, nil
+}
@@ -0,0 +1,217 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestAdminConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		ac   *AdminConfig
+		err  error
+	}{
+		{name: "nil"},
+		{name: "localhost", ac: &AdminConfig{Address: "localhost:8090"}},
+		{name: "loopback ip", ac: &AdminConfig{Address: "127.0.0.1:8090"}},
+		{name: "loopback ipv6", ac: &AdminConfig{Address: "[::1]:8090"}},
+		{name: "every interface with token", ac: &AdminConfig{Address: ":8090", Token: "secret"}},
+		{name: "every interface", ac: &AdminConfig{Address: ":8090"}, err: ErrInvalidAdmin},
+		{name: "public ip", ac: &AdminConfig{Address: "10.0.0.1:8090"}, err: ErrInvalidAdmin},
+		{name: "no address", ac: &AdminConfig{Token: "secret"}, err: ErrInvalidAdmin},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.ac.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestAdminAPI(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	control := newRunControl()
+
+	srv := httptest.NewServer(control.handler("secret", logger))
+	defer srv.Close()
+
+	call := func(method, path, token string) (int, *AdminStatus) {
+		t.Helper()
+
+		req, err := http.NewRequestWithContext(context.Background(), method, srv.URL+path, nil)
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rsp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatalf("error calling admin API: %v", err)
+		}
+		defer rsp.Body.Close()
+
+		if rsp.StatusCode != http.StatusOK {
+			return rsp.StatusCode, nil
+		}
+
+		status := new(AdminStatus)
+		if err := json.NewDecoder(rsp.Body).Decode(status); err != nil {
+			t.Fatalf("error decoding status: %v", err)
+		}
+
+		return rsp.StatusCode, status
+	}
+
+	if code, _ := call(http.MethodPost, "/pause", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("expected an unauthorized request to be rejected, got %d", code)
+	}
+
+	if code, _ := call(http.MethodGet, "/pause", "secret"); code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected a GET of pause to be rejected, got %d", code)
+	}
+
+	if _, status := call(http.MethodPost, "/pause", "secret"); !status.Paused {
+		t.Fatalf("expected the operation to be paused, got %+v", status)
+	}
+
+	waited := make(chan error, 1)
+
+	go func() { waited <- control.wait(context.Background()) }()
+
+	select {
+	case err := <-waited:
+		t.Fatalf("expected a paused operation to wait, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if _, status := call(http.MethodPost, "/resume", "secret"); status.Paused {
+		t.Fatalf("expected the operation to be resumed, got %+v", status)
+	}
+
+	if err := <-waited; err != nil {
+		t.Fatalf("expected the wait to be released, got %v", err)
+	}
+
+	for _, path := range []string{"/cancel", "/cancel?table=candles&request=1", "/cancel?request=-1"} {
+		if code, _ := call(http.MethodPost, path, "secret"); code != http.StatusBadRequest {
+			t.Fatalf("expected %s to be rejected, got %d", path, code)
+		}
+	}
+
+	call(http.MethodPost, "/cancel?table=candles", "secret")
+	call(http.MethodPost, "/cancel?request=2", "secret")
+
+	_, status := call(http.MethodGet, "/status", "secret")
+	if !reflect.DeepEqual(status.Canceled, []string{"candles"}) || !reflect.DeepEqual(status.CanceledRequests, []int{2}) {
+		t.Fatalf("expected the canceled tables and requests, got %+v", status)
+	}
+
+	first, third := 0, 2
+
+	if !control.isCanceled("candles", nil) || control.isCanceled("trades", &first) {
+		t.Fatal("expected only the canceled table to be skipped")
+	}
+
+	if !control.isCanceled("trades", &third) {
+		t.Fatal("expected the canceled request to be skipped")
+	}
+}
+
+func TestAdminAPIWithoutToken(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	srv := httptest.NewServer(newRunControl().handler("", logger))
+	t.Cleanup(srv.Close)
+
+	for _, tcase := range []struct {
+		name   string
+		host   string
+		origin string
+		code   int
+	}{
+		{name: "local", code: http.StatusOK},
+		{name: "localhost", host: "localhost:8090", code: http.StatusOK},
+		{name: "origin", origin: "https://attacker.test", code: http.StatusForbidden},
+		{name: "rebound host", host: "attacker.test:8090", code: http.StatusForbidden},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL+"/pause", nil)
+			if err != nil {
+				t.Fatalf("error creating request: %v", err)
+			}
+
+			if tcase.host != "" {
+				req.Host = tcase.host
+			}
+
+			if tcase.origin != "" {
+				req.Header.Set("Origin", tcase.origin)
+			}
+
+			rsp, err := srv.Client().Do(req)
+			if err != nil {
+				t.Fatalf("error calling admin API: %v", err)
+			}
+			defer rsp.Body.Close()
+
+			if rsp.StatusCode != tcase.code {
+				t.Fatalf("expected status %d, got %d", tcase.code, rsp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestRunControlWaitContext(t *testing.T) {
+	t.Parallel()
+
+	var nilControl *runControl
+	if err := nilControl.wait(context.Background()); err != nil {
+		t.Fatalf("expected a nil run control not to wait, got %v", err)
+	}
+
+	control := newRunControl()
+	control.pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := control.wait(ctx); err == nil {
+		t.Fatal("expected the wait to stop with the context")
+	}
+}
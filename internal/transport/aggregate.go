@@ -0,0 +1,362 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var ErrInvalidAggregate = fmt.Errorf("invalid aggregate")
+
+// InvalidAggregateError wraps an error with ErrInvalidAggregate.
+func InvalidAggregateError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAggregate, reason)
+}
+
+// The aggregation functions of aggregate fields.
+const (
+	AggregateSum   = "sum"
+	AggregateAvg   = "avg"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateFirst = "first"
+	AggregateLast  = "last"
+	AggregateCount = "count"
+)
+
+// AggregateConfig downsamples the records of a request before they are stored, e.g. to store one minute bars of
+// tick-level data: the records are grouped by the time bucket of their time field and by their key fields, and each
+// group is stored as a single record holding the time bucket, the keys, and the aggregate fields. Records are only
+// aggregated within a response, so a bucket is complete if the timeseries period is a multiple of the interval and the
+// web API returns each range in a single page.
+type AggregateConfig struct {
+	// Time is the time field of the records, which holds the start of its bucket in the aggregated records.
+	Time string `yaml:"time"`
+
+	// Layout is the time layout of time fields that hold strings. Defaults to RFC3339. Time fields that hold numbers
+	// are seconds since the Unix epoch.
+	Layout string `yaml:"layout"`
+
+	// Interval is the length of the time buckets, e.g. "1m".
+	Interval *time.Duration `yaml:"interval"`
+
+	// Keys are the fields, other than the time bucket, that records are grouped by, e.g. the symbol.
+	Keys []string `yaml:"keys"`
+
+	// Fields are the aggregate fields of the aggregated records.
+	Fields []*AggregateField `yaml:"fields"`
+}
+
+// AggregateField is a field of the aggregated records, computed from a field of the records of its group.
+type AggregateField struct {
+	// Field is the field of the records that is aggregated. Null and missing values are ignored.
+	Field string `yaml:"field"`
+
+	// Func is the aggregation function: "sum", "avg", "min", "max", "first", "last", or "count". The "first" and
+	// "last" values are those of the earliest and latest records of the group, the others require numbers or strings
+	// that hold numbers.
+	Func string `yaml:"func"`
+
+	// As is the name of the aggregate field. Defaults to the field.
+	As string `yaml:"as"`
+}
+
+// name will return the name of the aggregate field.
+func (af *AggregateField) name() string {
+	if af.As != "" {
+		return af.As
+	}
+
+	return af.Field
+}
+
+func (ac *AggregateConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+
+	if ac.Time == "" {
+		return InvalidAggregateError("time is required")
+	}
+
+	if ac.Interval == nil || *ac.Interval <= 0 {
+		return InvalidAggregateError("interval must be positive")
+	}
+
+	if len(ac.Fields) == 0 {
+		return InvalidAggregateError("fields are required")
+	}
+
+	names := map[string]bool{ac.Time: true}
+	for _, key := range ac.Keys {
+		names[key] = true
+	}
+
+	for _, field := range ac.Fields {
+		if field.Field == "" {
+			return InvalidAggregateError("field is required")
+		}
+
+		switch field.Func {
+		case AggregateSum, AggregateAvg, AggregateMin, AggregateMax, AggregateFirst, AggregateLast, AggregateCount:
+		default:
+			return InvalidAggregateError(fmt.Sprintf("unknown func %q of %q", field.Func, field.Field))
+		}
+
+		if names[field.name()] {
+			return InvalidAggregateError(fmt.Sprintf("duplicate field %q", field.name()))
+		}
+
+		names[field.name()] = true
+	}
+
+	return nil
+}
+
+// aggregateGroup is the state of the aggregation of the records of a group.
+type aggregateGroup struct {
+	record map[string]interface{}
+	start  time.Time
+
+	// first and last are the times of the values of the "first" and "last" fields.
+	first, last []time.Time
+
+	// sums and counts are the sums and number of values of the fields.
+	sums   []float64
+	counts []int
+}
+
+// apply will aggregate the records of JSON encoded upsert data. The aggregated records are ordered by time bucket, and
+// then by the order that their groups first appeared in.
+func (ac *AggregateConfig) apply(data []byte) ([]byte, error) {
+	if ac == nil {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	groups := make(map[string]*aggregateGroup)
+	order := make([]*aggregateGroup, 0)
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		recordTime, bucket, err := ac.bucket(rec[ac.Time])
+		if err != nil {
+			return nil, err
+		}
+
+		groupKey, err := ac.groupKey(rec, recordTime)
+		if err != nil {
+			return nil, err
+		}
+
+		group := groups[groupKey]
+		if group == nil {
+			group = ac.newGroup(rec, recordTime, bucket)
+			groups[groupKey] = group
+			order = append(order, group)
+		}
+
+		if err := ac.add(group, rec, recordTime); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return order[i].start.Before(order[j].start) })
+
+	aggregated := make([]map[string]interface{}, 0, len(order))
+	for _, group := range order {
+		aggregated = append(aggregated, ac.result(group))
+	}
+
+	aggregatedData, err := json.Marshal(aggregated)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return aggregatedData, nil
+}
+
+// bucket will return the time of a record's time field, and the value of its bucket, in the representation of the
+// time field.
+func (ac *AggregateConfig) bucket(value interface{}) (time.Time, interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		layout := ac.Layout
+		if layout == "" {
+			layout = time.RFC3339
+		}
+
+		recordTime, err := time.Parse(layout, value)
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("unable to aggregate %q: %w", ac.Time, err)
+		}
+
+		start := recordTime.Truncate(*ac.Interval)
+
+		return recordTime, start.Format(layout), nil
+	case json.Number:
+		seconds, err := value.Float64()
+		if err != nil {
+			return time.Time{}, nil, fmt.Errorf("unable to aggregate %q: %w", ac.Time, err)
+		}
+
+		recordTime := time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+		start := recordTime.Truncate(*ac.Interval)
+
+		return recordTime, json.Number(strconv.FormatInt(start.Unix(), 10)), nil
+	default:
+		return time.Time{}, nil, InvalidAggregateError(fmt.Sprintf("time field %q must hold a string or a number",
+			ac.Time))
+	}
+}
+
+// groupKey will return the key of the group of a record: its time bucket and the composite key of its key fields.
+// Records that are missing a key field are grouped by null.
+func (ac *AggregateConfig) groupKey(rec map[string]interface{}, recordTime time.Time) (string, error) {
+	encoded, err := tools.CompositeKey{Fields: ac.Keys, AllowNulls: true}.Key(rec)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(recordTime.Truncate(*ac.Interval).UnixNano(), 10) + "," + encoded, nil
+}
+
+// newGroup will return the group of a record, holding its time bucket and key fields.
+func (ac *AggregateConfig) newGroup(rec map[string]interface{}, recordTime time.Time,
+	bucket interface{},
+) *aggregateGroup {
+	group := &aggregateGroup{
+		record: map[string]interface{}{ac.Time: bucket},
+		start:  recordTime.Truncate(*ac.Interval),
+		first:  make([]time.Time, len(ac.Fields)),
+		last:   make([]time.Time, len(ac.Fields)),
+		sums:   make([]float64, len(ac.Fields)),
+		counts: make([]int, len(ac.Fields)),
+	}
+
+	for _, key := range ac.Keys {
+		group.record[key] = rec[key]
+	}
+
+	return group
+}
+
+// add will add the fields of a record to the aggregate fields of its group.
+func (ac *AggregateConfig) add(group *aggregateGroup, rec map[string]interface{}, recordTime time.Time) error {
+	for idx, field := range ac.Fields {
+		value, ok := rec[field.Field]
+		if !ok || value == nil {
+			continue
+		}
+
+		group.counts[idx]++
+
+		switch field.Func {
+		case AggregateCount:
+			continue
+		case AggregateFirst:
+			if group.counts[idx] == 1 || recordTime.Before(group.first[idx]) {
+				group.first[idx] = recordTime
+				group.record[field.name()] = value
+			}
+
+			continue
+		case AggregateLast:
+			if group.counts[idx] == 1 || !recordTime.Before(group.last[idx]) {
+				group.last[idx] = recordTime
+				group.record[field.name()] = value
+			}
+
+			continue
+		}
+
+		number, err := aggregateNumber(value)
+		if err != nil {
+			return InvalidAggregateError(fmt.Sprintf("%s of %q: %v", field.Func, field.Field, err))
+		}
+
+		switch field.Func {
+		case AggregateSum, AggregateAvg:
+			group.sums[idx] += number
+		case AggregateMin:
+			if group.counts[idx] == 1 || number < group.sums[idx] {
+				group.sums[idx] = number
+			}
+		case AggregateMax:
+			if group.counts[idx] == 1 || number > group.sums[idx] {
+				group.sums[idx] = number
+			}
+		}
+	}
+
+	return nil
+}
+
+// result will return the aggregated record of a group. Aggregate fields without values are null, except for counts.
+func (ac *AggregateConfig) result(group *aggregateGroup) map[string]interface{} {
+	for idx, field := range ac.Fields {
+		switch {
+		case field.Func == AggregateCount:
+			group.record[field.name()] = group.counts[idx]
+		case group.counts[idx] == 0:
+			group.record[field.name()] = nil
+		case field.Func == AggregateAvg:
+			group.record[field.name()] = group.sums[idx] / float64(group.counts[idx])
+		case field.Func != AggregateFirst && field.Func != AggregateLast:
+			group.record[field.name()] = group.sums[idx]
+		}
+	}
+
+	return group.record
+}
+
+// aggregateNumber will return the number held by a JSON value, which can be a number or a string that holds a number.
+func aggregateNumber(value interface{}) (float64, error) {
+	switch value := value.(type) {
+	case json.Number:
+		number, err := value.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %w", err)
+		}
+
+		return number, nil
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number: %w", err)
+		}
+
+		return number, nil
+	default:
+		return 0, fmt.Errorf("%T is not a number", value)
+	}
+}
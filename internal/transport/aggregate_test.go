@@ -0,0 +1,137 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAggregateConfigApply(t *testing.T) {
+	t.Parallel()
+
+	minute := time.Minute
+
+	fields := []*AggregateField{
+		{Field: "price", Func: AggregateFirst, As: "open"},
+		{Field: "price", Func: AggregateMax, As: "high"},
+		{Field: "price", Func: AggregateMin, As: "low"},
+		{Field: "price", Func: AggregateLast, As: "close"},
+		{Field: "size", Func: AggregateSum, As: "volume"},
+		{Field: "size", Func: AggregateAvg, As: "avg_size"},
+		{Field: "price", Func: AggregateCount, As: "trades"},
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		ac       *AggregateConfig
+		data     string
+		expected string
+	}{
+		{
+			name:     "nil",
+			data:     `[{"time":"2022-05-10T00:00:01Z"}]`,
+			expected: `[{"time":"2022-05-10T00:00:01Z"}]`,
+		},
+		{
+			name: "bars",
+			ac:   &AggregateConfig{Time: "time", Interval: &minute, Keys: []string{"symbol"}, Fields: fields},
+			data: `[
+				{"time":"2022-05-10T00:00:30Z","symbol":"BTC","price":"11","size":2},
+				{"time":"2022-05-10T00:00:10Z","symbol":"BTC","price":"10","size":1},
+				{"time":"2022-05-10T00:01:00Z","symbol":"BTC","price":"12","size":3},
+				{"time":"2022-05-10T00:00:50Z","symbol":"ETH","price":"5","size":4},
+				{"time":"2022-05-10T00:00:55Z","symbol":"BTC","price":"9","size":null}
+			]`,
+			expected: `[
+				{"time":"2022-05-10T00:00:00Z","symbol":"BTC","open":"10","high":11,"low":9,"close":"9","volume":3,
+					"avg_size":1.5,"trades":3},
+				{"time":"2022-05-10T00:00:00Z","symbol":"ETH","open":"5","high":5,"low":5,"close":"5","volume":4,
+					"avg_size":4,"trades":1},
+				{"time":"2022-05-10T00:01:00Z","symbol":"BTC","open":"12","high":12,"low":12,"close":"12","volume":3,
+					"avg_size":3,"trades":1}
+			]`,
+		},
+		{
+			name: "unix seconds",
+			ac: &AggregateConfig{Time: "ts", Interval: &minute, Fields: []*AggregateField{
+				{Field: "size", Func: AggregateSum},
+			}},
+			data:     `[{"ts":1652140810,"size":1},{"ts":1652140859.5,"size":2},{"ts":1652140861,"size":4}]`,
+			expected: `[{"ts":1652140800,"size":3},{"ts":1652140860,"size":4}]`,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := tcase.ac.apply([]byte(tcase.data))
+			if err != nil {
+				t.Fatalf("error aggregating records: %v", err)
+			}
+
+			var actual, expected interface{}
+			if err := json.Unmarshal(data, &actual); err != nil {
+				t.Fatalf("error decoding aggregated records: %v", err)
+			}
+
+			if err := json.Unmarshal([]byte(tcase.expected), &expected); err != nil {
+				t.Fatalf("error decoding expected records: %v", err)
+			}
+
+			if !reflect.DeepEqual(actual, expected) {
+				t.Fatalf("expected %v, got %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestAggregateConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	minute := time.Minute
+	sum := []*AggregateField{{Field: "size", Func: AggregateSum}}
+
+	for _, tcase := range []struct {
+		name string
+		ac   *AggregateConfig
+		err  error
+	}{
+		{name: "nil"},
+		{name: "valid", ac: &AggregateConfig{Time: "time", Interval: &minute, Fields: sum}},
+		{name: "no time", ac: &AggregateConfig{Interval: &minute, Fields: sum}, err: ErrInvalidAggregate},
+		{name: "no interval", ac: &AggregateConfig{Time: "time", Fields: sum}, err: ErrInvalidAggregate},
+		{name: "no fields", ac: &AggregateConfig{Time: "time", Interval: &minute}, err: ErrInvalidAggregate},
+		{
+			name: "unknown func",
+			ac: &AggregateConfig{Time: "time", Interval: &minute, Fields: []*AggregateField{
+				{Field: "size", Func: "median"},
+			}},
+			err: ErrInvalidAggregate,
+		},
+		{
+			name: "duplicate field",
+			ac:   &AggregateConfig{Time: "time", Interval: &minute, Keys: []string{"size"}, Fields: sum},
+			err:  ErrInvalidAggregate,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.ac.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultAnomalyThreshold is the default fraction that a run's counts can deviate from the historical mean.
+	defaultAnomalyThreshold = 0.5
+
+	// defaultAnomalyMinRuns is the default number of previous runs needed before counts are checked.
+	defaultAnomalyMinRuns = 3
+
+	// defaultAnomalyWindow is the default number of previous runs kept for each table.
+	defaultAnomalyWindow = 10
+
+	anomalyHistoryFileMode = 0o600
+)
+
+var (
+	ErrAnomaly            = fmt.Errorf("anomaly detected")
+	ErrInvalidAnomalyConf = fmt.Errorf("invalid anomaly configuration")
+)
+
+// AnomalyAction is what happens when a run's counts deviate from the history of previous runs.
+type AnomalyAction string
+
+const (
+	// AnomalyWarn logs a warning and stores the data.
+	AnomalyWarn AnomalyAction = "warn"
+
+	// AnomalyFail aborts the operation and rolls back the transactions.
+	AnomalyFail AnomalyAction = "fail"
+)
+
+// AnomalyConfig detects runs whose record counts or response sizes deviate from the history of previous runs, e.g. an
+// endpoint that normally returns 10k records returning 3, to catch silent breakage of the web API. The counts of each
+// table are kept in a history file after every committed run.
+type AnomalyConfig struct {
+	// HistoryFile is the path to the file where the counts of previous runs are kept.
+	HistoryFile string `yaml:"historyFile"`
+
+	// Threshold is the fraction that a table's record count or response size can deviate from the mean of previous
+	// runs, e.g. 0.5 allows between half and one and a half times the mean. Defaults to 0.5.
+	Threshold float64 `yaml:"threshold"`
+
+	// MinRuns is the number of previous runs of a table needed before its counts are checked. Defaults to 3.
+	MinRuns int `yaml:"minRuns"`
+
+	// Window is the number of previous runs kept for each table. Defaults to 10.
+	Window int `yaml:"window"`
+
+	// Action is what happens when an anomaly is detected: "warn" or "fail". Defaults to "warn".
+	Action AnomalyAction `yaml:"action"`
+}
+
+func (ac *AnomalyConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+
+	if ac.HistoryFile == "" {
+		return fmt.Errorf("%w: historyFile is required", ErrInvalidAnomalyConf)
+	}
+
+	if ac.Threshold < 0 {
+		return fmt.Errorf("%w: threshold must be non-negative", ErrInvalidAnomalyConf)
+	}
+
+	if ac.MinRuns < 0 || ac.Window < 0 {
+		return fmt.Errorf("%w: minRuns and window must be non-negative", ErrInvalidAnomalyConf)
+	}
+
+	switch ac.Action {
+	case "", AnomalyWarn, AnomalyFail:
+	default:
+		return fmt.Errorf("%w: action must be warn or fail", ErrInvalidAnomalyConf)
+	}
+
+	return nil
+}
+
+// AnomalyRun are the counts of a table for a single run.
+type AnomalyRun struct {
+	// Time is when the run was committed.
+	Time time.Time `yaml:"time"`
+
+	// Records is the number of records fetched for the table.
+	Records int64 `yaml:"records"`
+
+	// Bytes is the size of the responses fetched for the table.
+	Bytes int64 `yaml:"bytes"`
+}
+
+// runCounts counts the records and response bytes fetched for each table during a transport operation. A nil
+// runCounts is valid and counts nothing.
+type runCounts struct {
+	tables map[string]*AnomalyRun
+	mu     sync.Mutex
+}
+
+// newRunCounts will return the counts for the tables of the flattened requests, or nil if anomaly detection is not
+// enabled. Every table starts at zero, so that tables whose chunks all failed are checked too.
+func (ac *AnomalyConfig) newRunCounts(flattenedRequests []*flattenedRequest) *runCounts {
+	if ac == nil {
+		return nil
+	}
+
+	counts := &runCounts{tables: make(map[string]*AnomalyRun)}
+	for _, req := range flattenedRequests {
+		counts.tables[req.table] = new(AnomalyRun)
+	}
+
+	return counts
+}
+
+// countRecords will return the number of records in JSON encoded response data: the length of a list, or one for
+// any other value.
+func countRecords(data []byte) int64 {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		return 1
+	}
+
+	return int64(len(list))
+}
+
+// add will count the records and size of a response fetched for a table.
+func (counts *runCounts) add(table string, data []byte) {
+	if counts == nil {
+		return
+	}
+
+	records := countRecords(data)
+
+	counts.mu.Lock()
+	defer counts.mu.Unlock()
+
+	run := counts.tables[table]
+	run.Records += records
+	run.Bytes += int64(len(data))
+}
+
+// deviation will return the fraction that a count deviates from the mean, or zero if the mean is zero.
+func deviation(count int64, mean float64) float64 {
+	if mean == 0 {
+		return 0
+	}
+
+	return math.Abs(float64(count)-mean) / mean
+}
+
+// readHistory will read the counts of previous runs, keyed by table. If the file does not exist, there is no history.
+func (ac *AnomalyConfig) readHistory() (map[string][]*AnomalyRun, error) {
+	bytes, err := os.ReadFile(ac.HistoryFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[string][]*AnomalyRun), nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read anomaly history file: %w", err)
+	}
+
+	history := make(map[string][]*AnomalyRun)
+	if err := yaml.Unmarshal(bytes, &history); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal anomaly history: %w", err)
+	}
+
+	return history, nil
+}
+
+// anomalies will return a description of every table whose counts deviate from the history beyond the threshold.
+func (ac *AnomalyConfig) anomalies(history map[string][]*AnomalyRun, counts *runCounts) []string {
+	threshold, minRuns := ac.Threshold, ac.MinRuns
+	if threshold == 0 {
+		threshold = defaultAnomalyThreshold
+	}
+
+	if minRuns == 0 {
+		minRuns = defaultAnomalyMinRuns
+	}
+
+	var anomalies []string
+
+	for table, run := range counts.tables {
+		runs := history[table]
+		if len(runs) < minRuns {
+			continue
+		}
+
+		var records, bytes float64
+		for _, prev := range runs {
+			records += float64(prev.Records)
+			bytes += float64(prev.Bytes)
+		}
+
+		records /= float64(len(runs))
+		bytes /= float64(len(runs))
+
+		if deviation(run.Records, records) > threshold {
+			anomalies = append(anomalies, fmt.Sprintf("%s: %d records, expected about %.0f", table, run.Records,
+				records))
+		}
+
+		if deviation(run.Bytes, bytes) > threshold {
+			anomalies = append(anomalies, fmt.Sprintf("%s: %d response bytes, expected about %.0f", table, run.Bytes,
+				bytes))
+		}
+	}
+
+	sort.Strings(anomalies)
+
+	return anomalies
+}
+
+// check will compare the counts of the run to the history of previous runs. Anomalies are logged as warnings, or
+// returned as an error wrapping ErrAnomaly if the action is "fail".
+func (ac *AnomalyConfig) check(logger *logrus.Logger, counts *runCounts) error {
+	if ac == nil || counts == nil {
+		return nil
+	}
+
+	history, err := ac.readHistory()
+	if err != nil {
+		return err
+	}
+
+	anomalies := ac.anomalies(history, counts)
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	if ac.Action == AnomalyFail {
+		return fmt.Errorf("%w: %s", ErrAnomaly, strings.Join(anomalies, "; "))
+	}
+
+	for _, anomaly := range anomalies {
+		logger.Warn(tools.LogFormatter{Msg: fmt.Sprintf("%v: %s", ErrAnomaly, anomaly)}.String())
+	}
+
+	return nil
+}
+
+// record will append the counts of a committed run to the history file, keeping the window of the latest runs of
+// each table.
+func (ac *AnomalyConfig) record(counts *runCounts) error {
+	if ac == nil || counts == nil {
+		return nil
+	}
+
+	history, err := ac.readHistory()
+	if err != nil {
+		return err
+	}
+
+	window := ac.Window
+	if window == 0 {
+		window = defaultAnomalyWindow
+	}
+
+	now := time.Now().UTC()
+
+	for table, run := range counts.tables {
+		run.Time = now
+
+		runs := append(history[table], run)
+		if len(runs) > window {
+			runs = runs[len(runs)-window:]
+		}
+
+		history[table] = runs
+	}
+
+	bytes, err := yaml.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("unable to marshal anomaly history: %w", err)
+	}
+
+	if err := os.WriteFile(ac.HistoryFile, bytes, anomalyHistoryFileMode); err != nil {
+		return fmt.Errorf("unable to write anomaly history file: %w", err)
+	}
+
+	return nil
+}
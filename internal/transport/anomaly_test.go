@@ -0,0 +1,110 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCountRecords(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		data     string
+		expected int64
+	}{
+		{data: `[{"id":1},{"id":2}]`, expected: 2},
+		{data: `[]`, expected: 0},
+		{data: `{"id":1}`, expected: 1},
+	} {
+		if got := countRecords([]byte(tcase.data)); got != tcase.expected {
+			t.Fatalf("expected %d records in %s, got %d", tcase.expected, tcase.data, got)
+		}
+	}
+}
+
+func TestAnomalyCheck(t *testing.T) {
+	t.Parallel()
+
+	anomaly := &AnomalyConfig{
+		HistoryFile: filepath.Join(t.TempDir(), "history.yml"),
+		MinRuns:     2,
+		Window:      3,
+		Action:      AnomalyFail,
+	}
+
+	flatReqs := []*flattenedRequest{{table: "candles"}}
+	normal := `[{"id":1},{"id":2},{"id":3},{"id":4}]`
+
+	// Record the history of normal runs, which are not checked until there are enough of them.
+	for run := 0; run < 4; run++ {
+		counts := anomaly.newRunCounts(flatReqs)
+		counts.add("candles", []byte(normal))
+
+		if err := anomaly.check(logrus.New(), counts); err != nil {
+			t.Fatalf("expected no anomaly on run %d, got %v", run, err)
+		}
+
+		if err := anomaly.record(counts); err != nil {
+			t.Fatalf("failed to record run: %v", err)
+		}
+	}
+
+	history, err := anomaly.readHistory()
+	if err != nil {
+		t.Fatalf("failed to read history: %v", err)
+	}
+
+	if len(history["candles"]) != anomaly.Window {
+		t.Fatalf("expected %d runs in history, got %d", anomaly.Window, len(history["candles"]))
+	}
+
+	// A run that returns a fraction of the records is an anomaly.
+	counts := anomaly.newRunCounts(flatReqs)
+	counts.add("candles", []byte(`[{"id":1}]`))
+
+	if err := anomaly.check(logrus.New(), counts); !errors.Is(err, ErrAnomaly) {
+		t.Fatalf("expected error %v, got %v", ErrAnomaly, err)
+	}
+
+	// Warnings do not fail the run.
+	anomaly.Action = AnomalyWarn
+	if err := anomaly.check(logrus.New(), counts); err != nil {
+		t.Fatalf("expected no error when warning, got %v", err)
+	}
+}
+
+func TestAnomalyConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		ac   *AnomalyConfig
+		err  error
+	}{
+		{name: "nil"},
+		{name: "valid", ac: &AnomalyConfig{HistoryFile: "history.yml", Action: AnomalyFail}},
+		{name: "no history file", ac: &AnomalyConfig{}, err: ErrInvalidAnomalyConf},
+		{name: "negative threshold", ac: &AnomalyConfig{HistoryFile: "h", Threshold: -1}, err: ErrInvalidAnomalyConf},
+		{name: "invalid action", ac: &AnomalyConfig{HistoryFile: "h", Action: "ignore"}, err: ErrInvalidAnomalyConf},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.ac.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
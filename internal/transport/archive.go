@@ -0,0 +1,278 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	archiveDirMode  = 0o755
+	archiveFileMode = 0o644
+
+	// archiveIndexTimeFormat is the format of the start time in the key of an index.
+	archiveIndexTimeFormat = "20060102T150405.000000000Z"
+)
+
+var ErrInvalidArchive = fmt.Errorf("invalid archive")
+
+// InvalidArchiveError wraps an error with ErrInvalidArchive.
+func InvalidArchiveError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidArchive, reason)
+}
+
+// ArchiveStore stores the objects of the raw response archive. Custom stores, e.g. for an object store, can be set on
+// the configuration's "ArchiveStore".
+type ArchiveStore interface {
+	// Put will store an object under a key, e.g. "objects/ab/ab12....zst". Objects are content-addressed, so putting
+	// a key that exists can keep the stored object.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get will return the object stored under a key.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// List will return the keys of the objects that start with a prefix, e.g. "index/".
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ArchiveConfig archives the body of every web response, compressed with zstd and keyed by its SHA-256 hash, along
+// with an index of the requests of each run that returned them. The archive is written alongside the storage devices,
+// so that runs can be replayed and audited against what the web API returned.
+type ArchiveConfig struct {
+	// Dir is the directory of the built-in archive store.
+	Dir string `yaml:"dir"`
+}
+
+func (ac *ArchiveConfig) validate(store ArchiveStore) error {
+	if ac == nil {
+		return nil
+	}
+
+	if ac.Dir == "" && store == nil {
+		return InvalidArchiveError("dir is required")
+	}
+
+	return nil
+}
+
+// ArchiveEntry is an entry of the index of a run's archived responses.
+type ArchiveEntry struct {
+	Time   time.Time `json:"time"`
+	RunID  string    `json:"runId"`
+	Table  string    `json:"table"`
+	Method string    `json:"method"`
+	URL    string    `json:"url"`
+
+	// Hash is the hex encoded SHA-256 hash of the response body, which is archived as "objects/<hash[:2]>/<hash>.zst".
+	Hash string `json:"hash"`
+
+	// Size is the size in bytes of the response body.
+	Size int `json:"size"`
+}
+
+// dirArchiveStore is the built-in archive store, which writes the objects as files of a directory.
+type dirArchiveStore string
+
+// Put will write an object to its file, unless the file exists. The object is written to a temporary file that is
+// renamed, so that a crash never leaves a partial object.
+func (store dirArchiveStore) Put(_ context.Context, key string, data []byte) error {
+	name := filepath.Join(string(store), filepath.FromSlash(key))
+
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), archiveDirMode); err != nil {
+		return fmt.Errorf("unable to create archive directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".archive-*")
+	if err != nil {
+		return fmt.Errorf("unable to create archive object: %w", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+
+		return fmt.Errorf("unable to write archive object: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write archive object: %w", err)
+	}
+
+	if err := os.Chmod(tmp.Name(), archiveFileMode); err != nil {
+		return fmt.Errorf("unable to write archive object: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		return fmt.Errorf("unable to write archive object: %w", err)
+	}
+
+	return nil
+}
+
+// Get will read the file of an object.
+func (store dirArchiveStore) Get(_ context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(string(store), filepath.FromSlash(key)))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read archive object: %w", err)
+	}
+
+	return data, nil
+}
+
+// List will return the keys of the files of a directory of the archive, where the prefix ends with the directory.
+func (store dirArchiveStore) List(_ context.Context, prefix string) ([]string, error) {
+	dir := path.Dir(prefix + "_")
+
+	entries, err := os.ReadDir(filepath.Join(string(store), filepath.FromSlash(dir)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to list archive: %w", err)
+	}
+
+	var keys []string
+
+	for _, entry := range entries {
+		key := path.Join(dir, entry.Name())
+		if !entry.IsDir() && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// archiver archives the web responses of a run.
+type archiver struct {
+	store   ArchiveStore
+	runID   string
+	start   time.Time
+	encoder *zstd.Encoder
+
+	mu      sync.Mutex
+	entries []*ArchiveEntry
+}
+
+// archiveStore will return the store of the archive, the custom store if it is set.
+func (cfg *Config) archiveStore() ArchiveStore {
+	if cfg.ArchiveStore != nil {
+		return cfg.ArchiveStore
+	}
+
+	return dirArchiveStore(cfg.Archive.Dir)
+}
+
+// newArchiver will return the archiver of a run, or nil if responses are not archived.
+func (cfg *Config) newArchiver(runID string) (*archiver, error) {
+	if cfg.Archive == nil && cfg.ArchiveStore == nil {
+		return nil, nil
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create zstd encoder: %w", err)
+	}
+
+	return &archiver{store: cfg.archiveStore(), runID: runID, start: time.Now(), encoder: encoder}, nil
+}
+
+// archiveKey will return the key of the object of a response body hash.
+func archiveKey(hash string) string {
+	return path.Join("objects", hash[:2], hash+".zst")
+}
+
+// add will archive the body of a web response and add the request to the index.
+func (arc *archiver) add(ctx context.Context, table string, req *http.Request, body []byte) error {
+	if arc == nil {
+		return nil
+	}
+
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+
+	if err := arc.store.Put(ctx, archiveKey(hash), arc.encoder.EncodeAll(body, nil)); err != nil {
+		return fmt.Errorf("unable to archive response: %w", err)
+	}
+
+	entry := &ArchiveEntry{
+		Time:   time.Now().UTC(),
+		RunID:  arc.runID,
+		Table:  table,
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Hash:   hash,
+		Size:   len(body),
+	}
+
+	arc.mu.Lock()
+	defer arc.mu.Unlock()
+
+	arc.entries = append(arc.entries, entry)
+
+	return nil
+}
+
+// writeIndex will store the index of the run's archived responses as "index/<start>-<run ID>.ndjson", one JSON entry
+// per line, where the start time of the run orders the indexes of runs that share an ID.
+func (arc *archiver) writeIndex(ctx context.Context, logger *logrus.Logger) error {
+	if arc == nil {
+		return nil
+	}
+
+	defer arc.encoder.Close()
+
+	arc.mu.Lock()
+	defer arc.mu.Unlock()
+
+	if len(arc.entries) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range arc.entries {
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("unable to encode archive index: %w", err)
+		}
+	}
+
+	key := path.Join("index", fmt.Sprintf("%s-%s.ndjson", arc.start.UTC().Format(archiveIndexTimeFormat), arc.runID))
+	if err := arc.store.Put(ctx, key, buf.Bytes()); err != nil {
+		return fmt.Errorf("unable to write archive index: %w", err)
+	}
+
+	logInfo := tools.LogFormatter{Msg: fmt.Sprintf("archived %d web responses to %q", len(arc.entries), key)}
+	logger.Info(logInfo.String())
+
+	return nil
+}
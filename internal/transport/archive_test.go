@@ -0,0 +1,215 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/sirupsen/logrus"
+)
+
+// memoryArchiveStore is an archive store that keeps its objects in memory.
+type memoryArchiveStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (store *memoryArchiveStore) Put(_ context.Context, key string, data []byte) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.objects[key] = data
+
+	return nil
+}
+
+func (store *memoryArchiveStore) Get(_ context.Context, key string) ([]byte, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	data, ok := store.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	return data, nil
+}
+
+func (store *memoryArchiveStore) List(_ context.Context, prefix string) ([]string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	var keys []string
+
+	for key := range store.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+func TestArchiver(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	newRequest := func(t *testing.T, uri string) *http.Request {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, uri, nil)
+		if err != nil {
+			t.Fatalf("unable to create request: %v", err)
+		}
+
+		return req
+	}
+
+	t.Run("dir", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Archive: &ArchiveConfig{Dir: t.TempDir()}}
+
+		arc, err := cfg.newArchiver("run")
+		if err != nil {
+			t.Fatalf("unable to create archiver: %v", err)
+		}
+
+		body := []byte(`[{"id":1}]`)
+		for _, uri := range []string{"https://api.example.com/a", "https://api.example.com/b"} {
+			if err := arc.add(context.Background(), "a", newRequest(t, uri), body); err != nil {
+				t.Fatalf("unable to archive response: %v", err)
+			}
+		}
+
+		if err := arc.writeIndex(context.Background(), logger); err != nil {
+			t.Fatalf("unable to write index: %v", err)
+		}
+
+		objects, _ := filepath.Glob(filepath.Join(cfg.Archive.Dir, "objects", "*", "*.zst"))
+		if len(objects) != 1 {
+			t.Fatalf("expected identical responses to be archived once, got %v", objects)
+		}
+
+		compressed, err := os.ReadFile(objects[0])
+		if err != nil {
+			t.Fatalf("unable to read object: %v", err)
+		}
+
+		decoder, _ := zstd.NewReader(nil)
+		defer decoder.Close()
+
+		if decompressed, err := decoder.DecodeAll(compressed, nil); err != nil || string(decompressed) != string(body) {
+			t.Fatalf("expected the archived response %s, got %s (%v)", body, decompressed, err)
+		}
+
+		indexes, _ := filepath.Glob(filepath.Join(cfg.Archive.Dir, "index", "*-run.ndjson"))
+		if len(indexes) != 1 {
+			t.Fatalf("expected an index of the run, got %v", indexes)
+		}
+
+		file, err := os.Open(indexes[0])
+		if err != nil {
+			t.Fatalf("unable to open index: %v", err)
+		}
+		defer file.Close()
+
+		var entries []*ArchiveEntry
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			entry := new(ArchiveEntry)
+			if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+				t.Fatalf("unable to decode index entry: %v", err)
+			}
+
+			entries = append(entries, entry)
+		}
+
+		if len(entries) != 2 || entries[1].URL != "https://api.example.com/b" || entries[1].Size != len(body) ||
+			!strings.HasSuffix(objects[0], entries[0].Hash+".zst") || entries[0].RunID != "run" {
+			t.Fatalf("unexpected index entries: %+v", entries)
+		}
+	})
+
+	t.Run("custom store", func(t *testing.T) {
+		t.Parallel()
+
+		store := &memoryArchiveStore{objects: make(map[string][]byte)}
+		cfg := &Config{Archive: &ArchiveConfig{Dir: "unused"}, ArchiveStore: store}
+
+		arc, err := cfg.newArchiver("run")
+		if err != nil {
+			t.Fatalf("unable to create archiver: %v", err)
+		}
+
+		if err := arc.add(context.Background(), "a", newRequest(t, "https://api.example.com"), []byte("{}")); err != nil {
+			t.Fatalf("unable to archive response: %v", err)
+		}
+
+		if err := arc.writeIndex(context.Background(), logger); err != nil {
+			t.Fatalf("unable to write index: %v", err)
+		}
+
+		if len(store.objects) != 2 {
+			t.Fatalf("expected an object and an index, got %d objects", len(store.objects))
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		arc, err := new(Config).newArchiver("run")
+		if err != nil || arc != nil {
+			t.Fatalf("expected no archiver, got %v, %v", arc, err)
+		}
+
+		if err := arc.add(context.Background(), "a", nil, nil); err != nil {
+			t.Fatalf("expected a nil archiver to archive nothing, got %v", err)
+		}
+	})
+}
+
+func TestArchiveConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name      string
+		archive   *ArchiveConfig
+		store     ArchiveStore
+		expectErr error
+	}{
+		{name: "nil"},
+		{name: "dir", archive: &ArchiveConfig{Dir: "archive"}},
+		{name: "custom store", archive: &ArchiveConfig{}, store: new(memoryArchiveStore)},
+		{name: "missing dir", archive: &ArchiveConfig{}, expectErr: ErrInvalidArchive},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.archive.validate(tcase.store); !errors.Is(err, tcase.expectErr) {
+				t.Fatalf("expected error %v, got %v", tcase.expectErr, err)
+			}
+		})
+	}
+}
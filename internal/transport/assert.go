@@ -0,0 +1,179 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var (
+	ErrAssertionFailed  = fmt.Errorf("assertion failed")
+	ErrInvalidAssertion = fmt.Errorf("invalid assertion")
+)
+
+// InvalidAssertionError wraps an error with ErrInvalidAssertion.
+func InvalidAssertionError(name, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidAssertion, name, reason)
+}
+
+// Assertion is a data quality check of a table that runs once the data of a transport operation is loaded, before it
+// is committed. If an assertion does not hold, the transactions are rolled back and the operation fails. Exactly one
+// of "Query", "NullField", or "Unique" is set.
+type Assertion struct {
+	// Name identifies the assertion in logs and errors. Defaults to the table.
+	Name string `yaml:"name"`
+
+	// Table is the table/collection that is checked.
+	Table string `yaml:"table"`
+
+	// Storage is the scheme of the storage devices that the assertion runs on, e.g. "postgresql" for SQL queries.
+	// By default, it runs on every storage device that supports assertions.
+	Storage string `yaml:"storage"`
+
+	// Query is a native query that returns a single number, which must be within "Min" and "Max": a SQL query, or a
+	// MongoDB aggregation pipeline on the table as extended JSON, whose first document's first field is the number.
+	Query string `yaml:"query"`
+
+	// Min is the smallest number the query can return.
+	Min *float64 `yaml:"min"`
+
+	// Max is the largest number the query can return.
+	Max *float64 `yaml:"max"`
+
+	// NullField is a field whose fraction of null or missing values must not exceed "MaxNullRate".
+	NullField string `yaml:"nullField"`
+
+	// MaxNullRate is the largest fraction of null values of the null field, a value between 0 and 1. Defaults to 0.
+	MaxNullRate float64 `yaml:"maxNullRate"`
+
+	// Unique are fields whose combinations of values must be unique.
+	Unique []string `yaml:"unique"`
+}
+
+// name will return the name of the assertion.
+func (assertion *Assertion) name() string {
+	if assertion.Name != "" {
+		return assertion.Name
+	}
+
+	return assertion.Table
+}
+
+func (assertion *Assertion) validate() error {
+	if assertion.Table == "" {
+		return InvalidAssertionError(assertion.name(), "table is required")
+	}
+
+	checks := 0
+
+	for _, set := range []bool{assertion.Query != "", assertion.NullField != "", len(assertion.Unique) > 0} {
+		if set {
+			checks++
+		}
+	}
+
+	if checks != 1 {
+		return InvalidAssertionError(assertion.name(), "exactly one of query, nullField, or unique is required")
+	}
+
+	if assertion.Query == "" && (assertion.Min != nil || assertion.Max != nil) {
+		return InvalidAssertionError(assertion.name(), "min and max require a query")
+	}
+
+	if assertion.MaxNullRate < 0 || assertion.MaxNullRate > 1 {
+		return InvalidAssertionError(assertion.name(), "maxNullRate must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// measureRequest will return the storage request that measures the table for the assertion.
+func (assertion *Assertion) measureRequest() *storage.MeasureRequest {
+	return &storage.MeasureRequest{
+		Table:        assertion.Table,
+		Query:        assertion.Query,
+		NullField:    assertion.NullField,
+		UniqueFields: assertion.Unique,
+	}
+}
+
+// check will return a description of why the measurement does not satisfy the assertion, or an empty string if it
+// does.
+func (assertion *Assertion) check(value float64) string {
+	switch {
+	case assertion.Query != "":
+		if assertion.Min != nil && value < *assertion.Min {
+			return fmt.Sprintf("%s: query returned %v, expected at least %v", assertion.name(), value, *assertion.Min)
+		}
+
+		if assertion.Max != nil && value > *assertion.Max {
+			return fmt.Sprintf("%s: query returned %v, expected at most %v", assertion.name(), value, *assertion.Max)
+		}
+	case assertion.NullField != "":
+		if value > assertion.MaxNullRate {
+			return fmt.Sprintf("%s: %.4f of %q are null, expected at most %v", assertion.name(), value,
+				assertion.NullField, assertion.MaxNullRate)
+		}
+	default:
+		if value > 0 {
+			return fmt.Sprintf("%s: %v duplicated values of %s", assertion.name(), value,
+				strings.Join(assertion.Unique, ", "))
+		}
+	}
+
+	return ""
+}
+
+// assertTxFn will return a transaction function that checks the assertions against the data loaded within the
+// transaction. Every assertion is checked, and the failures are returned as a single error wrapping
+// ErrAssertionFailed, so that the transaction is rolled back. Assertions are skipped on storage devices of another
+// scheme, or that do not support them.
+func assertTxFn(cfg *Config) func(context.Context, repository.Generic) error {
+	return func(sctx context.Context, repo repository.Generic) error {
+		scheme := storage.Scheme(repo.Type())
+
+		var failures []string
+
+		for _, assertion := range cfg.Assertions {
+			if assertion.Storage != "" && assertion.Storage != scheme {
+				continue
+			}
+
+			value, err := repo.Measure(sctx, assertion.measureRequest())
+			if errors.Is(err, storage.ErrMeasureNotSupported) {
+				return nil
+			}
+
+			if err != nil {
+				return fmt.Errorf("unable to check assertion %q: %w", assertion.name(), err)
+			}
+
+			if failure := assertion.check(value); failure != "" {
+				failures = append(failures, failure)
+
+				continue
+			}
+
+			msg := fmt.Sprintf("assertion passed on %q: %s", scheme, assertion.name())
+			cfg.Logger.Info(tools.LogFormatter{Msg: msg}.String())
+		}
+
+		if len(failures) > 0 {
+			return fmt.Errorf("%w on %q: %s", ErrAssertionFailed, scheme, strings.Join(failures, "; "))
+		}
+
+		return nil
+	}
+}
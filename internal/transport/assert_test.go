@@ -0,0 +1,79 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAssertionValidate(t *testing.T) {
+	t.Parallel()
+
+	one := 1.0
+
+	for _, tcase := range []struct {
+		name      string
+		assertion *Assertion
+		err       error
+	}{
+		{name: "query", assertion: &Assertion{Table: "candles", Query: "SELECT COUNT(*) FROM candles", Min: &one}},
+		{name: "null field", assertion: &Assertion{Table: "candles", NullField: "close", MaxNullRate: 0.1}},
+		{name: "unique", assertion: &Assertion{Table: "candles", Unique: []string{"product_id", "unix"}}},
+		{name: "no table", assertion: &Assertion{Query: "SELECT 1"}, err: ErrInvalidAssertion},
+		{name: "no check", assertion: &Assertion{Table: "candles"}, err: ErrInvalidAssertion},
+		{
+			name:      "two checks",
+			assertion: &Assertion{Table: "candles", NullField: "close", Unique: []string{"unix"}},
+			err:       ErrInvalidAssertion,
+		},
+		{name: "bounds without query", assertion: &Assertion{Table: "c", NullField: "x", Min: &one}, err: ErrInvalidAssertion},
+		{name: "null rate", assertion: &Assertion{Table: "c", NullField: "x", MaxNullRate: 2}, err: ErrInvalidAssertion},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.assertion.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestAssertionCheck(t *testing.T) {
+	t.Parallel()
+
+	low, high := 10.0, 100.0
+
+	for _, tcase := range []struct {
+		name      string
+		assertion *Assertion
+		value     float64
+		holds     bool
+	}{
+		{name: "within bounds", assertion: &Assertion{Query: "q", Min: &low, Max: &high}, value: 50, holds: true},
+		{name: "below min", assertion: &Assertion{Query: "q", Min: &low}, value: 3},
+		{name: "above max", assertion: &Assertion{Query: "q", Max: &high}, value: 101},
+		{name: "null rate", assertion: &Assertion{NullField: "close", MaxNullRate: 0.1}, value: 0.05, holds: true},
+		{name: "too many nulls", assertion: &Assertion{NullField: "close"}, value: 0.01},
+		{name: "unique", assertion: &Assertion{Unique: []string{"id"}}, value: 0, holds: true},
+		{name: "duplicates", assertion: &Assertion{Unique: []string{"id"}}, value: 2},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if failure := tcase.assertion.check(tcase.value); (failure == "") != tcase.holds {
+				t.Fatalf("expected assertion to hold: %v, got failure %q", tcase.holds, failure)
+			}
+		})
+	}
+}
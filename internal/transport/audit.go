@@ -0,0 +1,338 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
+)
+
+const auditFileMode = 0o600
+
+var ErrInvalidAudit = fmt.Errorf("invalid audit configuration")
+
+// AuditOperation is the kind of write operation recorded by an audit entry.
+type AuditOperation string
+
+const (
+	// AuditUpsert records an upsert of a chunk of records.
+	AuditUpsert AuditOperation = "upsert"
+
+	// AuditTruncate records the truncate of an entire table.
+	AuditTruncate AuditOperation = "truncate"
+
+	// AuditTruncateRange records the delete of the records of a table within a time window.
+	AuditTruncateRange AuditOperation = "truncate_range"
+)
+
+// AuditConfig records every write operation of the transport operations in an append-only audit log, e.g. for
+// compliance requirements around data movement. The log is a file of JSON lines, a table on every storage device, or
+// both. Entries of upserts and range truncates are only recorded once they are committed, and entries in a table are
+// written within the same transaction as the data.
+type AuditConfig struct {
+	// File is the path to the file that the entries are appended to, one JSON object per line.
+	File string `yaml:"file"`
+
+	// Table is the table/collection that the entries are inserted into on every storage device. SQL tables need a
+	// column for each field of an entry, with "id" as the primary key; the keys are stored as JSON text.
+	Table string `yaml:"table"`
+}
+
+func (ac *AuditConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+
+	if ac.File == "" && ac.Table == "" {
+		return fmt.Errorf("%w: file or table is required", ErrInvalidAudit)
+	}
+
+	return nil
+}
+
+// AuditEntry records a write operation on a table of a storage device.
+type AuditEntry struct {
+	// ID identifies the entry.
+	ID string `json:"id"`
+
+	// Time is when the operation was recorded.
+	Time time.Time `json:"time"`
+
+	// RunID identifies the transport operation.
+	RunID string `json:"run_id"`
+
+	// ConfigHash is the SHA-256 hash of the YAML configuration of the transport operation, if it was read from YAML.
+	ConfigHash string `json:"config_hash,omitempty"`
+
+	// Operation is the kind of write operation.
+	Operation AuditOperation `json:"operation"`
+
+	// Storage is the scheme of the storage device, e.g. "postgresql".
+	Storage string `json:"storage"`
+
+	// Table is the table that was written to.
+	Table string `json:"table"`
+
+	// Records is the number of records that were upserted, i.e. inserted, updated, or unchanged.
+	Records int64 `json:"records"`
+
+	// Inserted and Updated are the number of records that were inserted and updated.
+	Inserted int64 `json:"inserted"`
+	Updated  int64 `json:"updated"`
+
+	// Deleted is the number of records that were deleted, if known.
+	Deleted int64 `json:"deleted"`
+
+	// FirstKey and LastKey are the smallest and largest keys of the inserted or updated records, if any.
+	FirstKey map[string]interface{} `json:"first_key,omitempty"`
+	LastKey  map[string]interface{} `json:"last_key,omitempty"`
+
+	// RangeStart and RangeEnd are the time window of a range truncate.
+	RangeStart *time.Time `json:"range_start,omitempty"`
+	RangeEnd   *time.Time `json:"range_end,omitempty"`
+}
+
+// record will return the entry as a record for an audit table, with the keys encoded as JSON text.
+func (entry *AuditEntry) record() (map[string]interface{}, error) {
+	record := map[string]interface{}{
+		"id":          entry.ID,
+		"time":        entry.Time.Format(time.RFC3339Nano),
+		"run_id":      entry.RunID,
+		"config_hash": entry.ConfigHash,
+		"operation":   string(entry.Operation),
+		"storage":     entry.Storage,
+		"table":       entry.Table,
+		"records":     entry.Records,
+		"inserted":    entry.Inserted,
+		"updated":     entry.Updated,
+		"deleted":     entry.Deleted,
+	}
+
+	for field, key := range map[string]map[string]interface{}{"first_key": entry.FirstKey, "last_key": entry.LastKey} {
+		if key == nil {
+			continue
+		}
+
+		bytes, err := json.Marshal(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		record[field] = string(bytes)
+	}
+
+	if entry.RangeStart != nil && entry.RangeEnd != nil {
+		record["range_start"] = entry.RangeStart.Format(time.RFC3339Nano)
+		record["range_end"] = entry.RangeEnd.Format(time.RFC3339Nano)
+	}
+
+	return record, nil
+}
+
+// configHash will return the hex encoded SHA-256 hash of a YAML configuration.
+func configHash(yamlBytes []byte) string {
+	sum := sha256.Sum256(yamlBytes)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// auditor records the write operations of a transport operation. Entries of operations within the transactions are
+// collected until they are committed. A nil auditor is valid and records nothing. It is safe for concurrent use.
+type auditor struct {
+	cfg        *AuditConfig
+	runID      string
+	configHash string
+
+	mu      sync.Mutex
+	entries []*AuditEntry
+}
+
+// newAuditor will return an auditor for a transport operation, or nil if auditing is not enabled.
+func (cfg *Config) newAuditor(runID string) *auditor {
+	if cfg.Audit == nil {
+		return nil
+	}
+
+	return &auditor{cfg: cfg.Audit, runID: runID, configHash: cfg.hash}
+}
+
+// entry will return a new entry of an operation on a table of a storage device.
+func (aud *auditor) entry(operation AuditOperation, scheme, table string) *AuditEntry {
+	return &AuditEntry{
+		ID:         uuid.New().String(),
+		Time:       time.Now().UTC(),
+		RunID:      aud.runID,
+		ConfigHash: aud.configHash,
+		Operation:  operation,
+		Storage:    scheme,
+		Table:      table,
+	}
+}
+
+// add will collect an entry until it is committed.
+func (aud *auditor) add(entry *AuditEntry) {
+	aud.mu.Lock()
+	defer aud.mu.Unlock()
+
+	aud.entries = append(aud.entries, entry)
+}
+
+// upserted will collect the entry of an upsert.
+func (aud *auditor) upserted(scheme, table string, rsp *proto.UpsertResponse) {
+	if aud == nil {
+		return
+	}
+
+	entry := aud.entry(AuditUpsert, scheme, table)
+	entry.Records = rsp.GetUpsertedCount() + rsp.GetMatchedCount()
+	entry.Inserted = rsp.GetInsertedCount()
+	entry.Updated = rsp.GetUpdatedCount()
+	entry.FirstKey, entry.LastKey = widenKeyRange(nil, nil, rsp.GetAffectedKeys())
+
+	aud.add(entry)
+}
+
+// truncatedRange will collect the entry of a range truncate.
+func (aud *auditor) truncatedRange(scheme string, req *storage.TruncateRangeRequest, rsp *proto.TruncateResponse) {
+	if aud == nil {
+		return
+	}
+
+	start, end := req.Start.UTC(), req.End.UTC()
+
+	entry := aud.entry(AuditTruncateRange, scheme, req.Table)
+	entry.Deleted = int64(rsp.GetDeletedCount())
+	entry.RangeStart, entry.RangeEnd = &start, &end
+
+	aud.add(entry)
+}
+
+// truncated will record the truncate of entire tables on a storage device. Truncates are not transactional, so the
+// entries are written to the audit file and table right away.
+func (aud *auditor) truncated(ctx context.Context, repo repository.Generic, tables []string) error {
+	if aud == nil {
+		return nil
+	}
+
+	entries := make([]*AuditEntry, 0, len(tables))
+	for _, table := range tables {
+		entries = append(entries, aud.entry(AuditTruncate, storage.Scheme(repo.Type()), table))
+	}
+
+	if err := aud.writeTable(ctx, repo, entries); err != nil {
+		return err
+	}
+
+	return aud.writeFile(entries)
+}
+
+// list will return the collected entries. If a scheme is given, only the entries of its storage devices are returned.
+func (aud *auditor) list(scheme string) []*AuditEntry {
+	if aud == nil {
+		return nil
+	}
+
+	aud.mu.Lock()
+	defer aud.mu.Unlock()
+
+	entries := make([]*AuditEntry, 0, len(aud.entries))
+
+	for _, entry := range aud.entries {
+		if scheme == "" || entry.Storage == scheme {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// writeFile will append the entries to the audit file, one JSON object per line.
+func (aud *auditor) writeFile(entries []*AuditEntry) error {
+	if aud == nil || aud.cfg.File == "" || len(entries) == 0 {
+		return nil
+	}
+
+	var lines []byte
+
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		lines = append(append(lines, line...), '\n')
+	}
+
+	file, err := os.OpenFile(aud.cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, auditFileMode)
+	if err != nil {
+		return fmt.Errorf("unable to open audit file: %w", err)
+	}
+
+	if _, err := file.Write(lines); err != nil {
+		file.Close()
+
+		return fmt.Errorf("unable to write audit file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("unable to close audit file: %w", err)
+	}
+
+	return nil
+}
+
+// writeTable will insert the entries into the audit table of a storage device. Within a transaction, the entries are
+// committed with the data.
+func (aud *auditor) writeTable(ctx context.Context, repo repository.Generic, entries []*AuditEntry) error {
+	if aud == nil || aud.cfg.Table == "" || len(entries) == 0 {
+		return nil
+	}
+
+	records := make([]map[string]interface{}, 0, len(entries))
+
+	for _, entry := range entries {
+		record, err := entry.record()
+		if err != nil {
+			return err
+		}
+
+		records = append(records, record)
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	req := &proto.UpsertRequest{Table: aud.cfg.Table, Data: data, DataType: int32(tools.UpsertDataJSON)}
+	if _, err := repo.Upsert(ctx, req); err != nil {
+		return fmt.Errorf("unable to write audit table %q: %w", aud.cfg.Table, err)
+	}
+
+	return nil
+}
+
+// auditTxFn will return a transaction function that inserts the collected entries of a storage device into its audit
+// table, so that they are committed with the data.
+func auditTxFn(aud *auditor) func(context.Context, repository.Generic) error {
+	return func(sctx context.Context, repo repository.Generic) error {
+		return aud.writeTable(sctx, repo, aud.list(storage.Scheme(repo.Type())))
+	}
+}
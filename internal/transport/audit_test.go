@@ -0,0 +1,154 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestAuditConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		ac   *AuditConfig
+		err  error
+	}{
+		{name: "nil"},
+		{name: "file", ac: &AuditConfig{File: "audit.jsonl"}},
+		{name: "table", ac: &AuditConfig{Table: "audit"}},
+		{name: "empty", ac: &AuditConfig{}, err: ErrInvalidAudit},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.ac.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestAuditorWriteFile(t *testing.T) {
+	t.Parallel()
+
+	file := filepath.Join(t.TempDir(), "audit.jsonl")
+	cfg := &Config{Audit: &AuditConfig{File: file}, hash: configHash([]byte("url: https://example.com"))}
+
+	var nilAuditor *auditor
+	nilAuditor.upserted("postgresql", "candles", new(proto.UpsertResponse))
+
+	if entries := nilAuditor.list(""); len(entries) != 0 {
+		t.Fatalf("expected no entries from a nil auditor, got %d", len(entries))
+	}
+
+	keys := make([]*structpb.Struct, 0, 3)
+
+	for _, id := range []float64{2, 3, 1} {
+		key, err := structpb.NewStruct(map[string]interface{}{"id": id})
+		if err != nil {
+			t.Fatalf("failed to create key: %v", err)
+		}
+
+		keys = append(keys, key)
+	}
+
+	aud := cfg.newAuditor("run")
+	aud.upserted("postgresql", "candles", &proto.UpsertResponse{
+		UpsertedCount: 2,
+		MatchedCount:  1,
+		InsertedCount: 2,
+		UpdatedCount:  1,
+		AffectedKeys:  keys,
+	})
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	req := &storage.TruncateRangeRequest{Table: "candles", Start: start, End: start.Add(time.Hour)}
+	aud.truncatedRange("mongodb", req, &proto.TruncateResponse{DeletedCount: 4})
+
+	if entries := aud.list("mongodb"); len(entries) != 1 || entries[0].Operation != AuditTruncateRange {
+		t.Fatalf("expected the range truncate entry of mongodb, got %v", entries)
+	}
+
+	// The log is append-only, so writing twice keeps the entries of both writes.
+	for run := 0; run < 2; run++ {
+		if err := aud.writeFile(aud.list("")); err != nil {
+			t.Fatalf("failed to write audit file: %v", err)
+		}
+	}
+
+	auditFile, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("failed to open audit file: %v", err)
+	}
+	defer auditFile.Close()
+
+	var entries []*AuditEntry
+
+	scanner := bufio.NewScanner(auditFile)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to unmarshal audit entry: %v", err)
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 audit entries, got %d", len(entries))
+	}
+
+	upsert := entries[0]
+	if upsert.RunID != "run" || upsert.ConfigHash != cfg.hash || upsert.Operation != AuditUpsert {
+		t.Fatalf("unexpected upsert entry: %+v", upsert)
+	}
+
+	if upsert.Records != 3 || upsert.Inserted != 2 || upsert.Updated != 1 {
+		t.Fatalf("unexpected upsert counts: %+v", upsert)
+	}
+
+	if upsert.FirstKey["id"] != 1.0 || upsert.LastKey["id"] != 3.0 {
+		t.Fatalf("expected key range [1, 3], got [%v, %v]", upsert.FirstKey, upsert.LastKey)
+	}
+
+	if truncate := entries[1]; truncate.Deleted != 4 || !truncate.RangeStart.Equal(start) {
+		t.Fatalf("unexpected range truncate entry: %+v", truncate)
+	}
+}
+
+func TestAuditEntryRecord(t *testing.T) {
+	t.Parallel()
+
+	entry := &AuditEntry{ID: "id", Operation: AuditUpsert, FirstKey: map[string]interface{}{"id": 1}}
+
+	record, err := entry.record()
+	if err != nil {
+		t.Fatalf("failed to encode audit record: %v", err)
+	}
+
+	if record["first_key"] != `{"id":1}` {
+		t.Fatalf("expected the first key as JSON text, got %v", record["first_key"])
+	}
+
+	if _, ok := record["last_key"]; ok {
+		t.Fatalf("expected no last key, got %v", record["last_key"])
+	}
+}
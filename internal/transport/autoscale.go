@@ -0,0 +1,388 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultAutoscaleInterval is the default interval at which the worker counts are adjusted.
+	defaultAutoscaleInterval = 5 * time.Second
+
+	// defaultAutoscaleWaitShare is the default share of the fetch time spent waiting on rate limits, above which the
+	// fetchers are scaled down.
+	defaultAutoscaleWaitShare = 0.5
+
+	// defaultAutoscaleWriteLatency is the default average latency of the upserts, above which the writers are scaled
+	// down.
+	defaultAutoscaleWriteLatency = time.Second
+)
+
+var ErrInvalidAutoscale = fmt.Errorf("invalid autoscale")
+
+// InvalidAutoscaleError wraps an error with ErrInvalidAutoscale.
+func InvalidAutoscaleError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidAutoscale, reason)
+}
+
+// AutoscaleConfig adjusts the number of active web workers (fetchers) and repository workers (writers) during a run,
+// within bounds, instead of running as many of each as there are CPUs. At every interval, the fetchers are scaled down
+// by one while the time they spend waiting on rate limits dominates their fetch time, since more fetchers would only
+// wait longer, and scaled up by one otherwise. The writers are scaled down by one while the average latency of the
+// upserts is above the write latency, and scaled up by one once it is below half of it.
+type AutoscaleConfig struct {
+	// MinFetchers and MaxFetchers bound the number of active web workers. They default to 1 and the number of CPUs.
+	MinFetchers int `yaml:"minFetchers"`
+	MaxFetchers int `yaml:"maxFetchers"`
+
+	// MinWriters and MaxWriters bound the number of active repository workers. They default to 1 and the number of
+	// CPUs.
+	MinWriters int `yaml:"minWriters"`
+	MaxWriters int `yaml:"maxWriters"`
+
+	// Interval is the time between adjustments. Defaults to 5s.
+	Interval time.Duration `yaml:"interval"`
+
+	// WaitShare is the share of the fetch time spent waiting on rate limits, between 0 and 1, above which the fetchers
+	// are scaled down. Defaults to 0.5.
+	WaitShare float64 `yaml:"waitShare"`
+
+	// WriteLatency is the average latency of the upserts above which the writers are scaled down. Defaults to 1s.
+	WriteLatency time.Duration `yaml:"writeLatency"`
+}
+
+func (ac *AutoscaleConfig) validate() error {
+	if ac == nil {
+		return nil
+	}
+
+	if ac.MinFetchers < 0 || ac.MaxFetchers < 0 || ac.MinWriters < 0 || ac.MaxWriters < 0 {
+		return InvalidAutoscaleError("worker bounds can not be negative")
+	}
+
+	if ac.MaxFetchers > 0 && ac.MinFetchers > ac.MaxFetchers {
+		return InvalidAutoscaleError("minFetchers can not be greater than maxFetchers")
+	}
+
+	if ac.MaxWriters > 0 && ac.MinWriters > ac.MaxWriters {
+		return InvalidAutoscaleError("minWriters can not be greater than maxWriters")
+	}
+
+	if ac.Interval < 0 || ac.WriteLatency < 0 {
+		return InvalidAutoscaleError("interval and writeLatency can not be negative")
+	}
+
+	if ac.WaitShare < 0 || ac.WaitShare > 1 {
+		return InvalidAutoscaleError("waitShare must be between 0 and 1")
+	}
+
+	return nil
+}
+
+// autoscaleBounds will return the minimum and maximum of a worker count, defaulting to 1 and the static worker count.
+func autoscaleBounds(minimum, maximum, threads int) (int, int) {
+	if maximum == 0 {
+		maximum = threads
+	}
+
+	if minimum == 0 {
+		minimum = 1
+	}
+
+	if minimum > maximum {
+		minimum = maximum
+	}
+
+	return minimum, maximum
+}
+
+// workers will return the number of web and repository workers to start for a run, which is the static worker count
+// unless the workers are autoscaled.
+func (ac *AutoscaleConfig) workers(threads int) (int, int) {
+	if ac == nil {
+		return threads, threads
+	}
+
+	_, fetchers := autoscaleBounds(ac.MinFetchers, ac.MaxFetchers, threads)
+	_, writers := autoscaleBounds(ac.MinWriters, ac.MaxWriters, threads)
+
+	return fetchers, writers
+}
+
+// workerGate limits the number of workers that process jobs at once. A nil gate does not limit the workers.
+type workerGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	active int
+	limit  int
+}
+
+func newWorkerGate(limit int) *workerGate {
+	gate := &workerGate{limit: limit}
+	gate.cond = sync.NewCond(&gate.mu)
+
+	return gate
+}
+
+// acquire will block until the worker can process a job.
+func (gate *workerGate) acquire() {
+	if gate == nil {
+		return
+	}
+
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	for gate.active >= gate.limit {
+		gate.cond.Wait()
+	}
+
+	gate.active++
+}
+
+// release will let another worker process a job.
+func (gate *workerGate) release() {
+	if gate == nil {
+		return
+	}
+
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	gate.active--
+	gate.cond.Broadcast()
+}
+
+// setLimit will set the number of workers that process jobs at once.
+func (gate *workerGate) setLimit(limit int) {
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+
+	gate.limit = limit
+	gate.cond.Broadcast()
+}
+
+// autoscaler adjusts the worker gates of a run from the rate limit waits of the web requests and the latency of the
+// upserts. A nil autoscaler does not limit the workers.
+type autoscaler struct {
+	cfg    *AutoscaleConfig
+	logger *logrus.Logger
+
+	fetchers *workerGate
+	writers  *workerGate
+
+	minFetchers, maxFetchers int
+	minWriters, maxWriters   int
+
+	mu           sync.Mutex
+	waited       time.Duration
+	fetched      time.Duration
+	written      time.Duration
+	writeCount   int
+	fetcherLimit int
+	writerLimit  int
+}
+
+// newAutoscaler will return the autoscaler of a run, or nil if the workers are not autoscaled.
+func (ac *AutoscaleConfig) newAutoscaler(logger *logrus.Logger, threads int) *autoscaler {
+	if ac == nil {
+		return nil
+	}
+
+	scaler := &autoscaler{cfg: ac, logger: logger}
+	scaler.minFetchers, scaler.maxFetchers = autoscaleBounds(ac.MinFetchers, ac.MaxFetchers, threads)
+	scaler.minWriters, scaler.maxWriters = autoscaleBounds(ac.MinWriters, ac.MaxWriters, threads)
+
+	// The run starts with every worker active.
+	scaler.fetcherLimit, scaler.writerLimit = scaler.maxFetchers, scaler.maxWriters
+	scaler.fetchers = newWorkerGate(scaler.fetcherLimit)
+	scaler.writers = newWorkerGate(scaler.writerLimit)
+
+	return scaler
+}
+
+// fetcherGate and writerGate will return the worker gates of the autoscaler, which are nil without an autoscaler.
+func (as *autoscaler) fetcherGate() *workerGate {
+	if as == nil {
+		return nil
+	}
+
+	return as.fetchers
+}
+
+func (as *autoscaler) writerGate() *workerGate {
+	if as == nil {
+		return nil
+	}
+
+	return as.writers
+}
+
+// timedLimiter is a rate limiter that reports the time spent waiting on it to an autoscaler.
+type timedLimiter struct {
+	web.RateLimiter
+	scaler *autoscaler
+}
+
+// WaitN will block until the rate limit allows n tokens, reporting the time waited.
+func (tl *timedLimiter) WaitN(ctx context.Context, n int) error {
+	start := time.Now()
+	err := tl.RateLimiter.WaitN(ctx, n)
+
+	tl.scaler.mu.Lock()
+	tl.scaler.waited += time.Since(start)
+	tl.scaler.mu.Unlock()
+
+	return err
+}
+
+// instrument will report the rate limit waits of the web requests of a run to the autoscaler.
+func (as *autoscaler) instrument(flattenedRequests []*flattenedRequest) {
+	if as == nil {
+		return
+	}
+
+	for _, req := range flattenedRequests {
+		if req.fetchConfig != nil && req.fetchConfig.RateLimiter != nil {
+			req.fetchConfig.RateLimiter = &timedLimiter{RateLimiter: req.fetchConfig.RateLimiter, scaler: as}
+		}
+	}
+}
+
+// observeFetch will report the time that a web worker spent on a job, including its rate limit wait.
+func (as *autoscaler) observeFetch(elapsed time.Duration) {
+	if as == nil {
+		return
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.fetched += elapsed
+}
+
+// observeWrite will report the latency of an upsert.
+func (as *autoscaler) observeWrite(latency time.Duration) {
+	if as == nil {
+		return
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.written += latency
+	as.writeCount++
+}
+
+func (as *autoscaler) waitShare() float64 {
+	if as.cfg.WaitShare == 0 {
+		return defaultAutoscaleWaitShare
+	}
+
+	return as.cfg.WaitShare
+}
+
+func (as *autoscaler) writeLatency() time.Duration {
+	if as.cfg.WriteLatency == 0 {
+		return defaultAutoscaleWriteLatency
+	}
+
+	return as.cfg.WriteLatency
+}
+
+// adjust will scale the workers by the rate limit waits and upsert latency since the last adjustment.
+func (as *autoscaler) adjust() {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	fetchers, writers := as.fetcherLimit, as.writerLimit
+
+	if as.fetched > 0 {
+		// Rate limit waits dominate, so more fetchers would only queue on the rate limiter.
+		if float64(as.waited)/float64(as.fetched) > as.waitShare() {
+			fetchers--
+		} else {
+			fetchers++
+		}
+	}
+
+	if as.writeCount > 0 {
+		latency := as.written / time.Duration(as.writeCount)
+
+		switch {
+		case latency > as.writeLatency():
+			writers--
+		case latency < as.writeLatency()/2:
+			writers++
+		}
+	}
+
+	as.waited, as.fetched, as.written, as.writeCount = 0, 0, 0, 0
+
+	fetchers = clampWorkers(fetchers, as.minFetchers, as.maxFetchers)
+	writers = clampWorkers(writers, as.minWriters, as.maxWriters)
+
+	if fetchers != as.fetcherLimit {
+		as.fetcherLimit = fetchers
+		as.fetchers.setLimit(fetchers)
+		as.logger.Info(tools.LogFormatter{Msg: fmt.Sprintf("scaled web workers to %d", fetchers)}.String())
+	}
+
+	if writers != as.writerLimit {
+		as.writerLimit = writers
+		as.writers.setLimit(writers)
+		as.logger.Info(tools.LogFormatter{Msg: fmt.Sprintf("scaled repository workers to %d", writers)}.String())
+	}
+}
+
+func clampWorkers(workers, minimum, maximum int) int {
+	if workers < minimum {
+		return minimum
+	}
+
+	if workers > maximum {
+		return maximum
+	}
+
+	return workers
+}
+
+// run will adjust the workers at every interval until the context is done.
+func (as *autoscaler) run(ctx context.Context) {
+	if as == nil {
+		return
+	}
+
+	interval := as.cfg.Interval
+	if interval == 0 {
+		interval = defaultAutoscaleInterval
+	}
+
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				as.adjust()
+			}
+		}
+	}()
+}
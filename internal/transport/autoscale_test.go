@@ -0,0 +1,120 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestWorkerGate(t *testing.T) {
+	t.Parallel()
+
+	gate := newWorkerGate(2)
+
+	gate.acquire()
+	gate.acquire()
+
+	var acquired int32
+
+	go func() {
+		gate.acquire()
+		atomic.StoreInt32(&acquired, 1)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	if atomic.LoadInt32(&acquired) != 0 {
+		t.Fatal("expected the gate to block a third worker")
+	}
+
+	gate.setLimit(3)
+
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&acquired) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a raised limit to release the waiting worker")
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAutoscalerAdjust(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &AutoscaleConfig{MinFetchers: 2, MaxWriters: 3, WriteLatency: 100 * time.Millisecond}
+	scaler := cfg.newAutoscaler(logger, 4)
+
+	if scaler.fetcherLimit != 4 || scaler.writerLimit != 3 {
+		t.Fatalf("expected the run to start with every worker, got %d fetchers and %d writers", scaler.fetcherLimit,
+			scaler.writerLimit)
+	}
+
+	// Rate limit waits dominate and the database is slow.
+	for idx := 0; idx < 5; idx++ {
+		scaler.waited, scaler.fetched = 8*time.Second, 10*time.Second
+		scaler.observeWrite(200 * time.Millisecond)
+		scaler.adjust()
+	}
+
+	if scaler.fetcherLimit != 2 || scaler.writerLimit != 1 {
+		t.Fatalf("expected the workers to scale down to their minimums, got %d fetchers and %d writers",
+			scaler.fetcherLimit, scaler.writerLimit)
+	}
+
+	// The rate limit has headroom and the database recovered.
+	scaler.waited, scaler.fetched = time.Second, 10*time.Second
+	scaler.observeWrite(10 * time.Millisecond)
+	scaler.adjust()
+
+	if scaler.fetcherLimit != 3 || scaler.writerLimit != 2 {
+		t.Fatalf("expected the workers to scale up, got %d fetchers and %d writers", scaler.fetcherLimit,
+			scaler.writerLimit)
+	}
+
+	// An interval without fetches or writes keeps the workers.
+	scaler.adjust()
+
+	if scaler.fetcherLimit != 3 || scaler.writerLimit != 2 {
+		t.Fatalf("expected the workers to be kept, got %d fetchers and %d writers", scaler.fetcherLimit,
+			scaler.writerLimit)
+	}
+}
+
+func TestAutoscaleWorkers(t *testing.T) {
+	t.Parallel()
+
+	var cfg *AutoscaleConfig
+	if fetchers, writers := cfg.workers(4); fetchers != 4 || writers != 4 {
+		t.Fatalf("expected the static worker count, got %d and %d", fetchers, writers)
+	}
+
+	cfg = &AutoscaleConfig{MaxFetchers: 16}
+	if fetchers, writers := cfg.workers(4); fetchers != 16 || writers != 4 {
+		t.Fatalf("expected the maximum worker counts, got %d and %d", fetchers, writers)
+	}
+
+	_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+autoscale: {minFetchers: 8, maxFetchers: 4}
+requests:
+  - endpoint: /ticker
+`))
+	if !errors.Is(err, ErrInvalidAutoscale) {
+		t.Fatalf("expected ErrInvalidAutoscale, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// defaultIdempotencyHeader is the default header of idempotency keys.
+const defaultIdempotencyHeader = "Idempotency-Key"
+
+var ErrInvalidBody = fmt.Errorf("invalid body")
+
+// idempotencyNamespace is the namespace of the name-based UUIDs of idempotency keys.
+var idempotencyNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/alpine-hodler/gidari/idempotency"))
+
+// IdempotencyConfig sends an idempotency key with each web request of a request, e.g. the POST queries of APIs that
+// process them, so that retried requests are not processed twice by providers that honor idempotency keys.
+//
+// The key of a chunk is derived from its method, URL, and body, so it is the same for every attempt of the chunk:
+// failovers to mirrors, and replays of failed chunks with "gidari retry".
+type IdempotencyConfig struct {
+	// Header is the header of the idempotency key. Defaults to "Idempotency-Key".
+	Header string `yaml:"header"`
+}
+
+// header will return the header of the idempotency key.
+func (ic *IdempotencyConfig) header() string {
+	if ic.Header != "" {
+		return ic.Header
+	}
+
+	return defaultIdempotencyHeader
+}
+
+// headerName will return the header of the idempotency key, or an empty string if no idempotency key is sent.
+func (ic *IdempotencyConfig) headerName() string {
+	if ic == nil {
+		return ""
+	}
+
+	return ic.header()
+}
+
+// key will return the idempotency key of a web request.
+func (ic *IdempotencyConfig) key(method, uri string, body []byte) string {
+	name := make([]byte, 0, len(method)+len(uri)+len(body)+2)
+	name = append(name, method+" "+uri+"\n"...)
+	name = append(name, body...)
+
+	return uuid.NewSHA1(idempotencyNamespace, name).String()
+}
+
+// jsonBodyValue will convert a value decoded from YAML into a value that can be encoded as JSON, filling in the
+// "{name}" placeholders of its strings with the params.
+func jsonBodyValue(value interface{}, params map[string]string) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		return fillPlaceholders(value, params), nil
+	case []interface{}:
+		list := make([]interface{}, len(value))
+
+		for idx, elem := range value {
+			converted, err := jsonBodyValue(elem, params)
+			if err != nil {
+				return nil, err
+			}
+
+			list[idx] = converted
+		}
+
+		return list, nil
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(value))
+
+		for key, elem := range value {
+			converted, err := jsonBodyValue(elem, params)
+			if err != nil {
+				return nil, err
+			}
+
+			obj[key] = converted
+		}
+
+		return obj, nil
+	case map[interface{}]interface{}:
+		obj := make(map[string]interface{}, len(value))
+
+		for key, elem := range value {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: key %v is not a string", ErrInvalidBody, key)
+			}
+
+			converted, err := jsonBodyValue(elem, params)
+			if err != nil {
+				return nil, err
+			}
+
+			obj[name] = converted
+		}
+
+		return obj, nil
+	}
+
+	return value, nil
+}
+
+// body will return the JSON encoded body of the request's web requests, if it has one.
+func (req *Request) body() ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+
+	value, err := jsonBodyValue(req.Body, req.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBody, err)
+	}
+
+	return body, nil
+}
+
+// header will return the headers of a web request of the request with the given method, URL, and body.
+func (req *Request) header(method, uri string, body []byte) http.Header {
+	if req.Idempotency == nil && req.Locale == "" && len(req.Headers) == 0 {
+		return nil
+	}
+
+	header := make(http.Header)
+	for key, value := range req.Headers {
+		header.Set(key, fillPlaceholders(value, req.Params))
+	}
+
+	if req.Idempotency != nil {
+		header.Set(req.Idempotency.header(), req.Idempotency.key(method, uri, body))
+	}
+
+	if req.Locale != "" {
+		header.Set("Accept-Language", req.Locale)
+	}
+
+	return header
+}
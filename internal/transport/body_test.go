@@ -0,0 +1,104 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRequestBody(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /query
+    method: POST
+    table: trades
+    expand: {symbol: [BTC-USD, ETH-USD]}
+    body:
+      product: "{symbol}"
+      filters: [{side: buy, limit: 100}]
+    idempotency: {header: X-Idempotency-Key}
+  - endpoint: /query
+    method: POST
+    table: orders
+    body: {product: BTC-USD}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	keys := make(map[string]bool)
+
+	for idx, symbol := range []string{"BTC-USD", "ETH-USD"} {
+		fetchConfig := cfg.Requests[idx].newFetchConfig(*cfg.URL, nil)
+
+		expected := fmt.Sprintf(`{"filters":[{"limit":100,"side":"buy"}],"product":%q}`, symbol)
+		if string(fetchConfig.Body) != expected {
+			t.Fatalf("expected body %s, got %s", expected, fetchConfig.Body)
+		}
+
+		key := fetchConfig.Header.Get("X-Idempotency-Key")
+		if key == "" {
+			t.Fatalf("expected an idempotency key, got headers %v", fetchConfig.Header)
+		}
+
+		// The key of a chunk is the same every time it is fetched.
+		if again := cfg.Requests[idx].newFetchConfig(*cfg.URL, nil); again.Header.Get("X-Idempotency-Key") != key {
+			t.Fatalf("expected a stable idempotency key %q, got %q", key, again.Header.Get("X-Idempotency-Key"))
+		}
+
+		keys[key] = true
+	}
+
+	if len(keys) != 2 {
+		t.Fatalf("expected a distinct idempotency key per chunk, got %v", keys)
+	}
+
+	if fetchConfig := cfg.Requests[2].newFetchConfig(*cfg.URL, nil); fetchConfig.Header != nil {
+		t.Fatalf("expected no headers without idempotency, got %v", fetchConfig.Header)
+	}
+}
+
+func TestFailedChunkReplay(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /query
+    method: POST
+    body: {product: BTC-USD}
+    idempotency: {}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	fetchConfig := cfg.Requests[0].newFetchConfig(*cfg.URL, nil)
+
+	chunk := newFailedChunk(&Error{
+		Table:       "query",
+		Method:      fetchConfig.Method,
+		URL:         fetchConfig.URL.String(),
+		Err:         fmt.Errorf("unavailable"),
+		fetchConfig: fetchConfig,
+	})
+
+	if chunk.Body != `{"product":"BTC-USD"}` {
+		t.Fatalf("expected the chunk to record the body, got %q", chunk.Body)
+	}
+
+	if key := fetchConfig.Header.Get(defaultIdempotencyHeader); chunk.Header[defaultIdempotencyHeader] != key {
+		t.Fatalf("expected the chunk to record the idempotency key %q, got %v", key, chunk.Header)
+	}
+}
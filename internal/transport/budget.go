@@ -0,0 +1,168 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrErrorBudgetExceeded = fmt.Errorf("error budget exceeded")
+	ErrInvalidErrorBudget  = fmt.Errorf("invalid error budget configuration")
+)
+
+// budgetExceededError is an error that exceeded the error budget. It matches ErrErrorBudgetExceeded while preserving
+// the underlying error for "errors.Is" and "errors.As".
+type budgetExceededError struct{ err error }
+
+func (berr *budgetExceededError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrErrorBudgetExceeded, berr.err)
+}
+
+func (berr *budgetExceededError) Unwrap() error { return berr.err }
+
+func (berr *budgetExceededError) Is(target error) bool {
+	return errors.Is(target, ErrErrorBudgetExceeded)
+}
+
+// ErrorBudgetExceededError wraps an error with ErrErrorBudgetExceeded.
+func ErrorBudgetExceededError(err error) error {
+	return &budgetExceededError{err: err}
+}
+
+// skippedChunkError is a failed chunk that is within the error budget, so it is skipped instead of failing the
+// transport operation.
+type skippedChunkError struct{ terr *Error }
+
+func (serr *skippedChunkError) Error() string { return serr.terr.Error() }
+
+func (serr *skippedChunkError) Unwrap() error { return serr.terr }
+
+// ErrorBudgetConfig is the number of failed chunks that can be tolerated before a transport operation is aborted. A
+// "chunk" is a single web request, e.g. one range of a timeseries. Failed chunks that are within the budget are
+// skipped and recorded on the configuration's "FailedChunks" so that they can be retried later.
+//
+// If neither threshold is set, no errors are tolerated and the operation will fail fast.
+type ErrorBudgetConfig struct {
+	// MaxErrors is the maximum number of failed chunks to tolerate.
+	MaxErrors *int `yaml:"maxErrors"`
+
+	// MaxErrorRate is the maximum fraction of failed chunks to tolerate, a value between 0 and 1.
+	MaxErrorRate *float64 `yaml:"maxErrorRate"`
+}
+
+func (ebc *ErrorBudgetConfig) validate() error {
+	if ebc == nil {
+		return nil
+	}
+
+	if ebc.MaxErrors != nil && *ebc.MaxErrors < 0 {
+		return fmt.Errorf("%w: maxErrors must be non-negative", ErrInvalidErrorBudget)
+	}
+
+	if ebc.MaxErrorRate != nil && (*ebc.MaxErrorRate < 0 || *ebc.MaxErrorRate > 1) {
+		return fmt.Errorf("%w: maxErrorRate must be between 0 and 1", ErrInvalidErrorBudget)
+	}
+
+	return nil
+}
+
+// FailedChunk is a chunk of a request that failed during a transport operation and was skipped because the failure
+// was within the error budget.
+type FailedChunk struct {
+	// Table is the name of the table/collection that the chunk was destined for.
+	Table string `yaml:"table"`
+
+	// Request is the index of the configured request of the chunk, so that a retry uses the options of that request
+	// when several requests store data in the same table.
+	Request *int `yaml:"request,omitempty"`
+
+	// Method is the HTTP method of the chunk's web request.
+	Method string `yaml:"method"`
+
+	// URL is the URL of the chunk's web request.
+	URL string `yaml:"url"`
+
+	// Body is the body of the chunk's web request, if any.
+	Body string `yaml:"body,omitempty"`
+
+	// Header are the headers of the chunk's web request, if any, e.g. its idempotency key, so that a retry replays the
+	// same request.
+	Header map[string]string `yaml:"header,omitempty"`
+
+	// Error is the error message of the failure.
+	Error string `yaml:"error"`
+}
+
+// newFailedChunk will return the failed chunk of a transport error.
+func newFailedChunk(terr *Error) *FailedChunk {
+	chunk := &FailedChunk{
+		Table:   terr.Table,
+		Request: terr.request,
+		Method:  terr.Method,
+		URL:     terr.URL,
+		Error:   terr.Err.Error(),
+	}
+
+	if terr.fetchConfig == nil {
+		return chunk
+	}
+
+	chunk.Body = string(terr.fetchConfig.Body)
+
+	for key := range terr.fetchConfig.Header {
+		if chunk.Header == nil {
+			chunk.Header = make(map[string]string, len(terr.fetchConfig.Header))
+		}
+
+		chunk.Header[key] = terr.fetchConfig.Header.Get(key)
+	}
+
+	return chunk
+}
+
+// errorBudget tracks the number of failed chunks against an "ErrorBudgetConfig". It is safe for concurrent use.
+type errorBudget struct {
+	cfg    *ErrorBudgetConfig
+	total  int
+	failed int
+	mu     sync.Mutex
+}
+
+func newErrorBudget(cfg *ErrorBudgetConfig, total int) *errorBudget {
+	return &errorBudget{cfg: cfg, total: total}
+}
+
+// configured will return true if the budget tolerates any failed chunks, i.e. if it has a threshold.
+func (eb *errorBudget) configured() bool {
+	return eb != nil && eb.cfg != nil && (eb.cfg.MaxErrors != nil || eb.cfg.MaxErrorRate != nil)
+}
+
+// fail will record a failed chunk and return true if the budget has been exceeded.
+func (eb *errorBudget) fail() bool {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	eb.failed++
+
+	if eb.cfg == nil || (eb.cfg.MaxErrors == nil && eb.cfg.MaxErrorRate == nil) {
+		return true
+	}
+
+	if eb.cfg.MaxErrors != nil && eb.failed > *eb.cfg.MaxErrors {
+		return true
+	}
+
+	if eb.cfg.MaxErrorRate != nil && eb.total > 0 && float64(eb.failed)/float64(eb.total) > *eb.cfg.MaxErrorRate {
+		return true
+	}
+
+	return false
+}
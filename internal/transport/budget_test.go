@@ -0,0 +1,181 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+)
+
+func TestErrorBudget(t *testing.T) {
+	t.Parallel()
+
+	maxErrors := 2
+	maxErrorRate := 0.25
+
+	for _, tcase := range []struct {
+		name     string
+		cfg      *ErrorBudgetConfig
+		total    int
+		failures int
+		exceeded bool
+	}{
+		{"nil config fails fast", nil, 10, 1, true},
+		{"empty config fails fast", &ErrorBudgetConfig{}, 10, 1, true},
+		{"within max errors", &ErrorBudgetConfig{MaxErrors: &maxErrors}, 10, 2, false},
+		{"exceeds max errors", &ErrorBudgetConfig{MaxErrors: &maxErrors}, 10, 3, true},
+		{"within max error rate", &ErrorBudgetConfig{MaxErrorRate: &maxErrorRate}, 8, 2, false},
+		{"exceeds max error rate", &ErrorBudgetConfig{MaxErrorRate: &maxErrorRate}, 8, 3, true},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			budget := newErrorBudget(tcase.cfg, tcase.total)
+
+			var exceeded bool
+			for i := 0; i < tcase.failures; i++ {
+				exceeded = budget.fail()
+			}
+
+			if exceeded != tcase.exceeded {
+				t.Fatalf("expected exceeded to be %t, got %t", tcase.exceeded, exceeded)
+			}
+		})
+	}
+
+	t.Run("exceeded error preserves the underlying error", func(t *testing.T) {
+		t.Parallel()
+
+		err := ErrorBudgetExceededError(&Error{Table: "test", Err: web.ErrRateLimited})
+		if !errors.Is(err, ErrErrorBudgetExceeded) {
+			t.Fatalf("expected error to be ErrErrorBudgetExceeded, got %v", err)
+		}
+
+		if !errors.Is(err, web.ErrRateLimited) {
+			t.Fatalf("expected error to be web.ErrRateLimited, got %v", err)
+		}
+
+		var terr *Error
+		if !errors.As(err, &terr) || terr.Table != "test" {
+			t.Fatalf("expected error to be a transport error, got %v", err)
+		}
+	})
+}
+
+func TestErrorBudgetRun(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		budget   string
+		failed   int
+		exceeded bool
+	}{
+		{name: "no budget fails fast with the error"},
+		{name: "request budget", budget: "    errorBudget: {maxErrors: 1}", failed: 1},
+		{name: "exceeded request budget", budget: "    errorBudget: {maxErrors: 0}", exceeded: true},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+				if req.URL.Path == "/broken" {
+					writer.WriteHeader(http.StatusBadRequest)
+
+					return
+				}
+
+				fmt.Fprint(writer, `[{"id": "a"}]`)
+			}))
+			defer testServer.Close()
+
+			// Only the broken request has an error budget, the run does not.
+			cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 5
+  period: 1ms
+requests:
+  - endpoint: /ticks
+    table: ticks
+  - endpoint: /broken
+    table: broken
+%s
+`, testServer.URL, tcase.budget)))
+			if err != nil {
+				t.Fatalf("error creating config: %v", err)
+			}
+
+			err = Upsert(context.Background(), cfg)
+			if tcase.budget != "" && !tcase.exceeded {
+				if err != nil {
+					t.Fatalf("expected the failure to be within the request budget, got %v", err)
+				}
+
+				if len(cfg.FailedChunks) != tcase.failed {
+					t.Fatalf("expected %d failed chunks, got %d", tcase.failed, len(cfg.FailedChunks))
+				}
+
+				return
+			}
+
+			var terr *Error
+			if !errors.As(err, &terr) || terr.Table != "broken" {
+				t.Fatalf("expected the error of the broken request, got %v", err)
+			}
+
+			if exceeded := errors.Is(err, ErrErrorBudgetExceeded); exceeded != tcase.exceeded {
+				t.Fatalf("expected the error budget to be exceeded %t, got %v", tcase.exceeded, err)
+			}
+		})
+	}
+}
+
+func TestErrorBudgetSource(t *testing.T) {
+	t.Parallel()
+
+	// The source can not be retried as a failed chunk, so it fails the run within the run's error budget.
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit:
+  burst: 5
+  period: 1ms
+errorBudget:
+  maxErrors: 5
+requests:
+  - table: ticks
+    sql:
+      connection: postgresql://localhost:1/db?sslmode=disable&connect_timeout=1
+      query: SELECT id FROM ticks
+      orderBy: [id]
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	err = Upsert(context.Background(), cfg)
+
+	var terr *Error
+	if !errors.As(err, &terr) || terr.Table != "ticks" || errors.Is(err, ErrErrorBudgetExceeded) {
+		t.Fatalf("expected the error of the source, got %v", err)
+	}
+
+	if len(cfg.FailedChunks) != 0 {
+		t.Fatalf("expected no failed chunks, got %v", cfg.FailedChunks)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// fetchKey is the key of a web request in the fetch cache. Every request of a run is made with the same client, and
+// so with the same authentication headers, which means that requests with the same method, URL, body, and headers
+// are identical, e.g. requests of the same endpoint with different "Accept-Language" headers are not. The idempotency
+// key is left out, since it is derived from the method, URL, and body.
+func fetchKey(req *flattenedRequest) string {
+	fetchConfig := req.fetchConfig
+
+	header := make([]string, 0, len(fetchConfig.Header))
+
+	for key, values := range fetchConfig.Header {
+		if !strings.EqualFold(key, req.idempotencyHeader) {
+			header = append(header, http.CanonicalHeaderKey(key)+": "+strings.Join(values, ", ")+"\n")
+		}
+	}
+
+	sort.Strings(header)
+
+	return fetchConfig.Method + " " + fetchConfig.URL.String() + "\n" + strings.Join(header, "") + "\n" +
+		string(fetchConfig.Body)
+}
+
+// fetchBytes will make a web request and read the response body, transcoded to UTF-8.
+func fetchBytes(ctx context.Context, fetchConfig *web.FetchConfig) ([]byte, *http.Request, error) {
+	rsp, err := web.Fetch(ctx, fetchConfig)
+	if err != nil {
+		return nil, nil, WrapWebError(err)
+	}
+
+	defer rsp.Body.Close()
+
+	bytes, err := tools.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, nil, WrapWebError(err)
+	}
+
+	if bytes, err = decodeCharset(rsp.Header.Get("Content-Type"), bytes); err != nil {
+		return nil, nil, err
+	}
+
+	return bytes, rsp.Request, nil
+}
+
+// cachedFetch is the shared result of identical web requests.
+type cachedFetch struct {
+	once sync.Once
+
+	// refs is the number of requests that have yet to read the result.
+	refs int
+
+	bytes []byte
+	req   *http.Request
+	err   error
+}
+
+// fetchCache shares the result of identical web requests within a run, so that each is only made once. Only requests
+// that occur more than once are cached, and a result is released as soon as every request has read it.
+type fetchCache struct {
+	entries map[string]*cachedFetch
+	mu      sync.Mutex
+}
+
+// newFetchCache will return a fetch cache for the cacheable flattened requests of a run.
+func newFetchCache(flattenedRequests []*flattenedRequest) *fetchCache {
+	refs := make(map[string]int)
+
+	for _, req := range flattenedRequests {
+		if !req.noCache {
+			refs[fetchKey(req)]++
+		}
+	}
+
+	cache := &fetchCache{entries: make(map[string]*cachedFetch)}
+
+	for key, count := range refs {
+		if count > 1 {
+			cache.entries[key] = &cachedFetch{refs: count}
+		}
+	}
+
+	return cache
+}
+
+// fetch will make the web request of a flattened request, or share the result of an identical request. The returned
+// boolean is true if the result was shared.
+func (fc *fetchCache) fetch(ctx context.Context, req *flattenedRequest) ([]byte, *http.Request, bool, error) {
+	if fc == nil || req.noCache {
+		bytes, httpReq, err := fetchBytes(ctx, req.fetchConfig)
+
+		return bytes, httpReq, false, err
+	}
+
+	key := fetchKey(req)
+
+	fc.mu.Lock()
+	entry := fc.entries[key]
+	fc.mu.Unlock()
+
+	if entry == nil {
+		bytes, httpReq, err := fetchBytes(ctx, req.fetchConfig)
+
+		return bytes, httpReq, false, err
+	}
+
+	shared := true
+
+	entry.once.Do(func() {
+		shared = false
+		entry.bytes, entry.req, entry.err = fetchBytes(ctx, req.fetchConfig)
+	})
+
+	fc.mu.Lock()
+	entry.refs--
+
+	if entry.refs == 0 {
+		delete(fc.entries, key)
+	}
+	fc.mu.Unlock()
+
+	return entry.bytes, entry.req, shared, entry.err
+}
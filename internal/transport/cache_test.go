@@ -0,0 +1,123 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchCache(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name    string
+		noCache string
+		hits    int32
+	}{
+		{"identical requests are fetched once", "false", 1},
+		{"opt out fetches every request", "true", 2},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var hits int32
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+				atomic.AddInt32(&hits, 1)
+				fmt.Fprint(writer, `[{"id": "a"}]`)
+			}))
+			defer testServer.Close()
+
+			cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 5
+  period: 1ms
+requests:
+  - endpoint: /ticks
+    table: ticks
+  - endpoint: /ticks
+    table: ticks_copy
+    noCache: %s
+`, testServer.URL, tcase.noCache)))
+			if err != nil {
+				t.Fatalf("error creating config: %v", err)
+			}
+
+			if err := Upsert(context.Background(), cfg); err != nil {
+				t.Fatalf("error upserting: %v", err)
+			}
+
+			if hits != tcase.hits {
+				t.Fatalf("expected %d web requests, got %d", tcase.hits, hits)
+			}
+		})
+	}
+}
+
+func TestFetchCacheHeaders(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu        sync.Mutex
+		languages []string
+	)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		languages = append(languages, req.Header.Get("Accept-Language"))
+		mu.Unlock()
+
+		fmt.Fprintf(writer, `[{"id": %q}]`, req.Header.Get("Accept-Language"))
+	}))
+	defer testServer.Close()
+
+	// The idempotency keys of identical requests are the same, so they do not keep the requests from being shared.
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 5
+  period: 1ms
+requests:
+  - endpoint: /ticks
+    table: ticks_en
+    locale: en-US
+    idempotency: {}
+  - endpoint: /ticks
+    table: ticks_en_copy
+    locale: en-US
+    idempotency: {}
+  - endpoint: /ticks
+    table: ticks_fr
+    locale: fr-FR
+    idempotency: {}
+`, testServer.URL)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if err := Upsert(context.Background(), cfg); err != nil {
+		t.Fatalf("error upserting: %v", err)
+	}
+
+	sort.Strings(languages)
+
+	if expected := []string{"en-US", "fr-FR"}; !reflect.DeepEqual(languages, expected) {
+		t.Fatalf("expected a web request per locale %v, got %v", expected, languages)
+	}
+}
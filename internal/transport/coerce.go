@@ -0,0 +1,283 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// The types that fields are coerced to.
+const (
+	CoerceNumber = "number"
+	CoerceBool   = "bool"
+	CoerceString = "string"
+	CoerceTime   = "time"
+)
+
+// The actions for values that can not be coerced.
+const (
+	CoerceOnErrorKeep = "keep"
+	CoerceOnErrorNull = "null"
+	CoerceOnErrorFail = "fail"
+)
+
+var (
+	ErrInvalidCoerce = fmt.Errorf("invalid coerce")
+	ErrCoerce        = fmt.Errorf("unable to coerce value")
+)
+
+// InvalidCoerceError wraps an error with ErrInvalidCoerce.
+func InvalidCoerceError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCoerce, reason)
+}
+
+// defaultCoerceTrue and defaultCoerceFalse are the case-insensitive strings that are coerced to booleans.
+var (
+	defaultCoerceTrue  = []string{"1", "true", "t", "yes", "y"}
+	defaultCoerceFalse = []string{"0", "false", "f", "no", "n"}
+)
+
+// CoerceRule coerces the messy scalar encodings of fields when the records of a response are decoded, e.g. "0" and "1"
+// to booleans, "N/A" to null, "1,234.5" to a number, or "3. März 2023" to a timestamp, before they are transformed and
+// stored. Numbers and dates are parsed in the locale of the request, if any.
+type CoerceRule struct {
+	// Fields are the fields of the records that are coerced.
+	Fields []string `yaml:"fields"`
+
+	// Type is the type that the fields are coerced to: "number", "bool", "string", or "time". If it is not set, only
+	// the nulls are coerced.
+	Type string `yaml:"type"`
+
+	// Nulls are the strings that are coerced to null, e.g. "" or "N/A".
+	Nulls []string `yaml:"nulls"`
+
+	// Thousands is the thousands separator that is stripped from numbers, e.g. ",". Defaults to the separators of the
+	// request's locale, if any.
+	Thousands string `yaml:"thousands"`
+
+	// Decimal is the decimal separator of numbers, e.g. ",". Defaults to the separator of the request's locale, or ".".
+	Decimal string `yaml:"decimal"`
+
+	// Layout is the Go time layout that the fields are parsed with when they are coerced to times, e.g.
+	// "2. January 2006 15:04". The localized names of months and weekdays of the request's locale are parsed as the
+	// English names of the layout. Times are stored as RFC3339 timestamps.
+	Layout string `yaml:"layout"`
+
+	// True and False are the case-insensitive strings that are coerced to booleans. Default to "1", "true", "t", "yes",
+	// and "y", and to "0", "false", "f", "no", and "n".
+	True  []string `yaml:"true"`
+	False []string `yaml:"false"`
+
+	// OnError is the action for values that can not be coerced: "keep" the value as is, set it to "null", or "fail"
+	// the response. Defaults to "keep".
+	OnError string `yaml:"onError"`
+}
+
+func (rule *CoerceRule) validate() error {
+	if len(rule.Fields) == 0 {
+		return MissingConfigFieldError("coerce.fields")
+	}
+
+	switch rule.Type {
+	case "", CoerceNumber, CoerceBool, CoerceString:
+	case CoerceTime:
+		if rule.Layout == "" {
+			return MissingConfigFieldError("coerce.layout")
+		}
+	default:
+		return InvalidCoerceError(fmt.Sprintf("unknown type %q", rule.Type))
+	}
+
+	if rule.Type == "" && len(rule.Nulls) == 0 {
+		return InvalidCoerceError("one of type or nulls is required")
+	}
+
+	switch rule.OnError {
+	case "", CoerceOnErrorKeep, CoerceOnErrorNull, CoerceOnErrorFail:
+	default:
+		return InvalidCoerceError(fmt.Sprintf("unknown onError %q", rule.OnError))
+	}
+
+	return nil
+}
+
+// coerceBool will coerce a value to a boolean.
+func (rule *CoerceRule) coerceBool(value interface{}) (interface{}, bool) {
+	trueValues, falseValues := defaultCoerceTrue, defaultCoerceFalse
+	if len(rule.True) > 0 {
+		trueValues = rule.True
+	}
+
+	if len(rule.False) > 0 {
+		falseValues = rule.False
+	}
+
+	if b, ok := value.(bool); ok {
+		return b, true
+	}
+
+	str := strings.TrimSpace(fmt.Sprint(value))
+
+	for _, trueValue := range trueValues {
+		if strings.EqualFold(str, trueValue) {
+			return true, true
+		}
+	}
+
+	for _, falseValue := range falseValues {
+		if strings.EqualFold(str, falseValue) {
+			return false, true
+		}
+	}
+
+	return nil, false
+}
+
+// coerceNumber will coerce a value to a number in a locale, preserving the precision of its digits.
+func (rule *CoerceRule) coerceNumber(value interface{}, locale *localeFormat) (interface{}, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		return value, true
+	case bool:
+		if value {
+			return json.Number("1"), true
+		}
+
+		return json.Number("0"), true
+	case string:
+		str := locale.number(strings.TrimSpace(value), rule.Thousands, rule.Decimal)
+		str = strings.TrimPrefix(str, "+")
+		if _, err := strconv.ParseFloat(str, 64); err != nil || str == "" {
+			return nil, false
+		}
+
+		return json.Number(str), true
+	}
+
+	return nil, false
+}
+
+// coerceTime will coerce a string in a locale to an RFC3339 timestamp.
+func (rule *CoerceRule) coerceTime(value interface{}, locale *localeFormat) (interface{}, bool) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, false
+	}
+
+	ts, err := time.Parse(rule.Layout, locale.englishDate(rule.Layout, strings.TrimSpace(str)))
+	if err != nil {
+		return nil, false
+	}
+
+	return ts.Format(time.RFC3339Nano), true
+}
+
+// coerce will coerce a value of the rule's fields in a locale.
+func (rule *CoerceRule) coerce(field string, value interface{}, locale *localeFormat) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	if str, ok := value.(string); ok {
+		for _, null := range rule.Nulls {
+			if str == null {
+				return nil, nil
+			}
+		}
+	}
+
+	var (
+		coerced interface{}
+		ok      = true
+	)
+
+	switch rule.Type {
+	case CoerceNumber:
+		coerced, ok = rule.coerceNumber(value, locale)
+	case CoerceBool:
+		coerced, ok = rule.coerceBool(value)
+	case CoerceTime:
+		coerced, ok = rule.coerceTime(value, locale)
+	case CoerceString:
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			ok = false
+		default:
+			coerced = fmt.Sprint(value)
+		}
+	default:
+		coerced = value
+	}
+
+	if ok {
+		return coerced, nil
+	}
+
+	switch rule.OnError {
+	case CoerceOnErrorNull:
+		return nil, nil
+	case CoerceOnErrorFail:
+		return nil, fmt.Errorf("%w %v of %q to %s", ErrCoerce, value, field, rule.Type)
+	default:
+		return value, nil
+	}
+}
+
+// applyCoercion will coerce the fields of the records of a JSON encoded response in the locale of a BCP 47 language
+// tag, if any.
+func applyCoercion(rules []*CoerceRule, locale string, dataType tools.UpsertDataType, data []byte) ([]byte, error) {
+	if len(rules) == 0 {
+		return data, nil
+	}
+
+	format, err := parseLocale(locale)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := dataType.DecodeNumbers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, rule := range rules {
+			for _, field := range rule.Fields {
+				value, ok := rec[field]
+				if !ok {
+					continue
+				}
+
+				coerced, err := rule.coerce(field, value, format)
+				if err != nil {
+					return nil, err
+				}
+
+				rec[field] = coerced
+			}
+		}
+	}
+
+	return dataType.Marshal(decoded)
+}
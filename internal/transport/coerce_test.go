@@ -0,0 +1,153 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+func TestApplyCoercion(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		rules    []*CoerceRule
+		locale   string
+		data     string
+		expected string
+		err      error
+	}{
+		{
+			name:     "bool",
+			rules:    []*CoerceRule{{Fields: []string{"active", "test"}, Type: CoerceBool}},
+			data:     `[{"active":"1","test":"No"},{"active":0,"test":true}]`,
+			expected: `[{"active":true,"test":false},{"active":false,"test":true}]`,
+		},
+		{
+			name:     "custom bool",
+			rules:    []*CoerceRule{{Fields: []string{"side"}, Type: CoerceBool, True: []string{"buy"}, False: []string{"sell"}}},
+			data:     `{"side":"BUY"}`,
+			expected: `{"side":true}`,
+		},
+		{
+			name:     "number",
+			rules:    []*CoerceRule{{Fields: []string{"volume"}, Type: CoerceNumber, Thousands: ",", Nulls: []string{"", "N/A"}}},
+			data:     `[{"volume":"1,234,567.891"},{"volume":"N/A"},{"volume":""},{"volume":"+7"},{"volume":2}]`,
+			expected: `[{"volume":1234567.891},{"volume":null},{"volume":null},{"volume":7},{"volume":2}]`,
+		},
+		{
+			name:     "german number",
+			rules:    []*CoerceRule{{Fields: []string{"price"}, Type: CoerceNumber}},
+			locale:   "de-DE",
+			data:     `[{"price":"1.234.567,891"},{"price":"-0,5"}]`,
+			expected: `[{"price":1234567.891},{"price":-0.5}]`,
+		},
+		{
+			name:     "french number",
+			rules:    []*CoerceRule{{Fields: []string{"price"}, Type: CoerceNumber}},
+			locale:   "fr-FR",
+			data:     "{\"price\":\"1\u202f234,5\"}",
+			expected: `{"price":1234.5}`,
+		},
+		{
+			name:     "swiss number",
+			rules:    []*CoerceRule{{Fields: []string{"price"}, Type: CoerceNumber}},
+			locale:   "de-CH",
+			data:     `{"price":"1'234.5"}`,
+			expected: `{"price":1234.5}`,
+		},
+		{
+			name:     "german time",
+			rules:    []*CoerceRule{{Fields: []string{"date"}, Type: CoerceTime, Layout: "Monday, 2. January 2006"}},
+			locale:   "de",
+			data:     `{"date":"Freitag, 3. März 2023"}`,
+			expected: `{"date":"2023-03-03T00:00:00Z"}`,
+		},
+		{
+			name:     "abbreviated portuguese time",
+			rules:    []*CoerceRule{{Fields: []string{"date"}, Type: CoerceTime, Layout: "Mon, 02 Jan 2006 15:04"}},
+			locale:   "pt-BR",
+			data:     `{"date":"sexta-feira, 03 fev 2023 09:30"}`,
+			expected: `{"date":"2023-02-03T09:30:00Z"}`,
+		},
+		{
+			name:     "string",
+			rules:    []*CoerceRule{{Fields: []string{"id"}, Type: CoerceString}},
+			data:     `[{"id":12345678901234567890},{"id":true}]`,
+			expected: `[{"id":"12345678901234567890"},{"id":"true"}]`,
+		},
+		{
+			name:     "nulls",
+			rules:    []*CoerceRule{{Fields: []string{"note"}, Nulls: []string{"-"}}},
+			data:     `[{"note":"-"},{"note":"ok"}]`,
+			expected: `[{"note":null},{"note":"ok"}]`,
+		},
+		{
+			name:     "keep",
+			rules:    []*CoerceRule{{Fields: []string{"volume"}, Type: CoerceNumber}},
+			data:     `[{"volume":"lots"}]`,
+			expected: `[{"volume":"lots"}]`,
+		},
+		{
+			name:     "null",
+			rules:    []*CoerceRule{{Fields: []string{"volume"}, Type: CoerceNumber, OnError: CoerceOnErrorNull}},
+			data:     `[{"volume":"lots"}]`,
+			expected: `[{"volume":null}]`,
+		},
+		{
+			name:  "fail",
+			rules: []*CoerceRule{{Fields: []string{"active"}, Type: CoerceBool, OnError: CoerceOnErrorFail}},
+			data:  `[{"active":"maybe"}]`,
+			err:   ErrCoerce,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			coerced, err := applyCoercion(tcase.rules, tcase.locale, tools.UpsertDataJSON, []byte(tcase.data))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if string(coerced) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, coerced)
+			}
+		})
+	}
+}
+
+func TestCoerceRuleValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		rule *CoerceRule
+		err  error
+	}{
+		{"no fields", &CoerceRule{Type: CoerceBool}, ErrMissingConfigField},
+		{"unknown type", &CoerceRule{Fields: []string{"a"}, Type: "date"}, ErrInvalidCoerce},
+		{"no type or nulls", &CoerceRule{Fields: []string{"a"}}, ErrInvalidCoerce},
+		{"time without layout", &CoerceRule{Fields: []string{"a"}, Type: CoerceTime}, ErrMissingConfigField},
+		{"unknown onError", &CoerceRule{Fields: []string{"a"}, Type: CoerceBool, OnError: "drop"}, ErrInvalidCoerce},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.rule.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+// defaultCollationStrength is the default comparison strength of a collation, which ignores case.
+const defaultCollationStrength = 2
+
+var ErrInvalidCollation = fmt.Errorf("invalid collation")
+
+// InvalidCollationError wraps an error with ErrInvalidCollation.
+func InvalidCollationError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCollation, reason)
+}
+
+// CollationConfig is the collation that the records of a table are matched with when they are upserted, e.g. so that
+// keys that only differ by case update the same record instead of duplicating it.
+type CollationConfig struct {
+	// Locale is the ICU locale that MongoDB matches the documents with, e.g. "en". The collection should be created
+	// with the same collation, since queries with another collation can not use its indexes.
+	Locale string `yaml:"locale"`
+
+	// Strength is the ICU comparison strength of the locale: 1 compares base characters only, 2 also diacritics, and
+	// 3 also case. Defaults to 2, which ignores case.
+	Strength int `yaml:"strength"`
+
+	// Postgres is the name of the collation that Postgres matches the keys with, e.g. a nondeterministic ICU
+	// collation. The table needs a unique index on its primary keys with this collation.
+	Postgres string `yaml:"postgres"`
+
+	// Keys are the primary keys that Postgres matches with the collation, e.g. the text keys of a table with a
+	// composite key. Defaults to every primary key.
+	Keys []string `yaml:"keys"`
+}
+
+func (cc *CollationConfig) validate() error {
+	if cc == nil {
+		return nil
+	}
+
+	if cc.Locale == "" && cc.Postgres == "" {
+		return InvalidCollationError("locale or postgres is required")
+	}
+
+	if cc.Strength < 0 || cc.Strength > 5 {
+		return InvalidCollationError("strength must be between 1 and 5")
+	}
+
+	if len(cc.Keys) > 0 && cc.Postgres == "" {
+		return InvalidCollationError("keys require a postgres collation")
+	}
+
+	return nil
+}
+
+// apply will set the collation on an upsert request.
+func (cc *CollationConfig) apply(req *proto.UpsertRequest) {
+	if cc == nil {
+		return
+	}
+
+	req.CollationLocale = cc.Locale
+	req.KeyCollation = cc.Postgres
+	req.CollationKeys = cc.Keys
+
+	if cc.Locale != "" {
+		req.CollationStrength = defaultCollationStrength
+		if cc.Strength != 0 {
+			req.CollationStrength = int32(cc.Strength)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+func TestCollationConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name      string
+		collation *CollationConfig
+		expectErr error
+		locale    string
+		strength  int32
+		key       string
+	}{
+		{name: "nil"},
+		{
+			name:      "case-insensitive locale",
+			collation: &CollationConfig{Locale: "en"},
+			locale:    "en",
+			strength:  2,
+		},
+		{
+			name:      "postgres collation",
+			collation: &CollationConfig{Postgres: "case_insensitive", Keys: []string{"symbol"}},
+			key:       "case_insensitive",
+		},
+		{
+			name:      "missing collation",
+			collation: &CollationConfig{Strength: 2},
+			expectErr: ErrInvalidCollation,
+		},
+		{
+			name:      "invalid strength",
+			collation: &CollationConfig{Locale: "en", Strength: 6},
+			expectErr: ErrInvalidCollation,
+		},
+		{
+			name:      "keys without postgres",
+			collation: &CollationConfig{Locale: "en", Keys: []string{"symbol"}},
+			expectErr: ErrInvalidCollation,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.collation.validate(); !errors.Is(err, tcase.expectErr) {
+				t.Fatalf("expected error %v, got %v", tcase.expectErr, err)
+			}
+
+			if tcase.expectErr != nil {
+				return
+			}
+
+			req := new(proto.UpsertRequest)
+			tcase.collation.apply(req)
+
+			if req.CollationLocale != tcase.locale || req.CollationStrength != tcase.strength ||
+				req.KeyCollation != tcase.key {
+				t.Fatalf("expected locale %q, strength %d, and key collation %q, got %q, %d, and %q", tcase.locale,
+					tcase.strength, tcase.key, req.CollationLocale, req.CollationStrength, req.KeyCollation)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// defaultCompressMinSize is the default size in bytes of the smallest values that are compressed.
+const defaultCompressMinSize = 1024
+
+var ErrInvalidCompress = fmt.Errorf("invalid compress")
+
+// InvalidCompressError wraps an error with ErrInvalidCompress.
+func InvalidCompressError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCompress, reason)
+}
+
+// CompressConfig compresses large payload fields of the records of a table with zstd before they are stored, to
+// control the storage costs of verbose web APIs. A compressed value is stored as a string that holds a marker and the
+// base64 encoded zstd frame, so SQL columns of compressed fields must hold text. The records of read responses are
+// restored with "tools.DecompressRecords" and the same fields.
+type CompressConfig struct {
+	// Fields are the fields that hold strings, objects, or arrays to compress.
+	Fields []string `yaml:"fields"`
+
+	// MinSize is the size in bytes of the smallest values that are compressed, as text or JSON. Defaults to 1024.
+	MinSize int `yaml:"minSize"`
+}
+
+func (cc *CompressConfig) validate() error {
+	if cc == nil {
+		return nil
+	}
+
+	if len(cc.Fields) == 0 {
+		return InvalidCompressError("fields are required")
+	}
+
+	if cc.MinSize < 0 {
+		return InvalidCompressError("minSize can not be negative")
+	}
+
+	return nil
+}
+
+// apply will compress the fields of every record of JSON encoded upsert data.
+func (cc *CompressConfig) apply(data []byte) ([]byte, error) {
+	if cc == nil {
+		return data, nil
+	}
+
+	minSize := cc.MinSize
+	if minSize == 0 {
+		minSize = defaultCompressMinSize
+	}
+
+	compressed, err := tools.CompressRecords(data, cc.Fields, minSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compress records: %w", err)
+	}
+
+	return compressed, nil
+}
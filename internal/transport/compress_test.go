@@ -0,0 +1,38 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCompressConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		cc   *CompressConfig
+		err  error
+	}{
+		{name: "nil"},
+		{name: "fields", cc: &CompressConfig{Fields: []string{"raw"}, MinSize: 10}},
+		{name: "no fields", cc: &CompressConfig{}, err: ErrInvalidCompress},
+		{name: "negative min size", cc: &CompressConfig{Fields: []string{"raw"}, MinSize: -1}, err: ErrInvalidCompress},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.cc.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var ErrMissingCopyDestination = fmt.Errorf("destination connection string is required to copy tables")
+
+// copyPageSize is the number of records of each page read from the source of a copy.
+const copyPageSize = snapshotBatchSize
+
+// copyCursor reads the pages of a table of the source of a copy, in the order of their primary keys. Every snapshot
+// chunk of the table reads the next page when it is imported, so that only the pages that are being upserted are held
+// in memory. It is safe for concurrent use.
+type copyCursor struct {
+	repo  repository.Generic
+	table string
+
+	mu sync.Mutex
+
+	// after is the last record read, and read is the number of records read.
+	after *structpb.Struct
+	read  int64
+}
+
+// next will read the next page of the table, returning no records once every record has been read.
+func (cur *copyCursor) next(ctx context.Context) ([]json.RawMessage, error) {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	req := &storage.ReadPageRequest{Table: cur.table, After: cur.after, Limit: copyPageSize}
+
+	stored, err := cur.repo.ReadPage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to copy %q: %w", cur.table, err)
+	}
+
+	records := make([]json.RawMessage, len(stored.Records))
+
+	for idx, record := range stored.Records {
+		if records[idx], err = protojson.Marshal(record); err != nil {
+			return nil, fmt.Errorf("unable to copy %q: %w", cur.table, err)
+		}
+	}
+
+	if len(stored.Records) > 0 {
+		cur.after = stored.Records[len(stored.Records)-1]
+		cur.read += int64(len(stored.Records))
+	}
+
+	return records, nil
+}
+
+// copied will return the number of records read.
+func (cur *copyCursor) copied() int64 {
+	cur.mu.Lock()
+	defer cur.mu.Unlock()
+
+	return cur.read
+}
+
+// countCopyTables will count the records of the tables of the source storage device of a copy, returning them as the
+// manifest of a snapshot, where the file of each table is its name, along with a cursor for each table.
+func (cfg *Config) countCopyTables(ctx context.Context, repo repository.Generic, tables []string,
+) (*SnapshotManifest, map[string]*copyCursor, error) {
+	names, err := exportTables(ctx, repo, tables)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scheme := storage.Scheme(repo.Type())
+	manifest := &SnapshotManifest{Version: snapshotVersion, CreatedAt: time.Now().UTC(), Format: SnapshotFormatNDJSON}
+	cursors := make(map[string]*copyCursor, len(names))
+
+	for _, table := range names {
+		count, err := repo.CountTable(ctx, table)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to copy %q: %w", table, err)
+		}
+
+		manifest.Tables = append(manifest.Tables, &SnapshotTable{
+			Storage: scheme,
+			Table:   table,
+			File:    table,
+			Records: count,
+		})
+		cursors[table] = &copyCursor{repo: repo, table: table}
+	}
+
+	return manifest, cursors, nil
+}
+
+// flattenCopy will convert the tables of a copy into a flattened request per page of their records, which read their
+// pages from the cursors of the tables when they are imported.
+func (cfg *Config) flattenCopy(manifest *SnapshotManifest, cursors map[string]*copyCursor) []*flattenedRequest {
+	var flattenedRequests []*flattenedRequest
+
+	for _, entry := range manifest.Tables {
+		pages := int((entry.Records + copyPageSize - 1) / copyPageSize)
+		budget := newErrorBudget(cfg.ErrorBudget, pages)
+
+		// The URL identifies the source table without exposing the connection string.
+		uri := &url.URL{Scheme: entry.Storage, Path: "/" + entry.Table}
+
+		for page := 0; page < pages; page++ {
+			flattenedRequests = append(flattenedRequests, &flattenedRequest{
+				table:   entry.Table,
+				budget:  budget,
+				noCache: true,
+				snapshot: &snapshotChunk{
+					manifest: manifest,
+					entry:    entry,
+					url:      uri,
+					cursor:   cursors[entry.Table],
+				},
+				storageOptions: new(storageOptions),
+			})
+		}
+	}
+
+	return flattenedRequests
+}
+
+// CopyTables will copy tables from the storage device of a connection string to every storage device in the
+// configuration, e.g. from PostgreSQL to MongoDB. The records of each table are counted, and then read a page at a
+// time in the order of their primary keys through the source storage device's implementation, so that tables larger
+// than memory can be copied. Each page is upserted as a batch through the upsert pipeline of a run, so that the
+// progress, flush policies, journals, and audit log of the configuration apply to the copy. Records that are added to
+// the source after they are counted are not copied. If no tables are given, every table of the source is copied.
+// Copied records are neither stamped nor transformed.
+func CopyTables(ctx context.Context, cfg *Config, from string, tables []string) error {
+	start := time.Now()
+
+	if len(cfg.ConnectionStrings) == 0 {
+		return ErrMissingCopyDestination
+	}
+
+	repo, err := cfg.newRepo(ctx, from)
+	if err != nil {
+		return err
+	}
+
+	defer repo.Close()
+
+	// Nothing is written to the source, so its transaction is rolled back.
+	defer func() {
+		if err := repo.Rollback(); err != nil {
+			cfg.Logger.Warn(tools.LogFormatter{Msg: fmt.Sprintf("unable to roll back %q: %v", from, err)}.String())
+		}
+	}()
+
+	manifest, cursors, err := cfg.countCopyTables(ctx, repo, tables)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.importRecords(ctx, cfg.flattenCopy(manifest, cursors)); err != nil {
+		return err
+	}
+
+	var total int64
+	for _, cursor := range cursors {
+		total += cursor.copied()
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg:      fmt.Sprintf("copied %d records of %d tables", total, len(manifest.Tables)),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runIDLogField is the field of the run ID in the entries of the configuration's logger.
+const runIDLogField = "run_id"
+
+// runIDHook adds the ID of the current transport operation to the entries of a logger.
+type runIDHook struct {
+	mu    sync.RWMutex
+	runID string
+}
+
+// Levels implements the logrus.Hook interface.
+func (hook *runIDHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements the logrus.Hook interface.
+func (hook *runIDHook) Fire(entry *logrus.Entry) error {
+	hook.mu.RLock()
+	defer hook.mu.RUnlock()
+
+	if hook.runID != "" {
+		entry.Data[runIDLogField] = hook.runID
+	}
+
+	return nil
+}
+
+// set will set the run ID of the hook, returning the previous one.
+func (hook *runIDHook) set(runID string) string {
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+
+	previous := hook.runID
+	hook.runID = runID
+
+	return previous
+}
+
+// logRunID will add the run ID to the entries of the configuration's logger, until the returned function is called,
+// so that the logs of a transport operation can be correlated with the logs of the web API.
+func (cfg *Config) logRunID(runID string) func() {
+	if cfg.Logger == nil {
+		return func() {}
+	}
+
+	// The hook is added again if the logger was replaced after the configuration was created.
+	if cfg.runIDHook == nil || cfg.runIDLogger != cfg.Logger {
+		cfg.runIDHook = new(runIDHook)
+		cfg.runIDLogger = cfg.Logger
+		cfg.Logger.AddHook(cfg.runIDHook)
+	}
+
+	hook := cfg.runIDHook
+	previous := hook.set(runID)
+
+	return func() { hook.set(previous) }
+}
+
+// correlate will set the correlation header of the web requests of a transport operation to its run ID, so that the
+// logs of the web API can be correlated with the operation.
+func (cfg *Config) correlate(flattenedRequests []*flattenedRequest, runID string) {
+	if cfg.CorrelationHeader == "" {
+		return
+	}
+
+	for _, req := range flattenedRequests {
+		if req.fetchConfig == nil {
+			continue
+		}
+
+		// The headers are copied, since flattened requests can share them, e.g. the failed chunks of a retry.
+		header := req.fetchConfig.Header.Clone()
+		if header == nil {
+			header = make(http.Header)
+		}
+
+		header.Set(cfg.CorrelationHeader, runID)
+		req.fetchConfig.Header = header
+	}
+}
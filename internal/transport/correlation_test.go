@@ -0,0 +1,75 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLogRunID(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	cfg := &Config{Logger: logrus.New()}
+	cfg.Logger.SetOutput(&buf)
+
+	resetRun := cfg.logRunID("run-1")
+	resetTruncate := cfg.logRunID("run-1")
+	resetTruncate()
+	cfg.Logger.Info("during")
+	resetRun()
+	cfg.Logger.Info("after")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %q", buf.String())
+	}
+
+	if !strings.Contains(lines[0], "run_id=run-1") {
+		t.Fatalf("expected the run ID in the log of the run, got %q", lines[0])
+	}
+
+	if strings.Contains(lines[1], "run_id") {
+		t.Fatalf("expected no run ID after the run, got %q", lines[1])
+	}
+}
+
+func TestCorrelate(t *testing.T) {
+	t.Parallel()
+
+	shared := http.Header{"Idempotency-Key": []string{"key"}}
+
+	flattenedRequests := []*flattenedRequest{
+		{fetchConfig: &web.FetchConfig{}},
+		{fetchConfig: &web.FetchConfig{Header: shared}},
+	}
+
+	cfg := &Config{CorrelationHeader: "X-Correlation-ID"}
+	cfg.correlate(flattenedRequests, "run-1")
+
+	for _, req := range flattenedRequests {
+		if id := req.fetchConfig.Header.Get("X-Correlation-ID"); id != "run-1" {
+			t.Fatalf("expected the correlation header to be the run ID, got %q", id)
+		}
+	}
+
+	if key := flattenedRequests[1].fetchConfig.Header.Get("Idempotency-Key"); key != "key" {
+		t.Fatalf("expected the headers of the request to be kept, got %v", flattenedRequests[1].fetchConfig.Header)
+	}
+
+	if shared.Get("X-Correlation-ID") != "" {
+		t.Fatalf("expected the shared headers not to be modified, got %v", shared)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+)
+
+var ErrInvalidDecode = fmt.Errorf("invalid decode configuration")
+
+// InvalidDecodeError wraps an error with ErrInvalidDecode.
+func InvalidDecodeError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidDecode, reason)
+}
+
+// DecodeConfig processes the fetched responses on a pool of decode workers, separate from the web workers, instead of
+// on the web worker that fetched them. Decoding, coercing, and transforming large responses is CPU-bound, so without
+// a decode pool the web workers stop fetching while they process. The web workers queue the fetched responses for the
+// decode workers, and block once the queue is full, so that the responses held in memory are bounded.
+type DecodeConfig struct {
+	// Workers is the number of decode workers. Defaults to the number of CPUs.
+	Workers int `yaml:"workers"`
+
+	// Queue is the number of fetched responses that wait for a decode worker before the web workers block. Defaults
+	// to the number of decode workers.
+	Queue int `yaml:"queue"`
+}
+
+func (dc *DecodeConfig) validate() error {
+	if dc == nil {
+		return nil
+	}
+
+	if dc.Workers < 0 || dc.Queue < 0 {
+		return InvalidDecodeError("workers and queue can not be negative")
+	}
+
+	return nil
+}
+
+// startDecoders will start the decode workers of a run, returning the queue of the fetched responses, or nil if the
+// web workers process the responses that they fetch. The queue must be closed once the web workers are done, which
+// stops the decode workers.
+func (dc *DecodeConfig) startDecoders(ctx context.Context, threads int) chan *fetchedResponse {
+	if dc == nil {
+		return nil
+	}
+
+	workers := dc.Workers
+	if workers == 0 {
+		workers = threads
+	}
+
+	queue := dc.Queue
+	if queue == 0 {
+		queue = workers
+	}
+
+	jobs := make(chan *fetchedResponse, queue)
+
+	for id := 1; id <= workers; id++ {
+		go decodeWorker(ctx, id, jobs)
+	}
+
+	return jobs
+}
+
+func decodeWorker(ctx context.Context, workerID int, jobs <-chan *fetchedResponse) {
+	for fetched := range jobs {
+		fetched.finish(ctx, workerID, "decode")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestDecodeWorkers(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	repoJobs := make(chan *repoJob, 3)
+	decodeJobs := (&DecodeConfig{Workers: 2, Queue: 1}).startDecoders(context.Background(), 4)
+
+	go func() {
+		for _, table := range []string{"a", "b", "c"} {
+			job := &webJob{
+				flattenedRequest: &flattenedRequest{table: table, storageOptions: new(storageOptions)},
+				repoJobs:         repoJobs,
+				logger:           logger,
+			}
+
+			decodeJobs <- &fetchedResponse{
+				webJob: job,
+				bytes:  []byte(`[{"table": "` + table + `"}]`),
+				req:    &http.Request{URL: &url.URL{Host: "api.example.com", Path: "/" + table}},
+				start:  time.Now(),
+			}
+		}
+
+		close(decodeJobs)
+	}()
+
+	var tables []string
+
+	for range []string{"a", "b", "c"} {
+		select {
+		case rjob := <-repoJobs:
+			if string(rjob.b) != `[{"table": "`+rjob.table+`"}]` {
+				t.Fatalf("unexpected records for table %s: %s", rjob.table, rjob.b)
+			}
+
+			tables = append(tables, rjob.table)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the decoded responses, got %v", tables)
+		}
+	}
+
+	sort.Strings(tables)
+
+	if len(tables) != 3 || tables[0] != "a" || tables[2] != "c" {
+		t.Fatalf("expected the responses of tables a, b, and c, got %v", tables)
+	}
+}
+
+func TestDecodeConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := (&DecodeConfig{Workers: -1}).validate(); !errors.Is(err, ErrInvalidDecode) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidDecode, err)
+	}
+
+	if decodeJobs := (*DecodeConfig)(nil).startDecoders(context.Background(), 4); decodeJobs != nil {
+		t.Fatal("expected no decode workers without a decode configuration")
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// TableDiff is the difference between the records fetched for a table and the records stored in the table of a
+// storage device, by the primary keys of the table. Keys are the JSON encoded values of the primary keys, separated by
+// commas.
+type TableDiff struct {
+	// Storage is the scheme of the storage device, e.g. "postgresql".
+	Storage string `json:"storage"`
+
+	// Table is the name of the table/collection.
+	Table string `json:"table"`
+
+	// New are the keys of the fetched records that are not stored.
+	New []string `json:"new"`
+
+	// Changed are the keys of the fetched records whose fields differ from the stored record.
+	Changed []string `json:"changed"`
+
+	// Deleted are the keys of the stored records that were not fetched.
+	Deleted []string `json:"deleted"`
+
+	// Unchanged is the number of fetched records whose fields match the stored record.
+	Unchanged int64 `json:"unchanged"`
+}
+
+// normalizeDiffValue will normalize a value so that equal values compare equal after a round trip through storage:
+// timestamps are compared in UTC, whatever their offset.
+func normalizeDiffValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		if ts, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return ts.UTC().Format(time.RFC3339Nano)
+		}
+	case map[string]interface{}:
+		for key, val := range value {
+			value[key] = normalizeDiffValue(val)
+		}
+	case []interface{}:
+		for idx, val := range value {
+			value[idx] = normalizeDiffValue(val)
+		}
+	}
+
+	return value
+}
+
+// diffChecksum will return the checksum of the fields of a record, normalized. Fields that the record does not have
+// are checksummed as null.
+func diffChecksum(record *structpb.Struct, fields []string) ([32]byte, error) {
+	values := make(map[string]interface{}, len(fields))
+
+	for _, field := range fields {
+		var value interface{}
+		if val, ok := record.GetFields()[field]; ok {
+			value = normalizeDiffValue(val.AsInterface())
+		}
+
+		values[field] = value
+	}
+
+	// Maps are encoded with sorted keys, so the encoding is canonical.
+	bytes, err := json.Marshal(values)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("unable to encode record: %w", err)
+	}
+
+	return sha256.Sum256(bytes), nil
+}
+
+// diffRecords will compare the fetched records of a table with its stored records. Only the fields of the fetched
+// records are compared, so that columns that a run does not write, e.g. stamps, do not count as changes. If a key is
+// fetched more than once, the last record is compared, as it is the one that an upsert stores.
+func diffRecords(scheme, table string, fetched []*structpb.Struct, stored *storage.StoredRecords) (*TableDiff, error) {
+	diff := &TableDiff{Storage: scheme, Table: table}
+
+	// Keys are the JSON encoded values of the primary keys, separated by commas. Records that are missing a key are
+	// keyed by null.
+	diffKey := tools.CompositeKey{Fields: stored.PrimaryKeys, AllowNulls: true}
+
+	storedRecords := make(map[string]*structpb.Struct, len(stored.Records))
+
+	for _, record := range stored.Records {
+		key, err := diffKey.StructKey(record)
+		if err != nil {
+			return nil, err
+		}
+
+		storedRecords[key] = record
+	}
+
+	fetchedRecords := make(map[string]*structpb.Struct, len(fetched))
+
+	var fetchedKeys []string
+
+	for _, record := range fetched {
+		key, err := diffKey.StructKey(record)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, ok := fetchedRecords[key]; !ok {
+			fetchedKeys = append(fetchedKeys, key)
+		}
+
+		fetchedRecords[key] = record
+	}
+
+	for _, key := range fetchedKeys {
+		record := fetchedRecords[key]
+
+		storedRecord, ok := storedRecords[key]
+		if !ok {
+			diff.New = append(diff.New, key)
+
+			continue
+		}
+
+		fields := make([]string, 0, len(record.GetFields()))
+		for field := range record.GetFields() {
+			fields = append(fields, field)
+		}
+
+		fetchedSum, err := diffChecksum(record, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		storedSum, err := diffChecksum(storedRecord, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		if fetchedSum != storedSum {
+			diff.Changed = append(diff.Changed, key)
+
+			continue
+		}
+
+		diff.Unchanged++
+	}
+
+	for key := range storedRecords {
+		if _, ok := fetchedRecords[key]; !ok {
+			diff.Deleted = append(diff.Deleted, key)
+		}
+	}
+
+	sort.Strings(diff.New)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Deleted)
+
+	return diff, nil
+}
+
+// diffFetch will fetch and process the data of a flattened request, returning the records that each storage device
+// would store, by scheme and table. The records are not stamped, since stamps differ between runs.
+func (cfg *Config) diffFetch(ctx context.Context, flatReq *flattenedRequest, schemes []string,
+) (map[string]map[string][]*structpb.Struct, error) {
+	bytes, httpReq, err := fetchBytes(ctx, flatReq.fetchConfig)
+	if err != nil {
+		return nil, &Error{Table: flatReq.table, URL: flatReq.fetchConfig.URL.String(), Err: err}
+	}
+
+	job := &webJob{
+		flattenedRequest: flatReq,
+		recordType:       cfg.RecordTypes[flatReq.table],
+		logger:           cfg.Logger,
+		embedder:         cfg.embedder(),
+		wasm:             cfg.WASMRuntime,
+	}
+
+	if bytes, err = job.process(ctx, bytes); err != nil {
+		return nil, &Error{Table: flatReq.table, URL: httpReq.URL.String(), Err: err}
+	}
+
+	repoJob := &repoJob{b: bytes, req: *httpReq, table: flatReq.table, storageOptions: flatReq.storageOptions}
+	records := make(map[string]map[string][]*structpb.Struct, len(schemes))
+
+	for _, scheme := range schemes {
+		reqs, err := repoJob.upsertRequests(scheme)
+		if err != nil {
+			return nil, &Error{Table: flatReq.table, URL: httpReq.URL.String(), Err: err}
+		}
+
+		records[scheme] = make(map[string][]*structpb.Struct, len(reqs))
+
+		for _, req := range reqs {
+			decoded, err := tools.DecodeUpsertRecords(req)
+			if err != nil {
+				return nil, &Error{Table: req.Table, URL: httpReq.URL.String(), Err: err}
+			}
+
+			records[scheme][req.Table] = append(records[scheme][req.Table], decoded...)
+		}
+	}
+
+	return records, nil
+}
+
+// DiffTables will fetch the data of every request in the configuration and compare the records with the records
+// stored on each storage device, by the primary keys of the tables, without writing to storage. Every stored record
+// that was not fetched is reported as deleted, so the requests should fetch entire tables. Storage devices and tables
+// that can not be read are skipped, as are requests that read from a SQL source.
+func DiffTables(ctx context.Context, cfg *Config) ([]*TableDiff, error) {
+	flattenedRequests, err := cfg.flattenRequests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, closeRepos, err := cfg.repos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeRepos()
+
+	schemes := make([]string, len(repos))
+	for idx, repo := range repos {
+		schemes[idx] = storage.Scheme(repo.Type())
+	}
+
+	// fetched are the fetched records by scheme and table.
+	fetched := make(map[string]map[string][]*structpb.Struct)
+
+	for _, flatReq := range flattenedRequests {
+		if flatReq.source != nil {
+			logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped diff of SQL source: %s", flatReq.table)}
+			cfg.Logger.Warn(logWarn.String())
+
+			continue
+		}
+
+		records, err := cfg.diffFetch(ctx, flatReq, schemes)
+		if err != nil {
+			return nil, err
+		}
+
+		for scheme, tables := range records {
+			if fetched[scheme] == nil {
+				fetched[scheme] = make(map[string][]*structpb.Struct)
+			}
+
+			for table, tableRecords := range tables {
+				fetched[scheme][table] = append(fetched[scheme][table], tableRecords...)
+			}
+		}
+	}
+
+	// The transactions of the storage devices run concurrently.
+	var (
+		mu    sync.Mutex
+		diffs []*TableDiff
+	)
+
+	for _, repo := range repos {
+		repo.Transact(func(sctx context.Context, repo repository.Generic) error {
+			scheme := storage.Scheme(repo.Type())
+
+			for table, records := range fetched[scheme] {
+				stored, err := repo.ReadTable(sctx, &proto.ReadRequest{Table: table})
+				if errors.Is(err, storage.ErrReadNotSupported) {
+					logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped diff: %v", err)}
+					cfg.Logger.Warn(logWarn.String())
+
+					continue
+				}
+
+				if err != nil {
+					return fmt.Errorf("unable to diff %q: %w", table, err)
+				}
+
+				diff, err := diffRecords(scheme, table, records, stored)
+				if err != nil {
+					return fmt.Errorf("unable to diff %q: %w", table, err)
+				}
+
+				mu.Lock()
+				diffs = append(diffs, diff)
+				mu.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	// Nothing is written, so the transactions are rolled back.
+	for _, repo := range repos {
+		if rerr := repo.Rollback(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].Storage != diffs[j].Storage {
+			return diffs[i].Storage < diffs[j].Storage
+		}
+
+		return diffs[i].Table < diffs[j].Table
+	})
+
+	return diffs, nil
+}
+
+// WriteDiff will write a table of the number of new, changed, deleted, and unchanged records of each table to w,
+// followed by the keys of the new, changed, and deleted records.
+func WriteDiff(w io.Writer, diffs []*TableDiff) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "STORAGE\tTABLE\tNEW\tCHANGED\tDELETED\tUNCHANGED\n")
+
+	for _, diff := range diffs {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n", diff.Storage, diff.Table, len(diff.New), len(diff.Changed),
+			len(diff.Deleted), diff.Unchanged)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write diff: %w", err)
+	}
+
+	for _, diff := range diffs {
+		for _, change := range []struct {
+			kind string
+			keys []string
+		}{{"new", diff.New}, {"changed", diff.Changed}, {"deleted", diff.Deleted}} {
+			if len(change.keys) == 0 {
+				continue
+			}
+
+			_, err := fmt.Fprintf(w, "\n%s.%s %s:\n  %s\n", diff.Storage, diff.Table, change.kind,
+				strings.Join(change.keys, "\n  "))
+			if err != nil {
+				return fmt.Errorf("unable to write diff: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
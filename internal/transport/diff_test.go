@@ -0,0 +1,133 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestDiffRecords(t *testing.T) {
+	t.Parallel()
+
+	records := func(t *testing.T, maps ...map[string]interface{}) []*structpb.Struct {
+		t.Helper()
+
+		structs := make([]*structpb.Struct, len(maps))
+
+		for idx, m := range maps {
+			record, err := structpb.NewStruct(m)
+			if err != nil {
+				t.Fatalf("unable to create record: %v", err)
+			}
+
+			structs[idx] = record
+		}
+
+		return structs
+	}
+
+	for _, tcase := range []struct {
+		name        string
+		primaryKeys []string
+		fetched     []map[string]interface{}
+		stored      []map[string]interface{}
+		expected    *TableDiff
+	}{
+		{
+			name:        "new, changed, deleted, and unchanged",
+			primaryKeys: []string{"id"},
+			fetched: []map[string]interface{}{
+				{"id": 1, "name": "a"},
+				{"id": 2, "name": "b"},
+				{"id": 4, "name": "d"},
+			},
+			stored: []map[string]interface{}{
+				{"id": 1, "name": "a"},
+				{"id": 2, "name": "x"},
+				{"id": 3, "name": "c"},
+			},
+			expected: &TableDiff{New: []string{"4"}, Changed: []string{"2"}, Deleted: []string{"3"}, Unchanged: 1},
+		},
+		{
+			name:        "stored columns that are not fetched are ignored",
+			primaryKeys: []string{"id"},
+			fetched:     []map[string]interface{}{{"id": 1, "name": "a"}},
+			stored:      []map[string]interface{}{{"id": 1, "name": "a", "ingested_at": "2022-01-01T00:00:00Z"}},
+			expected:    &TableDiff{Unchanged: 1},
+		},
+		{
+			name:        "fetched fields that are not stored are changed",
+			primaryKeys: []string{"id"},
+			fetched:     []map[string]interface{}{{"id": 1, "name": "a"}},
+			stored:      []map[string]interface{}{{"id": 1}},
+			expected:    &TableDiff{Changed: []string{"1"}},
+		},
+		{
+			name:        "timestamps are compared in UTC",
+			primaryKeys: []string{"id"},
+			fetched:     []map[string]interface{}{{"id": 1, "at": "2022-01-01T00:00:00Z"}},
+			stored:      []map[string]interface{}{{"id": 1, "at": "2022-01-01T01:00:00+01:00"}},
+			expected:    &TableDiff{Unchanged: 1},
+		},
+		{
+			name:        "the last record of a key is compared",
+			primaryKeys: []string{"id"},
+			fetched:     []map[string]interface{}{{"id": 1, "name": "x"}, {"id": 1, "name": "a"}},
+			stored:      []map[string]interface{}{{"id": 1, "name": "a"}},
+			expected:    &TableDiff{Unchanged: 1},
+		},
+		{
+			name:        "composite keys",
+			primaryKeys: []string{"id", "region"},
+			fetched:     []map[string]interface{}{{"id": 1, "region": "eu"}},
+			stored:      []map[string]interface{}{{"id": 1, "region": "us"}},
+			expected:    &TableDiff{New: []string{`1,"eu"`}, Deleted: []string{`1,"us"`}},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			stored := &storage.StoredRecords{PrimaryKeys: tcase.primaryKeys, Records: records(t, tcase.stored...)}
+
+			diff, err := diffRecords("postgresql", "accounts", records(t, tcase.fetched...), stored)
+			if err != nil {
+				t.Fatalf("unable to diff records: %v", err)
+			}
+
+			tcase.expected.Storage, tcase.expected.Table = "postgresql", "accounts"
+			if !reflect.DeepEqual(diff, tcase.expected) {
+				t.Fatalf("expected diff %+v, got %+v", tcase.expected, diff)
+			}
+		})
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	diffs := []*TableDiff{{Storage: "postgresql", Table: "accounts", New: []string{"4", "5"}, Unchanged: 2}}
+	if err := WriteDiff(&buf, diffs); err != nil {
+		t.Fatalf("unable to write diff: %v", err)
+	}
+
+	for _, expected := range []string{"postgresql  accounts  2    0        0        2", "postgresql.accounts new:\n  4\n  5"} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Fatalf("expected diff to contain %q, got:\n%s", expected, buf.String())
+		}
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// defaultEmbeddingBatchSize is the default number of texts that are embedded with a single request.
+const defaultEmbeddingBatchSize = 100
+
+var (
+	ErrInvalidEmbed = fmt.Errorf("invalid embed")
+	ErrNoEmbedder   = fmt.Errorf("no embedder is configured")
+	ErrEmbedFailed  = fmt.Errorf("failed to embed")
+)
+
+// InvalidEmbedError wraps an error with ErrInvalidEmbed.
+func InvalidEmbedError(target, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidEmbed, target, reason)
+}
+
+// EmbedFailedError wraps an error with ErrEmbedFailed.
+func EmbedFailedError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrEmbedFailed, reason)
+}
+
+// Embedder embeds texts as vectors, e.g. with a hosted embedding model. Custom embedders, e.g. for a local model, can
+// be set on the configuration's "Embedder".
+type Embedder interface {
+	// Embed will return the vectors of the texts, in the same order.
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// EmbeddingConfig is the built-in embedder, which requests the vectors from an OpenAI-compatible embeddings API.
+type EmbeddingConfig struct {
+	// URL is the URL of the embeddings endpoint, e.g. "https://api.openai.com/v1/embeddings".
+	URL string `yaml:"url"`
+
+	// Model is the embedding model, e.g. "text-embedding-3-small".
+	Model string `yaml:"model"`
+
+	// APIKey is sent as a bearer token, if set.
+	APIKey string `yaml:"apiKey"`
+
+	// BatchSize is the number of texts that are embedded with a single request. Defaults to 100.
+	BatchSize int `yaml:"batchSize"`
+}
+
+func (ec *EmbeddingConfig) validate() error {
+	if ec == nil {
+		return nil
+	}
+
+	if ec.URL == "" {
+		return MissingConfigFieldError("embedding.url")
+	}
+
+	if ec.BatchSize < 0 {
+		return InvalidEmbedError(ec.URL, "batch size can not be negative")
+	}
+
+	return nil
+}
+
+// embedder will return the built-in embedder of the configuration.
+func (ec *EmbeddingConfig) embedder(client *http.Client) *httpEmbedder {
+	batchSize := ec.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultEmbeddingBatchSize
+	}
+
+	return &httpEmbedder{cfg: ec, client: client, batchSize: batchSize}
+}
+
+// httpEmbedder requests vectors from an OpenAI-compatible embeddings API.
+type httpEmbedder struct {
+	cfg       *EmbeddingConfig
+	client    *http.Client
+	batchSize int
+}
+
+// Embed will request the vectors of the texts in batches.
+func (emb *httpEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, 0, len(texts))
+
+	for start := 0; start < len(texts); start += emb.batchSize {
+		end := start + emb.batchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+
+		batch, err := emb.embedBatch(ctx, texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		vectors = append(vectors, batch...)
+	}
+
+	return vectors, nil
+}
+
+// embedBatch will request the vectors of a batch of texts.
+func (emb *httpEmbedder) embedBatch(ctx context.Context, texts []string) ([][]float64, error) {
+	body, err := json.Marshal(map[string]interface{}{"input": texts, "model": emb.cfg.Model})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, emb.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create embeddings request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if emb.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+emb.cfg.APIKey)
+	}
+
+	rsp, err := emb.client.Do(req)
+	if err != nil {
+		return nil, EmbedFailedError(err.Error())
+	}
+	defer rsp.Body.Close()
+
+	data, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read embeddings response: %w", err)
+	}
+
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		return nil, EmbedFailedError(fmt.Sprintf("%s: %s", rsp.Status, data))
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	if len(result.Data) != len(texts) {
+		return nil, EmbedFailedError(fmt.Sprintf("expected %d embeddings, got %d", len(texts), len(result.Data)))
+	}
+
+	vectors := make([][]float64, len(texts))
+
+	for _, item := range result.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, EmbedFailedError(fmt.Sprintf("embedding index %d is out of range", item.Index))
+		}
+
+		vectors[item.Index] = item.Embedding
+	}
+
+	return vectors, nil
+}
+
+// EmbedField embeds text fields of the records of a table, and stores the vector in a target field, e.g. for
+// retrieval with a vector store such as pgvector or Qdrant.
+type EmbedField struct {
+	// Fields are the text fields that are embedded. The texts of multiple fields are joined by newlines.
+	Fields []string `yaml:"fields"`
+
+	// Target is the field that the vector is stored in, e.g. a "vector" column on Postgres.
+	Target string `yaml:"target"`
+}
+
+func (ef *EmbedField) validate() error {
+	if ef.Target == "" {
+		return InvalidEmbedError(strings.Join(ef.Fields, ","), "target is required")
+	}
+
+	if len(ef.Fields) == 0 {
+		return InvalidEmbedError(ef.Target, "fields are required")
+	}
+
+	return nil
+}
+
+// text will return the text of a record that is embedded, or an empty string if the record has none of the fields.
+func (ef *EmbedField) text(rec map[string]interface{}) string {
+	texts := make([]string, 0, len(ef.Fields))
+
+	for _, field := range ef.Fields {
+		switch value := rec[field].(type) {
+		case nil:
+		case string:
+			if value != "" {
+				texts = append(texts, value)
+			}
+		default:
+			texts = append(texts, fmt.Sprint(value))
+		}
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// embedder will return the embedder of the configuration, preferring a custom embedder to the built-in one. It
+// returns nil if no embedder is configured.
+func (cfg *Config) embedder() Embedder {
+	if cfg.Embedder != nil {
+		return cfg.Embedder
+	}
+
+	if cfg.Embedding == nil {
+		return nil
+	}
+
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return cfg.Embedding.embedder(client)
+}
+
+// applyEmbeddings will embed the text fields of every record of JSON encoded data, storing the vectors in their target
+// fields. Records without any text are not embedded.
+func applyEmbeddings(ctx context.Context, embedder Embedder, fields []*EmbedField, data []byte) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	if embedder == nil {
+		return nil, ErrNoEmbedder
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, field := range fields {
+		var (
+			texts   []string
+			targets []map[string]interface{}
+		)
+
+		for _, record := range records {
+			rec, ok := record.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			if text := field.text(rec); text != "" {
+				texts = append(texts, text)
+				targets = append(targets, rec)
+			}
+		}
+
+		if len(texts) == 0 {
+			continue
+		}
+
+		vectors, err := embedder.Embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("unable to embed %q: %w", field.Target, err)
+		}
+
+		if len(vectors) != len(texts) {
+			return nil, EmbedFailedError(fmt.Sprintf("expected %d vectors, got %d", len(texts), len(vectors)))
+		}
+
+		for idx, rec := range targets {
+			rec[field.Target] = vectors[idx]
+		}
+	}
+
+	embedded, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return embedded, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// lengthEmbedder embeds texts as a vector of their length.
+type lengthEmbedder struct{}
+
+func (lengthEmbedder) Embed(_ context.Context, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for idx, text := range texts {
+		vectors[idx] = []float64{float64(len(text))}
+	}
+
+	return vectors, nil
+}
+
+func TestApplyEmbeddings(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		embedder Embedder
+		fields   []*EmbedField
+		data     string
+		expected string
+		err      error
+	}{
+		{
+			name:     "joined fields",
+			embedder: lengthEmbedder{},
+			fields:   []*EmbedField{{Fields: []string{"title", "body"}, Target: "vec"}},
+			data:     `[{"title":"ab","body":"cde"},{"title":"","body":null},{"title":"x","n":1.50}]`,
+			expected: `[{"body":"cde","title":"ab","vec":[6]},{"body":null,"title":""},{"n":1.50,"title":"x","vec":[1]}]`,
+		},
+		{
+			name:     "single object",
+			embedder: lengthEmbedder{},
+			fields:   []*EmbedField{{Fields: []string{"title"}, Target: "vec"}},
+			data:     `{"title":"abc"}`,
+			expected: `{"title":"abc","vec":[3]}`,
+		},
+		{
+			name:   "no embedder",
+			fields: []*EmbedField{{Fields: []string{"title"}, Target: "vec"}},
+			data:   `[{"title":"abc"}]`,
+			err:    ErrNoEmbedder,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := applyEmbeddings(context.Background(), tcase.embedder, tcase.fields, []byte(tcase.data))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err == nil && string(data) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, data)
+			}
+		})
+	}
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	t.Parallel()
+
+	var batches [][]string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		var body struct {
+			Input []string `json:"input"`
+			Model string   `json:"model"`
+		}
+
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Model != "small" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		batches = append(batches, body.Input)
+
+		// The embeddings are returned out of order, to be placed by their index.
+		type item struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		}
+
+		items := make([]item, len(body.Input))
+		for idx, text := range body.Input {
+			items[len(items)-1-idx] = item{Index: idx, Embedding: []float64{float64(len(text))}}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": items})
+	}))
+	defer srv.Close()
+
+	cfg := &EmbeddingConfig{URL: srv.URL, Model: "small", APIKey: "secret", BatchSize: 2}
+
+	vectors, err := cfg.embedder(srv.Client()).Embed(context.Background(), []string{"a", "bb", "ccc"})
+	if err != nil {
+		t.Fatalf("failed to embed: %v", err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("unexpected batches: %v", batches)
+	}
+
+	if len(vectors) != 3 || vectors[0][0] != 1 || vectors[1][0] != 2 || vectors[2][0] != 3 {
+		t.Fatalf("unexpected vectors: %v", vectors)
+	}
+
+	cfg.APIKey = "wrong"
+
+	if _, err := cfg.embedder(srv.Client()).Embed(context.Background(), []string{"a"}); !errors.Is(err, ErrEmbedFailed) {
+		t.Fatalf("expected error %v, got %v", ErrEmbedFailed, err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// Estimate is an estimate of the web requests that a configured request will make during a transport operation.
+type Estimate struct {
+	// Table is the name of the table/collection that the request stores data in.
+	Table string
+
+	// Endpoint is the endpoint of the request.
+	Endpoint string
+
+	// Requests is the number of web requests, one for each timeseries chunk.
+	Requests int
+
+	// Duration is the minimum wall-clock time needed to make the web requests without exceeding the rate limit.
+	Duration time.Duration
+
+	// RatePerSecond is the sustained number of web requests per second allowed by the rate limit.
+	RatePerSecond float64
+}
+
+// estimateRequest will estimate the web requests of a single request, whose rate limit is shared by requests that
+// spend "shared" tokens in total, including its own: a token is a request, or a credit for a rate limit of credits.
+// The first "burst" tokens are spent immediately, after which the rate limiter refills the burst once per period, so
+// the request is done once every request of the rate limit is done, and it gets its share of the sustained rate.
+func estimateRequest(req *Request, chunks, shared int) *Estimate {
+	estimate := &Estimate{Table: req.Table, Endpoint: req.Endpoint, Requests: chunks}
+
+	period := *req.RateLimitConfig.Period
+	if period <= 0 || shared <= 0 {
+		return estimate
+	}
+
+	// The rate limiter refills one request per period, or the credits once per period.
+	weight, burst, refill := req.weight(), 0, 1
+
+	if credits := req.RateLimitConfig.Credits; credits != nil {
+		burst, refill = *credits, *credits
+	} else {
+		burst = *req.RateLimitConfig.Burst
+	}
+
+	// The rate is shared in proportion to the tokens that each request spends.
+	tokensPerSecond := float64(refill) / period.Seconds()
+	estimate.RatePerSecond = tokensPerSecond / float64(weight) * float64(chunks*weight) / float64(shared)
+
+	if waits := shared - burst; waits > 0 {
+		estimate.Duration = time.Duration(waits) * period / time.Duration(refill)
+	}
+
+	return estimate
+}
+
+// Estimates will estimate the web requests that each request in the configuration will make, without making any of
+// them. Requests are made concurrently, so the wall-clock time of the operation is bounded by the slowest request.
+// Requests with the same rate limit, e.g. the requests that inherit the rate limit of the configuration, share its
+// rate limiter.
+func Estimates(ctx context.Context, cfg *Config) ([]*Estimate, error) {
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to web API: %w", err)
+	}
+
+	var (
+		requests []*Request
+		chunks   []int
+	)
+
+	shared := make(map[*RateLimitConfig]int)
+
+	for _, req := range cfg.Requests {
+		// Requests that read from a SQL source do not make web requests.
+		if req.SQL != nil {
+			continue
+		}
+
+		flatReqs, err := req.flattenTimeseries(*cfg.URL, client)
+		if err != nil {
+			return nil, err
+		}
+
+		requests = append(requests, req)
+		chunks = append(chunks, len(flatReqs))
+		shared[req.RateLimitConfig] += len(flatReqs) * req.weight()
+	}
+
+	estimates := make([]*Estimate, 0, len(requests))
+
+	for idx, req := range requests {
+		estimates = append(estimates, estimateRequest(req, chunks[idx], shared[req.RateLimitConfig]))
+	}
+
+	return estimates, nil
+}
+
+// WriteEstimate will write a dry-run estimate of the transport operation to w: the number of web requests for each
+// request in the configuration, the expected wall-clock time given the rate limits, and the total number of web
+// requests counted against the API's quota.
+func WriteEstimate(ctx context.Context, cfg *Config, w io.Writer) error {
+	estimates, err := Estimates(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	var (
+		total    int
+		duration time.Duration
+		rate     float64
+	)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "TABLE\tENDPOINT\tREQUESTS\tRATE/S\tDURATION\n")
+
+	for _, estimate := range estimates {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%.2f\t%v\n", estimate.Table, estimate.Endpoint, estimate.Requests,
+			estimate.RatePerSecond, estimate.Duration)
+
+		total += estimate.Requests
+		rate += estimate.RatePerSecond
+
+		if estimate.Duration > duration {
+			duration = estimate.Duration
+		}
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write estimate: %w", err)
+	}
+
+	_, err = fmt.Fprintf(w, "\ntotal requests: %d\npeak rate:      %.2f requests/s\nexpected time:  %v\n", total,
+		rate, duration)
+	if err != nil {
+		return fmt.Errorf("unable to write estimate: %w", err)
+	}
+
+	return nil
+}
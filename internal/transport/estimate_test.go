@@ -0,0 +1,174 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimates(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit:
+  burst: 2
+  period: 1s
+requests:
+  - endpoint: /candles
+    query:
+      start: "2022-05-10T00:00:00Z"
+      end: "2022-05-10T00:10:00Z"
+    timeseries:
+      startName: start
+      endName: end
+      period: 60
+  - endpoint: /ticker
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	estimates, err := Estimates(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("error estimating requests: %v", err)
+	}
+
+	// The requests share the rate limit of the configuration, so both are done once its 11 requests are made, 9 more
+	// than the burst, at one request per second.
+	for idx, expected := range []struct {
+		requests int
+		duration time.Duration
+	}{
+		{10, 9 * time.Second},
+		{1, 9 * time.Second},
+	} {
+		if estimates[idx].Requests != expected.requests || estimates[idx].Duration != expected.duration {
+			t.Errorf("estimate %d: expected (%d, %v), got (%d, %v)", idx, expected.requests, expected.duration,
+				estimates[idx].Requests, estimates[idx].Duration)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEstimate(context.Background(), cfg, &buf); err != nil {
+		t.Fatalf("error writing estimate: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "total requests: 11") ||
+		!strings.Contains(buf.String(), "peak rate:      1.00 requests/s") ||
+		!strings.Contains(buf.String(), "expected time:  9s") {
+		t.Fatalf("unexpected estimate:\n%s", buf.String())
+	}
+}
+
+func TestEstimatesCredits(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit:
+  credits: 10
+  period: 1s
+requests:
+  - endpoint: /search
+    weight: 5
+    query:
+      start: "2022-05-10T00:00:00Z"
+      end: "2022-05-10T00:10:00Z"
+    timeseries:
+      startName: start
+      endName: end
+      period: 60
+  - endpoint: /ticker
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	client, err := cfg.connect(context.Background())
+	if err != nil {
+		t.Fatalf("error connecting: %v", err)
+	}
+
+	search, err := cfg.Requests[0].flattenTimeseries(*cfg.URL, client)
+	if err != nil {
+		t.Fatalf("error flattening request: %v", err)
+	}
+
+	ticker, err := cfg.Requests[1].flattenTimeseries(*cfg.URL, client)
+	if err != nil {
+		t.Fatalf("error flattening request: %v", err)
+	}
+
+	// Requests with the same credit rate limit share a single limiter, and spend their weight.
+	if search[0].fetchConfig.RateLimiter != ticker[0].fetchConfig.RateLimiter {
+		t.Fatal("expected the requests to share the credit limiter")
+	}
+
+	if weight := search[0].fetchConfig.Weight; weight != 5 {
+		t.Fatalf("expected weight 5, got %d", weight)
+	}
+
+	estimates, err := Estimates(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("error estimating requests: %v", err)
+	}
+
+	// 10 requests of 5 credits and the ticker's credit spend 51 credits, 41 more than the bucket holds, refilled at 10
+	// credits per second. The search spends 50 of the 51 credits, so it gets 50/51 of the 2 requests per second.
+	if estimates[0].Duration != 4100*time.Millisecond || math.Abs(estimates[0].RatePerSecond-100.0/51) > 1e-9 {
+		t.Fatalf("expected (4.1s, %v), got (%v, %v)", 100.0/51, estimates[0].Duration, estimates[0].RatePerSecond)
+	}
+}
+
+func TestRateLimitWeight(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name   string
+		config string
+		err    error
+	}{
+		{
+			name:   "weight within credits",
+			config: "rateLimit: {credits: 10, period: 1s}\nrequests: [{endpoint: /a, weight: 10}]",
+		},
+		{
+			name:   "weight exceeds credits",
+			config: "rateLimit: {credits: 10, period: 1s}\nrequests: [{endpoint: /a, weight: 11}]",
+			err:    ErrInvalidRateLimit,
+		},
+		{
+			name:   "weight exceeds burst",
+			config: "rateLimit: {burst: 1, period: 1s}\nrequests: [{endpoint: /a, weight: 2}]",
+			err:    ErrInvalidRateLimit,
+		},
+		{
+			name:   "negative weight",
+			config: "rateLimit: {credits: 10, period: 1s}\nrequests: [{endpoint: /a, weight: -1}]",
+			err:    ErrInvalidRateLimit,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte("url: https://api.example.com\n" + tcase.config))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultExecTimeout is the default time that an exec transform can run for each response.
+const defaultExecTimeout = 30 * time.Second
+
+// The policies for responses whose exec transform fails.
+const (
+	ExecOnErrorFail = "fail"
+	ExecOnErrorSkip = "skip"
+	ExecOnErrorKeep = "keep"
+)
+
+var (
+	ErrInvalidExec = fmt.Errorf("invalid exec")
+	ErrExecFailed  = fmt.Errorf("exec transform failed")
+)
+
+// InvalidExecError wraps an error with ErrInvalidExec.
+func InvalidExecError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidExec, reason)
+}
+
+// ExecConfig transforms the records of each response with a subprocess, an escape hatch for custom processing in any
+// language. The records are written to the subprocess' stdin as NDJSON, one JSON record per line, and the records to
+// store are read from its stdout as NDJSON. Its stderr is logged.
+type ExecConfig struct {
+	// Command is the program and its arguments, e.g. ["python3", "transform.py"].
+	Command []string `yaml:"command"`
+
+	// Env are environment variables set for the subprocess, in addition to those of the process.
+	Env map[string]string `yaml:"env"`
+
+	// Timeout is the time that the subprocess can run for each response. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// OnError is the policy for responses whose transform fails or times out: "fail" the chunk, which counts against
+	// the error budget, "skip" the response's records, or "keep" the records as they were fetched. Defaults to "fail".
+	OnError string `yaml:"onError"`
+}
+
+func (ec *ExecConfig) validate() error {
+	if ec == nil {
+		return nil
+	}
+
+	if len(ec.Command) == 0 || ec.Command[0] == "" {
+		return InvalidExecError("command is required")
+	}
+
+	if ec.Timeout < 0 {
+		return InvalidExecError("timeout can not be negative")
+	}
+
+	switch ec.OnError {
+	case "", ExecOnErrorFail, ExecOnErrorSkip, ExecOnErrorKeep:
+	default:
+		return InvalidExecError(fmt.Sprintf("unknown onError %q", ec.OnError))
+	}
+
+	return nil
+}
+
+// timeout will return the time that the subprocess can run for each response.
+func (ec *ExecConfig) timeout() time.Duration {
+	if ec.Timeout > 0 {
+		return ec.Timeout
+	}
+
+	return defaultExecTimeout
+}
+
+// encodeNDJSON will encode JSON encoded records as NDJSON, with the JSON implementation of the data type.
+func encodeNDJSON(dataType tools.UpsertDataType, data []byte) ([]byte, error) {
+	decoded, err := dataType.DecodeNumbers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	var buf bytes.Buffer
+
+	for _, record := range records {
+		line, err := dataType.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeNDJSON will decode NDJSON records into a JSON encoded list of records. Blank lines are ignored.
+func decodeNDJSON(data []byte) ([]byte, error) {
+	records := make([]json.RawMessage, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if !json.Valid(line) {
+			return nil, fmt.Errorf("%w: invalid NDJSON line %q", tools.ErrFailedToUnmarshalJSON, line)
+		}
+
+		records = append(records, append(json.RawMessage(nil), line...))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read NDJSON: %w", err)
+	}
+
+	encoded, err := json.Marshal(records)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return encoded, nil
+}
+
+// run will transform JSON encoded records with the subprocess. The subprocess and the processes it starts are killed
+// when it times out or the context is done, so that a process that keeps its output open can not block the run.
+func (ec *ExecConfig) run(ctx context.Context, logger *logrus.Logger, dataType tools.UpsertDataType, data []byte,
+) ([]byte, error) {
+	input, err := encodeNDJSON(dataType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, ec.timeout())
+	defer cancel()
+
+	//nolint:gosec // The command is configured by the user to transform their records.
+	cmd := exec.Command(ec.Command[0], ec.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Env = os.Environ()
+	setExecGroup(cmd)
+
+	for name, value := range ec.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrExecFailed, ec.Command[0], err)
+	}
+
+	exited := make(chan struct{})
+
+	go func() {
+		select {
+		case <-timeoutCtx.Done():
+			killExec(cmd)
+		case <-exited:
+		}
+	}()
+
+	err = cmd.Wait()
+	close(exited)
+
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		logInfo := tools.LogFormatter{Msg: fmt.Sprintf("exec transform %s: %s", ec.Command[0], msg)}
+		logger.Info(logInfo.String())
+	}
+
+	// The run is only reported as timed out if the timeout of the transform expired, rather than the context of the
+	// run, e.g. when the operation is canceled.
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrExecFailed, ec.Command[0], ctx.Err())
+	}
+
+	if timeoutCtx.Err() != nil {
+		return nil, fmt.Errorf("%w: %s timed out after %v", ErrExecFailed, ec.Command[0], ec.timeout())
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrExecFailed, ec.Command[0], err)
+	}
+
+	output, err := decodeNDJSON(stdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrExecFailed, ec.Command[0], err)
+	}
+
+	return output, nil
+}
+
+// applyExec will transform JSON encoded records with the subprocess, applying the error policy if it fails.
+func applyExec(ctx context.Context, logger *logrus.Logger, ec *ExecConfig, dataType tools.UpsertDataType,
+	data []byte,
+) ([]byte, error) {
+	if ec == nil {
+		return data, nil
+	}
+
+	transformed, err := ec.run(ctx, logger, dataType, data)
+	if err == nil {
+		return transformed, nil
+	}
+
+	switch ec.OnError {
+	case ExecOnErrorSkip:
+		logger.Warn(tools.LogFormatter{Msg: fmt.Sprintf("skipped records: %v", err)}.String())
+
+		return []byte("[]"), nil
+	case ExecOnErrorKeep:
+		logger.Warn(tools.LogFormatter{Msg: fmt.Sprintf("kept untransformed records: %v", err)}.String())
+
+		return data, nil
+	default:
+		return nil, err
+	}
+}
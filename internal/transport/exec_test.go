@@ -0,0 +1,139 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+func TestApplyExec(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`[{"symbol":"btc-usd","size":2},{"symbol":"eth-usd","size":0}]`)
+
+	for _, tcase := range []struct {
+		name     string
+		exec     *ExecConfig
+		expected string
+		err      error
+	}{
+		{
+			name:     "cat",
+			exec:     &ExecConfig{Command: []string{"cat"}},
+			expected: `[{"size":2,"symbol":"btc-usd"},{"size":0,"symbol":"eth-usd"}]`,
+		},
+		{
+			name:     "filter",
+			exec:     &ExecConfig{Command: []string{"grep", "-v", `"size":0`}},
+			expected: `[{"size":2,"symbol":"btc-usd"}]`,
+		},
+		{
+			name: "env",
+			exec: &ExecConfig{
+				Command: []string{"sh", "-c", `sed "s/usd/$QUOTE/"`},
+				Env:     map[string]string{"QUOTE": "eur"},
+			},
+			expected: `[{"size":2,"symbol":"btc-eur"},{"size":0,"symbol":"eth-eur"}]`,
+		},
+		{
+			name: "fail",
+			exec: &ExecConfig{Command: []string{"sh", "-c", "echo broken >&2; exit 1"}},
+			err:  ErrExecFailed,
+		},
+		{
+			name: "invalid output",
+			exec: &ExecConfig{Command: []string{"echo", "not json"}},
+			err:  ErrExecFailed,
+		},
+		{
+			name: "timeout",
+			exec: &ExecConfig{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond},
+			err:  ErrExecFailed,
+		},
+		{
+			name: "timeout with output held by a child",
+			exec: &ExecConfig{Command: []string{"sh", "-c", "sleep 5 & cat"}, Timeout: 50 * time.Millisecond},
+			err:  ErrExecFailed,
+		},
+		{
+			name:     "skip",
+			exec:     &ExecConfig{Command: []string{"false"}, OnError: ExecOnErrorSkip},
+			expected: `[]`,
+		},
+		{
+			name:     "keep",
+			exec:     &ExecConfig{Command: []string{"false"}, OnError: ExecOnErrorKeep},
+			expected: string(data),
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Now()
+
+			transformed, err := applyExec(context.Background(), logrus.New(), tcase.exec, tools.UpsertDataJSON, data)
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if string(transformed) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, transformed)
+			}
+
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Fatalf("expected the transform to be killed, it ran for %v", elapsed)
+			}
+		})
+	}
+}
+
+func TestApplyExecCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	exec := &ExecConfig{Command: []string{"sleep", "5"}}
+
+	_, err := applyExec(ctx, logrus.New(), exec, tools.UpsertDataJSON, []byte(`[]`))
+	if !errors.Is(err, ErrExecFailed) || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("expected a canceled transform, got %v", err)
+	}
+}
+
+func TestExecConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		exec *ExecConfig
+	}{
+		{"no command", &ExecConfig{}},
+		{"negative timeout", &ExecConfig{Command: []string{"cat"}, Timeout: -time.Second}},
+		{"unknown onError", &ExecConfig{Command: []string{"cat"}, OnError: "retry"}},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.exec.validate(); !errors.Is(err, ErrInvalidExec) {
+				t.Fatalf("expected error %v, got %v", ErrInvalidExec, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build !windows
+
+package transport
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setExecGroup will start the subprocess in its own process group, so that the processes it starts are killed with
+// it.
+func setExecGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killExec will kill the process group of the subprocess.
+func killExec(cmd *exec.Cmd) {
+	// The process group has the ID of the subprocess, and a negative ID kills every process of the group.
+	_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
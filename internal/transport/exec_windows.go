@@ -0,0 +1,22 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build windows
+
+package transport
+
+import "os/exec"
+
+// setExecGroup is a no-op, since Windows has no process groups to kill.
+func setExecGroup(*exec.Cmd) {}
+
+// killExec will kill the subprocess. The processes it starts are not killed, and the output of the subprocess is read
+// until they exit if they inherit it.
+func killExec(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
@@ -0,0 +1,186 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+var (
+	ErrInvalidExpand        = fmt.Errorf("invalid expand")
+	ErrInvalidTableTemplate = fmt.Errorf("invalid table template")
+	ErrInvalidParamFields   = fmt.Errorf("invalid param fields")
+)
+
+// tableTemplateFuncs are the functions of table templates.
+var tableTemplateFuncs = template.FuncMap{
+	"lower":   strings.ToLower,
+	"upper":   strings.ToUpper,
+	"replace": func(old, replacement, value string) string { return strings.ReplaceAll(value, old, replacement) },
+}
+
+// InvalidExpandError wraps an error with ErrInvalidExpand.
+func InvalidExpandError(endpoint, reason string) error {
+	return fmt.Errorf("%w of %q: %s", ErrInvalidExpand, endpoint, reason)
+}
+
+// fillPlaceholders will replace the "{name}" placeholders of a string with the values of the params.
+func fillPlaceholders(value string, params map[string]string) string {
+	if !strings.Contains(value, "{") {
+		return value
+	}
+
+	for name, param := range params {
+		value = strings.ReplaceAll(value, "{"+name+"}", param)
+	}
+
+	return value
+}
+
+// expandTable will return the table of the request with its "{name}" placeholders filled in, or, if it is a Go
+// template, e.g. "candles_{{.symbol | lower}}", executed on the params.
+func (req *Request) expandTable() (string, error) {
+	if !strings.Contains(req.Table, "{{") {
+		return fillPlaceholders(req.Table, req.Params), nil
+	}
+
+	tmpl, err := template.New("table").Funcs(tableTemplateFuncs).Option("missingkey=error").Parse(req.Table)
+	if err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidTableTemplate, req.Table, err)
+	}
+
+	var table strings.Builder
+	if err := tmpl.Execute(&table, req.Params); err != nil {
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidTableTemplate, req.Table, err)
+	}
+
+	return table.String(), nil
+}
+
+// validateParamFields will check that the params of the param fields are set.
+func (req *Request) validateParamFields() error {
+	for field, param := range req.ParamFields {
+		if _, ok := req.Params[param]; !ok {
+			return fmt.Errorf("%w: param %q of field %q is not set for %q", ErrInvalidParamFields, param, field,
+				req.Endpoint)
+		}
+	}
+
+	return nil
+}
+
+// paramFields will return the values of the param fields of the request, which are set on every record.
+func (req *Request) paramFields() map[string]interface{} {
+	if len(req.ParamFields) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]interface{}, len(req.ParamFields))
+	for field, param := range req.ParamFields {
+		fields[field] = req.Params[param]
+	}
+
+	return fields
+}
+
+// expand will return a copy of the request for each combination of the values of its expanded params, e.g. for each
+// symbol and granularity, with the "{name}" placeholders of the query and table filled in. The combinations are
+// ordered by the sorted names of the params, and then by the order of their values.
+func (req *Request) expand() ([]*Request, error) {
+	if len(req.Expand) == 0 {
+		return []*Request{req}, nil
+	}
+
+	names := make([]string, 0, len(req.Expand))
+
+	for name, values := range req.Expand {
+		if len(values) == 0 {
+			return nil, InvalidExpandError(req.Endpoint, fmt.Sprintf("%q has no values", name))
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	combinations := []map[string]string{{}}
+
+	for _, name := range names {
+		next := make([]map[string]string, 0, len(combinations)*len(req.Expand[name]))
+
+		for _, combination := range combinations {
+			for _, value := range req.Expand[name] {
+				params := make(map[string]string, len(combination)+1)
+				for key, param := range combination {
+					params[key] = param
+				}
+
+				params[name] = value
+				next = append(next, params)
+			}
+		}
+
+		combinations = next
+	}
+
+	expanded := make([]*Request, 0, len(combinations))
+	for _, combination := range combinations {
+		expanded = append(expanded, req.withParams(combination))
+	}
+
+	return expanded, nil
+}
+
+// withParams will return a copy of the request with the expanded params, leaving the request intact.
+func (req *Request) withParams(expandParams map[string]string) *Request {
+	expandedReq := *req
+	expandedReq.Expand = nil
+
+	expandedReq.Params = make(map[string]string, len(req.Params)+len(expandParams))
+	for key, value := range req.Params {
+		expandedReq.Params[key] = value
+	}
+
+	for key, value := range expandParams {
+		expandedReq.Params[key] = value
+	}
+
+	expandedReq.Query = make(map[string]string, len(req.Query))
+	for key, value := range req.Query {
+		expandedReq.Query[key] = fillPlaceholders(value, expandParams)
+	}
+
+	// The chunks of a timeseries are set for each request.
+	if req.Timeseries != nil {
+		timeseries := *req.Timeseries
+		expandedReq.Timeseries = &timeseries
+	}
+
+	return &expandedReq
+}
+
+// expandRequests will replace the requests with expanded params by their expansions.
+func (cfg *Config) expandRequests() error {
+	requests := make([]*Request, 0, len(cfg.Requests))
+
+	for _, req := range cfg.Requests {
+		expanded, err := req.expand()
+		if err != nil {
+			return err
+		}
+
+		requests = append(requests, expanded...)
+	}
+
+	cfg.Requests = requests
+
+	return nil
+}
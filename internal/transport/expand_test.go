@@ -0,0 +1,177 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestExpandRequests(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /products/{symbol}/candles
+    table: candles_{granularity}
+    expand:
+      symbol: [BTC-USD, ETH-USD]
+      granularity: ["60", "300"]
+    query:
+      granularity: "{granularity}"
+      start: "2022-05-10T00:00:00Z"
+      end: "2022-05-10T00:10:00Z"
+    timeseries:
+      startName: start
+      endName: end
+      period: 600
+      truncateColumn: time
+  - endpoint: /ticker/{symbol}
+    params: {symbol: BTC-USD}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	expected := []struct{ table, symbol, granularity string }{
+		{"candles_60", "BTC-USD", "60"},
+		{"candles_60", "ETH-USD", "60"},
+		{"candles_300", "BTC-USD", "300"},
+		{"candles_300", "ETH-USD", "300"},
+		{"BTC-USD", "BTC-USD", ""},
+	}
+
+	if len(cfg.Requests) != len(expected) {
+		t.Fatalf("expected %d requests, got %d", len(expected), len(cfg.Requests))
+	}
+
+	for idx, req := range cfg.Requests {
+		want := expected[idx]
+		if req.Table != want.table || req.Params["symbol"] != want.symbol || req.Query["granularity"] != want.granularity {
+			t.Fatalf("expected request %v, got table %q, params %v, query %v", want, req.Table, req.Params, req.Query)
+		}
+	}
+
+	if cfg.Requests[0].Timeseries == cfg.Requests[1].Timeseries {
+		t.Fatal("expected the expanded requests to have their own timeseries")
+	}
+
+	flatReqs, err := cfg.flattenRequests(context.Background())
+	if err != nil {
+		t.Fatalf("error flattening requests: %v", err)
+	}
+
+	if path := flatReqs[1].fetchConfig.URL.Path; path != "/products/ETH-USD/candles" {
+		t.Fatalf("expected the expanded endpoint, got %q", path)
+	}
+
+	// The requests that share a table delete each window once.
+	ranges, err := cfg.truncateRanges()
+	if err != nil {
+		t.Fatalf("error getting truncate ranges: %v", err)
+	}
+
+	if len(ranges) != 2 {
+		t.Fatalf("expected 2 truncate ranges, got %d", len(ranges))
+	}
+}
+
+func TestExpandRequestsInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /products/{symbol}/candles
+    expand: {symbol: []}
+`))
+	if !errors.Is(err, ErrInvalidExpand) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidExpand, err)
+	}
+}
+
+func TestTableTemplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name  string
+		table string
+		want  string
+		err   error
+	}{
+		{name: "placeholder", table: "candles_{symbol}", want: "candles_BTC-USD"},
+		{name: "template", table: "candles_{{.symbol | lower}}", want: "candles_btc-usd"},
+		{name: "replace", table: `candles_{{.symbol | lower | replace "-" "_"}}`, want: "candles_btc_usd"},
+		{name: "missing param", table: "candles_{{.granularity}}", err: ErrInvalidTableTemplate},
+		{name: "invalid template", table: "candles_{{.symbol", err: ErrInvalidTableTemplate},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := &Request{Table: tcase.table, Params: map[string]string{"symbol": "BTC-USD"}}
+
+			table, err := req.expandTable()
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if table != tcase.want {
+				t.Fatalf("expected table %q, got %q", tcase.want, table)
+			}
+		})
+	}
+}
+
+func TestParamFields(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /products/{symbol}/trades
+    table: trades
+    expand: {symbol: [BTC-USD, ETH-USD]}
+    paramFields: {product_id: symbol}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	job := &repoJob{
+		b:              []byte(`[{"price":"1"}]`),
+		table:          "trades",
+		storageOptions: cfg.Requests[1].storageOptions(),
+	}
+
+	reqs, err := job.upsertRequests("postgresql")
+	if err != nil {
+		t.Fatalf("error getting upsert requests: %v", err)
+	}
+
+	if data := string(reqs[0].Data); data != `[{"price":"1","product_id":"ETH-USD"}]` {
+		t.Fatalf("expected the param field on the records, got %s", data)
+	}
+
+	_, err = NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+    paramFields: {product_id: symbol}
+`))
+	if !errors.Is(err, ErrInvalidParamFields) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidParamFields, err)
+	}
+}
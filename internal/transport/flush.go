@@ -0,0 +1,229 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrInvalidFlush = fmt.Errorf("invalid flush policy")
+
+// InvalidFlushError wraps an error with ErrInvalidFlush.
+func InvalidFlushError(scheme, reason string) error {
+	return fmt.Errorf("%w for %q: %s", ErrInvalidFlush, scheme, reason)
+}
+
+// FlushPolicy commits the records upserted to a storage device every "Records" records or every "Interval", whichever
+// comes first, instead of once at the end of the run. This bounds the latency of the records of long-running
+// streaming sources and the size of their transactions, at the cost of more commits. A failed run only rolls back the
+// records upserted since the last commit.
+type FlushPolicy struct {
+	// Records is the number of upserted records after which they are committed.
+	Records int64 `yaml:"records"`
+
+	// Interval is the time after which the upserted records are committed.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (fp *FlushPolicy) validate(scheme string) error {
+	if fp == nil {
+		return nil
+	}
+
+	if !isStorageScheme(scheme) {
+		return InvalidFlushError(scheme, "unknown storage scheme")
+	}
+
+	if fp.Records < 0 || fp.Interval < 0 {
+		return InvalidFlushError(scheme, "records and interval can not be negative")
+	}
+
+	if fp.Records == 0 && fp.Interval == 0 {
+		return InvalidFlushError(scheme, "records or interval is required")
+	}
+
+	return nil
+}
+
+// isStorageScheme will return true if the scheme is that of a type of storage device.
+func isStorageScheme(scheme string) bool {
+	for stype := storage.MongoType; stype <= storage.ParquetType; stype++ {
+		if storage.Scheme(stype) == scheme {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flusher commits the transaction of a repository by its flush policy while the repository workers send upserts to
+// it. The transaction is replaced when it is committed, so the upserts are sent while holding the read lock and the
+// transaction is committed while holding the write lock. A nil flusher sends upserts to the repository directly.
+type flusher struct {
+	policy *FlushPolicy
+	repo   repository.Generic
+	logger *logrus.Logger
+
+	mu sync.RWMutex
+
+	// pending is the number of records upserted since the last commit.
+	pending int64
+
+	// due is signaled when the pending records reach the records of the policy.
+	due  chan struct{}
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newFlushers will return the flushers of the repositories, by the flush policy of their storage schemes. Repositories
+// without a flush policy have a nil flusher.
+func (cfg *Config) newFlushers(repos []repository.Generic) []*flusher {
+	if len(cfg.Flush) == 0 {
+		return nil
+	}
+
+	flushers := make([]*flusher, len(repos))
+
+	for idx, repo := range repos {
+		if policy := cfg.Flush[storage.Scheme(repo.Type())]; policy != nil {
+			flushers[idx] = &flusher{
+				policy: policy,
+				repo:   repo,
+				logger: cfg.Logger,
+				due:    make(chan struct{}, 1),
+				stop:   make(chan struct{}),
+			}
+		}
+	}
+
+	return flushers
+}
+
+// transact will send a transaction function to the repository.
+func (fl *flusher) transact(repo repository.Generic, txfn func(context.Context, repository.Generic) error) {
+	if fl == nil {
+		repo.Transact(txfn)
+
+		return
+	}
+
+	fl.mu.RLock()
+	defer fl.mu.RUnlock()
+
+	fl.repo.Transact(txfn)
+}
+
+// upserted will count the records of an upsert that ran on the transaction, signaling the flusher once the records of
+// the policy are pending. It is called by the storage device as it runs the transaction functions, so it must not
+// block.
+func (fl *flusher) upserted(records int64) {
+	if fl == nil {
+		return
+	}
+
+	if pending := atomic.AddInt64(&fl.pending, records); fl.policy.Records > 0 && pending >= fl.policy.Records {
+		select {
+		case fl.due <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run will commit the transaction whenever the records of the policy are pending, and at every interval, until the
+// flusher is stopped.
+func (fl *flusher) run(ctx context.Context) {
+	if fl == nil {
+		return
+	}
+
+	var tick <-chan time.Time
+
+	if fl.policy.Interval > 0 {
+		ticker := time.NewTicker(fl.policy.Interval)
+		defer ticker.Stop()
+
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-fl.stop:
+			return
+		case <-fl.due:
+		case <-tick:
+		}
+
+		fl.checkpoint(ctx)
+	}
+}
+
+// checkpoint will commit the upserts sent so far. If the commit fails, the error is sent to the new transaction, so
+// that the run fails when it is committed.
+func (fl *flusher) checkpoint(ctx context.Context) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	// Intervals without upserts do not commit.
+	records := atomic.SwapInt64(&fl.pending, 0)
+	if records == 0 {
+		return
+	}
+
+	start := time.Now()
+
+	if err := fl.repo.Checkpoint(ctx); err != nil {
+		fl.repo.Transact(func(context.Context, repository.Generic) error { return err })
+
+		return
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg:      fmt.Sprintf("flushed %d records to %q", records, storage.Scheme(fl.repo.Type())),
+	}
+	fl.logger.Info(logInfo.String())
+}
+
+// startFlushers will start the flushers of a run.
+func startFlushers(ctx context.Context, flushers []*flusher) {
+	for _, fl := range flushers {
+		if fl == nil {
+			continue
+		}
+
+		fl.wg.Add(1)
+
+		go func(fl *flusher) {
+			defer fl.wg.Done()
+
+			fl.run(ctx)
+		}(fl)
+	}
+}
+
+// stopFlushers will stop the flushers of a run, waiting for a commit in progress. The remaining upserts are committed
+// or rolled back with the run.
+func stopFlushers(flushers []*flusher) {
+	for _, fl := range flushers {
+		if fl == nil {
+			continue
+		}
+
+		close(fl.stop)
+		fl.wg.Wait()
+	}
+}
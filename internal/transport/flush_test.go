@@ -0,0 +1,149 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// checkpointRepository is a repository that runs the functions sent to it right away and counts its checkpoints.
+type checkpointRepository struct {
+	repository.Generic
+	checkpoints int32
+}
+
+func (repo *checkpointRepository) Transact(fn func(context.Context, repository.Generic) error) {
+	_ = fn(context.Background(), repo)
+}
+
+func (repo *checkpointRepository) Checkpoint(context.Context) error {
+	atomic.AddInt32(&repo.checkpoints, 1)
+
+	return nil
+}
+
+func (repo *checkpointRepository) Type() uint8 { return storage.PostgresType }
+
+func TestFlusher(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	waitCheckpoints := func(t *testing.T, repo *checkpointRepository, expected int32) {
+		t.Helper()
+
+		for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&repo.checkpoints) < expected; {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d checkpoints, got %d", expected, atomic.LoadInt32(&repo.checkpoints))
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("records", func(t *testing.T) {
+		t.Parallel()
+
+		repo := new(checkpointRepository)
+		cfg := &Config{Logger: logger, Flush: map[string]*FlushPolicy{"postgresql": {Records: 3}}}
+		flushers := cfg.newFlushers([]repository.Generic{repo})
+
+		startFlushers(context.Background(), flushers)
+
+		upsert := func(context.Context, repository.Generic) error {
+			flushers[0].upserted(2)
+
+			return nil
+		}
+
+		flushers[0].transact(repo, upsert)
+
+		time.Sleep(20 * time.Millisecond)
+
+		if checkpoints := atomic.LoadInt32(&repo.checkpoints); checkpoints != 0 {
+			t.Fatalf("expected no checkpoint below the records of the policy, got %d", checkpoints)
+		}
+
+		flushers[0].transact(repo, upsert)
+		waitCheckpoints(t, repo, 1)
+
+		stopFlushers(flushers)
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		t.Parallel()
+
+		repo := new(checkpointRepository)
+		cfg := &Config{Logger: logger, Flush: map[string]*FlushPolicy{"postgresql": {Interval: 10 * time.Millisecond}}}
+		flushers := cfg.newFlushers([]repository.Generic{repo})
+
+		startFlushers(context.Background(), flushers)
+
+		// Intervals without upserts do not commit.
+		time.Sleep(50 * time.Millisecond)
+
+		if checkpoints := atomic.LoadInt32(&repo.checkpoints); checkpoints != 0 {
+			t.Fatalf("expected no checkpoint without upserts, got %d", checkpoints)
+		}
+
+		flushers[0].transact(repo, func(context.Context, repository.Generic) error {
+			flushers[0].upserted(1)
+
+			return nil
+		})
+		waitCheckpoints(t, repo, 1)
+
+		stopFlushers(flushers)
+	})
+
+	t.Run("other schemes are not flushed", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Logger: logger, Flush: map[string]*FlushPolicy{"mongodb": {Records: 1}}}
+		if flushers := cfg.newFlushers([]repository.Generic{new(checkpointRepository)}); flushers[0] != nil {
+			t.Fatal("expected no flusher for a repository without a flush policy")
+		}
+	})
+}
+
+func TestFlushPolicyValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name      string
+		scheme    string
+		policy    *FlushPolicy
+		expectErr error
+	}{
+		{name: "records", scheme: "postgresql", policy: &FlushPolicy{Records: 1000}},
+		{name: "interval", scheme: "mongodb", policy: &FlushPolicy{Interval: time.Second}},
+		{name: "unknown scheme", scheme: "postgres", policy: &FlushPolicy{Records: 1}, expectErr: ErrInvalidFlush},
+		{name: "empty", scheme: "postgresql", policy: &FlushPolicy{}, expectErr: ErrInvalidFlush},
+		{name: "negative", scheme: "postgresql", policy: &FlushPolicy{Records: -1}, expectErr: ErrInvalidFlush},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.policy.validate(tcase.scheme); !errors.Is(err, tcase.expectErr) {
+				t.Fatalf("expected error %v, got %v", tcase.expectErr, err)
+			}
+		})
+	}
+}
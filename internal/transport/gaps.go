@@ -0,0 +1,296 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var ErrInvalidGaps = fmt.Errorf("invalid gaps configuration")
+
+// GapConfig is the expected granularity of a timeseries table, used to find the intervals of the timeseries range that
+// have no records, e.g. candles that were silently dropped by the web API, so that they can be fetched again.
+type GapConfig struct {
+	// Column is the time column of the table. Defaults to the timeseries' "TruncateColumn".
+	Column string `yaml:"column"`
+
+	// Granularity is the interval between records, e.g. "1m" for one minute candles. Every interval of the range is
+	// expected to hold at least one record.
+	Granularity *time.Duration `yaml:"granularity"`
+}
+
+func (gc *GapConfig) validate(ts *timeseries) error {
+	if gc == nil {
+		return nil
+	}
+
+	if gc.Granularity == nil || *gc.Granularity <= 0 {
+		return fmt.Errorf("%w: granularity must be positive", ErrInvalidGaps)
+	}
+
+	if gc.column(ts) == "" {
+		return MissingConfigFieldError("timeseries.gaps.column")
+	}
+
+	return nil
+}
+
+// column will return the time column of the table.
+func (gc *GapConfig) column(ts *timeseries) string {
+	if gc.Column != "" {
+		return gc.Column
+	}
+
+	return ts.TruncateColumn
+}
+
+// Gap is an interval of a timeseries table without records.
+type Gap struct {
+	// Table is the table/collection with the gap.
+	Table string
+
+	// Start is the inclusive start of the gap.
+	Start time.Time
+
+	// End is the exclusive end of the gap.
+	End time.Time
+
+	// Intervals is the number of intervals of the expected granularity within the gap.
+	Intervals int
+
+	// req is the request that fills the gap.
+	req *Request
+}
+
+// gapRequest is the state of the gap detection of a request.
+type gapRequest struct {
+	req        *Request
+	start, end time.Time
+
+	// missing are the buckets of the range without records in any of the storage devices.
+	missing []bool
+}
+
+// bucketsRequest will return the storage request for the buckets of the range that hold records.
+func (gr *gapRequest) bucketsRequest() *storage.BucketsRequest {
+	return &storage.BucketsRequest{
+		Table:       gr.req.Table,
+		Column:      gr.req.Timeseries.Gaps.column(gr.req.Timeseries),
+		Start:       gr.start,
+		End:         gr.end,
+		Granularity: *gr.req.Timeseries.Gaps.Granularity,
+		Database:    gr.req.Database,
+	}
+}
+
+// mark will mark the buckets that are not present as missing.
+func (gr *gapRequest) mark(present []int64) {
+	found := make([]bool, len(gr.missing))
+
+	for _, bucket := range present {
+		if bucket >= 0 && bucket < int64(len(found)) {
+			found[bucket] = true
+		}
+	}
+
+	for bucket, ok := range found {
+		if !ok {
+			gr.missing[bucket] = true
+		}
+	}
+}
+
+// gaps will merge the consecutive missing buckets into gaps, the last of which ends with the range.
+func (gr *gapRequest) gaps() []*Gap {
+	granularity := *gr.req.Timeseries.Gaps.Granularity
+
+	var gaps []*Gap
+
+	for bucket := 0; bucket < len(gr.missing); bucket++ {
+		if !gr.missing[bucket] {
+			continue
+		}
+
+		first := bucket
+		start := gr.start.Add(time.Duration(bucket) * granularity)
+
+		for bucket < len(gr.missing) && gr.missing[bucket] {
+			bucket++
+		}
+
+		end := gr.start.Add(time.Duration(bucket) * granularity)
+		if end.After(gr.end) {
+			end = gr.end
+		}
+
+		gaps = append(gaps, &Gap{Table: gr.req.Table, Start: start, End: end, Intervals: bucket - first, req: gr.req})
+	}
+
+	return gaps
+}
+
+// gapRequests will return the gap detection state of the timeseries requests with an expected granularity.
+func (cfg *Config) gapRequests() ([]*gapRequest, error) {
+	var gapReqs []*gapRequest
+
+	for _, req := range cfg.Requests {
+		if req.Timeseries == nil || req.Timeseries.Gaps == nil {
+			continue
+		}
+
+		start, end, err := req.timeseriesWindow(*cfg.URL)
+		if err != nil {
+			return nil, err
+		}
+
+		if !start.Before(end) {
+			continue
+		}
+
+		granularity := *req.Timeseries.Gaps.Granularity
+		buckets := (end.Sub(start) + granularity - 1) / granularity
+
+		gapReqs = append(gapReqs, &gapRequest{req: req, start: start, end: end, missing: make([]bool, buckets)})
+	}
+
+	return gapReqs, nil
+}
+
+// DetectGaps will scan the timeseries tables of the requests with an expected granularity for intervals of their
+// ranges without records. An interval that is missing from any of the storage devices is a gap. Storage devices that
+// can not list the buckets of a table are skipped. Nothing is written to storage.
+func DetectGaps(ctx context.Context, cfg *Config) ([]*Gap, error) {
+	gapReqs, err := cfg.gapRequests()
+	if err != nil || len(gapReqs) == 0 {
+		return nil, err
+	}
+
+	repos, closeRepos, err := cfg.repos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeRepos()
+
+	// The transactions of the storage devices run concurrently.
+	var mu sync.Mutex
+
+	for _, repo := range repos {
+		repo.Transact(func(sctx context.Context, repo repository.Generic) error {
+			for _, gapReq := range gapReqs {
+				present, err := repo.Buckets(sctx, gapReq.bucketsRequest())
+				if errors.Is(err, storage.ErrBucketsNotSupported) {
+					logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped gap detection: %v", err)}
+					cfg.Logger.Warn(logWarn.String())
+
+					return nil
+				}
+
+				if err != nil {
+					return fmt.Errorf("unable to detect gaps of %q: %w", gapReq.req.Table, err)
+				}
+
+				mu.Lock()
+				gapReq.mark(present)
+				mu.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	// Nothing is written, so the transactions are rolled back.
+	for _, repo := range repos {
+		if rerr := repo.Rollback(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var gaps []*Gap
+	for _, gapReq := range gapReqs {
+		gaps = append(gaps, gapReq.gaps()...)
+	}
+
+	return gaps, nil
+}
+
+// FillGaps will fetch the data of the gaps from the web API and upsert it, as a single transport operation.
+func FillGaps(ctx context.Context, cfg *Config, gaps []*Gap) error {
+	if len(gaps) == 0 {
+		return nil
+	}
+
+	if err := cfg.validateWASMRuntime(); err != nil {
+		return err
+	}
+
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to web API: %w", err)
+	}
+
+	var flattenedRequests []*flattenedRequest
+
+	for _, gap := range gaps {
+		req := gap.req.withRange(gap.Start, gap.End)
+
+		flatReqs, err := req.flattenTimeseries(*cfg.URL, client)
+		if err != nil {
+			return err
+		}
+
+		budget := newErrorBudget(req.ErrorBudget, len(flatReqs))
+		for _, flatReq := range flatReqs {
+			flatReq.budget = budget
+			flatReq.noCache = cfg.NoCache || req.NoCache
+		}
+
+		flattenedRequests = append(flattenedRequests, flatReqs...)
+
+		logInfo := tools.LogFormatter{Msg: fmt.Sprintf("filling gap of %q from %v to %v", gap.Table, gap.Start, gap.End)}
+		cfg.Logger.Info(logInfo.String())
+	}
+
+	return upsertFlattenedRequests(ctx, cfg, flattenedRequests, cfg.runID(), nil)
+}
+
+// WriteGaps will write a table of the gaps to w.
+func WriteGaps(w io.Writer, gaps []*Gap) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "TABLE\tSTART\tEND\tINTERVALS\n")
+
+	for _, gap := range gaps {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", gap.Table, gap.Start.Format(time.RFC3339), gap.End.Format(time.RFC3339),
+			gap.Intervals)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write gaps: %w", err)
+	}
+
+	_, err := fmt.Fprintf(w, "\ntotal gaps: %d\n", len(gaps))
+	if err != nil {
+		return fmt.Errorf("unable to write gaps: %w", err)
+	}
+
+	return nil
+}
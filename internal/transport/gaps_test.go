@@ -0,0 +1,126 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGaps(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /candles
+    table: candles
+    query:
+      start: "2022-05-10T00:00:00Z"
+      end: "2022-05-10T00:09:30Z"
+    timeseries:
+      startName: start
+      endName: end
+      period: 300
+      truncateColumn: time
+      gaps:
+        granularity: 1m
+  - endpoint: /ticker
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	gapReqs, err := cfg.gapRequests()
+	if err != nil {
+		t.Fatalf("error getting gap requests: %v", err)
+	}
+
+	if len(gapReqs) != 1 || len(gapReqs[0].missing) != 10 {
+		t.Fatalf("expected 1 gap request with 10 buckets, got %d", len(gapReqs))
+	}
+
+	if column := gapReqs[0].bucketsRequest().Column; column != "time" {
+		t.Fatalf("expected the truncate column, got %q", column)
+	}
+
+	// A bucket that is missing from either storage device is a gap.
+	gapReqs[0].mark([]int64{0, 1, 2, 4, 5, 6, 7, 8})
+	gapReqs[0].mark([]int64{0, 1, 2, 3, 4, 6, 7, 8, 42})
+
+	gaps := gapReqs[0].gaps()
+
+	start := time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC)
+	expected := []*Gap{
+		{Table: "candles", Start: start.Add(3 * time.Minute), End: start.Add(4 * time.Minute), Intervals: 1},
+		{Table: "candles", Start: start.Add(5 * time.Minute), End: start.Add(6 * time.Minute), Intervals: 1},
+		{Table: "candles", Start: start.Add(9 * time.Minute), End: start.Add(9*time.Minute + 30*time.Second), Intervals: 1},
+	}
+
+	if len(gaps) != len(expected) {
+		t.Fatalf("expected %d gaps, got %d", len(expected), len(gaps))
+	}
+
+	for idx, gap := range gaps {
+		want := expected[idx]
+		if gap.Table != want.Table || !gap.Start.Equal(want.Start) || !gap.End.Equal(want.End) ||
+			gap.Intervals != want.Intervals {
+			t.Fatalf("expected gap %v-%v, got %v-%v", want.Start, want.End, gap.Start, gap.End)
+		}
+	}
+
+	// The gap is filled by a copy of the request for its range.
+	req := gaps[0].req.withRange(gaps[0].Start, gaps[0].End)
+	if req.Query["start"] != "2022-05-10T00:03:00Z" || req.Query["end"] != "2022-05-10T00:04:00Z" {
+		t.Fatalf("unexpected gap range: %v", req.Query)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteGaps(&buf, gaps); err != nil {
+		t.Fatalf("error writing gaps: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "total gaps: 3") {
+		t.Fatalf("unexpected gaps output: %s", buf.String())
+	}
+}
+
+func TestGapConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		gaps string
+		err  error
+	}{
+		{"valid", "{column: time, granularity: 1m}", nil},
+		{"missing granularity", "{column: time}", ErrInvalidGaps},
+		{"missing column", "{granularity: 1m}", ErrMissingConfigField},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /candles
+    timeseries: {startName: start, endName: end, period: 60, gaps: ` + tcase.gaps + `}
+`))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
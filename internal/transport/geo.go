@@ -0,0 +1,163 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var (
+	ErrInvalidGeoField = fmt.Errorf("invalid geo field")
+	ErrInvalidGeometry = fmt.Errorf("invalid geometry")
+)
+
+// InvalidGeoFieldError wraps an error with ErrInvalidGeoField.
+func InvalidGeoFieldError(field, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidGeoField, field, reason)
+}
+
+// InvalidGeometryError wraps an error with ErrInvalidGeometry.
+func InvalidGeometryError(field string, value interface{}) error {
+	return fmt.Errorf("%w for %q: %v", ErrInvalidGeometry, field, value)
+}
+
+// GeoField maps a location in the records of a table to a native geospatial type: a PostGIS geometry on Postgres and
+// a GeoJSON object with a "2dsphere" index on MongoDB. The location is either a GeoJSON geometry or a pair of
+// latitude and longitude fields.
+type GeoField struct {
+	// Field is the field that holds the geometry. If no latitude and longitude are set, the field must already hold a
+	// GeoJSON geometry, either as an object or as a string.
+	Field string `yaml:"field"`
+
+	// Latitude and Longitude are the fields of the coordinates of a point, which is stored in the field as a GeoJSON
+	// point. The coordinate fields are left as they are.
+	Latitude  string `yaml:"latitude"`
+	Longitude string `yaml:"longitude"`
+}
+
+func (gf *GeoField) validate() error {
+	if gf.Field == "" {
+		return InvalidGeoFieldError(gf.Latitude, "field is required")
+	}
+
+	if (gf.Latitude == "") != (gf.Longitude == "") {
+		return InvalidGeoFieldError(gf.Field, "latitude and longitude must be set together")
+	}
+
+	return nil
+}
+
+// geometry will return the GeoJSON geometry of a record, or nil if the record has no location.
+func (gf *GeoField) geometry(rec map[string]interface{}) (interface{}, error) {
+	if gf.Latitude == "" {
+		switch value := rec[gf.Field].(type) {
+		case nil:
+			return nil, nil
+		case map[string]interface{}:
+			if _, ok := value["type"].(string); !ok {
+				return nil, InvalidGeometryError(gf.Field, value)
+			}
+
+			return value, nil
+		case string:
+			var geometry map[string]interface{}
+			if err := json.Unmarshal([]byte(value), &geometry); err != nil {
+				return nil, InvalidGeometryError(gf.Field, value)
+			}
+
+			if _, ok := geometry["type"].(string); !ok {
+				return nil, InvalidGeometryError(gf.Field, value)
+			}
+
+			return geometry, nil
+		default:
+			return nil, InvalidGeometryError(gf.Field, value)
+		}
+	}
+
+	if rec[gf.Latitude] == nil || rec[gf.Longitude] == nil {
+		return nil, nil
+	}
+
+	lat, err := geoCoordinate(rec[gf.Latitude])
+	if err != nil {
+		return nil, InvalidGeometryError(gf.Latitude, rec[gf.Latitude])
+	}
+
+	lon, err := geoCoordinate(rec[gf.Longitude])
+	if err != nil {
+		return nil, InvalidGeometryError(gf.Longitude, rec[gf.Longitude])
+	}
+
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil, InvalidGeometryError(gf.Field, fmt.Sprintf("(%v, %v)", lat, lon))
+	}
+
+	// GeoJSON coordinates are in longitude, latitude order.
+	return map[string]interface{}{"type": "Point", "coordinates": []interface{}{lon, lat}}, nil
+}
+
+// geoCoordinate will parse a coordinate, which some web APIs encode as a string.
+func geoCoordinate(value interface{}) (float64, error) {
+	switch coord := value.(type) {
+	case json.Number:
+		return coord.Float64()
+	case string:
+		return strconv.ParseFloat(coord, 64)
+	default:
+		return 0, fmt.Errorf("%w: %T", tools.ErrUnsupportedDataType, value)
+	}
+}
+
+// applyGeoFields will set the GeoJSON geometries of the geo fields on every record of JSON encoded upsert data.
+func applyGeoFields(fields []*GeoField, data []byte) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, field := range fields {
+			geometry, err := field.geometry(rec)
+			if err != nil {
+				return nil, err
+			}
+
+			rec[field.Field] = geometry
+		}
+	}
+
+	geoData, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return geoData, nil
+}
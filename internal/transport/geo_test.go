@@ -0,0 +1,91 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyGeoFields(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		fields   []*GeoField
+		data     string
+		expected string
+		err      error
+	}{
+		{
+			name:   "coordinates",
+			fields: []*GeoField{{Field: "location", Latitude: "lat", Longitude: "lon"}},
+			data:   `[{"lat":"40.7","lon":-74.0},{"lat":null,"lon":1}]`,
+			expected: `[{"lat":"40.7","location":{"coordinates":[-74,40.7],"type":"Point"},"lon":-74.0},` +
+				`{"lat":null,"location":null,"lon":1}]`,
+		},
+		{
+			name:     "geojson string",
+			fields:   []*GeoField{{Field: "area"}},
+			data:     `{"area":"{\"type\":\"Point\",\"coordinates\":[1,2]}"}`,
+			expected: `{"area":{"coordinates":[1,2],"type":"Point"}}`,
+		},
+		{
+			name:   "out of range",
+			fields: []*GeoField{{Field: "location", Latitude: "lat", Longitude: "lon"}},
+			data:   `[{"lat":91,"lon":0}]`,
+			err:    ErrInvalidGeometry,
+		},
+		{
+			name:   "not geojson",
+			fields: []*GeoField{{Field: "area"}},
+			data:   `[{"area":{"coordinates":[1,2]}}]`,
+			err:    ErrInvalidGeometry,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := applyGeoFields(tcase.fields, []byte(tcase.data))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err != nil {
+				return
+			}
+
+			var got, expected interface{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("failed to decode data: %v", err)
+			}
+
+			if err := json.Unmarshal([]byte(tcase.expected), &expected); err != nil {
+				t.Fatalf("failed to decode expected data: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatalf("expected %s, got %s", tcase.expected, data)
+			}
+		})
+	}
+}
+
+func TestGeoFieldValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, field := range []*GeoField{{Latitude: "lat", Longitude: "lon"}, {Field: "location", Latitude: "lat"}} {
+		if err := field.validate(); !errors.Is(err, ErrInvalidGeoField) {
+			t.Fatalf("expected error %v for %+v, got %v", ErrInvalidGeoField, field, err)
+		}
+	}
+}
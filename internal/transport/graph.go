@@ -0,0 +1,95 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+var ErrInvalidGraph = fmt.Errorf("invalid graph")
+
+// InvalidGraphError wraps an error with ErrInvalidGraph.
+func InvalidGraphError(label, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidGraph, label, reason)
+}
+
+// GraphConfig maps the records of a table to the nodes and relationships of a graph, for graph storage such as Neo4j.
+// Each record is merged into a node on its keys, and relationships are merged from the node to the nodes whose keys
+// are held by the record's fields. Other storage ignores the mapping.
+type GraphConfig struct {
+	// Label is the label of the nodes of the records, which are also labeled with the table. Defaults to the table.
+	Label string `yaml:"label"`
+
+	// Keys are the fields that the nodes are merged on. Defaults to the primary keys of the connection string.
+	Keys []string `yaml:"keys"`
+
+	// Relationships are the relationships from the nodes of the records to other nodes.
+	Relationships []*GraphRelationship `yaml:"relationships"`
+}
+
+// GraphRelationship is a relationship from the node of a record to the nodes whose keys are held by a field of the
+// record, e.g. a "FOLLOWS" relationship to the "User" nodes of the IDs in a "following" field. The other nodes are
+// created if they do not exist.
+type GraphRelationship struct {
+	// Type is the type of the relationship, e.g. "FOLLOWS".
+	Type string `yaml:"type"`
+
+	// Field is the field of the record that holds the key, or an array of keys, of the other nodes.
+	Field string `yaml:"field"`
+
+	// Label is the label of the other nodes.
+	Label string `yaml:"label"`
+
+	// Key is the property of the other nodes that they are merged on. Defaults to "id".
+	Key string `yaml:"key"`
+
+	// Incoming directs the relationship from the other nodes to the node of the record.
+	Incoming bool `yaml:"incoming"`
+}
+
+func (gc *GraphConfig) validate() error {
+	if gc == nil {
+		return nil
+	}
+
+	for _, rel := range gc.Relationships {
+		if rel.Type == "" || rel.Field == "" || rel.Label == "" {
+			return InvalidGraphError(gc.Label, "relationships require a type, field, and label")
+		}
+	}
+
+	return nil
+}
+
+// proto will return the graph mapping of an upsert request.
+func (gc *GraphConfig) proto() *proto.Graph {
+	if gc == nil {
+		return nil
+	}
+
+	graph := &proto.Graph{Label: gc.Label, Keys: gc.Keys}
+
+	for _, rel := range gc.Relationships {
+		key := rel.Key
+		if key == "" {
+			key = "id"
+		}
+
+		graph.Relationships = append(graph.Relationships, &proto.GraphRelationship{
+			Type:     rel.Type,
+			Field:    rel.Field,
+			Label:    rel.Label,
+			Key:      key,
+			Incoming: rel.Incoming,
+		})
+	}
+
+	return graph
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGraphConfig(t *testing.T) {
+	t.Parallel()
+
+	t.Run("proto", func(t *testing.T) {
+		t.Parallel()
+
+		graph := (&GraphConfig{
+			Label:         "User",
+			Relationships: []*GraphRelationship{{Type: "FOLLOWS", Field: "following", Label: "User"}},
+		}).proto()
+
+		if graph.GetLabel() != "User" || len(graph.GetRelationships()) != 1 {
+			t.Fatalf("unexpected graph: %v", graph)
+		}
+
+		if key := graph.GetRelationships()[0].GetKey(); key != "id" {
+			t.Fatalf("expected the key to default to id, got %q", key)
+		}
+
+		if (*GraphConfig)(nil).proto() != nil {
+			t.Fatalf("expected no graph without a configuration")
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		t.Parallel()
+
+		err := (&GraphConfig{Relationships: []*GraphRelationship{{Type: "FOLLOWS"}}}).validate()
+		if !errors.Is(err, ErrInvalidGraph) {
+			t.Fatalf("expected error %v, got %v", ErrInvalidGraph, err)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// LargeIntegerPolicy is how the integers of the records whose magnitude exceeds 2^53, e.g. snowflake IDs, are decoded.
+type LargeIntegerPolicy string
+
+const (
+	// LargeIntegersString decodes large integers as strings of their exact digits. Postgres parses them into "BIGINT"
+	// and "NUMERIC" columns, and fields that are also decimal fields are stored as "NUMERIC" on Postgres and
+	// "Decimal128" on MongoDB.
+	LargeIntegersString LargeIntegerPolicy = "string"
+
+	// LargeIntegersFloat decodes large integers as floating-point numbers, which rounds them.
+	LargeIntegersFloat LargeIntegerPolicy = "float"
+)
+
+var ErrInvalidLargeIntegers = fmt.Errorf("invalid large integers policy")
+
+// InvalidLargeIntegersError wraps an error with ErrInvalidLargeIntegers.
+func InvalidLargeIntegersError(policy LargeIntegerPolicy) error {
+	return fmt.Errorf("%w %q: must be string or float", ErrInvalidLargeIntegers, policy)
+}
+
+func (policy LargeIntegerPolicy) validate() error {
+	switch policy {
+	case "", LargeIntegersString, LargeIntegersFloat:
+		return nil
+	default:
+		return InvalidLargeIntegersError(policy)
+	}
+}
+
+// applyLargeIntegers will encode the large integers of the records of a JSON encoded response as strings, unless the
+// policy is to decode them as floating-point numbers.
+func applyLargeIntegers(policy LargeIntegerPolicy, dataType tools.UpsertDataType, data []byte) ([]byte, error) {
+	if policy == LargeIntegersFloat {
+		return data, nil
+	}
+
+	return tools.LargeIntegerRecords(dataType, data)
+}
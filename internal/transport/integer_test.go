@@ -0,0 +1,56 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+func TestLargeIntegerPolicy(t *testing.T) {
+	t.Parallel()
+
+	data := `{"id":1541815603606036480}`
+
+	for _, tcase := range []struct {
+		name     string
+		policy   LargeIntegerPolicy
+		expected string
+		err      error
+	}{
+		{name: "default", policy: "", expected: `{"id":"1541815603606036480"}`},
+		{name: "string", policy: LargeIntegersString, expected: `{"id":"1541815603606036480"}`},
+		{name: "float", policy: LargeIntegersFloat, expected: data},
+		{name: "unknown", policy: "numeric", err: ErrInvalidLargeIntegers},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.policy.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err != nil {
+				return
+			}
+
+			got, err := applyLargeIntegers(tcase.policy, tools.UpsertDataJSON, []byte(data))
+			if err != nil {
+				t.Fatalf("failed to apply large integers policy: %v", err)
+			}
+
+			if string(got) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+const (
+	journalDirMode  = 0o700
+	journalFileMode = 0o600
+	journalExt      = ".wal"
+
+	// rejectedExt is the extension of the files of the upsert requests that a storage device rejected.
+	rejectedExt = ".rejected"
+)
+
+var ErrCorruptJournal = fmt.Errorf("corrupt journal")
+
+// CorruptJournalError wraps an error with ErrCorruptJournal.
+func CorruptJournalError(path string, err error) error {
+	return fmt.Errorf("%w %q: %v", ErrCorruptJournal, path, err)
+}
+
+// journalEntry is a line of a journal file. An entry without "Ack" is an upsert request that was about to be sent to
+// the storage device, and an entry with "Ack" marks the upsert request with the same sequence as written, or as
+// rejected. The entries of the rejected files have the error that the storage device rejected the request with.
+type journalEntry struct {
+	Seq     int64  `json:"seq"`
+	Ack     bool   `json:"ack,omitempty"`
+	Request []byte `json:"request,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// journalFile is the journal of a table.
+type journalFile struct {
+	file *os.File
+	seq  int64
+
+	// pending is the number of upsert requests that were not acknowledged.
+	pending int
+}
+
+// journal is the write-ahead journal of the upserts to a storage device without transactions. Every upsert request is
+// appended to the journal of its table and synced to disk before it is sent to the storage device, and it is
+// acknowledged once the storage device has written it. The upsert requests that were not acknowledged, e.g. because
+// the process crashed while they were written, are replayed at the start of the next run. Upserts match the records
+// by their primary keys, so replaying an upsert that was partially or entirely written does not duplicate records.
+type journal struct {
+	dir    string
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	files  map[string]*journalFile
+	closed bool
+}
+
+// journalName will return the name of the journal directory of a connection string, which identifies the storage
+// device without exposing the connection string.
+func journalName(stype uint8, dns string) string {
+	sum := sha256.Sum256([]byte(dns))
+
+	return fmt.Sprintf("%s-%s", storage.Scheme(stype), hex.EncodeToString(sum[:8]))
+}
+
+// openJournals will open the journals of the repositories without transactions and replay the upserts that previous
+// runs did not finish writing. Repositories with transactions have a nil journal, since a crash rolls back their
+// upserts.
+func (cfg *Config) openJournals(ctx context.Context, repos []repository.Generic) ([]*journal, error) {
+	if cfg.JournalDir == "" {
+		return nil, nil
+	}
+
+	journals := make([]*journal, len(repos))
+
+	for idx, repo := range repos {
+		if !repo.Untransacted() {
+			continue
+		}
+
+		jrnl := &journal{
+			dir:    filepath.Join(cfg.JournalDir, journalName(repo.Type(), cfg.ConnectionStrings[idx])),
+			logger: cfg.Logger,
+			files:  make(map[string]*journalFile),
+		}
+
+		if err := os.MkdirAll(jrnl.dir, journalDirMode); err != nil {
+			return nil, fmt.Errorf("unable to create journal directory: %w", err)
+		}
+
+		if err := jrnl.replay(ctx, repo); err != nil {
+			return nil, err
+		}
+
+		journals[idx] = jrnl
+	}
+
+	return journals, nil
+}
+
+// readJournal will return the upsert requests of a journal file that were not acknowledged, in the order they were
+// appended. A partial last line is the entry of a write that was interrupted by a crash, so its upsert was never sent
+// and it is ignored.
+func readJournal(path string) ([]*proto.UpsertRequest, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read journal: %w", err)
+	}
+
+	lines := strings.Split(string(bytes), "\n")
+
+	var seqs []int64
+
+	requests := make(map[int64]*proto.UpsertRequest)
+
+	for idx, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		var entry journalEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			if idx == len(lines)-1 {
+				break
+			}
+
+			return nil, CorruptJournalError(path, err)
+		}
+
+		if entry.Ack {
+			delete(requests, entry.Seq)
+
+			continue
+		}
+
+		req := new(proto.UpsertRequest)
+		if err := protobuf.Unmarshal(entry.Request, req); err != nil {
+			return nil, CorruptJournalError(path, err)
+		}
+
+		requests[entry.Seq] = req
+		seqs = append(seqs, entry.Seq)
+	}
+
+	pending := make([]*proto.UpsertRequest, 0, len(requests))
+
+	for _, seq := range seqs {
+		if req, ok := requests[seq]; ok {
+			pending = append(pending, req)
+		}
+	}
+
+	return pending, nil
+}
+
+// replay will upsert the requests of the journal that were not acknowledged, and remove the journal files once they
+// are written. Requests that the storage device rejects, e.g. because their data violates a constraint, would be
+// rejected by every run, so they are moved to the rejected file of their table with a warning instead. Any other
+// failure, e.g. a lost connection, fails the replay and keeps the journal file, so that it is replayed again by the
+// next run.
+func (jrnl *journal) replay(ctx context.Context, repo repository.Generic) error {
+	paths, err := filepath.Glob(filepath.Join(jrnl.dir, "*"+journalExt))
+	if err != nil {
+		return fmt.Errorf("unable to list journals: %w", err)
+	}
+
+	for _, path := range paths {
+		reqs, err := readJournal(path)
+		if err != nil {
+			return err
+		}
+
+		rejected := 0
+
+		for idx, req := range reqs {
+			_, err := repo.Upsert(ctx, req)
+			if err == nil {
+				continue
+			}
+
+			if ctx.Err() != nil || !errors.Is(err, storage.ErrRejected) {
+				return fmt.Errorf("unable to replay journal %q: %w", path, err)
+			}
+
+			rejectedPath := strings.TrimSuffix(path, journalExt) + rejectedExt
+			if err := writeRejected(rejectedPath, int64(idx+1), req, err); err != nil {
+				return err
+			}
+
+			logWarn := tools.LogFormatter{
+				Msg: fmt.Sprintf("moved rejected upsert from journal %q to %q: %v", path, rejectedPath, err),
+			}
+			jrnl.logger.Warn(logWarn.String())
+
+			rejected++
+		}
+
+		if len(reqs) > 0 {
+			logInfo := tools.LogFormatter{
+				Msg: fmt.Sprintf("replayed %d unfinished upserts from journal %q", len(reqs)-rejected, path),
+			}
+			jrnl.logger.Info(logInfo.String())
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("unable to remove journal: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// writeRejected will append an upsert request that a storage device rejected to a rejected file, along with the
+// error, so that it can be inspected and fixed without being replayed.
+func writeRejected(path string, seq int64, req *proto.UpsertRequest, rejection error) error {
+	bytes, err := protobuf.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("unable to encode journal request: %w", err)
+	}
+
+	entry, err := json.Marshal(&journalEntry{Seq: seq, Request: bytes, Error: rejection.Error()})
+	if err != nil {
+		return fmt.Errorf("unable to encode journal entry: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, journalFileMode)
+	if err != nil {
+		return fmt.Errorf("unable to open rejected journal: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(entry, '\n')); err != nil {
+		return fmt.Errorf("unable to write rejected journal: %w", err)
+	}
+
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync rejected journal: %w", err)
+	}
+
+	return nil
+}
+
+// append will write an entry to the journal file of a table. Upsert requests are synced to disk before they are sent,
+// acknowledgements are not: a lost acknowledgement only replays an upsert that was already written.
+func (jrnl *journal) append(table string, entry *journalEntry, sync bool) error {
+	jfile := jrnl.files[table]
+
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("unable to encode journal entry: %w", err)
+	}
+
+	if _, err := jfile.file.Write(append(bytes, '\n')); err != nil {
+		return fmt.Errorf("unable to write journal: %w", err)
+	}
+
+	if !sync {
+		return nil
+	}
+
+	if err := jfile.file.Sync(); err != nil {
+		return fmt.Errorf("unable to sync journal: %w", err)
+	}
+
+	return nil
+}
+
+// write will append an upsert request to the journal of its table before it is sent to the storage device, returning
+// the sequence that acknowledges it.
+func (jrnl *journal) write(req *proto.UpsertRequest) (int64, error) {
+	if jrnl == nil {
+		return 0, nil
+	}
+
+	jrnl.mu.Lock()
+	defer jrnl.mu.Unlock()
+
+	if jrnl.closed {
+		return 0, fmt.Errorf("unable to write journal: journal is closed")
+	}
+
+	jfile := jrnl.files[req.Table]
+	if jfile == nil {
+		path := filepath.Join(jrnl.dir, url.PathEscape(req.Table)+journalExt)
+
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, journalFileMode)
+		if err != nil {
+			return 0, fmt.Errorf("unable to open journal: %w", err)
+		}
+
+		jfile = &journalFile{file: file}
+		jrnl.files[req.Table] = jfile
+	}
+
+	bytes, err := protobuf.Marshal(req)
+	if err != nil {
+		return 0, fmt.Errorf("unable to encode journal request: %w", err)
+	}
+
+	jfile.seq++
+
+	if err := jrnl.append(req.Table, &journalEntry{Seq: jfile.seq, Request: bytes}, true); err != nil {
+		return 0, err
+	}
+
+	jfile.pending++
+
+	return jfile.seq, nil
+}
+
+// ack will acknowledge an upsert request once the storage device has written it.
+func (jrnl *journal) ack(table string, seq int64) error {
+	if jrnl == nil {
+		return nil
+	}
+
+	jrnl.mu.Lock()
+	defer jrnl.mu.Unlock()
+
+	if jrnl.closed {
+		return fmt.Errorf("unable to acknowledge journal: journal is closed")
+	}
+
+	if err := jrnl.append(table, &journalEntry{Seq: seq, Ack: true}, false); err != nil {
+		return err
+	}
+
+	jrnl.files[table].pending--
+
+	return nil
+}
+
+// reject will acknowledge an upsert request that the storage device rejected, after moving it to the rejected file of
+// its table, so that it is not replayed by every later run.
+func (jrnl *journal) reject(req *proto.UpsertRequest, seq int64, rejection error) error {
+	if jrnl == nil {
+		return nil
+	}
+
+	jrnl.mu.Lock()
+	defer jrnl.mu.Unlock()
+
+	if jrnl.closed {
+		return fmt.Errorf("unable to reject journal: journal is closed")
+	}
+
+	path := filepath.Join(jrnl.dir, url.PathEscape(req.Table)+rejectedExt)
+	if err := writeRejected(path, seq, req, rejection); err != nil {
+		return err
+	}
+
+	if err := jrnl.append(req.Table, &journalEntry{Seq: seq, Ack: true}, true); err != nil {
+		return err
+	}
+
+	jrnl.files[req.Table].pending--
+
+	return nil
+}
+
+// close will close the journal files, removing those whose upserts were all acknowledged. The others are replayed by
+// the next run.
+func (jrnl *journal) close() error {
+	if jrnl == nil {
+		return nil
+	}
+
+	jrnl.mu.Lock()
+	defer jrnl.mu.Unlock()
+
+	jrnl.closed = true
+
+	var errs []string
+
+	for _, jfile := range jrnl.files {
+		if err := jfile.file.Close(); err != nil {
+			errs = append(errs, err.Error())
+
+			continue
+		}
+
+		if jfile.pending > 0 {
+			continue
+		}
+
+		if err := os.Remove(jfile.file.Name()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to close journal: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// closeJournals will close the journals of a run once its transactions are committed or rolled back.
+func closeJournals(logger *logrus.Logger, journals []*journal) {
+	for _, jrnl := range journals {
+		if err := jrnl.close(); err != nil {
+			logErr := tools.LogFormatter{Msg: err.Error()}
+			logger.Error(logErr.String())
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	errRejectedUpsert = storage.RejectedError(errors.New("rejected upsert"))
+	errUnavailable    = errors.New("connection refused")
+)
+
+// journaledRepository is a repository without transactions that records the data of its upserts, and rejects the
+// upserts of its rejected data. An unavailable repository fails every upsert.
+type journaledRepository struct {
+	repository.Generic
+	upserted    []string
+	rejected    string
+	unavailable bool
+}
+
+func (repo *journaledRepository) Upsert(_ context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	if repo.unavailable {
+		return nil, errUnavailable
+	}
+
+	if repo.rejected != "" && string(req.Data) == repo.rejected {
+		return nil, errRejectedUpsert
+	}
+
+	repo.upserted = append(repo.upserted, string(req.Data))
+
+	return new(proto.UpsertResponse), nil
+}
+
+func (repo *journaledRepository) Untransacted() bool { return true }
+
+func (repo *journaledRepository) Type() uint8 { return storage.NATSType }
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	upsert := func(table, data string) *proto.UpsertRequest {
+		return &proto.UpsertRequest{Table: table, Data: []byte(data)}
+	}
+
+	t.Run("replays unacknowledged upserts", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Logger: logger, JournalDir: t.TempDir(), ConnectionStrings: []string{"nats://localhost:4222"}}
+
+		repo := new(journaledRepository)
+
+		journals, err := cfg.openJournals(context.Background(), []repository.Generic{repo})
+		if err != nil {
+			t.Fatalf("unable to open journals: %v", err)
+		}
+
+		for _, req := range []*proto.UpsertRequest{upsert("a", "1"), upsert("a", "2"), upsert("b", "3")} {
+			seq, err := journals[0].write(req)
+			if err != nil {
+				t.Fatalf("unable to write journal: %v", err)
+			}
+
+			// The second upsert of "a" crashed before it was acknowledged.
+			if string(req.Data) == "2" {
+				continue
+			}
+
+			if err := journals[0].ack(req.Table, seq); err != nil {
+				t.Fatalf("unable to acknowledge journal: %v", err)
+			}
+		}
+
+		// The crash interrupted the write of an entry.
+		file := journals[0].files["a"].file
+		if _, err := file.WriteString(`{"seq":3,"req`); err != nil {
+			t.Fatalf("unable to write partial entry: %v", err)
+		}
+
+		if err := journals[0].close(); err != nil {
+			t.Fatalf("unable to close journal: %v", err)
+		}
+
+		paths, _ := filepath.Glob(filepath.Join(journals[0].dir, "*"+journalExt))
+		if len(paths) != 1 || filepath.Base(paths[0]) != "a"+journalExt {
+			t.Fatalf("expected only the journal of the unacknowledged upsert to be kept, got %v", paths)
+		}
+
+		if _, err := cfg.openJournals(context.Background(), []repository.Generic{repo}); err != nil {
+			t.Fatalf("unable to replay journals: %v", err)
+		}
+
+		if len(repo.upserted) != 1 || repo.upserted[0] != "2" {
+			t.Fatalf("expected the unacknowledged upsert to be replayed, got %v", repo.upserted)
+		}
+
+		if _, err := os.Stat(paths[0]); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected the replayed journal to be removed, got %v", err)
+		}
+	})
+
+	t.Run("rejected upserts", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Logger: logger, JournalDir: t.TempDir(), ConnectionStrings: []string{"nats://localhost:4222"}}
+
+		repo := &journaledRepository{rejected: "bad"}
+
+		journals, err := cfg.openJournals(context.Background(), []repository.Generic{repo})
+		if err != nil {
+			t.Fatalf("unable to open journals: %v", err)
+		}
+
+		// The first run crashed before "bad" and "2" were acknowledged, and "worse" was rejected while it ran.
+		for _, req := range []*proto.UpsertRequest{upsert("a", "bad"), upsert("a", "2"), upsert("a", "worse")} {
+			seq, err := journals[0].write(req)
+			if err != nil {
+				t.Fatalf("unable to write journal: %v", err)
+			}
+
+			if string(req.Data) == "worse" {
+				if err := journals[0].reject(req, seq, errRejectedUpsert); err != nil {
+					t.Fatalf("unable to reject journal: %v", err)
+				}
+			}
+		}
+
+		if err := journals[0].close(); err != nil {
+			t.Fatalf("unable to close journal: %v", err)
+		}
+
+		// The second run replays "2" and moves "bad" to the rejected file instead of failing.
+		if _, err := cfg.openJournals(context.Background(), []repository.Generic{repo}); err != nil {
+			t.Fatalf("expected the rejected upsert to be skipped, got %v", err)
+		}
+
+		if len(repo.upserted) != 1 || repo.upserted[0] != "2" {
+			t.Fatalf("expected the other upserts to be replayed, got %v", repo.upserted)
+		}
+
+		rejected, err := os.ReadFile(filepath.Join(journals[0].dir, "a"+rejectedExt))
+		if err != nil {
+			t.Fatalf("unable to read rejected journal: %v", err)
+		}
+
+		if lines := strings.Count(string(rejected), "\n"); lines != 2 || !strings.Contains(string(rejected), "rejected") {
+			t.Fatalf("expected both rejected upserts with their errors, got %s", rejected)
+		}
+
+		// The third run has nothing left to replay.
+		if _, err := cfg.openJournals(context.Background(), []repository.Generic{repo}); err != nil {
+			t.Fatalf("unable to open journals: %v", err)
+		}
+
+		if len(repo.upserted) != 1 {
+			t.Fatalf("expected nothing to be replayed again, got %v", repo.upserted)
+		}
+	})
+
+	t.Run("unavailable storage", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Logger: logger, JournalDir: t.TempDir(), ConnectionStrings: []string{"nats://localhost:4222"}}
+
+		repo := &journaledRepository{unavailable: true}
+
+		journals, err := cfg.openJournals(context.Background(), []repository.Generic{repo})
+		if err != nil {
+			t.Fatalf("unable to open journals: %v", err)
+		}
+
+		if _, err := journals[0].write(upsert("a", "1")); err != nil {
+			t.Fatalf("unable to write journal: %v", err)
+		}
+
+		if err := journals[0].close(); err != nil {
+			t.Fatalf("unable to close journal: %v", err)
+		}
+
+		// The upsert is not rejected by a storage device that can not be reached, so the journal is kept.
+		if _, err := cfg.openJournals(context.Background(), []repository.Generic{repo}); !errors.Is(err, errUnavailable) {
+			t.Fatalf("expected error %v, got %v", errUnavailable, err)
+		}
+
+		if _, err := os.Stat(filepath.Join(journals[0].dir, "a"+rejectedExt)); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected no rejected journal, got %v", err)
+		}
+
+		repo.unavailable = false
+
+		if _, err := cfg.openJournals(context.Background(), []repository.Generic{repo}); err != nil {
+			t.Fatalf("unable to replay journals: %v", err)
+		}
+
+		if len(repo.upserted) != 1 || repo.upserted[0] != "1" {
+			t.Fatalf("expected the kept upsert to be replayed, got %v", repo.upserted)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{Logger: logger, JournalDir: t.TempDir(), ConnectionStrings: []string{"nats://localhost:4222"}}
+		dir := filepath.Join(cfg.JournalDir, journalName(storage.NATSType, cfg.ConnectionStrings[0]))
+
+		if err := os.MkdirAll(dir, journalDirMode); err != nil {
+			t.Fatalf("unable to create journal directory: %v", err)
+		}
+
+		err := os.WriteFile(filepath.Join(dir, "a"+journalExt), []byte("{\n{\"seq\":1}\n"), journalFileMode)
+		if err != nil {
+			t.Fatalf("unable to write journal: %v", err)
+		}
+
+		_, err = cfg.openJournals(context.Background(), []repository.Generic{new(journaledRepository)})
+		if !errors.Is(err, ErrCorruptJournal) {
+			t.Fatalf("expected error %v, got %v", ErrCorruptJournal, err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		journals, err := new(Config).openJournals(context.Background(), []repository.Generic{new(journaledRepository)})
+		if err != nil || journals != nil {
+			t.Fatalf("expected no journals, got %v, %v", journals, err)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// JSONDecoder is the implementation that decodes the JSON records of the upsert requests on the storage devices, which
+// is the dominant cost of large JSON ingests.
+type JSONDecoder string
+
+const (
+	// JSONDecoderStandard decodes the records with "encoding/json".
+	JSONDecoderStandard JSONDecoder = "standard"
+
+	// JSONDecoderFast decodes the records with github.com/goccy/go-json, which is faster and decodes the records the
+	// same.
+	JSONDecoderFast JSONDecoder = "fast"
+)
+
+var ErrInvalidJSONDecoder = fmt.Errorf("invalid json decoder")
+
+// InvalidJSONDecoderError wraps an error with ErrInvalidJSONDecoder.
+func InvalidJSONDecoderError(decoder JSONDecoder) error {
+	return fmt.Errorf("%w %q: must be standard or fast", ErrInvalidJSONDecoder, decoder)
+}
+
+func (decoder JSONDecoder) validate() error {
+	switch decoder {
+	case "", JSONDecoderStandard, JSONDecoderFast:
+		return nil
+	default:
+		return InvalidJSONDecoderError(decoder)
+	}
+}
+
+// dataType will return the upsert data type that selects the decoder on the storage devices. If the decoder is not
+// set, the default of the build is used, which is the fast decoder with the "gidari_fastjson" build tag.
+func (decoder JSONDecoder) dataType() tools.UpsertDataType {
+	if decoder == "" {
+		decoder = defaultJSONDecoder
+	}
+
+	if decoder == JSONDecoderFast {
+		return tools.UpsertDataFastJSON
+	}
+
+	return tools.UpsertDataJSON
+}
@@ -0,0 +1,14 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+
+//go:build !gidari_fastjson
+
+package transport
+
+// defaultJSONDecoder is the decoder of the requests that do not set one.
+const defaultJSONDecoder = JSONDecoderStandard
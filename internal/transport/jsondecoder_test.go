@@ -0,0 +1,93 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+func TestJSONDecoder(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		yml      string
+		expected []tools.UpsertDataType
+		err      error
+	}{
+		{
+			name: "default",
+			yml: `
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+`,
+			expected: []tools.UpsertDataType{defaultJSONDecoder.dataType()},
+		},
+		{
+			name: "inherited",
+			yml: `
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+jsonDecoder: fast
+requests:
+  - endpoint: /trades
+  - endpoint: /candles
+    jsonDecoder: standard
+`,
+			expected: []tools.UpsertDataType{tools.UpsertDataFastJSON, tools.UpsertDataJSON},
+		},
+		{
+			name: "invalid",
+			yml: `
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+    jsonDecoder: simd
+`,
+			err: ErrInvalidJSONDecoder,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := NewConfig([]byte(tcase.yml))
+			if tcase.err != nil {
+				if err == nil {
+					err = cfg.validate()
+				}
+
+				if !errors.Is(err, tcase.err) {
+					t.Fatalf("expected error %v, got %v", tcase.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("error creating config: %v", err)
+			}
+
+			for i, expected := range tcase.expected {
+				if got := cfg.Requests[i].storageOptions().dataType; got != expected {
+					t.Fatalf("expected request %d to use data type %d, got %d", i, expected, got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The rules of the lint pass.
+const (
+	LintRateLimit         = "rate-limit"
+	LintTruncate          = "truncate"
+	LintTimeseriesRange   = "timeseries-range"
+	LintTimeout           = "timeout"
+	LintOverlappingTables = "overlapping-tables"
+)
+
+const (
+	// lintMaxRequestsPerSecond is the rate limit above which a rate limit is effectively missing.
+	lintMaxRequestsPerSecond = 100
+
+	// lintMaxChunks is the number of chunks above which a timeseries range is effectively unbounded.
+	lintMaxChunks = 10000
+)
+
+// LintWarning is a setting of a valid configuration that is likely to cause problems, e.g. a missing timeout.
+type LintWarning struct {
+	// Rule is the lint rule that flagged the setting, e.g. "timeout".
+	Rule string `json:"rule"`
+
+	// Table is the table of the requests whose setting is flagged, if the setting is not of the entire configuration.
+	Table string `json:"table,omitempty"`
+
+	// Message describes the risk and how to avoid it.
+	Message string `json:"message"`
+}
+
+// String will return the warning as a line of text.
+func (warning *LintWarning) String() string {
+	if warning.Table == "" {
+		return fmt.Sprintf("%s: %s", warning.Rule, warning.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", warning.Rule, warning.Table, warning.Message)
+}
+
+// lintRateLimit will flag a rate limit that allows so many requests per second that it is effectively missing.
+func lintRateLimit(rl *RateLimitConfig, table string) *LintWarning {
+	if rl == nil || rl.Period == nil {
+		return nil
+	}
+
+	requests := rl.Credits
+	if requests == nil {
+		requests = rl.Burst
+	}
+
+	if requests == nil {
+		return nil
+	}
+
+	if *rl.Period <= 0 {
+		return &LintWarning{Rule: LintRateLimit, Table: table, Message: "the rate limit has no period, so it is unlimited"}
+	}
+
+	perSecond := float64(*requests) / rl.Period.Seconds()
+	if perSecond <= lintMaxRequestsPerSecond {
+		return nil
+	}
+
+	return &LintWarning{
+		Rule:  LintRateLimit,
+		Table: table,
+		Message: fmt.Sprintf("the rate limit allows %.0f requests per second, which is effectively unlimited; set it "+
+			"to the documented limit of the web API to avoid 429 errors and bans", perSecond),
+	}
+}
+
+// lintTimeseriesChunks will return the number of chunks of the range of a timeseries request, or 0 if the range can
+// not be parsed.
+func lintTimeseriesChunks(req *Request) int64 {
+	layout := time.RFC3339
+	if req.Timeseries.Layout != nil {
+		layout = *req.Timeseries.Layout
+	}
+
+	start, err := time.Parse(layout, req.Query[req.Timeseries.StartName])
+	if err != nil {
+		return 0
+	}
+
+	end, err := time.Parse(layout, req.Query[req.Timeseries.EndName])
+	if err != nil || req.Timeseries.Period <= 0 {
+		return 0
+	}
+
+	period := time.Duration(req.Timeseries.Period) * time.Second
+
+	return int64((end.Sub(start) + period - 1) / period)
+}
+
+// lintRequests will flag the risky settings of the requests of a configuration.
+func (cfg *Config) lintRequests() []*LintWarning {
+	var warnings []*LintWarning
+
+	// Requests without a rate limit inherit the configuration's, and expanded requests share theirs, so each rate
+	// limit is flagged once.
+	linted := map[*RateLimitConfig]bool{cfg.RateLimitConfig: true}
+
+	for _, req := range cfg.Requests {
+		if !linted[req.RateLimitConfig] {
+			linted[req.RateLimitConfig] = true
+
+			if warning := lintRateLimit(req.RateLimitConfig, req.Table); warning != nil {
+				warnings = append(warnings, warning)
+			}
+		}
+
+		if req.Timeseries == nil {
+			continue
+		}
+
+		if chunks := lintTimeseriesChunks(req); chunks > lintMaxChunks {
+			warnings = append(warnings, &LintWarning{
+				Rule:  LintTimeseriesRange,
+				Table: req.Table,
+				Message: fmt.Sprintf("the timeseries range is %d chunks of %ds; narrow the range, e.g. a start far "+
+					"in the past, or increase the period", chunks, req.Timeseries.Period),
+			})
+		}
+	}
+
+	return warnings
+}
+
+// lintTruncate will flag the tables that are truncated entirely before they are loaded, so that a failed run leaves
+// them empty.
+func (cfg *Config) lintTruncate() []*LintWarning {
+	if !cfg.Truncate {
+		return nil
+	}
+
+	policy := cfg.TruncatePolicy
+	if policy != nil && (policy.RequireConfirmation || len(policy.ProtectedTables) > 0) {
+		return nil
+	}
+
+	var warnings []*LintWarning
+
+	seen := make(map[string]bool)
+
+	for _, req := range cfg.Requests {
+		if req.Table == "" || seen[req.Table] || (req.Timeseries != nil && req.Timeseries.TruncateColumn != "") {
+			continue
+		}
+
+		seen[req.Table] = true
+
+		warnings = append(warnings, &LintWarning{
+			Rule:  LintTruncate,
+			Table: req.Table,
+			Message: "the table is truncated before it is loaded, so a failed run leaves it empty; set the timeseries " +
+				"truncateColumn to only delete the loaded range, or protect the table with the truncatePolicy",
+		})
+	}
+
+	return warnings
+}
+
+// lintOverlappingTables will flag the tables that are written by several requests whose records can not be told
+// apart, since they set no param fields.
+func (cfg *Config) lintOverlappingTables() []*LintWarning {
+	endpoints := make(map[string][]string)
+	distinguished := make(map[string]bool)
+
+	for _, req := range cfg.Requests {
+		if req.Table == "" {
+			continue
+		}
+
+		key := req.Database + "." + req.Table
+		endpoints[key] = append(endpoints[key], req.Endpoint)
+
+		if len(req.ParamFields) > 0 {
+			distinguished[key] = true
+		}
+	}
+
+	keys := make([]string, 0, len(endpoints))
+	for key := range endpoints {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var warnings []*LintWarning
+
+	for _, key := range keys {
+		if len(endpoints[key]) < 2 || distinguished[key] {
+			continue
+		}
+
+		warnings = append(warnings, &LintWarning{
+			Rule:  LintOverlappingTables,
+			Table: strings.TrimPrefix(key, "."),
+			Message: fmt.Sprintf("the table is written by %d requests (%s) whose records can not be told apart; set "+
+				"paramFields or write them to different tables", len(endpoints[key]),
+				strings.Join(endpoints[key], ", ")),
+		})
+	}
+
+	return warnings
+}
+
+// Lint will return the warnings of the settings of a valid configuration that are likely to cause problems: rate
+// limits that are effectively missing, tables that are truncated before they are loaded, timeseries ranges that are
+// effectively unbounded, web requests without a timeout, and tables written by several requests.
+func Lint(cfg *Config) []*LintWarning {
+	var warnings []*LintWarning
+
+	if warning := lintRateLimit(cfg.RateLimitConfig, ""); warning != nil {
+		warnings = append(warnings, warning)
+	}
+
+	if cfg.Timeout == 0 && (cfg.HTTPClient == nil || cfg.HTTPClient.Timeout == 0) {
+		warnings = append(warnings, &LintWarning{
+			Rule:    LintTimeout,
+			Message: "web requests have no timeout, so a stalled connection hangs the run; set the timeout",
+		})
+	}
+
+	warnings = append(warnings, cfg.lintRequests()...)
+	warnings = append(warnings, cfg.lintTruncate()...)
+	warnings = append(warnings, cfg.lintOverlappingTables()...)
+
+	return warnings
+}
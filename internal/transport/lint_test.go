@@ -0,0 +1,93 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		yml      string
+		expected []string
+	}{
+		{
+			name: "clean",
+			yml: `
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 5, period: 1s}
+timeout: 30s
+truncate: true
+truncatePolicy: {requireConfirmation: true}
+requests:
+  - endpoint: /trades/{symbol}
+    table: trades
+    expand: {symbol: [BTC-USD, ETH-USD]}
+    paramFields: {symbol: symbol}
+`,
+		},
+		{
+			name: "risky",
+			yml: `
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 1000, period: 1s}
+truncate: true
+requests:
+  - endpoint: /candles
+    table: candles
+    query: {start: "2000-01-01T00:00:00Z", end: "2023-01-01T00:00:00Z"}
+    timeseries: {startName: start, endName: end, period: 60, truncateColumn: time}
+  - endpoint: /trades
+    table: trades
+    rateLimit: {burst: 200, period: 1s}
+  - endpoint: /fills
+    table: trades
+`,
+			expected: []string{
+				LintRateLimit + ": the rate limit allows 1000 requests per second, which is effectively unlimited; set it " +
+					"to the documented limit of the web API to avoid 429 errors and bans",
+				LintTimeout + ": web requests have no timeout, so a stalled connection hangs the run; set the timeout",
+				LintTimeseriesRange + ": candles: the timeseries range is 12097440 chunks of 60s; narrow the range, e.g. " +
+					"a start far in the past, or increase the period",
+				LintRateLimit + ": trades: the rate limit allows 200 requests per second, which is effectively " +
+					"unlimited; set it to the documented limit of the web API to avoid 429 errors and bans",
+				LintTruncate + ": trades: the table is truncated before it is loaded, so a failed run leaves it empty; " +
+					"set the timeseries truncateColumn to only delete the loaded range, or protect the table with the " +
+					"truncatePolicy",
+				LintOverlappingTables + ": trades: the table is written by 2 requests (/trades, /fills) whose records " +
+					"can not be told apart; set paramFields or write them to different tables",
+			},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := NewConfig([]byte(tcase.yml))
+			if err != nil {
+				t.Fatalf("error creating config: %v", err)
+			}
+
+			var got []string
+			for _, warning := range Lint(cfg) {
+				got = append(got, warning.String())
+			}
+
+			if !reflect.DeepEqual(got, tcase.expected) {
+				t.Fatalf("expected warnings:\n%q\ngot:\n%q", tcase.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+var ErrInvalidLocale = fmt.Errorf("invalid locale")
+
+// InvalidLocaleError wraps an error with ErrInvalidLocale.
+func InvalidLocaleError(locale string, err error) error {
+	return fmt.Errorf("%w %q: %v", ErrInvalidLocale, locale, err)
+}
+
+// localeNames are the localized names of the months, from January, and of the weekdays, from Sunday, of a language,
+// with their abbreviations.
+type localeNames struct {
+	months, shortMonths, weekdays, shortWeekdays string
+}
+
+// localeFormat is how a locale writes numbers and dates.
+type localeFormat struct {
+	decimal   string
+	thousands []string
+
+	// months and weekdays are the lower case localized names and abbreviations of the months and weekdays.
+	months   map[string]time.Month
+	weekdays map[string]time.Weekday
+}
+
+// localeNumbers are the decimal separator and thousands separators of the languages, and of the regions that differ
+// from their language, e.g. "de-CH". Other languages write numbers like English.
+var localeNumbers = map[string]*localeFormat{
+	"en":    {decimal: ".", thousands: []string{","}},
+	"de":    {decimal: ",", thousands: []string{"."}},
+	"de-CH": {decimal: ".", thousands: []string{"'", "\u2019"}},
+	"es":    {decimal: ",", thousands: []string{"."}},
+	"es-MX": {decimal: ".", thousands: []string{","}},
+	"es-US": {decimal: ".", thousands: []string{","}},
+	"fr":    {decimal: ",", thousands: []string{" ", "\u00a0", "\u202f"}},
+	"it":    {decimal: ",", thousands: []string{"."}},
+	"nl":    {decimal: ",", thousands: []string{"."}},
+	"pt":    {decimal: ",", thousands: []string{"."}},
+}
+
+// localeDates are the localized names of the months and weekdays of the languages that dates are parsed in.
+var localeDates = map[string]localeNames{
+	"de": {
+		months:        "januar februar märz april mai juni juli august september oktober november dezember",
+		shortMonths:   "jan feb mär apr mai jun jul aug sep okt nov dez",
+		weekdays:      "sonntag montag dienstag mittwoch donnerstag freitag samstag",
+		shortWeekdays: "so mo di mi do fr sa",
+	},
+	"es": {
+		months:        "enero febrero marzo abril mayo junio julio agosto septiembre octubre noviembre diciembre",
+		shortMonths:   "ene feb mar abr may jun jul ago sep oct nov dic",
+		weekdays:      "domingo lunes martes miércoles jueves viernes sábado",
+		shortWeekdays: "dom lun mar mié jue vie sáb",
+	},
+	"fr": {
+		months:        "janvier février mars avril mai juin juillet août septembre octobre novembre décembre",
+		shortMonths:   "janv févr mars avr mai juin juil août sept oct nov déc",
+		weekdays:      "dimanche lundi mardi mercredi jeudi vendredi samedi",
+		shortWeekdays: "dim lun mar mer jeu ven sam",
+	},
+	"it": {
+		months:        "gennaio febbraio marzo aprile maggio giugno luglio agosto settembre ottobre novembre dicembre",
+		shortMonths:   "gen feb mar apr mag giu lug ago set ott nov dic",
+		weekdays:      "domenica lunedì martedì mercoledì giovedì venerdì sabato",
+		shortWeekdays: "dom lun mar mer gio ven sab",
+	},
+	"nl": {
+		months:        "januari februari maart april mei juni juli augustus september oktober november december",
+		shortMonths:   "jan feb mrt apr mei jun jul aug sep okt nov dec",
+		weekdays:      "zondag maandag dinsdag woensdag donderdag vrijdag zaterdag",
+		shortWeekdays: "zo ma di wo do vr za",
+	},
+	"pt": {
+		months:        "janeiro fevereiro março abril maio junho julho agosto setembro outubro novembro dezembro",
+		shortMonths:   "jan fev mar abr mai jun jul ago set out nov dez",
+		weekdays:      "domingo segunda-feira terça-feira quarta-feira quinta-feira sexta-feira sábado",
+		shortWeekdays: "dom seg ter qua qui sex sáb",
+	},
+}
+
+// parseLocale will return how the locale of a BCP 47 language tag, e.g. "de-DE", writes numbers and dates. An empty
+// locale returns nil.
+func parseLocale(locale string) (*localeFormat, error) {
+	if locale == "" {
+		return nil, nil
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return nil, InvalidLocaleError(locale, err)
+	}
+
+	base, _ := tag.Base()
+	region, _ := tag.Region()
+
+	numbers, ok := localeNumbers[base.String()+"-"+region.String()]
+	if !ok {
+		if numbers, ok = localeNumbers[base.String()]; !ok {
+			numbers = localeNumbers["en"]
+		}
+	}
+
+	format := &localeFormat{
+		decimal:   numbers.decimal,
+		thousands: numbers.thousands,
+		months:    make(map[string]time.Month),
+		weekdays:  make(map[string]time.Weekday),
+	}
+
+	names := localeDates[base.String()]
+	for _, list := range []string{names.months, names.shortMonths} {
+		for idx, name := range strings.Fields(list) {
+			format.months[name] = time.Month(idx + 1)
+		}
+	}
+
+	for _, list := range []string{names.weekdays, names.shortWeekdays} {
+		for idx, name := range strings.Fields(list) {
+			format.weekdays[name] = time.Weekday(idx)
+		}
+	}
+
+	return format, nil
+}
+
+// englishName will return the English name of a month or weekday in the form that a time layout expects, i.e. the full
+// name if the layout has the full name of its reference, e.g. "January", and the abbreviation otherwise.
+func englishName(layout, reference, name string) string {
+	if strings.Contains(layout, reference) {
+		return name
+	}
+
+	return name[:3]
+}
+
+// englishDate will replace the localized names of the months and weekdays of a date with the English names that a
+// time layout expects. Words are runs of letters, including the hyphens between them, e.g. "segunda-feira".
+func (format *localeFormat) englishDate(layout, date string) string {
+	if format == nil || len(format.months) == 0 {
+		return date
+	}
+
+	var (
+		builder strings.Builder
+		word    []rune
+	)
+
+	flush := func() {
+		name := strings.ToLower(string(word))
+
+		if month, ok := format.months[name]; ok {
+			builder.WriteString(englishName(layout, "January", month.String()))
+		} else if weekday, ok := format.weekdays[name]; ok {
+			builder.WriteString(englishName(layout, "Monday", weekday.String()))
+		} else {
+			builder.WriteString(string(word))
+		}
+
+		word = word[:0]
+	}
+
+	runes := []rune(date)
+	for idx, char := range runes {
+		isWordHyphen := char == '-' && len(word) > 0 && idx+1 < len(runes) && unicode.IsLetter(runes[idx+1])
+		if unicode.IsLetter(char) || isWordHyphen {
+			word = append(word, char)
+
+			continue
+		}
+
+		if len(word) > 0 {
+			flush()
+		}
+
+		builder.WriteRune(char)
+	}
+
+	if len(word) > 0 {
+		flush()
+	}
+
+	return builder.String()
+}
+
+// number will return the text of a localized number with its thousands separators stripped and a "." decimal
+// separator. Separators that are set on a rule take precedence over those of the locale.
+func (format *localeFormat) number(str string, thousands, decimal string) string {
+	separators := []string{thousands}
+	if thousands == "" && format != nil {
+		separators = format.thousands
+	}
+
+	if decimal == "" && format != nil {
+		decimal = format.decimal
+	}
+
+	for _, separator := range separators {
+		if separator != "" {
+			str = strings.ReplaceAll(str, separator, "")
+		}
+	}
+
+	if decimal != "" && decimal != "." {
+		str = strings.ReplaceAll(str, decimal, ".")
+	}
+
+	return str
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseLocale(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		locale  string
+		decimal string
+		month   string
+		err     error
+	}{
+		{locale: "de-AT", decimal: ",", month: "märz"},
+		{locale: "de-CH", decimal: ".", month: "märz"},
+		{locale: "nl", decimal: ",", month: "mrt"},
+		{locale: "ja-JP", decimal: "."},
+		{locale: "not a locale", err: ErrInvalidLocale},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.locale, func(t *testing.T) {
+			t.Parallel()
+
+			format, err := parseLocale(tcase.locale)
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err != nil {
+				return
+			}
+
+			if format.decimal != tcase.decimal {
+				t.Fatalf("expected decimal separator %q, got %q", tcase.decimal, format.decimal)
+			}
+
+			if _, ok := format.months[tcase.month]; tcase.month != "" && !ok {
+				t.Fatalf("expected month %q to be known", tcase.month)
+			}
+		})
+	}
+}
+
+func TestLocaleHeader(t *testing.T) {
+	t.Parallel()
+
+	if header := (&Request{}).header("GET", "https://api.example.com", nil); header != nil {
+		t.Fatalf("expected no header, got %v", header)
+	}
+
+	header := (&Request{Locale: "de-DE"}).header("GET", "https://api.example.com", nil)
+	if got := header.Get("Accept-Language"); got != "de-DE" {
+		t.Fatalf("expected Accept-Language de-DE, got %q", got)
+	}
+}
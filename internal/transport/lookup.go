@@ -0,0 +1,250 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"gopkg.in/yaml.v2"
+)
+
+// The actions for records without a match in a lookup table.
+const (
+	LookupMissingKeep = "keep"
+	LookupMissingDrop = "drop"
+	LookupMissingFail = "fail"
+)
+
+var (
+	ErrInvalidLookup = fmt.Errorf("invalid lookup")
+	ErrLookupMissing = fmt.Errorf("lookup missing")
+)
+
+// InvalidLookupError wraps an error with ErrInvalidLookup.
+func InvalidLookupError(file, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidLookup, file, reason)
+}
+
+// LookupConfig enriches the records of a table from a local lookup table before they are upserted, e.g. to map the
+// symbols of an exchange to internal instrument IDs. A record whose field matches the key of a row of the lookup table
+// is set the fields of the row.
+type LookupConfig struct {
+	// File is the path of the lookup table: a CSV file with a header row, or a YAML or JSON file holding a list of
+	// rows, by its extension.
+	File string `yaml:"file"`
+
+	// Key is the column of the lookup table that is matched.
+	Key string `yaml:"key"`
+
+	// Field is the field of the records that is matched against the key. Defaults to the key.
+	Field string `yaml:"field"`
+
+	// Fields are the columns of the lookup table that are set on the records. Defaults to every column but the key.
+	Fields []string `yaml:"fields"`
+
+	// OnMissing is the action for records without a match: "keep" the record as is, "drop" the record, or "fail" the
+	// upsert. Defaults to "keep".
+	OnMissing string `yaml:"onMissing"`
+
+	// rows are the rows of the lookup table by the values of their key.
+	rows map[string]map[string]interface{}
+}
+
+func (lc *LookupConfig) validate() error {
+	if lc.File == "" {
+		return MissingConfigFieldError("lookups.file")
+	}
+
+	if lc.Key == "" {
+		return InvalidLookupError(lc.File, "key is required")
+	}
+
+	switch lc.OnMissing {
+	case "", LookupMissingKeep, LookupMissingDrop, LookupMissingFail:
+	default:
+		return InvalidLookupError(lc.File, fmt.Sprintf("unknown onMissing %q", lc.OnMissing))
+	}
+
+	return nil
+}
+
+// field will return the field of the records that is matched against the key.
+func (lc *LookupConfig) field() string {
+	if lc.Field != "" {
+		return lc.Field
+	}
+
+	return lc.Key
+}
+
+// lookupValue will return the string that a lookup key or record field is matched by.
+func lookupValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
+
+// load will read the rows of the lookup table, once for the requests that share it, e.g. expanded requests.
+func (lc *LookupConfig) load() error {
+	if lc.rows != nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(lc.File)
+	if err != nil {
+		return fmt.Errorf("unable to read lookup table: %w", err)
+	}
+
+	var rows []map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(lc.File)) {
+	case ".csv":
+		if rows, err = decodeCSVRows(data); err != nil {
+			return InvalidLookupError(lc.File, err.Error())
+		}
+	case ".yml", ".yaml", ".json":
+		if err := yaml.Unmarshal(data, &rows); err != nil {
+			return InvalidLookupError(lc.File, err.Error())
+		}
+	default:
+		return InvalidLookupError(lc.File, "file must be CSV, YAML, or JSON")
+	}
+
+	lc.rows = make(map[string]map[string]interface{}, len(rows))
+
+	for _, row := range rows {
+		key, ok := row[lc.Key]
+		if !ok {
+			return InvalidLookupError(lc.File, fmt.Sprintf("row without key %q", lc.Key))
+		}
+
+		fields := make(map[string]interface{}, len(row))
+
+		for column, value := range row {
+			if column != lc.Key {
+				fields[column] = value
+			}
+		}
+
+		if len(lc.Fields) > 0 {
+			fields = make(map[string]interface{}, len(lc.Fields))
+			for _, column := range lc.Fields {
+				fields[column] = row[column]
+			}
+		}
+
+		lc.rows[lookupValue(key)] = fields
+	}
+
+	return nil
+}
+
+// decodeCSVRows will decode the rows of a CSV file with a header row.
+func decodeCSVRows(data []byte) ([]map[string]interface{}, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CSV: %w", err)
+	}
+
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	rows := make([]map[string]interface{}, 0, len(records)-1)
+
+	for _, record := range records[1:] {
+		row := make(map[string]interface{}, len(header))
+		for idx, column := range header {
+			row[column] = record[idx]
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// applyLookups will enrich every record of JSON encoded upsert data from the lookup tables.
+func applyLookups(lookups []*LookupConfig, data []byte) ([]byte, error) {
+	if len(lookups) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	enriched := make([]interface{}, 0, len(records))
+
+	for _, record := range records {
+		keep, err := enrichRecord(lookups, record)
+		if err != nil {
+			return nil, err
+		}
+
+		if keep {
+			enriched = append(enriched, record)
+		}
+	}
+
+	enrichedData, err := json.Marshal(enriched)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return enrichedData, nil
+}
+
+// enrichRecord will set the fields of the matching rows of the lookup tables on a record, returning false if the
+// record is dropped.
+func enrichRecord(lookups []*LookupConfig, record interface{}) (bool, error) {
+	rec, ok := record.(map[string]interface{})
+	if !ok {
+		return true, nil
+	}
+
+	for _, lookup := range lookups {
+		row, ok := lookup.rows[lookupValue(rec[lookup.field()])]
+		if ok {
+			for column, value := range row {
+				rec[column] = value
+			}
+
+			continue
+		}
+
+		switch lookup.OnMissing {
+		case LookupMissingDrop:
+			return false, nil
+		case LookupMissingFail:
+			return false, fmt.Errorf("%w: %q has no row for %s %v", ErrLookupMissing, lookup.File, lookup.field(),
+				rec[lookup.field()])
+		}
+	}
+
+	return true, nil
+}
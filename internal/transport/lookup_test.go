@@ -0,0 +1,105 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyLookups(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	csvFile := filepath.Join(dir, "instruments.csv")
+	if err := os.WriteFile(csvFile, []byte("symbol,instrument_id,venue\nBTC-USD,1,cb\nETH-USD,2,cb\n"), 0o600); err != nil {
+		t.Fatalf("error writing lookup table: %v", err)
+	}
+
+	yamlFile := filepath.Join(dir, "sectors.yml")
+	if err := os.WriteFile(yamlFile, []byte("- {id: 1, sector: crypto}\n"), 0o600); err != nil {
+		t.Fatalf("error writing lookup table: %v", err)
+	}
+
+	data := []byte(`[{"product_id":"BTC-USD","price":"1"},{"product_id":"DOGE-USD","price":"2"}]`)
+
+	for _, tcase := range []struct {
+		name     string
+		lookups  []*LookupConfig
+		expected string
+		err      error
+	}{
+		{
+			name:     "keep",
+			lookups:  []*LookupConfig{{File: csvFile, Key: "symbol", Field: "product_id"}},
+			expected: `[{"instrument_id":"1","price":"1","product_id":"BTC-USD","venue":"cb"},{"price":"2","product_id":"DOGE-USD"}]`,
+		},
+		{
+			name: "fields and drop",
+			lookups: []*LookupConfig{
+				{File: csvFile, Key: "symbol", Field: "product_id", Fields: []string{"instrument_id"}, OnMissing: "drop"},
+				{File: yamlFile, Key: "id", Field: "instrument_id"},
+			},
+			expected: `[{"instrument_id":"1","price":"1","product_id":"BTC-USD","sector":"crypto"}]`,
+		},
+		{
+			name:    "fail",
+			lookups: []*LookupConfig{{File: csvFile, Key: "symbol", Field: "product_id", OnMissing: "fail"}},
+			err:     ErrLookupMissing,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, lookup := range tcase.lookups {
+				if err := lookup.load(); err != nil {
+					t.Fatalf("error loading lookup table: %v", err)
+				}
+			}
+
+			enriched, err := applyLookups(tcase.lookups, data)
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if string(enriched) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, enriched)
+			}
+		})
+	}
+}
+
+func TestLookupConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		lc   *LookupConfig
+		err  error
+	}{
+		{name: "valid", lc: &LookupConfig{File: "instruments.csv", Key: "symbol", OnMissing: "drop"}},
+		{name: "no file", lc: &LookupConfig{Key: "symbol"}, err: ErrMissingConfigField},
+		{name: "no key", lc: &LookupConfig{File: "instruments.csv"}, err: ErrInvalidLookup},
+		{name: "unknown on missing", lc: &LookupConfig{File: "a.csv", Key: "id", OnMissing: "skip"}, err: ErrInvalidLookup},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.lc.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// MissingFieldsPolicy is how the fields that are missing from a record are upserted. Fields that are explicitly null
+// are always stored as null.
+type MissingFieldsPolicy string
+
+const (
+	// MissingFieldsKeep leaves the stored values of missing fields untouched.
+	MissingFieldsKeep MissingFieldsPolicy = storage.MissingFieldsKeep
+
+	// MissingFieldsNull clears the stored values of missing fields, i.e. they are set to NULL on SQL storage and
+	// removed from the document on NoSQL storage.
+	MissingFieldsNull MissingFieldsPolicy = storage.MissingFieldsNull
+
+	// MissingFieldsDefault sets missing fields to their default values. Missing fields without a default are left
+	// untouched.
+	MissingFieldsDefault MissingFieldsPolicy = "default"
+)
+
+var ErrInvalidMissingFields = fmt.Errorf("invalid missing fields policy")
+
+// InvalidMissingFieldsError wraps an error with ErrInvalidMissingFields.
+func InvalidMissingFieldsError(policy MissingFieldsPolicy, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidMissingFields, policy, reason)
+}
+
+func (policy MissingFieldsPolicy) validate(defaults map[string]interface{}) error {
+	switch policy {
+	case "", MissingFieldsKeep, MissingFieldsNull:
+		if len(defaults) > 0 {
+			return InvalidMissingFieldsError(policy, "defaults require the default policy")
+		}
+	case MissingFieldsDefault:
+		if len(defaults) == 0 {
+			return InvalidMissingFieldsError(policy, "defaults are required")
+		}
+	default:
+		return InvalidMissingFieldsError(policy, "must be keep, null, or default")
+	}
+
+	return nil
+}
+
+// storage will return the missing fields policy of the storage. Defaults are set before the records are upserted, so
+// the other missing fields are kept.
+func (policy MissingFieldsPolicy) storage() string {
+	if policy == MissingFieldsDefault {
+		return storage.MissingFieldsKeep
+	}
+
+	return string(policy)
+}
+
+// applyDefaults will set the missing fields on every record of JSON encoded upsert data to their default values.
+// Fields that are explicitly null are left as they are.
+func applyDefaults(defaults map[string]interface{}, data []byte) ([]byte, error) {
+	if len(defaults) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for field, value := range defaults {
+			if _, ok := rec[field]; !ok {
+				rec[field] = value
+			}
+		}
+	}
+
+	defaultData, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return defaultData, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMissingFieldsPolicy(t *testing.T) {
+	t.Parallel()
+
+	defaults := map[string]interface{}{"status": "open"}
+
+	for _, tcase := range []struct {
+		name     string
+		policy   MissingFieldsPolicy
+		defaults map[string]interface{}
+		err      error
+	}{
+		{name: "storage default", policy: ""},
+		{name: "keep", policy: MissingFieldsKeep},
+		{name: "default", policy: MissingFieldsDefault, defaults: defaults},
+		{name: "default without defaults", policy: MissingFieldsDefault, err: ErrInvalidMissingFields},
+		{name: "defaults without default", policy: MissingFieldsNull, defaults: defaults, err: ErrInvalidMissingFields},
+		{name: "unknown", policy: "ignore", err: ErrInvalidMissingFields},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.policy.validate(tcase.defaults); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestMissingFieldsParquet(t *testing.T) {
+	t.Parallel()
+
+	// The fields that the default policy has no default for are kept too.
+	for _, policy := range []string{"keep", "default\n    defaults: {status: open}"} {
+		_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+connectionStrings: ["parquet://lake"]
+requests:
+  - endpoint: /orders
+    table: orders
+    missingFields: ` + policy + `
+`))
+		if !errors.Is(err, ErrInvalidMissingFields) || !strings.Contains(err.Error(), "append-only") {
+			t.Fatalf("expected error %v for %q, got %v", ErrInvalidMissingFields, policy, err)
+		}
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	t.Parallel()
+
+	defaults := map[string]interface{}{"status": "open", "size": 1}
+	data := `[{"id":1,"status":null},{"id":2,"size":0.10000000000000000001}]`
+	expected := `[{"id":1,"size":1,"status":null},{"id":2,"size":0.10000000000000000001,"status":"open"}]`
+
+	got, err := applyDefaults(defaults, []byte(data))
+	if err != nil {
+		t.Fatalf("failed to apply defaults: %v", err)
+	}
+
+	if string(got) != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
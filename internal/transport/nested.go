@@ -0,0 +1,437 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidChildTable     = fmt.Errorf("invalid child table")
+	ErrInvalidNestedStrategy = fmt.Errorf("invalid nested strategy")
+)
+
+// InvalidChildTableError wraps an error with ErrInvalidChildTable.
+func InvalidChildTableError(field, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidChildTable, field, reason)
+}
+
+// InvalidNestedStrategyError wraps an error with ErrInvalidNestedStrategy.
+func InvalidNestedStrategyError(strategy NestedStrategy) error {
+	return fmt.Errorf("%w: %q", ErrInvalidNestedStrategy, strategy)
+}
+
+// NestedStrategy is how the nested objects and arrays of a record are stored.
+type NestedStrategy string
+
+const (
+	// NestedNative stores nested objects and arrays as they are, e.g. as sub-documents in MongoDB.
+	NestedNative NestedStrategy = "native"
+
+	// NestedFlatten flattens nested objects and arrays into top-level fields, joining the field names and array
+	// indexes with the delimiter, e.g. {"a": {"b": 1}} is stored as {"a_b": 1}.
+	NestedFlatten NestedStrategy = "flatten"
+
+	// NestedJSON serializes nested objects and arrays to JSON strings.
+	NestedJSON NestedStrategy = "json"
+
+	// NestedExplode stores arrays of objects in child tables named after the parent table and the field, joined with
+	// the delimiter, e.g. the "items" of "orders" are stored in "orders_items". Each child record has the keys of its
+	// parent, prefixed with the parent table, and an "_index" field with its position in the array. Nested objects are
+	// flattened, and arrays of other values are serialized to JSON strings.
+	NestedExplode NestedStrategy = "explode"
+)
+
+const (
+	// defaultNestedDelimiter is the default delimiter of flattened field names and child table names.
+	defaultNestedDelimiter = "_"
+
+	// nestedIndexField is the field of an exploded child record that holds its position in the array.
+	nestedIndexField = "_index"
+
+	// nestedIndexKey is the key of an exploded child record, which its own children reference as a foreign key
+	// prefixed with its table, e.g. "orders_items_index".
+	nestedIndexKey = "index"
+)
+
+// ChildTable stores an array of objects of a table's records in a child table, e.g. the "items" of "orders" in
+// "order_items". The parent and child records are upserted in the same transaction.
+type ChildTable struct {
+	// Field is the path of the array from the record of the parent table, with the field names joined by the
+	// delimiter, e.g. "items" or "items_options".
+	Field string `yaml:"field"`
+
+	// Table is the name of the child table, the default is the parent table and the field joined by the delimiter.
+	Table string `yaml:"table"`
+
+	// ForeignKey is the field of the child records that references their parent, if the parent has a single key.
+	// The default is the parent table and key joined by the delimiter, e.g. "orders_id".
+	ForeignKey string `yaml:"foreignKey"`
+}
+
+// NestedConfig configures how the nested objects and arrays of a table's records are stored.
+type NestedConfig struct {
+	// Strategy is how nested structures are stored on every storage device, the default is "native".
+	Strategy NestedStrategy `yaml:"strategy"`
+
+	// Storage overrides the strategy for a type of storage device, keyed by its scheme, e.g. "postgresql" or
+	// "mongodb".
+	Storage map[string]NestedStrategy `yaml:"storage"`
+
+	// Delimiter joins flattened field names and child table names, the default is "_".
+	Delimiter *string `yaml:"delimiter"`
+
+	// Keys are the fields that identify a record. They are copied onto the records exploded into child tables, so
+	// that the child records reference their parent.
+	Keys []string `yaml:"keys"`
+
+	// GeneratedKey is a key field that is generated for the records that do not have it, so that the records exploded
+	// into child tables can reference a parent without a natural key. The generated key is a UUID derived from the
+	// record's content, so that re-ingesting a record generates the same key.
+	GeneratedKey string `yaml:"generatedKey"`
+
+	// Children configure the child tables of arrays of objects. If set, the default strategy is "explode".
+	Children []*ChildTable `yaml:"children"`
+}
+
+func (nc *NestedConfig) validate() error {
+	if nc == nil {
+		return nil
+	}
+
+	strategies := []NestedStrategy{nc.Strategy}
+	for _, strategy := range nc.Storage {
+		strategies = append(strategies, strategy)
+	}
+
+	for _, strategy := range strategies {
+		switch strategy {
+		case "", NestedNative, NestedFlatten, NestedJSON, NestedExplode:
+		default:
+			return InvalidNestedStrategyError(strategy)
+		}
+	}
+
+	for _, child := range nc.Children {
+		if child.Field == "" {
+			return InvalidChildTableError(child.Table, "field is required")
+		}
+
+		// Foreign keys of the configured table's children can only be renamed if its records have a single key.
+		topLevel := !strings.Contains(child.Field, nc.delimiter())
+		if topLevel && child.ForeignKey != "" && len(nc.keys()) != 1 {
+			return InvalidChildTableError(child.Field, "a foreign key requires exactly one key")
+		}
+	}
+
+	return nil
+}
+
+// keys will return the key fields of the configured table's records.
+func (nc *NestedConfig) keys() []string {
+	keys := nc.Keys
+
+	if nc.GeneratedKey != "" {
+		for _, key := range keys {
+			if key == nc.GeneratedKey {
+				return keys
+			}
+		}
+
+		keys = append(append([]string{}, keys...), nc.GeneratedKey)
+	}
+
+	return keys
+}
+
+// child will return the child table configuration for the path of an array, or nil if there is none.
+func (nc *NestedConfig) child(path string) *ChildTable {
+	for _, child := range nc.Children {
+		if child.Field == path {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// generateKey will generate a key for a record from its content. Records decoded from JSON can always be encoded,
+// and the encoding sorts the fields so that the key does not depend on their order.
+func generateKey(rec map[string]interface{}) string {
+	data, _ := json.Marshal(rec)
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, data).String()
+}
+
+// strategy will return the strategy for a type of storage device.
+func (nc *NestedConfig) strategy(scheme string) NestedStrategy {
+	if nc == nil {
+		return NestedNative
+	}
+
+	if strategy, ok := nc.Storage[scheme]; ok && strategy != "" {
+		return strategy
+	}
+
+	if nc.Strategy == "" {
+		if len(nc.Children) > 0 {
+			return NestedExplode
+		}
+
+		return NestedNative
+	}
+
+	return nc.Strategy
+}
+
+func (nc *NestedConfig) delimiter() string {
+	if nc.Delimiter == nil {
+		return defaultNestedDelimiter
+	}
+
+	return *nc.Delimiter
+}
+
+// tableData is JSON encoded upsert data for a table.
+type tableData struct {
+	table string
+	data  []byte
+}
+
+// split will apply the strategy for a type of storage device to JSON encoded upsert data for a table. The data for
+// the table is returned first, followed by the data for any child tables in alphabetical order.
+func (nc *NestedConfig) split(scheme, table string, data []byte) ([]*tableData, error) {
+	strategy := nc.strategy(scheme)
+	if strategy == NestedNative {
+		return []*tableData{{table: table, data: data}}, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	tables := map[string][]interface{}{table: nil}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", tools.ErrUnsupportedDataType, record)
+		}
+
+		if nc.GeneratedKey != "" && rec[nc.GeneratedKey] == nil {
+			rec[nc.GeneratedKey] = generateKey(rec)
+		}
+
+		parent := &nestedParent{table: table, own: make(map[string]interface{})}
+
+		for _, key := range nc.keys() {
+			if value, ok := rec[key]; ok {
+				parent.own[key] = value
+			}
+		}
+
+		out := make(map[string]interface{}, len(rec))
+		nc.transform(strategy, parent, "", rec, tables, out)
+
+		tables[table] = append(tables[table], out)
+	}
+
+	return encodeTables(table, tables)
+}
+
+// nestedParent is a record whose arrays of objects are exploded into child tables.
+type nestedParent struct {
+	table string
+
+	// path is the path of the record's array from the record of the configured table, with the field names joined
+	// by the delimiter. It is empty for the record of the configured table.
+	path string
+
+	// inherited are the foreign keys of the record to its own parent, which are copied onto its children as they are.
+	inherited map[string]interface{}
+
+	// own are the keys of the record, which are copied onto its children as foreign keys.
+	own map[string]interface{}
+}
+
+// foreignKeys will return the foreign keys of a child record to the parent record.
+func (nc *NestedConfig) foreignKeys(parent *nestedParent, child *ChildTable) map[string]interface{} {
+	keys := make(map[string]interface{}, len(parent.inherited)+len(parent.own))
+	for column, value := range parent.inherited {
+		keys[column] = value
+	}
+
+	for key, value := range parent.own {
+		column := parent.table + nc.delimiter() + key
+		if child != nil && child.ForeignKey != "" && len(parent.own) == 1 {
+			column = child.ForeignKey
+		}
+
+		keys[column] = value
+	}
+
+	return keys
+}
+
+// transform will apply a strategy to a record, setting its fields on out with the prefix. Records exploded into child
+// tables are added to the tables, with the keys of their parent.
+func (nc *NestedConfig) transform(strategy NestedStrategy, parent *nestedParent, prefix string,
+	rec map[string]interface{}, tables map[string][]interface{}, out map[string]interface{},
+) {
+	for field, value := range rec {
+		name := field
+		if prefix != "" {
+			name = prefix + nc.delimiter() + field
+		}
+
+		switch nested := value.(type) {
+		case map[string]interface{}:
+			if strategy == NestedJSON {
+				out[name] = encodeNested(nested)
+			} else {
+				nc.transform(strategy, parent, name, nested, tables, out)
+			}
+		case []interface{}:
+			switch {
+			case strategy == NestedFlatten:
+				nc.flatten(name, nested, out)
+			case strategy == NestedExplode && isObjectArray(nested):
+				nc.explode(parent, name, nested, tables)
+			default:
+				out[name] = encodeNested(nested)
+			}
+		default:
+			out[name] = value
+		}
+	}
+}
+
+// explode will add the elements of an array of objects to a child table, with the keys of their parent.
+func (nc *NestedConfig) explode(parent *nestedParent, name string, elems []interface{},
+	tables map[string][]interface{},
+) {
+	path := name
+	if parent.path != "" {
+		path = parent.path + nc.delimiter() + name
+	}
+
+	child := nc.child(path)
+
+	table := parent.table + nc.delimiter() + name
+	if child != nil && child.Table != "" {
+		table = child.Table
+	}
+
+	keys := nc.foreignKeys(parent, child)
+
+	for idx, elem := range elems {
+		rec, _ := elem.(map[string]interface{})
+
+		// The child's own key is its position in the array.
+		childParent := &nestedParent{
+			table:     table,
+			path:      path,
+			inherited: keys,
+			own:       map[string]interface{}{nestedIndexKey: idx},
+		}
+
+		out := make(map[string]interface{}, len(rec)+len(keys)+1)
+		nc.transform(NestedExplode, childParent, "", rec, tables, out)
+
+		for column, value := range keys {
+			out[column] = value
+		}
+
+		out[nestedIndexField] = idx
+
+		tables[table] = append(tables[table], out)
+	}
+}
+
+// flatten will set the leaves of a nested value on the record, with the field names and array indexes along the
+// path joined by the delimiter.
+func (nc *NestedConfig) flatten(prefix string, value interface{}, out map[string]interface{}) {
+	switch nested := value.(type) {
+	case map[string]interface{}:
+		for field, value := range nested {
+			nc.flatten(prefix+nc.delimiter()+field, value, out)
+		}
+	case []interface{}:
+		for idx, value := range nested {
+			nc.flatten(prefix+nc.delimiter()+strconv.Itoa(idx), value, out)
+		}
+	default:
+		out[prefix] = value
+	}
+}
+
+// isObjectArray will return true if the array is not empty and every element is an object.
+func isObjectArray(elems []interface{}) bool {
+	for _, elem := range elems {
+		if _, ok := elem.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+
+	return len(elems) > 0
+}
+
+// encodeNested will encode a nested value as a JSON string. Values decoded from JSON can always be encoded.
+func encodeNested(value interface{}) string {
+	data, _ := json.Marshal(value)
+
+	return string(data)
+}
+
+// encodeTables will encode the records of each table, starting with the parent table.
+func encodeTables(parent string, tables map[string][]interface{}) ([]*tableData, error) {
+	names := make([]string, 0, len(tables))
+
+	for table := range tables {
+		if table != parent {
+			names = append(names, table)
+		}
+	}
+
+	sort.Strings(names)
+
+	out := make([]*tableData, 0, len(tables))
+
+	for _, table := range append([]string{parent}, names...) {
+		records := tables[table]
+		if records == nil {
+			records = []interface{}{}
+		}
+
+		data, err := json.Marshal(records)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+		}
+
+		out = append(out, &tableData{table: table, data: data})
+	}
+
+	return out, nil
+}
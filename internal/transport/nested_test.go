@@ -0,0 +1,163 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestNestedConfig(t *testing.T) {
+	t.Parallel()
+
+	const data = `[{"id":1,"customer":{"name":"a","tags":["x"]},"items":[{"sku":"s1","opts":[{"k":"v"}]},{"sku":"s2"}]}]`
+
+	dot := "."
+
+	for _, tcase := range []struct {
+		name     string
+		cfg      *NestedConfig
+		scheme   string
+		expected map[string]string
+	}{
+		{
+			name:     "native by default",
+			scheme:   "postgresql",
+			expected: map[string]string{"orders": data},
+		},
+		{
+			name:   "flatten",
+			cfg:    &NestedConfig{Strategy: NestedFlatten, Delimiter: &dot},
+			scheme: "postgresql",
+			expected: map[string]string{
+				"orders": `[{"customer.name":"a","customer.tags.0":"x","id":1,"items.0.opts.0.k":"v",` +
+					`"items.0.sku":"s1","items.1.sku":"s2"}]`,
+			},
+		},
+		{
+			name:   "json",
+			cfg:    &NestedConfig{Strategy: NestedJSON},
+			scheme: "postgresql",
+			expected: map[string]string{
+				"orders": `[{"customer":"{\"name\":\"a\",\"tags\":[\"x\"]}","id":1,` +
+					`"items":"[{\"opts\":[{\"k\":\"v\"}],\"sku\":\"s1\"},{\"sku\":\"s2\"}]"}]`,
+			},
+		},
+		{
+			name:   "explode",
+			cfg:    &NestedConfig{Strategy: NestedExplode, Keys: []string{"id"}},
+			scheme: "postgresql",
+			expected: map[string]string{
+				"orders":            `[{"customer_name":"a","customer_tags":"[\"x\"]","id":1}]`,
+				"orders_items":      `[{"_index":0,"orders_id":1,"sku":"s1"},{"_index":1,"orders_id":1,"sku":"s2"}]`,
+				"orders_items_opts": `[{"_index":0,"k":"v","orders_id":1,"orders_items_index":0}]`,
+			},
+		},
+		{
+			name: "child tables",
+			cfg: &NestedConfig{Keys: []string{"id"}, Children: []*ChildTable{
+				{Field: "items", Table: "order_items", ForeignKey: "order_id"},
+				{Field: "items_opts", Table: "item_options"},
+			}},
+			scheme: "postgresql",
+			expected: map[string]string{
+				"orders":       `[{"customer_name":"a","customer_tags":"[\"x\"]","id":1}]`,
+				"order_items":  `[{"_index":0,"order_id":1,"sku":"s1"},{"_index":1,"order_id":1,"sku":"s2"}]`,
+				"item_options": `[{"_index":0,"k":"v","order_id":1,"order_items_index":0}]`,
+			},
+		},
+		{
+			name:     "storage override",
+			cfg:      &NestedConfig{Strategy: NestedJSON, Storage: map[string]NestedStrategy{"mongodb": NestedNative}},
+			scheme:   "mongodb",
+			expected: map[string]string{"orders": data},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			tables, err := tcase.cfg.split(tcase.scheme, "orders", []byte(data))
+			if err != nil {
+				t.Fatalf("failed to split records: %v", err)
+			}
+
+			if len(tables) != len(tcase.expected) || tables[0].table != "orders" {
+				t.Fatalf("expected tables %v, got %d tables starting with %q", tcase.expected, len(tables),
+					tables[0].table)
+			}
+
+			for _, table := range tables {
+				if string(table.data) != tcase.expected[table.table] {
+					t.Fatalf("expected %s for %q, got %s", tcase.expected[table.table], table.table, table.data)
+				}
+			}
+		})
+	}
+
+	t.Run("generated keys", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &NestedConfig{GeneratedKey: "uid", Children: []*ChildTable{{Field: "items", ForeignKey: "order_uid"}}}
+		if err := cfg.validate(); err != nil {
+			t.Fatalf("failed to validate: %v", err)
+		}
+
+		split := func(data string) (map[string]interface{}, map[string]interface{}) {
+			tables, err := cfg.split("postgresql", "orders", []byte(data))
+			if err != nil {
+				t.Fatalf("failed to split records: %v", err)
+			}
+
+			var parents, children []map[string]interface{}
+			if err := json.Unmarshal(tables[0].data, &parents); err != nil {
+				t.Fatalf("failed to decode parents: %v", err)
+			}
+
+			if err := json.Unmarshal(tables[1].data, &children); err != nil {
+				t.Fatalf("failed to decode children: %v", err)
+			}
+
+			return parents[0], children[0]
+		}
+
+		parent, child := split(`{"n":1,"items":[{"sku":"s1"}]}`)
+		if parent["uid"] == nil || child["order_uid"] != parent["uid"] {
+			t.Fatalf("expected the child to reference the generated key %v, got %v", parent["uid"], child["order_uid"])
+		}
+
+		// Re-ingesting the same record generates the same key.
+		if again, _ := split(`{"items":[{"sku":"s1"}],"n":1}`); again["uid"] != parent["uid"] {
+			t.Fatalf("expected the same generated key %v, got %v", parent["uid"], again["uid"])
+		}
+
+		if other, _ := split(`{"n":2,"items":[{"sku":"s1"}]}`); other["uid"] == parent["uid"] {
+			t.Fatalf("expected a different generated key for a different record")
+		}
+	})
+
+	t.Run("foreign key requires a single key", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &NestedConfig{Keys: []string{"a", "b"}, Children: []*ChildTable{{Field: "items", ForeignKey: "fk"}}}
+		if err := cfg.validate(); !errors.Is(err, ErrInvalidChildTable) {
+			t.Fatalf("expected ErrInvalidChildTable, got %v", err)
+		}
+	})
+
+	t.Run("invalid strategy", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &NestedConfig{Storage: map[string]NestedStrategy{"postgresql": "zip"}}
+		if err := cfg.validate(); !errors.Is(err, ErrInvalidNestedStrategy) {
+			t.Fatalf("expected ErrInvalidNestedStrategy, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// defaultNotifyChannelPrefix is the default prefix of the notification channel of a table.
+const defaultNotifyChannelPrefix = "gidari_"
+
+// NotifyConfig emits a notification for every table that received data once the data is committed, so that downstream
+// consumers can react to fresh data without polling. Notifications are only supported by Postgres, where they are sent
+// with "NOTIFY" and received with "LISTEN <channel>". The payload is a JSON object with the "runId" of the transport
+// operation and the "table".
+type NotifyConfig struct {
+	// ChannelPrefix is prepended to the name of a table to form its channel. Defaults to "gidari_".
+	ChannelPrefix *string `yaml:"channelPrefix"`
+}
+
+// channel will return the notification channel of a table.
+func (nc *NotifyConfig) channel(table string) string {
+	if nc.ChannelPrefix == nil {
+		return defaultNotifyChannelPrefix + table
+	}
+
+	return *nc.ChannelPrefix + table
+}
+
+// notifyPayload is the payload of a notification.
+type notifyPayload struct {
+	RunID string `json:"runId"`
+	Table string `json:"table"`
+}
+
+// tableSet is a set of tables that is safe for concurrent use.
+type tableSet struct {
+	mu     sync.Mutex
+	tables map[string]bool
+}
+
+// add will add a table to the set.
+func (ts *tableSet) add(table string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.tables == nil {
+		ts.tables = make(map[string]bool)
+	}
+
+	ts.tables[table] = true
+}
+
+// list will return the tables of the set, sorted by name.
+func (ts *tableSet) list() []string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	tables := make([]string, 0, len(ts.tables))
+	for table := range ts.tables {
+		tables = append(tables, table)
+	}
+
+	sort.Strings(tables)
+
+	return tables
+}
+
+// notifyTxFn will return a transaction function that notifies the channel of each table. Notifications sent within the
+// transaction are only delivered if it is committed. Storage devices that do not support notifications are skipped.
+func notifyTxFn(cfg *Config, runID string, tables []string) func(context.Context, repository.Generic) error {
+	return func(sctx context.Context, repo repository.Generic) error {
+		for _, table := range tables {
+			payload, err := json.Marshal(notifyPayload{RunID: runID, Table: table})
+			if err != nil {
+				return fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+			}
+
+			req := &storage.NotifyRequest{Channel: cfg.Notify.channel(table), Payload: string(payload)}
+
+			err = repo.Notify(sctx, req)
+			if errors.Is(err, storage.ErrNotifyNotSupported) {
+				return nil
+			}
+
+			if err != nil {
+				return fmt.Errorf("unable to notify channel %q: %w", req.Channel, err)
+			}
+
+			msg := fmt.Sprintf("notification queued on %q: %s", storage.Scheme(repo.Type()), req.Channel)
+			cfg.Logger.Info(tools.LogFormatter{Msg: msg}.String())
+		}
+
+		return nil
+	}
+}
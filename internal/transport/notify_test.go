@@ -0,0 +1,101 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// notifyRepository is a repository that records its notifications.
+type notifyRepository struct {
+	repository.Generic
+	storageType uint8
+	notified    []*storage.NotifyRequest
+}
+
+func (repo *notifyRepository) Notify(_ context.Context, req *storage.NotifyRequest) error {
+	if repo.storageType != storage.PostgresType {
+		return fmt.Errorf("%w for %q", storage.ErrNotifyNotSupported, storage.Scheme(repo.storageType))
+	}
+
+	repo.notified = append(repo.notified, req)
+
+	return nil
+}
+
+func (repo *notifyRepository) Type() uint8 { return repo.storageType }
+
+func TestNotifyTxFn(t *testing.T) {
+	t.Parallel()
+
+	prefix := "fresh_"
+
+	for _, tcase := range []struct {
+		name        string
+		cfg         *NotifyConfig
+		storageType uint8
+		expected    []*storage.NotifyRequest
+	}{
+		{
+			name:        "default channel prefix",
+			cfg:         &NotifyConfig{},
+			storageType: storage.PostgresType,
+			expected: []*storage.NotifyRequest{
+				{Channel: "gidari_candles", Payload: `{"runId":"run","table":"candles"}`},
+				{Channel: "gidari_trades", Payload: `{"runId":"run","table":"trades"}`},
+			},
+		},
+		{
+			name:        "channel prefix",
+			cfg:         &NotifyConfig{ChannelPrefix: &prefix},
+			storageType: storage.PostgresType,
+			expected: []*storage.NotifyRequest{
+				{Channel: "fresh_candles", Payload: `{"runId":"run","table":"candles"}`},
+				{Channel: "fresh_trades", Payload: `{"runId":"run","table":"trades"}`},
+			},
+		},
+		{
+			name:        "not supported",
+			cfg:         &NotifyConfig{},
+			storageType: storage.MongoType,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			tables := new(tableSet)
+			for _, table := range []string{"trades", "candles", "trades"} {
+				tables.add(table)
+			}
+
+			cfg := &Config{Notify: tcase.cfg, Logger: logger}
+			repo := &notifyRepository{storageType: tcase.storageType}
+
+			if err := notifyTxFn(cfg, "run", tables.list())(context.Background(), repo); err != nil {
+				t.Fatalf("failed to notify: %v", err)
+			}
+
+			if !reflect.DeepEqual(repo.notified, tcase.expected) {
+				t.Fatalf("expected notifications %v, got %v", tcase.expected, repo.notified)
+			}
+		})
+	}
+}
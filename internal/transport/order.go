@@ -0,0 +1,99 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import "sync"
+
+// orderedSequence is the repository jobs of a flattened request, by part, that wait to be written.
+type orderedSequence struct {
+	parts map[int]*repoJob
+	next  int
+
+	// total is the number of parts of the flattened request, or -1 until its last job has been received or it has
+	// failed.
+	total int
+}
+
+// recordOrder writes the repository jobs of the flattened requests of an ordered request in the order of the
+// flattened requests, e.g. the pages or time chunks of the request, and of their parts, e.g. the batches of a SQL
+// source, rather than in the order that they complete. The transactions of the storage devices run their functions
+// in the order they are sent, so the jobs are written one at a time: a job is held until every job before it has been
+// written, or its flattened request has failed.
+type recordOrder struct {
+	mu        sync.Mutex
+	next      int
+	sequences map[int]*orderedSequence
+}
+
+// newRecordOrder will return the order of the records of a request, or nil if the request is not ordered.
+func (req *Request) newRecordOrder() *recordOrder {
+	if !req.Ordered {
+		return nil
+	}
+
+	return &recordOrder{sequences: make(map[int]*orderedSequence)}
+}
+
+// sequence will return the sequence of a flattened request.
+func (ord *recordOrder) sequence(seq int) *orderedSequence {
+	sequence := ord.sequences[seq]
+	if sequence == nil {
+		sequence = &orderedSequence{parts: make(map[int]*repoJob), total: -1}
+		ord.sequences[seq] = sequence
+	}
+
+	return sequence
+}
+
+// write will write a repository job once the jobs before it are written, along with the held jobs that it releases. A
+// nil order writes the job right away. A job that marks the failure of its flattened request is not written, it only
+// releases the jobs after it.
+func (ord *recordOrder) write(job *repoJob, write func(*repoJob)) {
+	if ord == nil {
+		write(job)
+
+		return
+	}
+
+	ord.mu.Lock()
+	defer ord.mu.Unlock()
+
+	sequence := ord.sequence(job.seq)
+
+	switch {
+	case job.failed:
+		sequence.total = job.part
+	case !job.partial:
+		sequence.total = job.part + 1
+		sequence.parts[job.part] = job
+	default:
+		sequence.parts[job.part] = job
+	}
+
+	for {
+		sequence := ord.sequence(ord.next)
+
+		for {
+			held, ok := sequence.parts[sequence.next]
+			if !ok {
+				break
+			}
+
+			write(held)
+			delete(sequence.parts, sequence.next)
+			sequence.next++
+		}
+
+		if sequence.total < 0 || sequence.next < sequence.total {
+			return
+		}
+
+		delete(ord.sequences, ord.next)
+		ord.next++
+	}
+}
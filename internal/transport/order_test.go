@@ -0,0 +1,95 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRecordOrder(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name    string
+		ordered bool
+		jobs    []*repoJob
+		written []string
+	}{
+		{
+			name: "unordered",
+			jobs: []*repoJob{
+				{seq: 1},
+				{seq: 0},
+			},
+			written: []string{"1.0", "0.0"},
+		},
+		{
+			name:    "out of order",
+			ordered: true,
+			jobs: []*repoJob{
+				{seq: 2},
+				{seq: 1},
+				{seq: 0},
+			},
+			written: []string{"0.0", "1.0", "2.0"},
+		},
+		{
+			name:    "parts",
+			ordered: true,
+			jobs: []*repoJob{
+				{seq: 1},
+				{seq: 0, part: 1, partial: true},
+				{seq: 0, part: 2},
+				{seq: 0, part: 0, partial: true},
+			},
+			written: []string{"0.0", "0.1", "0.2", "1.0"},
+		},
+		{
+			name:    "failed",
+			ordered: true,
+			jobs: []*repoJob{
+				{seq: 2},
+				{seq: 1, part: 1, failed: true},
+				{seq: 0},
+				{seq: 1, part: 0, partial: true},
+			},
+			written: []string{"0.0", "1.0", "2.0"},
+		},
+		{
+			name:    "held until the failed job",
+			ordered: true,
+			jobs: []*repoJob{
+				{seq: 1},
+				{seq: 0, part: 0, partial: true},
+			},
+			written: []string{"0.0"},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			order := (&Request{Ordered: tcase.ordered}).newRecordOrder()
+
+			var written []string
+
+			for _, job := range tcase.jobs {
+				order.write(job, func(job *repoJob) {
+					written = append(written, fmt.Sprintf("%d.%d", job.seq, job.part))
+				})
+			}
+
+			if !reflect.DeepEqual(written, tcase.written) {
+				t.Fatalf("expected %v to be written, got %v", tcase.written, written)
+			}
+		})
+	}
+}
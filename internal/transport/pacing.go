@@ -0,0 +1,99 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/alpine-hodler/gidari/tools"
+	"golang.org/x/time/rate"
+)
+
+// randomDelay will return a random delay of up to the limit, or zero if the limit is not positive.
+func randomDelay(limit time.Duration) time.Duration {
+	if limit <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // The delay spreads requests over time, it is not a secret.
+	return time.Duration(rand.Int63n(int64(limit)))
+}
+
+// sleepContext will block for the duration, or until the context is done.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jitterLimiter is a rate limiter that delays each request by a random time after the rate limit allows it, so that
+// the requests that the rate limit releases at once are spread out.
+type jitterLimiter struct {
+	web.RateLimiter
+	jitter time.Duration
+}
+
+// WaitN will block until the rate limit allows n tokens, and then for a random time of up to the jitter.
+func (jl *jitterLimiter) WaitN(ctx context.Context, n int) error {
+	if err := jl.RateLimiter.WaitN(ctx, n); err != nil {
+		return err
+	}
+
+	if err := sleepContext(ctx, randomDelay(jl.jitter)); err != nil {
+		return fmt.Errorf("rate limiter jitter: %w", err)
+	}
+
+	return nil
+}
+
+// pace will spread out the requests of a new rate limiter, as configured by the rate limit: a smooth rate limiter
+// starts without its burst, so that the requests that are queued at the start of a run are released at the sustained
+// rate rather than all at once, and a jittered rate limiter delays each request by a random time.
+func (rl *RateLimitConfig) pace(limiter *rate.Limiter, wrapped web.RateLimiter) web.RateLimiter {
+	if rl.Smooth {
+		limiter.AllowN(time.Now(), limiter.Burst())
+	}
+
+	if rl.Jitter > 0 {
+		return &jitterLimiter{RateLimiter: wrapped, jitter: rl.Jitter}
+	}
+
+	return wrapped
+}
+
+// waitStartJitter will delay the start of a transport operation by a random time of up to the configuration's start
+// jitter, so that the runs of many schedules, e.g. at the top of every hour, do not reach the web API at once.
+func (cfg *Config) waitStartJitter(ctx context.Context) error {
+	delay := randomDelay(cfg.StartJitter)
+	if delay <= 0 {
+		return nil
+	}
+
+	logInfo := tools.LogFormatter{Msg: fmt.Sprintf("delaying the start of the run by %v", delay.Round(time.Millisecond))}
+	cfg.Logger.Info(logInfo.String())
+
+	if err := sleepContext(ctx, delay); err != nil {
+		return fmt.Errorf("start jitter: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimitPacing(t *testing.T) {
+	t.Parallel()
+
+	burst, period := 3, 20*time.Millisecond
+
+	for _, tcase := range []struct {
+		name string
+		cfg  *RateLimitConfig
+		min  time.Duration
+		max  time.Duration
+	}{
+		{
+			name: "burst",
+			cfg:  &RateLimitConfig{Burst: &burst, Period: &period},
+			max:  2 * period,
+		},
+		{
+			name: "smooth",
+			cfg:  &RateLimitConfig{Burst: &burst, Period: &period, Smooth: true},
+			min:  2 * period,
+		},
+		{
+			name: "smooth windows",
+			cfg: &RateLimitConfig{Burst: &burst, Period: &period, Smooth: true, Windows: []*RateWindow{
+				{Start: "00:00", End: "00:00", Burst: &burst},
+			}},
+			min: 2 * period,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			limiter := tcase.cfg.rateLimiter()
+			start := time.Now()
+
+			for i := 0; i < burst; i++ {
+				if err := limiter.WaitN(context.Background(), 1); err != nil {
+					t.Fatalf("error waiting for the rate limiter: %v", err)
+				}
+			}
+
+			elapsed := time.Since(start)
+			if elapsed < tcase.min || (tcase.max > 0 && elapsed > tcase.max) {
+				t.Fatalf("expected %d requests to take between %v and %v, took %v", burst, tcase.min, tcase.max,
+					elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimitSharedByChunks(t *testing.T) {
+	t.Parallel()
+
+	const period = 20 * time.Millisecond
+
+	var (
+		mu    sync.Mutex
+		times []time.Time
+	)
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		mu.Unlock()
+
+		fmt.Fprint(writer, `[{"id": "a"}]`)
+	}))
+	defer testServer.Close()
+
+	// The 6 chunks of the request are released at the sustained rate of the shared limiter, one per period.
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 3
+  period: %s
+  smooth: true
+requests:
+  - endpoint: /candles
+    table: candles
+    query:
+      start: "2022-05-10T00:00:00Z"
+      end: "2022-05-10T00:06:00Z"
+    timeseries:
+      startName: start
+      endName: end
+      period: 60
+`, testServer.URL, period)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if err := Upsert(context.Background(), cfg); err != nil {
+		t.Fatalf("error upserting: %v", err)
+	}
+
+	if len(times) != 6 {
+		t.Fatalf("expected 6 web requests, got %d", len(times))
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	// Allow for the limiter's reservations being made slightly before the requests are sent.
+	if spread := times[5].Sub(times[0]); spread < 4*period {
+		t.Fatalf("expected the chunks to be paced over at least %v, took %v", 4*period, spread)
+	}
+}
+
+func TestRateLimitJitter(t *testing.T) {
+	t.Parallel()
+
+	burst, period, jitter := 10, time.Millisecond, 20*time.Millisecond
+
+	limiter := (&RateLimitConfig{Burst: &burst, Period: &period, Jitter: jitter}).rateLimiter()
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+
+		if err := limiter.WaitN(context.Background(), 1); err != nil {
+			t.Fatalf("error waiting for the rate limiter: %v", err)
+		}
+
+		if elapsed := time.Since(start); elapsed > jitter+10*time.Millisecond {
+			t.Fatalf("expected a delay of up to %v, got %v", jitter, elapsed)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := limiter.WaitN(ctx, 1); err == nil {
+		t.Fatal("expected an error waiting with a canceled context")
+	}
+}
+
+func TestPacingValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name   string
+		config string
+	}{
+		{"jitter", "rateLimit: {burst: 1, period: 1s, jitter: -1s}"},
+		{"start jitter", "rateLimit: {burst: 1, period: 1s}\nstartJitter: -1s"},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte("url: https://api.example.com\n" + tcase.config + "\nrequests: [{endpoint: /trades}]\n"))
+			if !errors.Is(err, ErrInvalidRateLimit) {
+				t.Fatalf("expected error %v, got %v", ErrInvalidRateLimit, err)
+			}
+		})
+	}
+}
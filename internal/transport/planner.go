@@ -0,0 +1,416 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// defaultPlannerTargetLatency is the default latency of a web request that chunk sizes are planned for.
+	defaultPlannerTargetLatency = 2 * time.Second
+
+	// defaultPlannerMaxErrorRate is the default share of failed web requests above which chunks and concurrency are
+	// reduced.
+	defaultPlannerMaxErrorRate = 0.05
+
+	// plannerMinFactor and plannerMaxFactor bound how much a chunk period changes from one run to the next.
+	plannerMinFactor = 0.5
+	plannerMaxFactor = 2
+
+	plannerStatsFileMode = 0o600
+)
+
+var ErrInvalidPlanner = fmt.Errorf("invalid planner")
+
+// InvalidPlannerError wraps an error with ErrInvalidPlanner.
+func InvalidPlannerError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPlanner, reason)
+}
+
+// PlannerConfig plans the chunk periods of the timeseries requests and the in-flight requests to the web API from the
+// statistics of the previous run, instead of the static periods and one in-flight request per web worker. The
+// observed latency, page size, and error rate of every endpoint are kept in a stats file after every run, including
+// failed runs. Each run, the period of a timeseries is halved if the share of its failed requests was above the maximum
+// error rate or its pages reached the maximum page size, and otherwise scaled towards the target latency, by at most
+// half or double. The in-flight requests to the web API are halved if the share of failed requests of the run was
+// above the maximum error rate, and increased by one while the average latency is within the target, up to the number
+// of web workers and the configured concurrency.
+type PlannerConfig struct {
+	// StatsFile is the path to the file where the statistics of the previous run are kept.
+	StatsFile string `yaml:"statsFile"`
+
+	// TargetLatency is the latency of a web request that the chunk periods are planned for. Defaults to 2s.
+	TargetLatency time.Duration `yaml:"targetLatency"`
+
+	// MaxErrorRate is the share of failed web requests, between 0 and 1, above which the chunk periods and in-flight
+	// requests are reduced. Defaults to 0.05.
+	MaxErrorRate float64 `yaml:"maxErrorRate"`
+
+	// MaxPageSize is the most records that the web API returns for a request, if it truncates larger responses.
+	// Chunk periods are not grown beyond it, and are halved if their pages reached it.
+	MaxPageSize int `yaml:"maxPageSize"`
+
+	// MinPeriod and MaxPeriod bound the planned chunk periods, e.g. to the range that the web API allows for a
+	// request. They default to 1s and no bound.
+	MinPeriod time.Duration `yaml:"minPeriod"`
+	MaxPeriod time.Duration `yaml:"maxPeriod"`
+}
+
+func (pc *PlannerConfig) validate() error {
+	if pc == nil {
+		return nil
+	}
+
+	if pc.StatsFile == "" {
+		return InvalidPlannerError("statsFile is required")
+	}
+
+	if pc.TargetLatency < 0 || pc.MinPeriod < 0 || pc.MaxPeriod < 0 {
+		return InvalidPlannerError("targetLatency, minPeriod, and maxPeriod can not be negative")
+	}
+
+	if pc.MaxPeriod > 0 && pc.MinPeriod > pc.MaxPeriod {
+		return InvalidPlannerError("minPeriod can not be greater than maxPeriod")
+	}
+
+	if pc.MaxErrorRate < 0 || pc.MaxErrorRate > 1 {
+		return InvalidPlannerError("maxErrorRate must be between 0 and 1")
+	}
+
+	if pc.MaxPageSize < 0 {
+		return InvalidPlannerError("maxPageSize can not be negative")
+	}
+
+	return nil
+}
+
+func (pc *PlannerConfig) targetLatency() time.Duration {
+	if pc.TargetLatency == 0 {
+		return defaultPlannerTargetLatency
+	}
+
+	return pc.TargetLatency
+}
+
+func (pc *PlannerConfig) maxErrorRate() float64 {
+	if pc.MaxErrorRate == 0 {
+		return defaultPlannerMaxErrorRate
+	}
+
+	return pc.MaxErrorRate
+}
+
+// EndpointStats are the statistics of the web requests to an endpoint during a run.
+type EndpointStats struct {
+	// Requests is the number of web requests made, and Errors the number of them that failed.
+	Requests int64 `yaml:"requests"`
+	Errors   int64 `yaml:"errors"`
+
+	// Latency is the average latency of the successful web requests.
+	Latency time.Duration `yaml:"latency"`
+
+	// Records is the number of records fetched.
+	Records int64 `yaml:"records"`
+
+	// Period is the chunk period of the endpoint's timeseries in seconds, if it has one.
+	Period int32 `yaml:"period,omitempty"`
+
+	// latency is the total latency of the successful web requests of a run in progress.
+	latency time.Duration
+}
+
+// errorRate will return the share of the web requests that failed.
+func (es *EndpointStats) errorRate() float64 {
+	if es.Requests == 0 {
+		return 0
+	}
+
+	return float64(es.Errors) / float64(es.Requests)
+}
+
+// pageSize will return the average number of records of a successful web request.
+func (es *EndpointStats) pageSize() float64 {
+	if es.Requests == es.Errors {
+		return 0
+	}
+
+	return float64(es.Records) / float64(es.Requests-es.Errors)
+}
+
+// PlannerStats are the statistics of a run, which the next run is planned from.
+type PlannerStats struct {
+	// Time is when the run finished.
+	Time time.Time `yaml:"time"`
+
+	// InFlight is the number of in-flight requests to the web API that the run was planned with, or zero if they
+	// were only limited by the web workers.
+	InFlight int `yaml:"inFlight"`
+
+	// Endpoints are the statistics of each endpoint, keyed by endpoint. Endpoints that the run did not request keep
+	// the statistics of the last run that did.
+	Endpoints map[string]*EndpointStats `yaml:"endpoints"`
+}
+
+// runStats collects the statistics of the endpoints during a run. A nil runStats is valid and collects nothing.
+type runStats struct {
+	mu    sync.Mutex
+	stats *PlannerStats
+}
+
+// observe will count a web request to an endpoint, with its latency and the number of records of its response data,
+// or the error that it failed with.
+func (rs *runStats) observe(endpoint string, latency time.Duration, data []byte, err error) {
+	if rs == nil {
+		return
+	}
+
+	var records int64
+	if err == nil {
+		records = countRecords(data)
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	stats, ok := rs.stats.Endpoints[endpoint]
+	if !ok {
+		stats = new(EndpointStats)
+		rs.stats.Endpoints[endpoint] = stats
+	}
+
+	stats.Requests++
+
+	if err != nil {
+		stats.Errors++
+
+		return
+	}
+
+	stats.latency += latency
+	stats.Records += records
+}
+
+// attach will collect the statistics of the web requests of the flattened requests.
+func (rs *runStats) attach(flattenedRequests []*flattenedRequest) {
+	if rs == nil {
+		return
+	}
+
+	for _, req := range flattenedRequests {
+		if req.source == nil {
+			req.stats = rs
+		}
+	}
+}
+
+// readStats will read the statistics of the previous run. If the file does not exist, there are no statistics.
+func (pc *PlannerConfig) readStats() (*PlannerStats, error) {
+	stats := &PlannerStats{Endpoints: make(map[string]*EndpointStats)}
+
+	bytes, err := os.ReadFile(pc.StatsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read planner stats file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(bytes, stats); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal planner stats: %w", err)
+	}
+
+	if stats.Endpoints == nil {
+		stats.Endpoints = make(map[string]*EndpointStats)
+	}
+
+	return stats, nil
+}
+
+// planPeriod will return the chunk period of a timeseries planned from the statistics of its endpoint, in seconds.
+// Without statistics of a previous period, the configured period is planned.
+func (pc *PlannerConfig) planPeriod(configured int32, stats *EndpointStats) int32 {
+	if stats == nil || stats.Period <= 0 || stats.Requests == 0 {
+		return configured
+	}
+
+	factor := 1.0
+
+	switch pageSize := stats.pageSize(); {
+	case stats.errorRate() > pc.maxErrorRate():
+		factor = plannerMinFactor
+	case pc.MaxPageSize > 0 && pageSize >= float64(pc.MaxPageSize):
+		// The pages were likely truncated by the web API, so the chunks must be smaller to fetch every record.
+		factor = plannerMinFactor
+	case stats.Latency > 0:
+		factor = math.Max(plannerMinFactor, math.Min(plannerMaxFactor,
+			pc.targetLatency().Seconds()/stats.Latency.Seconds()))
+
+		if pc.MaxPageSize > 0 && pageSize > 0 {
+			factor = math.Min(factor, float64(pc.MaxPageSize)/pageSize)
+		}
+	}
+
+	period := math.Round(float64(stats.Period) * factor)
+
+	minPeriod := math.Max(1, pc.MinPeriod.Seconds())
+	if period < minPeriod {
+		period = minPeriod
+	}
+
+	if pc.MaxPeriod > 0 && period > pc.MaxPeriod.Seconds() {
+		period = math.Max(minPeriod, math.Floor(pc.MaxPeriod.Seconds()))
+	}
+
+	if period > math.MaxInt32 {
+		period = math.MaxInt32
+	}
+
+	return int32(period)
+}
+
+// planInFlight will return the number of in-flight requests to the web API planned from the statistics of the
+// previous run, or zero to only limit them by the web workers. The in-flight requests are at most the number of web
+// workers, or the configured limit of the host.
+func (pc *PlannerConfig) planInFlight(stats *PlannerStats, maximum int) int {
+	var requests, errs int64
+
+	var latency time.Duration
+
+	for _, endpoint := range stats.Endpoints {
+		requests += endpoint.Requests
+		errs += endpoint.Errors
+		latency += endpoint.Latency * time.Duration(endpoint.Requests-endpoint.Errors)
+	}
+
+	if requests == 0 {
+		return 0
+	}
+
+	inFlight := stats.InFlight
+	if inFlight == 0 || inFlight > maximum {
+		inFlight = maximum
+	}
+
+	switch {
+	case float64(errs)/float64(requests) > pc.maxErrorRate():
+		inFlight /= 2
+	case requests > errs && latency/time.Duration(requests-errs) <= pc.targetLatency():
+		inFlight++
+	}
+
+	return clampWorkers(inFlight, 1, maximum)
+}
+
+// plan will plan the chunk periods of the timeseries requests and the in-flight requests to the web API of a run from
+// the statistics of the previous run, returning the statistics of the run, or nil if the run is not planned.
+func (pc *PlannerConfig) plan(cfg *Config) (*runStats, error) {
+	cfg.plannedInFlight = 0
+
+	if pc == nil {
+		return nil, nil
+	}
+
+	previous, err := pc.readStats()
+	if err != nil {
+		return nil, err
+	}
+
+	current := &PlannerStats{Endpoints: make(map[string]*EndpointStats)}
+
+	for _, req := range cfg.Requests {
+		if req.Timeseries == nil {
+			continue
+		}
+
+		req.Timeseries.planned = pc.planPeriod(req.Timeseries.Period, previous.Endpoints[req.Endpoint])
+		current.Endpoints[req.Endpoint] = &EndpointStats{Period: req.Timeseries.planned}
+
+		if req.Timeseries.planned != req.Timeseries.Period {
+			cfg.Logger.Info(tools.LogFormatter{Msg: fmt.Sprintf("planned chunks of %ds for %s", req.Timeseries.planned,
+				req.Endpoint)}.String())
+		}
+	}
+
+	maximum := runtime.NumCPU()
+	if cc := cfg.Concurrency; cc != nil && cfg.URL != nil {
+		if limit := cc.Hosts[cfg.URL.Hostname()]; limit > 0 && limit < maximum {
+			maximum = limit
+		}
+
+		if limit := cc.Hosts[cfg.URL.Host]; limit > 0 && limit < maximum {
+			maximum = limit
+		}
+
+		if cc.MaxInFlight > 0 && cc.MaxInFlight < maximum {
+			maximum = cc.MaxInFlight
+		}
+	}
+
+	cfg.plannedInFlight = pc.planInFlight(previous, maximum)
+	current.InFlight = cfg.plannedInFlight
+
+	if cfg.plannedInFlight > 0 {
+		cfg.Logger.Info(tools.LogFormatter{Msg: fmt.Sprintf("planned %d in-flight web requests",
+			cfg.plannedInFlight)}.String())
+	}
+
+	return &runStats{stats: current}, nil
+}
+
+// record will write the statistics of a run to the stats file, keeping the statistics of the endpoints that the run
+// did not request.
+func (pc *PlannerConfig) record(rs *runStats) error {
+	if pc == nil || rs == nil {
+		return nil
+	}
+
+	stats, err := pc.readStats()
+	if err != nil {
+		return err
+	}
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for endpoint, current := range rs.stats.Endpoints {
+		if current.Requests == 0 {
+			continue
+		}
+
+		if succeeded := current.Requests - current.Errors; succeeded > 0 {
+			current.Latency = current.latency / time.Duration(succeeded)
+		}
+
+		stats.Endpoints[endpoint] = current
+	}
+
+	stats.Time = time.Now().UTC()
+	stats.InFlight = rs.stats.InFlight
+
+	bytes, err := yaml.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("unable to marshal planner stats: %w", err)
+	}
+
+	if err := os.WriteFile(pc.StatsFile, bytes, plannerStatsFileMode); err != nil {
+		return fmt.Errorf("unable to write planner stats file: %w", err)
+	}
+
+	return nil
+}
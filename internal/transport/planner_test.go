@@ -0,0 +1,193 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlannerPlanPeriod(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		planner  *PlannerConfig
+		stats    *EndpointStats
+		expected int32
+	}{
+		{
+			name:     "no stats",
+			planner:  &PlannerConfig{},
+			expected: 3600,
+		},
+		{
+			name:     "fast",
+			planner:  &PlannerConfig{},
+			stats:    &EndpointStats{Requests: 10, Latency: 100 * time.Millisecond, Records: 100, Period: 600},
+			expected: 1200,
+		},
+		{
+			name:     "slow",
+			planner:  &PlannerConfig{},
+			stats:    &EndpointStats{Requests: 10, Latency: 3 * time.Second, Records: 100, Period: 600},
+			expected: 400,
+		},
+		{
+			name:     "errors",
+			planner:  &PlannerConfig{},
+			stats:    &EndpointStats{Requests: 10, Errors: 2, Latency: 100 * time.Millisecond, Period: 600},
+			expected: 300,
+		},
+		{
+			name:     "truncated pages",
+			planner:  &PlannerConfig{MaxPageSize: 300},
+			stats:    &EndpointStats{Requests: 10, Latency: 100 * time.Millisecond, Records: 3000, Period: 600},
+			expected: 300,
+		},
+		{
+			name:     "page size bound",
+			planner:  &PlannerConfig{MaxPageSize: 300},
+			stats:    &EndpointStats{Requests: 10, Latency: 100 * time.Millisecond, Records: 2000, Period: 600},
+			expected: 900,
+		},
+		{
+			name:     "period bounds",
+			planner:  &PlannerConfig{MaxPeriod: 15 * time.Minute},
+			stats:    &EndpointStats{Requests: 10, Latency: 100 * time.Millisecond, Records: 100, Period: 600},
+			expected: 900,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tcase.planner.planPeriod(3600, tcase.stats); got != tcase.expected {
+				t.Fatalf("expected a period of %d, got %d", tcase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlannerPlanInFlight(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		stats    *PlannerStats
+		expected int
+	}{
+		{
+			name:     "no stats",
+			stats:    &PlannerStats{},
+			expected: 0,
+		},
+		{
+			name: "errors",
+			stats: &PlannerStats{InFlight: 6, Endpoints: map[string]*EndpointStats{
+				"/a": {Requests: 10, Errors: 1, Latency: time.Second},
+				"/b": {Requests: 10, Errors: 1, Latency: time.Second},
+			}},
+			expected: 3,
+		},
+		{
+			name: "within target",
+			stats: &PlannerStats{InFlight: 3, Endpoints: map[string]*EndpointStats{
+				"/a": {Requests: 10, Latency: time.Second},
+			}},
+			expected: 4,
+		},
+		{
+			name: "slow",
+			stats: &PlannerStats{InFlight: 3, Endpoints: map[string]*EndpointStats{
+				"/a": {Requests: 10, Latency: 5 * time.Second},
+			}},
+			expected: 3,
+		},
+		{
+			name: "maximum",
+			stats: &PlannerStats{Endpoints: map[string]*EndpointStats{
+				"/a": {Requests: 10, Latency: time.Second},
+			}},
+			expected: 8,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := new(PlannerConfig).planInFlight(tcase.stats, 8); got != tcase.expected {
+				t.Fatalf("expected %d in-flight requests, got %d", tcase.expected, got)
+			}
+		})
+	}
+}
+
+func TestPlannerRecord(t *testing.T) {
+	t.Parallel()
+
+	statsFile := filepath.Join(t.TempDir(), "stats.yml")
+
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+planner: {statsFile: %q, targetLatency: 1s}
+requests:
+  - endpoint: /candles
+    table: candles
+    query: {start: "2022-01-01T00:00:00Z", end: "2022-01-02T00:00:00Z"}
+    timeseries: {startName: start, endName: end, period: 3600}
+`, statsFile)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	// The first run is planned with the configured period.
+	stats, err := cfg.Planner.plan(cfg)
+	if err != nil {
+		t.Fatalf("error planning run: %v", err)
+	}
+
+	if period := cfg.Requests[0].Timeseries.period(); period != 3600 {
+		t.Fatalf("expected the configured period of 3600, got %d", period)
+	}
+
+	stats.observe("/candles", 4*time.Second, []byte(`[{"id": 1}, {"id": 2}]`), nil)
+	stats.observe("/candles", 2*time.Second, []byte(`[{"id": 3}]`), nil)
+	stats.observe("/candles", 0, nil, errors.New("timeout"))
+
+	if err := cfg.Planner.record(stats); err != nil {
+		t.Fatalf("error recording stats: %v", err)
+	}
+
+	recorded, err := cfg.Planner.readStats()
+	if err != nil {
+		t.Fatalf("error reading stats: %v", err)
+	}
+
+	candles := recorded.Endpoints["/candles"]
+	if candles == nil || candles.Requests != 3 || candles.Errors != 1 || candles.Records != 3 ||
+		candles.Latency != 3*time.Second || candles.Period != 3600 {
+		t.Fatalf("unexpected stats recorded: %+v", candles)
+	}
+
+	// The next run halves the period, since a third of the requests failed.
+	if _, err := cfg.Planner.plan(cfg); err != nil {
+		t.Fatalf("error planning run: %v", err)
+	}
+
+	if period := cfg.Requests[0].Timeseries.period(); period != 1800 {
+		t.Fatalf("expected a planned period of 1800, got %d", period)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+//go:embed presets/*.yml
+var presetFS embed.FS
+
+var (
+	ErrPresetNotFound        = fmt.Errorf("preset not found")
+	ErrPresetRequestNotFound = fmt.Errorf("preset request not found")
+)
+
+// PresetNotFoundError is returned when a preset is not defined.
+func PresetNotFoundError(name string) error {
+	return fmt.Errorf("%w: %s", ErrPresetNotFound, name)
+}
+
+// PresetRequestNotFoundError is returned when a request is not defined on a preset.
+func PresetRequestNotFoundError(preset, name string) error {
+	return fmt.Errorf("%w: %s.%s", ErrPresetRequestNotFound, preset, name)
+}
+
+// preset is a built-in configuration for a web API provider. It contains the provider's URL, authentication scheme,
+// rate limits, and known request definitions keyed by name.
+type preset struct {
+	RawURL          string              `yaml:"url"`
+	Authentication  Authentication      `yaml:"authentication"`
+	RateLimitConfig *RateLimitConfig    `yaml:"rateLimit"`
+	Requests        map[string]*Request `yaml:"requests"`
+}
+
+// Presets will return the names of the built-in presets.
+func Presets() []string {
+	entries, _ := presetFS.ReadDir("presets")
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), path.Ext(entry.Name())))
+	}
+
+	return names
+}
+
+// loadPreset will load a built-in preset by name.
+func loadPreset(name string) (*preset, error) {
+	bytes, err := presetFS.ReadFile(path.Join("presets", strings.ToLower(name)+".yml"))
+	if err != nil {
+		return nil, PresetNotFoundError(name)
+	}
+
+	var pst preset
+	if err := yaml.Unmarshal(bytes, &pst); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal preset %q: %w", name, err)
+	}
+
+	return &pst, nil
+}
+
+// applyRequest will use a preset request as the default values for a request.
+func (pst *preset) applyRequest(presetName string, req *Request) error {
+	base, ok := pst.Requests[req.Preset]
+	if !ok {
+		return PresetRequestNotFoundError(presetName, req.Preset)
+	}
+
+	if req.Endpoint == "" {
+		req.Endpoint = base.Endpoint
+	}
+
+	if req.Method == "" {
+		req.Method = base.Method
+	}
+
+	if req.Table == "" {
+		req.Table = base.Table
+	}
+
+	if req.RateLimitConfig == nil {
+		req.RateLimitConfig = base.RateLimitConfig
+	}
+
+	if req.Timeseries == nil && base.Timeseries != nil {
+		series := *base.Timeseries
+		req.Timeseries = &series
+	}
+
+	// Query and params on the request override those of the preset.
+	query := make(map[string]string, len(base.Query)+len(req.Query))
+	for key, value := range base.Query {
+		query[key] = value
+	}
+
+	for key, value := range req.Query {
+		query[key] = value
+	}
+
+	req.Query = query
+
+	params := make(map[string]string, len(base.Params)+len(req.Params))
+	for key, value := range base.Params {
+		params[key] = value
+	}
+
+	for key, value := range req.Params {
+		params[key] = value
+	}
+
+	req.Params = params
+
+	return nil
+}
+
+// applyPreset will use the configured preset as the default values for the configuration and its requests.
+func (cfg *Config) applyPreset() error {
+	if cfg.Preset == "" {
+		return nil
+	}
+
+	pst, err := loadPreset(cfg.Preset)
+	if err != nil {
+		return err
+	}
+
+	if cfg.RawURL == "" {
+		cfg.RawURL = pst.RawURL
+	}
+
+	if cfg.RateLimitConfig == nil {
+		cfg.RateLimitConfig = pst.RateLimitConfig
+	}
+
+	// The preset declares the authentication headers that the provider requires, the values are set by the user.
+	for key, value := range pst.Authentication.Headers {
+		if cfg.Authentication.Headers == nil {
+			cfg.Authentication.Headers = make(map[string]string)
+		}
+
+		if _, ok := cfg.Authentication.Headers[key]; !ok {
+			cfg.Authentication.Headers[key] = value
+		}
+	}
+
+	for _, req := range cfg.Requests {
+		if req.Preset == "" {
+			continue
+		}
+
+		if err := pst.applyRequest(cfg.Preset, req); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
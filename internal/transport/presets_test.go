@@ -0,0 +1,110 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	t.Parallel()
+
+	t.Run("all presets load", func(t *testing.T) {
+		t.Parallel()
+
+		names := Presets()
+		if len(names) == 0 {
+			t.Fatal("expected built-in presets")
+		}
+
+		for _, name := range names {
+			pst, err := loadPreset(name)
+			if err != nil {
+				t.Fatalf("error loading preset %q: %v", name, err)
+			}
+
+			if pst.RawURL == "" || pst.RateLimitConfig == nil || pst.RateLimitConfig.validate() != nil {
+				t.Fatalf("preset %q is missing a url or rate limit", name)
+			}
+		}
+	})
+
+	t.Run("request inherits preset definition", func(t *testing.T) {
+		t.Parallel()
+
+		cfg, err := NewConfig([]byte(`
+preset: coinbase
+requests:
+  - preset: candles
+    params:
+      product: BTC-USD
+    query:
+      granularity: "300"
+      start: 2022-05-10T00:00:00Z
+      end: 2022-05-11T00:00:00Z
+`))
+		if err != nil {
+			t.Fatalf("error creating config: %v", err)
+		}
+
+		if cfg.RawURL != "https://api.exchange.coinbase.com" {
+			t.Fatalf("unexpected url: %q", cfg.RawURL)
+		}
+
+		req := cfg.Requests[0]
+		if req.Table != "candles" || req.Timeseries == nil || req.Query["granularity"] != "300" {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+
+		fetchConfig := req.newFetchConfig(*cfg.URL, nil)
+		if fetchConfig.URL.Path != "/products/BTC-USD/candles" {
+			t.Fatalf("unexpected path: %q", fetchConfig.URL.Path)
+		}
+	})
+
+	t.Run("required authentication headers", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewConfig([]byte(`
+preset: alpaca
+requests:
+  - preset: quotes
+    params:
+      symbol: AAPL
+`))
+		if !errors.Is(err, ErrMissingConfigField) {
+			t.Fatalf("expected missing config field error, got %v", err)
+		}
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := NewConfig([]byte(`preset: unknown`)); !errors.Is(err, ErrPresetNotFound) {
+			t.Fatalf("expected preset not found error, got %v", err)
+		}
+	})
+}
+
+func TestExpandEndpoint(t *testing.T) {
+	t.Parallel()
+
+	query := url.Values{"from": {"2022-05-10"}, "to": {"2022-05-11"}, "limit": {"10"}}
+	endpoint := expandEndpoint("/v2/aggs/ticker/{ticker}/range/1/minute/{from}/{to}",
+		map[string]string{"ticker": "AAPL"}, query)
+
+	if endpoint != "/v2/aggs/ticker/AAPL/range/1/minute/2022-05-10/2022-05-11" {
+		t.Fatalf("unexpected endpoint: %q", endpoint)
+	}
+
+	if query.Encode() != "limit=10" {
+		t.Fatalf("expected placeholders to be removed from the query, got %q", query.Encode())
+	}
+}
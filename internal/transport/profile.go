@@ -0,0 +1,215 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	profileDirMode = 0o755
+
+	// profileTimeFormat is the format of the start time in the names of the profiles of a run.
+	profileTimeFormat = "20060102T150405Z"
+)
+
+var ErrInvalidProfile = fmt.Errorf("invalid profile configuration")
+
+// InvalidProfileError wraps an error with ErrInvalidProfile.
+func InvalidProfileError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidProfile, reason)
+}
+
+// ProfileConfig captures CPU and heap profiles of every run to a directory, e.g. to attach them to a performance bug
+// report. The profiles of a run are named by its start time and run ID, e.g. "20220102T150405Z-<run ID>.cpu.pprof",
+// and can be read with "go tool pprof".
+type ProfileConfig struct {
+	// Dir is the directory that the profiles are written to. It is created if it does not exist.
+	Dir string `yaml:"dir"`
+
+	// CPU captures a CPU profile of the run. If neither CPU nor Heap is set, both profiles are captured.
+	CPU bool `yaml:"cpu"`
+
+	// Heap captures a heap profile at the end of the run, which includes the allocations of the entire run.
+	Heap bool `yaml:"heap"`
+
+	// SlowRun only profiles the runs that take longer than this duration. The CPU profile is started once a run
+	// exceeds it, so runs that finish in time are not profiled at all.
+	SlowRun time.Duration `yaml:"slowRun"`
+}
+
+func (pc *ProfileConfig) validate() error {
+	if pc == nil {
+		return nil
+	}
+
+	if pc.Dir == "" {
+		return InvalidProfileError("dir is required")
+	}
+
+	if pc.SlowRun < 0 {
+		return InvalidProfileError("slowRun can not be negative")
+	}
+
+	return nil
+}
+
+// profiles will return whether the CPU and heap profiles are captured.
+func (pc *ProfileConfig) profiles() (bool, bool) {
+	if !pc.CPU && !pc.Heap {
+		return true, true
+	}
+
+	return pc.CPU, pc.Heap
+}
+
+// runProfiler captures the profiles of a single run.
+type runProfiler struct {
+	logger *logrus.Logger
+	prefix string
+	heap   bool
+	timer  *time.Timer
+
+	mu      sync.Mutex
+	cpuFile *os.File
+	slow    bool
+	stopped bool
+}
+
+// start will start profiling a run, returning the function that stops it and writes the profiles once the run is
+// done. Profiles that can not be captured, e.g. because the process is already being CPU profiled, are logged
+// instead of failing the run.
+func (pc *ProfileConfig) start(logger *logrus.Logger, runID string) (func(), error) {
+	if pc == nil {
+		return func() {}, nil
+	}
+
+	if err := os.MkdirAll(pc.Dir, profileDirMode); err != nil {
+		return nil, fmt.Errorf("error creating profile directory: %w", err)
+	}
+
+	cpu, heap := pc.profiles()
+
+	prof := &runProfiler{
+		logger: logger,
+		prefix: filepath.Join(pc.Dir, time.Now().UTC().Format(profileTimeFormat)+"-"+runID),
+		heap:   heap,
+	}
+
+	if pc.SlowRun == 0 {
+		prof.slow = true
+
+		if cpu {
+			prof.startCPU()
+		}
+
+		return prof.stop, nil
+	}
+
+	prof.timer = time.AfterFunc(pc.SlowRun, func() {
+		prof.mu.Lock()
+		defer prof.mu.Unlock()
+
+		if prof.stopped {
+			return
+		}
+
+		prof.slow = true
+
+		logger.Warn(tools.LogFormatter{Msg: fmt.Sprintf("run exceeded %s, profiling the rest of it", pc.SlowRun)}.String())
+
+		if cpu {
+			prof.startCPU()
+		}
+	})
+
+	return prof.stop, nil
+}
+
+// startCPU will start the CPU profile of the run.
+func (prof *runProfiler) startCPU() {
+	file, err := os.Create(prof.prefix + ".cpu.pprof")
+	if err != nil {
+		prof.warn(fmt.Errorf("error creating cpu profile: %w", err))
+
+		return
+	}
+
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+
+		prof.warn(fmt.Errorf("error starting cpu profile: %w", err))
+
+		return
+	}
+
+	prof.cpuFile = file
+}
+
+// stop will stop the CPU profile and write the heap profile of the run, if the run was profiled.
+func (prof *runProfiler) stop() {
+	if prof.timer != nil {
+		prof.timer.Stop()
+	}
+
+	prof.mu.Lock()
+	defer prof.mu.Unlock()
+
+	prof.stopped = true
+
+	if prof.cpuFile != nil {
+		pprof.StopCPUProfile()
+
+		if err := prof.cpuFile.Close(); err != nil {
+			prof.warn(fmt.Errorf("error writing cpu profile: %w", err))
+		} else {
+			prof.logger.Info(tools.LogFormatter{Msg: "cpu profile written: " + prof.cpuFile.Name()}.String())
+		}
+	}
+
+	if prof.slow && prof.heap {
+		if err := prof.writeHeap(); err != nil {
+			prof.warn(err)
+		}
+	}
+}
+
+// writeHeap will write the heap profile of the run.
+func (prof *runProfiler) writeHeap() error {
+	file, err := os.Create(prof.prefix + ".heap.pprof")
+	if err != nil {
+		return fmt.Errorf("error creating heap profile: %w", err)
+	}
+
+	defer file.Close()
+
+	// The heap profile is as of the last garbage collection, so one is run to include the end of the run.
+	runtime.GC()
+
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		return fmt.Errorf("error writing heap profile: %w", err)
+	}
+
+	prof.logger.Info(tools.LogFormatter{Msg: "heap profile written: " + file.Name()}.String())
+
+	return nil
+}
+
+func (prof *runProfiler) warn(err error) {
+	prof.logger.Warn(tools.LogFormatter{Msg: err.Error()}.String())
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestProfileConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name    string
+		profile *ProfileConfig
+		err     error
+	}{
+		{name: "nil"},
+		{name: "valid", profile: &ProfileConfig{Dir: "profiles", SlowRun: time.Minute}},
+		{name: "no dir", profile: &ProfileConfig{CPU: true}, err: ErrInvalidProfile},
+		{name: "negative slow run", profile: &ProfileConfig{Dir: "profiles", SlowRun: -time.Second}, err: ErrInvalidProfile},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.profile.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+// TestProfile is not run in parallel, since a process can only capture one CPU profile at a time.
+func TestProfile(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	for _, tcase := range []struct {
+		name     string
+		profile  *ProfileConfig
+		duration time.Duration
+		expected []string
+	}{
+		{
+			name:     "cpu and heap",
+			profile:  &ProfileConfig{},
+			expected: []string{".cpu.pprof", ".heap.pprof"},
+		},
+		{
+			name:     "heap",
+			profile:  &ProfileConfig{Heap: true},
+			expected: []string{".heap.pprof"},
+		},
+		{
+			name:    "fast run",
+			profile: &ProfileConfig{SlowRun: time.Hour},
+		},
+		{
+			name:     "slow run",
+			profile:  &ProfileConfig{CPU: true, SlowRun: 10 * time.Millisecond},
+			duration: 100 * time.Millisecond,
+			expected: []string{".cpu.pprof"},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			tcase.profile.Dir = filepath.Join(t.TempDir(), "profiles")
+
+			stop, err := tcase.profile.start(logger, "run")
+			if err != nil {
+				t.Fatalf("error starting profile: %v", err)
+			}
+
+			time.Sleep(tcase.duration)
+			stop()
+
+			entries, err := os.ReadDir(tcase.profile.Dir)
+			if err != nil {
+				t.Fatalf("error reading profiles: %v", err)
+			}
+
+			var got []string
+
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil || info.Size() == 0 {
+					t.Fatalf("expected profile %s to be written: %v", entry.Name(), err)
+				}
+
+				// The profiles are named "<start time>-run.<profile>.pprof".
+				got = append(got, strings.TrimPrefix(entry.Name()[strings.Index(entry.Name(), "-run"):], "-run"))
+			}
+
+			if !reflect.DeepEqual(got, tcase.expected) {
+				t.Fatalf("expected profiles %v, got %v", tcase.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// defaultProgressInterval is the default interval between progress reports.
+const defaultProgressInterval = 5 * time.Second
+
+// ProgressConfig enables progress reporting during a transport operation. On a terminal the progress of each table is
+// redrawn in place, otherwise a structured log line is written for each table at every interval.
+type ProgressConfig struct {
+	// Interval is the time between progress reports, the default is 5 seconds. On a terminal, the progress is
+	// redrawn at most once a second regardless of the interval.
+	Interval *time.Duration `yaml:"interval"`
+}
+
+// tableProgress is the progress of the chunks destined for a single table.
+type tableProgress struct {
+	table  string
+	total  int
+	done   int
+	failed int
+
+	// records is the number of records that are sent to storage, which are counted once however many storage devices
+	// they are written to.
+	records int64
+
+	// upserted is the number of records upserted to each storage device, by the scheme of the device.
+	upserted map[string]int64
+}
+
+// eta will estimate the time remaining to complete the table given the time elapsed since the start of the operation.
+func (tp *tableProgress) eta(elapsed time.Duration) time.Duration {
+	finished := tp.done + tp.failed
+	if finished == 0 || finished >= tp.total {
+		return 0
+	}
+
+	return time.Duration(float64(elapsed) / float64(finished) * float64(tp.total-finished)).Round(time.Second)
+}
+
+// String returns a summary of the table's progress given the time elapsed since the start of the operation.
+func (tp *tableProgress) String(elapsed time.Duration) string {
+	var rate float64
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		rate = float64(tp.done+tp.failed) / seconds
+	}
+
+	summary := fmt.Sprintf("%s: %d/%d chunks, %d records, %.2f chunks/s", tp.table, tp.done+tp.failed, tp.total,
+		tp.records, rate)
+
+	schemes := make([]string, 0, len(tp.upserted))
+	for scheme := range tp.upserted {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+
+	for _, scheme := range schemes {
+		summary += fmt.Sprintf(", %d upserted to %s", tp.upserted[scheme], scheme)
+	}
+
+	if tp.failed > 0 {
+		summary += fmt.Sprintf(", %d failed", tp.failed)
+	}
+
+	if eta := tp.eta(elapsed); eta > 0 {
+		summary += fmt.Sprintf(", eta %v", eta)
+	}
+
+	return summary
+}
+
+// progress tracks the progress of a transport operation and periodically reports it to a writer. A nil progress is
+// valid and reports nothing, so that progress reporting can be disabled without checks at every call site.
+type progress struct {
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+	start    time.Time
+
+	// lines is the number of lines drawn by the last terminal report.
+	lines int
+
+	tables []*tableProgress
+	index  map[string]*tableProgress
+	mu     sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// isTerminal will return true if the writer is a character device, such as a terminal.
+func isTerminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// newProgress will return a progress tracker for the flattened requests, or nil if progress reporting is not
+// enabled on the configuration.
+func newProgress(cfg *Config, flattenedRequests []*flattenedRequest) *progress {
+	if cfg.Progress == nil {
+		return nil
+	}
+
+	prog := &progress{
+		w:        cfg.ProgressWriter,
+		interval: defaultProgressInterval,
+		index:    make(map[string]*tableProgress),
+	}
+
+	if prog.w == nil {
+		prog.w = os.Stderr
+	}
+
+	prog.tty = isTerminal(prog.w)
+
+	if cfg.Progress.Interval != nil && *cfg.Progress.Interval > 0 {
+		prog.interval = *cfg.Progress.Interval
+	}
+
+	if prog.tty && prog.interval > time.Second {
+		prog.interval = time.Second
+	}
+
+	for _, req := range flattenedRequests {
+		tp, ok := prog.index[req.table]
+		if !ok {
+			tp = &tableProgress{table: req.table, upserted: make(map[string]int64)}
+			prog.index[req.table] = tp
+			prog.tables = append(prog.tables, tp)
+		}
+
+		tp.total++
+	}
+
+	return prog
+}
+
+// run will report the progress at every interval until the progress is stopped.
+func (prog *progress) run() {
+	if prog == nil {
+		return
+	}
+
+	prog.start = time.Now()
+	prog.stop = make(chan struct{})
+	prog.done = make(chan struct{})
+
+	go func() {
+		defer close(prog.done)
+
+		ticker := time.NewTicker(prog.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				prog.report()
+			case <-prog.stop:
+				prog.report()
+
+				return
+			}
+		}
+	}()
+}
+
+// finish will stop reporting progress after writing a final report.
+func (prog *progress) finish() {
+	if prog == nil || prog.stop == nil {
+		return
+	}
+
+	close(prog.stop)
+	<-prog.done
+}
+
+// chunkDone will record a chunk of a table that has been sent to storage.
+func (prog *progress) chunkDone(table string) {
+	prog.update(table, func(tp *tableProgress) { tp.done++ })
+}
+
+// chunkFailed will record a chunk of a table that has failed.
+func (prog *progress) chunkFailed(table string) {
+	prog.update(table, func(tp *tableProgress) { tp.failed++ })
+}
+
+// recordsSent will count the records of a table that are sent to storage.
+func (prog *progress) recordsSent(table string, data []byte) {
+	if prog == nil {
+		return
+	}
+
+	records := countRecords(data)
+	prog.update(table, func(tp *tableProgress) { tp.records += records })
+}
+
+// recordsWritten will record the number of records of a table that have been upserted to a storage device.
+func (prog *progress) recordsWritten(table, scheme string, count int64) {
+	prog.update(table, func(tp *tableProgress) { tp.upserted[scheme] += count })
+}
+
+func (prog *progress) update(table string, fn func(*tableProgress)) {
+	if prog == nil {
+		return
+	}
+
+	prog.mu.Lock()
+	defer prog.mu.Unlock()
+
+	if tp, ok := prog.index[table]; ok {
+		fn(tp)
+	}
+}
+
+// report will write the progress of every table. On a terminal, the previous report is overwritten.
+func (prog *progress) report() {
+	prog.mu.Lock()
+	defer prog.mu.Unlock()
+
+	elapsed := time.Since(prog.start)
+
+	if !prog.tty {
+		for _, tp := range prog.tables {
+			logInfo := tools.LogFormatter{Duration: elapsed, Msg: "progress " + tp.String(elapsed)}
+			fmt.Fprintln(prog.w, logInfo.String())
+		}
+
+		return
+	}
+
+	var bldr strings.Builder
+
+	// Move the cursor to the start of the previous report and clear each line as it is redrawn.
+	if prog.lines > 0 {
+		bldr.WriteString(fmt.Sprintf("\033[%dA", prog.lines))
+	}
+
+	for _, tp := range prog.tables {
+		bldr.WriteString("\033[2K\r" + tp.String(elapsed) + "\n")
+	}
+
+	prog.lines = len(prog.tables)
+
+	fmt.Fprint(prog.w, bldr.String())
+}
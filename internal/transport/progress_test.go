@@ -0,0 +1,72 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+
+		prog := newProgress(&Config{}, []*flattenedRequest{{table: "candles"}})
+		if prog != nil {
+			t.Fatalf("expected progress to be disabled")
+		}
+
+		// A nil progress must be safe to use.
+		prog.run()
+		prog.chunkDone("candles")
+		prog.finish()
+	})
+
+	t.Run("reports structured lines when not a terminal", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+
+		interval := time.Hour
+		cfg := &Config{Progress: &ProgressConfig{Interval: &interval}, ProgressWriter: &buf}
+
+		prog := newProgress(cfg, []*flattenedRequest{{table: "candles"}, {table: "candles"}, {table: "ticker"}})
+		prog.run()
+
+		prog.chunkDone("candles")
+		prog.recordsSent("candles", []byte(`[{"id":1},{"id":2}]`))
+		prog.recordsWritten("candles", "postgresql", 2)
+		prog.recordsWritten("candles", "mongodb", 1)
+		prog.chunkFailed("ticker")
+		prog.finish()
+
+		for _, want := range []string{
+			"m:progress candles: 1/2 chunks, 2 records",
+			"chunks/s, 1 upserted to mongodb, 2 upserted to postgresql}",
+			"m:progress ticker: 1/1 chunks, 0 records",
+			", 1 failed",
+		} {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("expected progress to contain %q, got:\n%s", want, buf.String())
+			}
+		}
+	})
+}
+
+func TestTableProgressETA(t *testing.T) {
+	t.Parallel()
+
+	tp := &tableProgress{table: "candles", total: 10, done: 4, failed: 1}
+	if eta := tp.eta(10 * time.Second); eta != 10*time.Second {
+		t.Fatalf("expected eta to be 10s, got %v", eta)
+	}
+}
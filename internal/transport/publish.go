@@ -0,0 +1,327 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/nats"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// defaultPublishSubject is the default subject of the events of a table, where "{table}" is replaced by the table.
+const defaultPublishSubject = "gidari.{table}"
+
+var (
+	ErrInvalidPublisher = fmt.Errorf("invalid publisher")
+	ErrPublishFailed    = fmt.Errorf("failed to publish event")
+)
+
+// InvalidPublisherError wraps an error with ErrInvalidPublisher.
+func InvalidPublisherError(rawURL, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrInvalidPublisher, rawURL, reason)
+}
+
+// Event announces the data that a transport operation committed to a table of a storage device, so that downstream
+// processing can be triggered by it.
+type Event struct {
+	// RunID identifies the transport operation.
+	RunID string `json:"runId"`
+
+	// Storage is the scheme of the storage device, e.g. "postgresql".
+	Storage string `json:"storage"`
+
+	// Table is the table that the data was committed to.
+	Table string `json:"table"`
+
+	// RecordCount is the number of records that were upserted, i.e. inserted, updated, or unchanged.
+	RecordCount int64 `json:"recordCount"`
+
+	// InsertedCount and UpdatedCount are the number of records that were inserted and updated.
+	InsertedCount int64 `json:"insertedCount"`
+	UpdatedCount  int64 `json:"updatedCount"`
+
+	// FirstKey and LastKey are the smallest and largest keys of the inserted or updated records, if any. On MongoDB
+	// only the keys of inserted documents are known.
+	FirstKey map[string]interface{} `json:"firstKey,omitempty"`
+	LastKey  map[string]interface{} `json:"lastKey,omitempty"`
+}
+
+// Publisher publishes the events of a transport operation once its data is committed, e.g. to a message bus. Custom
+// publishers, e.g. for Kafka or SNS, can be set on the configuration's "Publishers".
+type Publisher interface {
+	Publish(ctx context.Context, event *Event) error
+}
+
+// PublishConfig is a built-in publisher of events, selected by the scheme of its URL: "nats" or "tls" publishes to a
+// NATS server, and "http" or "https" posts the events as JSON to a webhook.
+type PublishConfig struct {
+	// URL is the URL of the NATS server or the webhook.
+	URL string `yaml:"url"`
+
+	// Subject is the NATS subject of the events of a table, where "{table}" is replaced by the table. Defaults to
+	// "gidari.{table}".
+	Subject string `yaml:"subject"`
+}
+
+func (pc *PublishConfig) validate() error {
+	uri, err := url.Parse(pc.URL)
+	if err != nil || uri.Host == "" {
+		return InvalidPublisherError(pc.URL, "url must have a host")
+	}
+
+	switch uri.Scheme {
+	case "nats", "tls", "http", "https":
+		return nil
+	default:
+		return InvalidPublisherError(pc.URL, "scheme must be nats, tls, http, or https")
+	}
+}
+
+// publisher will return the publisher of the configuration.
+func (pc *PublishConfig) publisher(client *http.Client) Publisher {
+	if strings.HasPrefix(pc.URL, "http") {
+		return &webhookPublisher{url: pc.URL, client: client}
+	}
+
+	subject := pc.Subject
+	if subject == "" {
+		subject = defaultPublishSubject
+	}
+
+	return &natsPublisher{url: pc.URL, subject: subject}
+}
+
+// natsPublisher publishes events to a NATS server, connecting for each event.
+type natsPublisher struct {
+	url     string
+	subject string
+}
+
+// Publish implements the Publisher interface.
+func (pub *natsPublisher) Publish(ctx context.Context, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	conn, err := nats.Dial(ctx, pub.url)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Publish(ctx, strings.ReplaceAll(pub.subject, "{table}", event.Table), data)
+}
+
+// webhookPublisher posts events as JSON to a webhook.
+type webhookPublisher struct {
+	url    string
+	client *http.Client
+}
+
+// Publish implements the Publisher interface.
+func (pub *webhookPublisher) Publish(ctx context.Context, event *Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, pub.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("unable to create webhook request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := pub.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post webhook: %w", err)
+	}
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < http.StatusOK || rsp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unable to post webhook: %s", rsp.Status)
+	}
+
+	return nil
+}
+
+// eventCollector will return a collector of the events of a transport operation, or nil if the configuration has no
+// publishers.
+func (cfg *Config) eventCollector() *eventCollector {
+	if len(cfg.Publish) == 0 && len(cfg.Publishers) == 0 {
+		return nil
+	}
+
+	return new(eventCollector)
+}
+
+// publishers will return the built-in and custom publishers of the configuration.
+func (cfg *Config) publishers() []Publisher {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	publishers := make([]Publisher, 0, len(cfg.Publish)+len(cfg.Publishers))
+	for _, pub := range cfg.Publish {
+		publishers = append(publishers, pub.publisher(client))
+	}
+
+	return append(publishers, cfg.Publishers...)
+}
+
+// eventCollector collects the events of the upserts of a transport operation, one per storage device and table. It
+// is safe for concurrent use.
+type eventCollector struct {
+	mu     sync.Mutex
+	events map[string]*Event
+}
+
+// add will add an upsert response to the event of a storage device and table.
+func (ec *eventCollector) add(scheme, table string, rsp *proto.UpsertResponse) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if ec.events == nil {
+		ec.events = make(map[string]*Event)
+	}
+
+	key := scheme + "." + table
+
+	event, ok := ec.events[key]
+	if !ok {
+		event = &Event{Storage: scheme, Table: table}
+		ec.events[key] = event
+	}
+
+	event.RecordCount += rsp.GetUpsertedCount() + rsp.GetMatchedCount()
+	event.InsertedCount += rsp.GetInsertedCount()
+	event.UpdatedCount += rsp.GetUpdatedCount()
+
+	event.FirstKey, event.LastKey = widenKeyRange(event.FirstKey, event.LastKey, rsp.GetAffectedKeys())
+}
+
+// widenKeyRange will widen the range between the first and last key to include the keys. Nil bounds are unset.
+func widenKeyRange(first, last map[string]interface{},
+	keys []*structpb.Struct,
+) (map[string]interface{}, map[string]interface{}) {
+	for _, key := range keys {
+		keyMap := key.AsMap()
+
+		if first == nil || compareKeys(keyMap, first) < 0 {
+			first = keyMap
+		}
+
+		if last == nil || compareKeys(keyMap, last) > 0 {
+			last = keyMap
+		}
+	}
+
+	return first, last
+}
+
+// list will return the collected events for a run, sorted by storage device and table.
+func (ec *eventCollector) list(runID string) []*Event {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	keys := make([]string, 0, len(ec.events))
+	for key := range ec.events {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	events := make([]*Event, 0, len(keys))
+
+	for _, key := range keys {
+		event := *ec.events[key]
+		event.RunID = runID
+		events = append(events, &event)
+	}
+
+	return events
+}
+
+// compareKeys will compare two keys field by field, in the order of the field names. Numbers are compared numerically
+// and other values by their JSON encoding.
+func compareKeys(left, right map[string]interface{}) int {
+	fields := make([]string, 0, len(left))
+	for field := range left {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		if cmp := compareKeyValues(left[field], right[field]); cmp != 0 {
+			return cmp
+		}
+	}
+
+	return 0
+}
+
+// compareKeyValues will compare two values of a key.
+func compareKeyValues(left, right interface{}) int {
+	leftNum, leftOK := left.(float64)
+	rightNum, rightOK := right.(float64)
+
+	if leftOK && rightOK {
+		switch {
+		case leftNum < rightNum:
+			return -1
+		case leftNum > rightNum:
+			return 1
+		default:
+			return 0
+		}
+	}
+
+	leftJSON, leftErr := json.Marshal(left)
+	rightJSON, rightErr := json.Marshal(right)
+
+	if leftErr != nil || rightErr != nil {
+		return strings.Compare(fmt.Sprint(left), fmt.Sprint(right))
+	}
+
+	return bytes.Compare(leftJSON, rightJSON)
+}
+
+// publishEvents will publish the events with every publisher. Every event is attempted, and the first error is
+// returned.
+func publishEvents(ctx context.Context, cfg *Config, events []*Event) error {
+	var firstErr error
+
+	for _, pub := range cfg.publishers() {
+		for _, event := range events {
+			if err := pub.Publish(ctx, event); err != nil {
+				logErr := tools.LogFormatter{Msg: fmt.Sprintf("unable to publish event for %q: %v", event.Table, err)}
+				cfg.Logger.Error(logErr.String())
+
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%w for %q: %v", ErrPublishFailed, event.Table, err)
+				}
+			}
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestEventCollector(t *testing.T) {
+	t.Parallel()
+
+	newKey := func(id interface{}) *structpb.Struct {
+		key, err := structpb.NewStruct(map[string]interface{}{"id": id})
+		if err != nil {
+			t.Fatalf("failed to create key: %v", err)
+		}
+
+		return key
+	}
+
+	events := new(eventCollector)
+	events.add("postgresql", "candles", &proto.UpsertResponse{
+		UpsertedCount: 2, InsertedCount: 2, MatchedCount: 1, UnchangedCount: 1,
+		AffectedKeys: []*structpb.Struct{newKey(10), newKey(9)},
+	})
+	events.add("postgresql", "candles", &proto.UpsertResponse{
+		MatchedCount: 1, UpdatedCount: 1, AffectedKeys: []*structpb.Struct{newKey(100)},
+	})
+	events.add("mongodb", "candles", &proto.UpsertResponse{MatchedCount: 3})
+
+	expected := []*Event{
+		{RunID: "run", Storage: "mongodb", Table: "candles", RecordCount: 3},
+		{
+			RunID: "run", Storage: "postgresql", Table: "candles", RecordCount: 4, InsertedCount: 2, UpdatedCount: 1,
+			FirstKey: map[string]interface{}{"id": float64(9)}, LastKey: map[string]interface{}{"id": float64(100)},
+		},
+	}
+
+	if got := events.list("run"); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected events %+v, got %+v", expected, got)
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	t.Parallel()
+
+	received := make(chan *Event, 1)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := new(Event)
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil || event.Table == "rejected" {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+
+		received <- event
+	}))
+	defer webhook.Close()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cfg := &Config{Logger: logger, Publish: []*PublishConfig{{URL: webhook.URL}}}
+	if err := cfg.Publish[0].validate(); err != nil {
+		t.Fatalf("failed to validate publisher: %v", err)
+	}
+
+	events := []*Event{{RunID: "run", Storage: "postgresql", Table: "candles", RecordCount: 1}}
+	if err := publishEvents(context.Background(), cfg, events); err != nil {
+		t.Fatalf("failed to publish events: %v", err)
+	}
+
+	if got := <-received; !reflect.DeepEqual(got, events[0]) {
+		t.Fatalf("expected event %+v, got %+v", events[0], got)
+	}
+
+	err := publishEvents(context.Background(), cfg, []*Event{{Table: "rejected"}})
+	if !errors.Is(err, ErrPublishFailed) {
+		t.Fatalf("expected error %v, got %v", ErrPublishFailed, err)
+	}
+
+	if err := (&PublishConfig{URL: "kafka://broker:9092"}).validate(); !errors.Is(err, ErrInvalidPublisher) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidPublisher, err)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// jobHeap is a heap of web jobs, ordered by descending priority and then by the order they were pushed in.
+type jobHeap []*queuedJob
+
+// queuedJob is a web job in the queue.
+type queuedJob struct {
+	job      *webJob
+	priority int
+	seq      int
+}
+
+func (jh jobHeap) Len() int { return len(jh) }
+
+func (jh jobHeap) Less(i, j int) bool {
+	if jh[i].priority != jh[j].priority {
+		return jh[i].priority > jh[j].priority
+	}
+
+	return jh[i].seq < jh[j].seq
+}
+
+func (jh jobHeap) Swap(i, j int) { jh[i], jh[j] = jh[j], jh[i] }
+
+func (jh *jobHeap) Push(x interface{}) { *jh = append(*jh, x.(*queuedJob)) }
+
+func (jh *jobHeap) Pop() interface{} {
+	old := *jh
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*jh = old[:len(old)-1]
+
+	return item
+}
+
+// jobQueue is a priority queue of web jobs that feeds the web workers, so that high priority jobs, e.g. incremental
+// requests, are fetched before low priority jobs, e.g. the chunks of a backfill, that were queued before them. Jobs
+// of the same priority are fetched in the order that they were pushed. It is safe for concurrent use.
+//
+// Every job of a run is pushed before the queue is closed and fed to the workers, so the order of a run is static:
+// the jobs are fetched by descending priority, and a job never overtakes one that a worker has already taken.
+type jobQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   jobHeap
+	seq    int
+	closed bool
+}
+
+// newJobQueue will return an empty job queue.
+func newJobQueue() *jobQueue {
+	queue := new(jobQueue)
+	queue.cond = sync.NewCond(&queue.mu)
+
+	return queue
+}
+
+// push will add a job to the queue.
+func (queue *jobQueue) push(job *webJob) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	heap.Push(&queue.jobs, &queuedJob{job: job, priority: job.priority, seq: queue.seq})
+	queue.seq++
+	queue.cond.Signal()
+}
+
+// close will stop the queue once the jobs that have been pushed are popped.
+func (queue *jobQueue) close() {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	queue.closed = true
+	queue.cond.Broadcast()
+}
+
+// pop will wait for the highest priority job, returning false if the queue is closed and empty.
+func (queue *jobQueue) pop() (*webJob, bool) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	for queue.jobs.Len() == 0 && !queue.closed {
+		queue.cond.Wait()
+	}
+
+	if queue.jobs.Len() == 0 {
+		return nil, false
+	}
+
+	item, _ := heap.Pop(&queue.jobs).(*queuedJob)
+
+	return item.job, true
+}
+
+// feed will send the jobs of the queue to the web workers in priority order until the queue is closed and empty. The
+// channel should be unbuffered, so that jobs are only taken from the queue by idle workers.
+func (queue *jobQueue) feed(jobs chan<- *webJob) {
+	defer close(jobs)
+
+	for {
+		job, ok := queue.pop()
+		if !ok {
+			return
+		}
+
+		// Jobs are still sent once the context is done, so that they fail with its error and are accounted for.
+		jobs <- job
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"testing"
+)
+
+func TestJobQueue(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name       string
+		priorities []int
+		expected   []string
+	}{
+		{"fifo", []int{0, 0, 0}, []string{"t0", "t1", "t2"}},
+		{"priority first", []int{0, 0, 10}, []string{"t2", "t0", "t1"}},
+		{"descending", []int{-1, 5, 0, 5}, []string{"t1", "t3", "t2", "t0"}},
+		{"incremental ahead of backfill", []int{0, 0, 0, 1, 1}, []string{"t3", "t4", "t0", "t1", "t2"}},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			queue := newJobQueue()
+			for idx, priority := range tcase.priorities {
+				queue.push(&webJob{flattenedRequest: &flattenedRequest{
+					table:    "t" + string(rune('0'+idx)),
+					priority: priority,
+				}})
+			}
+
+			queue.close()
+
+			jobs := make(chan *webJob)
+			go queue.feed(jobs)
+
+			var tables []string
+			for job := range jobs {
+				tables = append(tables, job.table)
+			}
+
+			if len(tables) != len(tcase.expected) {
+				t.Fatalf("expected %v, got %v", tcase.expected, tables)
+			}
+
+			for idx := range tables {
+				if tables[idx] != tcase.expected[idx] {
+					t.Fatalf("expected %v, got %v", tcase.expected, tables)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// minutesPerDay is the number of minutes in a day, the range of the times of day of rate windows.
+const minutesPerDay = 24 * 60
+
+var ErrInvalidRateWindow = fmt.Errorf("invalid rate window")
+
+// weekdays are the names of the days of rate windows.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// RateWindow is a time of day during which a rate limit is replaced, e.g. a faster rate limit at night, or a pause
+// during the provider's maintenance window. The fields of the rate limit that are not set are inherited.
+type RateWindow struct {
+	// Start is the time of day that the window starts, "HH:MM".
+	Start string `yaml:"start"`
+
+	// End is the time of day that the window ends, "HH:MM". Windows that end before they start span midnight.
+	End string `yaml:"end"`
+
+	// Days are the days of the week that the window starts on, e.g. ["sat", "sun"]. Defaults to every day.
+	Days []string `yaml:"days"`
+
+	// Burst replaces the burst of the rate limit during the window.
+	Burst *int `yaml:"burst"`
+
+	// Period replaces the period of the rate limit during the window.
+	Period *time.Duration `yaml:"period"`
+
+	// Credits replaces the credits of the rate limit during the window.
+	Credits *int `yaml:"credits"`
+
+	// Pause stops the requests until the window ends.
+	Pause bool `yaml:"pause"`
+}
+
+// RateWindowError wraps an error with ErrInvalidRateWindow.
+func RateWindowError(window *RateWindow, reason string) error {
+	return fmt.Errorf("%w %s-%s: %s", ErrInvalidRateWindow, window.Start, window.End, reason)
+}
+
+// parseTimeOfDay will return the minute of the day of a time of day, "HH:MM".
+func parseTimeOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("time of day must be HH:MM: %w", err)
+	}
+
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+func (rw *RateWindow) validate() error {
+	start, err := parseTimeOfDay(rw.Start)
+	if err != nil {
+		return RateWindowError(rw, err.Error())
+	}
+
+	end, err := parseTimeOfDay(rw.End)
+	if err != nil {
+		return RateWindowError(rw, err.Error())
+	}
+
+	if start == end {
+		return RateWindowError(rw, "start and end must differ")
+	}
+
+	for _, day := range rw.Days {
+		if _, ok := weekdays[strings.ToLower(day)]; !ok {
+			return RateWindowError(rw, fmt.Sprintf("unknown day %q", day))
+		}
+	}
+
+	if (rw.Burst != nil && *rw.Burst <= 0) || (rw.Credits != nil && *rw.Credits <= 0) {
+		return RateWindowError(rw, "burst and credits must be positive")
+	}
+
+	return nil
+}
+
+// bounds will return the start and end of the occurrence of the window that contains t, if any.
+func (rw *RateWindow) bounds(t time.Time) (time.Time, time.Time, bool) {
+	// The times of day are validated with the configuration.
+	start, _ := parseTimeOfDay(rw.Start)
+	end, _ := parseTimeOfDay(rw.End)
+
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	minute := t.Hour()*60 + t.Minute()
+
+	// A window that spans midnight started the day before, if t is after midnight.
+	if end < start {
+		end += minutesPerDay
+
+		if minute < end-minutesPerDay {
+			midnight = midnight.AddDate(0, 0, -1)
+			minute += minutesPerDay
+		}
+	}
+
+	if minute < start || minute >= end || !rw.onDay(midnight.Weekday()) {
+		return time.Time{}, time.Time{}, false
+	}
+
+	return midnight.Add(time.Duration(start) * time.Minute), midnight.Add(time.Duration(end) * time.Minute), true
+}
+
+// onDay will return true if the window starts on the day of the week.
+func (rw *RateWindow) onDay(day time.Weekday) bool {
+	if len(rw.Days) == 0 {
+		return true
+	}
+
+	for _, name := range rw.Days {
+		if weekdays[strings.ToLower(name)] == day {
+			return true
+		}
+	}
+
+	return false
+}
+
+// window will return the first window of the rate limit that contains t, and the time that it ends, if any.
+func (rl *RateLimitConfig) window(t time.Time) (*RateWindow, time.Time) {
+	t = t.In(rl.location())
+
+	for _, window := range rl.Windows {
+		if _, end, ok := window.bounds(t); ok {
+			return window, end
+		}
+	}
+
+	return nil, time.Time{}
+}
+
+// location will return the time zone of the rate windows.
+func (rl *RateLimitConfig) location() *time.Location {
+	if rl.Timezone == "" {
+		return time.Local
+	}
+
+	// The time zone is validated with the configuration.
+	location, err := time.LoadLocation(rl.Timezone)
+	if err != nil {
+		return time.Local
+	}
+
+	return location
+}
+
+// limit will return the rate and burst of the rate limit during a window, or outside of the windows if it is nil.
+func (rl *RateLimitConfig) limit(window *RateWindow) (rate.Limit, int) {
+	burst, period, credits := rl.Burst, rl.Period, rl.Credits
+
+	if window != nil {
+		if window.Burst != nil {
+			burst = window.Burst
+		}
+
+		if window.Period != nil {
+			period = window.Period
+		}
+
+		if window.Credits != nil {
+			credits = window.Credits
+		}
+	}
+
+	if credits == nil {
+		return rate.Every(*period), *burst
+	}
+
+	if *period <= 0 {
+		return rate.Inf, *credits
+	}
+
+	return rate.Limit(float64(*credits) / period.Seconds()), *credits
+}
+
+// windowLimiter is a rate limiter whose rate follows the windows of a rate limit, evaluated before each request, so
+// that long running operations adapt to the windows as they start and end.
+type windowLimiter struct {
+	cfg     *RateLimitConfig
+	limiter *rate.Limiter
+	now     func() time.Time
+
+	mu     sync.Mutex
+	window *RateWindow
+}
+
+// newWindowLimiter will return a rate limiter that follows the windows of the rate limit.
+func newWindowLimiter(cfg *RateLimitConfig) *windowLimiter {
+	limit, burst := cfg.limit(nil)
+
+	return &windowLimiter{cfg: cfg, limiter: rate.NewLimiter(limit, burst), now: time.Now}
+}
+
+// WaitN will block until the current window allows n tokens, waiting out paused windows.
+func (wl *windowLimiter) WaitN(ctx context.Context, n int) error {
+	for {
+		now := wl.now()
+
+		window, end := wl.cfg.window(now)
+		if window == nil || !window.Pause {
+			wl.apply(window, now)
+
+			if err := wl.limiter.WaitN(ctx, n); err != nil {
+				return fmt.Errorf("rate limiter error: %w", err)
+			}
+
+			return nil
+		}
+
+		timer := time.NewTimer(end.Sub(now))
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return fmt.Errorf("rate limiter paused: %w", ctx.Err())
+		}
+	}
+}
+
+// apply will set the rate and burst of the limiter to those of the window, if the window has changed.
+func (wl *windowLimiter) apply(window *RateWindow, now time.Time) {
+	wl.mu.Lock()
+	defer wl.mu.Unlock()
+
+	if window == wl.window {
+		return
+	}
+
+	limit, burst := wl.cfg.limit(window)
+	wl.limiter.SetLimitAt(now, limit)
+	wl.limiter.SetBurstAt(now, burst)
+	wl.window = window
+}
@@ -0,0 +1,139 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateWindowBounds(t *testing.T) {
+	t.Parallel()
+
+	// 2022-05-14 is a Saturday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2022, 5, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	for _, tcase := range []struct {
+		name   string
+		window RateWindow
+		time   time.Time
+		ok     bool
+		end    time.Time
+	}{
+		{"within", RateWindow{Start: "09:00", End: "17:00"}, at(14, 12, 0), true, at(14, 17, 0)},
+		{"before", RateWindow{Start: "09:00", End: "17:00"}, at(14, 8, 59), false, time.Time{}},
+		{"at end", RateWindow{Start: "09:00", End: "17:00"}, at(14, 17, 0), false, time.Time{}},
+		{"spans midnight before", RateWindow{Start: "22:00", End: "06:00"}, at(14, 23, 0), true, at(15, 6, 0)},
+		{"spans midnight after", RateWindow{Start: "22:00", End: "06:00"}, at(15, 5, 0), true, at(15, 6, 0)},
+		{"on day", RateWindow{Start: "02:00", End: "03:00", Days: []string{"sat"}}, at(14, 2, 30), true, at(14, 3, 0)},
+		{"other day", RateWindow{Start: "02:00", End: "03:00", Days: []string{"Sun"}}, at(14, 2, 30), false, time.Time{}},
+		{
+			"spans midnight from day", RateWindow{Start: "22:00", End: "06:00", Days: []string{"sat"}}, at(15, 1, 0),
+			true, at(15, 6, 0),
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.window.validate(); err != nil {
+				t.Fatalf("invalid window: %v", err)
+			}
+
+			_, end, ok := tcase.window.bounds(tcase.time)
+			if ok != tcase.ok || !end.Equal(tcase.end) {
+				t.Fatalf("expected (%v, %v), got (%v, %v)", tcase.ok, tcase.end, ok, end)
+			}
+		})
+	}
+}
+
+func TestRateWindowValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name   string
+		config string
+	}{
+		{"invalid start", "{start: '25:00', end: '06:00'}"},
+		{"empty window", "{start: '06:00', end: '06:00'}"},
+		{"unknown day", "{start: '01:00', end: '06:00', days: [someday]}"},
+		{"zero burst", "{start: '01:00', end: '06:00', burst: 0}"},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte("url: https://api.example.com\nrateLimit: {burst: 1, period: 1s, windows: [" +
+				tcase.config + "]}"))
+			if !errors.Is(err, ErrInvalidRateLimit) || !strings.Contains(err.Error(), ErrInvalidRateWindow.Error()) {
+				t.Fatalf("expected an invalid rate window error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWindowLimiter(t *testing.T) {
+	t.Parallel()
+
+	burst, period, nightBurst, nightPeriod := 1, time.Hour, 5, time.Millisecond
+
+	cfg := &RateLimitConfig{
+		Burst:  &burst,
+		Period: &period,
+		Windows: []*RateWindow{
+			{Start: "22:00", End: "06:00", Burst: &nightBurst, Period: &nightPeriod},
+			{Start: "12:00", End: "13:00", Pause: true},
+		},
+		Timezone: "UTC",
+	}
+
+	now := time.Date(2022, 5, 14, 23, 0, 0, 0, time.UTC)
+
+	limiter := newWindowLimiter(cfg)
+	limiter.now = func() time.Time { return now }
+
+	// At night, the burst of the window allows more requests than the rate limit.
+	if err := limiter.WaitN(context.Background(), nightBurst); err != nil {
+		t.Fatalf("wait error: %v", err)
+	}
+
+	if limiter.limiter.Burst() != nightBurst || limiter.limiter.Limit() != rate.Every(nightPeriod) {
+		t.Fatalf("expected the night window's limit, got (%v, %d)", limiter.limiter.Limit(), limiter.limiter.Burst())
+	}
+
+	// During the day, the rate limit applies again.
+	now = time.Date(2022, 5, 15, 9, 0, 0, 0, time.UTC)
+
+	if err := limiter.WaitN(context.Background(), 1); err != nil {
+		t.Fatalf("wait error: %v", err)
+	}
+
+	if limiter.limiter.Burst() != burst {
+		t.Fatalf("expected burst %d, got %d", burst, limiter.limiter.Burst())
+	}
+
+	// During a paused window, requests wait until it ends.
+	now = time.Date(2022, 5, 15, 12, 30, 0, 0, time.UTC)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.WaitN(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the paused window to wait, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var ErrRecordsMissing = fmt.Errorf("records are missing from storage")
+
+// ReconcileConfig reconciles the records decoded by a transport operation with the records present in storage. After
+// each upsert, the records are looked up by their keys within the transaction, so that writes that did not land are
+// reported before the data is committed. Reconciliation is supported by Postgres tables with primary keys and MongoDB.
+type ReconcileConfig struct {
+	// Fail aborts the operation and rolls back the transactions if records are missing, instead of logging a warning.
+	Fail bool `yaml:"fail"`
+}
+
+// Reconciliation is the number of distinct records decoded for a table, and the number of them that are present in a
+// storage device.
+type Reconciliation struct {
+	// Storage is the scheme of the storage device, e.g. "postgresql".
+	Storage string
+
+	// Table is the name of the table/collection.
+	Table string
+
+	// Decoded is the number of distinct records decoded for the table, by their keys.
+	Decoded int64
+
+	// Present is the number of the decoded records that are present in the table.
+	Present int64
+}
+
+// Missing is the number of decoded records that are not present in storage.
+func (rec *Reconciliation) Missing() int64 {
+	return rec.Decoded - rec.Present
+}
+
+// reconciler collects the reconciliations of a transport operation, one per storage device and table. A nil
+// reconciler is valid and reconciles nothing. It is safe for concurrent use.
+type reconciler struct {
+	mu      sync.Mutex
+	entries map[string]*Reconciliation
+}
+
+// reconciler will return a reconciler for a transport operation, or nil if reconciliation is not enabled.
+func (cfg *Config) reconciler() *reconciler {
+	if cfg.Reconcile == nil {
+		return nil
+	}
+
+	return &reconciler{entries: make(map[string]*Reconciliation)}
+}
+
+// count will count the records of an upsert request that are present in storage. Storage devices and tables that can
+// not be counted are skipped.
+func (rec *reconciler) count(ctx context.Context, repo repository.Generic, req *proto.UpsertRequest) error {
+	if rec == nil {
+		return nil
+	}
+
+	count, err := repo.CountUpserted(ctx, req)
+	if errors.Is(err, storage.ErrCountNotSupported) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to reconcile records: %w", err)
+	}
+
+	scheme := storage.Scheme(repo.Type())
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	key := scheme + "." + req.Table
+
+	entry, ok := rec.entries[key]
+	if !ok {
+		entry = &Reconciliation{Storage: scheme, Table: req.Table}
+		rec.entries[key] = entry
+	}
+
+	entry.Decoded += count.Records
+	entry.Present += count.Present
+
+	return nil
+}
+
+// list will return the reconciliations, sorted by storage device and table. If a scheme is given, only the
+// reconciliations of its storage devices are returned.
+func (rec *reconciler) list(scheme string) []*Reconciliation {
+	if rec == nil {
+		return nil
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	keys := make([]string, 0, len(rec.entries))
+
+	for key, entry := range rec.entries {
+		if scheme == "" || entry.Storage == scheme {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+
+	list := make([]*Reconciliation, 0, len(keys))
+	for _, key := range keys {
+		entry := *rec.entries[key]
+		list = append(list, &entry)
+	}
+
+	return list
+}
+
+// reconcileTxFn will return a transaction function that reports the reconciliations of a storage device, once every
+// upsert sent to its transaction has been counted. Missing records are logged as warnings, or returned as an error
+// wrapping ErrRecordsMissing if the reconciliation fails the operation, so that the transaction is rolled back.
+func reconcileTxFn(cfg *Config, rec *reconciler) func(context.Context, repository.Generic) error {
+	return func(_ context.Context, repo repository.Generic) error {
+		var missing []string
+
+		for _, entry := range rec.list(storage.Scheme(repo.Type())) {
+			summary := fmt.Sprintf("%s.%s: %d of %d decoded records present", entry.Storage, entry.Table, entry.Present,
+				entry.Decoded)
+
+			if entry.Missing() == 0 {
+				cfg.Logger.Info(tools.LogFormatter{Msg: "reconciled " + summary}.String())
+
+				continue
+			}
+
+			missing = append(missing, summary)
+
+			if !cfg.Reconcile.Fail {
+				cfg.Logger.Warn(tools.LogFormatter{Msg: fmt.Sprintf("%v: %s", ErrRecordsMissing, summary)}.String())
+			}
+		}
+
+		if cfg.Reconcile.Fail && len(missing) > 0 {
+			return fmt.Errorf("%w: %s", ErrRecordsMissing, strings.Join(missing, "; "))
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"testing"
+)
+
+func TestReconcilerList(t *testing.T) {
+	t.Parallel()
+
+	if rec := new(Config).reconciler(); rec != nil {
+		t.Fatalf("expected no reconciler without a reconcile configuration")
+	}
+
+	rec := (&Config{Reconcile: new(ReconcileConfig)}).reconciler()
+	rec.entries["postgresql.trades"] = &Reconciliation{Storage: "postgresql", Table: "trades", Decoded: 10, Present: 7}
+	rec.entries["mongodb.trades"] = &Reconciliation{Storage: "mongodb", Table: "trades", Decoded: 10, Present: 10}
+
+	list := rec.list("")
+	if len(list) != 2 || list[0].Storage != "mongodb" || list[1].Storage != "postgresql" {
+		t.Fatalf("expected reconciliations sorted by storage, got %+v", list)
+	}
+
+	if missing := list[1].Missing(); missing != 3 {
+		t.Fatalf("expected 3 missing records, got %d", missing)
+	}
+
+	if list := rec.list("postgresql"); len(list) != 1 || list[0].Table != "trades" {
+		t.Fatalf("expected the postgresql reconciliation, got %+v", list)
+	}
+
+	// The listed reconciliations are copies.
+	list[0].Present = 0
+	if rec.entries["mongodb.trades"].Present != 10 {
+		t.Fatalf("expected the reconciler to be unchanged")
+	}
+}
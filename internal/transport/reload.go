@@ -0,0 +1,153 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+// RequestReload will request that the configuration is reloaded with its "Reloader" before the next poll of the live
+// tail, e.g. on SIGHUP or when the configuration file changes. Requests made while a reload is pending are merged.
+func (cfg *Config) RequestReload() {
+	select {
+	case cfg.reloads <- struct{}{}:
+	default:
+	}
+}
+
+// requestKey will return the key that identifies a request across reloads of the configuration.
+func requestKey(req *Request) string {
+	return fmt.Sprintf("%s %s %s %s %v", req.Database, req.Table, req.Method, req.Endpoint, req.Params)
+}
+
+// reload will reload the configuration for the live tail, returning the tails to poll. The requests, rate limits,
+// bandwidth, concurrency, and error budget of the reloaded configuration replace those of the configuration. Requests
+// that are new are backfilled before they are tailed, requests that were removed are no longer tailed, and requests
+// that are tailed already keep their watermarks. If the configuration can not be reloaded, it is kept as is.
+//
+// Only the requests and limits are reloaded: the URL, authentication, and storage of a running operation are kept.
+func (cfg *Config) reload(ctx context.Context, tails []*tailRequest) []*tailRequest {
+	if cfg.Reloader == nil {
+		cfg.Logger.Warn(tools.LogFormatter{Msg: "reload requested without a reloader"}.String())
+
+		return tails
+	}
+
+	start := time.Now()
+
+	reloaded, err := cfg.Reloader()
+	if err != nil {
+		logWarn := tools.LogFormatter{Msg: fmt.Sprintf("unable to reload the configuration, keeping it: %v", err)}
+		cfg.Logger.Warn(logWarn.String())
+
+		return tails
+	}
+
+	existing := make(map[string]*tailRequest, len(tails))
+	for _, tail := range tails {
+		existing[requestKey(tail.req)] = tail
+	}
+
+	known := make(map[string]bool, len(cfg.Requests))
+	for _, req := range cfg.Requests {
+		known[requestKey(req)] = true
+	}
+
+	var added []*Request
+
+	for _, req := range reloaded.Requests {
+		if !known[requestKey(req)] {
+			added = append(added, req)
+		}
+	}
+
+	cfg.Requests = reloaded.Requests
+	cfg.RateLimitConfig = reloaded.RateLimitConfig
+	cfg.Bandwidth = reloaded.Bandwidth
+	cfg.Concurrency = reloaded.Concurrency
+	cfg.ErrorBudget = reloaded.ErrorBudget
+
+	// The new requests are backfilled, so that their tails start at the end of their backfills. If the backfill
+	// fails, they are left out of the configuration, so that the next reload backfills them again.
+	if len(added) > 0 && !cfg.backfill(ctx, added) {
+		requests := make([]*Request, 0, len(cfg.Requests))
+
+		for _, req := range cfg.Requests {
+			if known[requestKey(req)] {
+				requests = append(requests, req)
+			}
+		}
+
+		cfg.Requests = requests
+		added = nil
+	}
+
+	reloadedTails := make([]*tailRequest, 0, len(tails))
+	now := time.Now()
+
+	for _, req := range cfg.Requests {
+		if req.Timeseries == nil || req.Timeseries.Tail == nil {
+			continue
+		}
+
+		if tail := existing[requestKey(req)]; tail != nil {
+			tail.req = req
+
+			// A shorter interval takes effect now, rather than after the interval of the previous configuration.
+			if due := now.Add(*req.Timeseries.Tail.Interval); due.Before(tail.due) {
+				tail.due = due
+			}
+
+			reloadedTails = append(reloadedTails, tail)
+
+			continue
+		}
+
+		// A request that is tailed for the first time, whether it is new or gained a tail, is tailed from the end of
+		// its backfill.
+		tail, err := cfg.newTail(req)
+		if err != nil {
+			logWarn := tools.LogFormatter{Msg: fmt.Sprintf("unable to tail reloaded request %s: %v", req.Table, err)}
+			cfg.Logger.Warn(logWarn.String())
+
+			continue
+		}
+
+		reloadedTails = append(reloadedTails, tail)
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg: fmt.Sprintf("configuration reloaded: %d requests, %d new, tailing %d", len(cfg.Requests), len(added),
+			len(reloadedTails)),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return reloadedTails
+}
+
+// backfill will upsert the data of the requests that were added by a reload, returning true if it succeeded.
+func (cfg *Config) backfill(ctx context.Context, requests []*Request) bool {
+	flattenedRequests, err := cfg.flatten(ctx, requests)
+	if err == nil {
+		err = upsertFlattenedRequests(ctx, cfg, flattenedRequests, cfg.runID(), nil)
+	}
+
+	if err != nil {
+		logWarn := tools.LogFormatter{Msg: fmt.Sprintf("unable to backfill the reloaded requests: %v", err)}
+		cfg.Logger.Warn(logWarn.String())
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,112 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+const reloadTestConfig = `
+url: https://api.example.com
+rateLimit: {burst: 1, period: %s}
+requests:
+  - endpoint: /candles
+    query: {start: "2022-05-10T00:00:00Z", end: "2022-05-10T00:10:00Z"}
+    timeseries: {startName: start, endName: end, period: 60, tail: {interval: %s}}
+  - endpoint: /trades
+    query: {start: "2022-05-10T00:00:00Z", end: "2022-05-10T00:10:00Z"}
+    timeseries: {startName: start, endName: end, period: 60%s}
+%s`
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(fmt.Sprintf(reloadTestConfig, "1s", "1h", "", `  - endpoint: /ticker
+    query: {start: "2022-05-10T00:00:00Z", end: "2022-05-10T00:10:00Z"}
+    timeseries: {startName: start, endName: end, period: 60, tail: {interval: 1m}}
+`)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	cfg.Logger.SetOutput(io.Discard)
+
+	if _, err := cfg.flattenRequests(context.Background()); err != nil {
+		t.Fatalf("error flattening requests: %v", err)
+	}
+
+	tails, err := cfg.tailRequests()
+	if err != nil {
+		t.Fatalf("error getting tail requests: %v", err)
+	}
+
+	// The candles tail has polled since the backfill.
+	watermark := time.Date(2022, 5, 10, 1, 0, 0, 0, time.UTC)
+	tails[0].watermark = watermark
+	tails[0].due = time.Now().Add(time.Hour)
+
+	cfg.Reloader = func() (*Config, error) {
+		return nil, fmt.Errorf("invalid YAML")
+	}
+
+	if reloaded := cfg.reload(context.Background(), tails); len(reloaded) != 2 {
+		t.Fatalf("expected a failed reload to keep the tails, got %d", len(reloaded))
+	}
+
+	// The reload shortens the interval of candles, tails trades, and removes ticker.
+	cfg.Reloader = func() (*Config, error) {
+		return NewConfig([]byte(fmt.Sprintf(reloadTestConfig, "2s", "1m", ", tail: {interval: 1m}", "")))
+	}
+
+	tails = cfg.reload(context.Background(), tails)
+	if len(tails) != 2 {
+		t.Fatalf("expected 2 tails, got %d", len(tails))
+	}
+
+	if tails[0].req.Endpoint != "/candles" || !tails[0].watermark.Equal(watermark) {
+		t.Fatalf("expected the candles tail to keep its watermark, got %s at %v", tails[0].req.Endpoint,
+			tails[0].watermark)
+	}
+
+	if time.Until(tails[0].due) > time.Minute {
+		t.Fatalf("expected the shorter interval to take effect, got due %v", tails[0].due)
+	}
+
+	backfillEnd := time.Date(2022, 5, 10, 0, 10, 0, 0, time.UTC)
+	if tails[1].req.Endpoint != "/trades" || !tails[1].watermark.Equal(backfillEnd) {
+		t.Fatalf("expected the trades tail to start at its backfill end, got %s at %v", tails[1].req.Endpoint,
+			tails[1].watermark)
+	}
+
+	if *cfg.RateLimitConfig.Period != 2*time.Second || len(cfg.Requests) != 2 {
+		t.Fatalf("expected the rate limit and requests to be reloaded, got %v and %d requests",
+			*cfg.RateLimitConfig.Period, len(cfg.Requests))
+	}
+}
+
+func TestRequestReload(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte("url: https://api.example.com\nrateLimit: {burst: 1, period: 1s}\n" +
+		"requests:\n  - endpoint: /ticker\n"))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	// Reload requests are merged while one is pending.
+	cfg.RequestReload()
+	cfg.RequestReload()
+
+	if len(cfg.reloads) != 1 {
+		t.Fatalf("expected 1 pending reload, got %d", len(cfg.reloads))
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrMissingArchive       = fmt.Errorf("archive is required to replay a run")
+	ErrArchiveIndexNotFound = fmt.Errorf("archive index not found")
+	ErrCorruptArchive       = fmt.Errorf("corrupt archive")
+)
+
+// ArchiveIndexNotFoundError wraps an error with ErrArchiveIndexNotFound.
+func ArchiveIndexNotFoundError(runID string) error {
+	return fmt.Errorf("%w for run %q", ErrArchiveIndexNotFound, runID)
+}
+
+// CorruptArchiveError wraps an error with ErrCorruptArchive.
+func CorruptArchiveError(key, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrCorruptArchive, key, reason)
+}
+
+// archivedResponse is an archived web response, which a flattened request replays instead of making its web request.
+type archivedResponse struct {
+	store   ArchiveStore
+	decoder *zstd.Decoder
+	entry   *ArchiveEntry
+}
+
+// read will return the archived response body, after checking it against its hash, along with the web request that
+// returned it.
+func (ar *archivedResponse) read(ctx context.Context) ([]byte, *http.Request, error) {
+	key := archiveKey(ar.entry.Hash)
+
+	compressed, err := ar.store.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err := ar.decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, nil, CorruptArchiveError(key, err.Error())
+	}
+
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != ar.entry.Hash {
+		return nil, nil, CorruptArchiveError(key, "hash mismatch")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, ar.entry.Method, ar.entry.URL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create archived request: %w", err)
+	}
+
+	return body, req, nil
+}
+
+// readArchiveIndex will read the entries of the latest index of a run.
+func readArchiveIndex(ctx context.Context, store ArchiveStore, runID string) ([]*ArchiveEntry, error) {
+	keys, err := store.List(ctx, "index/")
+	if err != nil {
+		return nil, err
+	}
+
+	var runKeys []string
+
+	for _, key := range keys {
+		if strings.HasSuffix(key, "-"+runID+".ndjson") {
+			runKeys = append(runKeys, key)
+		}
+	}
+
+	if len(runKeys) == 0 {
+		return nil, ArchiveIndexNotFoundError(runID)
+	}
+
+	// The keys start with the start time of their runs, so the latest run sorts last.
+	sort.Strings(runKeys)
+	key := runKeys[len(runKeys)-1]
+
+	data, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*ArchiveEntry
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		entry := new(ArchiveEntry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			return nil, CorruptArchiveError(key, err.Error())
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// flattenArchiveEntries will convert the entries of an archive index into flattened requests that replay the archived
+// responses. The storage options and error budget of each entry are taken from the configured request with the same
+// table, so that fixed mappings and new tables of the configuration apply to the replayed responses.
+func (cfg *Config) flattenArchiveEntries(entries []*ArchiveEntry, decoder *zstd.Decoder) []*flattenedRequest {
+	totals := make(map[string]int)
+	for _, entry := range entries {
+		totals[entry.Table]++
+	}
+
+	budgets := make(map[string]*errorBudget)
+	orders := make(map[string]*recordOrder)
+	seqs := make(map[string]int)
+	flattenedRequests := make([]*flattenedRequest, 0, len(entries))
+
+	for _, entry := range entries {
+		options, priority := new(storageOptions), 0
+		if req := cfg.requestForTable(entry.Table); req != nil {
+			options, priority = req.storageOptions(), req.Priority
+		}
+
+		if budgets[entry.Table] == nil {
+			budgetConfig := cfg.ErrorBudget
+			if req := cfg.requestForTable(entry.Table); req != nil {
+				budgetConfig = req.ErrorBudget
+			}
+
+			budgets[entry.Table] = newErrorBudget(budgetConfig, totals[entry.Table])
+
+			// The responses of an ordered request are replayed in the order they were archived.
+			if req := cfg.requestForTable(entry.Table); req != nil {
+				orders[entry.Table] = req.newRecordOrder()
+			}
+		}
+
+		flattenedRequests = append(flattenedRequests, &flattenedRequest{
+			table:          entry.Table,
+			priority:       priority,
+			budget:         budgets[entry.Table],
+			noCache:        true,
+			archived:       &archivedResponse{store: cfg.archiveStore(), decoder: decoder, entry: entry},
+			order:          orders[entry.Table],
+			seq:            seqs[entry.Table],
+			storageOptions: options,
+		})
+
+		seqs[entry.Table]++
+	}
+
+	return flattenedRequests
+}
+
+// ReplayArchive will run the responses that a previous transport operation archived through the decode, transform, and
+// upsert pipeline again, without making any web requests. If the run's ID has several indexes in the archive, the
+// latest is replayed.
+func ReplayArchive(ctx context.Context, cfg *Config, runID string) error {
+	if err := cfg.validateWASMRuntime(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	if cfg.Archive == nil && cfg.ArchiveStore == nil {
+		return ErrMissingArchive
+	}
+
+	entries, err := readArchiveIndex(ctx, cfg.archiveStore(), runID)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return fmt.Errorf("unable to create zstd decoder: %w", err)
+	}
+
+	defer decoder.Close()
+
+	cfg.replaying = true
+
+	defer func() {
+		cfg.replaying = false
+	}()
+
+	// Replays only hold the responses of a single run, so their counts are not compared to the history of full runs.
+	flattenedRequests := cfg.flattenArchiveEntries(entries, decoder)
+	if err := upsertFlattenedRequests(ctx, cfg, flattenedRequests, cfg.runID(), nil); err != nil {
+		return err
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg:      fmt.Sprintf("replayed %d archived responses of run %q", len(entries), runID),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return nil
+}
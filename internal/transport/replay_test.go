@@ -0,0 +1,102 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestReplayArchive(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// archiveRun will archive the responses of a run to a new in-memory store.
+	archiveRun := func(t *testing.T, runID string, bodies ...string) *memoryArchiveStore {
+		t.Helper()
+
+		store := &memoryArchiveStore{objects: make(map[string][]byte)}
+
+		arc, err := (&Config{ArchiveStore: store}).newArchiver(runID)
+		if err != nil {
+			t.Fatalf("unable to create archiver: %v", err)
+		}
+
+		for _, body := range bodies {
+			req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/accounts", nil)
+			if err := arc.add(context.Background(), "accounts", req, []byte(body)); err != nil {
+				t.Fatalf("unable to archive response: %v", err)
+			}
+		}
+
+		if err := arc.writeIndex(context.Background(), logger); err != nil {
+			t.Fatalf("unable to write index: %v", err)
+		}
+
+		return store
+	}
+
+	t.Run("replays the archived responses", func(t *testing.T) {
+		t.Parallel()
+
+		store := archiveRun(t, "run", `[{"id":1}]`, `[{"id":2}]`)
+		cfg := &Config{Logger: logger, ArchiveStore: store}
+
+		if err := ReplayArchive(context.Background(), cfg, "run"); err != nil {
+			t.Fatalf("unable to replay archive: %v", err)
+		}
+
+		// Replayed responses are not archived again.
+		if len(store.objects) != 3 {
+			t.Fatalf("expected the archive to be unchanged, got %d objects", len(store.objects))
+		}
+	})
+
+	t.Run("corrupt object", func(t *testing.T) {
+		t.Parallel()
+
+		store := archiveRun(t, "run", `[{"id":1}]`)
+		for key := range store.objects {
+			if strings.HasPrefix(key, "objects/") {
+				store.objects[key] = []byte("not zstd")
+			}
+		}
+
+		err := ReplayArchive(context.Background(), &Config{Logger: logger, ArchiveStore: store}, "run")
+		if !errors.Is(err, ErrCorruptArchive) {
+			t.Fatalf("expected error %v, got %v", ErrCorruptArchive, err)
+		}
+	})
+
+	t.Run("unknown run", func(t *testing.T) {
+		t.Parallel()
+
+		store := archiveRun(t, "run", `[{"id":1}]`)
+
+		err := ReplayArchive(context.Background(), &Config{Logger: logger, ArchiveStore: store}, "other")
+		if !errors.Is(err, ErrArchiveIndexNotFound) {
+			t.Fatalf("expected error %v, got %v", ErrArchiveIndexNotFound, err)
+		}
+	})
+
+	t.Run("missing archive", func(t *testing.T) {
+		t.Parallel()
+
+		if err := ReplayArchive(context.Background(), &Config{Logger: logger}, "run"); !errors.Is(err, ErrMissingArchive) {
+			t.Fatalf("expected error %v, got %v", ErrMissingArchive, err)
+		}
+	})
+}
@@ -11,9 +11,11 @@ import (
 	"fmt"
 	"net/url"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/alpine-hodler/gidari/internal/web"
-	"golang.org/x/time/rate"
+	"github.com/alpine-hodler/gidari/tools"
 )
 
 // Request is the information needed to query the web API for data to transport.
@@ -22,54 +24,407 @@ type Request struct {
 	Method string `yaml:"method"`
 
 	// Endpoint is the fragment of the URL that will be used to request data from the API. This value can include
-	// query parameters.
+	// query parameters, and "{name}" placeholders that are filled in from "Params" or "Query".
 	Endpoint string `yaml:"endpoint"`
 
+	// Params are the values for the "{name}" placeholders in the endpoint and table.
+	Params map[string]string `yaml:"params"`
+
+	// Expand are lists of values for params, e.g. symbols and granularities. The request is replaced by a request for
+	// each combination of the values, whose "{name}" placeholders in the endpoint, query, and table are filled in.
+	// Expanded requests without a "{name}" placeholder in their table share the table.
+	Expand map[string][]string `yaml:"expand"`
+
+	// ParamFields are fields that are set on every record to the value of a param, e.g. "symbol: symbol", so that the
+	// records of expanded requests that share a table can be told apart.
+	ParamFields map[string]string `yaml:"paramFields"`
+
+	// Preset is the name of a request defined by the transport config's preset. The preset request's endpoint,
+	// table, query, and timeseries are used as defaults for this request.
+	Preset string `yaml:"preset"`
+
+	// Template is the name of a request template of the transport config that this request extends. The fields of the
+	// template are used as defaults for this request, and their query, params, and headers are merged.
+	Template string `yaml:"template"`
+
 	// Query represent the query params to apply to the URL generated by the request.
 	Query map[string]string
 
+	// Headers are static headers of the request's web requests, e.g. the API version or key of a group of endpoints.
+	// Values can hold "{name}" placeholders of the params.
+	Headers map[string]string `yaml:"headers"`
+
+	// Body is the body of the web requests, encoded as JSON, e.g. the query of a POST request. Its strings can hold
+	// "{name}" placeholders of the params.
+	Body interface{} `yaml:"body"`
+
+	// Idempotency sends an idempotency key with each web request, so that retried requests are not processed twice.
+	Idempotency *IdempotencyConfig `yaml:"idempotency"`
+
 	// Timeseries indicates that the underlying data should be queries as a time series. This means that the
 	Timeseries *timeseries `yaml:"timeseries"`
 
-	// Table is the name of the table/collection to insert the data fetched from the web API.
+	// Table is the name of the table/collection to insert the data fetched from the web API. It can hold "{name}"
+	// placeholders, or be a Go template of the params, e.g. "candles_{{.symbol | lower}}", with the "lower", "upper",
+	// and "replace" functions.
 	Table string `yaml:"table"`
 
-	//
-	RateLimitConfig *RateLimitConfig `yaml:"rate_limit"`
+	// Database is the database that the table is in, overriding the database of the configuration and of the
+	// connection strings. Only MongoDB storage can write to several databases over one connection, other storage
+	// ignores it.
+	Database string `yaml:"database"`
+
+	// RateLimitConfig is the rate limit of the request's web requests, e.g. for endpoints with their own limit. If it is
+	// not set, the request will inherit the rate limit of the transport config.
+	RateLimitConfig *RateLimitConfig `yaml:"rateLimit"`
+
+	// Priority is the priority of the request's web requests, higher priorities are fetched first, e.g. to fetch
+	// latency-sensitive incremental requests ahead of the chunks of a bulk backfill. Defaults to 0.
+	Priority int `yaml:"priority"`
+
+	// Weight is the number of API credits that each web request of this request costs, when the rate limit is a
+	// bucket of credits. Defaults to 1.
+	Weight int `yaml:"weight"`
+
+	// ErrorBudget is the number of failed chunks to tolerate for this request before the transport operation is
+	// aborted. If this is not set, the request will inherit the error budget from the transport config.
+	ErrorBudget *ErrorBudgetConfig `yaml:"errorBudget"`
+
+	// Ordered upserts the records of the request in the order of its responses, e.g. its pages or time chunks, rather
+	// than in the order that the parallel workers complete them, for storage that derives state from the last write
+	// to fields other than the primary keys. The writes of an ordered request are not parallel.
+	Ordered bool `yaml:"ordered"`
+
+	// NoCache disables sharing the responses of this request with identical requests made within the same run, e.g.
+	// for endpoints that return different data each time they are requested.
+	NoCache bool `yaml:"noCache"`
+
+	// VersionField is the field of the table's records that holds their version, e.g. a revision number or an
+	// "updated_at" time. If set, a stored record is only overwritten by a fetched record with a newer version, so that
+	// stale pages of the web API can not overwrite fresher data.
+	VersionField string `yaml:"versionField"`
+
+	// DeleteField is the boolean field of the table's records that marks them as deleted, e.g. "deleted" for APIs
+	// that return soft-deleted records. The stored records of marked records are deleted instead of updated, and
+	// marked records that are not stored are not inserted. Supported by Postgres tables with primary keys, which are
+	// merged with MERGE statements on Postgres 15 and later, and rejected for other connection strings.
+	DeleteField string `yaml:"deleteField"`
+
+	// SurrogateKey generates a key for each record of a table without a natural key, derived from the content of the
+	// record, so that the records are upserted idempotently across runs.
+	SurrogateKey *SurrogateKeyConfig `yaml:"surrogateKey"`
+
+	// JSONColumn is the JSONB column of a Postgres table that holds each entire record, so that loosely-structured
+	// data can be stored without defining a column for every field. Only the table's other columns, e.g. its primary
+	// keys, are extracted from the records.
+	JSONColumn string `yaml:"jsonColumn"`
+
+	// Nested configures how the nested objects and arrays of the records are stored, e.g. flattened or exploded into
+	// child tables. By default, they are stored as they are.
+	Nested *NestedConfig `yaml:"nested"`
+
+	// Geo maps the locations in the records to native geospatial types.
+	Geo []*GeoField `yaml:"geo"`
+
+	// DecimalFields are the fields of the table's records that hold decimal numbers, e.g. prices that financial APIs
+	// encode as strings to preserve their precision. Their exact text is kept through the pipeline, and they are
+	// stored as "NUMERIC" on Postgres and "Decimal128" on MongoDB rather than as floating-point numbers.
+	DecimalFields []string `yaml:"decimalFields"`
+
+	// NanoTimestampFields are the fields of the table's records that hold timestamps with nanosecond precision, e.g.
+	// the trade times of tick data, as RFC3339 timestamps or integer nanoseconds. They are stored as integer
+	// nanoseconds since the Unix epoch, e.g. in "BIGINT" columns, since the native timestamps of Postgres keep
+	// microseconds, and integer nanoseconds would be rounded as floating-point numbers.
+	NanoTimestampFields []string `yaml:"nanoTimestampFields"`
+
+	// LargeIntegers is how the integers of the records whose magnitude exceeds 2^53, e.g. snowflake IDs, are decoded:
+	// "string" keeps their exact digits as strings, and "float" rounds them to floating-point numbers. If this is not
+	// set, they are kept as strings.
+	LargeIntegers LargeIntegerPolicy `yaml:"largeIntegers"`
+
+	// JSONDecoder is the implementation that decodes the records on the storage devices: "standard" or "fast". If
+	// this is not set, the request will inherit the decoder from the transport config.
+	JSONDecoder JSONDecoder `yaml:"jsonDecoder"`
+
+	// MissingFields is how the fields that are missing from the table's records are upserted: "keep" leaves their
+	// stored values untouched, "null" clears them, and "default" sets them to their "Defaults". If this is not set,
+	// Postgres clears missing fields and MongoDB keeps them.
+	MissingFields MissingFieldsPolicy `yaml:"missingFields"`
+
+	// Defaults are the values of the fields that are missing from the table's records, if "MissingFields" is
+	// "default".
+	Defaults map[string]interface{} `yaml:"defaults"`
+
+	// Collation is the collation that the records are matched with when they are upserted, e.g. to match keys that
+	// only differ by case.
+	Collation *CollationConfig `yaml:"collation"`
+
+	// Graph maps the table's records to the nodes and relationships of a graph, for graph storage.
+	Graph *GraphConfig `yaml:"graph"`
+
+	// Embed embeds text fields of the table's records as vectors with the configuration's embedder, and stores them
+	// in target fields, e.g. "vector" columns on Postgres or the points of a Qdrant collection.
+	Embed []*EmbedField `yaml:"embed"`
+
+	// Compress compresses large payload fields of the table's records with zstd before they are stored.
+	Compress *CompressConfig `yaml:"compress"`
+
+	// When is a guard expression of the request's "params" and "query", and of the environment variables, "env", e.g.
+	// `params.symbol != "DOGE-USD"`. Requests whose guard is false are skipped.
+	When string `yaml:"when"`
+
+	// Filter is an expression of the "record" and the request's "params", e.g. "record.volume > 0". Records for which
+	// it is false are not stored.
+	Filter string `yaml:"filter"`
+
+	// Computed are fields that are set on the records to the value of an expression of the "record" and the request's
+	// "params", e.g. "double(record.price) * double(record.size)".
+	Computed map[string]string `yaml:"computed"`
+
+	// Normalize is the Unicode normalization form that the strings and field names of the records are normalized to
+	// when they are decoded: "NFC", "NFD", "NFKC", or "NFKD".
+	Normalize string `yaml:"normalize"`
+
+	// Locale is the BCP 47 language tag of the request's responses, e.g. "de-DE" or "fr". It is sent as the
+	// "Accept-Language" header, and the numbers and dates of the coerce rules are parsed in it, e.g. with "," decimal
+	// separators and localized month names.
+	Locale string `yaml:"locale"`
+
+	// Coerce are rules that coerce the messy scalar encodings of fields when the records of a response are decoded.
+	Coerce []*CoerceRule `yaml:"coerce"`
+
+	// Drop are rules that drop the records that match any of them before they are stored.
+	Drop []*FilterRule `yaml:"drop"`
+
+	// Keep are rules that drop the records that do not match every one of them before they are stored.
+	Keep []*FilterRule `yaml:"keep"`
+
+	// WASM transforms the records of each response with a WebAssembly module before they are embedded and stored. It
+	// requires the configuration's WASMRuntime, which the CLI does not set.
+	WASM *WASMConfig `yaml:"wasm"`
+
+	// Exec transforms the records of each response with a subprocess, after the WebAssembly transform.
+	Exec *ExecConfig `yaml:"exec"`
+
+	// SQL reads the request's records from a SQL database instead of the web API, in which case the endpoint is not
+	// used and the table is required.
+	SQL *SQLSourceConfig `yaml:"sql"`
+
+	// Sync marks the records with the system they originated in and resolves conflicts, for tables that are synced in
+	// both directions.
+	Sync *SyncConfig `yaml:"sync"`
+
+	// Lookups enrich the table's records from local lookup tables before they are stored.
+	Lookups []*LookupConfig `yaml:"lookups"`
+
+	// Aggregate downsamples the table's records by time bucket and key fields before they are stored.
+	Aggregate *AggregateConfig `yaml:"aggregate"`
+
+	// transform is the compiled filter and computed fields.
+	transform *recordTransform
+
+	// encodedBody is the JSON encoded body.
+	encodedBody []byte
 }
 
-// newFetchConfig will constrcut a new HTTP request from the transport request.
-func (req *Request) newFetchConfig(rurl url.URL, client *web.Client) *web.FetchConfig {
-	rurl.Path = path.Join(rurl.Path, req.Endpoint)
+// storageOptions are the options for storing the records of a request.
+type storageOptions struct {
+	versionField string
+	deleteField  string
+	surrogateKey *SurrogateKeyConfig
+	jsonColumn   string
+	database     string
+	nested       *NestedConfig
+	geo          []*GeoField
+	decimals     []string
+	nanos        []string
+	missing      MissingFieldsPolicy
+	collation    *CollationConfig
+	defaults     map[string]interface{}
+	graph        *GraphConfig
+	embed        []*EmbedField
+	compress     *CompressConfig
+	aggregate    *AggregateConfig
+	lookups      []*LookupConfig
+	transform    *recordTransform
+	rules        *recordRules
+	coerce       []*CoerceRule
+	locale       string
+	normalize    string
+	integers     LargeIntegerPolicy
+	dataType     tools.UpsertDataType
+	wasm         *WASMConfig
+	exec         *ExecConfig
+	sync         *SyncConfig
+	paramFields  map[string]interface{}
+}
 
-	// Add the query params to the URL.
-	if req.Query != nil {
-		query := rurl.Query()
-		for key, value := range req.Query {
-			query.Set(key, value)
+// versionField will return the field of the records that holds their version, which is set by the sync conflict
+// policy, if any.
+func (req *Request) versionField() string {
+	if field := req.Sync.versionField(); field != "" {
+		return field
+	}
+
+	return req.VersionField
+}
+
+// storageOptions will return the options for storing the records of the request.
+func (req *Request) storageOptions() *storageOptions {
+	return &storageOptions{
+		versionField: req.versionField(),
+		deleteField:  req.DeleteField,
+		surrogateKey: req.SurrogateKey,
+		jsonColumn:   req.JSONColumn,
+		database:     req.Database,
+		nested:       req.Nested,
+		geo:          req.Geo,
+		decimals:     req.DecimalFields,
+		nanos:        req.NanoTimestampFields,
+		missing:      req.MissingFields,
+		collation:    req.Collation,
+		defaults:     req.Defaults,
+		graph:        req.Graph,
+		embed:        req.Embed,
+		compress:     req.Compress,
+		aggregate:    req.Aggregate,
+		lookups:      req.Lookups,
+		transform:    req.transform,
+		rules:        req.rules(),
+		coerce:       req.Coerce,
+		locale:       req.Locale,
+		normalize:    req.Normalize,
+		integers:     req.LargeIntegers,
+		dataType:     req.JSONDecoder.dataType(),
+		wasm:         req.WASM,
+		exec:         req.Exec,
+		sync:         req.Sync,
+		paramFields:  req.paramFields(),
+	}
+}
+
+// expandEndpoint will fill in the "{name}" placeholders of an endpoint. Values are taken from the params first and then
+// from the query. Query values that are used to fill in a placeholder are removed from the query.
+func expandEndpoint(endpoint string, params map[string]string, query url.Values) string {
+	for {
+		start := strings.Index(endpoint, "{")
+		end := strings.Index(endpoint, "}")
+
+		if start < 0 || end < start {
+			return endpoint
 		}
 
-		rurl.RawQuery = query.Encode()
+		name := endpoint[start+1 : end]
+
+		value, ok := params[name]
+		if !ok {
+			value = query.Get(name)
+			query.Del(name)
+		}
+
+		endpoint = endpoint[:start] + value + endpoint[end+1:]
 	}
+}
+
+// newFetchConfig will constrcut a new HTTP request from the transport request.
+func (req *Request) newFetchConfig(rurl url.URL, client *web.Client) *web.FetchConfig {
+	// Add the query params to the URL.
+	query := rurl.Query()
+	for key, value := range req.Query {
+		query.Set(key, value)
+	}
+
+	rurl.Path = path.Join(rurl.Path, expandEndpoint(req.Endpoint, req.Params, query))
+	rurl.RawQuery = query.Encode()
 
-	// create a rate limiter to pass to all "flattenedRequest". This has to be defined outside of the scope of
-	// individual "flattenedRequest"s so that they all share the same rate limiter, even concurrent requests to
-	// different endpoints could cause a rate limit error on a web API.
-	rateLimiter := rate.NewLimiter(rate.Every(*req.RateLimitConfig.Period), *req.RateLimitConfig.Burst)
+	// Every "flattenedRequest" shares the rate limiter of the rate limit, since even concurrent requests to different
+	// endpoints could cause a rate limit error on a web API.
+	rateLimiter := req.RateLimitConfig.rateLimiter()
 
 	return &web.FetchConfig{
 		Method:      req.Method,
 		URL:         &rurl,
 		C:           client,
 		RateLimiter: rateLimiter,
+		Weight:      req.weight(),
+		Body:        req.encodedBody,
+		Header:      req.header(req.Method, rurl.String(), req.encodedBody),
 	}
 }
 
+// weight will return the number of API credits that each web request of the request costs.
+func (req *Request) weight() int {
+	if req.Weight == 0 {
+		return 1
+	}
+
+	return req.Weight
+}
+
+// validateRateLimit will ensure that the request's rate limit, or the default rate limit if it has none, is valid and
+// allows the request's weight.
+func (req *Request) validateRateLimit(defaults *RateLimitConfig) error {
+	rateLimit := req.RateLimitConfig
+	if rateLimit == nil {
+		rateLimit = defaults
+	} else if err := rateLimit.validate(); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidRateLimit, req.Endpoint, err)
+	}
+
+	if req.Weight < 0 {
+		return fmt.Errorf("%w: %s: weight must not be negative", ErrInvalidRateLimit, req.Endpoint)
+	}
+
+	if req.weight() > rateLimit.capacity() {
+		return fmt.Errorf("%w: %s: weight %d exceeds the credits of the rate limit", ErrInvalidRateLimit,
+			req.Endpoint, req.Weight)
+	}
+
+	return nil
+}
+
 // flattenedRequest contains all of the request information to create a web job. The number of flattened request  for an
 // operation should be 1-1 with the number of requests to the web API.
 type flattenedRequest struct {
 	fetchConfig *web.FetchConfig
 	table       string
+
+	// request is the index of the configured request of the flattened request, if any.
+	request *int
+
+	// budget is the error budget shared by all of the flattened requests of a single request.
+	budget *errorBudget
+
+	// noCache is true if the response must not be shared with identical requests.
+	noCache bool
+
+	// idempotencyHeader is the header of the idempotency key of the web request, if any.
+	idempotencyHeader string
+
+	// priority is the priority of the web request, higher priorities are fetched first.
+	priority int
+
+	// source is the SQL source that the records are read from instead of the web request, if any.
+	source *sqlSource
+
+	// archived is the archived response that is replayed instead of making the web request, if any.
+	archived *archivedResponse
+
+	// snapshot is the snapshot chunk that is imported instead of making the web request, if any.
+	snapshot *snapshotChunk
+
+	// order is the order of the records of an ordered request, shared by its flattened requests, and seq is the index
+	// of the flattened request in the order.
+	order *recordOrder
+	seq   int
+
+	// endpoint is the endpoint of the request, and stats collect the statistics of its web requests if the run is
+	// planned.
+	endpoint string
+	stats    *runStats
+
+	// storageOptions are the options for storing the records.
+	*storageOptions
 }
 
 // flatten will compress the request information into a "web.FetchConfig" request and a "table" name for storage
@@ -78,8 +433,12 @@ func (req *Request) flatten(rurl url.URL, client *web.Client) *flattenedRequest
 	fetchConfig := req.newFetchConfig(rurl, client)
 
 	return &flattenedRequest{
-		fetchConfig: fetchConfig,
-		table:       req.Table,
+		fetchConfig:       fetchConfig,
+		table:             req.Table,
+		endpoint:          req.Endpoint,
+		priority:          req.Priority,
+		idempotencyHeader: req.Idempotency.headerName(),
+		storageOptions:    req.storageOptions(),
 	}
 }
 
@@ -111,18 +470,67 @@ func (req *Request) flattenTimeseries(rurl url.URL, client *web.Client) ([]*flat
 	}
 
 	for _, chunk := range timeseries.chunks {
-		// copy the request and update it to reflect the partitioned timeseries
-		chunkReq := req
+		// copy the request and update it to reflect the partitioned timeseries, leaving the configured query intact
+		// so that the request can be flattened again.
+		chunkReq := *req
+		chunkReq.Query = make(map[string]string, len(req.Query))
+
+		for key, value := range req.Query {
+			chunkReq.Query[key] = value
+		}
+
 		chunkReq.Query[timeseries.StartName] = chunk[0].Format(*timeseries.Layout)
 		chunkReq.Query[timeseries.EndName] = chunk[1].Format(*timeseries.Layout)
 
 		fetchConfig := chunkReq.newFetchConfig(rurl, client)
 
 		requests = append(requests, &flattenedRequest{
-			fetchConfig: fetchConfig,
-			table:       req.Table,
+			fetchConfig:    fetchConfig,
+			table:          req.Table,
+			endpoint:       req.Endpoint,
+			priority:       req.Priority,
+			storageOptions: req.storageOptions(),
 		})
 	}
 
 	return requests, nil
 }
+
+// withRange will return a copy of the timeseries request for the range from start to end, leaving the request intact.
+func (req *Request) withRange(start, end time.Time) *Request {
+	rangeReq := *req
+
+	timeseries := *req.Timeseries
+	rangeReq.Timeseries = &timeseries
+
+	rangeReq.Query = make(map[string]string, len(req.Query))
+	for key, value := range req.Query {
+		rangeReq.Query[key] = value
+	}
+
+	rangeReq.Query[timeseries.StartName] = start.Format(*timeseries.Layout)
+	rangeReq.Query[timeseries.EndName] = end.Format(*timeseries.Layout)
+
+	return &rangeReq
+}
+
+// timeseriesWindow will return the start and end of the configured range of a timeseries request.
+func (req *Request) timeseriesWindow(rurl url.URL) (time.Time, time.Time, error) {
+	query := rurl.Query()
+	for key, value := range req.Query {
+		query.Set(key, value)
+	}
+
+	rurl.RawQuery = query.Encode()
+
+	if err := req.Timeseries.chunk(rurl); err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("failed to set time series chunks: %w", err)
+	}
+
+	chunks := req.Timeseries.chunks
+	if len(chunks) == 0 {
+		return time.Time{}, time.Time{}, nil
+	}
+
+	return chunks[0][0], chunks[len(chunks)-1][1], nil
+}
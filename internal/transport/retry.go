@@ -0,0 +1,265 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/alpine-hodler/gidari/tools"
+	"gopkg.in/yaml.v2"
+)
+
+const failedChunksFileMode = 0o600
+
+var ErrMissingFailedChunksFile = fmt.Errorf("failedChunksFile is required to retry failed chunks")
+
+// writeFailedChunks will persist the failed chunks of the last transport operation to the failed chunks file. If
+// there are no failed chunks, the file is removed so that a subsequent retry is a no-op.
+func (cfg *Config) writeFailedChunks() error {
+	// The polls of a live tail hold their watermarks rather than recording failed chunks, so that the failed chunks of
+	// the backfill are not overwritten. Neither are they overwritten by replays of archived responses or imports of
+	// snapshots, which fail without a web request to retry.
+	if cfg.FailedChunksFile == "" || cfg.tailing || cfg.replaying || cfg.importing {
+		return nil
+	}
+
+	if len(cfg.FailedChunks) == 0 {
+		return cfg.removeFailedChunks()
+	}
+
+	bytes, err := yaml.Marshal(cfg.FailedChunks)
+	if err != nil {
+		return fmt.Errorf("unable to marshal failed chunks: %w", err)
+	}
+
+	if err := os.WriteFile(cfg.FailedChunksFile, bytes, failedChunksFileMode); err != nil {
+		return fmt.Errorf("unable to write failed chunks file: %w", err)
+	}
+
+	logInfo := tools.LogFormatter{
+		Msg: fmt.Sprintf("recorded %d failed chunks to %q", len(cfg.FailedChunks), cfg.FailedChunksFile),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return nil
+}
+
+// removeFailedChunks will remove the failed chunks file, once there are no failed chunks left to retry.
+func (cfg *Config) removeFailedChunks() error {
+	err := os.Remove(cfg.FailedChunksFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to remove failed chunks file: %w", err)
+	}
+
+	logInfo := tools.LogFormatter{Msg: fmt.Sprintf("removed failed chunks file %q", cfg.FailedChunksFile)}
+	cfg.Logger.Info(logInfo.String())
+
+	return nil
+}
+
+// readFailedChunks will read the failed chunks persisted by a previous transport operation. If the file does not
+// exist, there is nothing to retry and no chunks are returned.
+func (cfg *Config) readFailedChunks() ([]*FailedChunk, error) {
+	if cfg.FailedChunksFile == "" {
+		return nil, ErrMissingFailedChunksFile
+	}
+
+	bytes, err := os.ReadFile(cfg.FailedChunksFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read failed chunks file: %w", err)
+	}
+
+	var chunks []*FailedChunk
+	if err := yaml.Unmarshal(bytes, &chunks); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal failed chunks: %w", err)
+	}
+
+	return chunks, nil
+}
+
+// requestForTable will return the first configured request that stores data in the given table, or nil if there is no
+// such request.
+func (cfg *Config) requestForTable(table string) *Request {
+	for _, req := range cfg.Requests {
+		if req.Table == table {
+			return req
+		}
+	}
+
+	return nil
+}
+
+// requestIndex will return the index of a configured request, or nil if it is not one of the configured requests.
+func (cfg *Config) requestIndex(req *Request) *int {
+	for idx := range cfg.Requests {
+		if cfg.Requests[idx] == req {
+			return &idx
+		}
+	}
+
+	return nil
+}
+
+// requestForChunk will return the configured request of a failed chunk: the request at the chunk's index, if it
+// stores data in the chunk's table. Otherwise, e.g. for chunks recorded before the requests were edited, it is the
+// first request that stores data in the table, or nil if there is no such request.
+func (cfg *Config) requestForChunk(chunk *FailedChunk) *Request {
+	if idx := chunk.Request; idx != nil && *idx >= 0 && *idx < len(cfg.Requests) &&
+		cfg.Requests[*idx].Table == chunk.Table {
+		return cfg.Requests[*idx]
+	}
+
+	return cfg.requestForTable(chunk.Table)
+}
+
+// flattenFailedChunks will convert failed chunks into flattened requests. The rate limit and error budget for each
+// chunk are taken from the configured request of the chunk, falling back to the transport config.
+func (cfg *Config) flattenFailedChunks(ctx context.Context, chunks []*FailedChunk) ([]*flattenedRequest, error) {
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to web API: %w", err)
+	}
+
+	// The chunks of a request share its rate limiter and error budget, and the chunks without a configured request
+	// share those of their table.
+	keys := make([]interface{}, len(chunks))
+	totals := make(map[interface{}]int)
+
+	for idx, chunk := range chunks {
+		keys[idx] = chunk.Table
+		if req := cfg.requestForChunk(chunk); req != nil {
+			keys[idx] = req
+		}
+
+		totals[keys[idx]]++
+	}
+
+	limiters := make(map[interface{}]web.RateLimiter)
+	budgets := make(map[interface{}]*errorBudget)
+
+	flattenedRequests := make([]*flattenedRequest, 0, len(chunks))
+
+	for idx, chunk := range chunks {
+		uri, err := url.Parse(chunk.URL)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse failed chunk URL: %w", err)
+		}
+
+		req := cfg.requestForChunk(chunk)
+
+		options, weight, priority, idempotencyHeader := new(storageOptions), 1, 0, ""
+		if req != nil {
+			options, weight, priority = req.storageOptions(), req.weight(), req.Priority
+			idempotencyHeader = req.Idempotency.headerName()
+		}
+
+		if limiters[keys[idx]] == nil {
+			rateLimitConfig, budgetConfig := cfg.RateLimitConfig, cfg.ErrorBudget
+			if req != nil {
+				rateLimitConfig, budgetConfig = req.RateLimitConfig, req.ErrorBudget
+			}
+
+			limiters[keys[idx]] = rateLimitConfig.rateLimiter()
+			budgets[keys[idx]] = newErrorBudget(budgetConfig, totals[keys[idx]])
+		}
+
+		method := chunk.Method
+		if method == "" {
+			method = http.MethodGet
+		}
+
+		var body []byte
+		if chunk.Body != "" {
+			body = []byte(chunk.Body)
+		}
+
+		var header http.Header
+		for key, value := range chunk.Header {
+			if header == nil {
+				header = make(http.Header, len(chunk.Header))
+			}
+
+			header.Set(key, value)
+		}
+
+		flattenedRequests = append(flattenedRequests, &flattenedRequest{
+			fetchConfig: &web.FetchConfig{
+				Method:      method,
+				URL:         uri,
+				C:           client,
+				RateLimiter: limiters[keys[idx]],
+				Weight:      weight,
+				Body:        body,
+				Header:      header,
+			},
+			table:             chunk.Table,
+			request:           cfg.requestIndex(req),
+			priority:          priority,
+			budget:            budgets[keys[idx]],
+			idempotencyHeader: idempotencyHeader,
+			storageOptions:    options,
+		})
+	}
+
+	return flattenedRequests, nil
+}
+
+// RetryFailedChunks will re-execute only the chunks that failed during the previous transport operation, as recorded
+// in the configuration's "FailedChunksFile". Chunks that fail again within the error budget are written back to the
+// file, so that retries can be repeated until every chunk has succeeded, and the file is removed once they have.
+func RetryFailedChunks(ctx context.Context, cfg *Config) error {
+	if err := cfg.validateWASMRuntime(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	chunks, err := cfg.readFailedChunks()
+	if err != nil {
+		return err
+	}
+
+	if len(chunks) == 0 {
+		cfg.Logger.Info(tools.LogFormatter{Msg: "no failed chunks to retry"}.String())
+
+		return cfg.removeFailedChunks()
+	}
+
+	flattenedRequests, err := cfg.flattenFailedChunks(ctx, chunks)
+	if err != nil {
+		return err
+	}
+
+	// Retries only fetch some chunks of each table, so their counts are not compared to the history of full runs.
+	if err := upsertFlattenedRequests(ctx, cfg, flattenedRequests, cfg.runID(), nil); err != nil {
+		return err
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg:      fmt.Sprintf("retried %d failed chunks", len(chunks)),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestFailedChunksFile(t *testing.T) {
+	t.Parallel()
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		request := 1
+
+		cfg := &Config{
+			Logger:           logrus.New(),
+			FailedChunksFile: filepath.Join(t.TempDir(), "failures.yml"),
+			FailedChunks: []*FailedChunk{
+				{Table: "candles", Method: "GET", URL: "https://api.test.com/candles?start=1", Error: "rate limited"},
+				{
+					Table:   "candles",
+					Request: &request,
+					Method:  "GET",
+					URL:     "https://api.test.com/candles?start=2",
+					Error:   "rate limited",
+				},
+			},
+		}
+
+		if err := cfg.writeFailedChunks(); err != nil {
+			t.Fatalf("error writing failed chunks: %v", err)
+		}
+
+		chunks, err := cfg.readFailedChunks()
+		if err != nil {
+			t.Fatalf("error reading failed chunks: %v", err)
+		}
+
+		if !reflect.DeepEqual(chunks, cfg.FailedChunks) {
+			t.Fatalf("unexpected failed chunks: %v", chunks)
+		}
+	})
+
+	t.Run("no failures removes the file", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := &Config{
+			Logger:           logrus.New(),
+			FailedChunksFile: filepath.Join(t.TempDir(), "failures.yml"),
+			FailedChunks:     []*FailedChunk{{Table: "candles", URL: "https://api.test.com/candles"}},
+		}
+
+		if err := cfg.writeFailedChunks(); err != nil {
+			t.Fatalf("error writing failed chunks: %v", err)
+		}
+
+		cfg.FailedChunks = nil
+		if err := cfg.writeFailedChunks(); err != nil {
+			t.Fatalf("error writing failed chunks: %v", err)
+		}
+
+		chunks, err := cfg.readFailedChunks()
+		if err != nil {
+			t.Fatalf("error reading failed chunks: %v", err)
+		}
+
+		if len(chunks) != 0 {
+			t.Fatalf("expected no failed chunks, got %v", chunks)
+		}
+	})
+}
+
+func TestRetryFailedChunks(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name   string
+		chunks string
+	}{
+		{
+			name:   "retried chunks",
+			chunks: "- table: candles\n  method: GET\n  url: {url}/candles?start=1\n",
+		},
+		{
+			name:   "no chunks",
+			chunks: "[]\n",
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+				fmt.Fprint(writer, `[{"id": 1}]`)
+			}))
+			defer testServer.Close()
+
+			chunks := strings.ReplaceAll(tcase.chunks, "{url}", testServer.URL)
+
+			failedChunksFile := filepath.Join(t.TempDir(), "failures.yml")
+			if err := os.WriteFile(failedChunksFile, []byte(chunks), failedChunksFileMode); err != nil {
+				t.Fatalf("error writing failed chunks: %v", err)
+			}
+
+			cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 5
+  period: 1ms
+failedChunksFile: %s
+requests:
+  - endpoint: /candles
+    table: candles
+`, testServer.URL, failedChunksFile)))
+			if err != nil {
+				t.Fatalf("error creating config: %v", err)
+			}
+
+			if err := RetryFailedChunks(context.Background(), cfg); err != nil {
+				t.Fatalf("error retrying failed chunks: %v", err)
+			}
+
+			// There is nothing left to retry, so the file is removed.
+			if _, err := os.Stat(failedChunksFile); !errors.Is(err, os.ErrNotExist) {
+				t.Fatalf("expected the failed chunks file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestFlattenFailedChunks(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.test
+rateLimit:
+  burst: 5
+  period: 1ms
+requests:
+  - endpoint: /candles
+    table: candles
+    priority: 1
+  - endpoint: /candles/archive
+    table: candles
+    priority: 2
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	first, second, missing := 0, 1, 5
+
+	for _, tcase := range []struct {
+		name     string
+		request  *int
+		priority int
+	}{
+		{name: "first request", request: &first, priority: 1},
+		{name: "second request", request: &second, priority: 2},
+		{name: "no request", request: nil, priority: 1},
+		{name: "unknown request", request: &missing, priority: 1},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			chunk := &FailedChunk{Table: "candles", Request: tcase.request, URL: "https://api.test/candles/archive"}
+
+			flattenedRequests, err := cfg.flattenFailedChunks(context.Background(), []*FailedChunk{chunk})
+			if err != nil {
+				t.Fatalf("error flattening failed chunks: %v", err)
+			}
+
+			flatReq := flattenedRequests[0]
+			if flatReq.priority != tcase.priority {
+				t.Fatalf("expected priority %d, got %d", tcase.priority, flatReq.priority)
+			}
+
+			// A chunk that fails again is recorded with the request that was used to retry it.
+			if flatReq.request == nil || *flatReq.request != tcase.priority-1 {
+				t.Fatalf("expected request %d, got %v", tcase.priority-1, flatReq.request)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var ErrInvalidFilterRule = fmt.Errorf("invalid filter rule")
+
+// InvalidFilterRuleError wraps an error with ErrInvalidFilterRule.
+func InvalidFilterRuleError(field, reason string) error {
+	return fmt.Errorf("%w for %q: %s", ErrInvalidFilterRule, field, reason)
+}
+
+// filterRuleTimeLayouts are the layouts of the times that range bounds are compared as.
+var filterRuleTimeLayouts = []string{time.RFC3339Nano, "2006-01-02"}
+
+// FilterRule matches the records of a table by the value of a field, to drop or keep them before they are stored
+// without custom code, e.g. test data, zero-volume rows, or records outside a date range. A rule matches a record when
+// every condition that it sets holds. Records without the field never match.
+type FilterRule struct {
+	// Table is the table whose records are matched, e.g. a nested table. Defaults to the request's table.
+	Table string `yaml:"table"`
+
+	// Field is the field of the records that is matched.
+	Field string `yaml:"field"`
+
+	// Equals matches records whose field is equal to the value.
+	Equals interface{} `yaml:"equals"`
+
+	// In matches records whose field is equal to one of the values.
+	In []interface{} `yaml:"in"`
+
+	// Regex matches records whose field matches the regular expression.
+	Regex string `yaml:"regex"`
+
+	// Min and Max match records whose field is within the inclusive range. Bounds are compared as numbers, as times
+	// if they are RFC3339 times or dates, or else as strings.
+	Min interface{} `yaml:"min"`
+	Max interface{} `yaml:"max"`
+
+	regex *regexp.Regexp
+}
+
+func (rule *FilterRule) validate(list string) error {
+	if rule.Field == "" {
+		return MissingConfigFieldError(list + ".field")
+	}
+
+	if rule.Equals == nil && len(rule.In) == 0 && rule.Regex == "" && rule.Min == nil && rule.Max == nil {
+		return InvalidFilterRuleError(rule.Field, "one of equals, in, regex, min, or max is required")
+	}
+
+	if rule.Regex != "" {
+		regex, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return InvalidFilterRuleError(rule.Field, err.Error())
+		}
+
+		rule.regex = regex
+	}
+
+	return nil
+}
+
+// appliesTo will return true if the rule matches the records of the table, given the request's table.
+func (rule *FilterRule) appliesTo(table, requestTable string) bool {
+	if rule.Table == "" {
+		return table == requestTable
+	}
+
+	return table == rule.Table
+}
+
+// ruleFloat will return the value as a number, if it is one or a string of one.
+func ruleFloat(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case json.Number:
+		f, err := value.Float64()
+
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+
+		return f, err == nil
+	case int:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+
+	return 0, false
+}
+
+// ruleTime will return the value as a time, if it is one or a string of one.
+func ruleTime(value interface{}) (time.Time, bool) {
+	switch value := value.(type) {
+	case time.Time:
+		return value, true
+	case string:
+		for _, layout := range filterRuleTimeLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return t, true
+			}
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// compareRuleValues will compare a field's value to a rule's value, returning -1, 0, or 1 if it is less than, equal
+// to, or greater than the rule's value.
+func compareRuleValues(value, ruleValue interface{}) int {
+	if a, ok := ruleFloat(value); ok {
+		if b, ok := ruleFloat(ruleValue); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	if a, ok := ruleTime(value); ok {
+		if b, ok := ruleTime(ruleValue); ok {
+			switch {
+			case a.Before(b):
+				return -1
+			case a.After(b):
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	a, b := fmt.Sprint(value), fmt.Sprint(ruleValue)
+
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// match will return true if the record's field holds every condition of the rule.
+func (rule *FilterRule) match(record map[string]interface{}) bool {
+	value, ok := record[rule.Field]
+	if !ok || value == nil {
+		return false
+	}
+
+	if rule.Equals != nil && compareRuleValues(value, rule.Equals) != 0 {
+		return false
+	}
+
+	if len(rule.In) > 0 {
+		in := false
+
+		for _, ruleValue := range rule.In {
+			if compareRuleValues(value, ruleValue) == 0 {
+				in = true
+
+				break
+			}
+		}
+
+		if !in {
+			return false
+		}
+	}
+
+	if rule.regex != nil && !rule.regex.MatchString(fmt.Sprint(value)) {
+		return false
+	}
+
+	if rule.Min != nil && compareRuleValues(value, rule.Min) < 0 {
+		return false
+	}
+
+	if rule.Max != nil && compareRuleValues(value, rule.Max) > 0 {
+		return false
+	}
+
+	return true
+}
+
+// recordRules are the drop and keep rules of a request.
+type recordRules struct {
+	drop []*FilterRule
+	keep []*FilterRule
+}
+
+// apply will filter the records of a table of JSON encoded upsert data. Records that match any drop rule are dropped,
+// and so are records that do not match every keep rule.
+func (rr *recordRules) apply(table, requestTable string, data []byte) ([]byte, error) {
+	if rr == nil {
+		return data, nil
+	}
+
+	drop := make([]*FilterRule, 0, len(rr.drop))
+	for _, rule := range rr.drop {
+		if rule.appliesTo(table, requestTable) {
+			drop = append(drop, rule)
+		}
+	}
+
+	keep := make([]*FilterRule, 0, len(rr.keep))
+	for _, rule := range rr.keep {
+		if rule.appliesTo(table, requestTable) {
+			keep = append(keep, rule)
+		}
+	}
+
+	if len(drop) == 0 && len(keep) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	filtered := make([]interface{}, 0, len(records))
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok || keepRecord(rec, drop, keep) {
+			filtered = append(filtered, record)
+		}
+	}
+
+	filteredData, err := json.Marshal(filtered)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return filteredData, nil
+}
+
+// rules will return the drop and keep rules of the request.
+func (req *Request) rules() *recordRules {
+	if len(req.Drop) == 0 && len(req.Keep) == 0 {
+		return nil
+	}
+
+	return &recordRules{drop: req.Drop, keep: req.Keep}
+}
+
+// keepRecord will return true if the record is kept by the drop and keep rules.
+func keepRecord(record map[string]interface{}, drop, keep []*FilterRule) bool {
+	for _, rule := range drop {
+		if rule.match(record) {
+			return false
+		}
+	}
+
+	for _, rule := range keep {
+		if !rule.match(record) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+// recordIDs will return the JSON encoded list of the "id" fields of JSON encoded records.
+func recordIDs(t *testing.T, data []byte) string {
+	t.Helper()
+
+	var records []map[string]interface{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatalf("error decoding records: %v", err)
+	}
+
+	ids := make([]interface{}, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record["id"])
+	}
+
+	encoded, err := json.Marshal(ids)
+	if err != nil {
+		t.Fatalf("error encoding ids: %v", err)
+	}
+
+	return string(encoded)
+}
+
+func TestRecordRules(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`[
+		{"id":1,"symbol":"BTC-USD","volume":"10.5","time":"2022-01-02T00:00:00Z"},
+		{"id":2,"symbol":"TEST-USD","volume":"3","time":"2022-01-02T00:00:00Z"},
+		{"id":3,"symbol":"ETH-USD","volume":"0","time":"2022-01-03T00:00:00Z"},
+		{"id":4,"symbol":"ETH-USD","volume":"7","time":"2021-12-31T00:00:00Z"},
+		{"id":5,"symbol":"DOGE-USD","volume":"1","time":"2022-01-04T00:00:00Z"},
+		{"id":6,"symbol":"BTC-USD","time":"2022-01-02T00:00:00Z"}
+	]`)
+
+	for _, tcase := range []struct {
+		name     string
+		rules    string
+		table    string
+		expected string
+	}{
+		{
+			name:     "equals",
+			rules:    "drop: [{field: volume, equals: 0}]",
+			expected: "[1,2,4,5,6]",
+		},
+		{
+			name:     "in",
+			rules:    "keep: [{field: symbol, in: [BTC-USD, ETH-USD]}]",
+			expected: "[1,3,4,6]",
+		},
+		{
+			name:     "regex",
+			rules:    "drop: [{field: symbol, regex: ^TEST-}]",
+			expected: "[1,3,4,5,6]",
+		},
+		{
+			name:     "date range",
+			rules:    "keep: [{field: time, min: 2022-01-01, max: 2022-01-03T00:00:00Z}]",
+			expected: "[1,2,3,6]",
+		},
+		{
+			name:     "numeric range",
+			rules:    "keep: [{field: volume, min: 2.5}]",
+			expected: "[1,2,4]",
+		},
+		{
+			name:     "drop and keep",
+			rules:    "drop: [{field: symbol, regex: ^TEST-}]\n    keep: [{field: volume, min: 1, max: 10}]",
+			expected: "[4,5]",
+		},
+		{
+			name:     "other table",
+			rules:    "drop: [{table: fills, field: volume, equals: 0}]",
+			expected: "[1,2,3,4,5,6]",
+		},
+		{
+			name:     "nested table",
+			rules:    "drop: [{table: fills, field: volume, equals: 0}]",
+			table:    "fills",
+			expected: "[1,2,4,5,6]",
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+    ` + tcase.rules + `
+`))
+			if err != nil {
+				t.Fatalf("error creating config: %v", err)
+			}
+
+			table := tcase.table
+			if table == "" {
+				table = "trades"
+			}
+
+			filtered, err := cfg.Requests[0].rules().apply(table, "trades", data)
+			if err != nil {
+				t.Fatalf("error filtering records: %v", err)
+			}
+
+			if ids := recordIDs(t, filtered); ids != tcase.expected {
+				t.Fatalf("expected records %s, got %s", tcase.expected, ids)
+			}
+		})
+	}
+}
+
+func TestRecordRulesInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name  string
+		rules string
+		err   error
+	}{
+		{"no field", "drop: [{equals: 0}]", ErrMissingConfigField},
+		{"no condition", "keep: [{field: volume}]", ErrInvalidFilterRule},
+		{"invalid regex", "drop: [{field: symbol, regex: '['}]", ErrInvalidFilterRule},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+    ` + tcase.rules + `
+`))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
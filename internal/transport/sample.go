@@ -0,0 +1,256 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/alpine-hodler/gidari/internal/web"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Sample is a sample of the data that a request will store in a table, taken from the first chunk of the request.
+type Sample struct {
+	// Table is the name of the table/collection that the request stores data in.
+	Table string
+
+	// URL is the URL of the sampled chunk.
+	URL string
+
+	// Chunks is the total number of chunks that the request will fetch.
+	Chunks int
+
+	// Records are the records decoded from the sampled chunk.
+	Records []*structpb.Struct
+}
+
+// sampleRequest will fetch and decode the first chunk of a request.
+func sampleRequest(ctx context.Context, req *Request, flatReqs []*flattenedRequest) (*Sample, error) {
+	sample := &Sample{Table: req.Table, Chunks: len(flatReqs)}
+	if len(flatReqs) == 0 {
+		return sample, nil
+	}
+
+	sample.URL = flatReqs[0].fetchConfig.URL.String()
+
+	rsp, err := web.Fetch(ctx, flatReqs[0].fetchConfig)
+	if err != nil {
+		return nil, &Error{Table: req.Table, URL: sample.URL, Err: WrapWebError(err)}
+	}
+	defer rsp.Body.Close()
+
+	bytes, err := tools.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, &Error{Table: req.Table, URL: sample.URL, Err: WrapWebError(err)}
+	}
+
+	if bytes, err = tools.DecimalRecords(bytes, req.DecimalFields); err != nil {
+		return nil, &Error{Table: req.Table, URL: sample.URL, Err: err}
+	}
+
+	if bytes, err = tools.NanoTimestampRecords(bytes, req.NanoTimestampFields); err != nil {
+		return nil, &Error{Table: req.Table, URL: sample.URL, Err: err}
+	}
+
+	sample.Records, err = tools.DecodeUpsertRecords(&proto.UpsertRequest{
+		Table:    req.Table,
+		Data:     bytes,
+		DataType: int32(req.JSONDecoder.dataType()),
+	})
+	if err != nil {
+		return nil, &Error{Table: req.Table, URL: sample.URL, Err: err}
+	}
+
+	return sample, nil
+}
+
+// SampleRequests will fetch the first chunk of every request in the configuration without writing to storage.
+func SampleRequests(ctx context.Context, cfg *Config) ([]*Sample, error) {
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to web API: %w", err)
+	}
+
+	samples := make([]*Sample, 0, len(cfg.Requests))
+
+	for _, req := range cfg.Requests {
+		// Requests that read from a SQL source do not make web requests.
+		if req.SQL != nil {
+			continue
+		}
+
+		flatReqs, err := req.flattenTimeseries(*cfg.URL, client)
+		if err != nil {
+			return nil, err
+		}
+
+		sample, err := sampleRequest(ctx, req, flatReqs)
+		if err != nil {
+			return nil, err
+		}
+
+		samples = append(samples, sample)
+	}
+
+	return samples, nil
+}
+
+// previewSampleWidth is the maximum width of a sample value in a preview.
+const previewSampleWidth = 40
+
+// previewValue will format a sample value for a preview, truncating it to "previewSampleWidth" characters.
+func previewValue(val interface{}) string {
+	if val == nil {
+		return "-"
+	}
+
+	str, ok := val.(string)
+	if !ok {
+		bytes, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Sprint(val)
+		}
+
+		str = string(bytes)
+	}
+
+	if runes := []rune(str); len(runes) > previewSampleWidth {
+		str = string(runes[:previewSampleWidth-3]) + "..."
+	}
+
+	return str
+}
+
+// writePreview will write the preview of a single sample to w.
+func writePreview(w io.Writer, sample *Sample) error {
+	fmt.Fprintf(w, "%s\n", sample.Table)
+	fmt.Fprintf(w, "  url:      %s\n", sample.URL)
+	fmt.Fprintf(w, "  chunks:   %d\n", sample.Chunks)
+	fmt.Fprintf(w, "  records:  %d in sampled chunk, ~%d estimated total\n", len(sample.Records),
+		len(sample.Records)*sample.Chunks)
+
+	columns := tools.InferColumns(sample.Records)
+	if len(columns) == 0 {
+		if _, err := io.WriteString(w, "  (no fields)\n"); err != nil {
+			return fmt.Errorf("unable to write preview: %w", err)
+		}
+
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprint(tw, "  FIELD\tTYPE\tNULLABLE\tSAMPLE\n")
+
+	for _, column := range columns {
+		fmt.Fprintf(tw, "  %s\t%s\t%t\t%s\n", column.Name, column.Type, column.Nullable, previewValue(column.Sample))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return fmt.Errorf("unable to write preview: %w", err)
+	}
+
+	return nil
+}
+
+// WritePreview will sample every request in the configuration and write a preview of the sampled data to w: the field
+// names, inferred types, and sample values of the records, along with an estimate of the total number of records the
+// request will fetch. Nothing is written to storage.
+func WritePreview(ctx context.Context, cfg *Config, w io.Writer) error {
+	samples, err := SampleRequests(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	for idx, sample := range samples {
+		if idx > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return fmt.Errorf("unable to write preview: %w", err)
+			}
+		}
+
+		if err := writePreview(w, sample); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteDDL will sample every request in the configuration and write the "CREATE TABLE" statements inferred from the
+// sampled records in the given SQL dialect, returning the warnings about the statements to review. Requests that store
+// data in the same table are combined.
+func WriteDDL(ctx context.Context, cfg *Config, dialect tools.SQLDialect, w io.Writer) ([]string, error) {
+	samples, err := SampleRequests(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string][]*structpb.Struct)
+
+	// types are the types of the decimal and nanosecond timestamp fields, by table, which are encoded as strings.
+	types := make(map[string]map[string]tools.ColumnType)
+
+	for idx, sample := range samples {
+		records[sample.Table] = append(records[sample.Table], sample.Records...)
+
+		if types[sample.Table] == nil {
+			types[sample.Table] = make(map[string]tools.ColumnType)
+		}
+
+		// Samples are taken in the order of the requests.
+		for _, field := range cfg.Requests[idx].DecimalFields {
+			types[sample.Table][field] = tools.ColumnTypeDecimal
+		}
+
+		for _, field := range cfg.Requests[idx].NanoTimestampFields {
+			types[sample.Table][field] = tools.ColumnTypeInteger
+		}
+	}
+
+	tables := make([]string, 0, len(records))
+	for table := range records {
+		tables = append(tables, table)
+	}
+
+	sort.Strings(tables)
+
+	var warnings []string
+
+	for idx, table := range tables {
+		columns := tools.InferColumns(records[table])
+		for _, column := range columns {
+			if columnType, ok := types[table][column.Name]; ok {
+				column.Type = columnType
+			}
+		}
+
+		ddl, tableWarnings, err := tools.SQLCreateTable(dialect, table, columns)
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate ddl for %q: %w", table, err)
+		}
+
+		warnings = append(warnings, tableWarnings...)
+
+		if idx > 0 {
+			ddl = "\n" + ddl
+		}
+
+		if _, err := io.WriteString(w, ddl); err != nil {
+			return nil, fmt.Errorf("unable to write ddl: %w", err)
+		}
+	}
+
+	return warnings, nil
+}
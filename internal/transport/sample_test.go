@@ -0,0 +1,96 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+func TestWriteDDL(t *testing.T) {
+	t.Parallel()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(writer, `[{"id": "a", "price": 1.5}, {"id": "b", "price": 2}]`)
+	}))
+	defer testServer.Close()
+
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 1
+  period: 1ms
+requests:
+  - endpoint: /ticks
+`, testServer.URL)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	warnings, err := WriteDDL(context.Background(), cfg, tools.SQLDialectPostgres, &buf)
+	if err != nil {
+		t.Fatalf("error writing ddl: %v", err)
+	}
+
+	// The price is in every sampled record, but only the primary key is NOT NULL.
+	if !strings.Contains(buf.String(), `CREATE TABLE IF NOT EXISTS "ticks"`) ||
+		!strings.Contains(buf.String(), `"id" TEXT NOT NULL`) ||
+		!strings.Contains(buf.String(), `"price" DOUBLE PRECISION,`) {
+		t.Fatalf("unexpected ddl:\n%s", buf.String())
+	}
+
+	if len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+}
+
+func TestWritePreview(t *testing.T) {
+	t.Parallel()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(writer, `[{"id": "a", "price": 1.5}, {"id": "b", "price": 2, "note": "x"}]`)
+	}))
+	defer testServer.Close()
+
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 1
+  period: 1ms
+requests:
+  - endpoint: /ticks
+`, testServer.URL)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePreview(context.Background(), cfg, &buf); err != nil {
+		t.Fatalf("error writing preview: %v", err)
+	}
+
+	for _, want := range []string{
+		"ticks\n",
+		"records:  2 in sampled chunk, ~2 estimated total",
+		"note   string  true      x",
+		"price  float   false     1.5",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected preview to contain %q, got:\n%s", want, buf.String())
+		}
+	}
+}
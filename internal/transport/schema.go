@@ -0,0 +1,227 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	ErrInvalidSchema  = fmt.Errorf("invalid schema configuration")
+	ErrSchemaMismatch = fmt.Errorf("records do not match the schema of the table")
+)
+
+// InvalidSchemaError wraps an error with ErrInvalidSchema.
+func InvalidSchemaError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSchema, reason)
+}
+
+// SchemaMismatchError wraps the problems of the records of a table with ErrSchemaMismatch.
+func SchemaMismatchError(scheme, table string, problems []string) error {
+	return fmt.Errorf("%w %s.%s: %s", ErrSchemaMismatch, scheme, table, strings.Join(problems, "; "))
+}
+
+// SchemaConfig validates the records of each table against the schema of the table on each storage device before
+// they are written, so that records that can not be written fail the operation with an error per field, rather than
+// with a SQL error partway through the run. The schema of a table is read before its first batch is written, and
+// every batch is validated against it. Each field of the records must be a column of the table, unless it is ignored,
+// it is the delete field of the request, or the records are embedded in a JSON column, and each record must have a
+// value for each column of the primary key. Validation is supported by Postgres, SQL Server, and Oracle, the tables of
+// other storage devices are not validated.
+type SchemaConfig struct {
+	// Ignore are the fields that do not need a column, e.g. the fields of a web response that the table does not
+	// store. Fields without a column are not written.
+	Ignore []string `yaml:"ignore"`
+}
+
+func (sc *SchemaConfig) validate() error {
+	if sc == nil {
+		return nil
+	}
+
+	for _, field := range sc.Ignore {
+		if field == "" {
+			return InvalidSchemaError("ignored fields can not be empty")
+		}
+	}
+
+	return nil
+}
+
+// schemaValidator validates the upserts of a transport operation against the schemas of their tables, which are read
+// once per repository and table. A nil schema validator is valid and validates nothing. It is safe for concurrent use.
+type schemaValidator struct {
+	ignore map[string]bool
+	logger *logrus.Logger
+
+	mu sync.Mutex
+
+	// schemas are the schemas of the tables by repository, where a nil schema is a table that does not exist.
+	schemas map[int]map[string]*storage.TableSchema
+
+	// unsupported are the repositories whose storage devices do not have a fixed schema.
+	unsupported map[int]bool
+}
+
+// schemaValidator will return a schema validator for a transport operation, or nil if schema validation is not
+// enabled.
+func (cfg *Config) schemaValidator() *schemaValidator {
+	if cfg.Schema == nil {
+		return nil
+	}
+
+	ignore := make(map[string]bool, len(cfg.Schema.Ignore))
+	for _, field := range cfg.Schema.Ignore {
+		ignore[field] = true
+	}
+
+	return &schemaValidator{
+		ignore:      ignore,
+		logger:      cfg.Logger,
+		schemas:     make(map[int]map[string]*storage.TableSchema),
+		unsupported: make(map[int]bool),
+	}
+}
+
+// schema will return the schema of the table of an upsert request to the repository at an index, reading it if it is
+// the first upsert of the table. If the storage device does not have a fixed schema, false is returned.
+func (sv *schemaValidator) schema(ctx context.Context, repoIdx int, repo repository.Generic,
+	req *proto.UpsertRequest,
+) (*storage.TableSchema, bool, error) {
+	sv.mu.Lock()
+	defer sv.mu.Unlock()
+
+	if sv.unsupported[repoIdx] {
+		return nil, false, nil
+	}
+
+	if schema, ok := sv.schemas[repoIdx][req.Table]; ok {
+		return schema, true, nil
+	}
+
+	schema, err := repo.ReadSchema(ctx, req)
+	if errors.Is(err, storage.ErrSchemaNotSupported) {
+		logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped schema validation: %v", err)}
+		sv.logger.Warn(logWarn.String())
+
+		sv.unsupported[repoIdx] = true
+
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to read the schema of %q: %w", req.Table, err)
+	}
+
+	if sv.schemas[repoIdx] == nil {
+		sv.schemas[repoIdx] = make(map[string]*storage.TableSchema)
+	}
+
+	sv.schemas[repoIdx][req.Table] = schema
+
+	return schema, true, nil
+}
+
+// validate will validate the records of an upsert request to the repository at an index against the schema of its
+// table, returning an error wrapping ErrSchemaMismatch with each problem of the records.
+func (sv *schemaValidator) validate(ctx context.Context, repoIdx int, repo repository.Generic,
+	req *proto.UpsertRequest,
+) error {
+	if sv == nil {
+		return nil
+	}
+
+	schema, ok, err := sv.schema(ctx, repoIdx, repo, req)
+	if err != nil || !ok {
+		return err
+	}
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return fmt.Errorf("unable to validate records: %w", err)
+	}
+
+	if problems := sv.problems(schema, req, records); len(problems) > 0 {
+		return SchemaMismatchError(storage.Scheme(repo.Type()), req.Table, problems)
+	}
+
+	return nil
+}
+
+// problems will return the problems of the records of an upsert request with the schema of its table: the fields
+// that are not columns, in sorted order, followed by the columns of the primary key that records are missing.
+func (sv *schemaValidator) problems(schema *storage.TableSchema, req *proto.UpsertRequest,
+	records []*structpb.Struct,
+) []string {
+	if schema == nil {
+		return []string{"table does not exist"}
+	}
+
+	var problems []string
+
+	if len(schema.PrimaryKeys) == 0 {
+		problems = append(problems, "table has no primary key")
+	}
+
+	columns := make(map[string]bool, len(schema.Columns))
+	for _, column := range schema.Columns {
+		columns[column] = true
+	}
+
+	unknown := make(map[string]int)
+	missing := make(map[string]int)
+
+	for _, record := range records {
+		// The fields of records embedded in a JSON column do not need columns of their own.
+		if req.GetJsonColumn() == "" {
+			for field := range record.GetFields() {
+				if !columns[field] && !sv.ignore[field] && field != req.GetDeleteField() {
+					unknown[field]++
+				}
+			}
+		}
+
+		for _, pk := range schema.PrimaryKeys {
+			value, ok := record.GetFields()[pk]
+			if _, null := value.GetKind().(*structpb.Value_NullValue); !ok || null {
+				missing[pk]++
+			}
+		}
+	}
+
+	fields := make([]string, 0, len(unknown))
+	for field := range unknown {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		problems = append(problems, fmt.Sprintf("field %q of %d records is not a column", field, unknown[field]))
+	}
+
+	for _, pk := range schema.PrimaryKeys {
+		if missing[pk] > 0 {
+			problems = append(problems, fmt.Sprintf("%d records are missing primary key %q", missing[pk], pk))
+		}
+	}
+
+	return problems
+}
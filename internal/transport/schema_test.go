@@ -0,0 +1,212 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+)
+
+// schemaRepository is a repository whose tables have the schemas of a map, counting the schemas it reads.
+type schemaRepository struct {
+	repository.Generic
+
+	schemas     map[string]*storage.TableSchema
+	unsupported bool
+	reads       int
+}
+
+func (repo *schemaRepository) ReadSchema(_ context.Context, req *proto.UpsertRequest) (*storage.TableSchema, error) {
+	repo.reads++
+
+	if repo.unsupported {
+		return nil, fmt.Errorf("%w for %q", storage.ErrSchemaNotSupported, "mongodb")
+	}
+
+	return repo.schemas[req.Table], nil
+}
+
+func (repo *schemaRepository) Type() uint8 { return storage.PostgresType }
+
+func TestSchemaConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	if err := (&SchemaConfig{Ignore: []string{"links"}}).validate(); err != nil {
+		t.Fatalf("expected ignored fields to be valid, got %v", err)
+	}
+
+	if err := (&SchemaConfig{Ignore: []string{""}}).validate(); !errors.Is(err, ErrInvalidSchema) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidSchema, err)
+	}
+}
+
+func TestSchemaValidator(t *testing.T) {
+	t.Parallel()
+
+	schemas := map[string]*storage.TableSchema{
+		"trades": {Columns: []string{"id", "product", "price"}, PrimaryKeys: []string{"id", "product"}},
+		"logs":   {Columns: []string{"message"}},
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		schema   *SchemaConfig
+		req      *proto.UpsertRequest
+		records  []map[string]interface{}
+		problems []string
+	}{
+		{
+			name:    "matching records",
+			req:     &proto.UpsertRequest{Table: "trades"},
+			records: []map[string]interface{}{{"id": 1, "product": "BTC-USD", "price": "1.5"}, {"id": 2, "product": "a"}},
+		},
+		{
+			name: "unknown fields",
+			req:  &proto.UpsertRequest{Table: "trades"},
+			records: []map[string]interface{}{
+				{"id": 1, "product": "BTC-USD", "size": "1", "side": "buy"},
+				{"id": 2, "product": "BTC-USD", "size": "2"},
+			},
+			problems: []string{`field "side" of 1 records is not a column`, `field "size" of 2 records is not a column`},
+		},
+		{
+			name:    "ignored fields",
+			schema:  &SchemaConfig{Ignore: []string{"size"}},
+			req:     &proto.UpsertRequest{Table: "trades"},
+			records: []map[string]interface{}{{"id": 1, "product": "BTC-USD", "size": "1"}},
+		},
+		{
+			name:    "delete field",
+			req:     &proto.UpsertRequest{Table: "trades", DeleteField: "deleted"},
+			records: []map[string]interface{}{{"id": 1, "product": "BTC-USD", "deleted": true}},
+		},
+		{
+			name:    "json column",
+			req:     &proto.UpsertRequest{Table: "trades", JsonColumn: "price"},
+			records: []map[string]interface{}{{"id": 1, "product": "BTC-USD", "size": "1"}},
+		},
+		{
+			name:     "missing primary keys",
+			req:      &proto.UpsertRequest{Table: "trades"},
+			records:  []map[string]interface{}{{"id": 1}, {"id": nil, "product": "BTC-USD"}, {"product": nil}},
+			problems: []string{`2 records are missing primary key "id"`, `2 records are missing primary key "product"`},
+		},
+		{
+			name:     "missing table",
+			req:      &proto.UpsertRequest{Table: "orders"},
+			records:  []map[string]interface{}{{"id": 1}},
+			problems: []string{"table does not exist"},
+		},
+		{
+			name:     "no primary key",
+			req:      &proto.UpsertRequest{Table: "logs"},
+			records:  []map[string]interface{}{{"message": "hello"}},
+			problems: []string{"table has no primary key"},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			schema := tcase.schema
+			if schema == nil {
+				schema = &SchemaConfig{}
+			}
+
+			data, err := json.Marshal(tcase.records)
+			if err != nil {
+				t.Fatalf("error encoding records: %v", err)
+			}
+
+			tcase.req.Data, tcase.req.DataType = data, int32(tools.UpsertDataJSON)
+
+			repo := &schemaRepository{schemas: schemas}
+			validator := (&Config{Schema: schema, Logger: logrus.New()}).schemaValidator()
+
+			err = validator.validate(context.Background(), 0, repo, tcase.req)
+			if len(tcase.problems) == 0 {
+				if err != nil {
+					t.Fatalf("expected the records to match the schema, got %v", err)
+				}
+
+				return
+			}
+
+			if !errors.Is(err, ErrSchemaMismatch) {
+				t.Fatalf("expected error %v, got %v", ErrSchemaMismatch, err)
+			}
+
+			expected := SchemaMismatchError("postgresql", tcase.req.Table, tcase.problems).Error()
+			if !strings.Contains(err.Error(), expected) {
+				t.Fatalf("expected error %q, got %q", expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestSchemaValidatorReadsOnce(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	data, err := json.Marshal([]map[string]interface{}{{"id": 1}})
+	if err != nil {
+		t.Fatalf("error encoding records: %v", err)
+	}
+
+	repo := &schemaRepository{schemas: map[string]*storage.TableSchema{
+		"trades": {Columns: []string{"id"}, PrimaryKeys: []string{"id"}},
+	}}
+	unsupported := &schemaRepository{unsupported: true}
+	validator := (&Config{Schema: &SchemaConfig{}, Logger: logger}).schemaValidator()
+
+	for idx := 0; idx < 3; idx++ {
+		for _, table := range []string{"trades", "orders"} {
+			req := &proto.UpsertRequest{Table: table, Data: data, DataType: int32(tools.UpsertDataJSON)}
+
+			err := validator.validate(context.Background(), 0, repo, req)
+			if expected := table == "orders"; errors.Is(err, ErrSchemaMismatch) != expected {
+				t.Fatalf("expected a mismatch of %q to be %v, got %v", table, expected, err)
+			}
+
+			if err := validator.validate(context.Background(), 1, unsupported, req); err != nil {
+				t.Fatalf("expected storage without a schema to be skipped, got %v", err)
+			}
+		}
+	}
+
+	if repo.reads != 2 || unsupported.reads != 1 {
+		t.Fatalf("expected the schemas to be read once, got %d reads and %d reads", repo.reads, unsupported.reads)
+	}
+}
+
+func TestNoSchemaValidator(t *testing.T) {
+	t.Parallel()
+
+	validator := new(Config).schemaValidator()
+	if validator != nil {
+		t.Fatalf("expected no schema validator without a schema configuration")
+	}
+
+	if err := validator.validate(context.Background(), 0, nil, &proto.UpsertRequest{}); err != nil {
+		t.Fatalf("expected a nil schema validator to validate nothing, got %v", err)
+	}
+}
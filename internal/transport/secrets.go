@@ -0,0 +1,51 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+
+	"github.com/alpine-hodler/gidari/internal/secret"
+)
+
+// SecretProvider fetches secrets from a secrets manager, for the secret references of a configuration. Set custom
+// providers on the configuration's "SecretProviders", keyed by the provider name of their references.
+type SecretProvider = secret.Provider
+
+// secret will return a configuration value with its secret references resolved, e.g. "secret://vault/path#key" or a
+// connection string that embeds "${secret://aws/db#password}". The secrets are fetched once per configuration.
+func (cfg *Config) secret(ctx context.Context, value string) (string, error) {
+	if !secret.IsReference(value) {
+		return value, nil
+	}
+
+	if cfg.secrets == nil {
+		cfg.secrets = secret.NewResolver(cfg.HTTPClient, cfg.SecretProviders)
+	}
+
+	resolved, err := cfg.secrets.Resolve(ctx, value)
+	if err != nil {
+		return "", err
+	}
+
+	return resolved, nil
+}
+
+// resolveSecrets will resolve the secret references of the values in place.
+func (cfg *Config) resolveSecrets(ctx context.Context, values ...*string) error {
+	for _, value := range values {
+		resolved, err := cfg.secret(ctx, *value)
+		if err != nil {
+			return err
+		}
+
+		*value = resolved
+	}
+
+	return nil
+}
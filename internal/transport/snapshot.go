@@ -0,0 +1,532 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// SnapshotFormatNDJSON is the format of snapshots that store the records of a table as one JSON object per line.
+	SnapshotFormatNDJSON = "ndjson"
+
+	snapshotVersion      = 1
+	snapshotManifestName = "manifest.json"
+	snapshotDirMode      = 0o755
+	snapshotFileMode     = 0o644
+
+	// snapshotBatchSize is the number of records of each chunk of an import.
+	snapshotBatchSize = 1000
+)
+
+var ErrCorruptSnapshot = fmt.Errorf("corrupt snapshot")
+
+// CorruptSnapshotError wraps an error with ErrCorruptSnapshot.
+func CorruptSnapshotError(file, reason string) error {
+	return fmt.Errorf("%w %q: %s", ErrCorruptSnapshot, file, reason)
+}
+
+// SnapshotTable is the entry of a table in the manifest of a snapshot.
+type SnapshotTable struct {
+	// Storage is the scheme of the storage device that the table was exported from, e.g. "postgresql".
+	Storage string `json:"storage"`
+
+	// Table is the name of the table/collection.
+	Table string `json:"table"`
+
+	// File is the path of the table's file, relative to the snapshot directory.
+	File string `json:"file"`
+
+	// Records is the number of records in the file.
+	Records int64 `json:"records"`
+
+	// PrimaryKeys are the fields of the primary keys of the table.
+	PrimaryKeys []string `json:"primaryKeys"`
+
+	// SHA256 is the hex encoded SHA-256 hash of the file.
+	SHA256 string `json:"sha256"`
+}
+
+// SnapshotManifest is the manifest of a snapshot, which is written to "manifest.json" in the snapshot directory.
+type SnapshotManifest struct {
+	Version   int              `json:"version"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Format    string           `json:"format"`
+	Tables    []*SnapshotTable `json:"tables"`
+}
+
+// snapshotDirs will return the directory of each storage device in a snapshot, the scheme of the storage device. If
+// several storage devices share a scheme, their directories are suffixed with their index in the configuration.
+func snapshotDirs(repos []repository.Generic) []string {
+	counts := make(map[string]int)
+	for _, repo := range repos {
+		counts[storage.Scheme(repo.Type())]++
+	}
+
+	dirs := make([]string, len(repos))
+
+	for idx, repo := range repos {
+		scheme := storage.Scheme(repo.Type())
+		if counts[scheme] > 1 {
+			scheme = fmt.Sprintf("%s-%d", scheme, idx)
+		}
+
+		dirs[idx] = scheme
+	}
+
+	return dirs
+}
+
+// encodeSnapshotRecords will encode records as one JSON object per line.
+func encodeSnapshotRecords(records []*structpb.Struct) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for _, record := range records {
+		line, err := protojson.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("unable to encode snapshot record: %w", err)
+		}
+
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeSnapshotRecords will decode the records of a table's file, one JSON object per line.
+func decodeSnapshotRecords(file string, data []byte) ([]json.RawMessage, error) {
+	var records []json.RawMessage
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		if !json.Valid(line) || line[0] != '{' {
+			return nil, CorruptSnapshotError(file, fmt.Sprintf("line %d is not a JSON object", len(records)+1))
+		}
+
+		records = append(records, append(json.RawMessage(nil), line...))
+	}
+
+	return records, nil
+}
+
+// writeSnapshotTable will write the records of a table to its file in the snapshot directory, returning its entry in
+// the manifest.
+func writeSnapshotTable(dir, repoDir, scheme, table string, stored *storage.StoredRecords) (*SnapshotTable, error) {
+	data, err := encodeSnapshotRecords(stored.Records)
+	if err != nil {
+		return nil, err
+	}
+
+	file := path.Join(repoDir, fmt.Sprintf("%s.%s", table, SnapshotFormatNDJSON))
+	name := filepath.Join(dir, filepath.FromSlash(file))
+
+	if err := os.MkdirAll(filepath.Dir(name), snapshotDirMode); err != nil {
+		return nil, fmt.Errorf("unable to create snapshot directory: %w", err)
+	}
+
+	if err := os.WriteFile(name, data, snapshotFileMode); err != nil {
+		return nil, fmt.Errorf("unable to write snapshot: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+
+	return &SnapshotTable{
+		Storage:     scheme,
+		Table:       table,
+		File:        file,
+		Records:     int64(len(stored.Records)),
+		PrimaryKeys: stored.PrimaryKeys,
+		SHA256:      hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// exportTables will return the tables of a storage device to export, the given tables or every table of the storage
+// device.
+func exportTables(ctx context.Context, repo repository.Generic, tables []string) ([]string, error) {
+	if len(tables) > 0 {
+		return tables, nil
+	}
+
+	rsp, err := repo.ListTables(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list tables: %w", err)
+	}
+
+	names := make([]string, 0, len(rsp.GetTableSet()))
+	for name := range rsp.GetTableSet() {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// ExportSnapshot will read the tables of every storage device in the configuration and write them to a snapshot
+// directory, one NDJSON file per table along with a manifest of the files and their checksums. If no tables are given,
+// every table of each storage device is exported. Storage devices that can not be read are skipped. Nothing is
+// written to storage.
+func ExportSnapshot(ctx context.Context, cfg *Config, dir string, tables []string) (*SnapshotManifest, error) {
+	start := time.Now()
+
+	repos, closeRepos, err := cfg.repos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer closeRepos()
+
+	repoDirs := snapshotDirs(repos)
+	manifest := &SnapshotManifest{Version: snapshotVersion, CreatedAt: start.UTC(), Format: SnapshotFormatNDJSON}
+
+	// The transactions of the storage devices run concurrently.
+	var mu sync.Mutex
+
+	for idx, repo := range repos {
+		repoDir := repoDirs[idx]
+
+		repo.Transact(func(sctx context.Context, repo repository.Generic) error {
+			scheme := storage.Scheme(repo.Type())
+
+			names, err := exportTables(sctx, repo, tables)
+			if err != nil {
+				return err
+			}
+
+			for _, table := range names {
+				stored, err := repo.ReadTable(sctx, &proto.ReadRequest{Table: table})
+				if errors.Is(err, storage.ErrReadNotSupported) {
+					logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped export: %v", err)}
+					cfg.Logger.Warn(logWarn.String())
+
+					return nil
+				}
+
+				if err != nil {
+					return fmt.Errorf("unable to export %q: %w", table, err)
+				}
+
+				entry, err := writeSnapshotTable(dir, repoDir, scheme, table, stored)
+				if err != nil {
+					return fmt.Errorf("unable to export %q: %w", table, err)
+				}
+
+				mu.Lock()
+				manifest.Tables = append(manifest.Tables, entry)
+				mu.Unlock()
+			}
+
+			return nil
+		})
+	}
+
+	// Nothing is written, so the transactions are rolled back.
+	for _, repo := range repos {
+		if rerr := repo.Rollback(); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(manifest.Tables, func(i, j int) bool { return manifest.Tables[i].File < manifest.Tables[j].File })
+
+	if err := writeSnapshotManifest(dir, manifest); err != nil {
+		return nil, err
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg:      fmt.Sprintf("exported %d tables to snapshot %q", len(manifest.Tables), dir),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return manifest, nil
+}
+
+// writeSnapshotManifest will write the manifest of a snapshot once its files are written, so that a snapshot with a
+// manifest is complete.
+func writeSnapshotManifest(dir string, manifest *SnapshotManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode snapshot manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, snapshotDirMode); err != nil {
+		return fmt.Errorf("unable to create snapshot directory: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, snapshotManifestName), append(data, '\n'), snapshotFileMode); err != nil {
+		return fmt.Errorf("unable to write snapshot manifest: %w", err)
+	}
+
+	return nil
+}
+
+// readSnapshot will read the manifest of a snapshot and the records of its tables, by file, checking each file against
+// its checksum and record count.
+func readSnapshot(dir string) (*SnapshotManifest, map[string][]json.RawMessage, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotManifestName))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read snapshot manifest: %w", err)
+	}
+
+	manifest := new(SnapshotManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, nil, CorruptSnapshotError(snapshotManifestName, err.Error())
+	}
+
+	if manifest.Version != snapshotVersion {
+		return nil, nil, CorruptSnapshotError(snapshotManifestName,
+			fmt.Sprintf("unsupported version %d", manifest.Version))
+	}
+
+	if manifest.Format != SnapshotFormatNDJSON {
+		return nil, nil, CorruptSnapshotError(snapshotManifestName,
+			fmt.Sprintf("unsupported format %q", manifest.Format))
+	}
+
+	records := make(map[string][]json.RawMessage, len(manifest.Tables))
+
+	for _, entry := range manifest.Tables {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(entry.File)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read snapshot: %w", err)
+		}
+
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != entry.SHA256 {
+			return nil, nil, CorruptSnapshotError(entry.File, "checksum mismatch")
+		}
+
+		tableRecords, err := decodeSnapshotRecords(entry.File, data)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if int64(len(tableRecords)) != entry.Records {
+			return nil, nil, CorruptSnapshotError(entry.File,
+				fmt.Sprintf("%d records, manifest has %d", len(tableRecords), entry.Records))
+		}
+
+		records[entry.File] = tableRecords
+	}
+
+	return manifest, records, nil
+}
+
+// snapshotEntries will return the entries of a snapshot to import into a storage device, one per table: the entry
+// exported from a storage device with the same scheme, or else the first entry of the table.
+func snapshotEntries(manifest *SnapshotManifest, scheme string) []*SnapshotTable {
+	entries := make(map[string]*SnapshotTable)
+
+	var tables []string
+
+	for _, entry := range manifest.Tables {
+		current, ok := entries[entry.Table]
+		if !ok {
+			tables = append(tables, entry.Table)
+		}
+
+		if !ok || (current.Storage != scheme && entry.Storage == scheme) {
+			entries[entry.Table] = entry
+		}
+	}
+
+	selected := make([]*SnapshotTable, len(tables))
+	for idx, table := range tables {
+		selected[idx] = entries[table]
+	}
+
+	return selected
+}
+
+// snapshotChunk is a batch of the records of a snapshot table, which a flattened request imports instead of making
+// its web request.
+type snapshotChunk struct {
+	manifest *SnapshotManifest
+	entry    *SnapshotTable
+	url      *url.URL
+	records  []json.RawMessage
+
+	// cursor reads the records of the chunk from the source of a copy when it is imported, if any.
+	cursor *copyCursor
+}
+
+// read will return the records of the chunk as a JSON list, along with a request for the snapshot file that holds
+// them.
+func (chunk *snapshotChunk) read(ctx context.Context) ([]byte, *http.Request, error) {
+	records := chunk.records
+
+	if chunk.cursor != nil {
+		var err error
+		if records, err = chunk.cursor.next(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	raw := make([]string, len(records))
+	for idx, record := range records {
+		raw[idx] = string(record)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, chunk.url.String(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to create snapshot request: %w", err)
+	}
+
+	return []byte("[" + strings.Join(raw, ",") + "]"), req, nil
+}
+
+// importedBy will return true if the chunk is imported into a storage device with the scheme. Chunks of tables that a
+// snapshot holds for several storage devices are only imported by the storage devices that select their entry. A nil
+// chunk is imported by every storage device.
+func (chunk *snapshotChunk) importedBy(scheme string) bool {
+	if chunk == nil {
+		return true
+	}
+
+	for _, entry := range snapshotEntries(chunk.manifest, scheme) {
+		if entry == chunk.entry {
+			return true
+		}
+	}
+
+	return false
+}
+
+// snapshotURLs will return the URLs of the files of a snapshot, by file.
+func snapshotURLs(dir string, manifest *SnapshotManifest) (map[string]*url.URL, error) {
+	urls := make(map[string]*url.URL, len(manifest.Tables))
+
+	for _, entry := range manifest.Tables {
+		abs, err := filepath.Abs(filepath.Join(dir, filepath.FromSlash(entry.File)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve snapshot path: %w", err)
+		}
+
+		urls[entry.File] = &url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}
+	}
+
+	return urls, nil
+}
+
+// flattenSnapshot will convert the tables of a snapshot into flattened requests that import their records in batches,
+// where the records and URLs of the tables are keyed by their files. The records of a snapshot were transformed by the
+// run that stored them, so the storage options of the configured requests do not apply.
+func (cfg *Config) flattenSnapshot(manifest *SnapshotManifest, records map[string][]json.RawMessage,
+	urls map[string]*url.URL,
+) []*flattenedRequest {
+	var flattenedRequests []*flattenedRequest
+
+	for _, entry := range manifest.Tables {
+		tableRecords := records[entry.File]
+		budget := newErrorBudget(cfg.ErrorBudget, (len(tableRecords)+snapshotBatchSize-1)/snapshotBatchSize)
+
+		for start := 0; start < len(tableRecords); start += snapshotBatchSize {
+			end := start + snapshotBatchSize
+			if end > len(tableRecords) {
+				end = len(tableRecords)
+			}
+
+			flattenedRequests = append(flattenedRequests, &flattenedRequest{
+				table:   entry.Table,
+				budget:  budget,
+				noCache: true,
+				snapshot: &snapshotChunk{
+					manifest: manifest,
+					entry:    entry,
+					url:      urls[entry.File],
+					records:  tableRecords[start:end],
+				},
+				storageOptions: new(storageOptions),
+			})
+		}
+	}
+
+	return flattenedRequests
+}
+
+// ImportSnapshot will upsert the tables of a snapshot into every storage device in the configuration through the
+// upsert pipeline of a run, so that the storage devices are written with the same transactions, flush policies,
+// journals, and audit log as a transport operation. The files of the snapshot are checked against the checksums of its
+// manifest before anything is written. Each storage device imports the tables that were exported from a storage device
+// with the same scheme, falling back to the tables of another scheme, so that a snapshot of one storage device can
+// seed any other.
+func ImportSnapshot(ctx context.Context, cfg *Config, dir string) error {
+	start := time.Now()
+
+	manifest, records, err := readSnapshot(dir)
+	if err != nil {
+		return err
+	}
+
+	urls, err := snapshotURLs(dir, manifest)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.importRecords(ctx, cfg.flattenSnapshot(manifest, records, urls)); err != nil {
+		return err
+	}
+
+	logInfo := tools.LogFormatter{
+		Duration: time.Since(start),
+		Msg:      fmt.Sprintf("imported %d tables from snapshot %q", len(manifest.Tables), dir),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	return nil
+}
+
+// importRecords will upsert the flattened requests of snapshot chunks through the upsert pipeline.
+func (cfg *Config) importRecords(ctx context.Context, flattenedRequests []*flattenedRequest) error {
+	if len(flattenedRequests) == 0 {
+		return nil
+	}
+
+	cfg.importing = true
+
+	defer func() {
+		cfg.importing = false
+	}()
+
+	return upsertFlattenedRequests(ctx, cfg, flattenedRequests, cfg.runID(), nil)
+}
@@ -0,0 +1,239 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	// writeSnapshot will write a snapshot of a table with the records to a new directory.
+	writeSnapshot := func(t *testing.T, maps ...map[string]interface{}) (string, *SnapshotManifest) {
+		t.Helper()
+
+		stored := &storage.StoredRecords{PrimaryKeys: []string{"id"}}
+
+		for _, m := range maps {
+			record, err := structpb.NewStruct(m)
+			if err != nil {
+				t.Fatalf("unable to create record: %v", err)
+			}
+
+			stored.Records = append(stored.Records, record)
+		}
+
+		dir := t.TempDir()
+
+		entry, err := writeSnapshotTable(dir, "postgresql", "postgresql", "accounts", stored)
+		if err != nil {
+			t.Fatalf("unable to write snapshot table: %v", err)
+		}
+
+		manifest := &SnapshotManifest{
+			Version:   snapshotVersion,
+			CreatedAt: time.Now().UTC(),
+			Format:    SnapshotFormatNDJSON,
+			Tables:    []*SnapshotTable{entry},
+		}
+
+		if err := writeSnapshotManifest(dir, manifest); err != nil {
+			t.Fatalf("unable to write snapshot manifest: %v", err)
+		}
+
+		return dir, manifest
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		t.Parallel()
+
+		dir, _ := writeSnapshot(t, map[string]interface{}{"id": 1, "name": "a"}, map[string]interface{}{"id": 2})
+
+		manifest, records, err := readSnapshot(dir)
+		if err != nil {
+			t.Fatalf("unable to read snapshot: %v", err)
+		}
+
+		entry := manifest.Tables[0]
+		if entry.File != "postgresql/accounts.ndjson" || entry.Records != 2 {
+			t.Fatalf("unexpected manifest entry: %+v", entry)
+		}
+
+		if !reflect.DeepEqual(entry.PrimaryKeys, []string{"id"}) {
+			t.Fatalf("expected primary keys [id], got %v", entry.PrimaryKeys)
+		}
+
+		urls, err := snapshotURLs(dir, manifest)
+		if err != nil {
+			t.Fatalf("unable to resolve snapshot urls: %v", err)
+		}
+
+		flattenedRequests := (&Config{}).flattenSnapshot(manifest, records, urls)
+
+		if len(flattenedRequests) != 1 {
+			t.Fatalf("expected 1 flattened request, got %d", len(flattenedRequests))
+		}
+
+		data, _, err := flattenedRequests[0].snapshot.read(context.Background())
+		if err != nil {
+			t.Fatalf("unable to read snapshot chunk: %v", err)
+		}
+
+		decoded, err := tools.DecodeUpsertRecords(&proto.UpsertRequest{Data: data})
+		if err != nil {
+			t.Fatalf("unable to decode records: %v", err)
+		}
+
+		if len(decoded) != 2 || decoded[0].GetFields()["name"].GetStringValue() != "a" {
+			t.Fatalf("unexpected records: %v", decoded)
+		}
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		t.Parallel()
+
+		dir, manifest := writeSnapshot(t, map[string]interface{}{"id": 1})
+
+		name := filepath.Join(dir, filepath.FromSlash(manifest.Tables[0].File))
+		if err := os.WriteFile(name, []byte(`{"id":2}`+"\n"), snapshotFileMode); err != nil {
+			t.Fatalf("unable to modify snapshot: %v", err)
+		}
+
+		err := ImportSnapshot(context.Background(), &Config{Logger: logger}, dir)
+		if !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("expected error %v, got %v", ErrCorruptSnapshot, err)
+		}
+	})
+
+	t.Run("imports through the upsert pipeline", func(t *testing.T) {
+		t.Parallel()
+
+		dir, _ := writeSnapshot(t, map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2})
+
+		if err := ImportSnapshot(context.Background(), &Config{Logger: logger}, dir); err != nil {
+			t.Fatalf("unable to import snapshot: %v", err)
+		}
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		t.Parallel()
+
+		dir, manifest := writeSnapshot(t)
+
+		manifest.Version = snapshotVersion + 1
+		if err := writeSnapshotManifest(dir, manifest); err != nil {
+			t.Fatalf("unable to write snapshot manifest: %v", err)
+		}
+
+		if _, _, err := readSnapshot(dir); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Fatalf("expected error %v, got %v", ErrCorruptSnapshot, err)
+		}
+	})
+}
+
+func TestSnapshotEntries(t *testing.T) {
+	t.Parallel()
+
+	manifest := &SnapshotManifest{Tables: []*SnapshotTable{
+		{Storage: "mongodb", Table: "accounts", File: "mongodb/accounts.ndjson"},
+		{Storage: "postgresql", Table: "accounts", File: "postgresql/accounts.ndjson"},
+		{Storage: "mongodb", Table: "orders", File: "mongodb/orders.ndjson"},
+	}}
+
+	for _, tcase := range []struct {
+		scheme   string
+		expected []string
+	}{
+		{"postgresql", []string{"postgresql/accounts.ndjson", "mongodb/orders.ndjson"}},
+		{"mongodb", []string{"mongodb/accounts.ndjson", "mongodb/orders.ndjson"}},
+		{"sqlite", []string{"mongodb/accounts.ndjson", "mongodb/orders.ndjson"}},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.scheme, func(t *testing.T) {
+			t.Parallel()
+
+			var files []string
+			for _, entry := range snapshotEntries(manifest, tcase.scheme) {
+				files = append(files, entry.File)
+			}
+
+			if !reflect.DeepEqual(files, tcase.expected) {
+				t.Fatalf("expected %v, got %v", tcase.expected, files)
+			}
+		})
+	}
+}
+
+func TestSnapshotChunks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	stored := &storage.StoredRecords{PrimaryKeys: []string{"id"}}
+	for idx := 0; idx < snapshotBatchSize+1; idx++ {
+		stored.Records = append(stored.Records, &structpb.Struct{Fields: map[string]*structpb.Value{
+			"id": structpb.NewNumberValue(float64(idx)),
+		}})
+	}
+
+	entry, err := writeSnapshotTable(dir, "mongodb", "mongodb", "accounts", stored)
+	if err != nil {
+		t.Fatalf("unable to write snapshot table: %v", err)
+	}
+
+	manifest := &SnapshotManifest{Version: snapshotVersion, Format: SnapshotFormatNDJSON, Tables: []*SnapshotTable{entry}}
+	if err := writeSnapshotManifest(dir, manifest); err != nil {
+		t.Fatalf("unable to write snapshot manifest: %v", err)
+	}
+
+	manifest, records, err := readSnapshot(dir)
+	if err != nil {
+		t.Fatalf("unable to read snapshot: %v", err)
+	}
+
+	urls, err := snapshotURLs(dir, manifest)
+	if err != nil {
+		t.Fatalf("unable to resolve snapshot urls: %v", err)
+	}
+
+	flattenedRequests := (&Config{}).flattenSnapshot(manifest, records, urls)
+
+	if len(flattenedRequests) != 2 {
+		t.Fatalf("expected 2 chunks, got %d", len(flattenedRequests))
+	}
+
+	chunk := flattenedRequests[1].snapshot
+	if len(chunk.records) != 1 {
+		t.Fatalf("expected the last chunk to have 1 record, got %d", len(chunk.records))
+	}
+
+	// A snapshot with a single entry for the table seeds every storage device.
+	for _, scheme := range []string{"mongodb", "postgresql"} {
+		if !chunk.importedBy(scheme) {
+			t.Fatalf("expected the chunk to be imported by %q", scheme)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"net/http"
+	"time"
+)
+
+const (
+	// defaultIngestedAtField is the default field of the time a record was ingested.
+	defaultIngestedAtField = "_ingested_at"
+
+	// defaultSourceEndpointField is the default field of the endpoint a record was fetched from.
+	defaultSourceEndpointField = "_source_endpoint"
+)
+
+// StampConfig stamps every record with metadata about its ingestion before it is upserted, e.g. for debugging and
+// freshness checks. On SQL storage the columns must exist on the table, otherwise the fields are ignored.
+type StampConfig struct {
+	// IngestedAt is the field of the time the record was ingested, the default is "_ingested_at". Set it to an empty
+	// string to not stamp the time.
+	IngestedAt *string `yaml:"ingestedAt"`
+
+	// SourceEndpoint is the field of the endpoint path that the record was fetched from, the default is
+	// "_source_endpoint". Set it to an empty string to not stamp the endpoint.
+	SourceEndpoint *string `yaml:"sourceEndpoint"`
+
+	// RunID is the field of the ID of the transport operation that ingested the record, e.g. "_run_id". The run ID is
+	// not stamped by default.
+	RunID string `yaml:"runId"`
+}
+
+// fields will return the fields to stamp on the records of a response received at the given time by a transport
+// operation. If stamping is not configured, there are no fields.
+func (sc *StampConfig) fields(at time.Time, req *http.Request, runID string) map[string]interface{} {
+	if sc == nil {
+		return nil
+	}
+
+	fields := make(map[string]interface{})
+
+	field := func(configured *string, defaultField string) string {
+		if configured == nil {
+			return defaultField
+		}
+
+		return *configured
+	}
+
+	if name := field(sc.IngestedAt, defaultIngestedAtField); name != "" {
+		fields[name] = at.UTC().Format(time.RFC3339Nano)
+	}
+
+	if name := field(sc.SourceEndpoint, defaultSourceEndpointField); name != "" {
+		fields[name] = req.URL.Path
+	}
+
+	if sc.RunID != "" {
+		fields[sc.RunID] = runID
+	}
+
+	return fields
+}
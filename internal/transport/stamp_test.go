@@ -0,0 +1,59 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStampConfig(t *testing.T) {
+	t.Parallel()
+
+	at := time.Date(2022, 5, 10, 1, 2, 3, 0, time.FixedZone("EST", -5*60*60))
+	req, _ := http.NewRequest(http.MethodGet, "https://api.test/products/BTC-USD/candles?start=1", nil)
+
+	disabled, renamed := "", "fetched_from"
+
+	for _, tcase := range []struct {
+		name     string
+		cfg      *StampConfig
+		expected map[string]interface{}
+	}{
+		{
+			name: "defaults",
+			cfg:  &StampConfig{},
+			expected: map[string]interface{}{
+				"_ingested_at":     "2022-05-10T06:02:03Z",
+				"_source_endpoint": "/products/BTC-USD/candles",
+			},
+		},
+		{
+			name:     "renamed and disabled",
+			cfg:      &StampConfig{IngestedAt: &disabled, SourceEndpoint: &renamed},
+			expected: map[string]interface{}{"fetched_from": "/products/BTC-USD/candles"},
+		},
+		{
+			name:     "run id",
+			cfg:      &StampConfig{IngestedAt: &disabled, SourceEndpoint: &disabled, RunID: "_run_id"},
+			expected: map[string]interface{}{"_run_id": "run"},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if fields := tcase.cfg.fields(at, req, "run"); !reflect.DeepEqual(fields, tcase.expected) {
+				t.Fatalf("expected fields %v, got %v", tcase.expected, fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
+)
+
+// SurrogateKeyFormat is the format of the surrogate keys of a table.
+type SurrogateKeyFormat string
+
+const (
+	// SurrogateKeyUUID formats the keys as UUIDv5s in the surrogate key namespace.
+	SurrogateKeyUUID SurrogateKeyFormat = "uuid"
+
+	// SurrogateKeySHA256 formats the keys as hex encoded SHA-256 hashes.
+	SurrogateKeySHA256 SurrogateKeyFormat = "sha256"
+)
+
+// surrogateKeyNamespace is the namespace of the UUIDv5 surrogate keys, so that the keys of the same content are the
+// same on every run.
+var surrogateKeyNamespace = uuid.NewSHA1(uuid.NameSpaceURL,
+	[]byte("https://github.com/alpine-hodler/gidari/surrogate-key"))
+
+var ErrInvalidSurrogateKey = fmt.Errorf("invalid surrogate key")
+
+// InvalidSurrogateKeyError wraps an error with ErrInvalidSurrogateKey.
+func InvalidSurrogateKeyError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSurrogateKey, reason)
+}
+
+// SurrogateKeyConfig generates a key for each record of a table without a natural key, derived from the content of
+// the record, so that upserting the same record again on a later run updates it instead of duplicating it. The key
+// is derived from the JSON encoding of the fields with sorted names, and numbers with their exact text. The table's
+// primary key, or the "_id" of a MongoDB collection, should be the key field.
+type SurrogateKeyConfig struct {
+	// Field is the field that the key is set on, e.g. "id". A value of the field on the fetched record is replaced.
+	Field string `yaml:"field"`
+
+	// Fields are the fields that the key is derived from, e.g. the fields that identify a record. Records that are
+	// missing some of the fields are keyed by the others. Defaults to every field of the record, which only keys
+	// records as the same if they are identical.
+	Fields []string `yaml:"fields"`
+
+	// Format is the format of the keys: "uuid" for a UUIDv5 of the fields, or "sha256" for the hex encoded SHA-256
+	// hash of the fields. Defaults to "uuid".
+	Format SurrogateKeyFormat `yaml:"format"`
+}
+
+func (skc *SurrogateKeyConfig) validate() error {
+	if skc == nil {
+		return nil
+	}
+
+	if skc.Field == "" {
+		return InvalidSurrogateKeyError("field is required")
+	}
+
+	for _, field := range skc.Fields {
+		if field == skc.Field {
+			return InvalidSurrogateKeyError(fmt.Sprintf("%q can not be derived from itself", field))
+		}
+	}
+
+	switch skc.Format {
+	case "", SurrogateKeyUUID, SurrogateKeySHA256:
+	default:
+		return InvalidSurrogateKeyError(fmt.Sprintf("unsupported format %q", skc.Format))
+	}
+
+	return nil
+}
+
+// key will return the surrogate key of a record, given the fields that are set on the record after the key, e.g. the
+// param fields of the request, which identify the record as well.
+func (skc *SurrogateKeyConfig) key(rec, fields map[string]interface{}) (string, error) {
+	keyed := make(map[string]interface{}, len(rec)+len(fields))
+
+	if len(skc.Fields) == 0 {
+		for field, value := range rec {
+			keyed[field] = value
+		}
+
+		for field, value := range fields {
+			keyed[field] = value
+		}
+	}
+
+	for _, field := range skc.Fields {
+		if value, ok := fields[field]; ok {
+			keyed[field] = value
+		} else if value, ok := rec[field]; ok {
+			keyed[field] = value
+		}
+	}
+
+	// The key field is not part of its own key, so that re-keying a stored record gives the same key.
+	delete(keyed, skc.Field)
+
+	data, err := json.Marshal(keyed)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	if skc.Format == SurrogateKeySHA256 {
+		sum := sha256.Sum256(data)
+
+		return hex.EncodeToString(sum[:]), nil
+	}
+
+	return uuid.NewSHA1(surrogateKeyNamespace, data).String(), nil
+}
+
+// apply will set the surrogate key on every record of JSON encoded upsert data. The fields are set on the records
+// after the key, e.g. the param fields of the request, and are part of the key.
+func (skc *SurrogateKeyConfig) apply(data []byte, fields map[string]interface{}) ([]byte, error) {
+	if skc == nil {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		key, err := skc.key(rec, fields)
+		if err != nil {
+			return nil, err
+		}
+
+		rec[skc.Field] = key
+	}
+
+	keyedData, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return keyedData, nil
+}
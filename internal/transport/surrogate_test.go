@@ -0,0 +1,129 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestSurrogateKeyConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		key  *SurrogateKeyConfig
+		err  error
+	}{
+		{name: "nil"},
+		{name: "valid", key: &SurrogateKeyConfig{Field: "id", Fields: []string{"symbol", "time"}, Format: "sha256"}},
+		{name: "no field", key: &SurrogateKeyConfig{Fields: []string{"symbol"}}, err: ErrInvalidSurrogateKey},
+		{
+			name: "derived from itself",
+			key:  &SurrogateKeyConfig{Field: "id", Fields: []string{"id"}},
+			err:  ErrInvalidSurrogateKey,
+		},
+		{name: "unsupported format", key: &SurrogateKeyConfig{Field: "id", Format: "md5"}, err: ErrInvalidSurrogateKey},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := tcase.key.validate(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestSurrogateKey(t *testing.T) {
+	t.Parallel()
+
+	uuidPattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-5[0-9a-f]{3}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+	sha256Pattern := regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+	for _, tcase := range []struct {
+		name    string
+		key     *SurrogateKeyConfig
+		data    string
+		params  map[string]interface{}
+		pattern *regexp.Regexp
+
+		// same are the indexes of the records that get the same key as the first record.
+		same []int
+	}{
+		{
+			name:    "entire record",
+			key:     &SurrogateKeyConfig{Field: "id"},
+			data:    `[{"a":1,"b":"x"},{"b":"x","a":1},{"a":1.0,"b":"x"},{"a":1,"b":"x","id":"old"},{"a":2,"b":"x"}]`,
+			pattern: uuidPattern,
+			same:    []int{1, 3},
+		},
+		{
+			name:    "selected fields",
+			key:     &SurrogateKeyConfig{Field: "id", Fields: []string{"symbol", "time"}, Format: SurrogateKeySHA256},
+			data:    `[{"symbol":"BTC","time":1,"price":1},{"symbol":"BTC","time":1,"price":2},{"symbol":"ETH","time":1}]`,
+			pattern: sha256Pattern,
+			same:    []int{1},
+		},
+		{
+			name:    "param fields",
+			key:     &SurrogateKeyConfig{Field: "id", Fields: []string{"product_id", "time"}},
+			data:    `[{"time":1},{"time":1,"product_id":"ETH-USD"},{"time":2}]`,
+			params:  map[string]interface{}{"product_id": "BTC-USD"},
+			pattern: uuidPattern,
+			same:    []int{1},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := tcase.key.apply([]byte(tcase.data), tcase.params)
+			if err != nil {
+				t.Fatalf("error applying surrogate key: %v", err)
+			}
+
+			// Keying the keyed records again must give the same keys, so that upserts are idempotent.
+			again, err := tcase.key.apply(data, tcase.params)
+			if err != nil {
+				t.Fatalf("error applying surrogate key: %v", err)
+			}
+
+			if string(again) != string(data) {
+				t.Fatalf("expected the keys to be stable, got %s and %s", data, again)
+			}
+
+			var records []map[string]interface{}
+			if err := json.Unmarshal(data, &records); err != nil {
+				t.Fatalf("error decoding records: %v", err)
+			}
+
+			first, _ := records[0][tcase.key.Field].(string)
+			if !tcase.pattern.MatchString(first) {
+				t.Fatalf("expected a key matching %s, got %q", tcase.pattern, first)
+			}
+
+			same := map[int]bool{0: true}
+			for _, idx := range tcase.same {
+				same[idx] = true
+			}
+
+			for idx, record := range records {
+				if (record[tcase.key.Field] == first) != same[idx] {
+					t.Fatalf("expected record %d to have the same key as the first record: %v, got %v and %v", idx,
+						same[idx], record[tcase.key.Field], first)
+				}
+			}
+		})
+	}
+}
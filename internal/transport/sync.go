@@ -0,0 +1,228 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+const (
+	// defaultSyncMarkerField is the default field that records the system a synced record originated in.
+	defaultSyncMarkerField = "_sync_origin"
+
+	// defaultSyncVersionField is the default field that holds the version of a synced record for conflict resolution.
+	defaultSyncVersionField = "_sync_version"
+
+	// syncTimeLayout is a fixed width layout of UTC times, so that the versions of synced records compare in order as
+	// strings.
+	syncTimeLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// The conflict policies of synced records.
+const (
+	SyncConflictLatestWins     = "latestWins"
+	SyncConflictSourcePriority = "sourcePriority"
+)
+
+var ErrInvalidSync = fmt.Errorf("invalid sync")
+
+// InvalidSyncError wraps an error with ErrInvalidSync.
+func InvalidSyncError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSync, reason)
+}
+
+// SyncConfig configures a table that is synced in both directions between two systems, e.g. a web API and a database,
+// or two databases with a gidari run in each direction. Records are marked with the system they originated in, so
+// that a record is not synced back to where it came from, and conflicting writes of a record are resolved by a policy.
+type SyncConfig struct {
+	// Origin is the name of the system that the records are read from, e.g. "crm". It is set in the marker field of
+	// records that do not have one.
+	Origin string `yaml:"origin"`
+
+	// Target is the name of the system that the records are written to, e.g. "warehouse". Records whose marker is the
+	// target originated there and were synced to the origin by the other direction, so they are dropped rather than
+	// written back.
+	Target string `yaml:"target"`
+
+	// MarkerField is the field of the records that holds the system they originated in. The other direction of the
+	// sync must copy it. Defaults to "_sync_origin".
+	MarkerField string `yaml:"markerField"`
+
+	// Conflict is the policy for records that were changed in both systems: "latestWins" keeps the record with the
+	// latest timestamp, and "sourcePriority" keeps the record of the origin listed first in "priority", breaking ties
+	// with the latest timestamp. By default the written record always wins.
+	Conflict string `yaml:"conflict"`
+
+	// TimestampField is the field of the records that holds the time they were last changed, as an RFC 3339 string or
+	// a number of seconds since the epoch. Required by the conflict policies. Records without it lose every conflict.
+	TimestampField string `yaml:"timestampField"`
+
+	// Priority are the names of the systems from the highest to the lowest priority, for the "sourcePriority" policy.
+	// Records of systems that are not listed have the lowest priority.
+	Priority []string `yaml:"priority"`
+
+	// VersionField is the field that the version of the records is stored in, which a stored record must be older than
+	// to be overwritten. Defaults to "_sync_version".
+	VersionField string `yaml:"versionField"`
+}
+
+func (sc *SyncConfig) validate(versionField string) error {
+	if sc == nil {
+		return nil
+	}
+
+	if sc.Origin == "" {
+		return InvalidSyncError("origin is required")
+	}
+
+	if sc.Origin == sc.Target {
+		return InvalidSyncError("origin and target must be different systems")
+	}
+
+	switch sc.Conflict {
+	case "":
+		return nil
+	case SyncConflictLatestWins:
+	case SyncConflictSourcePriority:
+		if len(sc.Priority) == 0 {
+			return InvalidSyncError("priority is required by the sourcePriority conflict policy")
+		}
+	default:
+		return InvalidSyncError(fmt.Sprintf("unknown conflict policy %q", sc.Conflict))
+	}
+
+	if sc.TimestampField == "" {
+		return InvalidSyncError("timestampField is required by a conflict policy")
+	}
+
+	if versionField != "" {
+		return InvalidSyncError("versionField of the request can not be used with a conflict policy")
+	}
+
+	return nil
+}
+
+func (sc *SyncConfig) markerField() string {
+	if sc.MarkerField == "" {
+		return defaultSyncMarkerField
+	}
+
+	return sc.MarkerField
+}
+
+// versionField will return the field that the versions of the records are stored in, if they are resolved by a
+// conflict policy.
+func (sc *SyncConfig) versionField() string {
+	if sc == nil || sc.Conflict == "" {
+		return ""
+	}
+
+	if sc.VersionField == "" {
+		return defaultSyncVersionField
+	}
+
+	return sc.VersionField
+}
+
+// timestamp will return the time of a record's timestamp field, or the zero time if it has none.
+func (sc *SyncConfig) timestamp(rec map[string]interface{}) time.Time {
+	value := rec[sc.TimestampField]
+
+	if t, ok := ruleTime(value); ok {
+		return t.UTC()
+	}
+
+	if seconds, ok := ruleFloat(value); ok {
+		return time.Unix(0, int64(seconds*float64(time.Second))).UTC()
+	}
+
+	return time.Time{}
+}
+
+// version will return the version of a record, which is greater for the record that wins a conflict.
+func (sc *SyncConfig) version(rec map[string]interface{}, origin string) string {
+	timestamp := sc.timestamp(rec).Format(syncTimeLayout)
+
+	if sc.Conflict != SyncConflictSourcePriority {
+		return timestamp
+	}
+
+	rank := 0
+
+	for idx, system := range sc.Priority {
+		if system == origin {
+			rank = len(sc.Priority) - idx
+
+			break
+		}
+	}
+
+	return fmt.Sprintf("%04d/%s", rank, timestamp)
+}
+
+// apply will drop the records that originated in the target, mark the records with their origin, and set the version
+// of the records for the conflict policy.
+func (sc *SyncConfig) apply(data []byte) ([]byte, error) {
+	if sc == nil {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	markerField := sc.markerField()
+	versionField := sc.versionField()
+	synced := make([]interface{}, 0, len(records))
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			synced = append(synced, record)
+
+			continue
+		}
+
+		origin, _ := rec[markerField].(string)
+		if origin == "" {
+			origin = sc.Origin
+			rec[markerField] = origin
+		}
+
+		if sc.Target != "" && origin == sc.Target {
+			continue
+		}
+
+		if versionField != "" {
+			rec[versionField] = sc.version(rec, origin)
+		}
+
+		synced = append(synced, rec)
+	}
+
+	syncedData, err := json.Marshal(synced)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return syncedData, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+)
+
+func TestSyncApply(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`[
+		{"id": 1, "updated": "2022-05-10T00:00:01Z"},
+		{"id": 2, "updated": "2022-05-10T00:00:02.5Z", "_sync_origin": "billing"},
+		{"id": 3, "updated": 1652140800, "_sync_origin": "warehouse"},
+		{"id": 4}
+	]`)
+
+	for _, tcase := range []struct {
+		name     string
+		sync     *SyncConfig
+		ids      []float64
+		versions []interface{}
+	}{
+		{
+			name:     "loop prevention",
+			sync:     &SyncConfig{Origin: "crm", Target: "warehouse"},
+			ids:      []float64{1, 2, 4},
+			versions: []interface{}{nil, nil, nil},
+		},
+		{
+			name: "latest wins",
+			sync: &SyncConfig{
+				Origin: "crm", Target: "warehouse", Conflict: SyncConflictLatestWins,
+				TimestampField: "updated",
+			},
+			ids: []float64{1, 2, 4},
+			versions: []interface{}{
+				"2022-05-10T00:00:01.000000000Z",
+				"2022-05-10T00:00:02.500000000Z",
+				"0001-01-01T00:00:00.000000000Z",
+			},
+		},
+		{
+			name: "source priority",
+			sync: &SyncConfig{
+				Origin: "crm", Conflict: SyncConflictSourcePriority, TimestampField: "updated",
+				Priority: []string{"billing", "crm"},
+			},
+			ids: []float64{1, 2, 3, 4},
+			versions: []interface{}{
+				"0001/2022-05-10T00:00:01.000000000Z",
+				"0002/2022-05-10T00:00:02.500000000Z",
+				"0000/2022-05-10T00:00:00.000000000Z",
+				"0001/0001-01-01T00:00:00.000000000Z",
+			},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			synced, err := tcase.sync.apply(data)
+			if err != nil {
+				t.Fatalf("error applying sync: %v", err)
+			}
+
+			var records []map[string]interface{}
+			if err := json.Unmarshal(synced, &records); err != nil {
+				t.Fatalf("error decoding records: %v", err)
+			}
+
+			if len(records) != len(tcase.ids) {
+				t.Fatalf("expected %d records, got %s", len(tcase.ids), synced)
+			}
+
+			for idx, rec := range records {
+				if rec["id"] != tcase.ids[idx] {
+					t.Fatalf("expected record %v, got %v", tcase.ids[idx], rec)
+				}
+
+				if rec["_sync_origin"] == nil {
+					t.Fatalf("expected the record to be marked with its origin, got %v", rec)
+				}
+
+				if rec["_sync_version"] != tcase.versions[idx] {
+					t.Fatalf("expected version %v, got %v", tcase.versions[idx], rec["_sync_version"])
+				}
+			}
+		})
+	}
+}
+
+func TestSyncVersionField(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /accounts
+    sync: {origin: crm, target: warehouse, conflict: latestWins, timestampField: updated, versionField: version}
+  - endpoint: /contacts
+    sync: {origin: crm, target: warehouse}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if field := cfg.Requests[0].storageOptions().versionField; field != "version" {
+		t.Fatalf("expected the conflict policy to set the version field, got %q", field)
+	}
+
+	if field := cfg.Requests[1].storageOptions().versionField; field != "" {
+		t.Fatalf("expected no version field without a conflict policy, got %q", field)
+	}
+
+	_, err = NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /accounts
+    versionField: updated
+    sync: {origin: crm, conflict: latestWins, timestampField: updated}
+`))
+	if !errors.Is(err, ErrInvalidSync) {
+		t.Fatalf("expected ErrInvalidSync, got %v", err)
+	}
+}
+
+func TestSyncVersionNotSupported(t *testing.T) {
+	t.Parallel()
+
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `[{"id":1,"updated":"2022-01-01T00:00:00Z"}]`)
+	}))
+	defer server.Close()
+
+	// Parquet files are appended to, so they can not keep the newer version of a record.
+	cfg, err := NewConfig([]byte(`
+url: ` + server.URL + `
+rateLimit: {burst: 1, period: 1ms}
+connectionStrings: ["parquet://` + t.TempDir() + `"]
+truncate: true
+requests:
+  - endpoint: /accounts
+    table: accounts
+    sync: {origin: crm, target: warehouse, conflict: latestWins, timestampField: updated}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if err := Upsert(context.Background(), cfg); !errors.Is(err, storage.ErrVersionNotSupported) {
+		t.Fatalf("expected error %v, got %v", storage.ErrVersionNotSupported, err)
+	}
+
+	if count := atomic.LoadInt32(&requests); count != 0 {
+		t.Fatalf("expected nothing to be fetched, got %d requests", count)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var ErrInvalidTail = fmt.Errorf("invalid tail configuration")
+
+// TailConfig is the live tail of a timeseries request: once the timeseries has been backfilled, the request is polled
+// at an interval for the data since its watermark, the end of the last range that was upserted, so that there are no
+// gaps between the backfill and the new data.
+type TailConfig struct {
+	// Interval is the time between polls.
+	Interval *time.Duration `yaml:"interval"`
+
+	// Lag is how far behind the current time each poll ends, for web APIs whose most recent data is incomplete.
+	Lag time.Duration `yaml:"lag"`
+}
+
+func (tc *TailConfig) validate() error {
+	if tc == nil {
+		return nil
+	}
+
+	if tc.Interval == nil || *tc.Interval <= 0 {
+		return fmt.Errorf("%w: interval must be positive", ErrInvalidTail)
+	}
+
+	if tc.Lag < 0 {
+		return fmt.Errorf("%w: lag must be non-negative", ErrInvalidTail)
+	}
+
+	return nil
+}
+
+// tailRequest is the state of a request's live tail.
+type tailRequest struct {
+	req       *Request
+	watermark time.Time
+	due       time.Time
+}
+
+// tailRequests will return the requests with a live tail, with their watermarks at the end of their backfills.
+func (cfg *Config) tailRequests() ([]*tailRequest, error) {
+	var tails []*tailRequest
+
+	for _, req := range cfg.Requests {
+		tail, err := cfg.newTail(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if tail != nil {
+			tails = append(tails, tail)
+		}
+	}
+
+	return tails, nil
+}
+
+// newTail will return the live tail of a request, with its watermark at the end of its backfill, or nil if the
+// request has no live tail.
+func (cfg *Config) newTail(req *Request) (*tailRequest, error) {
+	if req.Timeseries == nil || req.Timeseries.Tail == nil {
+		return nil, nil
+	}
+
+	// A backfill without chunks, e.g. that starts at its end, is tailed from its end.
+	if chunks := req.Timeseries.chunks; len(chunks) > 0 {
+		return &tailRequest{req: req, watermark: chunks[len(chunks)-1][1], due: time.Now()}, nil
+	}
+
+	end := req.Query[req.Timeseries.EndName]
+	if end == "" {
+		end = cfg.URL.Query().Get(req.Timeseries.EndName)
+	}
+
+	// The layout is defaulted when the timeseries is chunked, which a reloaded request has not been yet.
+	layout := time.RFC3339
+	if req.Timeseries.Layout != nil {
+		layout = *req.Timeseries.Layout
+	}
+
+	watermark, err := time.Parse(layout, end)
+	if err != nil {
+		return nil, UnableToParseError("endTime")
+	}
+
+	return &tailRequest{req: req, watermark: watermark, due: time.Now()}, nil
+}
+
+// next will return the request for the range from the watermark to the end, leaving the tailed request intact.
+func (tail *tailRequest) next(end time.Time) *Request {
+	return tail.req.withRange(tail.watermark, end)
+}
+
+// tail will poll the requests with a live tail until the context is done. Each poll upserts the data from a request's
+// watermark until the current time, less its lag, and then advances the watermark. If a poll fails, or any of its
+// chunks fail within the error budget, the watermark is held so that the range is polled again.
+func (cfg *Config) tail(ctx context.Context) error {
+	tails, err := cfg.tailRequests()
+	if err != nil || len(tails) == 0 {
+		return err
+	}
+
+	// The failed chunks of the backfill are kept, since the polls only refetch the data after the watermarks.
+	backfillFailures := cfg.FailedChunks
+	cfg.tailing = true
+
+	defer func() {
+		cfg.tailing = false
+		cfg.FailedChunks = backfillFailures
+	}()
+
+	cfg.Logger.Info(tools.LogFormatter{Msg: fmt.Sprintf("tailing %d requests", len(tails))}.String())
+
+	for {
+		due := tails[0].due
+		for _, tail := range tails[1:] {
+			if tail.due.Before(due) {
+				due = tail.due
+			}
+		}
+
+		timer := time.NewTimer(time.Until(due))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			cfg.Logger.Info(tools.LogFormatter{Msg: "tail stopped"}.String())
+
+			return nil
+		case <-cfg.reloads:
+			timer.Stop()
+
+			// A reload is applied between polls, so that it does not interrupt a poll in progress.
+			tails = cfg.reload(ctx, tails)
+
+			continue
+		case <-timer.C:
+		}
+
+		if err := cfg.poll(ctx, tails, time.Now()); err != nil && ctx.Err() == nil {
+			logWarn := tools.LogFormatter{Msg: fmt.Sprintf("tail poll failed, retrying at the next interval: %v", err)}
+			cfg.Logger.Warn(logWarn.String())
+		}
+	}
+}
+
+// poll will upsert the data since the watermarks of the requests that are due, advancing their watermarks if the
+// upsert succeeds.
+func (cfg *Config) poll(ctx context.Context, tails []*tailRequest, now time.Time) error {
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to web API: %w", err)
+	}
+
+	matchers, err := cfg.TruncatePolicy.matchers()
+	if err != nil {
+		return err
+	}
+
+	var (
+		flattenedRequests []*flattenedRequest
+		ranges            []*storage.TruncateRangeRequest
+		polled            = make(map[*tailRequest]time.Time)
+	)
+
+	for _, tail := range tails {
+		if now.Before(tail.due) {
+			continue
+		}
+
+		tail.due = now.Add(*tail.req.Timeseries.Tail.Interval)
+
+		end := now.Add(-tail.req.Timeseries.Tail.Lag)
+		if !tail.watermark.Before(end) {
+			continue
+		}
+
+		req := tail.next(end)
+
+		flatReqs, err := req.flattenTimeseries(*cfg.URL, client)
+		if err != nil {
+			return err
+		}
+
+		budget := newErrorBudget(req.ErrorBudget, len(flatReqs))
+		for _, flatReq := range flatReqs {
+			flatReq.budget = budget
+			flatReq.noCache = cfg.NoCache || req.NoCache
+		}
+
+		flattenedRequests = append(flattenedRequests, flatReqs...)
+		polled[tail] = end
+
+		if req.truncatesRange() {
+			allowed, err := cfg.allowTruncate(matchers, req.Table)
+			if err != nil {
+				return err
+			}
+
+			if allowed {
+				ranges = append(ranges, &storage.TruncateRangeRequest{
+					Table:    req.Table,
+					Column:   req.Timeseries.TruncateColumn,
+					Start:    tail.watermark,
+					End:      end,
+					Database: req.Database,
+				})
+			}
+		}
+	}
+
+	if len(flattenedRequests) == 0 {
+		return nil
+	}
+
+	if err := upsertFlattenedRequests(ctx, cfg, flattenedRequests, cfg.runID(), nil, ranges...); err != nil {
+		return err
+	}
+
+	failed := make(map[string]bool)
+	for _, chunk := range cfg.FailedChunks {
+		failed[chunk.Table] = true
+	}
+
+	for tail, end := range polled {
+		if failed[tail.req.Table] {
+			continue
+		}
+
+		tail.watermark = end
+	}
+
+	return nil
+}
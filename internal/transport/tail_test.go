@@ -0,0 +1,104 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTailRequests(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit:
+  burst: 1
+  period: 1s
+requests:
+  - endpoint: /candles
+    query:
+      start: "2022-05-10T00:00:00Z"
+      end: "2022-05-10T00:10:00Z"
+    timeseries:
+      startName: start
+      endName: end
+      period: 60
+      tail:
+        interval: 1m
+        lag: 30s
+  - endpoint: /ticker
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if _, err := cfg.flattenRequests(context.Background()); err != nil {
+		t.Fatalf("error flattening requests: %v", err)
+	}
+
+	tails, err := cfg.tailRequests()
+	if err != nil {
+		t.Fatalf("error getting tail requests: %v", err)
+	}
+
+	if len(tails) != 1 {
+		t.Fatalf("expected 1 tail request, got %d", len(tails))
+	}
+
+	// The tail continues from the end of the backfill.
+	backfillEnd := time.Date(2022, 5, 10, 0, 10, 0, 0, time.UTC)
+	if !tails[0].watermark.Equal(backfillEnd) {
+		t.Fatalf("expected watermark %v, got %v", backfillEnd, tails[0].watermark)
+	}
+
+	end := backfillEnd.Add(3 * time.Minute)
+	req := tails[0].next(end)
+
+	if req.Query["start"] != "2022-05-10T00:10:00Z" || req.Query["end"] != "2022-05-10T00:13:00Z" {
+		t.Fatalf("unexpected tail range: %v", req.Query)
+	}
+
+	// The tailed request is left intact.
+	if cfg.Requests[0].Query["start"] != "2022-05-10T00:00:00Z" || req.Timeseries == cfg.Requests[0].Timeseries {
+		t.Fatal("expected the tailed request to be left intact")
+	}
+}
+
+func TestTailConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		tail string
+		err  error
+	}{
+		{"valid", "{interval: 1m, lag: 30s}", nil},
+		{"missing interval", "{lag: 30s}", ErrInvalidTail},
+		{"negative lag", "{interval: 1m, lag: -1s}", ErrInvalidTail},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /candles
+    timeseries: {startName: start, endName: end, period: 60, tail: ` + tcase.tail + `}
+`))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+	"reflect"
+)
+
+var (
+	ErrTemplateNotFound = fmt.Errorf("request template not found")
+	ErrTemplateCycle    = fmt.Errorf("request template extends itself")
+)
+
+// TemplateNotFoundError is returned when a request template is not defined.
+func TemplateNotFoundError(name string) error {
+	return fmt.Errorf("%w: %s", ErrTemplateNotFound, name)
+}
+
+// TemplateCycleError is returned when a request template extends itself, directly or through other templates.
+func TemplateCycleError(name string) error {
+	return fmt.Errorf("%w: %s", ErrTemplateCycle, name)
+}
+
+// extendRequest will use a template as the default values for a request. Fields that are set on the request override
+// those of the template, except for maps, e.g. the query and headers, whose entries are merged with the request's
+// entries taking precedence. The structs that the template points to are copied, so that requests do not share them.
+func extendRequest(req, tmpl *Request) {
+	reqValue, tmplValue := reflect.ValueOf(req).Elem(), reflect.ValueOf(tmpl).Elem()
+
+	for idx := 0; idx < reqValue.NumField(); idx++ {
+		field, base := reqValue.Field(idx), tmplValue.Field(idx)
+		if !field.CanSet() || base.IsZero() {
+			continue
+		}
+
+		switch {
+		case field.Kind() == reflect.Map:
+			merged := reflect.MakeMapWithSize(field.Type(), base.Len()+field.Len())
+			for _, key := range base.MapKeys() {
+				merged.SetMapIndex(key, base.MapIndex(key))
+			}
+
+			for _, key := range field.MapKeys() {
+				merged.SetMapIndex(key, field.MapIndex(key))
+			}
+
+			field.Set(merged)
+		case !field.IsZero():
+		case field.Kind() == reflect.Ptr && base.Elem().Kind() == reflect.Struct:
+			copied := reflect.New(base.Elem().Type())
+			copied.Elem().Set(base.Elem())
+			field.Set(copied)
+		default:
+			field.Set(base)
+		}
+	}
+}
+
+// resolveTemplate will return a template with the defaults of the templates that it extends.
+func (cfg *Config) resolveTemplate(name string, seen map[string]bool) (*Request, error) {
+	tmpl, ok := cfg.Templates[name]
+	if !ok {
+		return nil, TemplateNotFoundError(name)
+	}
+
+	if seen[name] {
+		return nil, TemplateCycleError(name)
+	}
+
+	seen[name] = true
+
+	resolved := *tmpl
+	if resolved.Template != "" {
+		base, err := cfg.resolveTemplate(resolved.Template, seen)
+		if err != nil {
+			return nil, err
+		}
+
+		extendRequest(&resolved, base)
+	}
+
+	return &resolved, nil
+}
+
+// applyTemplates will use the request templates of the configuration as the default values for the requests that
+// extend them.
+func (cfg *Config) applyTemplates() error {
+	for _, req := range cfg.Requests {
+		if req.Template == "" {
+			continue
+		}
+
+		tmpl, err := cfg.resolveTemplate(req.Template, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+
+		extendRequest(req, tmpl)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRequestTemplates(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+version: 2
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+templates:
+  base:
+    method: POST
+    headers: {X-API-Version: "2"}
+    rateLimit: {burst: 5, period: 1s}
+    query: {limit: "100"}
+    decimalFields: [price]
+  markets:
+    template: base
+    headers: {X-Market: "{symbol}"}
+    params: {symbol: BTC-USD}
+requests:
+  - endpoint: /trades
+    table: trades
+    template: markets
+  - endpoint: /orders
+    table: orders
+    template: markets
+    method: GET
+    query: {limit: "10", status: open}
+    params: {symbol: ETH-USD}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	trades, orders := cfg.Requests[0], cfg.Requests[1]
+
+	if trades.Method != "POST" || orders.Method != "GET" {
+		t.Fatalf("expected methods POST and GET, got %q and %q", trades.Method, orders.Method)
+	}
+
+	if !reflect.DeepEqual(orders.Query, map[string]string{"limit": "10", "status": "open"}) {
+		t.Fatalf("expected the query of the request to take precedence, got %v", orders.Query)
+	}
+
+	if !reflect.DeepEqual(trades.DecimalFields, []string{"price"}) {
+		t.Fatalf("expected the decimal fields of the base template, got %v", trades.DecimalFields)
+	}
+
+	if trades.RateLimitConfig == orders.RateLimitConfig || *trades.RateLimitConfig.Burst != 5 {
+		t.Fatalf("expected a copy of the rate limit of the base template, got %+v", trades.RateLimitConfig)
+	}
+
+	header := orders.newFetchConfig(*cfg.URL, nil).Header
+	if header.Get("X-API-Version") != "2" || header.Get("X-Market") != "ETH-USD" {
+		t.Fatalf("expected the headers of the templates, got %v", header)
+	}
+}
+
+func TestRequestTemplateErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		yml  string
+		err  error
+	}{
+		{
+			name: "not found",
+			yml:  "requests: [{endpoint: /trades, template: missing}]",
+			err:  ErrTemplateNotFound,
+		},
+		{
+			name: "cycle",
+			yml:  "templates: {a: {template: b}, b: {template: a}}\nrequests: [{endpoint: /trades, template: a}]",
+			err:  ErrTemplateCycle,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if _, err := NewConfig([]byte(tcase.yml)); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
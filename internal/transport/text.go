@@ -0,0 +1,138 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/tools"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrInvalidNormalize = fmt.Errorf("invalid normalize")
+	ErrCharset          = fmt.Errorf("unable to transcode response")
+)
+
+// The byte order marks of UTF-8 and UTF-16 responses.
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+)
+
+// normalizeForms are the Unicode normalization forms that string fields can be normalized to, by name.
+var normalizeForms = map[string]norm.Form{
+	"nfc":  norm.NFC,
+	"nfd":  norm.NFD,
+	"nfkc": norm.NFKC,
+	"nfkd": norm.NFKD,
+}
+
+// responseEncoding will return the encoding of a response body by its byte order mark, or else by the charset of its
+// content type. A nil encoding means that the body is UTF-8 without a byte order mark, or that its charset is unknown.
+func responseEncoding(contentType string, body []byte) encoding.Encoding {
+	switch {
+	case bytes.HasPrefix(body, bomUTF8):
+		return unicode.UTF8BOM
+	case bytes.HasPrefix(body, bomUTF16BE):
+		return unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM)
+	case bytes.HasPrefix(body, bomUTF16LE):
+		return unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM)
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["charset"] == "" {
+		return nil
+	}
+
+	enc, err := htmlindex.Get(params["charset"])
+	if err != nil {
+		return nil
+	}
+
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return nil
+	}
+
+	return enc
+}
+
+// decodeCharset will transcode a response body to UTF-8. The charset is detected by the body's byte order mark, or
+// else by the charset of its content type, e.g. "application/json; charset=windows-1252". Bodies with an unknown
+// charset are not transcoded.
+func decodeCharset(contentType string, body []byte) ([]byte, error) {
+	enc := responseEncoding(contentType, body)
+	if enc == nil {
+		return body, nil
+	}
+
+	decoded, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCharset, err)
+	}
+
+	return decoded, nil
+}
+
+// validateNormalize will validate the Unicode normalization form of a request.
+func validateNormalize(form string) error {
+	if form == "" {
+		return nil
+	}
+
+	if _, ok := normalizeForms[strings.ToLower(form)]; !ok {
+		return fmt.Errorf("%w: unknown form %q, must be NFC, NFD, NFKC, or NFKD", ErrInvalidNormalize, form)
+	}
+
+	return nil
+}
+
+// normalizeValue will normalize the strings and the field names of a decoded JSON value.
+func normalizeValue(form norm.Form, value interface{}) interface{} {
+	switch value := value.(type) {
+	case string:
+		return form.String(value)
+	case []interface{}:
+		for idx, elem := range value {
+			value[idx] = normalizeValue(form, elem)
+		}
+
+		return value
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for field, elem := range value {
+			normalized[form.String(field)] = normalizeValue(form, elem)
+		}
+
+		return normalized
+	}
+
+	return value
+}
+
+// applyNormalize will normalize the strings and field names of JSON encoded records to a Unicode normalization form,
+// so that strings that only differ by their form, e.g. as primary keys, are stored as the same string.
+func applyNormalize(form string, dataType tools.UpsertDataType, data []byte) ([]byte, error) {
+	if form == "" {
+		return data, nil
+	}
+
+	decoded, err := dataType.DecodeNumbers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return dataType.Marshal(normalizeValue(normalizeForms[strings.ToLower(form)], decoded))
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+func TestDecodeCharset(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name        string
+		contentType string
+		body        []byte
+		expected    string
+	}{
+		{
+			name:        "utf-8",
+			contentType: "application/json; charset=utf-8",
+			body:        []byte(`{"name":"café"}`),
+			expected:    `{"name":"café"}`,
+		},
+		{
+			name:        "no charset",
+			contentType: "application/json",
+			body:        []byte(`{"name":"café"}`),
+			expected:    `{"name":"café"}`,
+		},
+		{
+			name:        "windows-1252",
+			contentType: "application/json; charset=windows-1252",
+			body:        []byte("{\"name\":\"caf\xe9 \x80\"}"),
+			expected:    `{"name":"café €"}`,
+		},
+		{
+			name:        "latin1 label",
+			contentType: `text/csv; charset="ISO-8859-1"`,
+			body:        []byte("name\ncaf\xe9\n"),
+			expected:    "name\ncafé\n",
+		},
+		{
+			name:     "utf-8 bom",
+			body:     []byte("\xef\xbb\xbf{\"name\":\"café\"}"),
+			expected: `{"name":"café"}`,
+		},
+		{
+			name:        "utf-16le bom",
+			contentType: "application/json; charset=windows-1252",
+			body:        []byte("\xff\xfe{\x00}\x00"),
+			expected:    `{}`,
+		},
+		{
+			name:     "utf-16be bom",
+			body:     []byte("\xfe\xff\x00[\x00]"),
+			expected: `[]`,
+		},
+		{
+			name:        "unknown charset",
+			contentType: "application/json; charset=x-unknown",
+			body:        []byte(`{"name":"café"}`),
+			expected:    `{"name":"café"}`,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			decoded, err := decodeCharset(tcase.contentType, tcase.body)
+			if err != nil {
+				t.Fatalf("error decoding charset: %v", err)
+			}
+
+			if string(decoded) != tcase.expected {
+				t.Fatalf("expected %q, got %q", tcase.expected, decoded)
+			}
+		})
+	}
+}
+
+func TestApplyNormalize(t *testing.T) {
+	t.Parallel()
+
+	// The decomposed "e" and combining acute accent of "café", in a field name and a value.
+	data := []byte(`[{"cafe\u0301":"cafe\u0301","n":1.50,"tags":["cafe\u0301"]}]`)
+
+	composed, decomposed := "caf\u00e9", "cafe\u0301"
+
+	for _, tcase := range []struct {
+		form     string
+		expected string
+	}{
+		{"", `[{"cafe\u0301":"cafe\u0301","n":1.50,"tags":["cafe\u0301"]}]`},
+		{"NFC", `[{"` + composed + `":"` + composed + `","n":1.50,"tags":["` + composed + `"]}]`},
+		{"nfd", `[{"` + decomposed + `":"` + decomposed + `","n":1.50,"tags":["` + decomposed + `"]}]`},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.form, func(t *testing.T) {
+			t.Parallel()
+
+			normalized, err := applyNormalize(tcase.form, tools.UpsertDataJSON, data)
+			if err != nil {
+				t.Fatalf("error normalizing records: %v", err)
+			}
+
+			if string(normalized) != tcase.expected {
+				t.Fatalf("expected %q, got %q", tcase.expected, normalized)
+			}
+		})
+	}
+}
+
+func TestValidateNormalize(t *testing.T) {
+	t.Parallel()
+
+	if err := validateNormalize("NFKC"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := validateNormalize("NFX"); !errors.Is(err, ErrInvalidNormalize) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidNormalize, err)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alpine-hodler/gidari/internal/expr"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var ErrInvalidExpression = fmt.Errorf("invalid expression")
+
+// recordTransform filters the records of a request and sets their computed fields, with expressions of the
+// "record" and the request's "params".
+type recordTransform struct {
+	filter   *expr.Program
+	computed map[string]*expr.Program
+	params   map[string]string
+}
+
+// compileExpression will compile an expression of a request's configuration.
+func compileExpression(name, src string) (*expr.Program, error) {
+	prg, err := expr.Compile(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidExpression, name, err)
+	}
+
+	return prg, nil
+}
+
+// compileTransform will compile the filter and computed fields of the request.
+func (req *Request) compileTransform() error {
+	if req.Filter == "" && len(req.Computed) == 0 {
+		return nil
+	}
+
+	transform := &recordTransform{computed: make(map[string]*expr.Program, len(req.Computed)), params: req.Params}
+
+	if req.Filter != "" {
+		prg, err := compileExpression("filter", req.Filter)
+		if err != nil {
+			return err
+		}
+
+		transform.filter = prg
+	}
+
+	for field, src := range req.Computed {
+		prg, err := compileExpression(fmt.Sprintf("computed.%s", field), src)
+		if err != nil {
+			return err
+		}
+
+		transform.computed[field] = prg
+	}
+
+	req.transform = transform
+
+	return nil
+}
+
+// apply will drop the records of JSON encoded upsert data that do not pass the filter, and set the computed fields of
+// the others. Computed fields are evaluated on the record as it was decoded, so they can not refer to each other.
+func (rt *recordTransform) apply(data []byte) ([]byte, error) {
+	if rt == nil {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	transformed := make([]interface{}, 0, len(records))
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			transformed = append(transformed, record)
+
+			continue
+		}
+
+		vars := map[string]interface{}{"record": rec, "params": rt.params}
+
+		if rt.filter != nil {
+			keep, err := rt.filter.EvalBool(vars)
+			if err != nil {
+				return nil, fmt.Errorf("unable to filter records: %w", err)
+			}
+
+			if !keep {
+				continue
+			}
+		}
+
+		computed := make(map[string]interface{}, len(rt.computed))
+
+		for field, prg := range rt.computed {
+			value, err := prg.Eval(vars)
+			if err != nil {
+				return nil, fmt.Errorf("unable to compute %q: %w", field, err)
+			}
+
+			computed[field] = value
+		}
+
+		for field, value := range computed {
+			rec[field] = value
+		}
+
+		transformed = append(transformed, rec)
+	}
+
+	transformedData, err := json.Marshal(transformed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	return transformedData, nil
+}
+
+// environ will return the environment variables of the process by name.
+func environ() map[string]string {
+	env := make(map[string]string)
+
+	for _, variable := range os.Environ() {
+		if name, value, ok := strings.Cut(variable, "="); ok {
+			env[name] = value
+		}
+	}
+
+	return env
+}
+
+// guardRequests will remove the requests whose guard does not hold. Guards are expressions of the request's "params"
+// and "query", and of the environment variables, "env".
+func (cfg *Config) guardRequests() error {
+	env := environ()
+	requests := make([]*Request, 0, len(cfg.Requests))
+
+	for _, req := range cfg.Requests {
+		if req.When == "" {
+			requests = append(requests, req)
+
+			continue
+		}
+
+		guard, err := compileExpression("when", req.When)
+		if err != nil {
+			return err
+		}
+
+		run, err := guard.EvalBool(map[string]interface{}{"params": req.Params, "query": req.Query, "env": env})
+		if err != nil {
+			return fmt.Errorf("unable to guard request %q: %w", req.Endpoint, err)
+		}
+
+		if !run {
+			logInfo := tools.LogFormatter{Msg: fmt.Sprintf("skipped request by its guard: %s %v", req.Endpoint, req.Params)}
+			cfg.Logger.Info(logInfo.String())
+
+			continue
+		}
+
+		requests = append(requests, req)
+	}
+
+	cfg.Requests = requests
+
+	return nil
+}
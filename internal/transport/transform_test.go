@@ -0,0 +1,84 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRecordTransform(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /products/{symbol}/trades
+    table: trades
+    expand: {symbol: [BTC-USD, ETH-USD, DOGE-USD]}
+    when: params.symbol != "DOGE-USD"
+    filter: record.size > 0
+    computed:
+      notional: double(record.price) * record.size
+      product_id: params.symbol
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if len(cfg.Requests) != 2 {
+		t.Fatalf("expected the guard to skip 1 of 3 requests, got %d requests", len(cfg.Requests))
+	}
+
+	data := []byte(`[{"price":"10.5","size":2},{"price":"11","size":0}]`)
+
+	transformed, err := cfg.Requests[1].transform.apply(data)
+	if err != nil {
+		t.Fatalf("error transforming records: %v", err)
+	}
+
+	expected := `[{"notional":21,"price":"10.5","product_id":"ETH-USD","size":2}]`
+	if string(transformed) != expected {
+		t.Fatalf("expected %s, got %s", expected, transformed)
+	}
+
+	if _, err := cfg.Requests[0].transform.apply([]byte(`[{"price":"1","size":"2"}]`)); err == nil {
+		t.Fatal("expected an error comparing a string to a number")
+	}
+}
+
+func TestRecordTransformInvalid(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name    string
+		request string
+	}{
+		{"filter", "filter: record.size >"},
+		{"computed", "computed: {notional: record.price & 1}"},
+		{"when", "when: params.symbol.split()"},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+    ` + tcase.request + `
+`))
+			if !errors.Is(err, ErrInvalidExpression) {
+				t.Fatalf("expected error %v, got %v", ErrInvalidExpression, err)
+			}
+		})
+	}
+}
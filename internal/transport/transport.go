@@ -9,27 +9,40 @@ package transport
 
 import (
 	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/alpine-hodler/gidari/internal/secret"
 	"github.com/alpine-hodler/gidari/internal/storage"
 	"github.com/alpine-hodler/gidari/internal/web"
 	"github.com/alpine-hodler/gidari/internal/web/auth"
 	"github.com/alpine-hodler/gidari/proto"
 	"github.com/alpine-hodler/gidari/repository"
 	"github.com/alpine-hodler/gidari/tools"
+	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	"gopkg.in/yaml.v2"
 )
 
 var (
+	ErrDeleteFieldNotSupported  = fmt.Errorf("delete field is not supported")
 	ErrFetchingTimeseriesChunks = fmt.Errorf("failed to fetch timeseries chunks")
+	ErrInvalidBandwidth         = fmt.Errorf("invalid bandwidth configuration")
+	ErrInvalidConcurrency       = fmt.Errorf("invalid concurrency configuration")
 	ErrInvalidRateLimit         = fmt.Errorf("invalid rate limit configuration")
+	ErrInvalidSession           = fmt.Errorf("invalid session configuration")
+	ErrInvalidTimeout           = fmt.Errorf("invalid timeout")
 	ErrMissingConfigField       = fmt.Errorf("missing config field")
 	ErrMissingRateLimitField    = fmt.Errorf("missing rate limit field")
 	ErrMissingTimeseriesField   = fmt.Errorf("missing timeseries field")
@@ -38,6 +51,12 @@ var (
 	ErrNoRequests               = fmt.Errorf("no requests defined")
 )
 
+// DeleteFieldNotSupportedError is returned when a request has a delete field and the storage device of a scheme can
+// not delete the records that it marks.
+func DeleteFieldNotSupportedError(scheme string) error {
+	return fmt.Errorf("%w for %q", ErrDeleteFieldNotSupported, scheme)
+}
+
 // MissingConfigFieldError is returned when a configuration field is missing.
 func MissingConfigFieldError(field string) error {
 	return fmt.Errorf("%w: %s", ErrMissingConfigField, field)
@@ -68,6 +87,43 @@ func WrapWebError(err error) error {
 	return fmt.Errorf("web: %w", err)
 }
 
+// Error is returned when a transport operation fails for a specific request. It carries the table and URL associated
+// with the failure so that callers can programmatically determine which part of a run failed. The underlying error
+// can be inspected with "errors.Is" and "errors.As".
+type Error struct {
+	// Table is the name of the table/collection that the request was destined for.
+	Table string
+
+	// Method is the HTTP method of the web request, if any.
+	Method string
+
+	// URL is the URL of the web request, if any.
+	URL string
+
+	// Err is the underlying error.
+	Err error
+
+	// fetchConfig is the configuration of the web request, if any, so that it can be replayed.
+	fetchConfig *web.FetchConfig
+
+	// request is the index of the configured request of the web request, if any.
+	request *int
+}
+
+// Error implements the error interface.
+func (terr *Error) Error() string {
+	if terr.URL != "" {
+		return fmt.Sprintf("table %q, url %q: %v", terr.Table, terr.URL, terr.Err)
+	}
+
+	return fmt.Sprintf("table %q: %v", terr.Table, terr.Err)
+}
+
+// Unwrap returns the underlying error.
+func (terr *Error) Unwrap() error {
+	return terr.Err
+}
+
 // APIKey is one method of HTTP(s) transport that requires a passphrase, key, and secret.
 type APIKey struct {
 	Passphrase string `yaml:"passphrase"`
@@ -84,6 +140,207 @@ type Auth2 struct {
 type Authentication struct {
 	APIKey *APIKey `yaml:"apiKey"`
 	Auth2  *Auth2  `yaml:"auth2"`
+
+	// Headers are static headers used to authenticate every request, e.g. API key headers.
+	Headers map[string]string `yaml:"headers"`
+
+	// Kerberos authenticates every request with SPNEGO, using the configuration's "GSS" provider.
+	Kerberos *Kerberos `yaml:"kerberos"`
+
+	// JWT authenticates every request with the access tokens of the OAuth2 JWT-bearer flow.
+	JWT *JWTAssertion `yaml:"jwt"`
+
+	// Session authenticates every request with the cookies, and optional CSRF token, of a login request.
+	Session *SessionLogin `yaml:"session"`
+}
+
+// SessionLogin is the login request of a web API that uses session cookies. The session is started by the first
+// request and renewed by logging in again when a request is unauthorized.
+type SessionLogin struct {
+	// URL is the URL of the login request, e.g. "/api/login". Relative URLs are resolved against the API's URL.
+	URL string `yaml:"url"`
+
+	// Method is the HTTP method of the login request. Defaults to "POST".
+	Method string `yaml:"method"`
+
+	// Form is the form encoded body of the login request, e.g. the username and password. Values may be secret
+	// references.
+	Form map[string]string `yaml:"form"`
+
+	// JSON is the JSON body of the login request, if there is no form. String values may be secret references.
+	JSON map[string]interface{} `yaml:"json"`
+
+	// CSRF is where the CSRF token of the session is captured from, and the header that sends it.
+	CSRF *SessionCSRF `yaml:"csrf"`
+}
+
+// SessionCSRF is where the CSRF token of a session is captured from, the first of a cookie of the login response, a
+// header of the login response, or a field of its JSON body, and the header that sends it with every request.
+type SessionCSRF struct {
+	Cookie         string `yaml:"cookie"`
+	ResponseHeader string `yaml:"responseHeader"`
+	Field          string `yaml:"field"`
+	Header         string `yaml:"header"`
+}
+
+func (login *SessionLogin) validate() error {
+	if login == nil {
+		return nil
+	}
+
+	if login.URL == "" {
+		return MissingConfigFieldError("authentication.session.url")
+	}
+
+	if len(login.Form) > 0 && len(login.JSON) > 0 {
+		return fmt.Errorf("%w: form and json are mutually exclusive", ErrInvalidSession)
+	}
+
+	if csrf := login.CSRF; csrf != nil {
+		if csrf.Header == "" {
+			return MissingConfigFieldError("authentication.session.csrf.header")
+		}
+
+		if csrf.Cookie == "" && csrf.ResponseHeader == "" && csrf.Field == "" {
+			return MissingConfigFieldError("authentication.session.csrf.cookie")
+		}
+	}
+
+	return nil
+}
+
+// sessionLogin will return the login request of the session, with the secret references of its body resolved.
+func (cfg *Config) sessionLogin(ctx context.Context, login *SessionLogin) (auth.SessionLogin, error) {
+	req := auth.SessionLogin{URL: login.URL, Method: login.Method}
+
+	if len(login.JSON) > 0 {
+		body := make(map[string]interface{}, len(login.JSON))
+
+		for key, value := range login.JSON {
+			if str, ok := value.(string); ok {
+				if err := cfg.resolveSecrets(ctx, &str); err != nil {
+					return req, err
+				}
+
+				value = str
+			}
+
+			body[key] = value
+		}
+
+		bytes, err := json.Marshal(body)
+		if err != nil {
+			return req, fmt.Errorf("unable to encode session login: %w", err)
+		}
+
+		req.ContentType = "application/json"
+		req.Body = bytes
+
+		return req, nil
+	}
+
+	form := make(url.Values, len(login.Form))
+
+	for key, value := range login.Form {
+		if err := cfg.resolveSecrets(ctx, &value); err != nil {
+			return req, err
+		}
+
+		form.Set(key, value)
+	}
+
+	req.ContentType = "application/x-www-form-urlencoded"
+	req.Body = []byte(form.Encode())
+
+	return req, nil
+}
+
+// JWTAssertion is the OAuth2 JWT-bearer flow, where a JWT signed with a private key is exchanged for access tokens,
+// e.g. by Google service accounts, Box, and Salesforce. The access tokens are renewed before they expire.
+type JWTAssertion struct {
+	// TokenURL is the URL that the assertions are exchanged for access tokens at.
+	TokenURL string `yaml:"tokenUrl"`
+
+	// Issuer is the "iss" claim, e.g. the client ID or the service account's email.
+	Issuer string `yaml:"issuer"`
+
+	// Subject is the "sub" claim, e.g. the user that the client acts on behalf of. Defaults to the issuer.
+	Subject string `yaml:"subject"`
+
+	// Audience is the "aud" claim. Defaults to the token URL.
+	Audience string `yaml:"audience"`
+
+	// Scope is the "scope" claim, e.g. the space separated scopes of a Google service account.
+	Scope string `yaml:"scope"`
+
+	// KeyFile is the path to the PEM encoded RSA or ECDSA P-256 private key that signs the assertions.
+	KeyFile string `yaml:"keyFile"`
+
+	// Key is the PEM encoded private key, e.g. a secret reference, if there is no key file.
+	Key string `yaml:"key"`
+
+	// KeyID is the ID of the private key, the "kid" header of the assertions.
+	KeyID string `yaml:"keyId"`
+
+	// Lifetime is the lifetime of the assertions in seconds. Defaults to one hour.
+	Lifetime int `yaml:"lifetime"`
+}
+
+func (ja *JWTAssertion) validate() error {
+	if ja == nil {
+		return nil
+	}
+
+	if ja.TokenURL == "" {
+		return MissingConfigFieldError("authentication.jwt.tokenUrl")
+	}
+
+	if ja.Issuer == "" {
+		return MissingConfigFieldError("authentication.jwt.issuer")
+	}
+
+	if ja.KeyFile == "" && ja.Key == "" {
+		return MissingConfigFieldError("authentication.jwt.keyFile")
+	}
+
+	return nil
+}
+
+// jwtSigner will return the private key that signs the assertions, reading it from the key file or the resolved key.
+func (cfg *Config) jwtSigner(ctx context.Context, ja *JWTAssertion) (crypto.Signer, error) {
+	pemKey := ja.Key
+
+	if ja.KeyFile != "" {
+		bytes, err := os.ReadFile(ja.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read jwt key file: %w", err)
+		}
+
+		pemKey = string(bytes)
+	} else if err := cfg.resolveSecrets(ctx, &pemKey); err != nil {
+		return nil, err
+	}
+
+	key, err := auth.ParsePrivateKey([]byte(pemKey))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse jwt key: %w", err)
+	}
+
+	return key, nil
+}
+
+// GSS is a GSSAPI security context, e.g. of Kerberos.
+type GSS = auth.GSS
+
+// GSSFunc creates a GSSAPI security context, e.g. "func() (GSS, error) { return kerberos.NewGSS() }" with the
+// "github.com/lib/pq/auth/kerberos" package.
+type GSSFunc = auth.NewGSSFunc
+
+// Kerberos is the SPNEGO (HTTP Negotiate) authentication of a web API protected by Kerberos, e.g. Active Directory.
+type Kerberos struct {
+	// SPN is the service principal name of the web API, e.g. "HTTP/api.example.com". Defaults to the "HTTP" service
+	// of the API's host.
+	SPN string `yaml:"spn"`
 }
 
 // timeseries is a struct that contains the information needed to query a web API for timeseries data.
@@ -99,9 +356,34 @@ type timeseries struct {
 	// to be RFC3339.
 	Layout *string `yaml:"layout"`
 
+	// TruncateColumn is the time column of the table that is compared to the timeseries range. If set, the records of
+	// the table within the range are deleted before the range is upserted, instead of truncating the entire table.
+	TruncateColumn string `yaml:"truncateColumn"`
+
+	// Tail polls the request for new data at an interval once the timeseries has been backfilled, continuing from the
+	// end of the backfill.
+	Tail *TailConfig `yaml:"tail"`
+
+	// Gaps is the expected granularity of the table, used to detect the intervals of the range without records so
+	// that they can be fetched again.
+	Gaps *GapConfig `yaml:"gaps"`
+
 	// chunks are the time ranges for which we can query the API. These are broken up into pieces for API requests
 	// that only return a limited number of results.
 	chunks [][2]time.Time
+
+	// planned is the period of each chunk in seconds planned from the statistics of the previous run, if the run is
+	// planned.
+	planned int32
+}
+
+// period will return the size of each chunk in seconds, the planned period if the run is planned.
+func (ts *timeseries) period() int32 {
+	if ts.planned > 0 {
+		return ts.planned
+	}
+
+	return ts.Period
 }
 
 // chunk will attempt to use the query string of a URL to partition the timeseries into "chunks" of time for queying
@@ -135,8 +417,10 @@ func (ts *timeseries) chunk(rurl url.URL) error {
 		return UnableToParseError("endTime")
 	}
 
+	ts.chunks = nil
+
 	for start.Before(end) {
-		next := start.Add(time.Second * time.Duration(ts.Period))
+		next := start.Add(time.Second * time.Duration(ts.period()))
 		if next.Before(end) {
 			ts.chunks = append(ts.chunks, [2]time.Time{start, next})
 		} else {
@@ -156,319 +440,1802 @@ type RateLimitConfig struct {
 
 	// Period is the number of times to allow a burst per second.
 	Period *time.Duration `yaml:"period"`
-}
 
-func (rl RateLimitConfig) validate() error {
-	if rl.Burst == nil {
-		return MissingRateLimitFieldError("burst")
-	}
+	// Credits is the number of API credits that can be spent per period, for APIs that charge a different cost for
+	// each endpoint. If set, the rate limit is a bucket of credits shared by every request with this rate limit, each
+	// web request spending the request's "Weight", rather than a count of requests.
+	Credits *int `yaml:"credits"`
 
-	if rl.Period == nil {
-		return MissingRateLimitFieldError("period")
-	}
+	// Windows are the times of day during which the rate limit is replaced, e.g. to go faster at night or to pause
+	// during maintenance windows. The first window that contains the time of a request applies.
+	Windows []*RateWindow `yaml:"windows"`
 
-	return nil
-}
+	// Timezone is the IANA time zone of the windows, e.g. "America/New_York". Defaults to the local time zone.
+	Timezone string `yaml:"timezone"`
 
-// Config is the configuration used to query data from the web using HTTP requests and storing that data using
-// the repositories defined by the "ConnectionStrings" list.
-type Config struct {
-	RawURL            string           `yaml:"url"`
-	Authentication    Authentication   `yaml:"authentication"`
-	ConnectionStrings []string         `yaml:"connectionStrings"`
-	Requests          []*Request       `yaml:"requests"`
-	RateLimitConfig   *RateLimitConfig `yaml:"rateLimit"`
-	Logger            *logrus.Logger
-	Truncate          bool
+	// Smooth starts the rate limit without its burst, so that the requests queued at the start of a run are released
+	// at the sustained rate rather than all at once.
+	Smooth bool `yaml:"smooth"`
 
-	URL *url.URL `yaml:"-"`
-}
+	// Jitter is the longest random delay of each request after the rate limit allows it, to spread out the requests
+	// that are released at once.
+	Jitter time.Duration `yaml:"jitter"`
 
-// New config takes a YAML byte slice and returns a new transport configuration for upserting data to storage.
-//
-// For web requests defined on the transport configuration, the default HTTP Request Method is "GET". Furthermore,
-// if rate limit data has not been defined for a request it will inherit the rate limit data from the transport config.
-func NewConfig(yamlBytes []byte) (*Config, error) {
-	var cfg Config
+	once    sync.Once
+	limiter web.RateLimiter
+}
 
-	cfg.Logger = logrus.New()
+func (rl *RateLimitConfig) validate() error {
+	if rl.Period == nil {
+		return MissingRateLimitFieldError("period")
+	}
 
-	if err := yaml.Unmarshal(yamlBytes, &cfg); err != nil {
-		return nil, fmt.Errorf("unable to unmarshal YAML: %w", err)
+	if rl.Credits != nil && *rl.Credits <= 0 {
+		return fmt.Errorf("credits must be positive")
 	}
 
-	if err := cfg.validate(); err != nil {
-		return nil, err
+	if rl.Credits == nil && rl.Burst == nil {
+		return MissingRateLimitFieldError("burst")
 	}
 
-	// Parse the raw URL
-	var err error
+	if _, err := time.LoadLocation(rl.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
 
-	cfg.URL, err = url.Parse(cfg.RawURL)
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse URL: %w", err)
+	if rl.Jitter < 0 {
+		return fmt.Errorf("jitter must not be negative")
 	}
 
-	// Update default request data.
-	for _, req := range cfg.Requests {
-		if req.Method == "" {
-			req.Method = http.MethodGet
+	for _, window := range rl.Windows {
+		if err := window.validate(); err != nil {
+			return err
 		}
+	}
 
-		if req.RateLimitConfig == nil {
-			req.RateLimitConfig = cfg.RateLimitConfig
-		}
+	return nil
+}
 
-		if req.Table == "" {
-			endpointParts := strings.Split(req.Endpoint, "/")
-			req.Table = endpointParts[len(endpointParts)-1]
+// capacity will return the most requests, or credits, that the rate limit allows at once, in any window.
+func (rl *RateLimitConfig) capacity() int {
+	_, capacity := rl.limit(nil)
+
+	for _, window := range rl.Windows {
+		if _, burst := rl.limit(window); burst < capacity && !window.Pause {
+			capacity = burst
 		}
 	}
 
-	return &cfg, nil
+	return capacity
 }
 
-// connect will attempt to connect to the web API client. Since there are multiple ways to build a transport given the
-// authentication data, this method will exhaust every transport option in the "Authentication" struct.
-func (cfg *Config) connect(ctx context.Context) (*web.Client, error) {
-	if apiKey := cfg.Authentication.APIKey; apiKey != nil {
-		client, err := web.NewClient(ctx, auth.NewAPIKey().
-			SetURL(cfg.RawURL).
-			SetKey(apiKey.Key).
-			SetPassphrase(apiKey.Passphrase).
-			SetSecret(apiKey.Secret))
-		if err != nil {
-			return nil, WrapWebError(web.FailedToCreateClientError(err))
-		}
+// rateLimiter will return the rate limiter of the rate limit. It is built once and shared by every chunk of every
+// request with the rate limit, e.g. the requests that inherit the rate limit of the configuration, since they spend
+// the same quota of the web API, and so that smoothing and jitter pace all of them rather than each chunk.
+func (rl *RateLimitConfig) rateLimiter() web.RateLimiter {
+	rl.once.Do(func() {
+		rl.limiter = rl.buildLimiter()
+	})
 
-		return client, nil
-	}
+	return rl.limiter
+}
 
-	if apiKey := cfg.Authentication.Auth2; apiKey != nil {
-		client, err := web.NewClient(ctx, auth.NewAuth2().SetBearer(apiKey.Bearer).SetURL(cfg.RawURL))
-		if err != nil {
-			return nil, WrapWebError(web.FailedToCreateClientError(err))
-		}
+// buildLimiter will return a new rate limiter for the rate limit, following its windows if it has any.
+func (rl *RateLimitConfig) buildLimiter() web.RateLimiter {
+	if len(rl.Windows) > 0 {
+		limiter := newWindowLimiter(rl)
 
-		return client, nil
+		return rl.pace(limiter.limiter, limiter)
 	}
 
-	// In the case of no authentication, create a client without an auth transport.
-	client, err := web.NewClient(ctx, nil)
-	if err != nil {
-		return nil, WrapWebError(web.FailedToCreateClientError(err))
-	}
+	limit, burst := rl.limit(nil)
+	limiter := rate.NewLimiter(limit, burst)
 
-	return client, nil
+	return rl.pace(limiter, limiter)
 }
 
-type repoCloser func()
+// BandwidthConfig is the maximum download bandwidth of the web requests, independent of the rate limit. Bandwidth is
+// measured in bytes per second of response body.
+type BandwidthConfig struct {
+	// MaxBytesPerSecond is the maximum download bandwidth across all hosts.
+	MaxBytesPerSecond int `yaml:"maxBytesPerSecond"`
 
-// repos will return a slice of generic repositories along with associated transaction instances.
-func (cfg *Config) repos(ctx context.Context) ([]repository.Generic, repoCloser, error) {
-	repos := []repository.Generic{}
+	// Hosts is the maximum download bandwidth per host, keyed by host name.
+	Hosts map[string]int `yaml:"hosts"`
+}
 
-	for _, dns := range cfg.ConnectionStrings {
-		repo, err := repository.NewTx(ctx, dns)
-		if err != nil {
-			return nil, nil, WrapRepositoryError(repository.FailedToCreateRepositoryError(err))
-		}
+func (bc *BandwidthConfig) validate() error {
+	if bc == nil {
+		return nil
+	}
 
-		logInfo := tools.LogFormatter{
-			Msg: fmt.Sprintf("created repository for %q", dns),
+	if bc.MaxBytesPerSecond < 0 {
+		return fmt.Errorf("%w: maxBytesPerSecond must be non-negative", ErrInvalidBandwidth)
+	}
+
+	for host, bytesPerSecond := range bc.Hosts {
+		if bytesPerSecond < 0 {
+			return fmt.Errorf("%w: hosts.%s must be non-negative", ErrInvalidBandwidth, host)
 		}
-		cfg.Logger.Info(logInfo.String())
+	}
 
-		repos = append(repos, repo)
+	return nil
+}
+
+// limiter will return the bandwidth limiter for the configuration.
+func (bc *BandwidthConfig) limiter() *web.BandwidthLimiter {
+	limiter := web.NewBandwidthLimiter(bc.MaxBytesPerSecond)
+	for host, bytesPerSecond := range bc.Hosts {
+		limiter.SetHost(host, bytesPerSecond)
 	}
 
-	return repos, func() {
-		for _, repo := range repos {
-			repo.Close()
+	return limiter
+}
 
-			logInfo := tools.LogFormatter{
-				Msg: fmt.Sprintf("closed repository for %q", storage.Scheme(repo.Type())),
-			}
-			cfg.Logger.Info(logInfo.String())
-		}
-	}, nil
+// ConcurrencyConfig is the maximum number of web requests in flight at the same time, independent of the rate limit,
+// for web APIs that limit concurrent connections. A request is in flight until its response has been read.
+type ConcurrencyConfig struct {
+	// MaxInFlight is the maximum number of in-flight requests across all hosts.
+	MaxInFlight int `yaml:"maxInFlight"`
+
+	// Hosts is the maximum number of in-flight requests per host, keyed by host name.
+	Hosts map[string]int `yaml:"hosts"`
 }
 
-// validate will ensure that the configuration is valid for querying the web API.
-func (cfg *Config) validate() error {
-	if cfg.RateLimitConfig == nil {
-		return MissingConfigFieldError("rateLimit")
+func (cc *ConcurrencyConfig) validate() error {
+	if cc == nil {
+		return nil
 	}
 
-	if err := cfg.RateLimitConfig.validate(); err != nil {
-		return ErrInvalidRateLimit
+	if cc.MaxInFlight < 0 {
+		return fmt.Errorf("%w: maxInFlight must be non-negative", ErrInvalidConcurrency)
 	}
 
-	if cfg.ConnectionStrings == nil {
-		logWarn := tools.LogFormatter{
-			Msg: "no connectionStrings specified in the config file",
+	for host, maxInFlight := range cc.Hosts {
+		if maxInFlight < 0 {
+			return fmt.Errorf("%w: hosts.%s must be non-negative", ErrInvalidConcurrency, host)
 		}
-		cfg.Logger.Warn(logWarn.String())
 	}
 
 	return nil
 }
 
-// flattenRequests will flatten the requests into a single slice for HTTP requests.
-func (cfg *Config) flattenRequests(ctx context.Context) ([]*flattenedRequest, error) {
-	client, err := cfg.connect(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to web API: %w", err)
+// limiter will return the concurrency limiter for the configuration.
+func (cc *ConcurrencyConfig) limiter() *web.ConcurrencyLimiter {
+	limiter := web.NewConcurrencyLimiter(cc.MaxInFlight)
+	for host, maxInFlight := range cc.Hosts {
+		limiter.SetHost(host, maxInFlight)
 	}
 
-	var flattenedRequests []*flattenedRequest
+	return limiter
+}
 
-	for _, req := range cfg.Requests {
-		flatReqs, err := req.flattenTimeseries(*cfg.URL, client)
-		if err != nil {
-			return nil, err
-		}
+// Config is the configuration used to query data from the web using HTTP requests and storing that data using
+// the repositories defined by the "ConnectionStrings" list.
+type Config struct {
+	// Version is the version of the configuration format. Configurations of older versions are upgraded when they are
+	// loaded, so it is always the latest version, "ConfigVersion".
+	Version int `yaml:"version"`
 
-		flattenedRequests = append(flattenedRequests, flatReqs...)
-	}
+	// Preset is the name of a built-in provider preset, e.g. "coinbase". The preset defines the default URL, rate
+	// limit, authentication scheme, and named requests that can be referenced by the "preset" field of a request.
+	Preset string `yaml:"preset"`
 
-	if len(flattenedRequests) == 0 {
-		return nil, ErrNoRequests
-	}
+	// Templates are requests, keyed by name, that requests extend with the "template" field, e.g. to share the
+	// method, headers, rate limit, and storage options of dozens of similar endpoints. Templates can extend other
+	// templates.
+	Templates map[string]*Request `yaml:"templates"`
 
-	return flattenedRequests, nil
-}
+	RawURL            string           `yaml:"url"`
+	Authentication    Authentication   `yaml:"authentication"`
+	ConnectionStrings []string         `yaml:"connectionStrings"`
+	Requests          []*Request       `yaml:"requests"`
+	RateLimitConfig   *RateLimitConfig `yaml:"rateLimit"`
+	Logger            *logrus.Logger
+	Truncate          bool
 
-type repoJob struct {
-	req   http.Request
-	b     []byte
-	table string
-}
+	// Database is the database that the requests write to, instead of the database of the connection strings, so
+	// that one connection can feed several databases. Requests can override it with their own database. Only MongoDB
+	// storage can write to several databases over one connection, other storage ignores it.
+	Database string `yaml:"database"`
 
-type repoConfig struct {
-	repos      []repository.Generic
-	closeRepos repoCloser
-	jobs       chan *repoJob
-	done       chan bool
+	// TruncatePolicy selects the tables to truncate and guards against truncating tables by mistake.
+	TruncatePolicy *TruncatePolicy `yaml:"truncatePolicy"`
+
+	// ConfirmTruncate is called with the tables that will be truncated on a storage device when the truncate policy
+	// requires confirmation. The tables are only truncated if it returns true.
+	ConfirmTruncate func(storage string, tables []string) bool `yaml:"-"`
+
+	// HTTPClient is a pre-configured HTTP client used to make the web requests, so that embedders can reuse their own
+	// instrumentation, proxies, and connection pools. The client's transport makes the requests after they have been
+	// authenticated; to inject only a RoundTripper, use a client with just the "Transport" set. By default a new
+	// client is created with the default transport.
+	HTTPClient *http.Client `yaml:"-"`
+
+	// Timeout is the time limit of each web request, including reading its response, e.g. "30s". By default web
+	// requests have the timeout of the configured HTTP client, or none.
+	Timeout time.Duration `yaml:"timeout"`
+
+	// NoCache disables sharing the responses of identical web requests within a run. By default, requests with the
+	// same method and URL are only made once.
+	NoCache bool `yaml:"noCache"`
+
+	// Mirrors are base URLs of mirrors of the web API, in order of preference. If a request to the URL fails to
+	// connect or responds with a server error, it fails over to the mirrors. Unhealthy mirrors are skipped for a
+	// cooldown that grows with consecutive failures.
+	Mirrors []string `yaml:"mirrors"`
+
+	// Bandwidth is the maximum download bandwidth, globally and per host. By default bandwidth is not limited.
+	Bandwidth *BandwidthConfig `yaml:"bandwidth"`
+
+	// Concurrency is the maximum number of in-flight web requests, globally and per host. By default concurrency is
+	// only limited by the number of web workers.
+	Concurrency *ConcurrencyConfig `yaml:"concurrency"`
+
+	// ErrorBudget is the number of failed chunks to tolerate, both per request and for the entire run, before the
+	// transport operation is aborted. By default no errors are tolerated.
+	ErrorBudget *ErrorBudgetConfig `yaml:"errorBudget"`
+
+	// JSONDecoder is the implementation that decodes the records on the storage devices, "standard" or "fast", for
+	// the requests that do not set one. Defaults to "standard", or "fast" with the "gidari_fastjson" build tag.
+	JSONDecoder JSONDecoder `yaml:"jsonDecoder"`
+
+	URL *url.URL `yaml:"-"`
+
+	// FailedChunksFile is the path to a file where the chunks that failed within the error budget are persisted
+	// after a transport operation, so that they can be retried with "RetryFailedChunks".
+	FailedChunksFile string `yaml:"failedChunksFile"`
+
+	// FailedChunks are the chunks that failed within the error budget during the last transport operation.
+	FailedChunks []*FailedChunk `yaml:"-"`
+
+	// RecordTypes are the decoders used to decode and validate the records of a table into a Go struct type before
+	// they are stored, keyed by table.
+	RecordTypes map[string]*tools.TypedRecordDecoder `yaml:"-"`
+
+	// Progress enables reporting the progress of each table during a transport operation.
+	Progress *ProgressConfig `yaml:"progress"`
+
+	// ProgressWriter is where progress is reported, the default is stderr.
+	ProgressWriter io.Writer `yaml:"-"`
+
+	// Stamp stamps every record with the time it was ingested and the endpoint it was fetched from.
+	Stamp *StampConfig `yaml:"stamp"`
+
+	// Notify emits a notification for every table that received data once the data is committed.
+	Notify *NotifyConfig `yaml:"notify"`
+
+	// Publish are the built-in publishers of the events announcing the data committed by a transport operation.
+	Publish []*PublishConfig `yaml:"publish"`
+
+	// Publishers are custom publishers of the events announcing the data committed by a transport operation.
+	Publishers []Publisher `yaml:"-"`
+
+	// RunID identifies a transport operation, e.g. in the payload of notifications. By default, a random ID is
+	// generated for each operation.
+	RunID string `yaml:"runId"`
+
+	// StartJitter is the longest random delay of the start of a run, so that scheduled runs, e.g. at the top of every
+	// hour, do not reach the web API at the same time as every other scheduled job.
+	StartJitter time.Duration `yaml:"startJitter"`
+
+	// CorrelationHeader is the header that sends the run ID with every web request, e.g. "X-Correlation-ID", so that
+	// the logs of the web API can be correlated with the transport operation.
+	CorrelationHeader string `yaml:"correlationHeader"`
+
+	// Reloader loads the configuration again when a reload is requested, e.g. from the configuration file. While the
+	// live tail runs, the requests and limits of the reloaded configuration replace those of this configuration.
+	Reloader func() (*Config, error) `yaml:"-"`
+
+	// Decode processes the fetched responses on a pool of decode workers, separate from the web workers.
+	Decode *DecodeConfig `yaml:"decode"`
+
+	// Autoscale adjusts the number of active web and repository workers during a run, within bounds, from the rate
+	// limit waits and the latency of the upserts. By default there are as many of each as there are CPUs.
+	Autoscale *AutoscaleConfig `yaml:"autoscale"`
+
+	// Flush commits the upserted records to the storage devices of a scheme, e.g. "postgresql", every number of
+	// records or interval, instead of once at the end of the run.
+	Flush map[string]*FlushPolicy `yaml:"flush"`
+
+	// JournalDir is the directory of the write-ahead journals of the storage devices without transactions, e.g. NATS
+	// or a standalone MongoDB server. Their upserts are journaled before they are written, so that the upserts of a
+	// run that crashed are replayed by the next run. By default upserts are not journaled.
+	JournalDir string `yaml:"journalDir"`
+
+	// Admin serves an admin API to pause, resume, and cancel the requests of a running transport operation.
+	Admin *AdminConfig `yaml:"admin"`
+
+	// Embedding is the built-in embedder of the text fields that requests embed, an OpenAI-compatible embeddings API.
+	Embedding *EmbeddingConfig `yaml:"embedding"`
+
+	// Embedder is a custom embedder of the text fields that requests embed, which takes precedence over "Embedding".
+	Embedder Embedder `yaml:"-"`
+
+	// WASMRuntime instantiates the WebAssembly modules of the requests' "wasm" transforms.
+	WASMRuntime WASMRuntime `yaml:"-"`
+
+	// Anomaly detects runs whose record counts or response sizes deviate from the history of previous runs.
+	Anomaly *AnomalyConfig `yaml:"anomaly"`
+
+	// Planner plans the chunk periods and in-flight web requests of each run from the statistics of the previous run.
+	Planner *PlannerConfig `yaml:"planner"`
+
+	// Profile captures CPU and heap profiles of every run, or of the runs that are slow, to a directory.
+	Profile *ProfileConfig `yaml:"profile"`
+
+	// Assertions are data quality checks of the stored tables that must hold before the data is committed.
+	Assertions []*Assertion `yaml:"assertions"`
+
+	// Reconcile reconciles the records decoded by a transport operation with the records present in storage.
+	Reconcile *ReconcileConfig `yaml:"reconcile"`
+
+	// Reconciliations are the reconciliations of the records of each storage device and table during the last
+	// transport operation, if reconciliation is enabled.
+	Reconciliations []*Reconciliation `yaml:"-"`
+
+	// Schema validates the records of each table against the columns and primary key of the table before they are
+	// written.
+	Schema *SchemaConfig `yaml:"schema"`
+
+	// Verify re-reads a sample of the upserted records once they are committed and compares them with the decoded
+	// records.
+	Verify *VerifyConfig `yaml:"verify"`
+
+	// Verifications are the verifications of the sampled records of each storage device and table during the last
+	// transport operation, if verification is enabled.
+	Verifications []*Verification `yaml:"-"`
+
+	// Audit records every write operation of a transport operation in an append-only audit log.
+	Audit *AuditConfig `yaml:"audit"`
+
+	// Archive archives the body of every web response, keyed by its content hash, with an index of the requests of
+	// each run, so that runs can be replayed and audited.
+	Archive *ArchiveConfig `yaml:"archive"`
+
+	// ArchiveStore is a custom store of the archived responses, e.g. an object store, which takes precedence over the
+	// directory of "Archive".
+	ArchiveStore ArchiveStore `yaml:"-"`
+
+	// GSS creates the GSSAPI security contexts, e.g. of Kerberos, that authenticate web requests with SPNEGO and
+	// Postgres connections that require GSSAPI authentication.
+	GSS GSSFunc `yaml:"-"`
+
+	// SecretProviders are custom providers of the secret references in the configuration, keyed by provider name.
+	// They take precedence over the built-in "vault", "aws", and "gcp" providers.
+	SecretProviders map[string]SecretProvider `yaml:"-"`
+
+	// hash is the SHA-256 hash of the YAML configuration, if the configuration was read from YAML.
+	hash string
+
+	// tailing is true while the requests with a live tail are polled.
+	tailing bool
+
+	// replaying is true while archived responses are replayed.
+	replaying bool
+
+	// importing is true while a snapshot is imported or tables are copied.
+	importing bool
+
+	// control is the state of the operation that is controlled by the admin API, while it is served.
+	control *runControl
+
+	// reloads receives the requests to reload the configuration while the live tail runs.
+	reloads chan struct{}
+
+	// plannedInFlight is the number of in-flight requests to the web API planned for the run, if it is planned.
+	plannedInFlight int
+
+	// secrets resolves the secret references in the configuration.
+	secrets *secret.Resolver
+
+	// runIDHook adds the run ID of the current transport operation to the entries of runIDLogger.
+	runIDHook   *runIDHook
+	runIDLogger *logrus.Logger
+}
+
+// New config takes a YAML byte slice and returns a new transport configuration for upserting data to storage.
+//
+// For web requests defined on the transport configuration, the default HTTP Request Method is "GET". Furthermore,
+// if rate limit data has not been defined for a request it will inherit the rate limit data from the transport config.
+func NewConfig(yamlBytes []byte) (*Config, error) {
+	var cfg Config
+
+	cfg.Logger = logrus.New()
+	cfg.hash = configHash(yamlBytes)
+	cfg.reloads = make(chan struct{}, 1)
+
+	// Configurations of older versions are upgraded to the latest version of the format before they are decoded.
+	upgraded, version, err := UpgradeConfig(yamlBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < ConfigVersion {
+		logWarn := tools.LogFormatter{
+			Msg: fmt.Sprintf("config version %d is deprecated, run \"gidari upgrade\" to upgrade it to version %d",
+				version, ConfigVersion),
+		}
+		cfg.Logger.Warn(logWarn.String())
+	}
+
+	if err := yaml.Unmarshal(upgraded, &cfg); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal YAML: %w", err)
+	}
+
+	if err := cfg.applyTemplates(); err != nil {
+		return nil, fmt.Errorf("unable to apply templates: %w", err)
+	}
+
+	if err := cfg.applyPreset(); err != nil {
+		return nil, fmt.Errorf("unable to apply preset: %w", err)
+	}
+
+	if err := cfg.expandRequests(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.guardRequests(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	// Parse the raw URL
+	cfg.URL, err = url.Parse(cfg.RawURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse URL: %w", err)
+	}
+
+	// Update default request data.
+	for _, req := range cfg.Requests {
+		if req.Method == "" {
+			req.Method = http.MethodGet
+		}
+
+		if req.RateLimitConfig == nil {
+			req.RateLimitConfig = cfg.RateLimitConfig
+		}
+
+		if req.ErrorBudget == nil {
+			req.ErrorBudget = cfg.ErrorBudget
+		}
+
+		if req.JSONDecoder == "" {
+			req.JSONDecoder = cfg.JSONDecoder
+		}
+
+		if req.Table == "" {
+			endpointParts := strings.Split(req.Endpoint, "/")
+			req.Table = endpointParts[len(endpointParts)-1]
+		}
+
+		if req.Table, err = req.expandTable(); err != nil {
+			return nil, err
+		}
+
+		if req.encodedBody, err = req.body(); err != nil {
+			return nil, err
+		}
+
+		for _, lookup := range req.Lookups {
+			if err := lookup.load(); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := req.compileTransform(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cfg, nil
+}
+
+// runID will return the ID of a transport operation, a random ID if the configuration does not set one.
+func (cfg *Config) runID() string {
+	if cfg.RunID != "" {
+		return cfg.RunID
+	}
+
+	return uuid.New().String()
+}
+
+// connect will attempt to connect to the web API client, limiting its download bandwidth and in-flight requests, and
+// failing over to mirrors if configured.
+func (cfg *Config) connect(ctx context.Context) (*web.Client, error) {
+	client, err := cfg.newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Bandwidth != nil {
+		client.SetBandwidthLimiter(cfg.Bandwidth.limiter())
+	}
+
+	if cfg.Concurrency != nil || cfg.plannedInFlight > 0 {
+		limiter := web.NewConcurrencyLimiter(0)
+		if cfg.Concurrency != nil {
+			limiter = cfg.Concurrency.limiter()
+		}
+
+		// The planned in-flight requests replace the configured limit of the web API's host for the run.
+		if cfg.plannedInFlight > 0 {
+			limiter.SetHost(cfg.URL.Host, cfg.plannedInFlight)
+		}
+
+		client.SetConcurrencyLimiter(limiter)
+	}
+
+	mirrors, err := cfg.mirrorURLs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mirrors) > 0 {
+		client.SetFailover(web.NewFailover(cfg.URL, mirrors...))
+	}
+
+	return client, nil
+}
+
+// mirrorURLs will parse the base URLs of the mirrors of the web API.
+func (cfg *Config) mirrorURLs() ([]*url.URL, error) {
+	mirrors := make([]*url.URL, 0, len(cfg.Mirrors))
+
+	for _, rawURL := range cfg.Mirrors {
+		mirror, err := url.Parse(rawURL)
+		if err != nil || mirror.Host == "" {
+			return nil, UnableToParseError("mirrors: " + rawURL)
+		}
+
+		mirrors = append(mirrors, mirror)
+	}
+
+	return mirrors, nil
+}
+
+// newClient will return a web client that authenticates requests with the configured authentication scheme. Since
+// there are multiple ways to build a transport given the authentication data, this method will exhaust every transport
+// option in the "Authentication" struct.
+func (cfg *Config) newClient(ctx context.Context) (*web.Client, error) {
+	// base is the transport that makes the requests after they have been authenticated.
+	var base http.RoundTripper
+	if cfg.HTTPClient != nil {
+		base = cfg.HTTPClient.Transport
+	}
+
+	if apiKey := cfg.Authentication.APIKey; apiKey != nil {
+		key, passphrase, apiSecret := apiKey.Key, apiKey.Passphrase, apiKey.Secret
+		if err := cfg.resolveSecrets(ctx, &key, &passphrase, &apiSecret); err != nil {
+			return nil, err
+		}
+
+		return cfg.webClient(ctx, auth.NewAPIKey().
+			SetURL(cfg.RawURL).
+			SetKey(key).
+			SetPassphrase(passphrase).
+			SetSecret(apiSecret).
+			SetTransport(base))
+	}
+
+	if headers := cfg.Authentication.Headers; len(headers) > 0 {
+		tripper := auth.NewHeader().SetURL(cfg.RawURL).SetTransport(base)
+		for key, value := range headers {
+			if err := cfg.resolveSecrets(ctx, &value); err != nil {
+				return nil, err
+			}
+
+			tripper.SetHeader(key, value)
+		}
+
+		return cfg.webClient(ctx, tripper)
+	}
+
+	if jwt := cfg.Authentication.JWT; jwt != nil {
+		key, err := cfg.jwtSigner(ctx, jwt)
+		if err != nil {
+			return nil, err
+		}
+
+		tripper := auth.NewJWTBearer().
+			SetKey(key, jwt.KeyID).
+			SetClaims(auth.JWTClaims{
+				Issuer:   jwt.Issuer,
+				Subject:  jwt.Subject,
+				Audience: jwt.Audience,
+				Scope:    jwt.Scope,
+				Lifetime: time.Duration(jwt.Lifetime) * time.Second,
+			}).
+			SetTokenURL(jwt.TokenURL).
+			SetURL(cfg.RawURL).
+			SetTransport(base)
+
+		return cfg.webClient(ctx, tripper)
+	}
+
+	if session := cfg.Authentication.Session; session != nil {
+		login, err := cfg.sessionLogin(ctx, session)
+		if err != nil {
+			return nil, err
+		}
+
+		tripper := auth.NewSession().SetLogin(login).SetURL(cfg.RawURL).SetTransport(base)
+		if csrf := session.CSRF; csrf != nil {
+			tripper.SetCSRF(&auth.SessionCSRF{
+				Cookie:         csrf.Cookie,
+				ResponseHeader: csrf.ResponseHeader,
+				Field:          csrf.Field,
+				Header:         csrf.Header,
+			})
+		}
+
+		return cfg.webClient(ctx, tripper)
+	}
+
+	if kerberos := cfg.Authentication.Kerberos; kerberos != nil {
+		if cfg.GSS == nil {
+			return nil, WrapWebError(auth.ErrGSSProviderRequired)
+		}
+
+		tripper := auth.NewNegotiate().SetGSS(cfg.GSS).SetSPN(kerberos.SPN).SetURL(cfg.RawURL).SetTransport(base)
+
+		return cfg.webClient(ctx, tripper)
+	}
+
+	if apiKey := cfg.Authentication.Auth2; apiKey != nil {
+		bearer := apiKey.Bearer
+		if err := cfg.resolveSecrets(ctx, &bearer); err != nil {
+			return nil, err
+		}
+
+		return cfg.webClient(ctx, auth.NewAuth2().SetBearer(bearer).SetURL(cfg.RawURL).SetTransport(base))
+	}
+
+	// In the case of no authentication, create a client without an auth transport.
+	return cfg.webClient(ctx, nil)
+}
+
+// webClient will return a web client with the auth transport, reusing the configured HTTP client if there is one.
+func (cfg *Config) webClient(ctx context.Context, tripper auth.Transport) (*web.Client, error) {
+	var (
+		client *web.Client
+		err    error
+	)
+
+	if cfg.HTTPClient != nil {
+		client, err = web.NewClientFromHTTP(ctx, cfg.HTTPClient, tripper)
+	} else {
+		client, err = web.NewClient(ctx, tripper)
+	}
+
+	if err != nil {
+		return nil, WrapWebError(web.FailedToCreateClientError(err))
+	}
+
+	if cfg.Timeout > 0 {
+		client.Client.Timeout = cfg.Timeout
+	}
+
+	return client, nil
+}
+
+type repoCloser func()
+
+// repos will return a slice of generic repositories along with associated transaction instances. The repositories
+// are checked to support the storage options of the requests before anything is truncated or fetched.
+func (cfg *Config) repos(ctx context.Context) ([]repository.Generic, repoCloser, error) {
+	repos := []repository.Generic{}
+
+	closeRepos := func() {
+		for _, repo := range repos {
+			repo.Close()
+
+			logInfo := tools.LogFormatter{
+				Msg: fmt.Sprintf("closed repository for %q", storage.Scheme(repo.Type())),
+			}
+			cfg.Logger.Info(logInfo.String())
+		}
+	}
+
+	for _, dns := range cfg.ConnectionStrings {
+		repo, err := cfg.newRepo(ctx, dns)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		repos = append(repos, repo)
+
+		if err := cfg.checkVersioned(repo); err != nil {
+			closeRepos()
+
+			return nil, nil, err
+		}
+	}
+
+	return repos, closeRepos, nil
+}
+
+// checkVersioned will return an error if a request has a version field, e.g. of a sync conflict policy, and the
+// storage device of a repository would ignore it and overwrite newer records.
+func (cfg *Config) checkVersioned(repo repository.Generic) error {
+	for _, req := range cfg.Requests {
+		if field := req.versionField(); field != "" {
+			if err := repo.CheckVersioned(); err != nil {
+				return fmt.Errorf("unable to use version field %q of table %q: %w", field, req.Table, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// newRepo will return the repository of a connection string.
+func (cfg *Config) newRepo(ctx context.Context, dns string) (repository.Generic, error) {
+	if cfg.GSS != nil {
+		storage.RegisterPostgresGSS(func() (storage.PostgresGSS, error) { return cfg.GSS() })
+	}
+
+	// The connection string is logged with its secret references, the resolved secrets are never logged.
+	resolved, err := cfg.secret(ctx, dns)
+	if err != nil {
+		return nil, err
+	}
+
+	repo, err := repository.NewTx(ctx, resolved)
+	if err != nil {
+		return nil, WrapRepositoryError(repository.FailedToCreateRepositoryError(err))
+	}
+
+	logInfo := tools.LogFormatter{
+		Msg: fmt.Sprintf("created repository for %q", dns),
+	}
+	cfg.Logger.Info(logInfo.String())
+
+	if cfg.Database != "" {
+		repo.SelectDatabase(cfg.Database)
+	}
+
+	if repo.Untransacted() {
+		logWarn := tools.LogFormatter{
+			Msg: fmt.Sprintf("%q does not support transactions, writes are not rolled back on failure", dns),
+		}
+		cfg.Logger.Warn(logWarn.String())
+	}
+
+	return repo, nil
+}
+
+// validate will ensure that the configuration is valid for querying the web API.
+func (cfg *Config) validate() error {
+	if cfg.RateLimitConfig == nil {
+		return MissingConfigFieldError("rateLimit")
+	}
+
+	if err := cfg.RateLimitConfig.validate(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRateLimit, err)
+	}
+
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("%w: timeout must not be negative", ErrInvalidTimeout)
+	}
+
+	if cfg.StartJitter < 0 {
+		return fmt.Errorf("%w: startJitter must not be negative", ErrInvalidRateLimit)
+	}
+
+	for key, value := range cfg.Authentication.Headers {
+		if value == "" {
+			return MissingConfigFieldError("authentication.headers." + key)
+		}
+	}
+
+	if err := cfg.Authentication.JWT.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Authentication.Session.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.ErrorBudget.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Bandwidth.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Concurrency.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.TruncatePolicy.validate(); err != nil {
+		return err
+	}
+
+	if _, err := cfg.mirrorURLs(); err != nil {
+		return err
+	}
+
+	if err := cfg.Embedding.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Planner.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Anomaly.validate(); err != nil {
+		return err
+	}
+
+	for _, pub := range cfg.Publish {
+		if err := pub.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, assertion := range cfg.Assertions {
+		if err := assertion.validate(); err != nil {
+			return err
+		}
+	}
+
+	if err := cfg.Admin.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Autoscale.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Decode.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Profile.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Verify.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Schema.validate(); err != nil {
+		return err
+	}
+
+	for scheme, policy := range cfg.Flush {
+		if err := policy.validate(scheme); err != nil {
+			return err
+		}
+	}
+
+	for _, req := range cfg.Requests {
+		if err := req.validateRateLimit(cfg.RateLimitConfig); err != nil {
+			return err
+		}
+
+		if err := req.ErrorBudget.validate(); err != nil {
+			return err
+		}
+
+		if req.Timeseries != nil {
+			if err := req.Timeseries.Tail.validate(); err != nil {
+				return err
+			}
+
+			if err := req.Timeseries.Gaps.validate(req.Timeseries); err != nil {
+				return err
+			}
+		}
+
+		if err := req.Nested.validate(); err != nil {
+			return err
+		}
+
+		if err := req.MissingFields.validate(req.Defaults); err != nil {
+			return err
+		}
+
+		if err := req.JSONDecoder.validate(); err != nil {
+			return err
+		}
+
+		if err := req.LargeIntegers.validate(); err != nil {
+			return err
+		}
+
+		if err := req.Collation.validate(); err != nil {
+			return err
+		}
+
+		for _, geo := range req.Geo {
+			if err := geo.validate(); err != nil {
+				return err
+			}
+		}
+
+		if err := req.Graph.validate(); err != nil {
+			return err
+		}
+
+		for _, embed := range req.Embed {
+			if err := embed.validate(); err != nil {
+				return err
+			}
+		}
+
+		if err := req.Compress.validate(); err != nil {
+			return err
+		}
+
+		if err := req.Aggregate.validate(); err != nil {
+			return err
+		}
+
+		if err := req.WASM.validate(); err != nil {
+			return err
+		}
+
+		if err := req.Exec.validate(); err != nil {
+			return err
+		}
+
+		if err := req.SQL.validate(); err != nil {
+			return err
+		}
+
+		if err := req.Sync.validate(req.VersionField); err != nil {
+			return err
+		}
+
+		if err := req.SurrogateKey.validate(); err != nil {
+			return err
+		}
+
+		if req.SQL != nil && req.Table == "" {
+			return InvalidSQLSourceError("table is required")
+		}
+
+		if req.SQL != nil && req.Timeseries != nil {
+			return InvalidSQLSourceError("timeseries can not be used with a sql source")
+		}
+
+		// A failed source fails the operation, since it can not be retried as a failed chunk.
+		if req.SQL != nil && req.ErrorBudget != nil {
+			return InvalidSQLSourceError("errorBudget can not be used with a sql source")
+		}
+
+		if err := validateNormalize(req.Normalize); err != nil {
+			return err
+		}
+
+		for _, dns := range cfg.ConnectionStrings {
+			scheme, _, _ := strings.Cut(dns, "://")
+
+			// Only Postgres deletes the stored records of marked records, other storage devices would upsert them.
+			if req.DeleteField != "" && scheme != storage.Scheme(storage.PostgresType) {
+				return DeleteFieldNotSupportedError(scheme)
+			}
+
+			// Parquet files are appended to, so the missing fields of the records are always null.
+			if req.MissingFields.storage() == storage.MissingFieldsKeep && scheme == storage.Scheme(storage.ParquetType) {
+				return InvalidMissingFieldsError(req.MissingFields, "parquet files are append-only")
+			}
+		}
+
+		if _, err := parseLocale(req.Locale); err != nil {
+			return err
+		}
+
+		for _, rule := range req.Coerce {
+			if err := rule.validate(); err != nil {
+				return err
+			}
+		}
+
+		for _, rule := range req.Drop {
+			if err := rule.validate("drop"); err != nil {
+				return err
+			}
+		}
+
+		for _, rule := range req.Keep {
+			if err := rule.validate("keep"); err != nil {
+				return err
+			}
+		}
+
+		if err := req.validateParamFields(); err != nil {
+			return err
+		}
+
+		for _, lookup := range req.Lookups {
+			if err := lookup.validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := cfg.Audit.validate(); err != nil {
+		return err
+	}
+
+	if err := cfg.Archive.validate(cfg.ArchiveStore); err != nil {
+		return err
+	}
+
+	if cfg.ConnectionStrings == nil {
+		logWarn := tools.LogFormatter{
+			Msg: "no connectionStrings specified in the config file",
+		}
+		cfg.Logger.Warn(logWarn.String())
+	}
+
+	return nil
+}
+
+// flattenRequests will flatten the requests into a single slice for HTTP requests.
+func (cfg *Config) flattenRequests(ctx context.Context) ([]*flattenedRequest, error) {
+	return cfg.flatten(ctx, cfg.Requests)
+}
+
+// flatten will flatten a list of the configuration's requests into a single slice for HTTP requests.
+func (cfg *Config) flatten(ctx context.Context, requests []*Request) ([]*flattenedRequest, error) {
+	client, err := cfg.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to web API: %w", err)
+	}
+
+	var flattenedRequests []*flattenedRequest
+
+	for _, req := range requests {
+		if req.SQL != nil {
+			flatReq, err := req.flattenSource()
+			if err != nil {
+				return nil, err
+			}
+
+			flatReq.order = req.newRecordOrder()
+			flatReq.request = cfg.requestIndex(req)
+
+			flattenedRequests = append(flattenedRequests, flatReq)
+
+			continue
+		}
+
+		flatReqs, err := req.flattenTimeseries(*cfg.URL, client)
+		if err != nil {
+			return nil, err
+		}
+
+		// All of the chunks of a request share the same error budget, and the same order if it is ordered.
+		budget := newErrorBudget(req.ErrorBudget, len(flatReqs))
+		order := req.newRecordOrder()
+		reqIdx := cfg.requestIndex(req)
+
+		for idx, flatReq := range flatReqs {
+			flatReq.request = reqIdx
+			flatReq.budget = budget
+			flatReq.noCache = cfg.NoCache || req.NoCache
+			flatReq.order, flatReq.seq = order, idx
+		}
+
+		flattenedRequests = append(flattenedRequests, flatReqs...)
+	}
+
+	if len(flattenedRequests) == 0 {
+		return nil, ErrNoRequests
+	}
+
+	return flattenedRequests, nil
+}
+
+type repoJob struct {
+	req   http.Request
+	b     []byte
+	table string
+	stamp *StampConfig
+	runID string
+
+	// partial is true if the job is not the last of its flattened request, e.g. a batch of a SQL source.
+	partial bool
+
+	// snapshot is the snapshot chunk of the job's records, if they are imported from a snapshot.
+	snapshot *snapshotChunk
+
+	// order writes the jobs of an ordered request in order, where seq is the index of the job's flattened request and
+	// part is the index of the job within it.
+	order *recordOrder
+	seq   int
+	part  int
+
+	// failed is true if the job only marks the failure of its flattened request for the order, it has no records.
+	failed bool
+
+	*storageOptions
+}
+
+type repoConfig struct {
+	repos      []repository.Generic
+	closeRepos repoCloser
+	jobs       chan *repoJob
+	done       chan error
+	logger     *logrus.Logger
+	progress   *progress
+	runID      string
+
+	// tables are the tables that upserts were queued for.
+	tables *tableSet
+
+	// events collects the events of the upserts, if they are published.
+	events *eventCollector
+
+	// counts counts the records and response bytes of each table, if anomalies are detected.
+	counts *runCounts
+
+	// reconciler reconciles the upserted records with the records present in storage, if enabled.
+	reconciler *reconciler
+
+	// verifier samples the upserted records to re-read them once they are committed, if enabled.
+	verifier *verifier
+
+	// schemas validates the upserted records against the schemas of their tables, if enabled.
+	schemas *schemaValidator
+
+	// auditor records the upserts in the audit log, if enabled.
+	auditor *auditor
+
+	// scaler adjusts the number of active workers, if they are autoscaled.
+	scaler *autoscaler
+
+	// decodeJobs queues the fetched responses for the decode workers, if they are decoded by a separate pool.
+	decodeJobs chan *fetchedResponse
+
+	// archiver archives the web responses, if enabled.
+	archiver *archiver
+
+	// flushers commit the transactions of the repositories during the run, by their flush policies.
+	flushers []*flusher
+
+	// journals journal the upserts of the repositories without transactions, if enabled.
+	journals []*journal
+}
+
+// flusher will return the flusher of the repository at an index, or nil if its transaction is committed at the end of
+// the run.
+func (rc *repoConfig) flusher(idx int) *flusher {
+	if rc.flushers == nil {
+		return nil
+	}
+
+	return rc.flushers[idx]
+}
+
+// journal will return the journal of the repository at an index, or nil if its upserts are not journaled.
+func (rc *repoConfig) journal(idx int) *journal {
+	if rc.journals == nil {
+		return nil
+	}
+
+	return rc.journals[idx]
+}
+
+func newRepoConfig(ctx context.Context, cfg *Config, volume int, runID string) (*repoConfig, error) {
+	repos, closeRepos, err := cfg.repos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The upserts that previous runs did not finish writing are replayed before the upserts of this run.
+	journals, err := cfg.openJournals(ctx, repos)
+	if err != nil {
+		closeRepos()
+
+		return nil, err
+	}
+
+	return &repoConfig{
+		repos:      repos,
+		closeRepos: closeRepos,
+		journals:   journals,
+		jobs:       make(chan *repoJob, volume*len(repos)),
+		done:       make(chan error, volume),
+		logger:     cfg.Logger,
+		runID:      runID,
+		tables:     new(tableSet),
+		events:     cfg.eventCollector(),
+		reconciler: cfg.reconciler(),
+		verifier:   cfg.verifier(),
+		schemas:    cfg.schemaValidator(),
+		auditor:    cfg.newAuditor(runID),
+	}, nil
+}
+
+// upsertRequests will return the upsert requests of a repository job for a type of storage device, with the job's
+// nested strategy applied, its records synced, filtered, computed, aggregated, enriched from lookup tables, and keyed,
+// and the records stamped with metadata and param fields. The request for the job's table comes first, followed by
+// any child tables.
+func (job *repoJob) upsertRequests(scheme string) ([]*proto.UpsertRequest, error) {
+	tables, err := job.nested.split(scheme, job.table, job.b)
+	if err != nil {
+		return nil, fmt.Errorf("unable to apply nested strategy: %w", err)
+	}
+
+	stamp := job.stamp.fields(time.Now(), &job.req, job.runID)
+	if stamp == nil && len(job.paramFields) > 0 {
+		stamp = make(map[string]interface{}, len(job.paramFields))
+	}
+
+	for field, value := range job.paramFields {
+		stamp[field] = value
+	}
+	reqs := make([]*proto.UpsertRequest, 0, len(tables))
+
+	for _, table := range tables {
+		if table.data, err = job.rules.apply(table.table, job.table, table.data); err != nil {
+			return nil, err
+		}
+
+		// The job's records are synced, filtered, computed, aggregated, and enriched before they are stamped, so that
+		// the stamps are kept.
+		if table.table == job.table {
+			if table.data, err = job.sync.apply(table.data); err != nil {
+				return nil, err
+			}
+
+			if table.data, err = job.transform.apply(table.data); err != nil {
+				return nil, err
+			}
+
+			if table.data, err = job.aggregate.apply(table.data); err != nil {
+				return nil, err
+			}
+
+			if table.data, err = applyLookups(job.lookups, table.data); err != nil {
+				return nil, err
+			}
+
+			// The records are keyed before they are stamped, so that the key does not depend on when they were
+			// ingested, but the param fields that will be stamped are part of the key.
+			if table.data, err = job.surrogateKey.apply(table.data, job.paramFields); err != nil {
+				return nil, fmt.Errorf("unable to generate surrogate keys: %w", err)
+			}
+		}
+
+		if len(stamp) > 0 {
+			if table.data, err = tools.StampRecords(job.dataType, table.data, stamp); err != nil {
+				return nil, fmt.Errorf("unable to stamp records: %w", err)
+			}
+		}
+
+		req := &proto.UpsertRequest{
+			Table:    table.table,
+			Data:     table.data,
+			DataType: int32(job.dataType),
+			Database: job.database,
+		}
+
+		// The version, delete, json column, decimal, nanosecond timestamp, missing fields, collation, graph, geo,
+		// vector, and compression options only apply to the job's table.
+		if table.table == job.table {
+			req.VersionField = job.versionField
+			req.DeleteField = job.deleteField
+			req.JsonColumn = job.jsonColumn
+			req.DecimalFields = job.decimals
+			req.NanoTimestampFields = job.nanos
+			req.MissingFields = job.missing.storage()
+			job.collation.apply(req)
+			req.Graph = job.graph.proto()
+
+			for _, embed := range job.embed {
+				req.VectorFields = append(req.VectorFields, embed.Target)
+			}
+
+			if req.Data, err = applyDefaults(job.defaults, req.Data); err != nil {
+				return nil, fmt.Errorf("unable to apply defaults: %w", err)
+			}
+
+			if req.Data, err = tools.DecimalRecords(req.Data, job.decimals); err != nil {
+				return nil, fmt.Errorf("unable to preserve decimal fields: %w", err)
+			}
+
+			if req.Data, err = tools.NanoTimestampRecords(req.Data, job.nanos); err != nil {
+				return nil, fmt.Errorf("unable to preserve nanosecond timestamps: %w", err)
+			}
+
+			for _, geo := range job.geo {
+				req.GeoFields = append(req.GeoFields, geo.Field)
+			}
+
+			if req.Data, err = applyGeoFields(job.geo, req.Data); err != nil {
+				return nil, fmt.Errorf("unable to apply geo fields: %w", err)
+			}
+
+			if req.Data, err = job.compress.apply(req.Data); err != nil {
+				return nil, err
+			}
+		}
+
+		reqs = append(reqs, req)
+	}
+
+	return reqs, nil
+}
+
+func repositoryWorker(_ context.Context, workerID int, cfg *repoConfig) {
+	for job := range cfg.jobs {
+		cfg.scaler.writerGate().acquire()
+
+		job.order.write(job, func(job *repoJob) { writeRepoJob(workerID, cfg, job) })
+
+		cfg.scaler.writerGate().release()
+	}
+}
+
+// writeRepoJob will put the upserts of a repository job on the transactions of the repositories.
+func writeRepoJob(workerID int, cfg *repoConfig, job *repoJob) {
+	for idx, repo := range cfg.repos {
+		idx, fl, jrnl := idx, cfg.flusher(idx), cfg.journal(idx)
+
+		if !job.snapshot.importedBy(storage.Scheme(repo.Type())) {
+			continue
+		}
+
+		reqs, err := job.upsertRequests(storage.Scheme(repo.Type()))
+		if err != nil {
+			// Fail the transaction, the error is returned when it is committed.
+			terr := &Error{Table: job.table, URL: job.req.URL.String(), Err: err}
+			fl.transact(repo, func(context.Context, repository.Generic) error { return terr })
+
+			continue
+		}
+
+		for _, req := range reqs {
+			req := req
+
+			// The keys of the upserted records are needed for the key range of the published events and audit entries.
+			req.ReturnKeys = cfg.events != nil || cfg.auditor != nil
+
+			txfn := func(sctx context.Context, repo repository.Generic) error {
+				// The records are validated before they are journaled, so that records that can not be written are
+				// not replayed.
+				if err := cfg.schemas.validate(sctx, idx, repo, req); err != nil {
+					return &Error{Table: req.Table, URL: job.req.URL.String(), Err: err}
+				}
+
+				seq, err := jrnl.write(req)
+				if err != nil {
+					return &Error{Table: req.Table, URL: job.req.URL.String(), Err: err}
+				}
+
+				start := time.Now()
+
+				rsp, err := repo.Upsert(sctx, req)
+				if err != nil {
+					// A rejected upsert would be rejected again by every replay. Other failures, e.g. a lost
+					// connection, leave the upsert pending, so that the next run replays it.
+					if errors.Is(err, storage.ErrRejected) {
+						if rerr := jrnl.reject(req, seq, err); rerr != nil {
+							err = fmt.Errorf("%w (%v)", err, rerr)
+						}
+					}
+
+					return &Error{Table: req.Table, URL: job.req.URL.String(), Err: err}
+				}
+
+				if err := jrnl.ack(req.Table, seq); err != nil {
+					return &Error{Table: req.Table, URL: job.req.URL.String(), Err: err}
+				}
+
+				cfg.scaler.observeWrite(time.Since(start))
+
+				rt := repo.Type()
+
+				msg := fmt.Sprintf("partial upsert completed: %s.%s", storage.Scheme(rt), req.Table)
+				logInfo := tools.LogFormatter{
+					WorkerID:       workerID,
+					WorkerName:     "repository",
+					Duration:       time.Since(start),
+					Msg:            msg,
+					UpsertedCount:  rsp.UpsertedCount,
+					MatchedCount:   rsp.MatchedCount,
+					InsertedCount:  rsp.InsertedCount,
+					UpdatedCount:   rsp.UpdatedCount,
+					UnchangedCount: rsp.UnchangedCount,
+					DeletedCount:   rsp.DeletedCount,
+				}
+
+				cfg.logger.Infof(logInfo.String())
+				cfg.progress.recordsWritten(req.Table, storage.Scheme(rt), rsp.UpsertedCount+rsp.MatchedCount)
+				fl.upserted(rsp.UpsertedCount + rsp.MatchedCount)
+
+				if cfg.events != nil {
+					cfg.events.add(storage.Scheme(rt), req.Table, rsp)
+				}
+
+				cfg.auditor.upserted(storage.Scheme(rt), req.Table, rsp)
+
+				if err := cfg.verifier.sample(idx, req); err != nil {
+					return &Error{Table: req.Table, URL: job.req.URL.String(), Err: err}
+				}
+
+				return cfg.reconciler.count(sctx, repo, req)
+			}
+			// Put the data onto the transaction channel for storage.
+			fl.transact(repo, txfn)
+			cfg.tables.add(req.Table)
+		}
+	}
+
+	// The flattened request is done with its last job.
+	if job.partial {
+		return
+	}
+
+	cfg.progress.chunkDone(job.table)
+	cfg.done <- nil
+}
+
+type webJob struct {
+	*flattenedRequest
+	repoJobs   chan<- *repoJob
+	done       chan<- error
+	runBudget  *errorBudget
+	recordType *tools.TypedRecordDecoder
 	logger     *logrus.Logger
+	progress   *progress
+	counts     *runCounts
+	cache      *fetchCache
+	stamp      *StampConfig
+	runID      string
+	embedder   Embedder
+	wasm       WASMRuntime
+	control    *runControl
+	scaler     *autoscaler
+	archiver   *archiver
+	decodeJobs chan<- *fetchedResponse
+
+	// parts is the number of repository jobs that the web job has sent.
+	parts int
+}
+
+func newWebJob(cfg *Config, req *flattenedRequest, repoConfig *repoConfig, runBudget *errorBudget,
+	cache *fetchCache,
+) *webJob {
+	job := &webJob{
+		flattenedRequest: req,
+		repoJobs:         repoConfig.jobs,
+		done:             repoConfig.done,
+		runBudget:        runBudget,
+		recordType:       cfg.RecordTypes[req.table],
+		logger:           cfg.Logger,
+		progress:         repoConfig.progress,
+		counts:           repoConfig.counts,
+		cache:            cache,
+		stamp:            cfg.Stamp,
+		runID:            repoConfig.runID,
+		embedder:         cfg.embedder(),
+		wasm:             cfg.WASMRuntime,
+		control:          cfg.control,
+		scaler:           repoConfig.scaler,
+		archiver:         repoConfig.archiver,
+		decodeJobs:       repoConfig.decodeJobs,
+	}
+
+	// The records of a snapshot were decoded and stamped by the run that stored them.
+	if req.snapshot != nil {
+		job.recordType, job.stamp = nil, nil
+	}
+
+	return job
 }
 
-func newRepoConfig(ctx context.Context, cfg *Config, volume int) (*repoConfig, error) {
-	repos, closeRepos, err := cfg.repos(ctx)
+// decodeTyped will decode and validate the response data with the job's record type, returning the typed records
+// encoded as JSON. If the job has no record type, the data is returned as is.
+func (job *webJob) decodeTyped(data []byte) ([]byte, error) {
+	if job.recordType == nil {
+		return data, nil
+	}
+
+	records, err := job.recordType.Records(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode typed records: %w", err)
+	}
+
+	bytes, err := json.Marshal(records)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode typed records: %w", err)
+	}
+
+	return bytes, nil
+}
+
+// fail will report a failed web job as done, so that the upsert operation does not wait on data that will never be
+// sent to the repository workers. Without an error budget, the failure fails the operation with its own error. If
+// the failure exceeds the error budget of the request or of the run, whichever are configured, the error will be
+// wrapped with ErrErrorBudgetExceeded. Otherwise the chunk is skipped. Jobs that read a SQL source or a snapshot
+// always fail the operation, since they have no web request that a retry of the failed chunks could repeat.
+func (job *webJob) fail(err error) {
+	terr := &Error{Table: job.table, Err: err, fetchConfig: job.fetchConfig, request: job.request}
+
+	if job.fetchConfig != nil {
+		terr.Method = job.fetchConfig.Method
+		terr.URL = job.fetchConfig.URL.String()
+	} else if job.archived != nil {
+		terr.Method = job.archived.entry.Method
+		terr.URL = job.archived.entry.URL
+	} else if job.source != nil {
+		terr.URL = job.source.url.String()
+	} else if job.snapshot != nil {
+		terr.URL = job.snapshot.url.String()
+	}
+
+	job.sendFailed()
+	job.progress.chunkFailed(job.table)
+
+	requestBudget, runBudget := job.budget.configured(), job.runBudget.configured()
+	if (!requestBudget && !runBudget) || job.source != nil || job.snapshot != nil {
+		job.done <- terr
+
+		return
+	}
+
+	// Both budgets count the failure, even if the first is exceeded.
+	requestExceeded := requestBudget && job.budget.fail()
+	runExceeded := runBudget && job.runBudget.fail()
+
+	if requestExceeded || runExceeded {
+		job.done <- ErrorBudgetExceededError(terr)
+
+		return
+	}
+
+	job.done <- &skippedChunkError{terr: terr}
+}
+
+// process will count, normalize, coerce, decode, and transform the data of a web job, returning the records to store.
+func (job *webJob) process(ctx context.Context, bytes []byte) ([]byte, error) {
+	job.counts.add(job.table, bytes)
+
+	// Large integers are kept as strings before any step decodes the records as floating-point numbers.
+	bytes, err := applyLargeIntegers(job.integers, job.dataType, bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	return &repoConfig{
-		repos:      repos,
-		closeRepos: closeRepos,
-		jobs:       make(chan *repoJob, volume*len(repos)),
-		done:       make(chan bool, volume),
-		logger:     cfg.Logger,
-	}, nil
+	bytes, err = applyNormalize(job.normalize, job.dataType, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err = applyCoercion(job.coerce, job.locale, job.dataType, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err = job.decodeTyped(bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err = applyWASM(ctx, job.wasm, job.storageOptions.wasm, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err = applyExec(ctx, job.logger, job.storageOptions.exec, job.dataType, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err = applyEmbeddings(ctx, job.embedder, job.embed, bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes, nil
 }
 
-func repositoryWorker(_ context.Context, workerID int, cfg *repoConfig) {
-	for job := range cfg.jobs {
-		reqs := []*proto.UpsertRequest{
-			{
-				Table:    job.table,
-				Data:     job.b,
-				DataType: int32(tools.UpsertDataJSON),
-			},
-		}
+// skip will report a web job whose table was canceled by the admin API as done, without fetching it.
+func (job *webJob) skip() {
+	logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped chunk of canceled table: %s", job.table)}
+	job.logger.Warn(logWarn.String())
 
-		for _, req := range reqs {
-			for _, repo := range cfg.repos {
-				txfn := func(sctx context.Context, repo repository.Generic) error {
-					start := time.Now()
+	job.sendFailed()
+	job.progress.chunkDone(job.table)
+	job.done <- nil
+}
 
-					rsp, err := repo.Upsert(sctx, req)
-					if err != nil {
-						cfg.logger.Fatalf("error upserting data: %v", err)
+// send will send a repository job of the web job to the repository workers, in the order of the web job's request if
+// it is ordered.
+func (job *webJob) send(rjob *repoJob) {
+	rjob.order, rjob.seq, rjob.part = job.order, job.seq, job.parts
+	job.parts++
 
-						return fmt.Errorf("error upserting data: %w", err)
-					}
+	job.progress.recordsSent(job.table, rjob.b)
 
-					rt := repo.Type()
+	job.repoJobs <- rjob
+}
 
-					msg := fmt.Sprintf("partial upsert completed: %s.%s", storage.Scheme(rt), req.Table)
-					logInfo := tools.LogFormatter{
-						WorkerID:      workerID,
-						WorkerName:    "repository",
-						Duration:      time.Since(start),
-						Msg:           msg,
-						UpsertedCount: rsp.UpsertedCount,
-						MatchedCount:  rsp.MatchedCount,
-					}
+// sendFailed will mark the web job as failed for the order of its request, so that the repository jobs of the
+// flattened requests after it are not held back. Web jobs of unordered requests send nothing.
+func (job *webJob) sendFailed() {
+	if job.order == nil {
+		return
+	}
 
-					cfg.logger.Infof(logInfo.String())
+	job.repoJobs <- &repoJob{table: job.table, order: job.order, seq: job.seq, part: job.parts, failed: true}
+}
 
-					return nil
-				}
-				// Put the data onto the transaction channel for storage.
-				repo.Transact(txfn)
-			}
+// run will fetch a web job, or read its SQL source, and send its records to the repository workers.
+func (job *webJob) run(ctx context.Context, workerID int) {
+	// Fetching is paused by the admin API between chunks, so that the chunks in flight are still written.
+	if err := job.control.wait(ctx); err != nil {
+		job.fail(err)
+
+		return
+	}
+
+	if job.control.isCanceled(job.table, job.request) {
+		job.skip()
+
+		return
+	}
+
+	if job.source != nil {
+		job.stream(ctx, workerID)
+
+		return
+	}
+
+	start := time.Now()
+
+	bytes, req, shared, err := job.fetch(ctx)
+
+	// Responses shared from the cache, replayed from an archive, or imported from a snapshot were not requested.
+	if !shared && job.archived == nil && job.snapshot == nil {
+		job.stats.observe(job.endpoint, time.Since(start), bytes, err)
+	}
+
+	if err != nil {
+		job.fail(err)
+
+		return
+	}
+
+	// Responses shared from the cache were archived by the request that made them, replayed responses are archived
+	// already, and snapshots are not web responses.
+	if !shared && job.archived == nil && job.snapshot == nil {
+		if err := job.archiver.add(ctx, job.table, req, bytes); err != nil {
+			job.fail(err)
+
+			return
 		}
+	}
 
-		cfg.done <- true
+	fetched := &fetchedResponse{webJob: job, bytes: bytes, req: req, shared: shared, start: start}
+
+	// With a decode pool, the web worker moves on to the next request while a decode worker processes the response.
+	if job.decodeJobs != nil {
+		job.decodeJobs <- fetched
+
+		return
 	}
+
+	fetched.finish(ctx, workerID, "web")
 }
 
-type webJob struct {
-	*flattenedRequest
-	repoJobs chan<- *repoJob
-	logger   *logrus.Logger
+// fetchedResponse is the response data of a web job, fetched at start, that has yet to be processed and sent to the
+// repository workers.
+type fetchedResponse struct {
+	*webJob
+	bytes  []byte
+	req    *http.Request
+	shared bool
+	start  time.Time
 }
 
-func newWebJob(cfg *Config, req *flattenedRequest, repoJobs chan<- *repoJob) *webJob {
-	return &webJob{
-		flattenedRequest: req,
-		repoJobs:         repoJobs,
-		logger:           cfg.Logger,
+// finish will process the response data of a web job, send its records to the repository workers, and log the
+// completed request.
+func (fetched *fetchedResponse) finish(ctx context.Context, workerID int, workerName string) {
+	job := fetched.webJob
+
+	bytes, err := job.process(ctx, fetched.bytes)
+	if err != nil {
+		job.fail(err)
+
+		return
+	}
+
+	job.send(&repoJob{
+		b:              bytes,
+		req:            *fetched.req,
+		table:          job.table,
+		stamp:          job.stamp,
+		runID:          job.runID,
+		snapshot:       job.snapshot,
+		storageOptions: job.storageOptions,
+	})
+
+	// strings.Replace is used to ensure no line endings are present in the user input.
+	escapedPath := strings.ReplaceAll(fetched.req.URL.Path, "\n", "")
+	escapedPath = strings.ReplaceAll(escapedPath, "\r", "")
+
+	escapedHost := strings.ReplaceAll(fetched.req.URL.Host, "\n", "")
+	escapedHost = strings.ReplaceAll(escapedHost, "\r", "")
+
+	logInfo := tools.LogFormatter{
+		WorkerID:   workerID,
+		WorkerName: workerName,
+		Duration:   time.Since(fetched.start),
+		Host:       escapedHost,
+		Msg:        fmt.Sprintf("web request completed: %s", escapedPath),
 	}
+
+	if fetched.shared {
+		logInfo.Msg = fmt.Sprintf("web request shared from cache: %s", escapedPath)
+	}
+
+	if job.archived != nil {
+		logInfo.Msg = fmt.Sprintf("web request replayed from archive: %s", escapedPath)
+	}
+
+	if job.snapshot != nil {
+		logInfo.Msg = fmt.Sprintf("snapshot chunk imported: %s", escapedPath)
+	}
+
+	job.logger.Infof(logInfo.String())
 }
 
-func webWorker(ctx context.Context, workerID int, jobs <-chan *webJob) {
-	for job := range jobs {
-		start := time.Now()
+// fetch will return the response body of the job's web request, from the archive if the job replays an archived
+// response or from the snapshot if the job imports a snapshot chunk, and whether the response was shared from the
+// cache.
+func (job *webJob) fetch(ctx context.Context) ([]byte, *http.Request, bool, error) {
+	if job.archived != nil {
+		bytes, req, err := job.archived.read(ctx)
 
-		rsp, err := web.Fetch(ctx, job.fetchConfig)
-		if err != nil {
-			job.logger.Fatal(err)
-		}
+		return bytes, req, false, err
+	}
 
-		bytes, err := io.ReadAll(rsp.Body)
-		if err != nil {
-			job.logger.Fatal(err)
-		}
+	if job.snapshot != nil {
+		bytes, req, err := job.snapshot.read(ctx)
+
+		return bytes, req, false, err
+	}
 
-		job.repoJobs <- &repoJob{b: bytes, req: *rsp.Request, table: job.table}
+	return job.cache.fetch(ctx, job.flattenedRequest)
+}
 
-		// strings.Replace is used to ensure no line endings are present in the user input.
-		escapedPath := strings.ReplaceAll(rsp.Request.URL.Path, "\n", "")
-		escapedPath = strings.ReplaceAll(escapedPath, "\r", "")
+func webWorker(ctx context.Context, workerID int, jobs <-chan *webJob) {
+	for job := range jobs {
+		job.scaler.fetcherGate().acquire()
 
-		escapedHost := strings.ReplaceAll(rsp.Request.URL.Host, "\n", "")
-		escapedHost = strings.ReplaceAll(escapedHost, "\r", "")
+		start := time.Now()
+		job.run(ctx, workerID)
 
-		logInfo := tools.LogFormatter{
-			WorkerID:   workerID,
-			WorkerName: "web",
-			Duration:   time.Since(start),
-			Host:       escapedHost,
-			Msg:        fmt.Sprintf("web request completed: %s", escapedPath),
-		}
-		job.logger.Infof(logInfo.String())
+		job.scaler.observeFetch(time.Since(start))
+		job.scaler.fetcherGate().release()
 	}
 }
 
 // Truncate will truncate the defined tables in the configuration.
 func Truncate(ctx context.Context, cfg *Config) error {
+	return truncate(ctx, cfg, cfg.runID())
+}
+
+// truncate will truncate the defined tables in the configuration, recording the truncates in the audit log for the
+// run.
+func truncate(ctx context.Context, cfg *Config, runID string) error {
 	if !cfg.Truncate {
 		return nil
 	}
 
+	defer cfg.logRunID(runID)()
+
 	start := time.Now()
 
 	repos, closeRepos, err := cfg.repos(ctx)
@@ -478,23 +2245,29 @@ func Truncate(ctx context.Context, cfg *Config) error {
 
 	defer closeRepos()
 
-	// truncateRequest is a special request that will truncate the table before upserting data.
-	truncateRequest := new(proto.TruncateRequest)
-
-	for _, req := range cfg.Requests {
-		// Add the table to the list of tables to truncate.
-		truncateRequest.Tables = append(truncateRequest.Tables, req.Table)
+	plans, err := cfg.planTruncate(ctx, repos)
+	if err != nil {
+		return err
 	}
 
-	for _, repo := range repos {
+	aud := cfg.newAuditor(runID)
+
+	for _, plan := range plans {
 		start := time.Now()
 
-		_, err := repo.Truncate(ctx, truncateRequest)
+		if len(plan.tables) == 0 {
+			continue
+		}
+
+		// truncateRequest is a special request that will truncate the table before upserting data.
+		truncateRequest := &proto.TruncateRequest{Tables: plan.tables, Database: plan.database}
+
+		_, err := plan.repo.Truncate(ctx, truncateRequest)
 		if err != nil {
 			return fmt.Errorf("unable to truncate tables: %w", err)
 		}
 
-		rt := repo.Type()
+		rt := plan.repo.Type()
 		tables := strings.Join(truncateRequest.Tables, ", ")
 		msg := fmt.Sprintf("truncated tables on %q: %v", storage.Scheme(rt), tables)
 
@@ -503,6 +2276,10 @@ func Truncate(ctx context.Context, cfg *Config) error {
 			Msg:      msg,
 		}
 		cfg.Logger.Infof(logInfo.String())
+
+		if err := aud.truncated(ctx, plan.repo, plan.tables); err != nil {
+			return err
+		}
 	}
 
 	logInfo := tools.LogFormatter{
@@ -520,11 +2297,37 @@ func Truncate(ctx context.Context, cfg *Config) error {
 // repository, a transaction will be created and used to upsert data. The transaction will be committed at the end
 // of the upsert operation. If the transaction fails, the transaction will be rolled back. Note that it is possible
 // for some repository transactions to succeed and others to fail.
+//
+// If any timeseries request has a live tail, Upsert will then poll those requests for new data until the context is
+// done.
 func Upsert(ctx context.Context, cfg *Config) error {
+	if err := cfg.validateWASMRuntime(); err != nil {
+		return err
+	}
+
 	start := time.Now()
-	threads := runtime.NumCPU()
+	runID := cfg.runID()
+
+	defer cfg.logRunID(runID)()
+
+	stopAdmin, err := cfg.serveAdmin()
+	if err != nil {
+		return err
+	}
+
+	defer stopAdmin()
+
+	if err := cfg.waitStartJitter(ctx); err != nil {
+		return err
+	}
+
+	if err := truncate(ctx, cfg, runID); err != nil {
+		return err
+	}
 
-	if err := Truncate(ctx, cfg); err != nil {
+	// The chunks and in-flight requests are planned before the requests are flattened into chunks.
+	stats, err := cfg.Planner.plan(cfg)
+	if err != nil {
 		return err
 	}
 
@@ -533,39 +2336,227 @@ func Upsert(ctx context.Context, cfg *Config) error {
 		return err
 	}
 
-	repoConfig, err := newRepoConfig(ctx, cfg, len(flattenedRequests))
+	stats.attach(flattenedRequests)
+
+	ranges, err := cfg.truncateRanges()
+	if err != nil {
+		return err
+	}
+
+	err = upsertFlattenedRequests(ctx, cfg, flattenedRequests, runID, cfg.Anomaly, ranges...)
+
+	// The statistics of failed runs are recorded too, since their error rates are what the next run must avoid.
+	if recordErr := cfg.Planner.record(stats); recordErr != nil {
+		cfg.Logger.Warn(tools.LogFormatter{Msg: recordErr.Error()}.String())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	logInfo := tools.LogFormatter{Duration: time.Since(start), Msg: "upsert completed"}
+	cfg.Logger.Info(logInfo.String())
+
+	return cfg.tail(ctx)
+}
+
+// upsertFlattenedRequests will fetch the data for each flattened request and upsert it into the configured
+// repositories. Chunks that fail within the error budget are recorded on the configuration's "FailedChunks" and, if a
+// failed chunks file is configured, persisted for a later retry. The records within the truncate ranges are deleted
+// in the same transactions, before any data is upserted. If anomaly detection is given, the counts of the run are
+// checked against the history of previous runs before the transactions are committed.
+func upsertFlattenedRequests(ctx context.Context, cfg *Config, flattenedRequests []*flattenedRequest, runID string,
+	anomaly *AnomalyConfig, ranges ...*storage.TruncateRangeRequest,
+) error {
+	threads := runtime.NumCPU()
+
+	defer cfg.logRunID(runID)()
+
+	stopProfile, err := cfg.Profile.start(cfg.Logger, runID)
+	if err != nil {
+		return err
+	}
+
+	defer stopProfile()
+
+	cfg.correlate(flattenedRequests, runID)
+
+	repoConfig, err := newRepoConfig(ctx, cfg, len(flattenedRequests), runID)
 	if err != nil {
 		return err
 	}
 
 	defer repoConfig.closeRepos()
 
+	// The journals are closed once the transactions are committed or rolled back, before the repositories are closed.
+	defer closeJournals(cfg.Logger, repoConfig.journals)
+
+	repoConfig.progress = newProgress(cfg, flattenedRequests)
+	repoConfig.progress.run()
+
+	repoConfig.counts = anomaly.newRunCounts(flattenedRequests)
+
+	defer repoConfig.progress.finish()
+
+	// The context is canceled if the error budget is exceeded, so that the remaining web jobs are aborted.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	runBudget := newErrorBudget(cfg.ErrorBudget, len(flattenedRequests))
+	cache := newFetchCache(flattenedRequests)
+	cfg.FailedChunks = nil
+
+	if repoConfig.archiver, err = cfg.newArchiver(runID); err != nil {
+		return err
+	}
+
+	// Delete the ranges that are re-ingested before the repository workers start putting upserts on the transactions.
+	for _, repo := range repoConfig.repos {
+		for _, req := range ranges {
+			repo.Transact(truncateRangeTxFn(cfg, repoConfig.auditor, req))
+		}
+	}
+
+	// The transactions are committed by their flush policies while the workers put upserts on them.
+	repoConfig.flushers = cfg.newFlushers(repoConfig.repos)
+	startFlushers(ctx, repoConfig.flushers)
+
+	// The workers are started at their maximum count, and the autoscaler limits how many of them are active.
+	fetchers, writers := cfg.Autoscale.workers(threads)
+	repoConfig.scaler = cfg.Autoscale.newAutoscaler(cfg.Logger, threads)
+	repoConfig.scaler.instrument(flattenedRequests)
+	repoConfig.scaler.run(ctx)
+
 	// Start the repository workers.
-	for id := 1; id <= threads; id++ {
+	for id := 1; id <= writers; id++ {
 		go repositoryWorker(ctx, id, repoConfig)
 	}
 
 	cfg.Logger.Info(tools.LogFormatter{Msg: "repository workers started"}.String())
 
-	webWorkerJobs := make(chan *webJob, len(cfg.Requests))
+	// The web workers take their jobs from a priority queue, so that higher priority requests are fetched first.
+	queue := newJobQueue()
+	webWorkerJobs := make(chan *webJob)
+
+	// The decode workers process the responses that the web workers fetch, if they are decoded by a separate pool.
+	repoConfig.decodeJobs = cfg.Decode.startDecoders(ctx, threads)
 
-	// Start the same number of web workers as the cores on the machine.
-	for id := 1; id <= threads; id++ {
-		go webWorker(ctx, id, webWorkerJobs)
+	// Start the same number of web workers as the cores on the machine, unless they are autoscaled.
+	var fetching sync.WaitGroup
+
+	for id := 1; id <= fetchers; id++ {
+		fetching.Add(1)
+
+		go func(id int) {
+			defer fetching.Done()
+
+			webWorker(ctx, id, webWorkerJobs)
+		}(id)
+	}
+
+	// The decode workers stop once the web workers have queued every fetched response.
+	if repoConfig.decodeJobs != nil {
+		go func() {
+			fetching.Wait()
+			close(repoConfig.decodeJobs)
+		}()
 	}
 
 	cfg.Logger.Info(tools.LogFormatter{Msg: "web workers started"}.String())
 
-	// Enqueue the worker jobs
+	// Enqueue the worker jobs. Every job is queued before the workers are fed, so the jobs of the run are fetched in
+	// priority order.
 	for _, req := range flattenedRequests {
-		webWorkerJobs <- newWebJob(cfg, req, repoConfig.jobs)
+		queue.push(newWebJob(cfg, req, repoConfig, runBudget, cache))
 	}
 
+	queue.close()
+
+	go queue.feed(webWorkerJobs)
+
 	cfg.Logger.Info(tools.LogFormatter{Msg: "web worker jobs enqueued"}.String())
 
-	// Wait for all of the data to flush.
+	// Wait for all of the data to flush. Failures within the error budget are recorded as failed chunks, the first
+	// failure to exceed the budget will abort the operation.
+	var jobErr error
+
 	for a := 1; a <= len(flattenedRequests); a++ {
-		<-repoConfig.done
+		err := <-repoConfig.done
+		if err == nil || jobErr != nil {
+			continue
+		}
+
+		var skipped *skippedChunkError
+		if !errors.As(err, &skipped) {
+			jobErr = err
+
+			cancel()
+
+			continue
+		}
+
+		cfg.FailedChunks = append(cfg.FailedChunks, newFailedChunk(skipped.terr))
+
+		logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped failed chunk within error budget: %v", skipped.terr)}
+		cfg.Logger.Warn(logWarn.String())
+	}
+
+	// Every upsert has been put on the transactions, the rest of them is committed or rolled back with the run.
+	stopFlushers(repoConfig.flushers)
+
+	// The responses of failed runs are indexed too, so that they can be audited.
+	if err := repoConfig.archiver.writeIndex(ctx, cfg.Logger); err != nil {
+		if jobErr != nil {
+			logErr := tools.LogFormatter{Msg: err.Error()}
+			cfg.Logger.Error(logErr.String())
+		} else {
+			jobErr = err
+		}
+	}
+
+	if jobErr == nil {
+		jobErr = anomaly.check(cfg.Logger, repoConfig.counts)
+	}
+
+	// If any of the jobs failed, or an anomaly failed the run, rollback the transactions.
+	if jobErr != nil {
+		for _, repo := range repoConfig.repos {
+			if err := repo.Rollback(); err != nil {
+				logErr := tools.LogFormatter{Msg: fmt.Sprintf("unable to rollback transaction: %v", err)}
+				cfg.Logger.Error(logErr.String())
+			}
+		}
+
+		return jobErr
+	}
+
+	// Report the reconciliations once every upsert has been counted.
+	if repoConfig.reconciler != nil {
+		for _, repo := range repoConfig.repos {
+			repo.Transact(reconcileTxFn(cfg, repoConfig.reconciler))
+		}
+	}
+
+	// Check the assertions against the loaded data, the transactions are rolled back if any of them do not hold.
+	if len(cfg.Assertions) > 0 {
+		for _, repo := range repoConfig.repos {
+			repo.Transact(assertTxFn(cfg))
+		}
+	}
+
+	// Notify the tables that received data, the notifications are delivered when the transactions are committed.
+	if cfg.Notify != nil {
+		tables := repoConfig.tables.list()
+		for _, repo := range repoConfig.repos {
+			repo.Transact(notifyTxFn(cfg, runID, tables))
+		}
+	}
+
+	// Record the write operations in the audit tables, so that they are committed with the data.
+	if repoConfig.auditor != nil {
+		for _, repo := range repoConfig.repos {
+			repo.Transact(auditTxFn(repoConfig.auditor))
+		}
 	}
 
 	// Commit the transactions and check for errors.
@@ -575,8 +2566,29 @@ func Upsert(ctx context.Context, cfg *Config) error {
 		}
 	}
 
-	logInfo := tools.LogFormatter{Duration: time.Since(start), Msg: "upsert completed"}
-	cfg.Logger.Info(logInfo.String())
+	cfg.Reconciliations = repoConfig.reconciler.list("")
+
+	if err := repoConfig.auditor.writeFile(repoConfig.auditor.list("")); err != nil {
+		return err
+	}
+
+	if err := cfg.writeFailedChunks(); err != nil {
+		return err
+	}
+
+	if err := anomaly.record(repoConfig.counts); err != nil {
+		return err
+	}
+
+	// Re-read the sampled records now that they are committed, outside of the transactions.
+	if cfg.Verifications, err = repoConfig.verifier.verify(ctx, cfg, repoConfig.repos); err != nil {
+		return err
+	}
+
+	// Announce the committed data, the data is not rolled back if an event fails to publish.
+	if repoConfig.events != nil {
+		return publishEvents(ctx, cfg, repoConfig.events.list(runID))
+	}
 
 	return nil
 }
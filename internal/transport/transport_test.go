@@ -9,10 +9,15 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -242,3 +247,111 @@ func TestUpsert(t *testing.T) {
 		})
 	}
 }
+
+// countingTransport is an http.RoundTripper that counts the requests made with it.
+type countingTransport struct{ count int32 }
+
+func (ct *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&ct.count, 1)
+
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Api-Key") != "key" {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		fmt.Fprint(writer, `[{"id": "a"}]`)
+	}))
+	defer testServer.Close()
+
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+authentication:
+  headers:
+    X-Api-Key: key
+rateLimit:
+  burst: 1
+  period: 1ms
+requests:
+  - endpoint: /ticks
+`, testServer.URL)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	transport := new(countingTransport)
+	cfg.HTTPClient = &http.Client{Transport: transport, Timeout: time.Second}
+
+	samples, err := SampleRequests(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("error sampling requests: %v", err)
+	}
+
+	if len(samples) != 1 || len(samples[0].Records) != 1 {
+		t.Fatalf("expected one sampled record, got %v", samples)
+	}
+
+	if transport.count != 1 {
+		t.Fatalf("expected the authenticated request to use the custom transport, got %d requests", transport.count)
+	}
+}
+
+func TestDeleteField(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /orders
+    table: orders
+    deleteField: deleted
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	job := &repoJob{
+		b:              []byte(`[{"id":1,"deleted":true}]`),
+		table:          "orders",
+		storageOptions: cfg.Requests[0].storageOptions(),
+	}
+
+	reqs, err := job.upsertRequests("postgresql")
+	if err != nil {
+		t.Fatalf("error getting upsert requests: %v", err)
+	}
+
+	if len(reqs) != 1 || reqs[0].DeleteField != "deleted" {
+		t.Fatalf("expected the delete field on the upsert request, got %v", reqs)
+	}
+
+	for _, tcase := range []struct {
+		dns string
+		err error
+	}{
+		{dns: "postgresql://localhost:5432/db"},
+		{dns: "mongodb://localhost:27017/db", err: ErrDeleteFieldNotSupported},
+		{dns: "nats://localhost:4222", err: ErrDeleteFieldNotSupported},
+	} {
+		_, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+connectionStrings: [` + tcase.dns + `]
+requests:
+  - endpoint: /orders
+    table: orders
+    deleteField: deleted
+`))
+		if !errors.Is(err, tcase.err) {
+			t.Fatalf("expected error %v for %q, got %v", tcase.err, tcase.dns, err)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+)
+
+var (
+	ErrInvalidTablePattern  = fmt.Errorf("invalid table pattern")
+	ErrProtectedTable       = fmt.Errorf("refusing to truncate protected table")
+	ErrTruncateNotConfirmed = fmt.Errorf("truncate was not confirmed")
+)
+
+// InvalidTablePatternError wraps an error with ErrInvalidTablePattern.
+func InvalidTablePatternError(pattern string, err error) error {
+	return fmt.Errorf("%w %q: %v", ErrInvalidTablePattern, pattern, err)
+}
+
+// ProtectedTableError wraps an error with ErrProtectedTable.
+func ProtectedTableError(table string) error {
+	return fmt.Errorf("%w: %q", ErrProtectedTable, table)
+}
+
+// TruncatePolicy selects the tables to truncate and guards against truncating tables by mistake. Table patterns are
+// either shell globs, e.g. "candles_*", or regular expressions enclosed in slashes, e.g. "/^trades_[0-9]+$/".
+type TruncatePolicy struct {
+	// Tables are patterns matched against the tables in storage to select the tables to truncate. By default, the
+	// tables of the configured requests are truncated.
+	Tables []string `yaml:"tables"`
+
+	// Allow are patterns of the tables that can be truncated. If set, selected tables that do not match are skipped.
+	Allow []string `yaml:"allow"`
+
+	// Deny are patterns of the tables that are never truncated. Selected tables that match are skipped.
+	Deny []string `yaml:"deny"`
+
+	// ProtectedTables are patterns of tables that must never be truncated. Unlike "Deny", the operation fails if a
+	// protected table is selected, since that is likely a misconfiguration.
+	ProtectedTables []string `yaml:"protectedTables"`
+
+	// RequireConfirmation requires each truncate to be confirmed by the configuration's "ConfirmTruncate" function
+	// before any table is truncated.
+	RequireConfirmation bool `yaml:"requireConfirmation"`
+}
+
+// tableMatcher matches table names against a set of patterns.
+type tableMatcher struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+// newTableMatcher will compile the table patterns into a table matcher.
+func newTableMatcher(patterns []string) (*tableMatcher, error) {
+	matcher := new(tableMatcher)
+
+	for _, pattern := range patterns {
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, InvalidTablePatternError(pattern, err)
+			}
+
+			matcher.regexps = append(matcher.regexps, re)
+
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, InvalidTablePatternError(pattern, err)
+		}
+
+		matcher.globs = append(matcher.globs, pattern)
+	}
+
+	return matcher, nil
+}
+
+// empty will return true if the matcher has no patterns.
+func (tm *tableMatcher) empty() bool {
+	return len(tm.globs) == 0 && len(tm.regexps) == 0
+}
+
+// match will return true if the table matches any of the patterns.
+func (tm *tableMatcher) match(table string) bool {
+	for _, glob := range tm.globs {
+		if ok, _ := path.Match(glob, table); ok {
+			return true
+		}
+	}
+
+	for _, re := range tm.regexps {
+		if re.MatchString(table) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncateMatchers are the compiled patterns of a truncate policy.
+type truncateMatchers struct {
+	tables, allow, deny, protected *tableMatcher
+}
+
+func (tp *TruncatePolicy) matchers() (*truncateMatchers, error) {
+	var (
+		matchers truncateMatchers
+		err      error
+	)
+
+	if tp == nil {
+		tp = new(TruncatePolicy)
+	}
+
+	for _, target := range []struct {
+		matcher  **tableMatcher
+		patterns []string
+	}{
+		{&matchers.tables, tp.Tables},
+		{&matchers.allow, tp.Allow},
+		{&matchers.deny, tp.Deny},
+		{&matchers.protected, tp.ProtectedTables},
+	} {
+		if *target.matcher, err = newTableMatcher(target.patterns); err != nil {
+			return nil, err
+		}
+	}
+
+	return &matchers, nil
+}
+
+func (tp *TruncatePolicy) validate() error {
+	_, err := tp.matchers()
+
+	return err
+}
+
+// truncatePlan is the tables to truncate in a database of a repository.
+type truncatePlan struct {
+	repo     repository.Generic
+	database string
+	tables   []string
+}
+
+// splitTruncatePlan will split the tables to truncate on a repository by the databases of their requests. Tables
+// without a request, e.g. the tables matched by the truncate policy, are truncated in the configured database.
+func (cfg *Config) splitTruncatePlan(repo repository.Generic, tables []string) []*truncatePlan {
+	if len(tables) == 0 {
+		return []*truncatePlan{{repo: repo}}
+	}
+
+	databases := make(map[string]map[string]bool)
+
+	for _, req := range cfg.Requests {
+		if req.truncatesRange() {
+			continue
+		}
+
+		if databases[req.Table] == nil {
+			databases[req.Table] = make(map[string]bool)
+		}
+
+		databases[req.Table][req.Database] = true
+	}
+
+	byDatabase := make(map[string][]string)
+
+	for _, table := range tables {
+		if len(databases[table]) == 0 {
+			byDatabase[""] = append(byDatabase[""], table)
+		}
+
+		for database := range databases[table] {
+			byDatabase[database] = append(byDatabase[database], table)
+		}
+	}
+
+	names := make([]string, 0, len(byDatabase))
+	for database := range byDatabase {
+		names = append(names, database)
+	}
+
+	sort.Strings(names)
+
+	plans := make([]*truncatePlan, 0, len(names))
+	for _, database := range names {
+		plans = append(plans, &truncatePlan{repo: repo, database: database, tables: byDatabase[database]})
+	}
+
+	return plans
+}
+
+// selectTruncateTables will select the tables to truncate on a repository according to the truncate policy.
+func (cfg *Config) selectTruncateTables(ctx context.Context, repo repository.Generic) ([]string, error) {
+	matchers, err := cfg.TruncatePolicy.matchers()
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []string
+
+	if matchers.tables.empty() {
+		for _, req := range cfg.Requests {
+			// Tables of ranged requests only have their range truncated before the upsert.
+			if !req.truncatesRange() {
+				candidates = append(candidates, req.Table)
+			}
+		}
+	} else {
+		rsp, err := repo.ListTables(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to list tables: %w", err)
+		}
+
+		for table := range rsp.GetTableSet() {
+			if matchers.tables.match(table) {
+				candidates = append(candidates, table)
+			}
+		}
+	}
+
+	sort.Strings(candidates)
+
+	tables := make([]string, 0, len(candidates))
+
+	for idx, table := range candidates {
+		if idx > 0 && candidates[idx-1] == table {
+			continue
+		}
+
+		allowed, err := cfg.allowTruncate(matchers, table)
+		if err != nil {
+			return nil, err
+		}
+
+		if allowed {
+			tables = append(tables, table)
+		}
+	}
+
+	return tables, nil
+}
+
+// allowTruncate will return true if the truncate policy allows truncating the table, and an error if the table is
+// protected.
+func (cfg *Config) allowTruncate(matchers *truncateMatchers, table string) (bool, error) {
+	if matchers.protected.match(table) {
+		return false, ProtectedTableError(table)
+	}
+
+	if (!matchers.allow.empty() && !matchers.allow.match(table)) || matchers.deny.match(table) {
+		logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipping truncate of table not allowed by policy: %q", table)}
+		cfg.Logger.Warn(logWarn.String())
+
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// truncatesRange will return true if the records of the request's timeseries range are deleted before the upsert.
+func (req *Request) truncatesRange() bool {
+	return req.Timeseries != nil && req.Timeseries.TruncateColumn != ""
+}
+
+// truncateRanges will return the time windows to delete from the tables of ranged requests, so that re-running a
+// window does not leave stale records or destroy the rest of the table. The requests must have been flattened.
+func (cfg *Config) truncateRanges() ([]*storage.TruncateRangeRequest, error) {
+	matchers, err := cfg.TruncatePolicy.matchers()
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []*storage.TruncateRangeRequest
+
+	for _, req := range cfg.Requests {
+		if !req.truncatesRange() || len(req.Timeseries.chunks) == 0 {
+			continue
+		}
+
+		allowed, err := cfg.allowTruncate(matchers, req.Table)
+		if err != nil {
+			return nil, err
+		}
+
+		if !allowed {
+			continue
+		}
+
+		chunks := req.Timeseries.chunks
+		window := &storage.TruncateRangeRequest{
+			Table:    req.Table,
+			Column:   req.Timeseries.TruncateColumn,
+			Start:    chunks[0][0],
+			End:      chunks[len(chunks)-1][1],
+			Database: req.Database,
+		}
+
+		// Expanded requests that share a table, e.g. one per symbol, delete each window once.
+		if !containsRange(ranges, window) {
+			ranges = append(ranges, window)
+		}
+	}
+
+	return ranges, nil
+}
+
+// containsRange will return true if the ranges contain the window of the same table and column.
+func containsRange(ranges []*storage.TruncateRangeRequest, window *storage.TruncateRangeRequest) bool {
+	for _, rng := range ranges {
+		if rng.Table == window.Table && rng.Column == window.Column && rng.Database == window.Database &&
+			rng.Start.Equal(window.Start) && rng.End.Equal(window.End) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncateRangeTxFn will return a transaction function that deletes the records of a table within a time window,
+// recording the delete in the audit log.
+func truncateRangeTxFn(cfg *Config, aud *auditor,
+	req *storage.TruncateRangeRequest,
+) func(context.Context, repository.Generic) error {
+	return func(sctx context.Context, repo repository.Generic) error {
+		start := time.Now()
+
+		rsp, err := repo.TruncateRange(sctx, req)
+		if err != nil {
+			return fmt.Errorf("unable to truncate range of table %q: %w", req.Table, err)
+		}
+
+		msg := fmt.Sprintf("truncated range of table on %q: %s [%s, %s)", storage.Scheme(repo.Type()), req.Table,
+			req.Start.Format(time.RFC3339), req.End.Format(time.RFC3339))
+
+		logInfo := tools.LogFormatter{Duration: time.Since(start), Msg: msg}
+		cfg.Logger.Info(logInfo.String())
+
+		aud.truncatedRange(storage.Scheme(repo.Type()), req, rsp)
+
+		return nil
+	}
+}
+
+// planTruncate will select the tables to truncate on every repository, and confirm the truncate if the policy requires
+// it. Nothing is truncated unless every repository's plan is valid and confirmed.
+func (cfg *Config) planTruncate(ctx context.Context, repos []repository.Generic) ([]*truncatePlan, error) {
+	plans := make([]*truncatePlan, 0, len(repos))
+
+	for _, repo := range repos {
+		tables, err := cfg.selectTruncateTables(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		plans = append(plans, cfg.splitTruncatePlan(repo, tables)...)
+	}
+
+	if cfg.TruncatePolicy == nil || !cfg.TruncatePolicy.RequireConfirmation {
+		return plans, nil
+	}
+
+	for _, plan := range plans {
+		if len(plan.tables) == 0 {
+			continue
+		}
+
+		scheme := storage.Scheme(plan.repo.Type())
+		if cfg.ConfirmTruncate == nil || !cfg.ConfirmTruncate(scheme, plan.tables) {
+			return nil, fmt.Errorf("%w on %q: %s", ErrTruncateNotConfirmed, scheme, strings.Join(plan.tables, ", "))
+		}
+	}
+
+	return plans, nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/sirupsen/logrus"
+)
+
+// tablesRepository is a repository that only lists a fixed set of tables.
+type tablesRepository struct {
+	repository.Generic
+	tables []string
+}
+
+func (repo *tablesRepository) ListTables(context.Context) (*proto.ListTablesResponse, error) {
+	rsp := &proto.ListTablesResponse{TableSet: make(map[string]*proto.Table)}
+	for _, table := range repo.tables {
+		rsp.TableSet[table] = new(proto.Table)
+	}
+
+	return rsp, nil
+}
+
+func (repo *tablesRepository) Type() uint8 { return storage.PostgresType }
+
+func TestPlanTruncate(t *testing.T) {
+	t.Parallel()
+
+	storageTables := []string{"candles_1m", "candles_1h", "trades_1", "trades_x", "accounts"}
+
+	for _, tcase := range []struct {
+		name      string
+		policy    *TruncatePolicy
+		confirm   func(string, []string) bool
+		requests  []string
+		expected  []string
+		expectErr error
+	}{
+		{
+			name:     "defaults to the request tables",
+			requests: []string{"candles_1m", "candles_1m", "trades_1"},
+			expected: []string{"candles_1m", "trades_1"},
+		},
+		{
+			name:     "glob and regex selection",
+			policy:   &TruncatePolicy{Tables: []string{"candles_*", "/^trades_[0-9]+$/"}},
+			expected: []string{"candles_1h", "candles_1m", "trades_1"},
+		},
+		{
+			name:     "allow and deny",
+			policy:   &TruncatePolicy{Tables: []string{"*"}, Allow: []string{"candles_*", "trades_*"}, Deny: []string{"*_1h"}},
+			expected: []string{"candles_1m", "trades_1", "trades_x"},
+		},
+		{
+			name:      "protected tables abort",
+			policy:    &TruncatePolicy{Tables: []string{"*"}, ProtectedTables: []string{"accounts"}},
+			expectErr: ErrProtectedTable,
+		},
+		{
+			name:      "confirmation required without a confirm function",
+			policy:    &TruncatePolicy{RequireConfirmation: true},
+			requests:  []string{"candles_1m"},
+			expectErr: ErrTruncateNotConfirmed,
+		},
+		{
+			name:      "confirmation declined",
+			policy:    &TruncatePolicy{RequireConfirmation: true},
+			confirm:   func(string, []string) bool { return false },
+			requests:  []string{"candles_1m"},
+			expectErr: ErrTruncateNotConfirmed,
+		},
+		{
+			name:     "confirmation accepted",
+			policy:   &TruncatePolicy{RequireConfirmation: true},
+			confirm:  func(scheme string, _ []string) bool { return scheme == "postgresql" },
+			requests: []string{"candles_1m"},
+			expected: []string{"candles_1m"},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := &Config{TruncatePolicy: tcase.policy, ConfirmTruncate: tcase.confirm, Logger: logrus.New()}
+			for _, table := range tcase.requests {
+				cfg.Requests = append(cfg.Requests, &Request{Table: table})
+			}
+
+			repos := []repository.Generic{&tablesRepository{tables: storageTables}}
+
+			plans, err := cfg.planTruncate(context.Background(), repos)
+			if tcase.expectErr != nil {
+				if !errors.Is(err, tcase.expectErr) {
+					t.Fatalf("expected error %v, got %v", tcase.expectErr, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("error planning truncate: %v", err)
+			}
+
+			if !reflect.DeepEqual(plans[0].tables, tcase.expected) {
+				t.Fatalf("expected tables %v, got %v", tcase.expected, plans[0].tables)
+			}
+		})
+	}
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		t.Parallel()
+
+		policy := &TruncatePolicy{Tables: []string{"/[/"}}
+		if err := policy.validate(); !errors.Is(err, ErrInvalidTablePattern) {
+			t.Fatalf("expected ErrInvalidTablePattern, got %v", err)
+		}
+	})
+}
+
+func TestTruncateRanges(t *testing.T) {
+	t.Parallel()
+
+	rurl, _ := url.Parse("https://api.test/candles?start=2022-05-10T00:00:00Z&end=2022-05-10T03:00:00Z")
+	start := time.Date(2022, 5, 10, 0, 0, 0, 0, time.UTC)
+
+	for _, tcase := range []struct {
+		name      string
+		policy    *TruncatePolicy
+		column    string
+		expected  []*storage.TruncateRangeRequest
+		expectErr error
+	}{
+		{
+			name:   "window of every chunk",
+			column: "time",
+			expected: []*storage.TruncateRangeRequest{
+				{Table: "candles", Column: "time", Start: start, End: start.Add(3 * time.Hour)},
+			},
+		},
+		{
+			name: "no truncate column",
+		},
+		{
+			name:   "denied by policy",
+			policy: &TruncatePolicy{Deny: []string{"candles"}},
+			column: "time",
+		},
+		{
+			name:      "protected table",
+			policy:    &TruncatePolicy{ProtectedTables: []string{"candles"}},
+			column:    "time",
+			expectErr: ErrProtectedTable,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			series := &timeseries{StartName: "start", EndName: "end", Period: 3600, TruncateColumn: tcase.column}
+			if err := series.chunk(*rurl); err != nil {
+				t.Fatalf("error chunking timeseries: %v", err)
+			}
+
+			req := &Request{Table: "candles", Timeseries: series}
+			cfg := &Config{TruncatePolicy: tcase.policy, Requests: []*Request{req}, Logger: logrus.New()}
+
+			ranges, err := cfg.truncateRanges()
+			if !errors.Is(err, tcase.expectErr) {
+				t.Fatalf("expected error %v, got %v", tcase.expectErr, err)
+			}
+
+			if !reflect.DeepEqual(ranges, tcase.expected) {
+				t.Fatalf("expected ranges %v, got %v", tcase.expected, ranges)
+			}
+
+			// Tables that are truncated by range are not truncated in full.
+			if tcase.column != "" {
+				tables, _ := cfg.selectTruncateTables(context.Background(), &tablesRepository{})
+				if len(tables) != 0 {
+					t.Fatalf("expected no full truncate, got %v", tables)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitTruncatePlan(t *testing.T) {
+	t.Parallel()
+
+	cfg := &Config{Requests: []*Request{
+		{Table: "candles"},
+		{Table: "candles", Database: "archive"},
+		{Table: "trades", Database: "archive"},
+	}}
+
+	plans := cfg.splitTruncatePlan(&tablesRepository{}, []string{"candles", "orders", "trades"})
+
+	got := make(map[string][]string, len(plans))
+	for _, plan := range plans {
+		got[plan.database] = plan.tables
+	}
+
+	expected := map[string][]string{"": {"candles", "orders"}, "archive": {"candles", "trades"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected tables by database %v, got %v", expected, got)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigVersion is the version of the configuration format. Configurations without a version are version 1.
+const ConfigVersion = 2
+
+var ErrUnsupportedConfigVersion = fmt.Errorf("unsupported config version")
+
+// UnsupportedConfigVersionError is returned when a configuration is newer than this version of gidari, or its version
+// is not a positive integer.
+func UnsupportedConfigVersionError(version interface{}) error {
+	return fmt.Errorf("%w %v: the latest version is %d", ErrUnsupportedConfigVersion, version, ConfigVersion)
+}
+
+// configMigration will upgrade a configuration, decoded as YAML, from the version before the migration's version.
+type configMigration func(cfg yaml.MapSlice) yaml.MapSlice
+
+// configMigrations are the migrations to each version, from version 2.
+var configMigrations = []configMigration{
+	// Version 2 renames the "rate_limit" of requests and templates to "rateLimit", like that of the configuration.
+	func(cfg yaml.MapSlice) yaml.MapSlice {
+		for _, req := range configRequests(cfg) {
+			renameConfigKey(req, "rate_limit", "rateLimit")
+		}
+
+		return cfg
+	},
+}
+
+// configKeyIndex will return the index of a key of a YAML mapping, or -1.
+func configKeyIndex(cfg yaml.MapSlice, key string) int {
+	for idx, item := range cfg {
+		if item.Key == key {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// configValue will return the value of a key of a YAML mapping, or nil.
+func configValue(cfg yaml.MapSlice, key string) interface{} {
+	if idx := configKeyIndex(cfg, key); idx >= 0 {
+		return cfg[idx].Value
+	}
+
+	return nil
+}
+
+// renameConfigKey will rename a key of a YAML mapping, unless the mapping already has the new key.
+func renameConfigKey(cfg yaml.MapSlice, from, to string) {
+	if configKeyIndex(cfg, to) >= 0 {
+		return
+	}
+
+	if idx := configKeyIndex(cfg, from); idx >= 0 {
+		cfg[idx].Key = to
+	}
+}
+
+// configRequests will return the requests and request templates of a configuration decoded as YAML.
+func configRequests(cfg yaml.MapSlice) []yaml.MapSlice {
+	var requests []yaml.MapSlice
+
+	if list, ok := configValue(cfg, "requests").([]interface{}); ok {
+		for _, item := range list {
+			if req, ok := item.(yaml.MapSlice); ok {
+				requests = append(requests, req)
+			}
+		}
+	}
+
+	if templates, ok := configValue(cfg, "templates").(yaml.MapSlice); ok {
+		for _, item := range templates {
+			if tmpl, ok := item.Value.(yaml.MapSlice); ok {
+				requests = append(requests, tmpl)
+			}
+		}
+	}
+
+	return requests
+}
+
+// configVersion will return the version of a configuration decoded as YAML.
+func configVersion(cfg yaml.MapSlice) (int, error) {
+	value := configValue(cfg, "version")
+	if value == nil {
+		return 1, nil
+	}
+
+	version, ok := value.(int)
+	if !ok || version < 1 || version > ConfigVersion {
+		return 0, UnsupportedConfigVersionError(value)
+	}
+
+	return version, nil
+}
+
+// UpgradeConfig will upgrade a YAML configuration to the latest version of the configuration format, returning the
+// upgraded configuration and the version that it was upgraded from. The order of the keys is kept, but comments are
+// not. Configurations that are already the latest version are returned as they are.
+func UpgradeConfig(yamlBytes []byte) ([]byte, int, error) {
+	var cfg yaml.MapSlice
+	if err := yaml.Unmarshal(yamlBytes, &cfg); err != nil {
+		return nil, 0, fmt.Errorf("unable to unmarshal YAML: %w", err)
+	}
+
+	version, err := configVersion(cfg)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if version == ConfigVersion {
+		return yamlBytes, version, nil
+	}
+
+	for _, migrate := range configMigrations[version-1:] {
+		cfg = migrate(cfg)
+	}
+
+	versionItem := yaml.MapItem{Key: "version", Value: ConfigVersion}
+	if idx := configKeyIndex(cfg, "version"); idx >= 0 {
+		cfg[idx] = versionItem
+	} else {
+		cfg = append(yaml.MapSlice{versionItem}, cfg...)
+	}
+
+	upgraded, err := yaml.Marshal(cfg)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unable to marshal YAML: %w", err)
+	}
+
+	return upgraded, version, nil
+}
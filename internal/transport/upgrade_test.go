@@ -0,0 +1,112 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpgradeConfig(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		yml      string
+		expected string
+		version  int
+		err      error
+	}{
+		{
+			name: "version 1",
+			yml: `url: https://api.example.com
+templates:
+  base: {rate_limit: {burst: 2, period: 1s}}
+requests:
+  - endpoint: /trades
+    rate_limit: {burst: 1, period: 1s}
+`,
+			expected: `version: 2
+url: https://api.example.com
+templates:
+  base:
+    rateLimit:
+      burst: 2
+      period: 1s
+requests:
+- endpoint: /trades
+  rateLimit:
+    burst: 1
+    period: 1s
+`,
+			version: 1,
+		},
+		{
+			name:     "latest version",
+			yml:      "version: 2\nurl: https://api.example.com # unchanged\n",
+			expected: "version: 2\nurl: https://api.example.com # unchanged\n",
+			version:  ConfigVersion,
+		},
+		{
+			name: "newer version",
+			yml:  "version: 3\n",
+			err:  ErrUnsupportedConfigVersion,
+		},
+		{
+			name: "invalid version",
+			yml:  "version: two\n",
+			err:  ErrUnsupportedConfigVersion,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			upgraded, version, err := UpgradeConfig([]byte(tcase.yml))
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err != nil {
+				return
+			}
+
+			if version != tcase.version {
+				t.Fatalf("expected version %d, got %d", tcase.version, version)
+			}
+
+			if string(upgraded) != tcase.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tcase.expected, upgraded)
+			}
+		})
+	}
+}
+
+func TestNewConfigUpgrades(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := NewConfig([]byte(`
+url: https://api.example.com
+rateLimit: {burst: 1, period: 1s}
+requests:
+  - endpoint: /trades
+    rate_limit: {burst: 3, period: 1s}
+`))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if cfg.Version != ConfigVersion {
+		t.Fatalf("expected version %d, got %d", ConfigVersion, cfg.Version)
+	}
+
+	if burst := cfg.Requests[0].RateLimitConfig.Burst; burst == nil || *burst != 3 {
+		t.Fatalf("expected the rate limit of the request to be upgraded, got %+v", cfg.Requests[0].RateLimitConfig)
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// defaultVerifyRecords is the number of upserted records that are sampled per storage device and table, if the
+// verification does not set it.
+const defaultVerifyRecords = 100
+
+var (
+	ErrInvalidVerify      = fmt.Errorf("invalid verify configuration")
+	ErrVerificationFailed = fmt.Errorf("upserted records do not read back as written")
+)
+
+// InvalidVerifyError wraps an error with ErrInvalidVerify.
+func InvalidVerifyError(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidVerify, reason)
+}
+
+// VerifyConfig re-reads a random sample of the upserted records of each storage device and table once the data is
+// committed, and compares them with the decoded records, as an end-to-end check of the storage devices. Records that
+// are missing or whose fields differ are reported. If a key is upserted more than once in a run, the sampled record
+// is compared, so it is reported as different if a later record of the key changed it. Verification is supported by
+// Postgres tables with primary keys and MongoDB.
+type VerifyConfig struct {
+	// Records is the number of upserted records that are sampled per storage device and table. Defaults to 100.
+	Records int `yaml:"records"`
+
+	// Fail fails the operation if a sampled record is missing or differs, instead of logging a warning. The data is
+	// already committed, so it is not rolled back.
+	Fail bool `yaml:"fail"`
+}
+
+func (vc *VerifyConfig) validate() error {
+	if vc == nil {
+		return nil
+	}
+
+	if vc.Records < 0 {
+		return InvalidVerifyError("records can not be negative")
+	}
+
+	return nil
+}
+
+// Verification is the result of re-reading the sampled records of a table from a storage device. Keys are the JSON
+// encoded values of the primary keys, separated by commas.
+type Verification struct {
+	// Storage is the scheme of the storage device, e.g. "postgresql".
+	Storage string
+
+	// Table is the name of the table/collection.
+	Table string
+
+	// Sampled is the number of distinct records that were re-read.
+	Sampled int64
+
+	// Missing are the keys of the sampled records that are not stored.
+	Missing []string
+
+	// Mismatched are the keys of the sampled records whose fields differ from the stored record.
+	Mismatched []string
+}
+
+// verifySample is a uniform random sample of the upserted records of a table, along with an upsert request of the
+// table that the records are read back with.
+type verifySample struct {
+	req      *proto.UpsertRequest
+	seen     int64
+	records  []*structpb.Struct
+	capacity int
+}
+
+// add will add the records of an upsert request to the sample, replacing sampled records at random once the sample is
+// full, so that every upserted record is sampled with the same probability.
+func (sample *verifySample) add(records []*structpb.Struct) {
+	for _, record := range records {
+		sample.seen++
+
+		if len(sample.records) < sample.capacity {
+			sample.records = append(sample.records, record)
+
+			continue
+		}
+
+		//nolint:gosec // The sample only has to be uniform, it is not a secret.
+		if idx := rand.Int63n(sample.seen); idx < int64(sample.capacity) {
+			sample.records[idx] = record
+		}
+	}
+}
+
+// verifier samples the upserted records of a transport operation, per repository and table. A nil verifier is valid
+// and verifies nothing. It is safe for concurrent use.
+type verifier struct {
+	cfg *VerifyConfig
+
+	mu      sync.Mutex
+	samples map[int]map[string]*verifySample
+}
+
+// verifier will return a verifier for a transport operation, or nil if verification is not enabled.
+func (cfg *Config) verifier() *verifier {
+	if cfg.Verify == nil {
+		return nil
+	}
+
+	return &verifier{cfg: cfg.Verify, samples: make(map[int]map[string]*verifySample)}
+}
+
+// sample will sample the records of an upsert request to the repository at an index.
+func (ver *verifier) sample(repoIdx int, req *proto.UpsertRequest) error {
+	if ver == nil {
+		return nil
+	}
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return fmt.Errorf("unable to sample records: %w", err)
+	}
+
+	ver.mu.Lock()
+	defer ver.mu.Unlock()
+
+	tables, ok := ver.samples[repoIdx]
+	if !ok {
+		tables = make(map[string]*verifySample)
+		ver.samples[repoIdx] = tables
+	}
+
+	sample, ok := tables[req.Table]
+	if !ok {
+		capacity := ver.cfg.Records
+		if capacity == 0 {
+			capacity = defaultVerifyRecords
+		}
+
+		// The records are read back with the options of the upsert, e.g. its database and collation.
+		readReq, _ := protobuf.Clone(req).(*proto.UpsertRequest)
+		readReq.Data = nil
+
+		sample = &verifySample{req: readReq, capacity: capacity}
+		tables[req.Table] = sample
+	}
+
+	sample.add(records)
+
+	return nil
+}
+
+// verify will re-read the sampled records from the committed repositories and compare them with the sampled records,
+// returning the verifications by repository and table. Mismatches are logged as warnings, or returned as an error
+// wrapping ErrVerificationFailed if the verification fails the operation. Storage devices and tables that can not be
+// read are skipped.
+func (ver *verifier) verify(ctx context.Context, cfg *Config, repos []repository.Generic) ([]*Verification, error) {
+	if ver == nil {
+		return nil, nil
+	}
+
+	ver.mu.Lock()
+	defer ver.mu.Unlock()
+
+	var (
+		verifications []*Verification
+		failed        []string
+	)
+
+	for idx, repo := range repos {
+		tables := make([]string, 0, len(ver.samples[idx]))
+		for table := range ver.samples[idx] {
+			tables = append(tables, table)
+		}
+
+		sort.Strings(tables)
+
+		for _, table := range tables {
+			verification, err := ver.samples[idx][table].verify(ctx, repo)
+			if errors.Is(err, storage.ErrReadNotSupported) {
+				logWarn := tools.LogFormatter{Msg: fmt.Sprintf("skipped verification: %v", err)}
+				cfg.Logger.Warn(logWarn.String())
+
+				continue
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("unable to verify %q: %w", table, err)
+			}
+
+			verifications = append(verifications, verification)
+
+			summary := fmt.Sprintf("%s.%s: %d of %d sampled records missing, %d differ", verification.Storage,
+				verification.Table, len(verification.Missing), verification.Sampled, len(verification.Mismatched))
+
+			if len(verification.Missing) == 0 && len(verification.Mismatched) == 0 {
+				logInfo := tools.LogFormatter{Msg: fmt.Sprintf("verified %s.%s: %d sampled records read back as written",
+					verification.Storage, verification.Table, verification.Sampled)}
+				cfg.Logger.Info(logInfo.String())
+
+				continue
+			}
+
+			failed = append(failed, summary)
+
+			if !ver.cfg.Fail {
+				logWarn := tools.LogFormatter{Msg: fmt.Sprintf("%v: %s, e.g. keys %s", ErrVerificationFailed, summary,
+					strings.Join(verification.examples(), "; "))}
+				cfg.Logger.Warn(logWarn.String())
+			}
+		}
+	}
+
+	if ver.cfg.Fail && len(failed) > 0 {
+		return verifications, fmt.Errorf("%w: %s", ErrVerificationFailed, strings.Join(failed, "; "))
+	}
+
+	return verifications, nil
+}
+
+// verify will re-read the sampled records from a repository and compare them with the stored records. Sampled records
+// without every primary key of the table can not be matched with a stored record, so they are not compared.
+func (sample *verifySample) verify(ctx context.Context, repo repository.Generic) (*Verification, error) {
+	scheme := storage.Scheme(repo.Type())
+
+	hashes := make([]interface{}, len(sample.records))
+	for idx, record := range sample.records {
+		hashes[idx] = record.AsMap()
+	}
+
+	data, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", tools.ErrFailedToMarshalJSON, err)
+	}
+
+	req, _ := protobuf.Clone(sample.req).(*proto.UpsertRequest)
+	req.Data, req.DataType = data, int32(tools.UpsertDataJSON)
+
+	stored, err := repo.ReadUpserted(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	keyed := make([]*structpb.Struct, 0, len(sample.records))
+
+	for _, record := range sample.records {
+		if hasFields(record, stored.PrimaryKeys) {
+			keyed = append(keyed, record)
+		}
+	}
+
+	diff, err := diffRecords(scheme, req.Table, keyed, stored)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Verification{
+		Storage:    scheme,
+		Table:      req.Table,
+		Sampled:    int64(len(diff.New)+len(diff.Changed)) + diff.Unchanged,
+		Missing:    diff.New,
+		Mismatched: diff.Changed,
+	}, nil
+}
+
+// examples will return up to three of the keys of the missing and mismatched records, to be logged.
+func (verification *Verification) examples() []string {
+	const maxExamples = 3
+
+	keys := append(append([]string{}, verification.Missing...), verification.Mismatched...)
+	if len(keys) > maxExamples {
+		keys = keys[:maxExamples]
+	}
+
+	return keys
+}
+
+// hasFields will return true if the record has every field.
+func hasFields(record *structpb.Struct, fields []string) bool {
+	for _, field := range fields {
+		if _, ok := record.GetFields()[field]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
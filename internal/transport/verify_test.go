@@ -0,0 +1,192 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/repository"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// verifyRepository is a repository whose stored records are read back by their "id".
+type verifyRepository struct {
+	repository.Generic
+
+	stored map[float64]map[string]interface{}
+}
+
+func (repo *verifyRepository) ReadUpserted(_ context.Context, req *proto.UpsertRequest,
+) (*storage.StoredRecords, error) {
+	if repo.stored == nil {
+		return nil, fmt.Errorf("%w for %q", storage.ErrReadNotSupported, "postgresql")
+	}
+
+	records, err := tools.DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &storage.StoredRecords{PrimaryKeys: []string{"id"}}
+
+	for _, record := range records {
+		hash, ok := repo.stored[record.GetFields()["id"].GetNumberValue()]
+		if !ok {
+			continue
+		}
+
+		storedRecord, err := structpb.NewStruct(hash)
+		if err != nil {
+			return nil, err
+		}
+
+		stored.Records = append(stored.Records, storedRecord)
+	}
+
+	return stored, nil
+}
+
+func (repo *verifyRepository) Type() uint8 { return storage.PostgresType }
+
+func TestVerifySampleAdd(t *testing.T) {
+	t.Parallel()
+
+	sample := &verifySample{capacity: 10}
+
+	for idx := 0; idx < 100; idx++ {
+		record, err := structpb.NewStruct(map[string]interface{}{"id": float64(idx)})
+		if err != nil {
+			t.Fatalf("error creating record: %v", err)
+		}
+
+		sample.add([]*structpb.Struct{record})
+	}
+
+	if sample.seen != 100 || len(sample.records) != 10 {
+		t.Fatalf("expected 10 of 100 records to be sampled, got %d of %d", len(sample.records), sample.seen)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	t.Parallel()
+
+	data, err := json.Marshal([]map[string]interface{}{
+		{"id": 1, "price": "1.5"},
+		{"id": 2, "price": "1.6"},
+		{"id": 3, "price": "1.7"},
+		{"id": 4, "price": "1.8"},
+		{"id": 5},
+	})
+	if err != nil {
+		t.Fatalf("error encoding records: %v", err)
+	}
+
+	stored := map[float64]map[string]interface{}{
+		1: {"id": 1, "price": "1.5", "stamp": "run"},
+		2: {"id": 2, "price": "1.6"},
+		3: {"id": 3, "price": "0"},
+	}
+
+	for _, tcase := range []struct {
+		name     string
+		verify   *VerifyConfig
+		repo     *verifyRepository
+		expected []*Verification
+		err      error
+	}{
+		{
+			name:   "mismatches",
+			verify: &VerifyConfig{},
+			repo:   &verifyRepository{stored: stored},
+			expected: []*Verification{{
+				Storage:    "postgresql",
+				Table:      "trades",
+				Sampled:    5,
+				Missing:    []string{"4", "5"},
+				Mismatched: []string{"3"},
+			}},
+		},
+		{
+			name:   "fail",
+			verify: &VerifyConfig{Fail: true},
+			repo:   &verifyRepository{stored: stored},
+			err:    ErrVerificationFailed,
+		},
+		{
+			name:   "sample size",
+			verify: &VerifyConfig{Records: 2},
+			repo: &verifyRepository{stored: map[float64]map[string]interface{}{
+				1: {"id": 1, "price": "1.5"}, 2: {"id": 2, "price": "1.6"}, 3: {"id": 3, "price": "1.7"},
+				4: {"id": 4, "price": "1.8"}, 5: {"id": 5},
+			}},
+			expected: []*Verification{{Storage: "postgresql", Table: "trades", Sampled: 2}},
+		},
+		{
+			name:   "read not supported",
+			verify: &VerifyConfig{Fail: true},
+			repo:   &verifyRepository{},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			cfg := &Config{Verify: tcase.verify, Logger: logger}
+			ver := cfg.verifier()
+
+			req := &proto.UpsertRequest{Table: "trades", Data: data, DataType: int32(tools.UpsertDataJSON)}
+			if err := ver.sample(0, req); err != nil {
+				t.Fatalf("error sampling records: %v", err)
+			}
+
+			verifications, err := ver.verify(context.Background(), cfg, []repository.Generic{tcase.repo})
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err != nil {
+				return
+			}
+
+			if !reflect.DeepEqual(verifications, tcase.expected) {
+				t.Fatalf("expected verifications %+v, got %+v", tcase.expected, verifications)
+			}
+		})
+	}
+}
+
+func TestNoVerifier(t *testing.T) {
+	t.Parallel()
+
+	ver := new(Config).verifier()
+	if ver != nil {
+		t.Fatalf("expected no verifier without a verify configuration")
+	}
+
+	if err := ver.sample(0, &proto.UpsertRequest{}); err != nil {
+		t.Fatalf("expected a nil verifier to sample nothing, got %v", err)
+	}
+
+	if verifications, err := ver.verify(context.Background(), new(Config), nil); verifications != nil || err != nil {
+		t.Fatalf("expected a nil verifier to verify nothing, got %v, %v", verifications, err)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// defaultWASMFunction is the default exported function of a WebAssembly transform.
+const defaultWASMFunction = "transform"
+
+var (
+	ErrNoWASMRuntime     = fmt.Errorf("no WebAssembly runtime is configured")
+	ErrWASMTransform     = fmt.Errorf("failed to transform records with WebAssembly")
+	ErrInvalidWASMModule = fmt.Errorf("invalid WebAssembly module")
+)
+
+// RecordTransformer transforms the JSON encoded records of a response, a list of records or a single record, into the
+// records to store. It must be safe for concurrent use.
+type RecordTransformer interface {
+	Transform(ctx context.Context, records []byte) ([]byte, error)
+}
+
+// WASMRuntime instantiates the WebAssembly modules of transforms, e.g. with wazero or wasmtime, so that the runtime
+// is chosen by the program that embeds the transport, which sets it on the configuration's "WASMRuntime".
+type WASMRuntime interface {
+	// Instantiate will compile and instantiate a module, returning the transformer that calls its exported function
+	// with the transform ABI.
+	Instantiate(ctx context.Context, module []byte, function string) (RecordTransformer, error)
+}
+
+// WASMConfig is a custom transform of the records of a request, implemented by a WebAssembly module, so that business
+// logic in any language that compiles to WebAssembly runs sandboxed within the pipeline. The transform runs on the
+// records of each response, before they are embedded and stored.
+//
+// The transform ABI is records in, records out, as JSON in the module's exported "memory": the module exports
+// "alloc(size i32) i32", which returns a buffer of the size for the input, and the transform function
+// "(ptr i32, len i32) i64", which returns the pointer of its output in the high 32 bits and its length in the low 32
+// bits.
+type WASMConfig struct {
+	// Module is the path of the WebAssembly module.
+	Module string `yaml:"module"`
+
+	// Function is the exported transform function of the module. Defaults to "transform".
+	Function string `yaml:"function"`
+
+	once        sync.Once
+	transformer RecordTransformer
+	err         error
+}
+
+func (wc *WASMConfig) validate() error {
+	if wc == nil {
+		return nil
+	}
+
+	if wc.Module == "" {
+		return MissingConfigFieldError("wasm.module")
+	}
+
+	return nil
+}
+
+// function will return the exported transform function of the module.
+func (wc *WASMConfig) function() string {
+	if wc.Function != "" {
+		return wc.Function
+	}
+
+	return defaultWASMFunction
+}
+
+// instantiate will instantiate the module once, for the requests that share it, e.g. expanded requests.
+func (wc *WASMConfig) instantiate(ctx context.Context, runtime WASMRuntime) (RecordTransformer, error) {
+	if runtime == nil {
+		return nil, ErrNoWASMRuntime
+	}
+
+	wc.once.Do(func() {
+		module, err := os.ReadFile(wc.Module)
+		if err != nil {
+			wc.err = fmt.Errorf("%w %q: %v", ErrInvalidWASMModule, wc.Module, err)
+
+			return
+		}
+
+		wc.transformer, wc.err = runtime.Instantiate(ctx, module, wc.function())
+		if wc.err != nil {
+			wc.err = fmt.Errorf("%w: unable to instantiate %q: %v", ErrWASMTransform, wc.Module, wc.err)
+		}
+	})
+
+	return wc.transformer, wc.err
+}
+
+// applyWASM will transform JSON encoded records with the WebAssembly module.
+func applyWASM(ctx context.Context, runtime WASMRuntime, wasm *WASMConfig, data []byte) ([]byte, error) {
+	if wasm == nil {
+		return data, nil
+	}
+
+	transformer, err := wasm.instantiate(ctx, runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := transformer.Transform(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrWASMTransform, wasm.Module, err)
+	}
+
+	return transformed, nil
+}
+
+// validateWASMRuntime will return an error wrapping ErrNoWASMRuntime if a request has a WebAssembly transform but the
+// configuration has no runtime. No runtime is bundled with the transport, so a transform can only run in a program
+// that sets "WASMRuntime", and the run fails before anything is truncated or fetched rather than at the first response.
+func (cfg *Config) validateWASMRuntime() error {
+	if cfg.WASMRuntime != nil {
+		return nil
+	}
+
+	for _, req := range cfg.Requests {
+		if req.WASM != nil {
+			return fmt.Errorf("%w for the transform of %q", ErrNoWASMRuntime, req.Table)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// upperRuntime is a WebAssembly runtime whose modules upper case the records.
+type upperRuntime struct {
+	instances int32
+}
+
+type upperTransformer struct{}
+
+func (rt *upperRuntime) Instantiate(_ context.Context, module []byte, function string) (RecordTransformer, error) {
+	atomic.AddInt32(&rt.instances, 1)
+
+	if string(module) != "\x00asm" || function != "transform" {
+		return nil, errors.New("unexpected module")
+	}
+
+	return upperTransformer{}, nil
+}
+
+func (upperTransformer) Transform(_ context.Context, records []byte) ([]byte, error) {
+	return bytes.ToUpper(records), nil
+}
+
+func TestApplyWASM(t *testing.T) {
+	t.Parallel()
+
+	module := filepath.Join(t.TempDir(), "transform.wasm")
+	if err := os.WriteFile(module, []byte("\x00asm"), 0o600); err != nil {
+		t.Fatalf("error writing module: %v", err)
+	}
+
+	ctx := context.Background()
+	runtime := new(upperRuntime)
+	wasm := &WASMConfig{Module: module}
+
+	for i := 0; i < 2; i++ {
+		transformed, err := applyWASM(ctx, runtime, wasm, []byte(`[{"symbol":"btc-usd"}]`))
+		if err != nil {
+			t.Fatalf("error transforming records: %v", err)
+		}
+
+		if string(transformed) != `[{"SYMBOL":"BTC-USD"}]` {
+			t.Fatalf("unexpected transformed records: %s", transformed)
+		}
+	}
+
+	if runtime.instances != 1 {
+		t.Fatalf("expected the module to be instantiated once, got %d", runtime.instances)
+	}
+
+	if _, err := applyWASM(ctx, nil, &WASMConfig{Module: module}, nil); !errors.Is(err, ErrNoWASMRuntime) {
+		t.Fatalf("expected error %v, got %v", ErrNoWASMRuntime, err)
+	}
+
+	missing := &WASMConfig{Module: filepath.Join(t.TempDir(), "missing.wasm")}
+	if _, err := applyWASM(ctx, runtime, missing, nil); !errors.Is(err, ErrInvalidWASMModule) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidWASMModule, err)
+	}
+
+	other := &WASMConfig{Module: module, Function: "other"}
+	if _, err := applyWASM(ctx, runtime, other, nil); !errors.Is(err, ErrWASMTransform) {
+		t.Fatalf("expected error %v, got %v", ErrWASMTransform, err)
+	}
+}
+
+func TestUpsertWithoutWASMRuntime(t *testing.T) {
+	t.Parallel()
+
+	var fetches int32
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		fmt.Fprint(writer, `[{"id": "a"}]`)
+	}))
+	defer testServer.Close()
+
+	cfg, err := NewConfig([]byte(fmt.Sprintf(`
+url: %s
+rateLimit:
+  burst: 5
+  period: 1ms
+requests:
+  - endpoint: /ticks
+    table: ticks
+    wasm:
+      module: transform.wasm
+`, testServer.URL)))
+	if err != nil {
+		t.Fatalf("error creating config: %v", err)
+	}
+
+	if err := Upsert(context.Background(), cfg); !errors.Is(err, ErrNoWASMRuntime) {
+		t.Fatalf("expected error %v, got %v", ErrNoWASMRuntime, err)
+	}
+
+	if fetches != 0 {
+		t.Fatalf("expected the run to fail before fetching, got %d requests", fetches)
+	}
+}
@@ -35,6 +35,9 @@ type APIKey struct {
 	passphrase string
 	secret     string
 	url        *url.URL
+
+	// transport makes the signed requests, or the default transport if it is nil.
+	transport http.RoundTripper
 }
 
 // NewAPIKey will return an APIKey authentication transport.
@@ -107,11 +110,18 @@ func parsebytes(req *http.Request) []byte {
 
 // generageMsg makes the message to be signed.
 func (auth *APIKey) generageMsg(req *http.Request, timestamp string) string {
-	postAuthority := strings.Replace(req.URL.String(), auth.url.String(), "", 1)
+	postAuthority := strings.Replace(req.URL.String(), baseURL(req, auth.url).String(), "", 1)
 
 	return fmt.Sprintf("%s%s%s%s", timestamp, req.Method, postAuthority, string(parsebytes(req)))
 }
 
+// SetTransport will set the transport field on APIKey.
+func (auth *APIKey) SetTransport(transport http.RoundTripper) *APIKey {
+	auth.transport = transport
+
+	return auth
+}
+
 // RoundTrip authorizes the request with a signed API Key Authorization header.
 func (auth *APIKey) RoundTrip(req *http.Request) (*http.Response, error) {
 	if auth.url == nil {
@@ -128,8 +138,9 @@ func (auth *APIKey) RoundTrip(req *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
-	req.URL.Scheme = auth.url.Scheme
-	req.URL.Host = auth.url.Host
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
 
 	req.Header.Set("content-type", "application/json")
 	req.Header.Add("cb-access-key", auth.key)
@@ -137,7 +148,7 @@ func (auth *APIKey) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.Header.Add("cb-access-sign", sig)
 	req.Header.Add("cb-access-timestamp", timestamp)
 
-	rsp, err := http.DefaultTransport.RoundTrip(req)
+	rsp, err := next(auth.transport).RoundTrip(req)
 	if err != nil {
 		return nil, fmt.Errorf("error making request: %w", err)
 	}
@@ -77,6 +77,9 @@ type Auth1 struct {
 	consumerKey       string
 	consumerSecret    string
 	url               *url.URL
+
+	// transport makes the requests signed with OAuth1, or the default transport if it is nil.
+	transport http.RoundTripper
 }
 
 // NewAuth1 will return an OAuth1 http transpoauth.
@@ -84,21 +87,29 @@ func NewAuth1() *Auth1 {
 	return new(Auth1)
 }
 
+// SetTransport will set the transport field on Auth1.
+func (auth *Auth1) SetTransport(transport http.RoundTripper) *Auth1 {
+	auth.transport = transport
+
+	return auth
+}
+
 // RoundTrip authorizes the request with a signed OAuth1 Authorization header.
 func (auth *Auth1) RoundTrip(req *http.Request) (*http.Response, error) {
 	if auth.url == nil {
 		return nil, ErrURLRequired
 	}
 
-	req.URL.Scheme = auth.url.Scheme
-	req.URL.Host = auth.url.Host
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
 
 	err := auth.setRequestAuthHeader(req)
 	if err != nil {
 		return nil, err
 	}
 
-	rsp, err := http.DefaultTransport.RoundTrip(req)
+	rsp, err := next(auth.transport).RoundTrip(req)
 	if err != nil {
 		return nil, fmt.Errorf("%v: %w", err, ErrRequestFailed)
 	}
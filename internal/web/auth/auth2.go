@@ -17,6 +17,9 @@ import (
 type Auth2 struct {
 	bearer string
 	url    *url.URL
+
+	// transport makes the requests with the bearer token, or the default transport if it is nil.
+	transport http.RoundTripper
 }
 
 // NewAuth2 will return an OAuth2 http transport.
@@ -38,17 +41,25 @@ func (auth *Auth2) SetURL(u string) *Auth2 {
 	return auth
 }
 
+// SetTransport will set the transport field on Auth2.
+func (auth *Auth2) SetTransport(transport http.RoundTripper) *Auth2 {
+	auth.transport = transport
+
+	return auth
+}
+
 // RoundTrip authorizes the request with a signed OAuth1 Authorization header using the author and TokenSource.
 func (auth *Auth2) RoundTrip(req *http.Request) (*http.Response, error) {
 	if auth.url == nil {
 		return nil, ErrURLRequired
 	}
 
-	req.URL.Scheme = auth.url.Scheme
-	req.URL.Host = auth.url.Host
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
 	req.Header.Set(authorizationHeaderParam, fmt.Sprintf("%s %s", bearerHeaderPrefix, auth.bearer))
 
-	rsp, err := http.DefaultTransport.RoundTrip(req)
+	rsp, err := next(auth.transport).RoundTrip(req)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
 	}
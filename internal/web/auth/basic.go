@@ -16,6 +16,9 @@ import (
 type Basic struct {
 	email, password string
 	url             *url.URL
+
+	// transport makes the requests with the credentials, or the default transport if it is nil.
+	transport http.RoundTripper
 }
 
 // NewBasic will return an Basic http transport.
@@ -44,17 +47,25 @@ func (auth *Basic) SetURL(val string) *Basic {
 	return auth
 }
 
+// SetTransport will set the transport field on Basic.
+func (auth *Basic) SetTransport(transport http.RoundTripper) *Basic {
+	auth.transport = transport
+
+	return auth
+}
+
 // RoundTrip authorizes the request with a signed OAuth1 Authorization header using the author and TokenSource.
 func (auth *Basic) RoundTrip(req *http.Request) (*http.Response, error) {
 	if auth.url == nil {
 		return nil, ErrURLRequired
 	}
 
-	req.URL.Scheme = auth.url.Scheme
-	req.URL.Host = auth.url.Host
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
 	req.SetBasicAuth(auth.email, auth.password)
 
-	rsp, err := http.DefaultTransport.RoundTrip(req)
+	rsp, err := next(auth.transport).RoundTrip(req)
 	if err != nil {
 		return nil, fmt.Errorf("%v: %w", ErrRequestFailed, err)
 	}
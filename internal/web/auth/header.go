@@ -0,0 +1,72 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Header is an http transport that authenticates requests with a static set of headers, such as the API key headers
+// used by many market data providers.
+type Header struct {
+	headers map[string]string
+	url     *url.URL
+
+	// transport makes the requests with the headers, or the default transport if it is nil.
+	transport http.RoundTripper
+}
+
+// NewHeader will return a Header http transport.
+func NewHeader() *Header {
+	return &Header{headers: make(map[string]string)}
+}
+
+// SetHeader will set a header to add to every request.
+func (auth *Header) SetHeader(key, val string) *Header {
+	auth.headers[key] = val
+
+	return auth
+}
+
+// SetURL will set the url field on Header.
+func (auth *Header) SetURL(val string) *Header {
+	auth.url, _ = url.Parse(val)
+
+	return auth
+}
+
+// SetTransport will set the transport field on Header.
+func (auth *Header) SetTransport(transport http.RoundTripper) *Header {
+	auth.transport = transport
+
+	return auth
+}
+
+// RoundTrip authorizes the request with the configured headers.
+func (auth *Header) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth.url == nil {
+		return nil, ErrURLRequired
+	}
+
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
+
+	for key, val := range auth.headers {
+		req.Header.Set(key, val)
+	}
+
+	rsp, err := next(auth.transport).RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+	}
+
+	return rsp, nil
+}
@@ -0,0 +1,371 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// jwtBearerGrantType is the grant type of the OAuth2 JWT-bearer flow, RFC 7523.
+	jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// defaultJWTLifetime is the default lifetime of the signed assertions.
+	defaultJWTLifetime = time.Hour
+
+	// jwtRenewBefore is how long before its expiry an access token is renewed.
+	jwtRenewBefore = time.Minute
+
+	// jwtRenewFraction caps jwtRenewBefore at a fraction of the lifetime of short-lived access tokens, e.g. a quarter,
+	// so that they are not renewed on every request.
+	jwtRenewFraction = 4
+
+	// es256CoordinateSize is the size of the coordinates of an ES256 signature.
+	es256CoordinateSize = 32
+)
+
+var (
+	ErrInvalidPrivateKey = fmt.Errorf("invalid private key")
+	ErrTokenRequest      = fmt.Errorf("token request failed")
+)
+
+// ParsePrivateKey will parse a PEM encoded RSA or ECDSA P-256 private key, in PKCS #1, SEC 1, or PKCS #8 form.
+func ParsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("%w: no PEM block found", ErrInvalidPrivateKey)
+	}
+
+	var (
+		key interface{}
+		err error
+	)
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPrivateKey, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve.Params().BitSize != es256CoordinateSize*8 {
+			return nil, fmt.Errorf("%w: only P-256 ECDSA keys are supported", ErrInvalidPrivateKey)
+		}
+
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported key type %T", ErrInvalidPrivateKey, key)
+}
+
+// JWTClaims are the claims of the assertions signed by a JWTBearer transport.
+type JWTClaims struct {
+	// Issuer is the "iss" claim, e.g. the client ID or the service account's email.
+	Issuer string
+
+	// Subject is the "sub" claim, e.g. the user that the client acts on behalf of. Defaults to the issuer.
+	Subject string
+
+	// Audience is the "aud" claim. Defaults to the token URL.
+	Audience string
+
+	// Scope is the "scope" claim, e.g. the space separated scopes of a Google service account.
+	Scope string
+
+	// Lifetime is the lifetime of the assertions. Defaults to one hour.
+	Lifetime time.Duration
+}
+
+// JWTBearer is an http transport that authenticates requests with the access tokens of the OAuth2 JWT-bearer flow: it
+// signs a JWT assertion with a private key and exchanges it for an access token at the token URL. The access token is
+// reused until shortly before it expires, or until a request is unauthorized, and then renewed.
+type JWTBearer struct {
+	key      crypto.Signer
+	keyID    string
+	claims   JWTClaims
+	tokenURL string
+	url      *url.URL
+
+	// transport makes the authenticated requests and the token requests, the default transport is used if it is nil.
+	transport http.RoundTripper
+
+	mu    sync.Mutex
+	token string
+	now   func() time.Time
+
+	// renews is the time that the access token is renewed, shortly before it expires.
+	renews time.Time
+}
+
+// NewJWTBearer will return a JWTBearer http transport.
+func NewJWTBearer() *JWTBearer {
+	return &JWTBearer{now: time.Now}
+}
+
+// SetKey will set the private key that signs the assertions, and the optional ID of the key, the "kid" header.
+func (auth *JWTBearer) SetKey(key crypto.Signer, keyID string) *JWTBearer {
+	auth.key = key
+	auth.keyID = keyID
+
+	return auth
+}
+
+// SetClaims will set the claims of the assertions.
+func (auth *JWTBearer) SetClaims(claims JWTClaims) *JWTBearer {
+	auth.claims = claims
+
+	return auth
+}
+
+// SetTokenURL will set the URL that the assertions are exchanged for access tokens at.
+func (auth *JWTBearer) SetTokenURL(tokenURL string) *JWTBearer {
+	auth.tokenURL = tokenURL
+
+	return auth
+}
+
+// SetURL will set the url field on JWTBearer.
+func (auth *JWTBearer) SetURL(val string) *JWTBearer {
+	auth.url, _ = url.Parse(val)
+
+	return auth
+}
+
+// SetTransport will set the transport that makes the authenticated requests and the token requests, e.g. the
+// transport of a custom HTTP client.
+func (auth *JWTBearer) SetTransport(transport http.RoundTripper) *JWTBearer {
+	auth.transport = transport
+
+	return auth
+}
+
+// algorithm will return the JWS algorithm of the private key.
+func (auth *JWTBearer) algorithm() string {
+	if _, ok := auth.key.(*ecdsa.PrivateKey); ok {
+		return "ES256"
+	}
+
+	return "RS256"
+}
+
+// assertion will return a signed JWT assertion.
+func (auth *JWTBearer) assertion(now time.Time) (string, error) {
+	header := map[string]string{"alg": auth.algorithm(), "typ": "JWT"}
+	if auth.keyID != "" {
+		header["kid"] = auth.keyID
+	}
+
+	lifetime := auth.claims.Lifetime
+	if lifetime == 0 {
+		lifetime = defaultJWTLifetime
+	}
+
+	claims := map[string]interface{}{
+		"iss": auth.claims.Issuer,
+		"sub": auth.claims.Subject,
+		"aud": auth.claims.Audience,
+		"iat": now.Unix(),
+		"exp": now.Add(lifetime).Unix(),
+		"jti": uuid.New().String(),
+	}
+
+	if auth.claims.Subject == "" {
+		claims["sub"] = auth.claims.Issuer
+	}
+
+	if auth.claims.Audience == "" {
+		claims["aud"] = auth.tokenURL
+	}
+
+	if auth.claims.Scope != "" {
+		claims["scope"] = auth.claims.Scope
+	}
+
+	segments := make([]string, 0, 3)
+
+	for _, part := range []interface{}{header, claims} {
+		bytes, err := json.Marshal(part)
+		if err != nil {
+			return "", fmt.Errorf("unable to marshal jwt: %w", err)
+		}
+
+		segments = append(segments, base64.RawURLEncoding.EncodeToString(bytes))
+	}
+
+	signature, err := auth.sign([]byte(strings.Join(segments, ".")))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(append(segments, base64.RawURLEncoding.EncodeToString(signature)), "."), nil
+}
+
+// sign will sign the signing input of a JWT with the private key.
+func (auth *JWTBearer) sign(input []byte) ([]byte, error) {
+	digest := sha256.Sum256(input)
+
+	if key, ok := auth.key.(*ecdsa.PrivateKey); ok {
+		// ES256 signatures are the fixed size coordinates of the signature, rather than its ASN.1 encoding.
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return nil, fmt.Errorf("unable to sign jwt: %w", err)
+		}
+
+		signature := make([]byte, 2*es256CoordinateSize)
+		r.FillBytes(signature[:es256CoordinateSize])
+		s.FillBytes(signature[es256CoordinateSize:])
+
+		return signature, nil
+	}
+
+	signature, err := auth.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign jwt: %w", err)
+	}
+
+	return signature, nil
+}
+
+// accessToken will return the cached access token, or exchange a new assertion for one if it is about to expire.
+func (auth *JWTBearer) accessToken(req *http.Request) (string, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	now := auth.now()
+	if auth.token != "" && now.Before(auth.renews) {
+		return auth.token, nil
+	}
+
+	assertion, err := auth.assertion(now)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{"grant_type": {jwtBearerGrantType}, "assertion": {assertion}}
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodPost, auth.tokenURL,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("unable to create token request: %w", err)
+	}
+
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rsp, err := next(auth.transport).RoundTrip(tokenReq)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return "", fmt.Errorf("unable to read token response: %w", err)
+	}
+
+	if rsp.StatusCode >= http.StatusMultipleChoices {
+		return "", fmt.Errorf("%w: %s: %s", ErrTokenRequest, rsp.Status, body)
+	}
+
+	var token struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrTokenRequest, err)
+	}
+
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("%w: no access token in response", ErrTokenRequest)
+	}
+
+	// Tokens without an expiry, e.g. of Salesforce, are renewed along with the assertions.
+	lifetime := auth.claims.Lifetime
+	if lifetime == 0 {
+		lifetime = defaultJWTLifetime
+	}
+
+	if seconds, err := token.ExpiresIn.Int64(); err == nil && seconds > 0 {
+		lifetime = time.Duration(seconds) * time.Second
+	}
+
+	renewBefore := jwtRenewBefore
+	if maxRenewBefore := lifetime / jwtRenewFraction; renewBefore > maxRenewBefore {
+		renewBefore = maxRenewBefore
+	}
+
+	auth.token = token.AccessToken
+	auth.renews = now.Add(lifetime - renewBefore)
+
+	return auth.token, nil
+}
+
+// RoundTrip authorizes the request with the bearer access token of the JWT-bearer flow.
+func (auth *JWTBearer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth.url == nil {
+		return nil, ErrURLRequired
+	}
+
+	if auth.key == nil {
+		return nil, fmt.Errorf("%w: private key is required", ErrInvalidPrivateKey)
+	}
+
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
+
+	token, err := auth.accessToken(req)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set(authorizationHeaderParam, fmt.Sprintf("%s %s", bearerHeaderPrefix, token))
+
+	rsp, err := next(auth.transport).RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+	}
+
+	// A revoked token is renewed by the next request.
+	if rsp.StatusCode == http.StatusUnauthorized {
+		auth.mu.Lock()
+		if auth.token == token {
+			auth.token = ""
+		}
+		auth.mu.Unlock()
+	}
+
+	return rsp, nil
+}
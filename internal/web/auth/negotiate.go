@@ -0,0 +1,231 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// negotiateHeaderPrefix is the scheme of SPNEGO authorization headers.
+	negotiateHeaderPrefix = "Negotiate"
+
+	// negotiateMaxLegs is the maximum number of requests of a SPNEGO exchange, so that a server that keeps
+	// continuing the exchange does not send the request forever.
+	negotiateMaxLegs = 3
+)
+
+var (
+	ErrGSSProviderRequired = fmt.Errorf("a GSSAPI provider is required for negotiate authentication")
+	ErrMutualAuthFailed    = fmt.Errorf("negotiate mutual authentication failed")
+	ErrNegotiateFailed     = fmt.Errorf("negotiate authentication failed")
+)
+
+// GSS is a GSSAPI security context, e.g. of Kerberos. It is the same interface as the "GSS" of
+// "github.com/lib/pq", so that a single provider, such as "github.com/lib/pq/auth/kerberos", authenticates both web
+// requests and Postgres connections.
+type GSS interface {
+	GetInitToken(host string, service string) ([]byte, error)
+	GetInitTokenFromSpn(spn string) ([]byte, error)
+	Continue(inToken []byte) (done bool, outToken []byte, err error)
+}
+
+// NewGSSFunc creates a GSSAPI security context for each request.
+type NewGSSFunc func() (GSS, error)
+
+// Negotiate is an http transport that authenticates requests with SPNEGO, i.e. HTTP Negotiate authentication, as used
+// by Active Directory-protected APIs.
+type Negotiate struct {
+	newGSS NewGSSFunc
+	spn    string
+	url    *url.URL
+
+	// transport makes the requests with the SPNEGO tokens, or the default transport if it is nil.
+	transport http.RoundTripper
+}
+
+// NewNegotiate will return a Negotiate http transport.
+func NewNegotiate() *Negotiate {
+	return new(Negotiate)
+}
+
+// SetGSS will set the function that creates the GSSAPI security context of each request.
+func (auth *Negotiate) SetGSS(newGSS NewGSSFunc) *Negotiate {
+	auth.newGSS = newGSS
+
+	return auth
+}
+
+// SetSPN will set the service principal name of the web API, e.g. "HTTP/api.example.com". By default, the service
+// principal is the "HTTP" service of the request's host.
+func (auth *Negotiate) SetSPN(spn string) *Negotiate {
+	auth.spn = spn
+
+	return auth
+}
+
+// SetURL will set the url field on Negotiate.
+func (auth *Negotiate) SetURL(val string) *Negotiate {
+	auth.url, _ = url.Parse(val)
+
+	return auth
+}
+
+// SetTransport will set the transport field on Negotiate.
+func (auth *Negotiate) SetTransport(transport http.RoundTripper) *Negotiate {
+	auth.transport = transport
+
+	return auth
+}
+
+// initToken will return the initial token of a security context for the request's host.
+func (auth *Negotiate) initToken(gss GSS, host string) ([]byte, error) {
+	if auth.spn != "" {
+		return gss.GetInitTokenFromSpn(auth.spn)
+	}
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return gss.GetInitToken(host, "HTTP")
+}
+
+// RoundTrip authorizes the request with a SPNEGO token of a new security context. If the server continues the exchange
+// with a token in an unauthorized response, e.g. for a mechanism that needs more than one round trip, the request is
+// sent again with the token of the security context. If the server returns a token for mutual authentication, it is
+// verified by the security context, which must then be complete.
+func (auth *Negotiate) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth.url == nil {
+		return nil, ErrURLRequired
+	}
+
+	if auth.newGSS == nil {
+		return nil, ErrGSSProviderRequired
+	}
+
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
+
+	gss, err := auth.newGSS()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create GSSAPI context: %w", err)
+	}
+
+	token, err := auth.initToken(gss, req.URL.Host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize GSSAPI context: %w", err)
+	}
+
+	for leg := 1; ; leg++ {
+		req.Header.Set(authorizationHeaderParam, fmt.Sprintf("%s %s", negotiateHeaderPrefix,
+			base64.StdEncoding.EncodeToString(token)))
+
+		rsp, err := next(auth.transport).RoundTrip(req)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+		}
+
+		serverToken, err := negotiateToken(rsp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			rsp.Body.Close()
+
+			return nil, err
+		}
+
+		if rsp.StatusCode != http.StatusUnauthorized {
+			if err := verifyNegotiate(gss, serverToken); err != nil {
+				rsp.Body.Close()
+
+				return nil, err
+			}
+
+			return rsp, nil
+		}
+
+		// The request is unauthorized, unless the server continues the exchange and the request can be sent again.
+		if serverToken == nil || leg == negotiateMaxLegs || (req.Body != nil && req.GetBody == nil) {
+			return rsp, nil
+		}
+
+		done, outToken, err := gss.Continue(serverToken)
+		if err != nil {
+			rsp.Body.Close()
+
+			return nil, fmt.Errorf("%w: %v", ErrNegotiateFailed, err)
+		}
+
+		if done || len(outToken) == 0 {
+			return rsp, nil
+		}
+
+		rsp.Body.Close()
+
+		if req, err = cloneRequest(req); err != nil {
+			return nil, err
+		}
+
+		token = outToken
+	}
+}
+
+// cloneRequest will return a copy of a request that can be sent again, with a new body.
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+		}
+
+		clone.Body = body
+	}
+
+	return clone, nil
+}
+
+// negotiateToken will return the token of a "WWW-Authenticate" header of the Negotiate scheme, or nil if there is none.
+func negotiateToken(header string) ([]byte, error) {
+	encoded := strings.TrimSpace(strings.TrimPrefix(header, negotiateHeaderPrefix))
+	if !strings.HasPrefix(header, negotiateHeaderPrefix) || encoded == "" {
+		return nil, nil
+	}
+
+	token, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid token: %v", ErrNegotiateFailed, err)
+	}
+
+	return token, nil
+}
+
+// verifyNegotiate will verify the server's mutual authentication token, if any, with the security context. The token
+// is the last of the exchange, so the security context must be complete once it is verified.
+func verifyNegotiate(gss GSS, token []byte) error {
+	if token == nil {
+		return nil
+	}
+
+	done, _, err := gss.Continue(token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMutualAuthFailed, err)
+	}
+
+	if !done {
+		return fmt.Errorf("%w: the security context is incomplete", ErrMutualAuthFailed)
+	}
+
+	return nil
+}
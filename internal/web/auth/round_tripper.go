@@ -8,8 +8,10 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 )
 
 var (
@@ -20,3 +22,30 @@ var (
 type Transport interface {
 	http.RoundTripper
 }
+
+// next will return the transport that makes authenticated requests, which is the default transport if none is set.
+func next(transport http.RoundTripper) http.RoundTripper {
+	if transport == nil {
+		return http.DefaultTransport
+	}
+
+	return transport
+}
+
+// baseURLKey is the context key of the base URL that a request is made to.
+type baseURLKey struct{}
+
+// WithBaseURL will return a copy of the context that directs the transports to make requests to the given base URL
+// instead of their configured URL, e.g. when failing over to a mirror of the web API.
+func WithBaseURL(ctx context.Context, base *url.URL) context.Context {
+	return context.WithValue(ctx, baseURLKey{}, base)
+}
+
+// baseURL will return the base URL of the request's context, or the transport's configured URL if there is none.
+func baseURL(req *http.Request, configured *url.URL) *url.URL {
+	if base, ok := req.Context().Value(baseURLKey{}).(*url.URL); ok && base != nil {
+		return base
+	}
+
+	return configured
+}
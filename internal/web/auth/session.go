@@ -0,0 +1,286 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+)
+
+var ErrLoginFailed = fmt.Errorf("login failed")
+
+// SessionLogin is the login request that starts a session.
+type SessionLogin struct {
+	// URL is the URL of the login request. Relative URLs are resolved against the transport's URL.
+	URL string
+
+	// Method is the HTTP method of the login request. Defaults to "POST".
+	Method string
+
+	// ContentType is the content type of the body, e.g. "application/x-www-form-urlencoded" or "application/json".
+	ContentType string
+
+	// Body is the body of the login request, e.g. the encoded credentials.
+	Body []byte
+}
+
+// SessionCSRF is where the CSRF token of a session is captured from, and the header that sends it with every request.
+// The token is captured from the first source that has it.
+type SessionCSRF struct {
+	// Cookie is the name of the cookie that holds the token, e.g. "csrftoken".
+	Cookie string
+
+	// ResponseHeader is the header of the login response that holds the token.
+	ResponseHeader string
+
+	// Field is the field of the login response's JSON body that holds the token.
+	Field string
+
+	// Header is the header that sends the token, e.g. "X-CSRF-Token".
+	Header string
+}
+
+// Session is an http transport that authenticates requests with the session of a login request: the cookies that the
+// login sets are kept in a cookie jar, and an optional CSRF token is sent with every request. If a request is
+// unauthorized, the session is renewed by logging in again, and the request is retried once.
+type Session struct {
+	login SessionLogin
+	csrf  *SessionCSRF
+	url   *url.URL
+
+	// transport makes the authenticated requests and the login requests, the default transport is used if it is nil.
+	transport http.RoundTripper
+
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	csrfVal string
+
+	// generation is the number of logins, so that a request that is unauthorized with a session that has already
+	// been renewed by a concurrent request does not log in again.
+	generation int
+}
+
+// NewSession will return a Session http transport.
+func NewSession() *Session {
+	return new(Session)
+}
+
+// SetLogin will set the login request that starts the session.
+func (auth *Session) SetLogin(login SessionLogin) *Session {
+	auth.login = login
+
+	return auth
+}
+
+// SetCSRF will set where the CSRF token of the session is captured from and how it is sent.
+func (auth *Session) SetCSRF(csrf *SessionCSRF) *Session {
+	auth.csrf = csrf
+
+	return auth
+}
+
+// SetURL will set the url field on Session.
+func (auth *Session) SetURL(val string) *Session {
+	auth.url, _ = url.Parse(val)
+
+	return auth
+}
+
+// SetTransport will set the transport that makes the authenticated requests and the login requests, e.g. the
+// transport of a custom HTTP client.
+func (auth *Session) SetTransport(transport http.RoundTripper) *Session {
+	auth.transport = transport
+
+	return auth
+}
+
+// loginURL will return the URL of the login request, resolved against the base URL.
+func (auth *Session) loginURL(base *url.URL) (*url.URL, error) {
+	ref, err := url.Parse(auth.login.URL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid login url: %v", ErrLoginFailed, err)
+	}
+
+	return base.ResolveReference(ref), nil
+}
+
+// startSession will log in, replacing the cookies and CSRF token of the previous session. The caller must hold the
+// lock.
+func (auth *Session) startSession(req *http.Request, base *url.URL) error {
+	loginURL, err := auth.loginURL(base)
+	if err != nil {
+		return err
+	}
+
+	method := auth.login.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	loginReq, err := http.NewRequestWithContext(req.Context(), method, loginURL.String(),
+		bytes.NewReader(auth.login.Body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLoginFailed, err)
+	}
+
+	if auth.login.ContentType != "" {
+		loginReq.Header.Set("Content-Type", auth.login.ContentType)
+	}
+
+	// Cookies that were set before the login, e.g. by a previous session, are not sent.
+	auth.jar, err = cookiejar.New(nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLoginFailed, err)
+	}
+
+	rsp, err := next(auth.transport).RoundTrip(loginReq)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrLoginFailed, err)
+	}
+	defer rsp.Body.Close()
+
+	body, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return fmt.Errorf("%w: unable to read response: %v", ErrLoginFailed, err)
+	}
+
+	if rsp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("%w: %s: %s", ErrLoginFailed, rsp.Status, body)
+	}
+
+	auth.jar.SetCookies(loginURL, rsp.Cookies())
+	auth.csrfVal = auth.captureCSRF(loginURL, rsp, body)
+	auth.generation++
+
+	return nil
+}
+
+// captureCSRF will return the CSRF token of the login response, or an empty string if there is none.
+func (auth *Session) captureCSRF(loginURL *url.URL, rsp *http.Response, body []byte) string {
+	if auth.csrf == nil {
+		return ""
+	}
+
+	if auth.csrf.Cookie != "" {
+		for _, cookie := range auth.jar.Cookies(loginURL) {
+			if cookie.Name == auth.csrf.Cookie {
+				return cookie.Value
+			}
+		}
+	}
+
+	if auth.csrf.ResponseHeader != "" {
+		if token := rsp.Header.Get(auth.csrf.ResponseHeader); token != "" {
+			return token
+		}
+	}
+
+	if auth.csrf.Field != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(body, &fields); err == nil {
+			if token, ok := fields[auth.csrf.Field].(string); ok {
+				return token
+			}
+		}
+	}
+
+	return ""
+}
+
+// authorize will add the session's cookies and CSRF token to the request, logging in first if there is no session or
+// the session is the expired generation, returning the generation of the session. The expired generation is zero if
+// no session has expired.
+func (auth *Session) authorize(req *http.Request, base *url.URL, expired int) (int, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	if auth.generation == 0 || auth.generation == expired {
+		if err := auth.startSession(req, base); err != nil {
+			return 0, err
+		}
+	}
+
+	req.Header.Del("Cookie")
+
+	for _, cookie := range auth.jar.Cookies(req.URL) {
+		req.AddCookie(cookie)
+	}
+
+	if auth.csrf != nil && auth.csrf.Header != "" && auth.csrfVal != "" {
+		req.Header.Set(auth.csrf.Header, auth.csrfVal)
+	}
+
+	return auth.generation, nil
+}
+
+// RoundTrip authorizes the request with the session, logging in first if there is no session. Cookies set by the
+// response are kept in the session. If the request is unauthorized, the session is renewed and the request retried.
+func (auth *Session) RoundTrip(req *http.Request) (*http.Response, error) {
+	if auth.url == nil {
+		return nil, ErrURLRequired
+	}
+
+	base := baseURL(req, auth.url)
+	req.URL.Scheme = base.Scheme
+	req.URL.Host = base.Host
+
+	rsp, generation, err := auth.roundTrip(req, base, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return rsp, nil
+	}
+
+	// The session expired or was revoked, log in again and retry the request once. If a concurrent request has
+	// already logged in again, the retry uses its session.
+	rsp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+		}
+	}
+
+	rsp, _, err = auth.roundTrip(retry, base, generation)
+
+	return rsp, err
+}
+
+// roundTrip will make the request with the session, keeping the cookies that the response sets, and return the
+// generation of the session that made it. The session is renewed if it is the expired generation.
+func (auth *Session) roundTrip(req *http.Request, base *url.URL, expired int) (*http.Response, int, error) {
+	generation, err := auth.authorize(req, base, expired)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rsp, err := next(auth.transport).RoundTrip(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", ErrRequestFailed, err)
+	}
+
+	// The cookies of a response to a session that has since been renewed are not kept.
+	if cookies := rsp.Cookies(); len(cookies) > 0 {
+		auth.mu.Lock()
+		if auth.generation == generation {
+			auth.jar.SetCookies(req.URL, cookies)
+		}
+		auth.mu.Unlock()
+	}
+
+	return rsp, generation, nil
+}
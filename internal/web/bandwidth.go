@@ -0,0 +1,123 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// BandwidthLimiter limits the rate at which response bodies are downloaded, both across all hosts and per host. It
+// is independent of the request rate limit, and is safe for concurrent use.
+type BandwidthLimiter struct {
+	global *rate.Limiter
+	hosts  map[string]*rate.Limiter
+	mu     sync.Mutex
+}
+
+// NewBandwidthLimiter will return a bandwidth limiter with a maximum number of bytes per second across all hosts. If
+// bytesPerSecond is not positive, the bandwidth is only limited for the hosts set with "SetHost".
+func NewBandwidthLimiter(bytesPerSecond int) *BandwidthLimiter {
+	limiter := &BandwidthLimiter{hosts: make(map[string]*rate.Limiter)}
+	if bytesPerSecond > 0 {
+		limiter.global = rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+	}
+
+	return limiter
+}
+
+// SetHost will set the maximum number of bytes per second that can be downloaded from a host. The host can include a
+// port, otherwise the limit applies to every port of the host.
+func (bl *BandwidthLimiter) SetHost(host string, bytesPerSecond int) *BandwidthLimiter {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if bytesPerSecond > 0 {
+		bl.hosts[host] = rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+	}
+
+	return bl
+}
+
+// limiters will return the limiters that apply to a host.
+func (bl *BandwidthLimiter) limiters(host string) []*rate.Limiter {
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	var limiters []*rate.Limiter
+	if bl.global != nil {
+		limiters = append(limiters, bl.global)
+	}
+
+	limiter := bl.hosts[host]
+	if limiter == nil {
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			limiter = bl.hosts[hostname]
+		}
+	}
+
+	if limiter != nil {
+		limiters = append(limiters, limiter)
+	}
+
+	return limiters
+}
+
+// Reader will wrap a response body from a host so that reading it does not exceed the bandwidth limits.
+func (bl *BandwidthLimiter) Reader(ctx context.Context, host string, body io.ReadCloser) io.ReadCloser {
+	limiters := bl.limiters(host)
+	if len(limiters) == 0 {
+		return body
+	}
+
+	// A single read can not be larger than the smallest burst, otherwise the limiter can never allow it.
+	maxRead := limiters[0].Burst()
+	for _, limiter := range limiters[1:] {
+		if limiter.Burst() < maxRead {
+			maxRead = limiter.Burst()
+		}
+	}
+
+	return &throttledReader{ctx: ctx, body: body, limiters: limiters, maxRead: maxRead}
+}
+
+// throttledReader is a response body that waits on bandwidth limiters for the bytes that it reads.
+type throttledReader struct {
+	ctx      context.Context
+	body     io.ReadCloser
+	limiters []*rate.Limiter
+	maxRead  int
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > tr.maxRead {
+		p = p[:tr.maxRead]
+	}
+
+	n, err := tr.body.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	for _, limiter := range tr.limiters {
+		if werr := limiter.WaitN(tr.ctx, n); werr != nil {
+			return n, fmt.Errorf("bandwidth limiter error: %w", werr)
+		}
+	}
+
+	return n, err
+}
+
+func (tr *throttledReader) Close() error {
+	return tr.body.Close()
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	t.Parallel()
+
+	const bytesPerSecond = 100
+
+	body := strings.Repeat("x", 2*bytesPerSecond)
+
+	for _, tcase := range []struct {
+		name    string
+		global  int
+		host    string
+		minTime time.Duration
+	}{
+		{"global", bytesPerSecond, "", time.Second},
+		{"host", 0, "127.0.0.1", time.Second},
+		{"other host", 0, "example.com", 0},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+				fmt.Fprint(writer, body)
+			}))
+			defer testServer.Close()
+
+			uri, err := url.Parse(testServer.URL)
+			if err != nil {
+				t.Fatalf("error parsing url: %v", err)
+			}
+
+			limiter := NewBandwidthLimiter(tcase.global)
+			if tcase.host != "" {
+				limiter.SetHost(tcase.host, bytesPerSecond)
+			}
+
+			client, err := NewClient(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("error creating client: %v", err)
+			}
+
+			client.SetBandwidthLimiter(limiter)
+
+			start := time.Now()
+
+			rsp, err := Fetch(context.Background(), &FetchConfig{
+				C:           client,
+				Method:      http.MethodGet,
+				URL:         uri,
+				RateLimiter: rate.NewLimiter(1, 1),
+			})
+			if err != nil {
+				t.Fatalf("fetch error: %v", err)
+			}
+
+			defer rsp.Body.Close()
+
+			data, err := io.ReadAll(rsp.Body)
+			if err != nil {
+				t.Fatalf("error reading body: %v", err)
+			}
+
+			if string(data) != body {
+				t.Fatalf("expected %d bytes, got %d", len(body), len(data))
+			}
+
+			// The first second of bytes is allowed immediately by the burst.
+			if elapsed := time.Since(start); elapsed < tcase.minTime {
+				t.Fatalf("expected the download to take at least %v, took %v", tcase.minTime, elapsed)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@
 package web
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -16,10 +17,12 @@ import (
 	"net/url"
 
 	"github.com/alpine-hodler/gidari/internal/web/auth"
-	"golang.org/x/time/rate"
 )
 
 var (
+	// ErrAuth is returned when the web API rejects the credentials used to make a request.
+	ErrAuth = errors.New("authentication failed")
+
 	// ErrCreatingRequest is returned when the request fails to create.
 	ErrCreatingRequest = errors.New("failed to create request")
 
@@ -34,6 +37,9 @@ var (
 
 	// ErrMissingFetchConfigField is returned when a required field is missing.
 	ErrMissingFetchConfigField = errors.New("missing required field on FetchConfig")
+
+	// ErrRateLimited is returned when the web API responds with a "Too Many Requests" status.
+	ErrRateLimited = errors.New("rate limited")
 )
 
 // CreateRequestError is returned when the request fails to create.
@@ -51,17 +57,108 @@ func MissingFetchConfigFieldError(field string) error {
 	return fmt.Errorf("%w: %q", ErrMissingFetchConfigField, field)
 }
 
+// ResponseError is returned when the web API responds with an unsuccessful status code. The error can be compared
+// with "errors.Is" against ErrGettingResponse, ErrAuth, and ErrRateLimited to determine the class of the failure.
+type ResponseError struct {
+	// StatusCode is the HTTP status code of the response.
+	StatusCode int
+
+	// Status is the HTTP status text of the response, e.g. "429 Too Many Requests".
+	Status string
+
+	// URL is the URL of the request that failed.
+	URL string
+
+	// err is any error that occurred while draining the response body.
+	err error
+}
+
+// Error implements the error interface.
+func (rerr *ResponseError) Error() string {
+	if rerr.err != nil {
+		return fmt.Sprintf("%v: %v", ErrGettingResponse, rerr.err)
+	}
+
+	return fmt.Sprintf("%v: %v", ErrGettingResponse, rerr.Status)
+}
+
+// Unwrap returns the error that occurred while draining the response body, if any.
+func (rerr *ResponseError) Unwrap() error {
+	return rerr.err
+}
+
+// Is reports whether the response error belongs to the class of the target error.
+func (rerr *ResponseError) Is(target error) bool {
+	switch {
+	case errors.Is(target, ErrGettingResponse):
+		return true
+	case errors.Is(target, ErrRateLimited):
+		return rerr.StatusCode == http.StatusTooManyRequests
+	case errors.Is(target, ErrAuth):
+		return rerr.StatusCode == http.StatusUnauthorized || rerr.StatusCode == http.StatusForbidden
+	}
+
+	return false
+}
+
 // GettingResponseError is returned when the response fails to get.
 func GettingResponseError(rsp *http.Response) error {
+	rerr := &ResponseError{StatusCode: rsp.StatusCode, Status: rsp.Status}
+	if rsp.Request != nil && rsp.Request.URL != nil {
+		rerr.URL = rsp.Request.URL.String()
+	}
+
 	if _, err := io.ReadAll(rsp.Body); err != nil {
-		return fmt.Errorf("%w: %v", ErrGettingResponse, err)
+		rerr.err = err
 	}
 
-	return fmt.Errorf("%w: %v", ErrGettingResponse, rsp.Status)
+	return rerr
 }
 
 // Client is a wrapper around the http.Client that will handle authentication and rate limiting.
-type Client struct{ http.Client }
+type Client struct {
+	http.Client
+
+	// bandwidth limits the rate at which response bodies are downloaded, if set.
+	bandwidth *BandwidthLimiter
+
+	// failover fails requests over to mirrors of the web API, if set.
+	failover *Failover
+
+	// concurrency limits the number of in-flight requests, if set.
+	concurrency *ConcurrencyLimiter
+}
+
+// NewClientFromHTTP will return a new client that reuses the settings of a pre-configured HTTP client, such as its
+// timeout, cookie jar, and redirect policy. If the roundtripper is not nil, it replaces the HTTP client's transport,
+// and should make its requests with that transport to reuse the HTTP client's instrumentation, proxies, and
+// connection pool.
+func NewClientFromHTTP(_ context.Context, httpClient *http.Client, roundtripper auth.Transport) (*Client, error) {
+	if httpClient == nil {
+		return nil, MissingFetchConfigFieldError("HTTPClient")
+	}
+
+	c := &Client{Client: *httpClient}
+	if roundtripper != nil {
+		c.Client.Transport = roundtripper
+	}
+
+	return c, nil
+}
+
+// SetBandwidthLimiter will limit the rate at which the client downloads response bodies.
+func (c *Client) SetBandwidthLimiter(limiter *BandwidthLimiter) *Client {
+	c.bandwidth = limiter
+
+	return c
+}
+
+// SetConcurrencyLimiter will limit the number of requests that the client has in flight at the same time.
+func (c *Client) SetConcurrencyLimiter(limiter *ConcurrencyLimiter) *Client {
+	c.concurrency = limiter
+
+	return c
+}
 
 // NewClient will return a new client with the given options.
 func NewClient(_ context.Context, roundtripper auth.Transport) (*Client, error) {
@@ -71,13 +168,47 @@ func NewClient(_ context.Context, roundtripper auth.Transport) (*Client, error)
 	return c, nil
 }
 
-// newHTTPRequest will return a new request.  If the options are set, this function will encode a body if possible.
-func newHTTPRequest(ctx context.Context, method string, uri fmt.Stringer) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, method, uri.String(), nil)
+// SetFailover will fail the client's requests over to mirrors of the web API.
+func (c *Client) SetFailover(failover *Failover) *Client {
+	c.failover = failover
+
+	return c
+}
+
+// do will make the request, failing over to mirrors of the web API if the client has a failover.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.failover == nil {
+		return c.Client.Do(req)
+	}
+
+	return c.failover.do(&c.Client, req)
+}
+
+// newHTTPRequest will return a new request with the body and headers of the fetch config. Requests with a body are
+// JSON requests, unless the headers set another content type.
+func newHTTPRequest(ctx context.Context, cfg *FetchConfig) (*http.Request, error) {
+	var body io.Reader
+	if cfg.Body != nil {
+		body = bytes.NewReader(cfg.Body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, cfg.Method, cfg.URL.String(), body)
 	if err != nil {
 		return nil, CreateRequestError(err)
 	}
 
+	if cfg.Body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	for key, values := range cfg.Header {
+		req.Header.Del(key)
+
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
 	return req, nil
 }
 
@@ -101,11 +232,26 @@ func validateResponse(res *http.Response) error {
 	return nil
 }
 
+// RateLimiter limits the rate of the requests, e.g. a "*rate.Limiter".
+type RateLimiter interface {
+	// WaitN will block until n tokens are available, or the context is done.
+	WaitN(ctx context.Context, n int) error
+}
+
 type FetchConfig struct {
 	C           *Client
 	Method      string
 	URL         *url.URL
-	RateLimiter *rate.Limiter
+	RateLimiter RateLimiter
+
+	// Weight is the number of rate limiter tokens, e.g. API credits, that the request spends. Defaults to 1.
+	Weight int
+
+	// Body is the body of the request, e.g. the JSON query of a POST request.
+	Body []byte
+
+	// Header are the headers of the request, in addition to those of the client, e.g. an idempotency key.
+	Header http.Header
 }
 
 func (cfg *FetchConfig) validate() error {
@@ -133,13 +279,17 @@ type FetchResponse struct {
 	// Request is the request that was made to the server.
 	Request *http.Request
 
+	// Header is the response header from the server.
+	Header http.Header
+
 	// Body is the response body from the server.
 	Body io.ReadCloser
 }
 
-func newFetchResponse(req *http.Request, body io.ReadCloser) *FetchResponse {
+func newFetchResponse(req *http.Request, header http.Header, body io.ReadCloser) *FetchResponse {
 	return &FetchResponse{
 		Request: req,
+		Header:  header,
 		Body:    body,
 	}
 }
@@ -151,11 +301,16 @@ func Fetch(ctx context.Context, cfg *FetchConfig) (*FetchResponse, error) {
 	}
 
 	// If the rate limiter is not set, set it with defaults.
-	if err := cfg.RateLimiter.Wait(ctx); err != nil {
+	weight := cfg.Weight
+	if weight == 0 {
+		weight = 1
+	}
+
+	if err := cfg.RateLimiter.WaitN(ctx, weight); err != nil {
 		return nil, fmt.Errorf("rate limiter error: %w", err)
 	}
 
-	req, err := newHTTPRequest(ctx, cfg.Method, cfg.URL)
+	req, err := newHTTPRequest(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("error creating request: %w", err)
 	}
@@ -164,16 +319,39 @@ func Fetch(ctx context.Context, cfg *FetchConfig) (*FetchResponse, error) {
 		return nil, fmt.Errorf("rate limiter timeout: %w", err)
 	}
 
-	rsp, err := cfg.C.Client.Do(req)
+	// The request is in flight until its response body is closed.
+	release := func() {}
+
+	if cfg.C.concurrency != nil {
+		if release, err = cfg.C.concurrency.Acquire(ctx, req.URL.Host); err != nil {
+			return nil, err
+		}
+	}
+
+	rsp, err := cfg.C.do(req)
 	if err != nil {
+		release()
+
 		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 
+	rsp.Body = &releasingBody{ReadCloser: rsp.Body, release: release}
+
 	if err := validateResponse(rsp); err != nil {
 		rsp.Body.Close()
 
 		return nil, fmt.Errorf("error validating response: %w", err)
 	}
 
-	return newFetchResponse(req, rsp.Body), nil
+	// The request of the response is the request that was actually made, which may have failed over to a mirror.
+	if rsp.Request != nil {
+		req = rsp.Request
+	}
+
+	body := rsp.Body
+	if cfg.C.bandwidth != nil {
+		body = cfg.C.bandwidth.Reader(ctx, req.URL.Host, body)
+	}
+
+	return newFetchResponse(req, rsp.Header, body), nil
 }
@@ -9,11 +9,24 @@ package web
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/alpine-hodler/gidari/internal/web/auth"
 	"golang.org/x/time/rate"
@@ -99,8 +112,8 @@ func TestFetchWithBasicAuth(t *testing.T) {
 				URL:         uri,
 				RateLimiter: rate.NewLimiter(1, 1),
 			})
-			if err == nil {
-				t.Fatalf("expected error, got nil")
+			if !errors.Is(err, ErrAuth) {
+				t.Fatalf("expected auth error, got %v", err)
 			}
 		}
 	})
@@ -217,8 +230,8 @@ func TestFetchWithAuth2(t *testing.T) {
 				URL:         uri,
 				RateLimiter: rate.NewLimiter(1, 1),
 			})
-			if err == nil {
-				t.Fatalf("expected error, got nil")
+			if !errors.Is(err, ErrAuth) {
+				t.Fatalf("expected auth error, got %v", err)
 			}
 		}
 	})
@@ -259,6 +272,395 @@ func TestFetchWithAuth2(t *testing.T) {
 	})
 }
 
+func TestFetchWithJWTBearer(t *testing.T) {
+	t.Parallel()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("error generating rsa key: %v", err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating ecdsa key: %v", err)
+	}
+
+	for _, tcase := range []struct {
+		name      string
+		key       crypto.Signer
+		expiresIn int
+	}{
+		{name: "rsa", key: rsaKey, expiresIn: 3600},
+		{name: "ecdsa", key: ecKey, expiresIn: 3600},
+		{name: "lifetime shorter than the renewal margin", key: rsaKey, expiresIn: 30},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer, tokenRequests := createTestServerWithJWTBearer(t, tcase.key.Public(), tcase.expiresIn)
+			defer testServer.Close()
+
+			ctx := context.Background()
+
+			tripper := auth.NewJWTBearer().
+				SetKey(tcase.key, "key-1").
+				SetClaims(auth.JWTClaims{Issuer: "client@example.com", Scope: "read"}).
+				SetTokenURL(testServer.URL + "/token").
+				SetURL(testServer.URL)
+
+			client, err := NewClient(ctx, tripper)
+			if err != nil {
+				t.Fatalf("error creating client: %v", err)
+			}
+
+			uri, err := url.Parse(testServer.URL + "/data")
+			if err != nil {
+				t.Fatalf("error parsing url: %v", err)
+			}
+
+			// The access token is reused until it is about to expire.
+			for request := 0; request < 2; request++ {
+				_, err = Fetch(ctx, &FetchConfig{
+					C:           client,
+					Method:      http.MethodGet,
+					URL:         uri,
+					RateLimiter: rate.NewLimiter(rate.Inf, 1),
+				})
+				if err != nil {
+					t.Fatalf("fetch error: %v", err)
+				}
+			}
+
+			if requests := atomic.LoadInt32(tokenRequests); requests != 1 {
+				t.Fatalf("expected 1 token request, got %d", requests)
+			}
+		})
+	}
+}
+
+// fakeGSS is a security context whose tokens are the strings of the test, and whose continuation tokens are the
+// replies of the server.
+type fakeGSS struct {
+	continued map[string]string
+}
+
+func (gss *fakeGSS) GetInitToken(host, service string) ([]byte, error) {
+	return []byte(service + "@" + host), nil
+}
+
+func (gss *fakeGSS) GetInitTokenFromSpn(spn string) ([]byte, error) {
+	return []byte(spn), nil
+}
+
+func (gss *fakeGSS) Continue(inToken []byte) (bool, []byte, error) {
+	outToken, ok := gss.continued[string(inToken)]
+	if !ok {
+		return false, nil, fmt.Errorf("unexpected token %q", inToken)
+	}
+
+	return outToken == "", []byte(outToken), nil
+}
+
+func TestFetchWithNegotiate(t *testing.T) {
+	t.Parallel()
+
+	type reply struct {
+		status int
+		token  string
+	}
+
+	for _, tcase := range []struct {
+		name string
+
+		// replies are the replies of the server to the tokens of the client.
+		replies map[string]reply
+
+		// continued are the tokens of the client for the tokens of the server, an empty token completes the security
+		// context.
+		continued map[string]string
+		err       error
+	}{
+		{
+			name:    "without mutual authentication",
+			replies: map[string]reply{"HTTP@127.0.0.1": {status: http.StatusOK}},
+		},
+		{
+			name:      "with mutual authentication",
+			replies:   map[string]reply{"HTTP@127.0.0.1": {status: http.StatusOK, token: "mutual"}},
+			continued: map[string]string{"mutual": ""},
+		},
+		{
+			name:      "incomplete mutual authentication",
+			replies:   map[string]reply{"HTTP@127.0.0.1": {status: http.StatusOK, token: "mutual"}},
+			continued: map[string]string{"mutual": "more"},
+			err:       auth.ErrMutualAuthFailed,
+		},
+		{
+			name:    "invalid mutual authentication",
+			replies: map[string]reply{"HTTP@127.0.0.1": {status: http.StatusOK, token: "forged"}},
+			err:     auth.ErrMutualAuthFailed,
+		},
+		{
+			name: "continued exchange",
+			replies: map[string]reply{
+				"HTTP@127.0.0.1": {status: http.StatusUnauthorized, token: "challenge"},
+				"response":       {status: http.StatusOK, token: "mutual"},
+			},
+			continued: map[string]string{"challenge": "response", "mutual": ""},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				token, err := base64.StdEncoding.DecodeString(
+					strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate "))
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+
+					return
+				}
+
+				reply, ok := tcase.replies[string(token)]
+				if !ok {
+					w.WriteHeader(http.StatusUnauthorized)
+
+					return
+				}
+
+				if reply.token != "" {
+					w.Header().Set("WWW-Authenticate",
+						"Negotiate "+base64.StdEncoding.EncodeToString([]byte(reply.token)))
+				}
+
+				w.WriteHeader(reply.status)
+			}))
+			defer testServer.Close()
+
+			ctx := context.Background()
+
+			tripper := auth.NewNegotiate().
+				SetGSS(func() (auth.GSS, error) { return &fakeGSS{continued: tcase.continued}, nil }).
+				SetURL(testServer.URL)
+
+			client, err := NewClient(ctx, tripper)
+			if err != nil {
+				t.Fatalf("error creating client: %v", err)
+			}
+
+			uri, err := url.Parse(testServer.URL + "/data")
+			if err != nil {
+				t.Fatalf("error parsing url: %v", err)
+			}
+
+			_, err = Fetch(ctx, &FetchConfig{
+				C:           client,
+				Method:      http.MethodGet,
+				URL:         uri,
+				RateLimiter: rate.NewLimiter(rate.Inf, 1),
+			})
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestFetchWithSession(t *testing.T) {
+	t.Parallel()
+
+	testServer, logins := createTestServerWithSession(t)
+	defer testServer.Close()
+
+	ctx := context.Background()
+
+	tripper := auth.NewSession().
+		SetLogin(auth.SessionLogin{
+			URL:         "/login",
+			ContentType: "application/x-www-form-urlencoded",
+			Body:        []byte("username=user&password=pass"),
+		}).
+		SetCSRF(&auth.SessionCSRF{Cookie: "csrftoken", Header: "X-CSRF-Token"}).
+		SetURL(testServer.URL)
+
+	client, err := NewClient(ctx, tripper)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	uri, err := url.Parse(testServer.URL + "/data")
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	// The server expires the session after two requests, so the third request logs in again.
+	for request := 0; request < 3; request++ {
+		_, err = Fetch(ctx, &FetchConfig{
+			C:           client,
+			Method:      http.MethodGet,
+			URL:         uri,
+			RateLimiter: rate.NewLimiter(rate.Inf, 1),
+		})
+		if err != nil {
+			t.Fatalf("fetch error: %v", err)
+		}
+	}
+
+	if count := atomic.LoadInt32(logins); count != 2 {
+		t.Fatalf("expected 2 logins, got %d", count)
+	}
+}
+
+func TestFetchWithSessionConcurrentRenewal(t *testing.T) {
+	t.Parallel()
+
+	const concurrent = 8
+
+	var (
+		logins int32
+		stale  int32
+	)
+
+	// The first session is revoked, and its requests are rejected together once every concurrent request has been
+	// made with it, so that the requests are unauthorized at the same time.
+	rejected := make(chan struct{})
+
+	testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/login" {
+			session := fmt.Sprintf("session-%d", atomic.AddInt32(&logins, 1))
+			http.SetCookie(writer, &http.Cookie{Name: "sid", Value: session, Path: "/"})
+
+			return
+		}
+
+		cookie, err := req.Cookie("sid")
+		if err == nil && cookie.Value == "session-1" {
+			if atomic.AddInt32(&stale, 1) == concurrent {
+				close(rejected)
+			}
+
+			select {
+			case <-rejected:
+			case <-time.After(5 * time.Second):
+			}
+
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		if err != nil || cookie.Value != fmt.Sprintf("session-%d", atomic.LoadInt32(&logins)) {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		fmt.Fprint(writer, `{"ok":true}`)
+	}))
+	defer testServer.Close()
+
+	ctx := context.Background()
+
+	tripper := auth.NewSession().SetLogin(auth.SessionLogin{URL: "/login"}).SetURL(testServer.URL)
+
+	client, err := NewClient(ctx, tripper)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	uri, err := url.Parse(testServer.URL + "/data")
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	var wg sync.WaitGroup
+
+	for request := 0; request < concurrent; request++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			_, err := Fetch(ctx, &FetchConfig{
+				C:           client,
+				Method:      http.MethodGet,
+				URL:         uri,
+				RateLimiter: rate.NewLimiter(rate.Inf, 1),
+			})
+			if err != nil {
+				t.Errorf("fetch error: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	// The first unauthorized request renews the session, and the others retry with the renewed session.
+	if count := atomic.LoadInt32(&logins); count != 2 {
+		t.Fatalf("expected 2 logins, got %d", count)
+	}
+}
+
+func TestFetchResponseError(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name       string
+		statusCode int
+		target     error
+		match      bool
+	}{
+		{"too many requests is rate limited", http.StatusTooManyRequests, ErrRateLimited, true},
+		{"too many requests is not auth", http.StatusTooManyRequests, ErrAuth, false},
+		{"forbidden is auth", http.StatusForbidden, ErrAuth, true},
+		{"not found is a response error", http.StatusNotFound, ErrGettingResponse, true},
+		{"not found is not rate limited", http.StatusNotFound, ErrRateLimited, false},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+				writer.WriteHeader(tcase.statusCode)
+			}))
+			defer testServer.Close()
+
+			ctx := context.Background()
+
+			client, err := NewClient(ctx, nil)
+			if err != nil {
+				t.Fatalf("error creating client: %v", err)
+			}
+
+			uri, err := url.Parse(testServer.URL)
+			if err != nil {
+				t.Fatalf("error parsing url: %v", err)
+			}
+
+			_, err = Fetch(ctx, &FetchConfig{
+				C:           client,
+				Method:      http.MethodGet,
+				URL:         uri,
+				RateLimiter: rate.NewLimiter(1, 1),
+			})
+
+			if errors.Is(err, tcase.target) != tcase.match {
+				t.Fatalf("expected errors.Is(%v, %v) to be %t", err, tcase.target, tcase.match)
+			}
+
+			var rspErr *ResponseError
+			if !errors.As(err, &rspErr) || rspErr.StatusCode != tcase.statusCode {
+				t.Fatalf("expected response error with status code %d, got %v", tcase.statusCode, err)
+			}
+		})
+	}
+}
+
 // createTestServerWithBasicAuth is a helper that creates a httptest.Server with a handler that has basic auth.
 func createTestServerWithBasicAuth(username, password string) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
@@ -284,3 +686,118 @@ func createTestServerWithOAuth2(bearer string) *httptest.Server {
 		writer.WriteHeader(http.StatusUnauthorized)
 	}))
 }
+
+// createTestServerWithJWTBearer is a helper that creates a httptest.Server that exchanges JWT assertions signed by the
+// public key's private key for access tokens at "/token", and requires the access token at "/data". It returns the
+// number of token requests.
+func createTestServerWithJWTBearer(t *testing.T, public crypto.PublicKey, expiresIn int) (*httptest.Server, *int32) {
+	t.Helper()
+
+	const accessToken = "jwt-access-token"
+
+	var tokenRequests int32
+
+	verify := func(assertion string) bool {
+		parts := strings.Split(assertion, ".")
+		if len(parts) != 3 {
+			return false
+		}
+
+		claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil || !strings.Contains(string(claims), `"iss":"client@example.com"`) {
+			return false
+		}
+
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			return false
+		}
+
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+		switch public := public.(type) {
+		case *rsa.PublicKey:
+			return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) == nil
+		case *ecdsa.PublicKey:
+			r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+
+			return ecdsa.Verify(public, digest[:], r, s)
+		}
+
+		return false
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/token":
+			atomic.AddInt32(&tokenRequests, 1)
+
+			if req.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" ||
+				!verify(req.FormValue("assertion")) {
+				writer.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			fmt.Fprintf(writer, `{"access_token":%q,"token_type":"Bearer","expires_in":%d}`, accessToken,
+				expiresIn)
+		case "/data":
+			if req.Header.Get("Authorization") != "Bearer "+accessToken {
+				writer.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			writer.WriteHeader(http.StatusOK)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, &tokenRequests
+}
+
+// createTestServerWithSession will create a test server whose data requires the cookie and CSRF token of a login.
+// Sessions expire after two requests.
+func createTestServerWithSession(t *testing.T) (*httptest.Server, *int32) {
+	t.Helper()
+
+	var (
+		logins   int32
+		requests int32
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		session := fmt.Sprintf("session-%d", atomic.LoadInt32(&logins))
+
+		switch req.URL.Path {
+		case "/login":
+			if req.Method != http.MethodPost || req.FormValue("username") != "user" ||
+				req.FormValue("password") != "pass" {
+				writer.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			session = fmt.Sprintf("session-%d", atomic.AddInt32(&logins, 1))
+			atomic.StoreInt32(&requests, 0)
+
+			http.SetCookie(writer, &http.Cookie{Name: "sid", Value: session, Path: "/"})
+			http.SetCookie(writer, &http.Cookie{Name: "csrftoken", Value: "csrf-" + session, Path: "/"})
+		case "/data":
+			cookie, err := req.Cookie("sid")
+			if err != nil || cookie.Value != session || req.Header.Get("X-CSRF-Token") != "csrf-"+session ||
+				atomic.AddInt32(&requests, 1) > 2 {
+				writer.WriteHeader(http.StatusUnauthorized)
+
+				return
+			}
+
+			fmt.Fprint(writer, `{"ok":true}`)
+		default:
+			writer.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return server, &logins
+}
@@ -0,0 +1,115 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+)
+
+// ConcurrencyLimiter limits the number of requests that are in flight at the same time, both across all hosts and per
+// host, for web APIs that limit concurrent connections independently of the request rate. A request is in flight
+// until its response body is closed. It is safe for concurrent use.
+type ConcurrencyLimiter struct {
+	global chan struct{}
+	hosts  map[string]chan struct{}
+	mu     sync.Mutex
+}
+
+// NewConcurrencyLimiter will return a concurrency limiter with a maximum number of in-flight requests across all
+// hosts. If maxInFlight is not positive, concurrency is only limited for the hosts set with "SetHost".
+func NewConcurrencyLimiter(maxInFlight int) *ConcurrencyLimiter {
+	limiter := &ConcurrencyLimiter{hosts: make(map[string]chan struct{})}
+	if maxInFlight > 0 {
+		limiter.global = make(chan struct{}, maxInFlight)
+	}
+
+	return limiter
+}
+
+// SetHost will set the maximum number of in-flight requests to a host. The host can include a port, otherwise the
+// limit applies to every port of the host.
+func (cl *ConcurrencyLimiter) SetHost(host string, maxInFlight int) *ConcurrencyLimiter {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if maxInFlight > 0 {
+		cl.hosts[host] = make(chan struct{}, maxInFlight)
+	}
+
+	return cl
+}
+
+// semaphores will return the semaphores that apply to a host, the per host semaphore last.
+func (cl *ConcurrencyLimiter) semaphores(host string) []chan struct{} {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	var semaphores []chan struct{}
+	if cl.global != nil {
+		semaphores = append(semaphores, cl.global)
+	}
+
+	semaphore := cl.hosts[host]
+	if semaphore == nil {
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			semaphore = cl.hosts[hostname]
+		}
+	}
+
+	if semaphore != nil {
+		semaphores = append(semaphores, semaphore)
+	}
+
+	return semaphores
+}
+
+// Acquire will wait until a request to the host can be made without exceeding the concurrency limits, and return the
+// function that releases the request's slots once it is no longer in flight.
+func (cl *ConcurrencyLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	semaphores := cl.semaphores(host)
+	acquired := make([]chan struct{}, 0, len(semaphores))
+
+	release := func() {
+		for _, semaphore := range acquired {
+			<-semaphore
+		}
+	}
+
+	// The slots are always acquired in the same order, so that concurrent requests can not deadlock.
+	for _, semaphore := range semaphores {
+		select {
+		case semaphore <- struct{}{}:
+			acquired = append(acquired, semaphore)
+		case <-ctx.Done():
+			release()
+
+			return nil, fmt.Errorf("concurrency limiter error: %w", ctx.Err())
+		}
+	}
+
+	return release, nil
+}
+
+// releasingBody is a response body that releases the slots of its request when it is closed.
+type releasingBody struct {
+	io.ReadCloser
+
+	once    sync.Once
+	release func()
+}
+
+func (rb *releasingBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.once.Do(rb.release)
+
+	return err
+}
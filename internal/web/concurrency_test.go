@@ -0,0 +1,148 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Parallel()
+
+	const (
+		maxInFlight = 2
+		requests    = 8
+	)
+
+	for _, tcase := range []struct {
+		name   string
+		global int
+		host   string
+	}{
+		{"global", maxInFlight, ""},
+		{"host", 0, "127.0.0.1"},
+		{"global and host", maxInFlight + 1, "127.0.0.1"},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			var inFlight, peak int32
+
+			testServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+				current := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+
+				for {
+					previous := atomic.LoadInt32(&peak)
+					if current <= previous || atomic.CompareAndSwapInt32(&peak, previous, current) {
+						break
+					}
+				}
+
+				time.Sleep(20 * time.Millisecond)
+				fmt.Fprint(writer, "ok")
+			}))
+			defer testServer.Close()
+
+			uri, err := url.Parse(testServer.URL)
+			if err != nil {
+				t.Fatalf("error parsing url: %v", err)
+			}
+
+			limiter := NewConcurrencyLimiter(tcase.global)
+			if tcase.host != "" {
+				limiter.SetHost(tcase.host, maxInFlight)
+			}
+
+			client, err := NewClient(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("error creating client: %v", err)
+			}
+
+			client.SetConcurrencyLimiter(limiter)
+
+			var wg sync.WaitGroup
+
+			errs := make(chan error, requests)
+
+			for i := 0; i < requests; i++ {
+				wg.Add(1)
+
+				go func() {
+					defer wg.Done()
+
+					rsp, err := Fetch(context.Background(), &FetchConfig{
+						C:           client,
+						Method:      http.MethodGet,
+						URL:         uri,
+						RateLimiter: rate.NewLimiter(rate.Inf, 1),
+					})
+					if err != nil {
+						errs <- err
+
+						return
+					}
+
+					defer rsp.Body.Close()
+
+					if _, err := io.ReadAll(rsp.Body); err != nil {
+						errs <- err
+					}
+				}()
+			}
+
+			wg.Wait()
+			close(errs)
+
+			for err := range errs {
+				t.Fatalf("fetch error: %v", err)
+			}
+
+			if peak := atomic.LoadInt32(&peak); peak > maxInFlight {
+				t.Fatalf("expected at most %d requests in flight, got %d", maxInFlight, peak)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterContext(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewConcurrencyLimiter(1)
+
+	release, err := limiter.Acquire(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("error acquiring: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := limiter.Acquire(ctx, "example.com"); err == nil {
+		t.Fatal("expected the second request to wait until the context is done")
+	}
+
+	release()
+
+	if _, err := limiter.Acquire(context.Background(), "example.com"); err != nil {
+		t.Fatalf("error acquiring after release: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/web/auth"
+)
+
+const (
+	// defaultFailoverCooldown is the time a mirror is considered unhealthy after its first failure.
+	defaultFailoverCooldown = 30 * time.Second
+
+	// maxFailoverCooldown is the longest time a mirror is considered unhealthy after consecutive failures.
+	maxFailoverCooldown = 5 * time.Minute
+)
+
+// mirror is a base URL of a web API and its health.
+type mirror struct {
+	base      *url.URL
+	failures  int
+	downUntil time.Time
+}
+
+// Failover fails requests over to mirrors of a web API when the primary base URL is unreachable or responds with a
+// server error. Mirrors that fail are skipped for a cooldown that doubles with each consecutive failure, and are
+// restored as soon as a request to them succeeds. It is safe for concurrent use.
+type Failover struct {
+	mirrors  []*mirror
+	cooldown time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+// NewFailover will return a failover for the primary base URL of a web API and its mirrors, in order of preference.
+func NewFailover(primary *url.URL, mirrors ...*url.URL) *Failover {
+	failover := &Failover{cooldown: defaultFailoverCooldown, now: time.Now}
+	for _, base := range append([]*url.URL{primary}, mirrors...) {
+		failover.mirrors = append(failover.mirrors, &mirror{base: base})
+	}
+
+	return failover
+}
+
+// rebase will return a copy of a URL with the base URL "from" replaced by "to".
+func rebase(uri, from, to *url.URL) *url.URL {
+	rebased := *uri
+	rebased.Scheme = to.Scheme
+	rebased.Host = to.Host
+
+	if prefix := strings.TrimSuffix(from.Path, "/"); strings.HasPrefix(uri.Path, prefix) {
+		rebased.Path = strings.TrimSuffix(to.Path, "/") + strings.TrimPrefix(uri.Path, prefix)
+	}
+
+	return &rebased
+}
+
+// candidates will return the mirrors to try for a request. Healthy mirrors are returned in order of preference,
+// followed by unhealthy mirrors in the order that they are expected to recover.
+func (fo *Failover) candidates() []*mirror {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	now := fo.now()
+
+	candidates := make([]*mirror, len(fo.mirrors))
+	copy(candidates, fo.mirrors)
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iDown, jDown := candidates[i].downUntil.After(now), candidates[j].downUntil.After(now)
+		if iDown != jDown {
+			return !iDown
+		}
+
+		return iDown && candidates[i].downUntil.Before(candidates[j].downUntil)
+	})
+
+	return candidates
+}
+
+// succeed will mark a mirror as healthy.
+func (fo *Failover) succeed(mir *mirror) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	mir.failures = 0
+	mir.downUntil = time.Time{}
+}
+
+// fail will mark a mirror as unhealthy for a cooldown that doubles with each consecutive failure.
+func (fo *Failover) fail(mir *mirror) {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	cooldown := fo.cooldown << mir.failures
+	if cooldown <= 0 || cooldown > maxFailoverCooldown {
+		cooldown = maxFailoverCooldown
+	}
+
+	mir.failures++
+	mir.downUntil = fo.now().Add(cooldown)
+}
+
+// Healthy will return the base URLs that are currently considered healthy, in order of preference.
+func (fo *Failover) Healthy() []*url.URL {
+	fo.mu.Lock()
+	defer fo.mu.Unlock()
+
+	var healthy []*url.URL
+
+	now := fo.now()
+	for _, mir := range fo.mirrors {
+		if !mir.downUntil.After(now) {
+			healthy = append(healthy, mir.base)
+		}
+	}
+
+	return healthy
+}
+
+// shouldFailover will return true if a request should be retried on another mirror: the mirror was unreachable or
+// responded with a server error.
+func shouldFailover(rsp *http.Response, err error) bool {
+	return err != nil || rsp.StatusCode >= http.StatusInternalServerError
+}
+
+// do will make the request on each candidate mirror until one of them does not need to fail over, returning the
+// response of the last attempt.
+func (fo *Failover) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	primary := fo.mirrors[0].base
+	candidates := fo.candidates()
+
+	var (
+		rsp *http.Response
+		err error
+	)
+
+	for idx, mir := range candidates {
+		attempt := req.Clone(auth.WithBaseURL(req.Context(), mir.base))
+		attempt.URL = rebase(req.URL, primary, mir.base)
+		attempt.Host = ""
+
+		// Every attempt reads the body from the start.
+		if req.GetBody != nil {
+			if attempt.Body, err = req.GetBody(); err != nil {
+				return nil, fmt.Errorf("unable to replay request body: %w", err)
+			}
+		}
+
+		rsp, err = client.Do(attempt)
+		if !shouldFailover(rsp, err) {
+			fo.succeed(mir)
+
+			return rsp, nil
+		}
+
+		fo.fail(mir)
+
+		// Return the last attempt, or stop trying mirrors if the request was canceled.
+		if idx == len(candidates)-1 || req.Context().Err() != nil {
+			break
+		}
+
+		if err == nil {
+			rsp.Body.Close()
+		}
+	}
+
+	return rsp, err
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package web
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/web/auth"
+	"golang.org/x/time/rate"
+)
+
+func TestFailover(t *testing.T) {
+	t.Parallel()
+
+	var primaryHits, mirrorHits int32
+
+	primary := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&primaryHits, 1)
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&mirrorHits, 1)
+		fmt.Fprintf(writer, "%s %s", req.URL.Path, req.Header.Get("X-Api-Key"))
+	}))
+	defer mirror.Close()
+
+	primaryURL, err := url.Parse(primary.URL + "/api")
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	mirrorURL, err := url.Parse(mirror.URL + "/v1")
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	// The auth transport is configured with the primary URL, the failover must still reach the mirror.
+	tripper := auth.NewHeader().SetURL(primaryURL.String()).SetHeader("X-Api-Key", "k")
+
+	client, err := NewClient(context.Background(), tripper)
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	failover := NewFailover(primaryURL, mirrorURL)
+	client.SetFailover(failover)
+
+	for i := 0; i < 2; i++ {
+		rsp, err := Fetch(context.Background(), &FetchConfig{
+			C:           client,
+			Method:      http.MethodGet,
+			URL:         primaryURL.JoinPath("candles"),
+			RateLimiter: rate.NewLimiter(rate.Inf, 1),
+		})
+		if err != nil {
+			t.Fatalf("fetch error: %v", err)
+		}
+
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+
+		if err != nil {
+			t.Fatalf("error reading body: %v", err)
+		}
+
+		if string(body) != "/v1/candles k" {
+			t.Fatalf("expected the request to be rebased onto the mirror, got %q", body)
+		}
+
+		if rsp.Request.URL.Host != mirrorURL.Host {
+			t.Fatalf("expected the response request to be the mirror request, got %v", rsp.Request.URL)
+		}
+	}
+
+	// The primary is unhealthy after the first failure, so the second request goes straight to the mirror.
+	if primaryHits != 1 || mirrorHits != 2 {
+		t.Fatalf("expected 1 primary and 2 mirror requests, got %d and %d", primaryHits, mirrorHits)
+	}
+
+	if healthy := failover.Healthy(); len(healthy) != 1 || healthy[0] != mirrorURL {
+		t.Fatalf("expected only the mirror to be healthy, got %v", healthy)
+	}
+}
+
+func TestFailoverReplaysBody(t *testing.T) {
+	t.Parallel()
+
+	var primaryBody string
+
+	primary := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		primaryBody = string(body)
+
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer primary.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		fmt.Fprintf(writer, "%s %s %s", body, req.Header.Get("Content-Type"), req.Header.Get("Idempotency-Key"))
+	}))
+	defer mirror.Close()
+
+	primaryURL, err := url.Parse(primary.URL)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	mirrorURL, err := url.Parse(mirror.URL)
+	if err != nil {
+		t.Fatalf("error parsing url: %v", err)
+	}
+
+	client, err := NewClient(context.Background(), auth.NewHeader().SetURL(primaryURL.String()))
+	if err != nil {
+		t.Fatalf("error creating client: %v", err)
+	}
+
+	client.SetFailover(NewFailover(primaryURL, mirrorURL))
+
+	rsp, err := Fetch(context.Background(), &FetchConfig{
+		C:           client,
+		Method:      http.MethodPost,
+		URL:         primaryURL.JoinPath("query"),
+		RateLimiter: rate.NewLimiter(rate.Inf, 1),
+		Body:        []byte(`{"symbol":"BTC-USD"}`),
+		Header:      http.Header{"Idempotency-Key": []string{"key"}},
+	})
+	if err != nil {
+		t.Fatalf("fetch error: %v", err)
+	}
+
+	body, err := io.ReadAll(rsp.Body)
+	rsp.Body.Close()
+
+	if err != nil {
+		t.Fatalf("error reading body: %v", err)
+	}
+
+	if primaryBody != `{"symbol":"BTC-USD"}` {
+		t.Fatalf("expected the primary to receive the body, got %q", primaryBody)
+	}
+
+	if expected := `{"symbol":"BTC-USD"} application/json key`; string(body) != expected {
+		t.Fatalf("expected the mirror to receive the same request %q, got %q", expected, body)
+	}
+}
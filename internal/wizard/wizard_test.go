@@ -0,0 +1,191 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package wizard
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/internal/transport"
+)
+
+func TestRecordsPath(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		body interface{}
+		path string
+		ok   bool
+	}{
+		{
+			name: "array",
+			body: []interface{}{map[string]interface{}{"id": 1.0}},
+			ok:   true,
+		},
+		{
+			name: "nested",
+			body: map[string]interface{}{
+				"meta": map[string]interface{}{"tags": []interface{}{"a"}},
+				"result": map[string]interface{}{
+					"data": []interface{}{map[string]interface{}{"id": 1.0}},
+				},
+			},
+			path: "result.data",
+			ok:   true,
+		},
+		{
+			name: "no records",
+			body: map[string]interface{}{"count": 1.0, "empty": []interface{}{}},
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			path, _, ok := recordsPath(tcase.body)
+			if path != tcase.path || ok != tcase.ok {
+				t.Fatalf("expected path %q (%v), got %q (%v)", tcase.path, tcase.ok, path, ok)
+			}
+		})
+	}
+}
+
+func TestProposeRateLimit(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name   string
+		header http.Header
+		burst  int
+		period time.Duration
+	}{
+		{name: "policy", header: http.Header{"Ratelimit-Policy": {"100;w=60"}}, burst: 100, period: time.Minute},
+		{name: "limit with policy", header: http.Header{"Ratelimit-Limit": {"10, 10;w=1"}}, burst: 10, period: time.Second},
+		{name: "limit", header: http.Header{"X-Ratelimit-Limit": {"5000"}}, burst: 5000, period: time.Minute},
+		{name: "none", header: http.Header{}},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := proposeRateLimit(tcase.header)
+			if tcase.burst == 0 {
+				if got != nil {
+					t.Fatalf("expected no rate limit, got %+v", got)
+				}
+
+				return
+			}
+
+			if got == nil || got.Burst != tcase.burst || got.Period != tcase.period {
+				t.Fatalf("expected %d requests per %s, got %+v", tcase.burst, tcase.period, got)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer token" {
+			writer.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		writer.Header().Set("RateLimit-Policy", "30;w=10")
+		_, _ = writer.Write([]byte(`{"data": [{"id": 1, "price": "1.5"}, {"id": 2, "price": "1.6"}], "next": null}`))
+	}))
+	t.Cleanup(server.Close)
+
+	t.Run("config", func(t *testing.T) {
+		t.Parallel()
+
+		// The invalid answers are asked again.
+		input := strings.Join([]string{
+			"ftp://example.com", server.URL + "/", "basic", "bearer", "token", "v1/trades",
+			"", "", "20s", "postgresql://localhost:5432/db",
+		}, "\n") + "\n"
+
+		var out bytes.Buffer
+
+		answers, err := Run(context.Background(), strings.NewReader(input), &out, server.Client())
+		if err != nil {
+			t.Fatalf("error running wizard: %v\n%s", err, out.String())
+		}
+
+		expected := &Answers{
+			URL:              server.URL,
+			Endpoint:         "/v1/trades",
+			Table:            "trades",
+			Auth:             AuthBearer,
+			Credential:       "token",
+			RecordsPath:      "data",
+			Fields:           []string{"id", "price"},
+			RateLimit:        &RateLimit{Burst: 30, Period: 20 * time.Second, Source: "set in the wizard"},
+			ConnectionString: "postgresql://localhost:5432/db",
+		}
+
+		if !reflect.DeepEqual(answers, expected) {
+			t.Fatalf("expected answers %+v, got %+v\n%s", expected, answers, out.String())
+		}
+
+		var yml bytes.Buffer
+		if err := answers.WriteConfig(&yml); err != nil {
+			t.Fatalf("error writing config: %v", err)
+		}
+
+		cfg, err := transport.NewConfig(yml.Bytes())
+		if err != nil {
+			t.Fatalf("error loading written config: %v\n%s", err, yml.String())
+		}
+
+		if cfg.Version != transport.ConfigVersion || cfg.Authentication.Auth2.Bearer != "token" ||
+			*cfg.RateLimitConfig.Burst != 30 || cfg.Requests[0].Table != "trades" {
+			t.Fatalf("unexpected config written:\n%s", yml.String())
+		}
+	})
+
+	t.Run("failed probe", func(t *testing.T) {
+		t.Parallel()
+
+		input := strings.Join([]string{server.URL, "", "/v1/trades", "trades", "", "", ""}, "\n") + "\n"
+
+		var out bytes.Buffer
+
+		answers, err := Run(context.Background(), strings.NewReader(input), &out, server.Client())
+		if err != nil {
+			t.Fatalf("error running wizard: %v\n%s", err, out.String())
+		}
+
+		if !strings.Contains(out.String(), "The probe failed") || *answers.RateLimit != *defaultRateLimit() {
+			t.Fatalf("expected the failed probe to be reported with the default rate limit, got %+v\n%s",
+				answers.RateLimit, out.String())
+		}
+	})
+
+	t.Run("aborted", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := Run(context.Background(), strings.NewReader(server.URL+"\n"), new(bytes.Buffer), server.Client())
+		if !errors.Is(err, ErrAborted) {
+			t.Fatalf("expected error %v, got %v", ErrAborted, err)
+		}
+	})
+}
@@ -13,6 +13,8 @@ import (
 
 	"github.com/alpine-hodler/gidari/internal/storage"
 	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/apache/arrow/go/v12/arrow/memory"
 )
 
 // ErrFailedToCreateRepository is returned when the repository layer fails to create a new repository.
@@ -30,6 +32,49 @@ type Generic interface {
 	storage.Transactor
 
 	Transact(fn func(ctx context.Context, repo Generic) error)
+
+	// CheckVersioned will return an error if the storage device does not honor the version field of upserts.
+	CheckVersioned() error
+
+	// TruncateRange will delete the records of a table within a time window.
+	TruncateRange(ctx context.Context, req *storage.TruncateRangeRequest) (*proto.TruncateResponse, error)
+
+	// Notify will notify the listeners of a channel.
+	Notify(ctx context.Context, req *storage.NotifyRequest) error
+
+	// Measure will return a measurement of the data of a table.
+	Measure(ctx context.Context, req *storage.MeasureRequest) (float64, error)
+
+	// CountUpserted will count the records of an upsert request that are present in storage.
+	CountUpserted(ctx context.Context, req *proto.UpsertRequest) (*storage.UpsertedCount, error)
+
+	// Buckets will return the indexes of the time buckets of a table that hold records.
+	Buckets(ctx context.Context, req *storage.BucketsRequest) ([]int64, error)
+
+	// ReadTable will return every record of a table, along with the fields of its primary keys.
+	ReadTable(ctx context.Context, req *proto.ReadRequest) (*storage.StoredRecords, error)
+
+	// CountTable will return the number of records of a table.
+	CountTable(ctx context.Context, table string) (int64, error)
+
+	// ReadPage will return a page of the records of a table, in the order of their primary keys.
+	ReadPage(ctx context.Context, req *storage.ReadPageRequest) (*storage.StoredRecords, error)
+
+	// ReadUpserted will return the stored records of an upsert request, by their keys.
+	ReadUpserted(ctx context.Context, req *proto.UpsertRequest) (*storage.StoredRecords, error)
+
+	// ReadSchema will return the schema of the table of an upsert request, or nil if the table does not exist.
+	ReadSchema(ctx context.Context, req *proto.UpsertRequest) (*storage.TableSchema, error)
+
+	// Untransacted will return true if a rollback does not remove the writes of the transaction.
+	Untransacted() bool
+
+	// SelectDatabase will select the database of the requests without a database, for storage that can write to
+	// several databases over one connection.
+	SelectDatabase(name string)
+
+	// Checkpoint will commit the transaction and start a new one.
+	Checkpoint(ctx context.Context) error
 }
 
 // GenericService is the implementation of the Generic service.
@@ -64,6 +109,17 @@ func NewTx(ctx context.Context, dns string) (*GenericService, error) {
 	return &GenericService{stg, tx}, nil
 }
 
+// device will return the storage device of the service. The storage constructed from a connection string is wrapped
+// by a "storage.Service", which only has the methods of "storage.Storage", so it is unwrapped to find the optional
+// interfaces of the device.
+func (svc *GenericService) device() storage.Storage {
+	if wrapped, ok := svc.Storage.(*storage.Service); ok {
+		return wrapped.Storage
+	}
+
+	return svc.Storage
+}
+
 // Transact is a helper function that wraps a function in a transaction and commits or rolls back the transaction. If
 // svc is not a transaction, the function will be executed without executing.
 func (svc *GenericService) Transact(fn func(ctx context.Context, repo Generic) error) {
@@ -77,6 +133,45 @@ func (svc *GenericService) Transact(fn func(ctx context.Context, repo Generic) e
 	})
 }
 
+// Untransacted will return true if the storage device runs the writes of its transaction as they are received, so
+// that a rollback does not remove them.
+func (svc *GenericService) Untransacted() bool {
+	untransacted, ok := svc.device().(storage.Untransacted)
+
+	return ok && untransacted.Untransacted()
+}
+
+// SelectDatabase will select the database of the requests without a database, if the storage device can write to
+// several databases over one connection. Other storage devices ignore it.
+func (svc *GenericService) SelectDatabase(name string) {
+	if selector, ok := svc.device().(storage.DatabaseSelector); ok {
+		selector.SelectDatabase(name)
+	}
+}
+
+// Checkpoint will commit the transaction and start a new one, so that the operations sent so far are durable and the
+// later operations run in the new transaction. A new transaction is started even if the commit fails, and the error of
+// the commit is returned. It must not be called while operations are sent to the transaction.
+func (svc *GenericService) Checkpoint(ctx context.Context) error {
+	commitErr := svc.Txn.Commit()
+
+	txn, err := svc.Storage.StartTx(ctx)
+	if err != nil {
+		err = fmt.Errorf("failed to start transaction: %w", err)
+		svc.Txn = storage.FailedTx(err)
+
+		return err
+	}
+
+	svc.Txn = txn
+
+	if commitErr != nil {
+		return fmt.Errorf("failed to commit transaction: %w", commitErr)
+	}
+
+	return nil
+}
+
 // Truncate truncates a table.
 func (svc *GenericService) Truncate(ctx context.Context, req *proto.TruncateRequest) (*proto.TruncateResponse, error) {
 	rsp, err := svc.Storage.Truncate(ctx, req)
@@ -86,3 +181,219 @@ func (svc *GenericService) Truncate(ctx context.Context, req *proto.TruncateRequ
 
 	return rsp, nil
 }
+
+// Upsert will insert or update a batch of records. If the storage device consumes Arrow record batches, the records
+// are decoded into a record batch and sent to the device directly.
+func (svc *GenericService) Upsert(ctx context.Context, req *proto.UpsertRequest) (*proto.UpsertResponse, error) {
+	batchUpserter, ok := svc.device().(storage.BatchUpserter)
+	if !ok {
+		rsp, err := svc.Storage.Upsert(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("error upserting records: %w", err)
+		}
+
+		return rsp, nil
+	}
+
+	rec, err := tools.DecodeUpsertArrowRecord(memory.DefaultAllocator, req)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding record batch: %w", err)
+	}
+
+	defer rec.Release()
+
+	rsp, err := batchUpserter.UpsertBatch(ctx, req.Table, rec)
+	if err != nil {
+		return nil, fmt.Errorf("error upserting record batch: %w", err)
+	}
+
+	return rsp, nil
+}
+
+// CheckVersioned will return storage.ErrVersionNotSupported if the storage device does not honor the version field of
+// upsert requests, and would overwrite stored records with older versions of them.
+func (svc *GenericService) CheckVersioned() error {
+	versioner, ok := svc.device().(storage.Versioner)
+	if !ok || !versioner.Versioned() {
+		return fmt.Errorf("%w for %q", storage.ErrVersionNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	return nil
+}
+
+// TruncateRange will delete the records of a table whose time column is within a time window. If the storage device
+// does not support deleting a range of records, storage.ErrRangeNotSupported is returned.
+func (svc *GenericService) TruncateRange(ctx context.Context,
+	req *storage.TruncateRangeRequest,
+) (*proto.TruncateResponse, error) {
+	rangeTruncater, ok := svc.device().(storage.RangeTruncater)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrRangeNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	rsp, err := rangeTruncater.TruncateRange(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error truncating range: %w", err)
+	}
+
+	return rsp, nil
+}
+
+// Notify will notify the listeners of a channel. Within a transaction, the listeners are only notified once the
+// transaction is committed. If the storage device does not support notifications, storage.ErrNotifyNotSupported is
+// returned.
+func (svc *GenericService) Notify(ctx context.Context, req *storage.NotifyRequest) error {
+	notifier, ok := svc.device().(storage.Notifier)
+	if !ok {
+		return fmt.Errorf("%w for %q", storage.ErrNotifyNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	if err := notifier.Notify(ctx, req); err != nil {
+		return fmt.Errorf("error notifying: %w", err)
+	}
+
+	return nil
+}
+
+// Measure will return a measurement of the data of a table, e.g. to assert its quality once it is loaded. Within a
+// transaction, the uncommitted data is measured. If the storage device does not support measurements,
+// storage.ErrMeasureNotSupported is returned.
+func (svc *GenericService) Measure(ctx context.Context, req *storage.MeasureRequest) (float64, error) {
+	measurer, ok := svc.device().(storage.Measurer)
+	if !ok {
+		return 0, fmt.Errorf("%w for %q", storage.ErrMeasureNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	value, err := measurer.Measure(ctx, req)
+	if err != nil {
+		return 0, fmt.Errorf("error measuring table: %w", err)
+	}
+
+	return value, nil
+}
+
+// CountUpserted will count the distinct records of an upsert request and the number of them that are present in
+// storage, so that writes can be reconciled with the decoded records. Within a transaction, the uncommitted records are
+// counted. If the storage device does not support counting, storage.ErrCountNotSupported is returned.
+func (svc *GenericService) CountUpserted(ctx context.Context,
+	req *proto.UpsertRequest,
+) (*storage.UpsertedCount, error) {
+	counter, ok := svc.device().(storage.Counter)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrCountNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	count, err := counter.CountUpserted(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error counting upserted records: %w", err)
+	}
+
+	return count, nil
+}
+
+// Buckets will return the indexes of the time buckets of a table that hold records, e.g. to find the gaps of a
+// timeseries table. If the storage device does not support listing buckets, storage.ErrBucketsNotSupported is
+// returned.
+func (svc *GenericService) Buckets(ctx context.Context, req *storage.BucketsRequest) ([]int64, error) {
+	bucketer, ok := svc.device().(storage.Bucketer)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrBucketsNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	buckets, err := bucketer.Buckets(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error listing buckets: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// ReadTable will return every record of a table, along with the fields of the primary keys that identify them, e.g.
+// to compare the records of a run with the records in storage. If the storage device does not support reading tables,
+// storage.ErrReadNotSupported is returned.
+func (svc *GenericService) ReadTable(ctx context.Context, req *proto.ReadRequest) (*storage.StoredRecords, error) {
+	reader, ok := svc.device().(storage.TableReader)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrReadNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	stored, err := reader.ReadTable(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading table: %w", err)
+	}
+
+	return stored, nil
+}
+
+// CountTable will return the number of records of a table. If the storage device does not support reading tables a
+// page at a time, storage.ErrReadNotSupported is returned.
+func (svc *GenericService) CountTable(ctx context.Context, table string) (int64, error) {
+	pager, ok := svc.device().(storage.TablePager)
+	if !ok {
+		return 0, fmt.Errorf("%w for %q", storage.ErrReadNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	count, err := pager.CountTable(ctx, table)
+	if err != nil {
+		return 0, fmt.Errorf("error counting table: %w", err)
+	}
+
+	return count, nil
+}
+
+// ReadPage will return a page of the records of a table in the order of their primary keys, along with the fields of
+// the primary keys, e.g. to copy a table without holding all of its records in memory. If the storage device does not
+// support reading tables a page at a time, storage.ErrReadNotSupported is returned.
+func (svc *GenericService) ReadPage(ctx context.Context,
+	req *storage.ReadPageRequest,
+) (*storage.StoredRecords, error) {
+	pager, ok := svc.device().(storage.TablePager)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrReadNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	stored, err := pager.ReadPage(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading page: %w", err)
+	}
+
+	return stored, nil
+}
+
+// ReadUpserted will return the stored records whose keys are the keys of the records of an upsert request, e.g. to
+// verify that the records of a run read back as they were written. If the storage device does not support reading
+// upserted records, storage.ErrReadNotSupported is returned.
+func (svc *GenericService) ReadUpserted(ctx context.Context,
+	req *proto.UpsertRequest,
+) (*storage.StoredRecords, error) {
+	reader, ok := svc.device().(storage.UpsertReader)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrReadNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	stored, err := reader.ReadUpserted(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading upserted records: %w", err)
+	}
+
+	return stored, nil
+}
+
+// ReadSchema will return the columns and primary keys of the table of an upsert request, or nil if the table does not
+// exist, e.g. to validate the records of the request before they are written. If the storage device does not have a
+// fixed schema, storage.ErrSchemaNotSupported is returned.
+func (svc *GenericService) ReadSchema(ctx context.Context,
+	req *proto.UpsertRequest,
+) (*storage.TableSchema, error) {
+	reader, ok := svc.device().(storage.SchemaReader)
+	if !ok {
+		return nil, fmt.Errorf("%w for %q", storage.ErrSchemaNotSupported, storage.Scheme(svc.Type()))
+	}
+
+	schema, err := reader.ReadSchema(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema: %w", err)
+	}
+
+	return schema, nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/internal/storage"
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/alpine-hodler/gidari/tools"
+	"github.com/apache/arrow/go/v12/arrow"
+)
+
+// batchStorage is a storage device that consumes record batches, counting the records of each batch.
+type batchStorage struct {
+	storage.Storage
+
+	rows []int64
+}
+
+func (stg *batchStorage) UpsertBatch(_ context.Context, _ string, rec arrow.Record) (*proto.UpsertResponse, error) {
+	stg.rows = append(stg.rows, rec.NumRows())
+
+	return &proto.UpsertResponse{UpsertedCount: rec.NumRows()}, nil
+}
+
+func TestGenericServiceUntransacted(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		stg      storage.Storage
+		expected bool
+	}{
+		{name: "nats", stg: new(storage.NATS), expected: true},
+		{name: "cosmosdb", stg: new(storage.CosmosDB), expected: true},
+		{name: "qdrant", stg: new(storage.Qdrant), expected: true},
+		{name: "postgresql", stg: new(storage.Postgres), expected: false},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The storage constructed from a connection string is wrapped by a storage service.
+			for _, svc := range []*GenericService{{Storage: tcase.stg}, {Storage: &storage.Service{Storage: tcase.stg}}} {
+				if untransacted := svc.Untransacted(); untransacted != tcase.expected {
+					t.Fatalf("expected untransacted to be %v, got %v", tcase.expected, untransacted)
+				}
+			}
+		})
+	}
+}
+
+func TestGenericServiceCheckVersioned(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		stg  storage.Storage
+		err  error
+	}{
+		{name: "postgresql", stg: new(storage.Postgres)},
+		{name: "mongodb", stg: new(storage.Mongo)},
+		{name: "cosmosdb", stg: new(storage.CosmosDB), err: storage.ErrVersionNotSupported},
+		{name: "parquet", stg: new(storage.Parquet), err: storage.ErrVersionNotSupported},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			svc := &GenericService{Storage: &storage.Service{Storage: tcase.stg}}
+			if err := svc.CheckVersioned(); !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+		})
+	}
+}
+
+func TestGenericServiceUpsertBatch(t *testing.T) {
+	t.Parallel()
+
+	stg := new(batchStorage)
+	svc := &GenericService{Storage: &storage.Service{Storage: stg}}
+
+	req := &proto.UpsertRequest{
+		Table:    "trades",
+		Data:     []byte(`[{"id": 1, "price": 1.5}, {"id": 2}]`),
+		DataType: int32(tools.UpsertDataJSON),
+	}
+
+	// The embedded storage is nil, so the records can only be upserted as a record batch.
+	rsp, err := svc.Upsert(context.Background(), req)
+	if err != nil {
+		t.Fatalf("failed to upsert records: %v", err)
+	}
+
+	if rsp.UpsertedCount != 2 || len(stg.rows) != 1 || stg.rows[0] != 2 {
+		t.Fatalf("expected a record batch of 2 records, got %v", stg.rows)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var ErrFailedToBuildArrowRecord = fmt.Errorf("failed to build arrow record")
+
+// arrowDataType will return the Arrow data type of a column. Timestamps are stored in nanoseconds since the epoch in
+// UTC, and objects or lists are stored as JSON-encoded strings.
+func arrowDataType(columnType ColumnType) arrow.DataType {
+	switch columnType {
+	case ColumnTypeNull:
+		return arrow.Null
+	case ColumnTypeBool:
+		return arrow.FixedWidthTypes.Boolean
+	case ColumnTypeInteger:
+		return arrow.PrimitiveTypes.Int64
+	case ColumnTypeFloat:
+		return arrow.PrimitiveTypes.Float64
+	case ColumnTypeTimestamp:
+		return arrow.FixedWidthTypes.Timestamp_ns
+	default:
+		return arrow.BinaryTypes.String
+	}
+}
+
+// ArrowSchema will return the Arrow schema of a set of inferred columns.
+func ArrowSchema(columns []*Column) *arrow.Schema {
+	fields := make([]arrow.Field, 0, len(columns))
+
+	for _, column := range columns {
+		fields = append(fields, arrow.Field{
+			Name:     column.Name,
+			Type:     arrowDataType(column.Type),
+			Nullable: column.Nullable || column.Type == ColumnTypeNull,
+		})
+	}
+
+	return arrow.NewSchema(fields, nil)
+}
+
+// stringValue will return the string representation of a value in a string or JSON column.
+func stringValue(val *structpb.Value) (string, error) {
+	if str, ok := val.GetKind().(*structpb.Value_StringValue); ok {
+		return str.StringValue, nil
+	}
+
+	bytes, err := json.Marshal(val.AsInterface())
+	if err != nil {
+		return "", fmt.Errorf("%v: %w", ErrFailedToMarshalJSON, err)
+	}
+
+	return string(bytes), nil
+}
+
+// appendArrowValue will append a single value to the builder of a column.
+func appendArrowValue(builder array.Builder, column *Column, val *structpb.Value) error {
+	if val == nil || valueColumnType(val) == ColumnTypeNull {
+		builder.AppendNull()
+
+		return nil
+	}
+
+	switch bldr := builder.(type) {
+	case *array.BooleanBuilder:
+		bldr.Append(val.GetBoolValue())
+	case *array.Int64Builder:
+		bldr.Append(int64(val.GetNumberValue()))
+	case *array.Float64Builder:
+		bldr.Append(val.GetNumberValue())
+	case *array.TimestampBuilder:
+		ts, err := time.Parse(time.RFC3339Nano, val.GetStringValue())
+		if err != nil {
+			return fmt.Errorf("unable to parse timestamp for column %q: %w", column.Name, err)
+		}
+
+		// Nanoseconds since the epoch only fit in an int64 between the years 1677 and 2262.
+		if ts.Before(time.Unix(0, math.MinInt64)) || ts.After(time.Unix(0, math.MaxInt64)) {
+			return fmt.Errorf("timestamp for column %q is out of range: %s", column.Name, val.GetStringValue())
+		}
+
+		bldr.Append(arrow.Timestamp(ts.UnixNano()))
+	case *array.StringBuilder:
+		str, err := stringValue(val)
+		if err != nil {
+			return err
+		}
+
+		bldr.Append(str)
+	default:
+		builder.AppendNull()
+	}
+
+	return nil
+}
+
+// NewArrowRecord will build an Arrow record batch from a set of records. The schema of the batch is inferred from the
+// records with "InferColumns", and missing fields are stored as nulls. The caller is responsible for releasing the
+// record.
+func NewArrowRecord(mem memory.Allocator, records []*structpb.Struct) (arrow.Record, error) {
+	columns := InferColumns(records)
+
+	builder := array.NewRecordBuilder(mem, ArrowSchema(columns))
+	defer builder.Release()
+
+	for _, record := range records {
+		fields := record.GetFields()
+
+		for idx, column := range columns {
+			if err := appendArrowValue(builder.Field(idx), column, fields[column.Name]); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrFailedToBuildArrowRecord, err)
+			}
+		}
+	}
+
+	return builder.NewRecord(), nil
+}
+
+// DecodeUpsertArrowRecord will decode the data of an upsert request into an Arrow record batch. The caller is
+// responsible for releasing the record.
+func DecodeUpsertArrowRecord(mem memory.Allocator, req *proto.UpsertRequest) (arrow.Record, error) {
+	records, err := DecodeUpsertRecords(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewArrowRecord(mem, records)
+}
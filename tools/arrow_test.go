@@ -0,0 +1,79 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alpine-hodler/gidari/proto"
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+)
+
+func TestDecodeUpsertArrowRecord(t *testing.T) {
+	t.Parallel()
+
+	mem := memory.NewCheckedAllocator(memory.NewGoAllocator())
+	defer mem.AssertSize(t, 0)
+
+	req := &proto.UpsertRequest{
+		Table: "candles",
+		Data: []byte(`[
+			{"id": 1, "price": 1.5, "time": "2022-05-10T00:00:00Z", "open": true, "meta": {"a": 1}},
+			{"id": 2, "price": 2, "time": "2022-05-10T00:01:00.123456789Z", "open": false}
+		]`),
+		DataType: int32(UpsertDataJSON),
+	}
+
+	rec, err := DecodeUpsertArrowRecord(mem, req)
+	if err != nil {
+		t.Fatalf("error decoding record batch: %v", err)
+	}
+
+	defer rec.Release()
+
+	if rec.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", rec.NumRows())
+	}
+
+	expected := map[string]arrow.DataType{
+		"id":    arrow.PrimitiveTypes.Int64,
+		"meta":  arrow.BinaryTypes.String,
+		"open":  arrow.FixedWidthTypes.Boolean,
+		"price": arrow.PrimitiveTypes.Float64,
+		"time":  arrow.FixedWidthTypes.Timestamp_ns,
+	}
+
+	schema := rec.Schema()
+	if len(schema.Fields()) != len(expected) {
+		t.Fatalf("expected %d fields, got %d", len(expected), len(schema.Fields()))
+	}
+
+	for _, field := range schema.Fields() {
+		if !arrow.TypeEqual(field.Type, expected[field.Name]) {
+			t.Errorf("field %q: expected type %v, got %v", field.Name, expected[field.Name], field.Type)
+		}
+	}
+
+	idx := schema.FieldIndices("meta")[0]
+	meta, ok := rec.Column(idx).(*array.String)
+
+	if !ok || meta.Value(0) != `{"a":1}` || !meta.IsNull(1) {
+		t.Errorf("expected meta to be JSON-encoded with a trailing null, got %v", rec.Column(idx))
+	}
+
+	idx = schema.FieldIndices("time")[0]
+	ts, ok := rec.Column(idx).(*array.Timestamp)
+
+	want := time.Date(2022, 5, 10, 0, 1, 0, 123456789, time.UTC).UnixNano()
+	if !ok || int64(ts.Value(1)) != want {
+		t.Errorf("expected time to be %d, got %v", want, rec.Column(idx))
+	}
+}
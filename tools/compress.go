@@ -0,0 +1,206 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// CompressedTextMarker prefixes the base64 encoded zstd frame of a compressed string.
+	CompressedTextMarker = "zstd:"
+
+	// CompressedJSONMarker prefixes the base64 encoded zstd frame of a compressed object or array, which is restored
+	// from its JSON when it is decompressed.
+	CompressedJSONMarker = "zstd+json:"
+)
+
+var ErrInvalidCompressedValue = fmt.Errorf("invalid compressed value")
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	errZstd     error
+)
+
+// zstdCodec will return the shared zstd encoder and decoder, which are safe for concurrent use with "EncodeAll" and
+// "DecodeAll".
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdOnce.Do(func() {
+		if zstdEncoder, errZstd = zstd.NewWriter(nil); errZstd != nil {
+			return
+		}
+
+		zstdDecoder, errZstd = zstd.NewReader(nil)
+	})
+
+	return zstdEncoder, zstdDecoder, errZstd
+}
+
+// compressValue will compress a string, or the JSON of an object or array, if it is at least "minSize" bytes and
+// compressing it saves space. The compressed value is a string that holds a marker and the base64 encoded zstd frame.
+func compressValue(value interface{}, minSize int) (interface{}, error) {
+	var (
+		text   []byte
+		marker string
+		force  bool
+	)
+
+	switch val := value.(type) {
+	case string:
+		// Strings that look compressed are always compressed, so that they are restored as they are when read.
+		text, marker = []byte(val), CompressedTextMarker
+		force = strings.HasPrefix(val, CompressedTextMarker) || strings.HasPrefix(val, CompressedJSONMarker)
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(val)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
+		}
+
+		text, marker = data, CompressedJSONMarker
+	default:
+		return value, nil
+	}
+
+	if len(text) < minSize && !force {
+		return value, nil
+	}
+
+	encoder, _, err := zstdCodec()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create zstd encoder: %w", err)
+	}
+
+	compressed := marker + base64.StdEncoding.EncodeToString(encoder.EncodeAll(text, nil))
+	if len(compressed) >= len(text) && !force {
+		return value, nil
+	}
+
+	return compressed, nil
+}
+
+// CompressRecords will compress the fields of every record of JSON encoded upsert data that hold strings, objects, or
+// arrays of at least "minSize" bytes, e.g. verbose payloads of web APIs. Values that do not get smaller are left as
+// they are. The data keeps its shape, i.e. a single record or a list of records. Use "DecompressRecords" with the
+// same fields to restore the values of read records.
+func CompressRecords(data []byte, fields []string, minSize int) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, field := range fields {
+			value, ok := rec[field]
+			if !ok {
+				continue
+			}
+
+			compressed, err := compressValue(value, minSize)
+			if err != nil {
+				return nil, err
+			}
+
+			rec[field] = compressed
+		}
+	}
+
+	compressed, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
+	}
+
+	return compressed, nil
+}
+
+// decompressString will restore a compressed string, returning false if it is not compressed.
+func decompressString(str string) (*structpb.Value, bool, error) {
+	marker := CompressedJSONMarker
+	if !strings.HasPrefix(str, marker) {
+		if marker = CompressedTextMarker; !strings.HasPrefix(str, marker) {
+			return nil, false, nil
+		}
+	}
+
+	frame, err := base64.StdEncoding.DecodeString(str[len(marker):])
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidCompressedValue, err)
+	}
+
+	_, decoder, err := zstdCodec()
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to create zstd decoder: %w", err)
+	}
+
+	text, err := decoder.DecodeAll(frame, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidCompressedValue, err)
+	}
+
+	if marker == CompressedTextMarker {
+		return structpb.NewStringValue(string(text)), true, nil
+	}
+
+	value := new(structpb.Value)
+	if err := value.UnmarshalJSON(text); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrInvalidCompressedValue, err)
+	}
+
+	return value, true, nil
+}
+
+// DecompressRecords will restore the compressed values of the fields of read records in place. Only the fields that
+// are configured as compressed, i.e. the fields of the request's "compress" configuration, are restored, so that other
+// strings that happen to start with a marker are left as they are.
+func DecompressRecords(records []*structpb.Struct, fields []string) error {
+	for _, record := range records {
+		for _, field := range fields {
+			str, ok := record.GetFields()[field].GetKind().(*structpb.Value_StringValue)
+			if !ok {
+				continue
+			}
+
+			restored, ok, err := decompressString(str.StringValue)
+			if err != nil {
+				return fmt.Errorf("unable to decompress %q: %w", field, err)
+			}
+
+			if ok {
+				record.Fields[field] = restored
+			}
+		}
+	}
+
+	return nil
+}
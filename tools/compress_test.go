@@ -0,0 +1,96 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestCompressRecords(t *testing.T) {
+	t.Parallel()
+
+	payload := strings.Repeat("verbose ", 200)
+
+	data, err := json.Marshal([]interface{}{
+		map[string]interface{}{
+			"id": 1, "body": payload, "raw": map[string]interface{}{"text": payload}, "note": "zstd: not compressed",
+		},
+		map[string]interface{}{"id": 2, "body": "short"},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal data: %v", err)
+	}
+
+	compressed, err := CompressRecords(data, []string{"body", "raw"}, 64)
+	if err != nil {
+		t.Fatalf("failed to compress records: %v", err)
+	}
+
+	if len(compressed) >= len(data) {
+		t.Fatalf("expected compressed data to be smaller than %d bytes, got %d", len(data), len(compressed))
+	}
+
+	var list []map[string]interface{}
+	if err := json.Unmarshal(compressed, &list); err != nil {
+		t.Fatalf("failed to unmarshal compressed data: %v", err)
+	}
+
+	if body, _ := list[0]["body"].(string); !strings.HasPrefix(body, CompressedTextMarker) {
+		t.Fatalf("expected body to be compressed, got %q", body)
+	}
+
+	if raw, _ := list[0]["raw"].(string); !strings.HasPrefix(raw, CompressedJSONMarker) {
+		t.Fatalf("expected raw to be compressed, got %v", list[0]["raw"])
+	}
+
+	if list[1]["body"] != "short" {
+		t.Fatalf("expected short body to be left as is, got %v", list[1]["body"])
+	}
+
+	records := make([]*structpb.Struct, len(list))
+	for idx, rec := range list {
+		if records[idx], err = structpb.NewStruct(rec); err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+	}
+
+	if err := DecompressRecords(records, []string{"body", "raw"}); err != nil {
+		t.Fatalf("failed to decompress records: %v", err)
+	}
+
+	if body := records[0].Fields["body"].GetStringValue(); body != payload {
+		t.Fatalf("expected body to be restored, got %q", body)
+	}
+
+	if text := records[0].Fields["raw"].GetStructValue().GetFields()["text"].GetStringValue(); text != payload {
+		t.Fatalf("expected raw to be restored, got %v", records[0].Fields["raw"])
+	}
+
+	// Fields that are not compressed are left as they are, even if they look compressed.
+	if note := records[0].Fields["note"].GetStringValue(); note != "zstd: not compressed" {
+		t.Fatalf("expected note to be left as is, got %q", note)
+	}
+}
+
+func TestDecompressRecordsInvalid(t *testing.T) {
+	t.Parallel()
+
+	record, err := structpb.NewStruct(map[string]interface{}{"body": CompressedTextMarker + "not base64!"})
+	if err != nil {
+		t.Fatalf("failed to create struct: %v", err)
+	}
+
+	if err := DecompressRecords([]*structpb.Struct{record}, []string{"body"}); !errors.Is(err, ErrInvalidCompressedValue) {
+		t.Fatalf("expected error %v, got %v", ErrInvalidCompressedValue, err)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SQLDialect is a dialect of SQL used to generate DDL.
+type SQLDialect string
+
+const (
+	// SQLDialectPostgres is the Postgres dialect of SQL.
+	SQLDialectPostgres SQLDialect = "postgres"
+
+	// SQLDialectMySQL is the MySQL dialect of SQL.
+	SQLDialectMySQL SQLDialect = "mysql"
+)
+
+var ErrUnsupportedSQLDialect = fmt.Errorf("unsupported sql dialect")
+
+// ddlPrimaryKeyCandidates are the column names that are assumed to be a primary key, in order of preference.
+var ddlPrimaryKeyCandidates = []string{"id", "_id", "uuid", "key"}
+
+// quoteIdentifier will quote a SQL identifier for the dialect.
+func (dialect SQLDialect) quoteIdentifier(name string) string {
+	if dialect == SQLDialectMySQL {
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	}
+
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// columnType will return the SQL type of a column for the dialect. MySQL cannot index "TEXT" columns without a prefix
+// length, so primary key strings are stored as "VARCHAR".
+func (dialect SQLDialect) columnType(column *Column, primaryKey bool) string {
+	postgres := map[ColumnType]string{
+		ColumnTypeNull:      "TEXT",
+		ColumnTypeBool:      "BOOLEAN",
+		ColumnTypeInteger:   "BIGINT",
+		ColumnTypeFloat:     "DOUBLE PRECISION",
+		ColumnTypeTimestamp: "TIMESTAMPTZ",
+		ColumnTypeString:    "TEXT",
+		ColumnTypeJSON:      "JSONB",
+		ColumnTypeDecimal:   "NUMERIC",
+	}
+
+	mysql := map[ColumnType]string{
+		ColumnTypeNull:      "TEXT",
+		ColumnTypeBool:      "BOOLEAN",
+		ColumnTypeInteger:   "BIGINT",
+		ColumnTypeFloat:     "DOUBLE",
+		ColumnTypeTimestamp: "DATETIME(6)",
+		ColumnTypeString:    "TEXT",
+		ColumnTypeJSON:      "JSON",
+		ColumnTypeDecimal:   "DECIMAL(65,30)",
+	}
+
+	if dialect == SQLDialectMySQL {
+		if primaryKey && (column.Type == ColumnTypeString || column.Type == ColumnTypeNull) {
+			return "VARCHAR(255)"
+		}
+
+		return mysql[column.Type]
+	}
+
+	return postgres[column.Type]
+}
+
+// InferPrimaryKey will return the name of the column that is most likely the primary key of the columns, or an empty
+// string if no non-nullable candidate exists.
+func InferPrimaryKey(columns []*Column) string {
+	for _, candidate := range ddlPrimaryKeyCandidates {
+		for _, column := range columns {
+			if strings.EqualFold(column.Name, candidate) && !column.Nullable {
+				return column.Name
+			}
+		}
+	}
+
+	return ""
+}
+
+// SQLCreateTable will return a "CREATE TABLE" statement for the columns in the given dialect, along with warnings
+// about the statement to review. If no primary keys are given, the primary key is inferred from the column names.
+// Gidari upserts into SQL storage require a primary key, so a warning is returned if one cannot be inferred. Only the
+// primary key columns are "NOT NULL": columns are inferred from a sample of the records, and a field that is present
+// in every sampled record may still be missing from later ones.
+func SQLCreateTable(dialect SQLDialect, table string, columns []*Column, primaryKeys ...string,
+) (string, []string, error) {
+	if dialect != SQLDialectPostgres && dialect != SQLDialectMySQL {
+		return "", nil, fmt.Errorf("%w: %s", ErrUnsupportedSQLDialect, dialect)
+	}
+
+	if len(primaryKeys) == 0 {
+		if pk := InferPrimaryKey(columns); pk != "" {
+			primaryKeys = []string{pk}
+		}
+	}
+
+	isPK := make(map[string]bool, len(primaryKeys))
+	for _, pk := range primaryKeys {
+		isPK[pk] = true
+	}
+
+	var warnings []string
+	if len(primaryKeys) == 0 {
+		warnings = append(warnings, fmt.Sprintf("no primary key could be inferred for %q, upserts require one", table))
+	}
+
+	var bldr strings.Builder
+
+	bldr.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n", dialect.quoteIdentifier(table)))
+
+	defs := make([]string, 0, len(columns)+1)
+
+	for _, column := range columns {
+		def := fmt.Sprintf("  %s %s", dialect.quoteIdentifier(column.Name), dialect.columnType(column, isPK[column.Name]))
+		if isPK[column.Name] {
+			def += " NOT NULL"
+		}
+
+		defs = append(defs, def)
+	}
+
+	if len(primaryKeys) > 0 {
+		quoted := make([]string, 0, len(primaryKeys))
+		for _, pk := range primaryKeys {
+			quoted = append(quoted, dialect.quoteIdentifier(pk))
+		}
+
+		defs = append(defs, fmt.Sprintf("  PRIMARY KEY (%s)", strings.Join(quoted, ", ")))
+	}
+
+	bldr.WriteString(strings.Join(defs, ",\n"))
+	bldr.WriteString("\n);\n")
+
+	return bldr.String(), warnings, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidDecimal = fmt.Errorf("invalid decimal")
+
+// decimalPattern matches the text of a decimal number, optionally with an exponent.
+var decimalPattern = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?$`)
+
+// IsDecimal will return true if the string is the text of a decimal number, e.g. "-1.50" or "2.5e-8".
+func IsDecimal(str string) bool {
+	return decimalPattern.MatchString(str)
+}
+
+// DecimalRecords will encode the decimal fields on every record of JSON encoded upsert data as strings that hold their
+// exact text, so that they are not rounded by a conversion to float64 when the records are decoded. Fields that are
+// already strings are validated, and null or missing fields are left as they are. The data keeps its shape, i.e. a
+// single record or a list of records.
+func DecimalRecords(data []byte, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, field := range fields {
+			switch value := rec[field].(type) {
+			case nil:
+			case json.Number:
+				rec[field] = value.String()
+			case string:
+				if !IsDecimal(strings.TrimSpace(value)) {
+					return nil, fmt.Errorf("%w for %q: %q", ErrInvalidDecimal, field, value)
+				}
+
+				rec[field] = strings.TrimSpace(value)
+			default:
+				return nil, fmt.Errorf("%w for %q: %v", ErrInvalidDecimal, field, value)
+			}
+		}
+	}
+
+	decimalData, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
+	}
+
+	return decimalData, nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecimalRecords(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		data     string
+		expected string
+		err      error
+	}{
+		{
+			name:     "numbers keep their text",
+			data:     `[{"price":0.1000000000000000055511151231257827,"size":1},{"price":null}]`,
+			expected: `[{"price":"0.1000000000000000055511151231257827","size":1},{"price":null}]`,
+		},
+		{
+			name:     "strings",
+			data:     `{"price":" 29348.15000000 "}`,
+			expected: `{"price":"29348.15000000"}`,
+		},
+		{
+			name: "not a decimal",
+			data: `{"price":"1,000"}`,
+			err:  ErrInvalidDecimal,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := DecimalRecords([]byte(tcase.data), []string{"price"})
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err == nil && string(data) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// maxSafeInteger is 2^53, the largest magnitude below which every integer is exactly representable as a float64.
+const maxSafeInteger = 1 << 53
+
+// maxSafeIntegerDigits is the number of digits of maxSafeInteger. Integers with fewer digits are always safe.
+const maxSafeIntegerDigits = 16
+
+// IsLargeInteger will return true if a JSON number is an integer whose magnitude exceeds 2^53, e.g. a snowflake ID or
+// an unsigned 64-bit or 128-bit integer, which a conversion to float64 would round.
+func IsLargeInteger(number json.Number) bool {
+	str := number.String()
+	if strings.ContainsAny(str, ".eE") {
+		return false
+	}
+
+	value, err := strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		// Integers that overflow an int64 are large.
+		return true
+	}
+
+	return value > maxSafeInteger || value < -maxSafeInteger
+}
+
+// hasLongDigits will return true if data has a run of digits at least as long as a large integer, so that data without
+// large integers is not decoded.
+func hasLongDigits(data []byte) bool {
+	var run int
+
+	for _, char := range data {
+		if char < '0' || char > '9' {
+			run = 0
+
+			continue
+		}
+
+		if run++; run >= maxSafeIntegerDigits {
+			return true
+		}
+	}
+
+	return false
+}
+
+// largeIntegerStrings will replace the large integers of a decoded JSON value with strings of their digits, returning
+// the value and whether any integer was replaced.
+func largeIntegerStrings(value interface{}) (interface{}, bool) {
+	var replaced bool
+
+	switch value := value.(type) {
+	case json.Number:
+		if IsLargeInteger(value) {
+			return value.String(), true
+		}
+	case map[string]interface{}:
+		for key, val := range value {
+			var ok bool
+			if value[key], ok = largeIntegerStrings(val); ok {
+				replaced = true
+			}
+		}
+	case []interface{}:
+		for idx, val := range value {
+			var ok bool
+			if value[idx], ok = largeIntegerStrings(val); ok {
+				replaced = true
+			}
+		}
+	}
+
+	return value, replaced
+}
+
+// LargeIntegerRecords will encode the integers of JSON encoded upsert data whose magnitude exceeds 2^53 as strings
+// that hold their exact digits, at any depth of the records, so that they are not rounded by a conversion to float64
+// when the records are decoded. Data without large integers is returned as it is. Other data is decoded and encoded
+// with the JSON implementation of the data type.
+func LargeIntegerRecords(dataType UpsertDataType, data []byte) ([]byte, error) {
+	if !hasLongDigits(data) {
+		return data, nil
+	}
+
+	decoded, err := dataType.DecodeNumbers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, replaced := largeIntegerStrings(decoded)
+	if !replaced {
+		return data, nil
+	}
+
+	return dataType.Marshal(decoded)
+}
@@ -0,0 +1,78 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"testing"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+func TestLargeIntegerRecords(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name:     "snowflake ids",
+			data:     `[{"id":1541815603606036480,"size":1},{"id":-9007199254740993}]`,
+			expected: `[{"id":"1541815603606036480","size":1},{"id":"-9007199254740993"}]`,
+		},
+		{
+			name:     "unsigned and 128-bit integers at any depth",
+			data:     `{"user":{"ids":[18446744073709551615,170141183460469231731687303715884105727]}}`,
+			expected: `{"user":{"ids":["18446744073709551615","170141183460469231731687303715884105727"]}}`,
+		},
+		{
+			name:     "safe integers and floats are kept",
+			data:     `{"b":9007199254740992,"a":1234567890123456.5}`,
+			expected: `{"b":9007199254740992,"a":1234567890123456.5}`,
+		},
+		{
+			name:     "short numbers are not decoded",
+			data:     `{"id": 1}`,
+			expected: `{"id": 1}`,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			// The fast decoder encodes the records the same.
+			for _, dataType := range []UpsertDataType{UpsertDataJSON, UpsertDataFastJSON} {
+				data, err := LargeIntegerRecords(dataType, []byte(tcase.data))
+				if err != nil {
+					t.Fatalf("unable to encode large integers: %v", err)
+				}
+
+				if string(data) != tcase.expected {
+					t.Fatalf("expected %s with data type %d, got %s", tcase.expected, dataType, data)
+				}
+			}
+		})
+	}
+
+	// The digits survive the decoding of the upsert records.
+	data, err := LargeIntegerRecords(UpsertDataJSON, []byte(`{"id":1541815603606036481}`))
+	if err != nil {
+		t.Fatalf("unable to encode large integers: %v", err)
+	}
+
+	records, err := DecodeUpsertRecords(&proto.UpsertRequest{Data: data})
+	if err != nil {
+		t.Fatalf("unable to decode records: %v", err)
+	}
+
+	if id := records[0].GetFields()["id"].GetStringValue(); id != "1541815603606036481" {
+		t.Fatalf("expected the id to be kept, got %q", id)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var ErrIncompleteKey = fmt.Errorf("record is missing a key field")
+
+// CompositeKey builds deterministic keys of records from their key fields, e.g. to deduplicate records by their
+// primary keys or to identify a record on a message broker. A key is the JSON encoding of the values of the fields,
+// separated by commas, e.g. `"BTC-USD",1652140800`. Numbers are encoded the same whether they were decoded as
+// floating-point numbers or as JSON numbers, so that the numbers 1, 1.0, and 1e0 are the same key, and integers that
+// exceed 2^53 keep their exact digits if they were decoded as JSON numbers.
+type CompositeKey struct {
+	// Fields are the fields of the key, in order.
+	Fields []string
+
+	// Sorted orders the fields by name, so that keys of the same fields listed in another order are equal.
+	Sorted bool
+
+	// IgnoreCase lowercases the strings of the values, so that keys that only differ by case are equal.
+	IgnoreCase bool
+
+	// AllowNulls encodes the fields that are missing or null as null. By default, a record without a value for every
+	// field does not have a key, and ErrIncompleteKey is returned.
+	AllowNulls bool
+}
+
+// Key will return the key of a record.
+func (ck CompositeKey) Key(record map[string]interface{}) (string, error) {
+	return ck.encode(func(field string) interface{} { return record[field] })
+}
+
+// StructKey will return the key of a struct record.
+func (ck CompositeKey) StructKey(record *structpb.Struct) (string, error) {
+	return ck.encode(func(field string) interface{} { return record.GetFields()[field].AsInterface() })
+}
+
+// encode will return the key of the values of the fields.
+func (ck CompositeKey) encode(value func(field string) interface{}) (string, error) {
+	fields := ck.Fields
+	if ck.Sorted {
+		fields = append([]string{}, fields...)
+		sort.Strings(fields)
+	}
+
+	values := make([]string, len(fields))
+
+	for idx, field := range fields {
+		val := value(field)
+		if val == nil && !ck.AllowNulls {
+			return "", fmt.Errorf("%w: %q", ErrIncompleteKey, field)
+		}
+
+		encoded, err := json.Marshal(normalizeKeyValue(val, ck.IgnoreCase))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
+		}
+
+		values[idx] = string(encoded)
+	}
+
+	return strings.Join(values, ","), nil
+}
+
+// HashKey will return the hex encoded SHA-256 hash of a key, e.g. to use a key of any length as an identifier.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeKeyValue will normalize a value of a key so that equal values are encoded the same: numbers are encoded
+// by their value, and strings are lowercased if the case is ignored. Objects and arrays are normalized recursively,
+// and the fields of objects are encoded in sorted order.
+func normalizeKeyValue(value interface{}, ignoreCase bool) interface{} {
+	switch value := value.(type) {
+	case string:
+		if ignoreCase {
+			return strings.ToLower(value)
+		}
+	case float64:
+		return keyNumber(value)
+	case float32:
+		return keyNumber(float64(value))
+	case int:
+		return json.Number(strconv.FormatInt(int64(value), 10))
+	case int32:
+		return json.Number(strconv.FormatInt(int64(value), 10))
+	case int64:
+		return json.Number(strconv.FormatInt(value, 10))
+	case uint64:
+		return json.Number(strconv.FormatUint(value, 10))
+	case json.Number:
+		if isIntegerLiteral(string(value)) {
+			return value
+		}
+
+		if number, err := value.Float64(); err == nil {
+			return keyNumber(number)
+		}
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(value))
+		for key, val := range value {
+			normalized[key] = normalizeKeyValue(val, ignoreCase)
+		}
+
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(value))
+		for idx, val := range value {
+			normalized[idx] = normalizeKeyValue(val, ignoreCase)
+		}
+
+		return normalized
+	}
+
+	return value
+}
+
+// keyNumber will return the encoding of a number in a key. Integers are encoded with all of their digits, rather
+// than in exponent notation.
+func keyNumber(number float64) interface{} {
+	if number == math.Trunc(number) && !math.IsInf(number, 0) {
+		if number == 0 {
+			// Negative zero is the same key as zero.
+			return json.Number("0")
+		}
+
+		return json.Number(strconv.FormatFloat(number, 'f', -1, 64))
+	}
+
+	return number
+}
+
+// isIntegerLiteral will return true if a JSON number is an integer without a fraction or an exponent, e.g. "-12".
+func isIntegerLiteral(number string) bool {
+	digits := strings.TrimPrefix(number, "-")
+	if digits == "" || (digits == "0" && number != digits) {
+		return false
+	}
+
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
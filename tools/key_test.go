@@ -0,0 +1,228 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestCompositeKey(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		key      CompositeKey
+		record   map[string]interface{}
+		expected string
+		err      error
+	}{
+		{
+			name:     "fields in order",
+			key:      CompositeKey{Fields: []string{"time", "product"}},
+			record:   map[string]interface{}{"product": "BTC-USD", "time": 1652140800.0, "price": 1.5},
+			expected: `1652140800,"BTC-USD"`,
+		},
+		{
+			name:     "sorted fields",
+			key:      CompositeKey{Fields: []string{"time", "product"}, Sorted: true},
+			record:   map[string]interface{}{"product": "BTC-USD", "time": 1652140800.0},
+			expected: `"BTC-USD",1652140800`,
+		},
+		{
+			name:     "case",
+			key:      CompositeKey{Fields: []string{"product"}},
+			record:   map[string]interface{}{"product": "BTC-USD"},
+			expected: `"BTC-USD"`,
+		},
+		{
+			name:     "ignored case",
+			key:      CompositeKey{Fields: []string{"product", "tags"}, IgnoreCase: true},
+			record:   map[string]interface{}{"product": "BTC-USD", "tags": []interface{}{"Spot"}},
+			expected: `"btc-usd",["spot"]`,
+		},
+		{
+			name:   "missing field",
+			key:    CompositeKey{Fields: []string{"product", "time"}},
+			record: map[string]interface{}{"product": "BTC-USD"},
+			err:    ErrIncompleteKey,
+		},
+		{
+			name:   "null field",
+			key:    CompositeKey{Fields: []string{"product", "time"}},
+			record: map[string]interface{}{"product": "BTC-USD", "time": nil},
+			err:    ErrIncompleteKey,
+		},
+		{
+			name:     "allowed nulls",
+			key:      CompositeKey{Fields: []string{"product", "time", "venue"}, AllowNulls: true},
+			record:   map[string]interface{}{"product": "BTC-USD", "time": nil},
+			expected: `"BTC-USD",null,null`,
+		},
+		{
+			name:     "no fields",
+			key:      CompositeKey{},
+			record:   map[string]interface{}{"product": "BTC-USD"},
+			expected: "",
+		},
+		{
+			name:     "commas in strings",
+			key:      CompositeKey{Fields: []string{"a", "b"}},
+			record:   map[string]interface{}{"a": `x","y`, "b": "z"},
+			expected: `"x\",\"y","z"`,
+		},
+		{
+			name:     "nested values",
+			key:      CompositeKey{Fields: []string{"id"}},
+			record:   map[string]interface{}{"id": map[string]interface{}{"b": 2.0, "a": json.Number("1.0")}},
+			expected: `{"a":1,"b":2}`,
+		},
+		{
+			name:   "unencodable value",
+			key:    CompositeKey{Fields: []string{"id"}},
+			record: map[string]interface{}{"id": math.NaN()},
+			err:    ErrFailedToMarshalJSON,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := tcase.key.Key(tcase.record)
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if key != tcase.expected {
+				t.Fatalf("expected key %s, got %s", tcase.expected, key)
+			}
+		})
+	}
+}
+
+func TestCompositeKeyNumbers(t *testing.T) {
+	t.Parallel()
+
+	key := CompositeKey{Fields: []string{"id"}}
+
+	for _, tcase := range []struct {
+		name     string
+		values   []interface{}
+		expected string
+	}{
+		{
+			name: "integers",
+			values: []interface{}{
+				1, int32(1), int64(1), uint64(1), 1.0, float32(1), json.Number("1"), json.Number("1.0"),
+				json.Number("1e0"),
+			},
+			expected: "1",
+		},
+		{
+			name:     "zero",
+			values:   []interface{}{0, 0.0, math.Copysign(0, -1), json.Number("-0"), json.Number("0.0")},
+			expected: "0",
+		},
+		{
+			name:     "negative",
+			values:   []interface{}{-12, -12.0, json.Number("-12"), json.Number("-1.2e1")},
+			expected: "-12",
+		},
+		{
+			name:     "fractions",
+			values:   []interface{}{1.5, float32(1.5), json.Number("1.5"), json.Number("15e-1")},
+			expected: "1.5",
+		},
+		{
+			name:     "large integers",
+			values:   []interface{}{1e21, json.Number("1000000000000000000000"), json.Number("1e21")},
+			expected: "1000000000000000000000",
+		},
+		{
+			name:     "integers beyond float precision",
+			values:   []interface{}{json.Number("9007199254740993")},
+			expected: "9007199254740993",
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, value := range tcase.values {
+				got, err := key.Key(map[string]interface{}{"id": value})
+				if err != nil {
+					t.Fatalf("failed to build key of %T %v: %v", value, value, err)
+				}
+
+				if got != tcase.expected {
+					t.Fatalf("expected key of %T %v to be %s, got %s", value, value, tcase.expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestCompositeStructKey(t *testing.T) {
+	t.Parallel()
+
+	fields := map[string]interface{}{"product": "BTC-USD", "time": 1652140800.0, "open": nil}
+
+	record, err := structpb.NewStruct(fields)
+	if err != nil {
+		t.Fatalf("failed to create struct: %v", err)
+	}
+
+	for _, key := range []CompositeKey{
+		{Fields: []string{"product", "time"}},
+		{Fields: []string{"time", "product"}, Sorted: true, IgnoreCase: true},
+		{Fields: []string{"product", "open", "close"}, AllowNulls: true},
+	} {
+		expected, err := key.Key(fields)
+		if err != nil {
+			t.Fatalf("failed to build key: %v", err)
+		}
+
+		got, err := key.StructKey(record)
+		if err != nil {
+			t.Fatalf("failed to build struct key: %v", err)
+		}
+
+		if got != expected {
+			t.Fatalf("expected the struct key to be the record's key %s, got %s", expected, got)
+		}
+	}
+
+	if _, err := (CompositeKey{Fields: []string{"open"}}).StructKey(record); !errors.Is(err, ErrIncompleteKey) {
+		t.Fatalf("expected a null field to be incomplete, got %v", err)
+	}
+
+	if _, err := (CompositeKey{Fields: []string{"product"}}).StructKey(nil); !errors.Is(err, ErrIncompleteKey) {
+		t.Fatalf("expected a nil record to be incomplete, got %v", err)
+	}
+}
+
+func TestHashKey(t *testing.T) {
+	t.Parallel()
+
+	const empty = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	if hash := HashKey(""); hash != empty {
+		t.Fatalf("expected the hex encoded SHA-256 hash %s, got %s", empty, hash)
+	}
+
+	hash := HashKey(`"BTC-USD",1652140800`)
+	if hash != HashKey(`"BTC-USD",1652140800`) || hash == HashKey(`"ETH-USD",1652140800`) {
+		t.Fatalf("expected the hash to only depend on the key")
+	}
+}
@@ -22,6 +22,11 @@ type LogFormatter struct {
 	Msg           string
 	UpsertedCount int64
 	MatchedCount  int64
+
+	InsertedCount  int64
+	UpdatedCount   int64
+	UnchangedCount int64
+	DeletedCount   int64
 }
 
 const (
@@ -45,6 +50,18 @@ const (
 
 	// LogFormmaterMatchedCount the label of the matched count.
 	LogFormatterMatchedCount = "c"
+
+	// LogFormatterInsertedCount the label of the inserted count.
+	LogFormatterInsertedCount = "ins"
+
+	// LogFormatterUpdatedCount the label of the updated count.
+	LogFormatterUpdatedCount = "upd"
+
+	// LogFormatterUnchangedCount the label of the unchanged count.
+	LogFormatterUnchangedCount = "unc"
+
+	// LogFormatterDeletedCount the label of the deleted count.
+	LogFormatterDeletedCount = "del"
 )
 
 // String uses the data from the LogFormatter object to build a log message.
@@ -75,6 +92,22 @@ func (lf LogFormatter) String() string {
 		bldr.WriteString(fmt.Sprintf("%s:%d, ", LogFormatterMatchedCount, lf.MatchedCount))
 	}
 
+	if lf.InsertedCount > 0 {
+		bldr.WriteString(fmt.Sprintf("%s:%d, ", LogFormatterInsertedCount, lf.InsertedCount))
+	}
+
+	if lf.UpdatedCount > 0 {
+		bldr.WriteString(fmt.Sprintf("%s:%d, ", LogFormatterUpdatedCount, lf.UpdatedCount))
+	}
+
+	if lf.UnchangedCount > 0 {
+		bldr.WriteString(fmt.Sprintf("%s:%d, ", LogFormatterUnchangedCount, lf.UnchangedCount))
+	}
+
+	if lf.DeletedCount > 0 {
+		bldr.WriteString(fmt.Sprintf("%s:%d, ", LogFormatterDeletedCount, lf.DeletedCount))
+	}
+
 	if lf.Msg != "" {
 		bldr.WriteString(fmt.Sprintf("%s:%s, ", LogFormatterMsg, lf.Msg))
 	}
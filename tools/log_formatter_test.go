@@ -70,6 +70,13 @@ func TestLogFormatter(t *testing.T) {
 			t.Errorf("expected '{c:1}', got '%s'", lf.String())
 		}
 	})
+	t.Run("changed counts", func(t *testing.T) {
+		t.Parallel()
+		lf := LogFormatter{InsertedCount: 1, UpdatedCount: 2, UnchangedCount: 3, DeletedCount: 4}
+		if lf.String() != "{ins:1, upd:2, unc:3, del:4}" {
+			t.Errorf("expected '{ins:1, upd:2, unc:3, del:4}', got '%s'", lf.String())
+		}
+	})
 	t.Run("all", func(t *testing.T) {
 		t.Parallel()
 		lf := LogFormatter{
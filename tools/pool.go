@@ -0,0 +1,90 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"bytes"
+	"io"
+	"sync"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// maxPooledBuffer is the largest capacity of a buffer that is returned to the pool. Larger buffers, e.g. of an
+// unusually large response, are left to the garbage collector so that the pool does not hold on to them.
+const maxPooledBuffer = 16 << 20
+
+// bufferPool holds the byte buffers that are reused through the decode and upsert path, e.g. to read response bodies
+// and to encode records, which would otherwise be allocated for every response and record of a long backfill.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer will return an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	buf, _ := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	return buf
+}
+
+// putBuffer will return a buffer to the pool. The buffer's bytes must no longer be referenced.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+
+	bufferPool.Put(buf)
+}
+
+// recordMapPool holds the maps that records are converted to, e.g. to marshal them as BSON documents or to flatten
+// them into SQL arguments.
+var recordMapPool = sync.Pool{
+	New: func() interface{} {
+		return make(map[string]interface{})
+	},
+}
+
+// getRecordMap will return a map of the fields of a record, like record.AsMap, from the pool. The map must be returned
+// with putRecordMap once it is no longer referenced, while its values may still be.
+func getRecordMap(record *structpb.Struct) map[string]interface{} {
+	hash, _ := recordMapPool.Get().(map[string]interface{})
+	for key, value := range record.GetFields() {
+		hash[key] = value.AsInterface()
+	}
+
+	return hash
+}
+
+// putRecordMap will clear a record map and return it to the pool.
+func putRecordMap(hash map[string]interface{}) {
+	for key := range hash {
+		delete(hash, key)
+	}
+
+	recordMapPool.Put(hash)
+}
+
+// ReadAll will read from r until EOF, like io.ReadAll, into a pooled buffer and return a copy of exactly the bytes
+// read. Unlike io.ReadAll, the buffer is not grown again for every response, so only the returned bytes are
+// allocated.
+func ReadAll(r io.Reader) ([]byte, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, buf.Len())
+	copy(data, buf.Bytes())
+
+	return data, nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestReadAll(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name string
+		data string
+	}{
+		{name: "empty", data: ""},
+		{name: "small", data: `[{"id":1}]`},
+		{name: "large", data: strings.Repeat(`{"id":1},`, 1<<16)},
+		{name: "larger than the pooled buffers", data: strings.Repeat("x", maxPooledBuffer+1)},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			first, err := ReadAll(strings.NewReader(tcase.data))
+			if err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+
+			// Reading again reuses the pooled buffer, which must not change the bytes that were returned before.
+			if _, err := ReadAll(strings.NewReader(strings.ToUpper(tcase.data))); err != nil {
+				t.Fatalf("failed to read: %v", err)
+			}
+
+			if string(first) != tcase.data || first == nil {
+				t.Fatalf("expected %d bytes of the data, got %d", len(tcase.data), len(first))
+			}
+		})
+	}
+}
+
+func TestRecordMap(t *testing.T) {
+	t.Parallel()
+
+	for _, fields := range []map[string]interface{}{
+		{"id": 1.0, "name": "a", "tags": []interface{}{"x"}},
+		{"id": 2.0},
+		{},
+	} {
+		record, err := structpb.NewStruct(fields)
+		if err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+
+		hash := getRecordMap(record)
+		if !reflect.DeepEqual(hash, record.AsMap()) {
+			t.Fatalf("expected %v, got %v", record.AsMap(), hash)
+		}
+
+		putRecordMap(hash)
+	}
+}
+
+func TestAssingRecordBSONDocument(t *testing.T) {
+	t.Parallel()
+
+	records := []map[string]interface{}{
+		{"id": 1.0, "name": strings.Repeat("a", 1<<10), "nested": map[string]interface{}{"ok": true}},
+		{"id": 2.0, "name": "b"},
+	}
+
+	docs := make([]bson.D, len(records))
+
+	for i, fields := range records {
+		record, err := structpb.NewStruct(fields)
+		if err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+
+		if err := AssingRecordBSONDocument(record, &docs[i]); err != nil {
+			t.Fatalf("failed to assign document: %v", err)
+		}
+	}
+
+	// The documents must not share the pooled buffer that they were marshaled into.
+	for i, fields := range records {
+		expected, err := bson.Marshal(fields)
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+
+		got, err := bson.Marshal(docs[i])
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+
+		if !bytes.Equal(bson.Raw(expected).Lookup("name").Value, bson.Raw(got).Lookup("name").Value) {
+			t.Fatalf("expected document %v, got %v", bson.Raw(expected), bson.Raw(got))
+		}
+	}
+}
@@ -8,6 +8,7 @@
 package tools
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -17,6 +18,7 @@ import (
 	"strconv"
 
 	"github.com/alpine-hodler/gidari/proto"
+	gojson "github.com/goccy/go-json"
 	"go.mongodb.org/mongo-driver/bson"
 	"google.golang.org/protobuf/types/known/structpb"
 )
@@ -41,11 +43,22 @@ type Encoder interface {
 }
 
 func AssingRecordBSONDocument(req *structpb.Struct, doc *bson.D) error {
-	data, err := bson.Marshal(req.AsMap())
+	hash := getRecordMap(req)
+	defer putRecordMap(hash)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	// The document is marshaled into the pooled buffer. Unmarshaling copies its values, since a record holds no
+	// binary values, so the buffer can be reused once the document is assigned.
+	data, err := bson.MarshalAppend(buf.Bytes(), hash)
 	if err != nil {
 		return fmt.Errorf("%v: %w", ErrFailedToMarshalBSON, err)
 	}
 
+	// The buffer keeps the capacity that marshaling grew it to.
+	*buf = *bytes.NewBuffer(data[:0])
+
 	err = bson.Unmarshal(data, doc)
 	if err != nil {
 		return fmt.Errorf("%v: %w", ErrFailedToUnmarshalBSON, err)
@@ -244,17 +257,24 @@ func decodeRecords(data interface{}) ([]*structpb.Struct, error) {
 		return nil, fmt.Errorf("%w: %v", ErrUnsupportedDataType, dataValue.Kind())
 	}
 
-	records := make([]*structpb.Struct, 0)
+	records := make([]*structpb.Struct, 0, len(out))
+
+	// Every record is encoded into the same pooled buffer, since the struct does not reference the encoded bytes.
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	encoder := json.NewEncoder(buf)
 
 	for _, r := range out {
-		record, err := json.Marshal(r)
-		if err != nil {
+		buf.Reset()
+
+		if err := encoder.Encode(r); err != nil {
 			return nil, fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
 		}
 
 		rec := new(structpb.Struct)
 
-		err = rec.UnmarshalJSON(record)
+		err := rec.UnmarshalJSON(buf.Bytes())
 		if err != nil {
 			return nil, fmt.Errorf("%w: %v", ErrFailedToUnmarshalJSON, err)
 		}
@@ -271,25 +291,104 @@ type UpsertDataType uint8
 const (
 	// UpsertDataJSON is the default upsert data type.
 	UpsertDataJSON UpsertDataType = iota
+
+	// UpsertDataFastJSON is JSON data that is decoded with github.com/goccy/go-json instead of "encoding/json", which
+	// is faster. The records decode the same.
+	UpsertDataFastJSON
 )
 
+// DecodeNumbers will decode JSON data with the decoder of the data type, keeping its numbers as json.Number so that
+// they are not rounded.
+func (dataType UpsertDataType) DecodeNumbers(data []byte) (interface{}, error) {
+	var (
+		decoded interface{}
+		err     error
+	)
+
+	if dataType == UpsertDataFastJSON {
+		decoder := gojson.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&decoded)
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&decoded)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToUnmarshalJSON, err)
+	}
+
+	return decoded, nil
+}
+
+// Marshal will encode a value as JSON with the encoder of the data type.
+func (dataType UpsertDataType) Marshal(value interface{}) ([]byte, error) {
+	marshal := json.Marshal
+	if dataType == UpsertDataFastJSON {
+		marshal = gojson.Marshal
+	}
+
+	data, err := marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
+	}
+
+	return data, nil
+}
+
 // DecodeUpsertRecords will decode the records from the upsert request into a slice of structs.
 func DecodeUpsertRecords(req *proto.UpsertRequest) ([]*structpb.Struct, error) {
-	if UpsertDataType(req.DataType) == UpsertDataJSON {
-		var data interface{}
-		if err := json.Unmarshal(req.Data, &data); err != nil {
-			return nil, fmt.Errorf("%w: %v", ErrFailedToUnmarshalJSON, err)
-		}
+	var unmarshal func([]byte, interface{}) error
+
+	switch UpsertDataType(req.DataType) {
+	case UpsertDataJSON:
+		unmarshal = json.Unmarshal
+	case UpsertDataFastJSON:
+		unmarshal = gojson.Unmarshal
+	default:
+		return nil, fmt.Errorf("%w: %v: %v", ErrFailedToDecodeRecords, ErrUnsupportedDataType, req.DataType)
+	}
 
-		records, err := decodeRecords(data)
-		if err != nil {
-			return nil, fmt.Errorf("%w: %v", ErrFailedToDecodeRecords, err)
+	var data interface{}
+	if err := unmarshal(req.Data, &data); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrFailedToDecodeRecords, ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, err := decodeRecords(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToDecodeRecords, err)
+	}
+
+	return records, nil
+}
+
+// StampRecords will set the fields on every record of JSON encoded upsert data, overwriting any existing values. The
+// data keeps its shape, i.e. a single record or a list of records, and numbers are not rounded. The data is decoded
+// and encoded with the JSON implementation of the data type.
+func StampRecords(dataType UpsertDataType, data []byte, fields map[string]interface{}) ([]byte, error) {
+	decoded, err := dataType.DecodeNumbers(data)
+	if err != nil {
+		return nil, err
+	}
+
+	stamp := func(record interface{}) {
+		if rec, ok := record.(map[string]interface{}); ok {
+			for key, value := range fields {
+				rec[key] = value
+			}
 		}
+	}
 
-		return records, nil
+	if records, ok := decoded.([]interface{}); ok {
+		for _, record := range records {
+			stamp(record)
+		}
+	} else {
+		stamp(decoded)
 	}
 
-	return nil, fmt.Errorf("%w: %v", ErrUnsupportedDataType, req.DataType)
+	return dataType.Marshal(decoded)
 }
 
 // PartitionStructs ensures that the request structures are partitioned into size n or less-sized chunks of data, to
@@ -8,6 +8,8 @@
 package tools
 
 import (
+	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -45,6 +47,28 @@ func TestAssignReadResponseRecords(t *testing.T) {
 		}
 	})
 
+	t.Run("assign strings that look compressed", func(t *testing.T) {
+		t.Parallel()
+
+		record, err := structpb.NewStruct(map[string]interface{}{"note": CompressedTextMarker + "hello"})
+		if err != nil {
+			t.Fatalf("failed to create struct: %v", err)
+		}
+
+		notes := []*struct {
+			Note string `json:"note"`
+		}{}
+
+		err = AssignReadResponseRecords(&proto.ReadResponse{Records: []*structpb.Struct{record}}, &notes)
+		if err != nil {
+			t.Fatalf("failed to assign records: %v", err)
+		}
+
+		if len(notes) != 1 || notes[0].Note != CompressedTextMarker+"hello" {
+			t.Fatalf("expected the note to be assigned as is, got %v", notes)
+		}
+	})
+
 	assignSliceBenchmarkResponse := new(proto.ReadResponse)
 	assignSliceBenchmarkExpected := []*testCase{}
 
@@ -71,3 +95,137 @@ func TestAssignReadResponseRecords(t *testing.T) {
 		}
 	})
 }
+
+func TestDecodeUpsertRecords(t *testing.T) {
+	t.Parallel()
+
+	data := []byte(`[{"id":1,"price":"1.5","tags":["a","b"],"meta":{"ok":true,"note":null}},{"id":2.5}]`)
+
+	expected, err := DecodeUpsertRecords(&proto.UpsertRequest{Data: data, DataType: int32(UpsertDataJSON)})
+	if err != nil {
+		t.Fatalf("failed to decode records: %v", err)
+	}
+
+	t.Run("fast json", func(t *testing.T) {
+		t.Parallel()
+
+		records, err := DecodeUpsertRecords(&proto.UpsertRequest{Data: data, DataType: int32(UpsertDataFastJSON)})
+		if err != nil {
+			t.Fatalf("failed to decode records: %v", err)
+		}
+
+		if len(records) != len(expected) {
+			t.Fatalf("expected %d records, got %d", len(expected), len(records))
+		}
+
+		for i := range records {
+			if !reflect.DeepEqual(records[i].AsMap(), expected[i].AsMap()) {
+				t.Fatalf("expected %v, got %v", expected[i].AsMap(), records[i].AsMap())
+			}
+		}
+	})
+
+	t.Run("unsupported data type", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := DecodeUpsertRecords(&proto.UpsertRequest{Data: data, DataType: 99})
+		if !errors.Is(err, ErrFailedToDecodeRecords) {
+			t.Fatalf("expected error %v, got %v", ErrFailedToDecodeRecords, err)
+		}
+	})
+}
+
+func TestStampRecords(t *testing.T) {
+	t.Parallel()
+
+	fields := map[string]interface{}{"_source_endpoint": "/candles"}
+
+	for _, tcase := range []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name:     "single record",
+			data:     `{"id":1}`,
+			expected: `{"_source_endpoint":"/candles","id":1}`,
+		},
+		{
+			name:     "list of records",
+			data:     `[{"id":1},{"id":2,"_source_endpoint":"x"}]`,
+			expected: `[{"_source_endpoint":"/candles","id":1},{"_source_endpoint":"/candles","id":2}]`,
+		},
+		{
+			name:     "large numbers are not rounded",
+			data:     `[{"id":9007199254740993}]`,
+			expected: `[{"_source_endpoint":"/candles","id":9007199254740993}]`,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			for _, dataType := range []UpsertDataType{UpsertDataJSON, UpsertDataFastJSON} {
+				stamped, err := StampRecords(dataType, []byte(tcase.data), fields)
+				if err != nil {
+					t.Fatalf("failed to stamp records: %v", err)
+				}
+
+				if string(stamped) != tcase.expected {
+					t.Fatalf("expected %s with data type %d, got %s", tcase.expected, dataType, stamped)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkUpsertDataType(b *testing.B) {
+	records := make([]map[string]interface{}, 1000)
+	for idx := range records {
+		records[idx] = map[string]interface{}{
+			"id":     idx,
+			"symbol": "BTC-USD",
+			"price":  21034.53,
+			"size":   0.0125,
+			"time":   "2022-08-01T00:00:00Z",
+			"tags":   []string{"spot", "usd"},
+		}
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		b.Fatalf("failed to encode records: %v", err)
+	}
+
+	fields := map[string]interface{}{"_source_endpoint": "/candles"}
+
+	for _, bcase := range []struct {
+		name     string
+		dataType UpsertDataType
+	}{
+		{name: "standard", dataType: UpsertDataJSON},
+		{name: "fast", dataType: UpsertDataFastJSON},
+	} {
+		bcase := bcase
+
+		// Stamping decodes and encodes the records like the other steps of the pipeline, before the storage devices
+		// decode them.
+		b.Run(bcase.name, func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				stamped, err := StampRecords(bcase.dataType, data, fields)
+				if err != nil {
+					b.Fatalf("failed to stamp records: %v", err)
+				}
+
+				req := &proto.UpsertRequest{Data: stamped, DataType: int32(bcase.dataType)}
+				if _, err := DecodeUpsertRecords(req); err != nil {
+					b.Fatalf("failed to decode records: %v", err)
+				}
+			}
+		})
+	}
+}
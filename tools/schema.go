@@ -0,0 +1,169 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ColumnType is the type of a column inferred from a set of records.
+type ColumnType uint8
+
+const (
+	// ColumnTypeNull is the type of a column whose values are all null.
+	ColumnTypeNull ColumnType = iota
+
+	// ColumnTypeBool is the type of a column of booleans.
+	ColumnTypeBool
+
+	// ColumnTypeInteger is the type of a column of whole numbers.
+	ColumnTypeInteger
+
+	// ColumnTypeFloat is the type of a column of numbers with a fractional part.
+	ColumnTypeFloat
+
+	// ColumnTypeTimestamp is the type of a column of RFC3339 timestamp strings.
+	ColumnTypeTimestamp
+
+	// ColumnTypeString is the type of a column of strings, or of values with conflicting types.
+	ColumnTypeString
+
+	// ColumnTypeJSON is the type of a column of objects or lists.
+	ColumnTypeJSON
+
+	// ColumnTypeDecimal is the type of a column of exact decimal numbers. It is never inferred from records, since
+	// decimals are encoded as strings, and must be set for the configured decimal fields.
+	ColumnTypeDecimal
+)
+
+// String returns the name of the column type.
+func (ct ColumnType) String() string {
+	switch ct {
+	case ColumnTypeNull:
+		return "null"
+	case ColumnTypeBool:
+		return "bool"
+	case ColumnTypeInteger:
+		return "integer"
+	case ColumnTypeFloat:
+		return "float"
+	case ColumnTypeTimestamp:
+		return "timestamp"
+	case ColumnTypeString:
+		return "string"
+	case ColumnTypeJSON:
+		return "json"
+	case ColumnTypeDecimal:
+		return "decimal"
+	default:
+		return "unknown"
+	}
+}
+
+// Column is a column inferred from a set of records.
+type Column struct {
+	Name string
+	Type ColumnType
+
+	// Nullable is true if the column is null or missing in at least one record.
+	Nullable bool
+
+	// Sample is the first non-null value of the column.
+	Sample interface{}
+}
+
+// valueColumnType will return the column type of a single value.
+func valueColumnType(val *structpb.Value) ColumnType {
+	switch kind := val.GetKind().(type) {
+	case *structpb.Value_BoolValue:
+		return ColumnTypeBool
+	case *structpb.Value_NumberValue:
+		if kind.NumberValue == math.Trunc(kind.NumberValue) && math.Abs(kind.NumberValue) < math.MaxInt64 {
+			return ColumnTypeInteger
+		}
+
+		return ColumnTypeFloat
+	case *structpb.Value_StringValue:
+		if _, err := time.Parse(time.RFC3339Nano, kind.StringValue); err == nil {
+			return ColumnTypeTimestamp
+		}
+
+		return ColumnTypeString
+	case *structpb.Value_StructValue, *structpb.Value_ListValue:
+		return ColumnTypeJSON
+	default:
+		return ColumnTypeNull
+	}
+}
+
+// mergeColumnTypes will return a column type that can hold the values of both column types.
+func mergeColumnTypes(left, right ColumnType) ColumnType {
+	switch {
+	case left == right || right == ColumnTypeNull:
+		return left
+	case left == ColumnTypeNull:
+		return right
+	case (left == ColumnTypeInteger && right == ColumnTypeFloat) || (left == ColumnTypeFloat && right == ColumnTypeInteger):
+		return ColumnTypeFloat
+	case left == ColumnTypeJSON || right == ColumnTypeJSON:
+		return ColumnTypeJSON
+	default:
+		return ColumnTypeString
+	}
+}
+
+// InferColumns will infer the columns of a set of records, sorted by name. A column is nullable if it is null or missing
+// in any of the records.
+func InferColumns(records []*structpb.Struct) []*Column {
+	columns := make(map[string]*Column)
+
+	for _, record := range records {
+		for name, val := range record.GetFields() {
+			column, ok := columns[name]
+			if !ok {
+				column = &Column{Name: name, Type: ColumnTypeNull}
+				columns[name] = column
+			}
+
+			valType := valueColumnType(val)
+			if valType == ColumnTypeNull {
+				column.Nullable = true
+
+				continue
+			}
+
+			if column.Sample == nil {
+				column.Sample = val.AsInterface()
+			}
+
+			column.Type = mergeColumnTypes(column.Type, valType)
+		}
+	}
+
+	inferred := make([]*Column, 0, len(columns))
+
+	for _, column := range columns {
+		for _, record := range records {
+			if _, ok := record.GetFields()[column.Name]; !ok {
+				column.Nullable = true
+
+				break
+			}
+		}
+
+		inferred = append(inferred, column)
+	}
+
+	sort.Slice(inferred, func(i, j int) bool { return inferred[i].Name < inferred[j].Name })
+
+	return inferred
+}
@@ -0,0 +1,124 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestInferColumns(t *testing.T) {
+	t.Parallel()
+
+	records := []*structpb.Struct{}
+
+	for _, data := range []map[string]interface{}{
+		{"id": 1, "price": 1, "time": "2022-05-10T00:00:00Z", "open": true, "meta": map[string]interface{}{}},
+		{"id": 2, "price": 1.5, "time": "2022-05-10T00:01:00Z", "open": false, "note": "x"},
+	} {
+		record, err := structpb.NewStruct(data)
+		if err != nil {
+			t.Fatalf("error creating record: %v", err)
+		}
+
+		records = append(records, record)
+	}
+
+	expected := map[string]struct {
+		columnType ColumnType
+		nullable   bool
+	}{
+		"id":    {ColumnTypeInteger, false},
+		"meta":  {ColumnTypeJSON, true},
+		"note":  {ColumnTypeString, true},
+		"open":  {ColumnTypeBool, false},
+		"price": {ColumnTypeFloat, false},
+		"time":  {ColumnTypeTimestamp, false},
+	}
+
+	columns := InferColumns(records)
+	if len(columns) != len(expected) {
+		t.Fatalf("expected %d columns, got %d", len(expected), len(columns))
+	}
+
+	for _, column := range columns {
+		exp := expected[column.Name]
+		if column.Type != exp.columnType || column.Nullable != exp.nullable {
+			t.Errorf("column %q: expected (%v, %t), got (%v, %t)", column.Name, exp.columnType, exp.nullable,
+				column.Type, column.Nullable)
+		}
+	}
+}
+
+func TestSQLCreateTable(t *testing.T) {
+	t.Parallel()
+
+	columns := []*Column{
+		{Name: "id", Type: ColumnTypeString},
+		{Name: "price", Type: ColumnTypeFloat, Nullable: true},
+		{Name: "size", Type: ColumnTypeFloat},
+	}
+
+	for _, tcase := range []struct {
+		dialect  SQLDialect
+		expected string
+	}{
+		{
+			dialect: SQLDialectPostgres,
+			expected: "CREATE TABLE IF NOT EXISTS \"ticks\" (\n" +
+				"  \"id\" TEXT NOT NULL,\n" +
+				"  \"price\" DOUBLE PRECISION,\n" +
+				"  \"size\" DOUBLE PRECISION,\n" +
+				"  PRIMARY KEY (\"id\")\n);\n",
+		},
+		{
+			dialect: SQLDialectMySQL,
+			expected: "CREATE TABLE IF NOT EXISTS `ticks` (\n" +
+				"  `id` VARCHAR(255) NOT NULL,\n" +
+				"  `price` DOUBLE,\n" +
+				"  `size` DOUBLE,\n" +
+				"  PRIMARY KEY (`id`)\n);\n",
+		},
+	} {
+		tcase := tcase
+
+		t.Run(string(tcase.dialect), func(t *testing.T) {
+			t.Parallel()
+
+			ddl, warnings, err := SQLCreateTable(tcase.dialect, "ticks", columns)
+			if err != nil {
+				t.Fatalf("error creating ddl: %v", err)
+			}
+
+			if ddl != tcase.expected || len(warnings) != 0 {
+				t.Fatalf("unexpected ddl with warnings %v:\n%s", warnings, ddl)
+			}
+		})
+	}
+
+	t.Run("no primary key", func(t *testing.T) {
+		t.Parallel()
+
+		ddl, warnings, err := SQLCreateTable(SQLDialectPostgres, "ticks", columns[1:])
+		if err != nil {
+			t.Fatalf("error creating ddl: %v", err)
+		}
+
+		if strings.Contains(ddl, "--") || strings.Contains(ddl, "PRIMARY KEY") {
+			t.Fatalf("unexpected ddl:\n%s", ddl)
+		}
+
+		expected := []string{`no primary key could be inferred for "ticks", upserts require one`}
+		if !reflect.DeepEqual(warnings, expected) {
+			t.Fatalf("expected warnings %v, got %v", expected, warnings)
+		}
+	})
+}
@@ -56,10 +56,12 @@ func SQLFlattenPartition(columns []string, partition []*structpb.Struct) []inter
 	var args []interface{}
 
 	for _, record := range partition {
-		hash := record.AsMap()
+		hash := getRecordMap(record)
 		for _, column := range columns {
 			args = append(args, hash[column])
 		}
+
+		putRecordMap(hash)
 	}
 
 	return args
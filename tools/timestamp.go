@@ -0,0 +1,100 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTimestamp = fmt.Errorf("invalid timestamp")
+
+// ParseNanoTimestamp will return the nanoseconds since the Unix epoch of a timestamp, either the text of an integer
+// number of nanoseconds or an RFC3339 timestamp with up to nanosecond precision.
+func ParseNanoTimestamp(str string) (int64, error) {
+	if nanos, err := strconv.ParseInt(str, 10, 64); err == nil {
+		return nanos, nil
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidTimestamp, str)
+	}
+
+	// The nanoseconds of an int64 cover the years 1678 to 2262.
+	if ts.Before(time.Unix(0, math.MinInt64)) || ts.After(time.Unix(0, math.MaxInt64)) {
+		return 0, fmt.Errorf("%w: %q is out of the range of nanoseconds", ErrInvalidTimestamp, str)
+	}
+
+	return ts.UnixNano(), nil
+}
+
+// NanoTimestampRecords will encode the timestamp fields on every record of JSON encoded upsert data as strings that
+// hold their integer nanoseconds since the Unix epoch, so that they are not rounded by a conversion to float64 when the
+// records are decoded, nor truncated by the native timestamps of the storage. Fields may be RFC3339 timestamps or
+// integer nanoseconds, and null or missing fields are left as they are. The data keeps its shape, i.e. a single record
+// or a list of records.
+func NanoTimestampRecords(data []byte, fields []string) ([]byte, error) {
+	if len(fields) == 0 {
+		return data, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToUnmarshalJSON, err)
+	}
+
+	records, ok := decoded.([]interface{})
+	if !ok {
+		records = []interface{}{decoded}
+	}
+
+	for _, record := range records {
+		rec, ok := record.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, field := range fields {
+			var str string
+
+			switch value := rec[field].(type) {
+			case nil:
+				continue
+			case json.Number:
+				str = value.String()
+			case string:
+				str = strings.TrimSpace(value)
+			default:
+				return nil, fmt.Errorf("%w for %q: %v", ErrInvalidTimestamp, field, value)
+			}
+
+			nanos, err := ParseNanoTimestamp(str)
+			if err != nil {
+				return nil, fmt.Errorf("%w for %q", err, field)
+			}
+
+			rec[field] = strconv.FormatInt(nanos, 10)
+		}
+	}
+
+	nanoData, err := json.Marshal(decoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFailedToMarshalJSON, err)
+	}
+
+	return nanoData, nil
+}
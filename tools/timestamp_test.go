@@ -0,0 +1,76 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alpine-hodler/gidari/proto"
+)
+
+func TestNanoTimestampRecords(t *testing.T) {
+	t.Parallel()
+
+	for _, tcase := range []struct {
+		name     string
+		data     string
+		expected string
+		err      error
+	}{
+		{
+			name:     "rfc3339 timestamps",
+			data:     `[{"time":"2023-11-14T22:13:20.123456789Z"},{"time":"2023-11-14T23:13:20.123456789+01:00"},{"time":null}]`,
+			expected: `[{"time":"1700000000123456789"},{"time":"1700000000123456789"},{"time":null}]`,
+		},
+		{
+			name:     "integer nanoseconds keep their digits",
+			data:     `{"time":1700000000123456789,"size":1}`,
+			expected: `{"size":1,"time":"1700000000123456789"}`,
+		},
+		{
+			name: "out of range",
+			data: `{"time":"2300-01-01T00:00:00Z"}`,
+			err:  ErrInvalidTimestamp,
+		},
+		{
+			name: "not a timestamp",
+			data: `{"time":true}`,
+			err:  ErrInvalidTimestamp,
+		},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			data, err := NanoTimestampRecords([]byte(tcase.data), []string{"time"})
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected error %v, got %v", tcase.err, err)
+			}
+
+			if tcase.err != nil {
+				return
+			}
+
+			if string(data) != tcase.expected {
+				t.Fatalf("expected %s, got %s", tcase.expected, data)
+			}
+
+			// The nanoseconds survive the decoding of the upsert records.
+			records, err := DecodeUpsertRecords(&proto.UpsertRequest{Data: data})
+			if err != nil {
+				t.Fatalf("unable to decode records: %v", err)
+			}
+
+			if nanos := records[0].GetFields()["time"].GetStringValue(); nanos != "1700000000123456789" {
+				t.Fatalf("expected the nanoseconds to be kept, got %q", nanos)
+			}
+		})
+	}
+}
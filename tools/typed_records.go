@@ -0,0 +1,203 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// TypedRecordTag is the struct tag used to map the fields of a Go struct to the columns of a table. The tag value is
+// the column name, optionally followed by ",required" to reject records where the column is missing or null. Fields
+// without the tag, or with the tag "-", are ignored.
+const TypedRecordTag = "gidari"
+
+var (
+	ErrInvalidRecordType = fmt.Errorf("invalid record type")
+	ErrInvalidRecord     = fmt.Errorf("invalid record")
+)
+
+// Validator can be implemented by typed records to validate a record after it is decoded.
+type Validator interface {
+	Validate() error
+}
+
+// typedField is a field of a typed record mapped to a column.
+type typedField struct {
+	index    int
+	column   string
+	required bool
+}
+
+// TypedRecordDecoder will decode records into a Go struct type using the "gidari" struct tags, validating the records
+// in the process.
+type TypedRecordDecoder struct {
+	typ    reflect.Type
+	fields []typedField
+}
+
+// NewTypedRecordDecoder will return a decoder for the type of the given struct value, e.g. "Candle{}" or "&Candle{}".
+func NewTypedRecordDecoder(val interface{}) (*TypedRecordDecoder, error) {
+	typ := reflect.TypeOf(val)
+	if typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+
+	if typ == nil || typ.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %v is not a struct", ErrInvalidRecordType, typ)
+	}
+
+	dec := &TypedRecordDecoder{typ: typ}
+
+	for idx := 0; idx < typ.NumField(); idx++ {
+		field := typ.Field(idx)
+
+		tag, ok := field.Tag.Lookup(TypedRecordTag)
+		if !ok || tag == "-" {
+			continue
+		}
+
+		if !field.IsExported() {
+			return nil, fmt.Errorf("%w: field %q is not exported", ErrInvalidRecordType, field.Name)
+		}
+
+		parts := strings.Split(tag, ",")
+
+		tfield := typedField{index: idx, column: parts[0]}
+		if tfield.column == "" {
+			tfield.column = field.Name
+		}
+
+		for _, opt := range parts[1:] {
+			if opt == "required" {
+				tfield.required = true
+			}
+		}
+
+		dec.fields = append(dec.fields, tfield)
+	}
+
+	if len(dec.fields) == 0 {
+		return nil, fmt.Errorf("%w: %v has no %q tags", ErrInvalidRecordType, typ, TypedRecordTag)
+	}
+
+	return dec, nil
+}
+
+// Type returns the struct type of the decoder.
+func (dec *TypedRecordDecoder) Type() reflect.Type {
+	return dec.typ
+}
+
+// decodeOne will decode a single JSON object into a new value of the decoder's type.
+func (dec *TypedRecordDecoder) decodeOne(obj map[string]json.RawMessage) (reflect.Value, error) {
+	val := reflect.New(dec.typ)
+
+	for _, field := range dec.fields {
+		raw, ok := obj[field.column]
+		if !ok || string(raw) == "null" {
+			if field.required {
+				return val, fmt.Errorf("%w: missing required column %q", ErrInvalidRecord, field.column)
+			}
+
+			continue
+		}
+
+		if err := json.Unmarshal(raw, val.Elem().Field(field.index).Addr().Interface()); err != nil {
+			return val, fmt.Errorf("%w: column %q: %v", ErrInvalidRecord, field.column, err)
+		}
+	}
+
+	if validator, ok := val.Interface().(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return val, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+		}
+	}
+
+	return val, nil
+}
+
+// decodeObjects will decode JSON data, either an object or a list of objects, into the raw columns of each object.
+func decodeObjects(data []byte) ([]map[string]json.RawMessage, error) {
+	var objs []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &objs); err != nil {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(data, &obj); err != nil {
+			return nil, fmt.Errorf("%w: %v: %v", ErrFailedToDecodeRecords, ErrFailedToUnmarshalJSON, err)
+		}
+
+		objs = append(objs, obj)
+	}
+
+	return objs, nil
+}
+
+// Decode will decode JSON data, either an object or a list of objects, into a slice of pointers to the decoder's type.
+func (dec *TypedRecordDecoder) Decode(data []byte) ([]interface{}, error) {
+	objs, err := decodeObjects(data)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]interface{}, 0, len(objs))
+
+	for _, obj := range objs {
+		val, err := dec.decodeOne(obj)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFailedToDecodeRecords, err)
+		}
+
+		out = append(out, val.Interface())
+	}
+
+	return out, nil
+}
+
+// Records will decode JSON data into typed records and return the records as column maps. Only the columns mapped by
+// the decoder's type are included in the records. Optional columns that are missing from the data are left out of the
+// records, and those that are null are null, rather than the zero value of their type, so that upserting a record
+// does not overwrite stored data with zeros.
+func (dec *TypedRecordDecoder) Records(data []byte) ([]map[string]interface{}, error) {
+	objs, err := decodeObjects(data)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]map[string]interface{}, 0, len(objs))
+
+	for _, obj := range objs {
+		val, err := dec.decodeOne(obj)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFailedToDecodeRecords, err)
+		}
+
+		elem := val.Elem()
+		record := make(map[string]interface{}, len(dec.fields))
+
+		for _, field := range dec.fields {
+			raw, ok := obj[field.column]
+			if !ok {
+				continue
+			}
+
+			if string(raw) == "null" {
+				record[field.column] = nil
+
+				continue
+			}
+
+			record[field.column] = elem.Field(field.index).Interface()
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
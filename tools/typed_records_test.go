@@ -0,0 +1,95 @@
+// Copyright 2022 The Gidari Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type testCandle struct {
+	Product string  `gidari:"product,required"`
+	Close   float64 `gidari:"close"`
+	Ignored string
+}
+
+func (candle *testCandle) Validate() error {
+	if candle.Close < 0 {
+		return fmt.Errorf("close must be positive")
+	}
+
+	return nil
+}
+
+func TestTypedRecordDecoder(t *testing.T) {
+	t.Parallel()
+
+	dec, err := NewTypedRecordDecoder(testCandle{})
+	if err != nil {
+		t.Fatalf("error creating decoder: %v", err)
+	}
+
+	t.Run("records", func(t *testing.T) {
+		t.Parallel()
+
+		records, err := dec.Records([]byte(`[{"product": "BTC-USD", "close": 1.5, "Ignored": "x", "extra": 1}]`))
+		if err != nil {
+			t.Fatalf("error decoding records: %v", err)
+		}
+
+		expected := []map[string]interface{}{{"product": "BTC-USD", "close": 1.5}}
+		if !reflect.DeepEqual(expected, records) {
+			t.Fatalf("unexpected records: %v", records)
+		}
+	})
+
+	t.Run("absent optional columns", func(t *testing.T) {
+		t.Parallel()
+
+		records, err := dec.Records([]byte(`[{"product": "BTC-USD"}, {"product": "ETH-USD", "close": null}]`))
+		if err != nil {
+			t.Fatalf("error decoding records: %v", err)
+		}
+
+		// A missing column is not stored as a zero close, which would overwrite the stored close.
+		expected := []map[string]interface{}{{"product": "BTC-USD"}, {"product": "ETH-USD", "close": nil}}
+		if !reflect.DeepEqual(expected, records) {
+			t.Fatalf("expected records %v, got %v", expected, records)
+		}
+	})
+
+	for _, tcase := range []struct {
+		name string
+		data string
+	}{
+		{"missing required column", `{"close": 1.5}`},
+		{"wrong column type", `{"product": "BTC-USD", "close": "high"}`},
+		{"failed validation", `{"product": "BTC-USD", "close": -1}`},
+	} {
+		tcase := tcase
+
+		t.Run(tcase.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := dec.Decode([]byte(tcase.data))
+			if !errors.Is(err, ErrFailedToDecodeRecords) {
+				t.Fatalf("expected decode error, got %v", err)
+			}
+		})
+	}
+
+	t.Run("invalid type", func(t *testing.T) {
+		t.Parallel()
+
+		if _, err := NewTypedRecordDecoder(struct{ Name string }{}); !errors.Is(err, ErrInvalidRecordType) {
+			t.Fatalf("expected invalid record type error, got %v", err)
+		}
+	})
+}